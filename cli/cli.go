@@ -19,6 +19,8 @@ var (
 	UsageErrorf       = cmdr.UsageErrorf
 	OSErrorf          = cmdr.OSErrorf
 	IOErrorf          = cmdr.IOErrorf
+	DataErrorf        = cmdr.DataErrorf
+	FailureErrorf     = cmdr.FailureErrorf
 	InternalErrorf    = cmdr.InternalErrorf
 	EnsureErrorResult = cmdr.EnsureErrorResult
 )
@@ -55,8 +57,12 @@ func NewSousCLI(v semv.Version, out, errout io.Writer) (*cmdr.CLI, error) {
 	}
 
 	// Before Execute is called on any command, inject it with values from the
-	// graph.
-	c.Hooks.PreExecute = func(c cmdr.Command) error { return g.Inject(c) }
+	// graph. Global flags like -json may follow any command name, so they are
+	// applied here too, once each command's flags have been parsed.
+	c.Hooks.PreExecute = func(cmd cmdr.Command) error {
+		c.JSONErrors = s.flags.JSON
+		return g.Inject(cmd)
+	}
 
 	return c, nil
 }
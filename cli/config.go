@@ -14,16 +14,17 @@ import (
 func newConfig(u *User) (*sous.Config, error) {
 	config := u.DefaultConfig()
 
-	err := os.MkdirAll(u.ConfigDir(), os.ModeDir|0755)
-	if err != nil {
-		return nil, err
+	for _, dir := range []string{u.ConfigDir(), u.CacheDir()} {
+		if err := os.MkdirAll(dir, os.ModeDir|0755); err != nil {
+			return nil, err
+		}
 	}
 
 	return &config, configloader.New().Load(&config, u.ConfigFile())
 }
 
-// Save the configuration to the configuration path (by default:
-// $HOME/.config/sous/config)
+// Save the configuration to the user's configuration file (see
+// User.ConfigDir)
 func (c *LocalSousConfig) Save(u *User) error {
 	return ioutil.WriteFile(u.ConfigFile(), c.Bytes(), 0600)
 }
@@ -60,6 +60,7 @@ func BuildGraph(s *Sous, c *cmdr.CLI) (*SousCLIGraph, error) {
 		s, c,
 		newOut,
 		newErrOut,
+		newOutputSink,
 		newLocalUser,
 		newLocalSousConfig,
 		newLocalWorkDir,
@@ -133,8 +134,16 @@ func newLocalGitRepo(c LocalGitClient) (v LocalGitRepo, err error) {
 	return v, initErr(err, "opening local git repository")
 }
 
-func newDockerClient() LocalDockerClient {
-	return LocalDockerClient{docker_registry.NewClient()}
+func newDockerClient(s *Sous) (LocalDockerClient, error) {
+	opts := []docker_registry.ClientOption{
+		docker_registry.WithLogger(sous.DefaultLogger),
+		docker_registry.WithRequiredLabels(sous.DockerLabels...),
+	}
+	if s.flags.Verbosity.Debug {
+		opts = append(opts, docker_registry.WithDebugLog(sous.Log.Debug))
+	}
+	c, err := docker_registry.NewClientWithOptions(opts...)
+	return LocalDockerClient{c}, initErr(err, "building docker registry client")
 }
 
 // initErr returns nil if error is nil, otherwise an initialisation error.
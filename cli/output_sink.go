@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/opentable/sous/util/cmdr"
+)
+
+// OutputSink is where commands send their results, progress and errors,
+// rather than printing them directly, so that the global -json and -quiet
+// flags are honoured the same way by every command.
+type OutputSink struct {
+	Out, ErrOut *cmdr.Output
+	// JSON is set by -json: results are written as a single JSON document,
+	// and errors as JSON objects, one per line.
+	JSON bool
+	// Quiet is set by -quiet (or -q, or -s): informational messages are
+	// dropped.
+	Quiet bool
+	// NoColor is set by -no-color: tables aren't colour coded, even on a
+	// terminal.
+	NoColor bool
+	// Wide is set by -wide: tables aren't truncated to fit the terminal.
+	Wide bool
+}
+
+func newOutputSink(s *Sous, out Out, errOut ErrOut) OutputSink {
+	return OutputSink{
+		Out:     out.Output,
+		ErrOut:  errOut.Output,
+		JSON:    s.flags.JSON,
+		Quiet:   s.flags.Verbosity.Quiet || s.flags.Verbosity.Silent,
+		NoColor: s.flags.NoColor,
+		Wide:    s.flags.Wide,
+	}
+}
+
+// Result writes the primary result of a command to stdout: v as JSON if
+// -json was given, and whatever text writes otherwise.
+func (o OutputSink) Result(v interface{}, text func(io.Writer)) cmdr.ErrorResult {
+	if !o.JSON {
+		text(o.Out)
+		return nil
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return InternalErrorf("unable to marshal JSON: %s", err)
+	}
+	o.Out.Write(append(b, '\n'))
+	return nil
+}
+
+// Infof writes an informational message, such as progress or a summary, to
+// stdout, or to stderr when -json is given so that stdout holds only the
+// result. It writes nothing under -quiet.
+func (o OutputSink) Infof(format string, v ...interface{}) {
+	if o.Quiet {
+		return
+	}
+	if o.JSON {
+		o.ErrOut.Printfln(format, v...)
+		return
+	}
+	o.Out.Printfln(format, v...)
+}
+
+// Error writes an error that doesn't stop the command to stderr, as a JSON
+// object if -json was given.
+func (o OutputSink) Error(err error) {
+	if !o.JSON {
+		o.ErrOut.Println(err)
+		return
+	}
+	b, jsonErr := json.Marshal(struct {
+		Error string `json:"error"`
+	}{err.Error()})
+	if jsonErr != nil {
+		o.ErrOut.Println(err)
+		return
+	}
+	o.ErrOut.Write(append(b, '\n'))
+}
@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/opentable/sous/util/cmdr"
+)
+
+// testSink returns an OutputSink writing results to out and discarding
+// everything written to stderr.
+func testSink(out io.Writer, json bool) OutputSink {
+	return OutputSink{
+		Out:    cmdr.NewOutput(out),
+		ErrOut: cmdr.NewOutput(ioutil.Discard),
+		JSON:   json,
+	}
+}
+
+func TestOutputSink(t *testing.T) {
+	text := func(w io.Writer) { io.WriteString(w, "a table\n") }
+	for _, test := range []struct {
+		json, quiet bool
+		out, errOut string
+	}{
+		{false, false, "a table\nworking\n", "oops\n"},
+		{false, true, "a table\n", "oops\n"},
+		{true, false, "{\n  \"A\": 1\n}\n", "working\n{\"error\":\"oops\"}\n"},
+		{true, true, "{\n  \"A\": 1\n}\n", "{\"error\":\"oops\"}\n"},
+	} {
+		out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+		o := OutputSink{
+			Out:    cmdr.NewOutput(out),
+			ErrOut: cmdr.NewOutput(errOut),
+			JSON:   test.json,
+			Quiet:  test.quiet,
+		}
+		if err := o.Result(struct{ A int }{1}, text); err != nil {
+			t.Fatal(err)
+		}
+		o.Infof("working")
+		o.Error(errors.New("oops"))
+		if out.String() != test.out || errOut.String() != test.errOut {
+			t.Errorf("json=%t quiet=%t: got stdout %q, stderr %q; want %q, %q",
+				test.json, test.quiet, out, errOut, test.out, test.errOut)
+		}
+	}
+}
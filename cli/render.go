@@ -0,0 +1,231 @@
+package cli
+
+import (
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/opentable/sous/util/cmdr/style"
+)
+
+type (
+	// A renderer lays out tables for people to read, rather than for
+	// scripts: aligned in columns, cut down to fit the terminal, and colour
+	// coded. Commands get theirs from OutputSink.renderer.
+	renderer struct {
+		// width is the width to fit tables to, in columns, or zero not to
+		// limit them.
+		width int
+		// color writes styled spans with their styles; without it, all text
+		// is written plain.
+		color bool
+	}
+
+	// A table is a header and rows of cells, one for each column of the
+	// header. A row may have fewer cells than the header, e.g. the
+	// continuation of a cell on the row before, but not more.
+	table struct {
+		header []string
+		rows   [][]cell
+	}
+
+	// A cell is the text of a table cell, in spans that may be styled
+	// differently, e.g. to highlight part of it.
+	cell []span
+
+	span struct {
+		text  string
+		style style.Style
+	}
+)
+
+// columnGap is the number of spaces between columns.
+const columnGap = 2
+
+// The styles of parts of tables: each kind of change is coloured.
+var (
+	createStyle = style.Style{style.Green}
+	deleteStyle = style.Style{style.Red}
+	modifyStyle = style.Style{style.Yellow}
+	headerStyle = style.Style{style.Bold}
+	// newValueStyle highlights the value a field changes to.
+	newValueStyle = style.Style{style.Bold}
+)
+
+// renderer returns the renderer for tables written to o.Out. They're colour
+// coded unless -no-color is given, $NO_COLOR is set, or stdout isn't a
+// terminal, and they're fit to the terminal's width unless -wide is given.
+func (o OutputSink) renderer() renderer {
+	r := renderer{color: o.Out.IsTerminal() && !o.NoColor && os.Getenv("NO_COLOR") == ""}
+	if !o.Wide {
+		r.width = o.Out.TerminalWidth()
+	}
+	return r
+}
+
+func plain(text string) cell {
+	return cell{{text: text}}
+}
+
+func styled(text string, s style.Style) cell {
+	return cell{{text: text, style: s}}
+}
+
+// actionCell is the cell for the action taken on a deployment: "create",
+// "delete" or "modify", coloured to match, followed by any note.
+func actionCell(action, note string) cell {
+	var s style.Style
+	switch action {
+	case "create":
+		s = createStyle
+	case "delete":
+		s = deleteStyle
+	case "modify":
+		s = modifyStyle
+	}
+	c := styled(action, s)
+	if note != "" {
+		c = append(c, span{text: " " + note})
+	}
+	return c
+}
+
+// changeCell is the cell for one of DeploymentPair.Differences, e.g.
+// "version: 1.0.0 -> 1.1.0", with the field that changed highlighted, and the
+// value it changes to in bold.
+func changeCell(change string) cell {
+	field, rest := change, ""
+	if i := strings.Index(change, ": "); i >= 0 {
+		field, rest = change[:i], change[i:]
+	} else if i := strings.Index(change, " "); i >= 0 {
+		field, rest = change[:i], change[i:]
+	}
+	c := styled(field, modifyStyle)
+	i := strings.Index(rest, " -> ")
+	if i < 0 {
+		return append(c, span{text: rest})
+	}
+	to := rest[i+len(" -> "):]
+	var note string
+	if j := strings.Index(to, " ("); j >= 0 {
+		to, note = to[:j], to[j:]
+	}
+	c = append(c, span{text: rest[:i+len(" -> ")]}, span{text: to, style: newValueStyle})
+	if note != "" {
+		c = append(c, span{text: note})
+	}
+	return c
+}
+
+// width returns the width of c's text, in runes.
+func (c cell) width() int {
+	n := 0
+	for _, s := range c {
+		n += utf8.RuneCountInString(s.text)
+	}
+	return n
+}
+
+// truncate returns c cut down to width runes, the last of them an ellipsis
+// if any were cut.
+func (c cell) truncate(width int) cell {
+	if c.width() <= width {
+		return c
+	}
+	if width <= 0 {
+		return cell{}
+	}
+	out := cell{}
+	room := width - 1
+	for _, s := range c {
+		if room == 0 {
+			break
+		}
+		text := []rune(s.text)
+		if len(text) > room {
+			text = text[:room]
+		}
+		out = append(out, span{text: string(text), style: s.style})
+		room -= len(text)
+	}
+	var last style.Style
+	if len(out) != 0 {
+		last = out[len(out)-1].style
+	}
+	return append(out, span{text: "…", style: last})
+}
+
+// render writes t to w, each column as wide as its widest cell, and the
+// widest columns truncated until the table fits r.width. A column isn't
+// truncated to less than the width of its header: if the table can't fit
+// even then, its lines are left too long.
+func (r renderer) render(w io.Writer, t table) {
+	widths := r.widths(t)
+	header := make([]cell, len(t.header))
+	for i, h := range t.header {
+		header[i] = styled(h, headerStyle)
+	}
+	r.line(w, widths, header)
+	for _, row := range t.rows {
+		r.line(w, widths, row)
+	}
+}
+
+// widths returns the width of each column of t.
+func (r renderer) widths(t table) []int {
+	widths := make([]int, len(t.header))
+	least := make([]int, len(t.header))
+	for i, h := range t.header {
+		widths[i] = utf8.RuneCountInString(h)
+		least[i] = widths[i]
+	}
+	for _, row := range t.rows {
+		for i, c := range row {
+			if n := c.width(); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	if r.width <= 0 {
+		return widths
+	}
+	total := columnGap * (len(widths) - 1)
+	for _, n := range widths {
+		total += n
+	}
+	for total > r.width {
+		widest := -1
+		for i, n := range widths {
+			if n > least[i] && (widest < 0 || n > widths[widest]) {
+				widest = i
+			}
+		}
+		if widest < 0 {
+			break
+		}
+		widths[widest]--
+		total--
+	}
+	return widths
+}
+
+// line writes a row of cells to w, padding each but the last to the width of
+// its column.
+func (r renderer) line(w io.Writer, widths []int, cells []cell) {
+	b := &strings.Builder{}
+	for i, c := range cells {
+		c = c.truncate(widths[i])
+		for _, s := range c {
+			if r.color && len(s.style) != 0 {
+				b.WriteString("\033[" + s.style.String() + "m" + s.text + "\033[0m")
+			} else {
+				b.WriteString(s.text)
+			}
+		}
+		if i < len(cells)-1 {
+			b.WriteString(strings.Repeat(" ", widths[i]-c.width()+columnGap))
+		}
+	}
+	io.WriteString(w, strings.TrimRight(b.String(), " ")+"\n")
+}
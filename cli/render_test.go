@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"bytes"
+	"testing"
+)
+
+func testTable() table {
+	return table{
+		header: []string{"Action", "Cluster", "Source", "Changes"},
+		rows: [][]cell{
+			{actionCell("create", ""), plain("east"), plain("github.com/opentable/one"), plain("version 1.0.0, 2 instances")},
+			{actionCell("modify", ""), plain("west"), plain("github.com/opentable/two"), changeCell("version: 1.0.0 -> 1.1.0")},
+			{nil, nil, nil, changeCell("instances: 1 -> 2")},
+		},
+	}
+}
+
+func TestRendererAlignsColumns(t *testing.T) {
+	out := &bytes.Buffer{}
+	renderer{}.render(out, testTable())
+	want := "" +
+		"Action  Cluster  Source                    Changes\n" +
+		"create  east     github.com/opentable/one  version 1.0.0, 2 instances\n" +
+		"modify  west     github.com/opentable/two  version: 1.0.0 -> 1.1.0\n" +
+		"                                           instances: 1 -> 2\n"
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestRendererTruncatesToWidth(t *testing.T) {
+	out := &bytes.Buffer{}
+	renderer{width: 50}.render(out, testTable())
+	want := "" +
+		"Action  Cluster  Source           Changes\n" +
+		"create  east     github.com/ope…  version 1.0.0, …\n" +
+		"modify  west     github.com/ope…  version: 1.0.0 …\n" +
+		"                                  instances: 1 ->…\n"
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out, want)
+	}
+
+	out.Reset()
+	renderer{width: 10}.render(out, testTable())
+	want = "" +
+		"Action  Cluster  Source  Changes\n" +
+		"create  east     githu…  versio…\n" +
+		"modify  west     githu…  versio…\n" +
+		"                         instan…\n"
+	if out.String() != want {
+		t.Errorf("columns shouldn't be narrower than their headers; got:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestRendererColors(t *testing.T) {
+	out := &bytes.Buffer{}
+	renderer{color: true}.render(out, table{
+		header: []string{"Action", "Changes"},
+		rows: [][]cell{
+			{actionCell("delete", "(frozen)"), plain("version 1.0.0, 1 instances")},
+			{actionCell("modify", ""), changeCell("kind: worker -> http-service (recreates the request)")},
+		},
+	})
+	want := "" +
+		"\033[1mAction\033[0m           \033[1mChanges\033[0m\n" +
+		"\033[31mdelete\033[0m (frozen)  version 1.0.0, 1 instances\n" +
+		"\033[33mmodify\033[0m           \033[33mkind\033[0m: worker -> \033[1mhttp-service\033[0m (recreates the request)\n"
+	if out.String() != want {
+		t.Errorf("got:\n%q\nwant:\n%q", out, want)
+	}
+}
+
+func TestChangeCell(t *testing.T) {
+	for change, want := range map[string]cell{
+		"version: 1.0.0 -> 1.1.0": {
+			{text: "version", style: modifyStyle}, {text: ": 1.0.0 -> "}, {text: "1.1.0", style: newValueStyle},
+		},
+		"env changed: [B]": {{text: "env changed", style: modifyStyle}, {text: ": [B]"}},
+		"volumes changed":  {{text: "volumes", style: modifyStyle}, {text: " changed"}},
+	} {
+		got := changeCell(change)
+		if len(got) != len(want) {
+			t.Errorf("changeCell(%q) = %v; want %v", change, got, want)
+			continue
+		}
+		for i := range got {
+			if got[i].text != want[i].text || got[i].style.String() != want[i].style.String() {
+				t.Errorf("changeCell(%q) = %v; want %v", change, got, want)
+				break
+			}
+		}
+	}
+}
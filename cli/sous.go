@@ -2,11 +2,11 @@ package cli
 
 import (
 	"flag"
-	"fmt"
 	"os"
 
 	"github.com/opentable/sous/lib"
 	"github.com/opentable/sous/util/cmdr"
+	"github.com/opentable/sous/util/logging"
 	"github.com/opentable/sous/util/whitespace"
 	"github.com/samsalisbury/semv"
 )
@@ -22,8 +22,12 @@ type Sous struct {
 	Version semv.Version
 	// flags holds the values of flags passed to this command
 	flags struct {
-		Help      bool
-		Verbosity struct {
+		Help bool
+		// JSON asks for results and errors as JSON
+		JSON bool
+		// NoColor and Wide control how tables are rendered on a terminal
+		NoColor, Wide bool
+		Verbosity     struct {
 			Silent, Quiet, Loud, Debug bool
 		}
 	}
@@ -62,6 +66,14 @@ func (s *Sous) AddFlags(fs *flag.FlagSet) {
 		"silent: silence all non-essential output")
 	fs.BoolVar(&s.flags.Verbosity.Quiet, "q", false,
 		"quiet: output only essential error messages")
+	fs.BoolVar(&s.flags.Verbosity.Quiet, "quiet", false,
+		"quiet: same as -q")
+	fs.BoolVar(&s.flags.JSON, "json", false,
+		"json: print results on stdout and errors on stderr as JSON")
+	fs.BoolVar(&s.flags.NoColor, "no-color", false,
+		"no-color: don't colour code tables, even on a terminal")
+	fs.BoolVar(&s.flags.Wide, "wide", false,
+		"wide: don't truncate tables to fit the terminal")
 	fs.BoolVar(&s.flags.Verbosity.Loud, "v", false,
 		"loud: output extra info, including all shell commands")
 	fs.BoolVar(&s.flags.Verbosity.Debug, "d", false,
@@ -91,13 +103,23 @@ func (s *Sous) Subcommands() cmdr.Commands {
 
 func (s *Sous) Verbosity() cmdr.Verbosity {
 	if s.flags.Verbosity.Debug {
-		fmt.Println("debug level")
+		logging.DefaultLevels.SetDefault(logging.DebugLevel)
+	}
+	// $SOUS_LOG_LEVELS may turn up some subsystems without -d or -v
+	if err := logging.SetLevelsFromEnv(); err != nil {
+		sous.Log.Warn.Println(err)
+	}
+	if logging.DefaultLevels.Lowest() == logging.DebugLevel {
 		sous.Log.Debug.SetOutput(os.Stderr)
 		sous.Log.Info.SetOutput(os.Stderr)
-		return cmdr.Debug
 	}
 	if s.flags.Verbosity.Loud {
 		sous.Log.Info.SetOutput(os.Stderr)
+	}
+	if s.flags.Verbosity.Debug {
+		return cmdr.Debug
+	}
+	if s.flags.Verbosity.Loud {
 		return cmdr.Loud
 	}
 	if s.flags.Verbosity.Quiet {
@@ -44,6 +44,7 @@ func (sb *SousBuild) Execute(args []string) cmdr.Result {
 	}
 
 	nc := sous.NewNameCache(sb.DockerClient, sb.Config.DatabaseDriver, sb.Config.DatabaseConnection)
+	defer nc.Close()
 
 	_, err := sous.RunBuild(nc, "docker.otenv.com",
 		sb.SourceContext, sb.WDShell, sb.ScratchShell)
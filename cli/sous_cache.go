@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"flag"
+	"strings"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+	"github.com/opentable/sous/util/resolve"
+)
+
+// SousCache is the description of the `sous cache` command
+type SousCache struct{}
+
+// CacheSubcommands are the subcommands of `sous cache`
+var CacheSubcommands = cmdr.Commands{}
+
+func init() { TopLevelCommands["cache"] = &SousCache{} }
+
+const sousCacheHelp = `
+inspect and maintain the image name cache
+
+args: <command>
+
+cache commands work on the database sous uses to map source versions to
+docker image names. The database is given by -cache-db, or your sous
+configuration; it must be a file, since an in-memory cache is empty every
+time sous starts.
+`
+
+func (*SousCache) Help() string { return sousCacheHelp }
+
+func (SousCache) Subcommands() cmdr.Commands {
+	return CacheSubcommands
+}
+
+func (*SousCache) Execute(args []string) cmdr.Result {
+	err := UsageErrorf("usage: sous cache [options] command")
+	err.Tip = "try `sous help cache` for a list of commands"
+	return err
+}
+
+// cacheFlags are the flags shared by the cache subcommands
+type cacheFlags struct {
+	cacheDB string
+}
+
+func (f *cacheFlags) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&f.cacheDB, "cache-db", "",
+		"path to the SQLite name cache (default from sous config)")
+}
+
+// cacheDB returns the driver and connection of the name cache named by
+// cacheDBFlag, or else by config. The path in cacheDBFlag may begin with ~
+// and refer to environment variables, and if it's relative, it's relative to
+// base, or the working directory if base is empty. SQLite URIs, beginning
+// "file:", are used as they are.
+func cacheDB(config *sous.Config, cacheDBFlag, base string) (driver, conn string, err cmdr.ErrorResult) {
+	if cacheDBFlag == "" {
+		return config.DatabaseDriver, config.DatabaseConnection, nil
+	}
+	if strings.HasPrefix(cacheDBFlag, "file:") {
+		return "sqlite3", cacheDBFlag, nil
+	}
+	path, rerr := resolve.NewResolver(base, nil).Resolve(cacheDBFlag)
+	if rerr != nil {
+		return "", "", UsageErrorf("-cache-db %s: %s", cacheDBFlag, rerr)
+	}
+	return "sqlite3", path, nil
+}
+
+// configNameCache opens the name cache chosen by config, configured by it.
+func configNameCache(config *sous.Config, dc LocalDockerClient) (*sous.NameCache, error) {
+	opts, err := config.NameCacheOptions()
+	if err != nil {
+		return nil, err
+	}
+	return sous.NewNameCacheWithOptions(dc,
+		[]string{config.DatabaseDriver, config.DatabaseConnection}, opts...), nil
+}
+
+// nameCache opens the name cache chosen by -cache-db or config. It refuses
+// to open an in-memory database, which would always be empty.
+func (f *cacheFlags) nameCache(config LocalSousConfig, dc LocalDockerClient) (*sous.NameCache, cmdr.ErrorResult) {
+	driver, conn, errResult := cacheDB(config.Config, f.cacheDB, "")
+	if errResult != nil {
+		return nil, errResult
+	}
+	if sous.DatabaseFile(conn) == "" {
+		err := UsageErrorf("the name cache %q is in memory, so it is always empty", conn)
+		err.Tip = "use -cache-db to name the database file, " +
+			"or set DatabaseConnection in your sous config"
+		return nil, err
+	}
+	opts, oerr := config.NameCacheOptions()
+	if oerr != nil {
+		return nil, UsageErrorf("%s", oerr)
+	}
+	return sous.NewNameCacheWithOptions(dc, []string{driver, conn}, opts...), nil
+}
@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+)
+
+// SousCacheList is the description of the `sous cache list` command
+type SousCacheList struct {
+	Config       LocalSousConfig
+	DockerClient LocalDockerClient
+	Sink         OutputSink
+	flags        struct {
+		cacheFlags
+	}
+}
+
+func init() { CacheSubcommands["list"] = &SousCacheList{} }
+
+const sousCacheListHelp = `
+list the images in the name cache
+
+args: [<repo>]
+
+list prints the source version, canonical image name and cache time of each
+image in the name cache, or only those built from repo. With the global -json
+flag, all the names of each image are included, along with the number of
+times it has been refreshed from its registry, and whether the registry last
+said it was unchanged ("not-modified") or sent it in full ("full").
+`
+
+// Help prints the help
+func (*SousCacheList) Help() string { return sousCacheListHelp }
+
+// AddFlags adds flags for sous cache list
+func (sl *SousCacheList) AddFlags(fs *flag.FlagSet) {
+	sl.flags.addFlags(fs)
+}
+
+// cacheEntry is the JSON output of `sous cache list`
+type cacheEntry struct {
+	SourceVersion string    `json:"sourceVersion"`
+	ImageName     string    `json:"imageName"`
+	Names         []string  `json:"names"`
+	CachedAt      time.Time `json:"cachedAt"`
+	Refreshes     int       `json:"refreshes"`
+	LastFetch     string    `json:"lastFetch,omitempty"`
+}
+
+// Execute defines the behavior of `sous cache list`
+func (sl *SousCacheList) Execute(args []string) cmdr.Result {
+	if len(args) > 1 {
+		return UsageErrorf("sous cache list: at most one repo allowed")
+	}
+	nc, errResult := sl.flags.nameCache(sl.Config, sl.DockerClient)
+	if errResult != nil {
+		return errResult
+	}
+	defer nc.Close()
+	es, err := nc.Entries(sous.RepoURL(strings.Join(args, "")))
+	if err != nil {
+		return IOErrorf("unable to read name cache: %s", err)
+	}
+
+	out := make([]cacheEntry, len(es))
+	for i, e := range es {
+		out[i] = cacheEntry{e.SourceVersion.String(), e.CanonicalName, e.Names, e.CachedAt,
+			e.Refreshes, string(e.LastFetch)}
+	}
+	if errResult := sl.Sink.Result(out, func(w io.Writer) { printCacheEntries(w, es) }); errResult != nil {
+		return errResult
+	}
+	return Success()
+}
+
+func printCacheEntries(out io.Writer, es []sous.NameCacheEntry) {
+	w := &tabwriter.Writer{}
+	w.Init(out, 2, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "Source Version\tImage\tCached")
+	for _, e := range es {
+		fmt.Fprintf(w, "%s\t%s\t%s\n",
+			e.SourceVersion, e.CanonicalName, e.CachedAt.Format(time.RFC3339))
+	}
+	w.Flush()
+}
@@ -0,0 +1,69 @@
+package cli
+
+import (
+	"flag"
+	"time"
+
+	"github.com/opentable/sous/util/cmdr"
+)
+
+// SousCachePrune is the description of the `sous cache prune` command
+type SousCachePrune struct {
+	Config       LocalSousConfig
+	DockerClient LocalDockerClient
+	Out          Out
+	flags        struct {
+		cacheFlags
+		olderThan time.Duration
+		verify    bool
+	}
+}
+
+func init() { CacheSubcommands["prune"] = &SousCachePrune{} }
+
+const sousCachePruneHelp = `
+remove old images from the name cache
+
+args:
+
+prune removes every image that was cached longer ago than -older-than, and
+prints the ones it removed. With -verify, each of those images is looked up
+in its registry first, and those still there are kept and marked as freshly
+cached.
+`
+
+// Help prints the help
+func (*SousCachePrune) Help() string { return sousCachePruneHelp }
+
+// AddFlags adds flags for sous cache prune
+func (sp *SousCachePrune) AddFlags(fs *flag.FlagSet) {
+	sp.flags.addFlags(fs)
+	fs.DurationVar(&sp.flags.olderThan, "older-than", 720*time.Hour,
+		"remove images cached longer ago than this")
+	fs.BoolVar(&sp.flags.verify, "verify", false,
+		"keep images that are still in their registry")
+}
+
+// Execute defines the behavior of `sous cache prune`
+func (sp *SousCachePrune) Execute(args []string) cmdr.Result {
+	if len(args) != 0 {
+		return UsageErrorf("sous cache prune takes no arguments")
+	}
+	if sp.flags.olderThan < 0 {
+		return UsageErrorf("sous cache prune: -older-than must not be negative")
+	}
+	nc, errResult := sp.flags.nameCache(sp.Config, sp.DockerClient)
+	if errResult != nil {
+		return errResult
+	}
+	defer nc.Close()
+	removed, err := nc.Prune(time.Now().Add(-sp.flags.olderThan), sp.flags.verify)
+	if len(removed) != 0 {
+		printCacheEntries(sp.Out, removed)
+	}
+	sp.Out.Printfln("removed %d images", len(removed))
+	if err != nil {
+		return IOErrorf("unable to prune name cache: %s", err)
+	}
+	return Success()
+}
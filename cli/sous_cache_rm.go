@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"flag"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+)
+
+// SousCacheRm is the description of the `sous cache rm` command
+type SousCacheRm struct {
+	Config       LocalSousConfig
+	DockerClient LocalDockerClient
+	Out          Out
+	flags        struct {
+		cacheFlags
+	}
+}
+
+func init() { CacheSubcommands["rm"] = &SousCacheRm{} }
+
+const sousCacheRmHelp = `
+remove an image from the name cache
+
+args: <image name|source version>
+
+rm removes the image with the given name, or built from the given source
+version, such as github.com/opentable/sous,1.0.0, from the name cache, so
+that it is looked up in its registry next time it is needed. If there is no
+such image in the cache the exit code is 1.
+`
+
+// Help prints the help
+func (*SousCacheRm) Help() string { return sousCacheRmHelp }
+
+// AddFlags adds flags for sous cache rm
+func (sr *SousCacheRm) AddFlags(fs *flag.FlagSet) {
+	sr.flags.addFlags(fs)
+}
+
+// Execute defines the behavior of `sous cache rm`
+func (sr *SousCacheRm) Execute(args []string) cmdr.Result {
+	if len(args) != 1 {
+		return UsageErrorf("sous cache rm: exactly one image name or source version required")
+	}
+	nc, errResult := sr.flags.nameCache(sr.Config, sr.DockerClient)
+	if errResult != nil {
+		return errResult
+	}
+	defer nc.Close()
+
+	var n int64
+	var err error
+	if sv, perr := sous.ParseSourceVersion(args[0]); perr == nil {
+		n, err = nc.Invalidate(sv)
+	} else {
+		n, err = nc.InvalidateImage(args[0])
+	}
+	if err != nil {
+		return IOErrorf("unable to remove %s from name cache: %s", args[0], err)
+	}
+	if n == 0 {
+		return FailureErrorf("%s is not in the name cache", args[0])
+	}
+	sr.Out.Printfln("removed %d images", n)
+	return Success()
+}
@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+	"github.com/opentable/sous/util/docker_registry"
+	"github.com/samsalisbury/semv"
+)
+
+func newTestCacheDB(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "sous-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := filepath.Join(dir, "cache.db")
+	nc := sous.NewNameCache(docker_registry.NewDummyClient(), "sqlite3", db)
+	for _, v := range []string{"1.0.0", "1.1.0"} {
+		sv := sous.SourceVersion{RepoURL: "github.com/opentable/example", Version: semv.MustParse(v)}
+		if err := nc.Insert(sv, "docker.example.com/example:"+v, "etag"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db, func() { os.RemoveAll(dir) }
+}
+
+func TestSousCacheList(t *testing.T) {
+	db, cleanup := newTestCacheDB(t)
+	defer cleanup()
+
+	out := &bytes.Buffer{}
+	sl := &SousCacheList{Config: LocalSousConfig{&sous.Config{}}, Sink: testSink(out, true)}
+	sl.flags.cacheDB = db
+	if r := sl.Execute([]string{"github.com/opentable/example"}); r.ExitCode() != 0 {
+		t.Fatalf("got %T %v; want success", r, r)
+	}
+	got := []cacheEntry{}
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("%s in:\n%s", err, out)
+	}
+	if len(got) != 2 || got[1].ImageName != "docker.example.com/example:1.1.0" {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestSousCacheRm(t *testing.T) {
+	db, cleanup := newTestCacheDB(t)
+	defer cleanup()
+
+	sr := &SousCacheRm{Config: LocalSousConfig{&sous.Config{}}, Out: Out{cmdr.NewOutput(&bytes.Buffer{})}}
+	sr.flags.cacheDB = db
+	for _, arg := range []string{"github.com/opentable/example,1.0.0", "docker.example.com/example:1.1.0"} {
+		if r := sr.Execute([]string{arg}); r.ExitCode() != 0 {
+			t.Errorf("removing %s: got %T %v; want success", arg, r, r)
+		}
+		if r := sr.Execute([]string{arg}); r.ExitCode() != 1 {
+			t.Errorf("removing %s again: got exit code %d; want 1", arg, r.ExitCode())
+		}
+	}
+}
+
+func TestSousCachePrune(t *testing.T) {
+	db, cleanup := newTestCacheDB(t)
+	defer cleanup()
+
+	out := &bytes.Buffer{}
+	sp := &SousCachePrune{Config: LocalSousConfig{&sous.Config{}}, Out: Out{cmdr.NewOutput(out)}}
+	sp.flags.cacheDB = db
+	sp.flags.olderThan = -1
+	if r := sp.Execute(nil); r.ExitCode() != cmdr.EX_USAGE {
+		t.Errorf("got exit code %d for negative -older-than; want %d", r.ExitCode(), cmdr.EX_USAGE)
+	}
+	sp.flags.olderThan = 0
+	if r := sp.Execute(nil); r.ExitCode() != 0 {
+		t.Fatalf("got %T %v; want success", r, r)
+	}
+	if !strings.Contains(out.String(), "removed 2 images") {
+		t.Errorf("got output:\n%s", out)
+	}
+}
+
+func TestSousCache_RefusesInMemory(t *testing.T) {
+	sl := &SousCacheList{Config: LocalSousConfig{&sous.Config{
+		DatabaseDriver:     "sqlite3",
+		DatabaseConnection: sous.InMemory,
+	}}}
+	r := sl.Execute(nil)
+	if r.ExitCode() != cmdr.EX_USAGE || !strings.Contains(r.(cmdr.ErrorResult).Error(), "in memory") {
+		t.Errorf("got %T %v; want usage error about in-memory cache", r, r)
+	}
+}
+
+func TestSousCacheDBFlagExpandsEnv(t *testing.T) {
+	db, cleanup := newTestCacheDB(t)
+	defer cleanup()
+	os.Setenv("SOUS_TEST_CACHE_DIR", filepath.Dir(db))
+	defer os.Unsetenv("SOUS_TEST_CACHE_DIR")
+
+	driver, conn, errResult := cacheDB(&sous.Config{}, "$SOUS_TEST_CACHE_DIR/"+filepath.Base(db), "/elsewhere")
+	if errResult != nil {
+		t.Fatal(errResult)
+	}
+	if driver != "sqlite3" || conn != db {
+		t.Errorf("got %s %s; want sqlite3 %s", driver, conn, db)
+	}
+}
+
+func TestSousCacheDBFlagIsRelativeToBase(t *testing.T) {
+	for flag, want := range map[string]string{
+		"cache.db":                   "/state/cache.db",
+		"./db/cache.db":              "/state/db/cache.db",
+		"/abs/cache.db":              "/abs/cache.db",
+		"file:x?mode=memory":         "file:x?mode=memory",
+		sous.InMemoryConnection("x"): sous.InMemoryConnection("x"),
+	} {
+		_, conn, errResult := cacheDB(&sous.Config{}, flag, "/state")
+		if errResult != nil {
+			t.Errorf("%s: %s", flag, errResult)
+		} else if conn != want {
+			t.Errorf("%s: got %s; want %s", flag, conn, want)
+		}
+	}
+}
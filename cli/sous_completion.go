@@ -0,0 +1,255 @@
+package cli
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+)
+
+// SousCompletion is the description of the `sous completion` command
+type SousCompletion struct {
+	CLI   *cmdr.CLI
+	flags struct {
+		list, stateDir string
+	}
+}
+
+func init() { TopLevelCommands["completion"] = &SousCompletion{} }
+
+const sousCompletionHelp = `
+print a shell completion script
+
+args: <bash|zsh>
+
+completion prints a script that completes sous commands and their flags when
+sourced by the named shell, e.g. add this to your .bashrc:
+
+  source <(sous completion bash)
+
+The values of -cluster are completed with the names of the clusters in the
+state directory, and those of -only, -repo, -manifest and -source with the
+source locations of its manifests. The script gets them by running completion with
+-list clusters or -list sources, which print one per line.
+`
+
+// Help prints the help
+func (*SousCompletion) Help() string { return sousCompletionHelp }
+
+// AddFlags adds flags for sous completion
+func (sc *SousCompletion) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&sc.flags.list, "list", "",
+		"instead of a script, list the clusters or sources in the state")
+	addStateDirFlag(fs, &sc.flags.stateDir)
+}
+
+// completionLists are the lists completion -list can print, keyed by the
+// flags whose values they complete.
+var completionLists = map[string]string{
+	"cluster":  "clusters",
+	"only":     "sources",
+	"repo":     "sources",
+	"manifest": "sources",
+	"source":   "sources",
+}
+
+// Execute defines the behavior of `sous completion`
+func (sc *SousCompletion) Execute(args []string) cmdr.Result {
+	if sc.flags.list != "" {
+		return sc.list()
+	}
+	if len(args) != 1 {
+		return UsageErrorf("sous completion: a shell, bash or zsh, is required")
+	}
+	shell := args[0]
+	if shell != "bash" && shell != "zsh" {
+		return UsageErrorf("sous completion: unsupported shell %q; want bash or zsh", shell)
+	}
+	b, err := completionScript(shell, cmdr.Describe("sous", sc.CLI.Root))
+	if err != nil {
+		return InternalErrorf("unable to write completion script: %s", err)
+	}
+	return SuccessData(b)
+}
+
+// list prints the names asked for by -list.
+func (sc *SousCompletion) list() cmdr.Result {
+	dir, errResult := stateDir("", sc.flags.stateDir)
+	if errResult != nil {
+		return errResult
+	}
+	var names []string
+	switch sc.flags.list {
+	default:
+		return UsageErrorf("sous completion: unknown list %q; want clusters or sources", sc.flags.list)
+	case "clusters":
+		state, err := sous.LoadState(dir)
+		if err != nil {
+			return stateLoadError(dir, err)
+		}
+		for name := range state.Defs.Clusters {
+			names = append(names, name)
+		}
+	case "sources":
+		var err error
+		if names, err = manifestSources(dir); err != nil {
+			return IOErrorf("unable to list manifests in %s: %s", dir, err)
+		}
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return SuccessData(nil)
+	}
+	return SuccessData([]byte(strings.Join(names, "\n") + "\n"))
+}
+
+// manifestSources returns the source locations of the manifests in the
+// state in dir, which are their file names without the extension. Unlike
+// loading the state, this is quick enough to run on every tab press.
+func manifestSources(dir string) ([]string, error) {
+	root := filepath.Join(dir, "manifests")
+	names := []string{}
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		names = append(names, strings.TrimSuffix(filepath.ToSlash(rel), filepath.Ext(rel)))
+		return nil
+	})
+	return names, err
+}
+
+// completionCommand is a command as seen by the completion script templates.
+type completionCommand struct {
+	// Path is the command, e.g. "sous state parse"
+	Path        string
+	Subcommands string
+	Flags       string
+}
+
+// completionScript renders the completion script for shell.
+func completionScript(shell string, root cmdr.CommandInfo) ([]byte, error) {
+	data := struct {
+		Commands   []completionCommand
+		ValueFlags string
+		Lists      map[string][]string
+	}{Lists: map[string][]string{}}
+	valueFlags := map[string]bool{}
+	root.Walk(func(path []string, c cmdr.CommandInfo) {
+		subs := make([]string, len(c.Subcommands))
+		for i, s := range c.Subcommands {
+			subs[i] = s.Name
+		}
+		flags := make([]string, len(c.Flags))
+		for i, f := range c.Flags {
+			flags[i] = "-" + f.Name
+			if !f.IsBool {
+				valueFlags["-"+f.Name] = true
+			}
+		}
+		data.Commands = append(data.Commands, completionCommand{
+			Path:        strings.Join(path, " "),
+			Subcommands: strings.Join(subs, " "),
+			Flags:       strings.Join(flags, " "),
+		})
+	})
+	names := make([]string, 0, len(valueFlags))
+	for name := range valueFlags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	data.ValueFlags = strings.Join(names, " ")
+	for name, list := range completionLists {
+		data.Lists[list] = append(data.Lists[list], "-"+name)
+	}
+	for _, flags := range data.Lists {
+		sort.Strings(flags)
+	}
+
+	buf := &bytes.Buffer{}
+	err := completionTemplate.ExecuteTemplate(buf, shell, data)
+	return buf.Bytes(), err
+}
+
+var completionTemplate = template.Must(template.New("bash").Funcs(template.FuncMap{
+	"join": strings.Join,
+}).Parse(bashCompletion))
+
+func init() {
+	template.Must(completionTemplate.New("zsh").Parse(`#compdef sous
+autoload -U +X bashcompinit && bashcompinit
+{{template "bash" .}}`))
+}
+
+const bashCompletion = `# sous completion, generated by sous completion
+_sous_subcommands() {
+	case "$1" in
+{{- range .Commands}}{{if .Subcommands}}
+	"{{.Path}}") echo "{{.Subcommands}}" ;;
+{{- end}}{{end}}
+	esac
+}
+
+_sous_flags() {
+	case "$1" in
+{{- range .Commands}}
+	"{{.Path}}") echo "{{.Flags}}" ;;
+{{- end}}
+	esac
+}
+
+_sous_values() {
+	case "$1" in
+{{- range $list, $flags := .Lists}}
+	{{join $flags "|"}}) sous completion -list {{$list}} 2>/dev/null ;;
+{{- end}}
+	esac
+}
+
+_sous() {
+	local cur prev path word i values
+	COMPREPLY=()
+	cur="${COMP_WORDS[COMP_CWORD]}"
+	prev="${COMP_WORDS[COMP_CWORD-1]}"
+	path=sous
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		word="${COMP_WORDS[i]}"
+		if [[ " $(_sous_subcommands "$path") " == *" $word "* ]]; then
+			path="$path $word"
+		fi
+	done
+
+	values="$(_sous_values "$prev")"
+	if [[ -n "$values" ]]; then
+		COMPREPLY=($(compgen -W "$values" -- "$cur"))
+		return
+	fi
+	if [[ " {{.ValueFlags}} " == *" $prev "* ]]; then
+		COMPREPLY=($(compgen -f -- "$cur"))
+		return
+	fi
+	if [[ "$cur" == -* ]]; then
+		COMPREPLY=($(compgen -W "$(_sous_flags "$path")" -- "$cur"))
+		return
+	fi
+	COMPREPLY=($(compgen -W "$(_sous_subcommands "$path")" -- "$cur"))
+}
+
+complete -F _sous sous
+`
@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/opentable/sous/util/cmdr"
+)
+
+func TestCompletionScript(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh"} {
+		b, err := completionScript(shell, cmdr.Describe("sous", &Sous{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		script := string(b)
+		for _, want := range []string{
+			`"sous state") echo "parse validate" ;;`,
+			`"sous rectify") echo "-audit-log -cluster -d -dry-run -force-downgrade -ignore-blast-radius -ignore-quotas -json -manifest -no-color -only -q -quiet -s -skip-unreadable -state-dir -v -wide" ;;`,
+			`-cluster) sous completion -list clusters 2>/dev/null ;;`,
+			`-manifest|-only|-repo|-source) sous completion -list sources 2>/dev/null ;;`,
+			"complete -F _sous sous\n",
+		} {
+			if !strings.Contains(script, want) {
+				t.Errorf("%s script doesn't contain %q:\n%s", shell, want, script)
+			}
+		}
+		if strings.HasPrefix(script, "#compdef") != (shell == "zsh") {
+			t.Errorf("%s script starts %q", shell, strings.SplitN(script, "\n", 2)[0])
+		}
+	}
+}
+
+func TestSousCompletion_List(t *testing.T) {
+	dir := writeStateDir(t, validState)
+	defer os.RemoveAll(dir)
+
+	for list, want := range map[string]string{
+		"clusters": "eu-west\nus-west\n",
+		"sources":  "github.com/opentable/one\n",
+	} {
+		sc := &SousCompletion{}
+		sc.flags.list = list
+		sc.flags.stateDir = dir
+		r := sc.Execute(nil)
+		success, ok := r.(cmdr.SuccessResult)
+		if !ok {
+			t.Fatalf("-list %s: got %T %v; want success", list, r, r)
+		}
+		if got := success.String(); got != want {
+			t.Errorf("-list %s: got %q; want %q", list, got, want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+)
+
+// SousDeployPreview is the description of the `sous deploy-preview` command
+type SousDeployPreview struct {
+	Config       LocalSousConfig
+	DockerClient LocalDockerClient
+	Sink         OutputSink
+	// rc is the client the preview is deployed with, which is a
+	// SingularityClient for the clusters in the state unless set by tests
+	rc    sous.RectificationClient
+	flags struct {
+		cluster, source, stateDir string
+		ttl                       time.Duration
+		instances                 int
+		env                       envFlag
+	}
+}
+
+func init() { TopLevelCommands["deploy-preview"] = &SousDeployPreview{} }
+
+const sousDeployPreviewHelp = `
+deploy a temporary preview of a version of a service
+
+deploy-preview deploys the source version given by -source, such as
+github.com/opentable/sous,1.2.3-pr42, to the cluster given by -cluster, as a
+request of its own beside the service's usual one, e.g. to try out a pull
+request. The preview is configured as the manifest configures the service on
+that cluster, but runs -instances instances, with the env vars given by
+each -env NAME=value set as well.
+
+Previews aren't in the state, and rectify leaves them alone. Each is recorded
+in the file named by PreviewLedger in your config, and expires after -ttl,
+when sous preview-gc deletes it. Deploying a preview of the same version
+again replaces it, and restarts its -ttl.
+
+With the global -json flag, the preview is printed as it's recorded.
+
+The cluster and manifest are found in the state directory given by
+-state-dir, $SOUS_STATE_DIR, or the nearest directory above the working
+directory containing defs.yaml or .sous-state.
+`
+
+// Help prints the help
+func (*SousDeployPreview) Help() string { return sousDeployPreviewHelp }
+
+// AddFlags adds flags for sous deploy-preview
+func (sd *SousDeployPreview) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&sd.flags.cluster, "cluster", "",
+		"the cluster to deploy the preview to (required)")
+	fs.StringVar(&sd.flags.source, "source", "",
+		"the source version to preview, e.g. github.com/opentable/sous,1.2.3 (required)")
+	fs.DurationVar(&sd.flags.ttl, "ttl", 24*time.Hour,
+		"how long the preview lasts before sous preview-gc deletes it")
+	fs.IntVar(&sd.flags.instances, "instances", 1,
+		"how many instances of the preview to run")
+	fs.Var(&sd.flags.env, "env",
+		"an env var to set, as NAME=value (may be repeated)")
+	addStateDirFlag(fs, &sd.flags.stateDir)
+}
+
+// Execute defines the behavior of `sous deploy-preview`
+func (sd *SousDeployPreview) Execute(args []string) cmdr.Result {
+	if len(args) != 0 {
+		return UsageErrorf("sous deploy-preview: no arguments expected")
+	}
+	if sd.flags.cluster == "" {
+		return UsageErrorf("sous deploy-preview: -cluster required")
+	}
+	if sd.flags.source == "" {
+		return UsageErrorf("sous deploy-preview: -source required")
+	}
+	sv, err := sous.ParseSourceVersion(sd.flags.source)
+	if err != nil {
+		return UsageErrorf("sous deploy-preview: %s", err)
+	}
+	if sd.flags.ttl <= 0 {
+		return UsageErrorf("sous deploy-preview: -ttl must be positive, not %s", sd.flags.ttl)
+	}
+	if sd.flags.instances <= 0 {
+		return UsageErrorf("sous deploy-preview: -instances must be positive, not %d", sd.flags.instances)
+	}
+	if sd.Config.PreviewLedger == "" {
+		return UsageErrorf("sous deploy-preview: PreviewLedger isn't set in your config")
+	}
+
+	dir, errResult := stateDir("", sd.flags.stateDir)
+	if errResult != nil {
+		return errResult
+	}
+	state, err := sous.LoadState(dir)
+	if err != nil {
+		return stateLoadError(dir, err)
+	}
+	cluster, ok := state.Defs.Clusters[sd.flags.cluster]
+	if !ok {
+		return UsageErrorf("sous deploy-preview: no cluster named %q is defined", sd.flags.cluster)
+	}
+	sl := sv.CanonicalName()
+	_, m, ok := state.ManifestFor(sl)
+	if !ok {
+		return UsageErrorf("sous deploy-preview: no manifest for %s", sl)
+	}
+	ds, err := state.DeploymentsFromManifest(m)
+	if err != nil {
+		return DataErrorf("invalid manifest for %s: %s", sl, err)
+	}
+	var previewed *sous.Deployment
+	for _, d := range ds {
+		if d.Cluster == sous.ClusterName(cluster.BaseURL) {
+			previewed = d
+		}
+	}
+	if previewed == nil {
+		return UsageErrorf("sous deploy-preview: %s is not deployed to %s", sl, sd.flags.cluster)
+	}
+
+	rc := sd.rc
+	if rc == nil {
+		nc, err := configNameCache(sd.Config.Config, sd.DockerClient)
+		if err != nil {
+			return EnsureErrorResult(err)
+		}
+		defer nc.Close()
+		rc = sous.NewSingularityClient(state.Defs.ClusterURLs(), nc)
+	}
+	now := time.Now()
+	d := sous.NewPreviewDeployment(previewed, sv, sous.PreviewOpts{
+		Instances: sd.flags.instances,
+		Env:       sous.Env(sd.flags.env),
+		TTL:       sd.flags.ttl,
+	}, now)
+	p, err := sous.DeployPreview(rc, d, sd.flags.cluster, sous.NewPreviewLedger(sd.Config.PreviewLedger), now)
+	if err != nil {
+		return IOErrorf("unable to deploy a preview of %s to %s: %s", sv, sd.flags.cluster, err)
+	}
+	if errResult := sd.Sink.Result(p, func(out io.Writer) {
+		fmt.Fprintf(out, "deployed preview %s of %s to %s, expiring %s\n",
+			p.RequestID, sv, sd.flags.cluster, p.Expires.Local().Format(time.RFC3339))
+	}); errResult != nil {
+		return errResult
+	}
+	return Success()
+}
+
+// envFlag collects the NAME=value pairs given by a repeated flag.
+type envFlag sous.Env
+
+func (f *envFlag) String() string {
+	pairs := make([]string, 0, len(*f))
+	for name, value := range *f {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+// Set implements flag.Value
+func (f *envFlag) Set(s string) error {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("%q is not NAME=value", s)
+	}
+	if *f == nil {
+		*f = envFlag{}
+	}
+	(*f)[parts[0]] = parts[1]
+	return nil
+}
@@ -0,0 +1,175 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+)
+
+var previewState = map[string]string{
+	"defs.yaml": validState["defs.yaml"],
+	"manifests/github.com/opentable/one.yaml": `
+Source: github.com/opentable/one
+Kind: http-service
+Deployments:
+  us-west:
+    Version: 1.0.0
+    NumInstances: 4
+    Resources:
+      cpus: "0.1"
+      memory: "100"
+      ports: "1"
+    Env:
+      A: "1"
+`,
+}
+
+// runDeployPreview runs sous deploy-preview with the flags set by setFlags,
+// recording previews in a ledger in the state directory.
+func runDeployPreview(t *testing.T, rc sous.RectificationClient, setFlags func(*SousDeployPreview)) (string, string, cmdr.Result) {
+	dir := writeStateDir(t, previewState)
+	out := &bytes.Buffer{}
+	ledger := filepath.Join(dir, "previews.json")
+	sd := &SousDeployPreview{
+		Config: LocalSousConfig{&sous.Config{PreviewLedger: ledger}},
+		Sink:   testSink(out, false),
+		rc:     rc,
+	}
+	sd.flags.stateDir = dir
+	sd.flags.cluster = "us-west"
+	sd.flags.source = "github.com/opentable/one,1.1.0-pr42"
+	sd.flags.ttl = 48 * time.Hour
+	sd.flags.instances = 1
+	setFlags(sd)
+	r := sd.Execute(nil)
+	return out.String(), ledger, r
+}
+
+func TestSousDeployPreview(t *testing.T) {
+	rc := sous.NewDummyRectificationClient(sous.NewDummyNameCache())
+	out, ledger, r := runDeployPreview(t, rc, func(sd *SousDeployPreview) {
+		if err := sd.flags.env.Set("B=preview"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	defer os.RemoveAll(filepath.Dir(ledger))
+	if _, ok := r.(cmdr.SuccessResult); !ok {
+		t.Fatalf("got %T %v; want success", r, r)
+	}
+	reqID := "sous-preview-github.comopentableone-1.1.0-pr42"
+	if !strings.HasPrefix(out, "deployed preview "+reqID+" of github.com/opentable/one 1.1.0-pr42 to us-west") {
+		t.Errorf("got output %q", out)
+	}
+	deploys := rc.CallsTo("Deploy")
+	if len(deploys) != 1 {
+		t.Fatalf("got %d deploys; want 1", len(deploys))
+	}
+	if deploys[0].Args[2] != reqID {
+		t.Errorf("deployed request %v; want %s", deploys[0].Args[2], reqID)
+	}
+	if env := deploys[0].Args[5].(sous.Env); !env.Equal(sous.Env{"A": "1", "B": "preview"}) {
+		t.Errorf("deployed env %v", env)
+	}
+
+	ps, err := sous.NewPreviewLedger(ledger).Previews()
+	if err != nil || len(ps) != 1 || ps[0].RequestID != reqID || ps[0].Cluster != "us-west" {
+		t.Errorf("got ledger %+v, %v", ps, err)
+	}
+}
+
+func TestSousDeployPreview_UsageErrors(t *testing.T) {
+	for i, setFlags := range []func(*SousDeployPreview){
+		func(sd *SousDeployPreview) { sd.flags.cluster = "" },
+		func(sd *SousDeployPreview) { sd.flags.cluster = "mars" },
+		func(sd *SousDeployPreview) { sd.flags.cluster = "eu-west" },
+		func(sd *SousDeployPreview) { sd.flags.source = "" },
+		func(sd *SousDeployPreview) { sd.flags.source = "github.com/opentable/one" },
+		func(sd *SousDeployPreview) { sd.flags.source = "github.com/opentable/two,1.0.0" },
+		func(sd *SousDeployPreview) { sd.flags.ttl = 0 },
+		func(sd *SousDeployPreview) { sd.flags.instances = 0 },
+		func(sd *SousDeployPreview) { sd.Config.PreviewLedger = "" },
+	} {
+		rc := sous.NewDummyRectificationClient(sous.NewDummyNameCache())
+		_, ledger, r := runDeployPreview(t, rc, setFlags)
+		os.RemoveAll(filepath.Dir(ledger))
+		if _, ok := r.(cmdr.UsageErr); !ok {
+			t.Errorf("case %d: got %T %v; want a usage error", i, r, r)
+		}
+		if len(rc.Calls()) != 0 {
+			t.Errorf("case %d: got calls %v; want none", i, rc.Calls())
+		}
+	}
+}
+
+func TestEnvFlag(t *testing.T) {
+	var f envFlag
+	for _, s := range []string{"A=1", "B=x=y", "C="} {
+		if err := f.Set(s); err != nil {
+			t.Errorf("Set(%q): %s", s, err)
+		}
+	}
+	if got := f.String(); got != "A=1 B=x=y C=" {
+		t.Errorf("got %q", got)
+	}
+	for _, s := range []string{"A", "=1"} {
+		if err := f.Set(s); err == nil {
+			t.Errorf("Set(%q) succeeded; want an error", s)
+		}
+	}
+}
+
+func TestSousPreviewGC(t *testing.T) {
+	dir := writeStateDir(t, map[string]string{})
+	defer os.RemoveAll(dir)
+	ledger := sous.NewPreviewLedger(filepath.Join(dir, "previews.json"))
+	now := time.Now()
+	for _, p := range []sous.Preview{
+		{RequestID: "sous-preview-active", Cluster: "us-west", BaseURL: "http://singularity.example.com", Expires: now.Add(time.Hour)},
+		{RequestID: "sous-preview-expired", Cluster: "us-west", BaseURL: "http://singularity.example.com", Expires: now.Add(-time.Hour)},
+	} {
+		if err := ledger.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run := func(dryRun bool) (*sous.DummyRectificationClient, string) {
+		rc := sous.NewDummyRectificationClient(sous.NewDummyNameCache())
+		out := &bytes.Buffer{}
+		sp := &SousPreviewGC{
+			Config: LocalSousConfig{&sous.Config{PreviewLedger: filepath.Join(dir, "previews.json")}},
+			Sink:   testSink(out, false),
+			rc:     rc,
+		}
+		sp.flags.dryRun = dryRun
+		if r := sp.Execute(nil); r.ExitCode() != 0 {
+			t.Fatalf("got %T %v; want success", r, r)
+		}
+		return rc, out.String()
+	}
+
+	rc, out := run(true)
+	if len(rc.CallsTo("DeleteRequest")) != 0 {
+		t.Error("-dry-run deleted a preview")
+	}
+	if !strings.Contains(out, "sous-preview-expired") || !strings.Contains(out, "expired\n") {
+		t.Errorf("got:\n%s", out)
+	}
+
+	rc, out = run(false)
+	if deletes := rc.CallsTo("DeleteRequest"); len(deletes) != 1 || deletes[0].Args[1] != "sous-preview-expired" {
+		t.Errorf("got deletes %v; want sous-preview-expired deleted", deletes)
+	}
+	if !strings.Contains(out, "deleted\n") || !strings.Contains(out, "active\n") {
+		t.Errorf("got:\n%s", out)
+	}
+	ps, err := ledger.Previews()
+	if err != nil || len(ps) != 1 || ps[0].RequestID != "sous-preview-active" {
+		t.Errorf("got ledger %+v, %v; want only the active preview", ps, err)
+	}
+}
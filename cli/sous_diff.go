@@ -0,0 +1,188 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+	"golang.org/x/net/context"
+)
+
+// SousDiff is the description of the `sous diff` command
+type SousDiff struct {
+	Config       LocalSousConfig
+	DockerClient LocalDockerClient
+	Sink         OutputSink
+	flags        struct {
+		stateDir       string
+		skipUnreadable bool
+	}
+}
+
+func init() { TopLevelCommands["diff"] = &SousDiff{} }
+
+const sousDiffHelp = `
+show how deployments differ between states
+
+args: [<dir> [<other dir>]]
+
+Given one state directory, diff compares the deployments it describes with
+those running on its clusters. Given two, it compares the deployments of the
+first with those of the second, e.g. a branch against master. Given none,
+the state directory is taken from -state-dir, $SOUS_STATE_DIR, or the
+nearest directory above the working directory containing defs.yaml or
+.sous-state.
+
+Each deployment that would be created, deleted or modified is printed with
+an explanation of what changed. On a terminal, the table is colour coded and
+truncated to fit, unless the global -no-color or -wide flags are given. With
+the global -json flag the whole report, including unchanged deployments, is
+printed as JSON instead. The exit code is 0 if there are no differences and 1
+if there are.
+
+If the deployments running on any cluster can't be read, diff fails, unless
+-skip-unreadable is given, in which case those clusters are reported and left
+out of the comparison.
+`
+
+// Help prints the help
+func (*SousDiff) Help() string { return sousDiffHelp }
+
+// AddFlags adds flags for sous diff
+func (sd *SousDiff) AddFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&sd.flags.skipUnreadable, "skip-unreadable", false,
+		"leave out clusters whose running deployments can't be read, rather than failing")
+	addStateDirFlag(fs, &sd.flags.stateDir)
+}
+
+// Execute defines the behavior of `sous diff`
+func (sd *SousDiff) Execute(args []string) cmdr.Result {
+	if len(args) > 2 {
+		return UsageErrorf("sous diff: at most two state directories allowed")
+	}
+	if len(args) < 2 {
+		dir, errResult := stateDir(stateDirArg(args), sd.flags.stateDir)
+		if errResult != nil {
+			return errResult
+		}
+		args = []string{dir}
+	}
+
+	var from sous.Deployments
+	var unread map[sous.ClusterName]bool
+	var err error
+	if len(args) == 1 {
+		from, unread, err = sd.running(args[0])
+	} else {
+		from, err = intended(args[0])
+	}
+	if err != nil {
+		return EnsureErrorResult(err)
+	}
+	to, err := intended(args[len(args)-1])
+	if err != nil {
+		return EnsureErrorResult(err)
+	}
+	to = to.Filter(func(d *sous.Deployment) bool { return !unread[d.Cluster] })
+	r := sous.CollectDiff(from.Diff(to))
+	sortDiffReport(r)
+
+	if errResult := sd.Sink.Result(r, func(w io.Writer) { printDiffReport(w, sd.Sink.renderer(), r) }); errResult != nil {
+		return errResult
+	}
+
+	if n := r.Counts.Created + r.Counts.Deleted + r.Counts.Modified; n != 0 {
+		return FailureErrorf("%d deployments differ", n)
+	}
+	return Success()
+}
+
+// intended loads the deployments intended by the state in dir.
+func intended(dir string) (sous.Deployments, error) {
+	state, err := sous.LoadState(dir)
+	if err != nil {
+		return nil, err
+	}
+	return state.Deployments()
+}
+
+// running reads the deployments running on the clusters of the state in
+// dir. With -skip-unreadable, clusters that can't be read are reported, and
+// returned, rather than failing.
+func (sd *SousDiff) running(dir string) (sous.Deployments, map[sous.ClusterName]bool, error) {
+	state, err := sous.LoadState(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	nc, err := configNameCache(sd.Config.Config, sd.DockerClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer nc.Close()
+	ds, errs := sous.CollectExistingDeployments(context.Background(), sous.NewRectiAgent(nc), state.BaseURLs())
+	if len(errs) != 0 && !sd.flags.skipUnreadable {
+		return nil, nil, sous.CollectErrors(errs)
+	}
+	unread := map[sous.ClusterName]bool{}
+	for _, err := range errs {
+		sd.Sink.Error(err)
+		unread[err.(*sous.CollectError).Cluster] = true
+	}
+	return ds, unread, nil
+}
+
+func printDiffReport(out io.Writer, rn renderer, r sous.DiffReport) {
+	t := table{header: []string{"Action", "Cluster", "Source", "Changes"}}
+	row := func(action string, d *sous.Deployment, change cell) []cell {
+		return []cell{actionCell(action, ""), plain(string(d.Cluster)), plain(diffSource(d)), change}
+	}
+	for _, d := range r.Created {
+		t.rows = append(t.rows, row("create", d, plain(diffSummary(d))))
+	}
+	for _, d := range r.Deleted {
+		t.rows = append(t.rows, row("delete", d, plain(diffSummary(d))))
+	}
+	for _, p := range r.Modified {
+		d := p.Post()
+		for i, change := range p.Differences() {
+			if i == 0 {
+				t.rows = append(t.rows, row("modify", d, changeCell(change)))
+			} else {
+				t.rows = append(t.rows, []cell{nil, nil, nil, changeCell(change)})
+			}
+		}
+	}
+	rn.render(out, t)
+	fmt.Fprintf(out, "%d to create, %d to delete, %d to modify, %d unchanged\n",
+		r.Counts.Created, r.Counts.Deleted, r.Counts.Modified, r.Counts.Retained)
+}
+
+func diffSource(d *sous.Deployment) string {
+	if d.ForeignImage != "" {
+		return d.ForeignImage
+	}
+	return d.SourceVersion.CanonicalName().String()
+}
+
+func diffSummary(d *sous.Deployment) string {
+	return fmt.Sprintf("version %s, %d instances", d.SourceVersion.Version, d.NumInstances)
+}
+
+// sortDiffReport sorts each list in r by cluster and then source, so that
+// the output is stable
+func sortDiffReport(r sous.DiffReport) {
+	less := func(a, b *sous.Deployment) bool {
+		if a.Cluster != b.Cluster {
+			return a.Cluster < b.Cluster
+		}
+		return diffSource(a) < diffSource(b)
+	}
+	for _, ds := range []sous.Deployments{r.Created, r.Deleted, r.Retained} {
+		sort.Slice(ds, func(i, j int) bool { return less(ds[i], ds[j]) })
+	}
+	ps := r.Modified
+	sort.Slice(ps, func(i, j int) bool { return less(ps[i].Post(), ps[j].Post()) })
+}
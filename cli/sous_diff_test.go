@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+)
+
+func diffState(version, instances, env string) map[string]string {
+	return map[string]string{
+		"defs.yaml": validState["defs.yaml"],
+		"manifests/github.com/opentable/one.yaml": `
+Source: github.com/opentable/one
+Kind: http-service
+Deployments:
+  us-west:
+    Version: ` + version + `
+    NumInstances: ` + instances + `
+    Resources:
+      cpus: "0.1"
+      memory: "32"
+    Env:
+` + env,
+	}
+}
+
+func runDiff(t *testing.T, json bool, a, b map[string]string) (string, cmdr.Result) {
+	dirA, dirB := writeStateDir(t, a), writeStateDir(t, b)
+	defer os.RemoveAll(dirA)
+	defer os.RemoveAll(dirB)
+
+	out := &bytes.Buffer{}
+	sd := &SousDiff{Sink: testSink(out, json)}
+	r := sd.Execute([]string{dirA, dirB})
+	return out.String(), r
+}
+
+func TestSousDiff_NoDifferences(t *testing.T) {
+	state := diffState("1.0.0", "1", "      A: a\n")
+	out, r := runDiff(t, false, state, state)
+	if _, ok := r.(cmdr.SuccessResult); !ok {
+		t.Fatalf("got %T %v; want success", r, r)
+	}
+	if !strings.Contains(out, "0 to create, 0 to delete, 0 to modify, 1 unchanged") {
+		t.Errorf("got output:\n%s", out)
+	}
+}
+
+func TestSousDiff_Modified(t *testing.T) {
+	out, r := runDiff(t, false,
+		diffState("1.0.0", "1", "      A: a\n      B: b\n"),
+		diffState("1.1.0", "2", "      A: changed\n      C: c\n"))
+	if r.ExitCode() != 1 {
+		t.Errorf("got exit code %d; want 1", r.ExitCode())
+	}
+	for _, want := range []string{
+		"modify", "github.com/opentable/one",
+		"version: 1.0.0 -> 1.1.0",
+		"instances: 1 -> 2",
+		"env added: [C]", "env removed: [B]", "env changed: [A]",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output doesn't contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestSousDiff_JSON(t *testing.T) {
+	out, r := runDiff(t, true, validState, diffState("1.0.0", "1", "      A: a\n"))
+	if r.ExitCode() != 1 {
+		t.Errorf("got exit code %d; want 1", r.ExitCode())
+	}
+	report := sous.DiffReport{}
+	if err := json.Unmarshal([]byte(out), &report); err != nil {
+		t.Fatalf("%s in:\n%s", err, out)
+	}
+	if report.Counts.Created != 1 || len(report.Created) != 1 {
+		t.Errorf("got report %+v; want 1 created", report)
+	}
+}
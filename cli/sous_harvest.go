@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"flag"
+	"time"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+)
+
+// SousHarvest is the description of the `sous harvest` command
+type SousHarvest struct {
+	Config       LocalSousConfig
+	DockerClient LocalDockerClient
+	Sink         OutputSink
+	flags        struct {
+		cacheDB, stateDir string
+		timeout           time.Duration
+		parallel          int
+	}
+}
+
+func init() { TopLevelCommands["harvest"] = &SousHarvest{} }
+
+const sousHarvestHelp = `
+fill the image name cache for every service in a state
+
+args: [<dir>]
+
+harvest reads the state in dir and caches the name of every image tagged in
+the docker repos known for each of its manifests, so that later commands
+needn't wait for the registry. It prints the outcome for each manifest as it
+finishes, and then a summary. If any manifest fails, or -timeout passes
+before all are done, the exit code is 74.
+
+A docker repo shared by several manifests, e.g. by the offsets of a
+mono-repo, is only fetched once per run, and each manifest is credited with
+the tags of its own images.
+
+Names are cached in the database given by -cache-db, or your sous
+configuration. A relative -cache-db is taken to be in the state directory, so
+that harvest behaves the same wherever it's run from, e.g. by cron.
+`
+
+// Help prints the help
+func (*SousHarvest) Help() string { return sousHarvestHelp }
+
+// AddFlags adds flags for sous harvest
+func (sh *SousHarvest) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&sh.flags.cacheDB, "cache-db", "",
+		"path to the SQLite name cache, relative to the state directory (default from sous config)")
+	fs.DurationVar(&sh.flags.timeout, "timeout", 0,
+		"give up after this long (default no limit)")
+	fs.IntVar(&sh.flags.parallel, "parallel", 4,
+		"the number of manifests to harvest at once")
+	addStateDirFlag(fs, &sh.flags.stateDir)
+}
+
+// harvested is the outcome of warming the cache for one source location
+type harvested struct {
+	sl     sous.SourceLocation
+	cached int
+	err    error
+}
+
+// Execute defines the behavior of `sous harvest`
+func (sh *SousHarvest) Execute(args []string) cmdr.Result {
+	if len(args) > 1 {
+		return UsageErrorf("sous harvest: at most one state directory allowed")
+	}
+	if sh.flags.parallel < 1 {
+		return UsageErrorf("sous harvest: -parallel must be at least 1")
+	}
+	dir, errResult := stateDir(stateDirArg(args), sh.flags.stateDir)
+	if errResult != nil {
+		return errResult
+	}
+	state, err := sous.LoadState(dir)
+	if err != nil {
+		return stateLoadError(dir, err)
+	}
+
+	driver, conn, errResult := cacheDB(sh.Config.Config, sh.flags.cacheDB, dir)
+	if errResult != nil {
+		return errResult
+	}
+	opts, err := sh.Config.NameCacheOptions()
+	if err != nil {
+		return UsageErrorf("sous harvest: %s", err)
+	}
+	nc := sous.NewNameCacheWithOptions(sh.DockerClient, []string{driver, conn}, opts...)
+	defer nc.Close()
+	// one harvest for the run, so that repos shared between source
+	// locations are only fetched once
+	harvest := nc.NewHarvest()
+
+	sls := state.SourceLocations()
+	// buffered, so that workers finishing after a timeout don't block
+	results := make(chan harvested, len(sls))
+	slots := make(chan struct{}, sh.flags.parallel)
+	for _, sl := range sls {
+		go func(sl sous.SourceLocation) {
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			cached, err := harvest.Warm(sl)
+			results <- harvested{sl, cached, err}
+		}(sl)
+	}
+
+	var timeout <-chan time.Time
+	if sh.flags.timeout > 0 {
+		timeout = time.After(sh.flags.timeout)
+	}
+	warmed, cached, failed := 0, 0, 0
+	for done := 0; done < len(sls); done++ {
+		select {
+		case <-timeout:
+			sh.summarize(warmed, cached, failed)
+			return IOErrorf("sous harvest: timed out after %s with %d of %d manifests done",
+				sh.flags.timeout, done, len(sls))
+		case r := <-results:
+			cached += r.cached
+			if r.err != nil {
+				failed++
+				sh.Sink.Infof("%s: %d tags cached, failed: %s", r.sl, r.cached, r.err)
+				continue
+			}
+			warmed++
+			sh.Sink.Infof("%s: %d tags cached", r.sl, r.cached)
+		}
+	}
+	sh.summarize(warmed, cached, failed)
+	if failed != 0 {
+		return IOErrorf("sous harvest: %d of %d manifests failed", failed, len(sls))
+	}
+	return Success()
+}
+
+func (sh *SousHarvest) summarize(warmed, cached, failed int) {
+	sh.Sink.Infof("%d locations warmed, %d tags cached, %d failures",
+		warmed, cached, failed)
+}
@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+	"github.com/opentable/sous/util/docker_registry"
+	"github.com/samsalisbury/semv"
+)
+
+func TestSousHarvest(t *testing.T) {
+	dir := writeStateDir(t, map[string]string{
+		"defs.yaml": validState["defs.yaml"],
+		"manifests/github.com/opentable/example.yaml": `
+Source: github.com/opentable/example
+Kind: http-service
+`,
+		"manifests/github.com/opentable/unknown.yaml": `
+Source: github.com/opentable/unknown
+Kind: http-service
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	dc := docker_registry.NewDummyClient()
+	db := sous.InMemoryConnection("sousharvest")
+	// Keep a connection open, so the in-memory database survives.
+	nc := sous.NewNameCache(dc, "sqlite3", db)
+	sv := sous.SourceVersion{
+		RepoURL: "github.com/opentable/example",
+		Version: semv.MustParse("1.0.0"),
+	}
+	if err := nc.Insert(sv, "docker.example.com/example:1.0.0", "etag"); err != nil {
+		t.Fatal(err)
+	}
+	next := sv
+	next.Version = semv.MustParse("1.1.0")
+	cn := "docker.example.com/example@sha256:012345678901234567890123456789ab012345678901234567890123456789ab"
+	dc.FeedTags([]string{"1.1.0"})
+	dc.FeedMetadata(docker_registry.Metadata{
+		Labels:        next.DockerLabels(),
+		Etag:          "etag2",
+		CanonicalName: cn,
+		AllNames:      []string{cn, "docker.example.com/example:1.1.0"},
+	})
+
+	out := &bytes.Buffer{}
+	sh := &SousHarvest{
+		Config:       LocalSousConfig{&sous.Config{}},
+		DockerClient: LocalDockerClient{dc},
+		Sink:         testSink(out, false),
+	}
+	sh.flags.cacheDB = db
+	sh.flags.parallel = 2
+	r := sh.Execute([]string{dir})
+
+	if r.ExitCode() != cmdr.EX_IOERR {
+		t.Errorf("got exit code %d (%v); want %d for the unknown manifest", r.ExitCode(), r, cmdr.EX_IOERR)
+	}
+	for _, want := range []string{
+		"github.com/opentable/example: 1 tags cached\n",
+		"github.com/opentable/unknown: 0 tags cached, failed:",
+		"1 locations warmed, 1 tags cached, 1 failures\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output doesn't contain %q:\n%s", want, out)
+		}
+	}
+	if name, err := nc.GetImageName(next); err != nil || name != cn {
+		t.Errorf("got image name %q, %v; want %s", name, err, cn)
+	}
+}
@@ -0,0 +1,206 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+)
+
+// SousHistory is the description of the `sous history` command
+type SousHistory struct {
+	Config       LocalSousConfig
+	DockerClient LocalDockerClient
+	Sink         OutputSink
+	// rc is the client the history is read from, which is a
+	// SingularityClient for the clusters in the state unless set by tests
+	rc    sous.RectificationClient
+	flags struct {
+		cluster, stateDir string
+		count             int
+	}
+}
+
+func init() { TopLevelCommands["history"] = &SousHistory{} }
+
+const sousHistoryHelp = `
+list the recent deploys of a service
+
+args: <source location>
+
+history prints the most recent deploys of the service built from source
+location, such as github.com/opentable/sous, on the cluster named by
+-cluster, newest first: when each was made and by whom, the version it
+deployed, and how it turned out. Images that sous didn't build, or that
+aren't in the name cache, are shown by their image names instead of a
+version.
+
+With the global -json flag, the deploys are printed as a JSON array instead.
+
+The cluster and manifest are found in the state directory given by
+-state-dir, $SOUS_STATE_DIR, or the nearest directory above the working
+directory containing defs.yaml or .sous-state. A service with no manifest,
+e.g. one that has been removed, is still found by its source location.
+`
+
+// Help prints the help
+func (*SousHistory) Help() string { return sousHistoryHelp }
+
+// AddFlags adds flags for sous history
+func (sh *SousHistory) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&sh.flags.cluster, "cluster", "",
+		"the cluster to list deploys on (required)")
+	fs.IntVar(&sh.flags.count, "count", 5,
+		"how many deploys to list")
+	addStateDirFlag(fs, &sh.flags.stateDir)
+}
+
+// historyRecord is the JSON output of `sous history`
+type historyRecord struct {
+	Cluster       string    `json:"cluster"`
+	RequestID     string    `json:"requestId"`
+	DeployID      string    `json:"deployId"`
+	Time          time.Time `json:"time"`
+	User          string    `json:"user,omitempty"`
+	Message       string    `json:"message,omitempty"`
+	Result        string    `json:"result,omitempty"`
+	ResultMessage string    `json:"resultMessage,omitempty"`
+	Image         string    `json:"image"`
+	// Source and Version are empty if the image couldn't be resolved
+	Source  string `json:"source,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+// Execute defines the behavior of `sous history`
+func (sh *SousHistory) Execute(args []string) cmdr.Result {
+	if len(args) != 1 {
+		return UsageErrorf("sous history: source location required")
+	}
+	sl, err := sous.ParseCanonicalName(args[0])
+	if err != nil {
+		return UsageErrorf("sous history: %s", err)
+	}
+	if sh.flags.cluster == "" {
+		return UsageErrorf("sous history: -cluster required")
+	}
+	if sh.flags.count <= 0 {
+		return UsageErrorf("sous history: -count must be positive, not %d", sh.flags.count)
+	}
+
+	dir, errResult := stateDir("", sh.flags.stateDir)
+	if errResult != nil {
+		return errResult
+	}
+	state, err := sous.LoadState(dir)
+	if err != nil {
+		return stateLoadError(dir, err)
+	}
+	cluster, ok := state.Defs.Clusters[sh.flags.cluster]
+	if !ok {
+		return UsageErrorf("sous history: no cluster named %q is defined", sh.flags.cluster)
+	}
+	reqID, err := historyRequestID(&state, sl, sous.ClusterName(cluster.BaseURL))
+	if err != nil {
+		return DataErrorf("invalid manifest for %s: %s", sl, err)
+	}
+
+	rc := sh.rc
+	if rc == nil {
+		nc, err := configNameCache(sh.Config.Config, sh.DockerClient)
+		if err != nil {
+			return EnsureErrorResult(err)
+		}
+		defer nc.Close()
+		rc = sous.NewSingularityClient(state.Defs.ClusterURLs(), nc)
+	}
+	rs, err := rc.DeployHistory(sous.ClusterName(cluster.BaseURL), reqID, sh.flags.count)
+	if err != nil {
+		return IOErrorf("unable to read the deploys of %s on %s: %s", sl, sh.flags.cluster, err)
+	}
+
+	records := make([]historyRecord, len(rs))
+	for i, r := range rs {
+		records[i] = historyRecord{
+			Cluster:       sh.flags.cluster,
+			RequestID:     r.RequestID,
+			DeployID:      r.DeployID,
+			Time:          r.Time,
+			User:          r.User,
+			Message:       r.Message,
+			Result:        r.Result,
+			ResultMessage: r.ResultMessage,
+			Image:         r.ImageName,
+		}
+		if sv := r.SourceVersion; sv != nil {
+			records[i].Source = sv.CanonicalName().String()
+			records[i].Version = sv.Version.String()
+		}
+	}
+
+	if errResult := sh.Sink.Result(records, func(out io.Writer) {
+		if len(records) == 0 {
+			return
+		}
+		w := &tabwriter.Writer{}
+		w.Init(out, 2, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "Time\tDeploy ID\tVersion\tUser\tResult\tMessage")
+		for _, r := range records {
+			version := r.Version
+			if version == "" {
+				version = r.Image
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Time.Format(time.RFC3339), r.DeployID, version,
+				orDash(r.User), orDash(r.Result), orDash(historyMessage(r)))
+		}
+		w.Flush()
+	}); errResult != nil {
+		return errResult
+	}
+	if len(records) == 0 {
+		sh.Sink.Infof("no deploys of %s on %s", sl, sh.flags.cluster)
+	}
+	return Success()
+}
+
+// historyRequestID returns the ID of the request sl is deployed as on
+// cluster: the one its manifest gives it, if it has one, or else the
+// default for its source location.
+func historyRequestID(state *sous.State, sl sous.SourceLocation, cluster sous.ClusterName) (string, error) {
+	if _, m, ok := state.ManifestFor(sl); ok {
+		ds, err := state.DeploymentsFromManifest(m)
+		if err != nil {
+			return "", err
+		}
+		for _, d := range ds {
+			if d.Cluster == cluster {
+				return sous.ComputeRequestID(d), nil
+			}
+		}
+	}
+	return sous.ComputeRequestID(&sous.Deployment{
+		SourceVersion: sous.SourceVersion{RepoURL: sl.RepoURL, RepoOffset: sl.RepoOffset},
+	}), nil
+}
+
+// historyMessage joins the message a deploy was made with and the one
+// explaining its result.
+func historyMessage(r historyRecord) string {
+	switch {
+	case r.ResultMessage == "":
+		return r.Message
+	case r.Message == "":
+		return r.ResultMessage
+	}
+	return r.Message + "; " + r.ResultMessage
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
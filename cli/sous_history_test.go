@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+	"github.com/samsalisbury/semv"
+)
+
+// runHistory runs sous history for args against a DummyRectificationClient
+// on which github.com/opentable/one has been deployed twice on us-west, the
+// second time with an image sous didn't build.
+func runHistory(t *testing.T, setFlags func(sh *SousHistory), args ...string) (string, cmdr.Result) {
+	dir := writeStateDir(t, scaleState)
+	defer os.RemoveAll(dir)
+
+	rc := sous.NewDummyRectificationClient(sous.NewDummyNameCache())
+	cluster := sous.ClusterName("http://singularity.example.com")
+	d := &sous.Deployment{
+		SourceVersion: sous.SourceVersion{RepoURL: "github.com/opentable/one", Version: semv.MustParse("1.0.0")},
+		Cluster:       cluster,
+	}
+	name, err := rc.ImageName(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Deploy(cluster, "first", "github.comopentableone", name, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Deploy(cluster, "second", "github.comopentableone", "docker.example.com/hotfix:latest", nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	sh := &SousHistory{Sink: testSink(out, false), rc: rc}
+	sh.flags.stateDir = dir
+	sh.flags.count = 5
+	sh.flags.cluster = "us-west"
+	setFlags(sh)
+	r := sh.Execute(args)
+	return out.String(), r
+}
+
+func TestSousHistory(t *testing.T) {
+	out, r := runHistory(t, func(*SousHistory) {}, "github.com/opentable/one")
+	if _, ok := r.(cmdr.SuccessResult); !ok {
+		t.Fatalf("got %T %v; want success", r, r)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got:\n%s\nwant a header and 2 deploys", out)
+	}
+	for i, want := range [][]string{
+		{"Time", "Deploy ID", "Version", "User", "Result", "Message"},
+		{"second", "docker.example.com/hotfix:latest", "SUCCEEDED"},
+		{"first", "1.0.0", "SUCCEEDED"},
+	} {
+		for _, w := range want {
+			if !strings.Contains(lines[i], w) {
+				t.Errorf("got line %q; want it to contain %q", lines[i], w)
+			}
+		}
+	}
+}
+
+func TestSousHistory_JSON(t *testing.T) {
+	out, r := runHistory(t, func(sh *SousHistory) {
+		sh.Sink.JSON = true
+		sh.flags.count = 1
+	}, "github.com/opentable/one")
+	if _, ok := r.(cmdr.SuccessResult); !ok {
+		t.Fatalf("got %T %v; want success", r, r)
+	}
+	rs := []historyRecord{}
+	if err := json.Unmarshal([]byte(out), &rs); err != nil {
+		t.Fatalf("%s in:\n%s", err, out)
+	}
+	if len(rs) != 1 || rs[0].DeployID != "second" || rs[0].Cluster != "us-west" ||
+		rs[0].Image != "docker.example.com/hotfix:latest" || rs[0].Version != "" {
+		t.Errorf("got %+v", rs)
+	}
+}
+
+func TestSousHistory_NoDeploys(t *testing.T) {
+	out, r := runHistory(t, func(*SousHistory) {}, "github.com/opentable/gone")
+	if r.ExitCode() != 0 {
+		t.Errorf("got exit code %d; want 0", r.ExitCode())
+	}
+	if out != "no deploys of github.com/opentable/gone on us-west\n" {
+		t.Errorf("got:\n%s", out)
+	}
+}
+
+func TestSousHistory_UsageErrors(t *testing.T) {
+	for _, c := range []struct {
+		setFlags func(*SousHistory)
+		args     []string
+	}{
+		{func(*SousHistory) {}, nil},
+		{func(sh *SousHistory) { sh.flags.cluster = "" }, []string{"github.com/opentable/one"}},
+		{func(sh *SousHistory) { sh.flags.cluster = "mars" }, []string{"github.com/opentable/one"}},
+		{func(sh *SousHistory) { sh.flags.count = 0 }, []string{"github.com/opentable/one"}},
+	} {
+		_, r := runHistory(t, c.setFlags, c.args...)
+		if _, ok := r.(cmdr.UsageErr); !ok {
+			t.Errorf("%v: got %T %v; want a usage error", c.args, r, r)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+)
+
+// SousImage is the description of the `sous image` command
+type SousImage struct {
+	Config       LocalSousConfig
+	DockerClient LocalDockerClient
+	Sink         OutputSink
+	flags        struct {
+		resolve, all bool
+		db           string
+	}
+}
+
+func init() { TopLevelCommands["image"] = &SousImage{} }
+
+const sousImageHelp = `
+look up the docker image for a source version
+
+args: <source version>
+
+image prints the canonical name of the docker image built from a source
+version, such as github.com/opentable/sous,1.0.0. With -all it also prints
+every other name of that image. With -resolve the argument is an image name,
+and image prints the source version it was built from instead.
+
+Names are cached in the database given by -db, or your sous configuration;
+images not yet in the cache are looked up in their registry. If no image is
+found the exit code is 1.
+`
+
+// Help prints the help
+func (*SousImage) Help() string { return sousImageHelp }
+
+func (si *SousImage) AddFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&si.flags.resolve, "resolve", false,
+		"resolve an image name to its source version")
+	fs.BoolVar(&si.flags.all, "all", false,
+		"print all names of the image")
+	fs.StringVar(&si.flags.db, "db", "",
+		"path to the SQLite name cache (default from sous config)")
+}
+
+// imageResult is the JSON output of `sous image`
+type imageResult struct {
+	SourceVersion string   `json:"sourceVersion"`
+	ImageName     string   `json:"imageName"`
+	Aliases       []string `json:"aliases,omitempty"`
+}
+
+// Execute defines the behavior of `sous image`
+func (si *SousImage) Execute(args []string) cmdr.Result {
+	if len(args) != 1 {
+		return UsageErrorf("sous image: exactly one source version or image name required")
+	}
+	driver, conn := si.Config.DatabaseDriver, si.Config.DatabaseConnection
+	if si.flags.db != "" {
+		driver, conn = "sqlite3", si.flags.db
+	}
+	nc := sous.NewNameCache(si.DockerClient, driver, conn)
+	defer nc.Close()
+
+	var result imageResult
+	if si.flags.resolve {
+		in := args[0]
+		sv, err := nc.GetSourceVersion(in)
+		if err != nil {
+			return imageLookupError(in, err)
+		}
+		cn, err := nc.GetCanonicalName(in)
+		if err != nil {
+			return imageLookupError(in, err)
+		}
+		result = imageResult{SourceVersion: sv.String(), ImageName: cn}
+	} else {
+		sv, err := sous.ParseSourceVersion(args[0])
+		if err != nil {
+			return UsageErrorf("sous image: %s", err)
+		}
+		cn, names, err := nc.GetImageNames(sv)
+		if err != nil {
+			return imageLookupError(sv.String(), err)
+		}
+		result = imageResult{SourceVersion: sv.String(), ImageName: cn}
+		for _, n := range names {
+			if n != cn {
+				result.Aliases = append(result.Aliases, n)
+			}
+		}
+	}
+
+	if !si.flags.all {
+		result.Aliases = nil
+	}
+	errResult := si.Sink.Result(result, func(w io.Writer) {
+		switch {
+		case si.flags.resolve:
+			fmt.Fprintln(w, result.SourceVersion)
+		case si.flags.all:
+			fmt.Fprintln(w, strings.Join(append([]string{result.ImageName}, result.Aliases...), "\n"))
+		default:
+			fmt.Fprintln(w, result.ImageName)
+		}
+	})
+	if errResult != nil {
+		return errResult
+	}
+	return Success()
+}
+
+// imageLookupError reports err from looking up what. Registries that could
+// not be reached are IO errors; anything else means there is no such image.
+func imageLookupError(what string, err error) cmdr.ErrorResult {
+	if _, ok := err.(sous.RegistryUnavailable); ok {
+		return IOErrorf("%s", err)
+	}
+	return FailureErrorf("%s not found: %s", what, err)
+}
@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+	"github.com/opentable/sous/util/docker_registry"
+	"github.com/samsalisbury/semv"
+)
+
+func newTestSousImage(t *testing.T, db string) (*SousImage, *docker_registry.DummyRegistryClient, *bytes.Buffer) {
+	dc := docker_registry.NewDummyClient()
+	out := &bytes.Buffer{}
+	si := &SousImage{
+		Sink: testSink(out, false),
+		Config: LocalSousConfig{&sous.Config{
+			DatabaseDriver:     "sqlite3",
+			DatabaseConnection: sous.InMemoryConnection(db),
+		}},
+		DockerClient: LocalDockerClient{dc},
+	}
+	return si, dc, out
+}
+
+func TestSousImage(t *testing.T) {
+	si, dc, out := newTestSousImage(t, "sousimage")
+	// Keep a connection open, so the in-memory database survives.
+	nc := sous.NewNameCache(dc, "sqlite3", sous.InMemoryConnection("sousimage"))
+	sv := sous.SourceVersion{
+		RepoURL: "github.com/opentable/example",
+		Version: semv.MustParse("2.3.1"),
+	}
+	in := "docker.example.com/example:2.3.1"
+	if err := nc.Insert(sv, in, "etag"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := si.Execute([]string{"github.com/opentable/example,2.3.1"})
+	if _, ok := r.(cmdr.SuccessResult); !ok || out.String() != in+"\n" {
+		t.Errorf("got %T %v, output %q; want %s", r, r, out, in)
+	}
+
+	out.Reset()
+	si.Sink.JSON = true
+	r = si.Execute([]string{"github.com/opentable/example,2.3.1"})
+	if _, ok := r.(cmdr.SuccessResult); !ok {
+		t.Fatalf("got %T %v; want success", r, r)
+	}
+	got := imageResult{}
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ImageName != in || got.SourceVersion != sv.String() {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestSousImage_NotFound(t *testing.T) {
+	si, _, _ := newTestSousImage(t, "sousimage-notfound")
+	r := si.Execute([]string{"github.com/opentable/missing,1.0.0"})
+	if r.ExitCode() != 1 {
+		t.Errorf("got exit code %d (%v); want 1", r.ExitCode(), r)
+	}
+	if r := si.Execute([]string{"not a source version"}); r.ExitCode() != cmdr.EX_USAGE {
+		t.Errorf("got exit code %d (%v); want %d", r.ExitCode(), r, cmdr.EX_USAGE)
+	}
+}
+
+func TestSousImage_Resolve(t *testing.T) {
+	si, dc, out := newTestSousImage(t, "sousimage-resolve")
+	sv := sous.SourceVersion{
+		RepoURL: "github.com/opentable/example",
+		Version: semv.MustParse("2.3.1"),
+	}
+	in := "docker.example.com/example:2.3.1"
+	cn := "docker.example.com/example@sha256:012345678901234567890123456789ab012345678901234567890123456789ab"
+	dc.FeedMetadata(docker_registry.Metadata{
+		Labels:        sv.DockerLabels(),
+		Etag:          "etag",
+		CanonicalName: cn,
+		AllNames:      []string{cn, in},
+	})
+
+	si.flags.resolve = true
+	r := si.Execute([]string{in})
+	if _, ok := r.(cmdr.SuccessResult); !ok || out.String() != sv.String()+"\n" {
+		t.Errorf("got %T %v, output %q; want %s", r, r, out, sv)
+	}
+}
@@ -41,7 +41,7 @@ func (si *SousInit) Execute(args []string) cmdr.Result {
 			RepoOffset: sous.RepoOffset(c.OffsetDir),
 		},
 		Deployments: map[string]sous.PartialDeploySpec{
-			"Global": sous.PartialDeploySpec{
+			sous.GlobalDeploySpec: sous.PartialDeploySpec{
 				DeployConfig: sous.DeployConfig{
 					Resources:    sous.Resources{},
 					Env:          map[string]string{},
@@ -0,0 +1,167 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+)
+
+// SousLabels is the description of the `sous labels` command
+type SousLabels struct {
+	DockerClient LocalDockerClient
+	WDShell      LocalWorkDirShell
+	Sink         OutputSink
+	flags        struct {
+		source, format, check string
+	}
+}
+
+func init() { TopLevelCommands["labels"] = &SousLabels{} }
+
+const sousLabelsHelp = `
+print the docker labels for the current build
+
+args:
+
+labels prints the docker labels that identify an image built from the source
+version in the working directory's git repository, or from the source version
+given by -source, such as github.com/opentable/sous,1.0.0. By default they are
+printed as --label arguments for docker build, e.g.
+
+  docker build $(sous labels) .
+
+-format env prints them as key=value lines instead, and -format json (or the
+global -json flag) as a JSON object.
+
+With -check <image>, labels looks up the labels of an image already in its
+registry instead, and exits with code 1 if they don't match.
+`
+
+// Help prints the help
+func (*SousLabels) Help() string { return sousLabelsHelp }
+
+// AddFlags adds flags for sous labels
+func (sl *SousLabels) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&sl.flags.source, "source", "",
+		"the source version to label, instead of the working copy's")
+	fs.StringVar(&sl.flags.format, "format", "args",
+		"output format: args, env or json")
+	fs.StringVar(&sl.flags.check, "check", "",
+		"check the labels of this image rather than printing them")
+}
+
+// Execute defines the behavior of `sous labels`
+func (sl *SousLabels) Execute(args []string) cmdr.Result {
+	if len(args) != 0 {
+		return UsageErrorf("sous labels: takes no arguments; use -source to name a source version")
+	}
+	write := labelFormats[sl.flags.format]
+	if write == nil && sl.flags.format != "json" {
+		return UsageErrorf("sous labels: unknown format %q; want args, env or json", sl.flags.format)
+	}
+	sv, errResult := sl.sourceVersion()
+	if errResult != nil {
+		return errResult
+	}
+	labels := sv.DockerLabels()
+
+	if sl.flags.check != "" {
+		return sl.checkImage(sl.flags.check, labels)
+	}
+	if sl.flags.format == "json" {
+		sl.Sink.JSON = true
+	}
+	if errResult := sl.Sink.Result(labels, func(w io.Writer) { write(w, labels) }); errResult != nil {
+		return errResult
+	}
+	return Success()
+}
+
+// sourceVersion is the source version given by -source, or otherwise that
+// of the working directory.
+func (sl *SousLabels) sourceVersion() (sous.SourceVersion, cmdr.ErrorResult) {
+	if sl.flags.source != "" {
+		sv, err := sous.ParseSourceVersion(sl.flags.source)
+		if err != nil {
+			return sv, UsageErrorf("sous labels: bad -source: %s", err)
+		}
+		return sv, nil
+	}
+	// The git context is only built here, so that -source works outside a
+	// repository.
+	ctx, err := workDirSourceContext(sl.WDShell)
+	if err != nil {
+		err := UsageErrorf("%s", err)
+		err.Tip = "run labels in a git repository, or use -source"
+		return sous.SourceVersion{}, err
+	}
+	return ctx.Version(), nil
+}
+
+// workDirSourceContext builds the source context of the git repository sh
+// is in, as the graph would.
+func workDirSourceContext(sh LocalWorkDirShell) (*sous.SourceContext, error) {
+	gc, err := newLocalGitClient(sh)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := newLocalGitRepo(gc)
+	if err != nil {
+		return nil, err
+	}
+	return newSourceContext(repo)
+}
+
+// checkImage compares the labels of the image in with want, and fails
+// listing each label that differs.
+func (sl *SousLabels) checkImage(in string, want map[string]string) cmdr.Result {
+	got, err := sl.DockerClient.LabelsForImageName(in)
+	if err != nil {
+		return IOErrorf("unable to get labels for %s: %s", in, err)
+	}
+	problems := []string{}
+	for _, k := range sortedLabelKeys(want) {
+		g, ok := got[k]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s: missing, want %q", k, want[k]))
+		case g != want[k]:
+			problems = append(problems, fmt.Sprintf("%s: %q, want %q", k, g, want[k]))
+		}
+	}
+	if len(problems) != 0 {
+		return FailureErrorf("labels of %s don't match:\n  %s", in, strings.Join(problems, "\n  "))
+	}
+	sl.Sink.Infof("labels of %s match", in)
+	return Success()
+}
+
+// labelFormats write labels in each of the text formats.
+var labelFormats = map[string]func(io.Writer, map[string]string){
+	"args": func(w io.Writer, labels map[string]string) {
+		args := []string{}
+		for _, k := range sortedLabelKeys(labels) {
+			args = append(args, fmt.Sprintf("--label %s=%s", k, labels[k]))
+		}
+		fmt.Fprintln(w, strings.Join(args, " "))
+	},
+	"env": func(w io.Writer, labels map[string]string) {
+		for _, k := range sortedLabelKeys(labels) {
+			fmt.Fprintf(w, "%s=%s\n", k, labels[k])
+		}
+	},
+}
+
+func sortedLabelKeys(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
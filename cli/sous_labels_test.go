@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+	"github.com/opentable/sous/util/docker_registry"
+)
+
+const labelsSource = "github.com/opentable/example,1.2.3+abc123"
+
+func TestSousLabels_Formats(t *testing.T) {
+	for format, want := range map[string]string{
+		"args": "--label com.opentable.sous.repo_offset= " +
+			"--label com.opentable.sous.repo_url=github.com/opentable/example " +
+			"--label com.opentable.sous.revision=abc123 " +
+			"--label com.opentable.sous.version=1.2.3\n",
+		"env": "com.opentable.sous.repo_offset=\n" +
+			"com.opentable.sous.repo_url=github.com/opentable/example\n" +
+			"com.opentable.sous.revision=abc123\n" +
+			"com.opentable.sous.version=1.2.3\n",
+	} {
+		out := &bytes.Buffer{}
+		sl := &SousLabels{Sink: testSink(out, false)}
+		sl.flags.source = labelsSource
+		sl.flags.format = format
+		if r := sl.Execute(nil); r.ExitCode() != 0 {
+			t.Fatalf("%s: got %T %v; want success", format, r, r)
+		}
+		if out.String() != want {
+			t.Errorf("%s: got %q; want %q", format, out, want)
+		}
+	}
+
+	out := &bytes.Buffer{}
+	sl := &SousLabels{Sink: testSink(out, false)}
+	sl.flags.source = labelsSource
+	sl.flags.format = "json"
+	if r := sl.Execute(nil); r.ExitCode() != 0 {
+		t.Fatalf("json: got %T %v; want success", r, r)
+	}
+	labels := map[string]string{}
+	if err := json.Unmarshal(out.Bytes(), &labels); err != nil {
+		t.Fatalf("%s in:\n%s", err, out)
+	}
+	if labels[sous.DockerRevisionLabel] != "abc123" {
+		t.Errorf("got labels %v", labels)
+	}
+}
+
+func TestSousLabels_Check(t *testing.T) {
+	sv, err := sous.ParseSourceVersion(labelsSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc := docker_registry.NewDummyClient()
+	sl := &SousLabels{
+		DockerClient: LocalDockerClient{dc},
+		Sink:         testSink(&bytes.Buffer{}, false),
+	}
+	sl.flags.source = labelsSource
+	sl.flags.format = "args"
+	sl.flags.check = "docker.example.com/example:1.2.3"
+
+	dc.FeedMetadata(docker_registry.Metadata{Labels: sv.DockerLabels()})
+	if r := sl.Execute(nil); r.ExitCode() != 0 {
+		t.Errorf("got %T %v; want success for matching labels", r, r)
+	}
+
+	labels := sv.DockerLabels()
+	labels[sous.DockerRevisionLabel] = "def456"
+	dc.FeedMetadata(docker_registry.Metadata{Labels: labels})
+	r := sl.Execute(nil)
+	if _, ok := r.(cmdr.FailureErr); !ok {
+		t.Errorf("got %T %v; want a failure for a mismatched revision", r, r)
+	}
+}
@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+)
+
+// SousPreviewGC is the description of the `sous preview-gc` command
+type SousPreviewGC struct {
+	Config       LocalSousConfig
+	DockerClient LocalDockerClient
+	Sink         OutputSink
+	// rc is the client expired previews are deleted with, which is a
+	// SingularityClient for the clusters they run on unless set by tests
+	rc    sous.RectificationClient
+	flags struct {
+		dryRun bool
+	}
+}
+
+func init() { TopLevelCommands["preview-gc"] = &SousPreviewGC{} }
+
+const sousPreviewGCHelp = `
+delete the previews that have expired
+
+preview-gc lists the previews deployed by sous deploy-preview that haven't
+been deleted yet, from the file named by PreviewLedger in your config, and
+deletes the requests of those whose time is up. With -dry-run, nothing is
+deleted.
+
+With the global -json flag, the previews are printed as a JSON array instead.
+`
+
+// Help prints the help
+func (*SousPreviewGC) Help() string { return sousPreviewGCHelp }
+
+// AddFlags adds flags for sous preview-gc
+func (sp *SousPreviewGC) AddFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&sp.flags.dryRun, "dry-run", false,
+		"list the previews without deleting any")
+}
+
+// previewRecord is the JSON output of `sous preview-gc`
+type previewRecord struct {
+	sous.Preview
+	// Status is "active", "expired" or "deleted".
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Execute defines the behavior of `sous preview-gc`
+func (sp *SousPreviewGC) Execute(args []string) cmdr.Result {
+	if len(args) != 0 {
+		return UsageErrorf("sous preview-gc: no arguments expected")
+	}
+	if sp.Config.PreviewLedger == "" {
+		return UsageErrorf("sous preview-gc: PreviewLedger isn't set in your config")
+	}
+	ledger := sous.NewPreviewLedger(sp.Config.PreviewLedger)
+	ps, err := ledger.Previews()
+	if err != nil {
+		return IOErrorf("unable to read previews: %s", err)
+	}
+
+	rc := sp.rc
+	if rc == nil {
+		nc, err := configNameCache(sp.Config.Config, sp.DockerClient)
+		if err != nil {
+			return EnsureErrorResult(err)
+		}
+		defer nc.Close()
+		urls := map[string]string{}
+		for _, p := range ps {
+			urls[p.Cluster] = p.BaseURL
+		}
+		rc = sous.NewSingularityClient(urls, nc)
+	}
+
+	now := time.Now()
+	records := make([]previewRecord, len(ps))
+	failed := 0
+	for i, p := range ps {
+		records[i] = previewRecord{Preview: p, Status: "active"}
+		if !p.Expires.Before(now) {
+			continue
+		}
+		records[i].Status = "expired"
+		if sp.flags.dryRun {
+			continue
+		}
+		if err := sous.DeletePreview(rc, p, ledger); err != nil {
+			records[i].Error = err.Error()
+			failed++
+			continue
+		}
+		records[i].Status = "deleted"
+	}
+
+	if errResult := sp.Sink.Result(records, func(out io.Writer) {
+		if len(records) == 0 {
+			return
+		}
+		w := &tabwriter.Writer{}
+		w.Init(out, 2, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "Request ID\tCluster\tSource Version\tExpires\tStatus")
+		for _, r := range records {
+			status := r.Status
+			if r.Error != "" {
+				status += ": " + r.Error
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.RequestID, r.Cluster, r.SourceVersion,
+				r.Expires.Local().Format(time.RFC3339), status)
+		}
+		w.Flush()
+	}); errResult != nil {
+		return errResult
+	}
+	if len(records) == 0 {
+		sp.Sink.Infof("no previews")
+	}
+	if failed > 0 {
+		return IOErrorf("unable to delete %d expired previews", failed)
+	}
+	return Success()
+}
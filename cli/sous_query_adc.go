@@ -1,9 +1,8 @@
 package cli
 
 import (
-	"fmt"
-	"os"
-	"text/tabwriter"
+	"flag"
+	"io"
 
 	"github.com/opentable/sous/lib"
 	"github.com/opentable/sous/util/cmdr"
@@ -14,9 +13,11 @@ type SousQueryAdc struct {
 	Config       LocalSousConfig
 	DockerClient LocalDockerClient
 	Sous         *Sous
+	Sink         OutputSink
 	flags        struct {
 		singularity string
 		registry    string
+		stateDir    string
 	}
 }
 
@@ -31,22 +32,31 @@ This should resemble the manifest that was used to establish the current state o
 // Help prints the help
 func (*SousQueryAdc) Help() string { return sousBuildHelp }
 
+// AddFlags adds flags for sous query adc
+func (sb *SousQueryAdc) AddFlags(fs *flag.FlagSet) {
+	addStateDirFlag(fs, &sb.flags.stateDir)
+}
+
 // Execute defines the behavior of `sous query adc`
 func (sb *SousQueryAdc) Execute(args []string) cmdr.Result {
-	if len(args) < 1 {
-		return UsageErrorf("sous querry adc: directory to load deployment configuration required")
+	if len(args) > 1 {
+		return UsageErrorf("sous query adc: at most one state directory allowed")
+	}
+	dir, errResult := stateDir(stateDirArg(args), sb.flags.stateDir)
+	if errResult != nil {
+		return errResult
 	}
-	dir := args[0]
 
 	state, err := sous.LoadState(dir)
 	if err != nil {
 		return EnsureErrorResult(err)
 	}
 
-	nc := sous.NewNameCache(
-		sb.DockerClient,
-		sb.Config.DatabaseDriver,
-		sb.Config.DatabaseConnection)
+	nc, err := configNameCache(sb.Config.Config, sb.DockerClient)
+	if err != nil {
+		return EnsureErrorResult(err)
+	}
+	defer nc.Close()
 	ra := sous.NewRectiAgent(nc)
 	sc := sous.NewSetCollector(ra)
 	ads, err := sc.GetRunningDeployment(state.BaseURLs())
@@ -54,14 +64,11 @@ func (sb *SousQueryAdc) Execute(args []string) cmdr.Result {
 		return EnsureErrorResult(err)
 	}
 
-	w := &tabwriter.Writer{}
-	w.Init(os.Stdout, 2, 4, 2, ' ', 0)
-	fmt.Fprintln(w, sous.TabbedDeploymentHeaders())
-
-	for _, d := range ads {
-		fmt.Fprintln(w, d.Tabbed())
+	if errResult := sb.Sink.Result(ads, func(w io.Writer) {
+		printDeployments(w, sb.Sink.renderer(), ads)
+	}); errResult != nil {
+		return errResult
 	}
-	w.Flush()
 
 	return Success()
 }
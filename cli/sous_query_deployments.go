@@ -0,0 +1,196 @@
+package cli
+
+import (
+	"flag"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+	"github.com/samsalisbury/semv"
+)
+
+// SousQueryDeployments is the description of the `sous query deployments`
+// command
+type SousQueryDeployments struct {
+	Config       LocalSousConfig
+	DockerClient LocalDockerClient
+	Sink         OutputSink
+	// images resolves -image, and is a NameCache unless set by tests
+	images sous.ImageMapper
+	flags  struct {
+		repo, offset, cluster string
+		versionConstraint     string
+		image, cacheDB        string
+		stateDir              string
+	}
+}
+
+func init() { QuerySubcommands["deployments"] = &SousQueryDeployments{} }
+
+const sousQueryDeploymentsHelp = `
+search the deployments described by a state
+
+args: [<dir>]
+
+deployments prints the cluster, source location, version and instance count
+of each deployment in the state that matches all of the filters given, e.g.
+-repo github.com/opentable/sous -version-constraint ">=1.2.0". -image finds
+the deployments of the source version an image was built from, which is
+looked up in the name cache given by -cache-db, or your sous configuration.
+
+With the global -json flag, the matches are printed as a JSON array instead.
+If nothing matches, "no matches" is printed and the exit code is still 0. A
+filter that can't be understood, like an unknown cluster or unparseable
+version constraint, is a usage error.
+`
+
+// Help prints the help
+func (*SousQueryDeployments) Help() string { return sousQueryDeploymentsHelp }
+
+// AddFlags adds flags for sous query deployments
+func (sq *SousQueryDeployments) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&sq.flags.repo, "repo", "",
+		"only deployments built from this repo")
+	fs.StringVar(&sq.flags.offset, "offset", "",
+		"only deployments built from this offset within their repo")
+	fs.StringVar(&sq.flags.cluster, "cluster", "",
+		"only deployments to the named cluster")
+	fs.StringVar(&sq.flags.versionConstraint, "version-constraint", "",
+		"only deployments whose version is in this range, e.g. ^1.2.0 or <2.0.0")
+	fs.StringVar(&sq.flags.image, "image", "",
+		"only deployments of the source version this image was built from")
+	fs.StringVar(&sq.flags.cacheDB, "cache-db", "",
+		"path to the SQLite name cache used by -image, relative to the state directory (default from sous config)")
+	addStateDirFlag(fs, &sq.flags.stateDir)
+}
+
+// queryMatch is the JSON output of `sous query deployments`
+type queryMatch struct {
+	Cluster      string `json:"cluster"`
+	Source       string `json:"source"`
+	Version      string `json:"version"`
+	NumInstances int    `json:"numInstances"`
+}
+
+// Execute defines the behavior of `sous query deployments`
+func (sq *SousQueryDeployments) Execute(args []string) cmdr.Result {
+	if len(args) > 1 {
+		return UsageErrorf("sous query deployments: at most one state directory allowed")
+	}
+	dir, errResult := stateDir(stateDirArg(args), sq.flags.stateDir)
+	if errResult != nil {
+		return errResult
+	}
+	state, err := sous.LoadState(dir)
+	if err != nil {
+		return stateLoadError(dir, err)
+	}
+	predicate, errResult := sq.predicate(&state, dir)
+	if errResult != nil {
+		return errResult
+	}
+	ds, err := state.Deployments()
+	if err != nil {
+		return DataErrorf("invalid state in %s: %s", dir, err)
+	}
+
+	matches := []queryMatch{}
+	for _, d := range ds.Filter(predicate) {
+		cluster, _, _ := state.Defs.Clusters.Lookup(d.Cluster)
+		matches = append(matches, queryMatch{
+			Cluster:      cluster,
+			Source:       d.SourceVersion.CanonicalName().String(),
+			Version:      d.SourceVersion.Version.String(),
+			NumInstances: d.NumInstances,
+		})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Source != matches[j].Source {
+			return matches[i].Source < matches[j].Source
+		}
+		return matches[i].Cluster < matches[j].Cluster
+	})
+
+	if errResult := sq.Sink.Result(matches, func(out io.Writer) {
+		if len(matches) == 0 {
+			return
+		}
+		t := table{header: []string{"Cluster", "Source", "Version", "Instances"}}
+		for _, m := range matches {
+			t.rows = append(t.rows, []cell{plain(m.Cluster), plain(m.Source), plain(m.Version),
+				plain(strconv.Itoa(m.NumInstances))})
+		}
+		sq.Sink.renderer().render(out, t)
+	}); errResult != nil {
+		return errResult
+	}
+	if len(matches) == 0 {
+		sq.Sink.Infof("no matches")
+	}
+	return Success()
+}
+
+// predicate builds a predicate matching the deployments selected by the
+// filter flags, for the state read from dir.
+func (sq *SousQueryDeployments) predicate(state *sous.State, dir string) (sous.DeploymentPredicate, cmdr.ErrorResult) {
+	ps := []sous.DeploymentPredicate{}
+	if sq.flags.repo != "" {
+		repo := sous.RepoURL(sq.flags.repo)
+		ps = append(ps, func(d *sous.Deployment) bool {
+			return d.SourceVersion.RepoURL == repo
+		})
+	}
+	if sq.flags.offset != "" {
+		offset := sous.RepoOffset(sq.flags.offset)
+		ps = append(ps, func(d *sous.Deployment) bool {
+			return d.SourceVersion.RepoOffset == offset
+		})
+	}
+	if sq.flags.cluster != "" {
+		c, ok := state.Defs.Clusters[sq.flags.cluster]
+		if !ok {
+			return nil, UsageErrorf("sous query deployments: no cluster named %q is defined",
+				sq.flags.cluster)
+		}
+		ps = append(ps, func(d *sous.Deployment) bool {
+			return d.Cluster == sous.ClusterName(c.BaseURL)
+		})
+	}
+	if sq.flags.versionConstraint != "" {
+		r, err := semv.ParseRange(sq.flags.versionConstraint)
+		if err != nil {
+			return nil, UsageErrorf("sous query deployments: bad -version-constraint: %s", err)
+		}
+		ps = append(ps, func(d *sous.Deployment) bool {
+			return r.SatisfiedBy(d.SourceVersion.Version)
+		})
+	}
+	if sq.flags.image != "" {
+		images := sq.images
+		if images == nil {
+			driver, conn, errResult := cacheDB(sq.Config.Config, sq.flags.cacheDB, dir)
+			if errResult != nil {
+				return nil, errResult
+			}
+			images = sous.NewNameCache(sq.DockerClient, driver, conn)
+			defer images.Close()
+		}
+		sv, err := images.GetSourceVersion(sq.flags.image)
+		if err != nil {
+			return nil, imageLookupError(sq.flags.image, err)
+		}
+		ps = append(ps, func(d *sous.Deployment) bool {
+			return d.SourceVersion.Equal(sv)
+		})
+	}
+	return func(d *sous.Deployment) bool {
+		for _, p := range ps {
+			if !p(d) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
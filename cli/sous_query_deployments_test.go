@@ -0,0 +1,148 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+	"github.com/samsalisbury/semv"
+	"golang.org/x/net/context"
+)
+
+var queryState = map[string]string{
+	"defs.yaml": validState["defs.yaml"],
+	"manifests/github.com/opentable/one.yaml": `
+Source: github.com/opentable/one
+Kind: http-service
+Deployments:
+  us-west:
+    Version: 1.0.0
+    NumInstances: 2
+  eu-west:
+    Version: 1.2.0
+    NumInstances: 3
+`,
+	"manifests/github.com/opentable/two.yaml": `
+Source: github.com/opentable/two
+Kind: http-service
+Deployments:
+  us-west:
+    Version: 2.0.0
+    NumInstances: 1
+`,
+}
+
+// queryImages is an ImageMapper that only knows the source versions of
+// the images it's given.
+type queryImages map[string]sous.SourceVersion
+
+func (qi queryImages) GetCanonicalName(in string) (string, error) { return in, nil }
+func (qi queryImages) Insert(sv sous.SourceVersion, in, etag string) error {
+	return nil
+}
+func (qi queryImages) GetImageName(sv sous.SourceVersion) (string, error) { return "", nil }
+func (qi queryImages) GetSourceVersion(in string) (sous.SourceVersion, error) {
+	sv, ok := qi[in]
+	if !ok {
+		return sv, sous.NoSourceVersionFound{}
+	}
+	return sv, nil
+}
+func (qi queryImages) GetLabels(in string) (map[string]string, error) {
+	sv, err := qi.GetSourceVersion(in)
+	if err != nil {
+		return nil, err
+	}
+	return sv.DockerLabels(), nil
+}
+func (qi queryImages) Ping(ctx context.Context) error { return nil }
+func (qi queryImages) Close() error                   { return nil }
+
+func runQuery(t *testing.T, setFlags func(sq *SousQueryDeployments)) (string, cmdr.Result) {
+	dir := writeStateDir(t, queryState)
+	defer os.RemoveAll(dir)
+
+	out := &bytes.Buffer{}
+	sq := &SousQueryDeployments{Sink: testSink(out, false)}
+	setFlags(sq)
+	r := sq.Execute([]string{dir})
+	return out.String(), r
+}
+
+func TestSousQueryDeployments_Filters(t *testing.T) {
+	out, r := runQuery(t, func(sq *SousQueryDeployments) {
+		sq.flags.repo = "github.com/opentable/one"
+		sq.flags.versionConstraint = "^1.1.0"
+	})
+	if _, ok := r.(cmdr.SuccessResult); !ok {
+		t.Fatalf("got %T %v; want success", r, r)
+	}
+	want := "Cluster  Source                    Version  Instances\n" +
+		"eu-west  github.com/opentable/one  1.2.0    3\n"
+	if out != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestSousQueryDeployments_JSON(t *testing.T) {
+	out, r := runQuery(t, func(sq *SousQueryDeployments) {
+		sq.flags.cluster = "us-west"
+		sq.Sink.JSON = true
+	})
+	if _, ok := r.(cmdr.SuccessResult); !ok {
+		t.Fatalf("got %T %v; want success", r, r)
+	}
+	ms := []queryMatch{}
+	if err := json.Unmarshal([]byte(out), &ms); err != nil {
+		t.Fatalf("%s in:\n%s", err, out)
+	}
+	if len(ms) != 2 || ms[0].Source != "github.com/opentable/one" ||
+		ms[1].Version != "2.0.0" || ms[1].Cluster != "us-west" {
+		t.Errorf("got %+v", ms)
+	}
+}
+
+func TestSousQueryDeployments_Image(t *testing.T) {
+	sv := sous.SourceVersion{
+		RepoURL: "github.com/opentable/one",
+		Version: semv.MustParse("1.0.0"),
+	}
+	out, r := runQuery(t, func(sq *SousQueryDeployments) {
+		sq.images = queryImages{"docker.example.com/one:1.0.0": sv}
+		sq.flags.image = "docker.example.com/one:1.0.0"
+	})
+	if _, ok := r.(cmdr.SuccessResult); !ok {
+		t.Fatalf("got %T %v; want success", r, r)
+	}
+	if !strings.Contains(out, "us-west") || strings.Contains(out, "eu-west") {
+		t.Errorf("got:\n%s", out)
+	}
+}
+
+func TestSousQueryDeployments_NoMatches(t *testing.T) {
+	out, r := runQuery(t, func(sq *SousQueryDeployments) {
+		sq.flags.repo = "github.com/opentable/three"
+	})
+	if r.ExitCode() != 0 {
+		t.Errorf("got exit code %d; want 0", r.ExitCode())
+	}
+	if out != "no matches\n" {
+		t.Errorf("got %q", out)
+	}
+}
+
+func TestSousQueryDeployments_BadFilters(t *testing.T) {
+	for _, setFlags := range []func(*SousQueryDeployments){
+		func(sq *SousQueryDeployments) { sq.flags.versionConstraint = "about 1" },
+		func(sq *SousQueryDeployments) { sq.flags.cluster = "nowhere" },
+	} {
+		_, r := runQuery(t, setFlags)
+		if _, ok := r.(cmdr.UsageErr); !ok {
+			t.Errorf("got %T %v; want a usage error", r, r)
+		}
+	}
+}
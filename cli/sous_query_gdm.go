@@ -1,9 +1,9 @@
 package cli
 
 import (
-	"fmt"
-	"os"
-	"text/tabwriter"
+	"flag"
+	"io"
+	"strings"
 
 	"github.com/opentable/sous/lib"
 	"github.com/opentable/sous/util/cmdr"
@@ -12,9 +12,11 @@ import (
 // SousQueryGDM is the description of the `sous query gdm` command
 type SousQueryGDM struct {
 	Sous  *Sous
+	Sink  OutputSink
 	flags struct {
 		singularity string
 		registry    string
+		stateDir    string
 	}
 }
 
@@ -29,12 +31,20 @@ This should resemble the manifest that was used to establish the intended state
 // Help prints the help
 func (*SousQueryGDM) Help() string { return sousQueryGDMHelp }
 
+// AddFlags adds flags for sous query gdm
+func (sb *SousQueryGDM) AddFlags(fs *flag.FlagSet) {
+	addStateDirFlag(fs, &sb.flags.stateDir)
+}
+
 // Execute defines the behavior of `sous query gdm`
 func (sb *SousQueryGDM) Execute(args []string) cmdr.Result {
-	if len(args) < 1 {
-		return UsageErrorf("sous querry gdm: directory to load deployment configuration required")
+	if len(args) > 1 {
+		return UsageErrorf("sous query gdm: at most one state directory allowed")
+	}
+	dir, errResult := stateDir(stateDirArg(args), sb.flags.stateDir)
+	if errResult != nil {
+		return errResult
 	}
-	dir := args[0]
 
 	state, err := sous.LoadState(dir)
 	if err != nil {
@@ -46,14 +56,24 @@ func (sb *SousQueryGDM) Execute(args []string) cmdr.Result {
 		return EnsureErrorResult(err)
 	}
 
-	w := &tabwriter.Writer{}
-	w.Init(os.Stdout, 2, 4, 2, ' ', 0)
-	fmt.Fprintln(w, sous.TabbedDeploymentHeaders())
-
-	for _, d := range gdm {
-		fmt.Fprintln(w, d.Tabbed())
+	if errResult := sb.Sink.Result(gdm, func(w io.Writer) {
+		printDeployments(w, sb.Sink.renderer(), gdm)
+	}); errResult != nil {
+		return errResult
 	}
-	w.Flush()
 
 	return Success()
 }
+
+// printDeployments writes a table of ds to out, one row per deployment.
+func printDeployments(out io.Writer, rn renderer, ds sous.Deployments) {
+	t := table{header: strings.Split(sous.TabbedDeploymentHeaders(), "\t")}
+	for _, d := range ds {
+		row := []cell{}
+		for _, f := range strings.Split(d.Tabbed(), "\t") {
+			row = append(row, plain(f))
+		}
+		t.rows = append(t.rows, row)
+	}
+	rn.render(out, t)
+}
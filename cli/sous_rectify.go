@@ -2,20 +2,36 @@ package cli
 
 import (
 	"flag"
-	"log"
-	"os"
+	"fmt"
+	"io"
+	"strings"
+	"time"
 
+	"github.com/opentable/sous/ext/git"
 	"github.com/opentable/sous/lib"
 	"github.com/opentable/sous/util/cmdr"
+	"github.com/opentable/sous/util/resolve"
+	"github.com/opentable/sous/util/shell"
+	"github.com/satori/go.uuid"
+	"golang.org/x/net/context"
 )
 
 // SousRectify is the injectable command object used for `sous rectify`
 type SousRectify struct {
 	Config       LocalSousConfig
 	DockerClient LocalDockerClient
+	Sink         OutputSink
 	flags        struct {
 		dryrun,
-		manifest string
+		manifest,
+		cluster,
+		only,
+		stateDir,
+		auditLog string
+		forceDowngrade,
+		ignoreBlastRadius,
+		ignoreQuotas,
+		skipUnreadable bool
 	}
 }
 
@@ -24,7 +40,83 @@ func init() { TopLevelCommands["rectify"] = &SousRectify{} }
 const sousRectifyHelp = `
 force Sous to make the deployment match the contents of a state directory
 
-usage: sous rectify <dir>
+usage: sous rectify [<dir>]
+
+If dir is not given, it is taken from -state-dir, $SOUS_STATE_DIR, or the
+nearest directory above the working directory containing defs.yaml or
+.sous-state.
+
+Use -cluster to rectify only the named cluster, and -only repo[:offset] to
+rectify only one service. Deployments excluded this way are left as they are.
+
+If BlockDowngrades is set in your config, deploys of versions older than
+those running are skipped and reported as errors, except for deployments
+whose manifests set AllowDowngrade: true. Use -force-downgrade to deploy them
+anyway.
+
+If CompareRevisions is set in your config, a deployment whose version hasn't
+changed is still redeployed if its image has been rebuilt from a different
+revision, e.g. because a tag was moved.
+
+Deletes wait for every create and modify to finish, so that a service moving
+between manifests is running in its new place before it's deleted from its
+old one. Set RectifyOrdering in your config to deletes-first to run deletes
+first instead, or to concurrent to run them all at once. The ordering is
+noted before rectifying, in the plan printed by -dry-run, and in each entry of
+the -audit-log.
+
+If MaxDeletes, MaxModifies or MaxCreates (or their Percent variants) are set
+in your config, and rectify would change more deployments than they allow,
+it changes nothing and exits non-zero. Use -ignore-blast-radius to rectify
+anyway. With -dry-run, the plan notes when it would exceed them.
+
+Freezes in defs.yaml stop rectify changing the deployments they apply to,
+everywhere or on one cluster or for one service, optionally from a Start to
+an End time, e.g.
+
+  Freezes:
+  - Cluster: us-west
+    End: 2017-01-03T00:00:00Z
+    Reason: holidays
+
+Frozen changes are reported instead of being made, and marked in the plan
+printed by -dry-run.
+
+A cluster in defs.yaml can set a Capacity, and OwnerQuotas for the
+deployments of each owner, limiting the CPUs and MemoryMB of all their
+instances together, and the MaxInstances of each, e.g.
+
+  Clusters:
+    us-west:
+      Capacity: {CPUs: 400, MemoryMB: 819200, MaxInstances: 50}
+      OwnerQuotas:
+        payments: {CPUs: 40}
+
+If the deployments rectify would leave running exceed any of them, it
+changes nothing, reports each quota exceeded along with the deployments
+using the most of it, and exits non-zero. Use -ignore-quotas to rectify
+anyway. With -dry-run, the plan notes the quotas it would exceed. sous state
+validate checks the quotas too.
+
+If the deployments running on any cluster can't be read, rectify changes
+nothing and exits non-zero, so that a cluster that's down isn't mistaken for
+an empty one. Use -skip-unreadable to report those clusters and rectify the
+others.
+
+With -audit-log, every create, deploy, scale and delete rectify attempts is
+appended to the named file as a line of JSON as soon as it's done, along
+with an ID for the run and the git revision of the state directory.
+
+With -dry-run scheduler (or both), rectify prints the changes it would make
+instead of making them, or with the global -json flag, the whole plan as JSON.
+On a terminal, the changes are colour coded, with the fields a modify changes
+highlighted, and truncated to fit, unless -no-color or -wide is given.
+Errors are printed as they happen; if there were any, rectify exits non-zero.
+
+If Offline is set in your config, or $SOUS_OFFLINE, images are looked up in
+the name cache alone, and a docker registry is never queried, so that
+-dry-run scheduler works on machines that can't reach one, given a warm
+cache.
 
 Note: by default this command will query a live docker registry and make
 changes to live Mesos schedulers
@@ -40,46 +132,319 @@ func (sr *SousRectify) AddFlags(fs *flag.FlagSet) {
 			"values are none,scheduler,registry,both")
 	fs.StringVar(&sr.flags.manifest, "manifest", "",
 		"consider only the named manifest for rectification")
+	fs.StringVar(&sr.flags.cluster, "cluster", "",
+		"consider only the named cluster for rectification")
+	fs.StringVar(&sr.flags.only, "only", "",
+		"consider only the service at repo[:offset] for rectification")
+	fs.StringVar(&sr.flags.auditLog, "audit-log", "",
+		"append a record of each change made to clusters to this file")
+	fs.BoolVar(&sr.flags.forceDowngrade, "force-downgrade", false,
+		"deploy versions older than those running, even if downgrades are blocked")
+	fs.BoolVar(&sr.flags.ignoreBlastRadius, "ignore-blast-radius", false,
+		"rectify even if more deployments would change than config allows")
+	fs.BoolVar(&sr.flags.ignoreQuotas, "ignore-quotas", false,
+		"rectify even if deployments would exceed the quotas of their clusters")
+	fs.BoolVar(&sr.flags.skipUnreadable, "skip-unreadable", false,
+		"leave out clusters whose running deployments can't be read, rather than failing")
+	addStateDirFlag(fs, &sr.flags.stateDir)
 }
 
 // Execute fulfils the cmdr.Executor interface
 func (sr *SousRectify) Execute(args []string) cmdr.Result {
 	var nc sous.ImageMapper
-	var rc sous.RectificationClient
 
-	if len(args) < 1 {
-		return UsageErrorf("sous rectify requires a directory to load the intended deployment from")
+	if len(args) > 1 {
+		return UsageErrorf("sous rectify: at most one state directory allowed")
+	}
+	dir, errResult := stateDir(stateDirArg(args), sr.flags.stateDir)
+	if errResult != nil {
+		return errResult
+	}
+
+	filter := sous.ResolveFilter{Cluster: sr.flags.cluster}
+	if sr.flags.only != "" {
+		sl := parseSourceLocationFlag(sr.flags.only)
+		filter.Source = &sl
+	}
+
+	state, err := sous.LoadState(dir)
+	if err != nil {
+		return EnsureErrorResult(err)
+	}
+	urls, err := filter.BaseURLs(&state)
+	if err != nil {
+		return UsageErrorf("sous rectify: %s", err)
 	}
-	dir := args[0]
 
 	if sr.flags.dryrun == "both" || sr.flags.dryrun == "registry" {
 		nc = sous.NewDummyNameCache()
 	} else {
-		nc = sous.NewNameCache(
-			sr.DockerClient,
-			sr.Config.DatabaseDriver,
-			sr.Config.DatabaseConnection)
+		if nc, err = configNameCache(sr.Config.Config, sr.DockerClient); err != nil {
+			return EnsureErrorResult(err)
+		}
 	}
+	defer nc.Close()
+	rc := sous.NewSingularityClient(state.Defs.ClusterURLs(), nc)
 
-	if sr.flags.dryrun == "both" || sr.flags.dryrun == "scheduler" {
-		drc := sous.NewDummyRectificationClient(nc)
-		drc.SetLogger(log.New(os.Stdout, "rectify: ", 0))
-		rc = drc
-	} else {
-		rc = sous.NewRectiAgent(nc)
-	}
-	var predicate sous.DeploymentPredicate
+	predicate := filter.Predicate(&state)
 	if sr.flags.manifest != "" {
+		p := predicate
 		predicate = func(d *sous.Deployment) bool {
-			return d.SourceVersion.RepoURL == sous.RepoURL(sr.flags.manifest)
+			return d.SourceVersion.RepoURL == sous.RepoURL(sr.flags.manifest) &&
+				(p == nil || p(d))
 		}
 	}
 
 	// If predicate is still nil, that means resolve all. See Deployments.Filter.
-	err := sous.ResolveFromDirFiltered(rc, dir, predicate)
+	plan, err := sous.ResolvePlanWith(context.Background(), rc, state, urls, predicate, sous.ResolveOpts{
+		SkipUnreadableClusters: sr.flags.skipUnreadable,
+		Skipped:                sr.Sink.Error,
+	})
 	if err != nil {
 		return EnsureErrorResult(err)
 	}
 
+	blastRadius := sr.Config.BlastRadius()
+	quotas := state.Defs.Clusters.Quotas()
+	ordering, err := sous.ParseRectifyOrdering(sr.Config.RectifyOrdering)
+	if err != nil {
+		return UsageErrorf("sous rectify: %s", err)
+	}
+	if sr.flags.dryrun == "both" || sr.flags.dryrun == "scheduler" {
+		r := dryRunPlan{DiffReport: sous.CollectDiff(plan), Ordering: ordering.String()}
+		r.Frozen = frozenChanges(r.DiffReport, state.Defs.Freezes.In(state.Defs.Clusters), time.Now())
+		if err := blastRadius.Check(r.Counts); err != nil {
+			r.BlastRadiusExceeded = err.Error()
+		}
+		for _, err := range quotas.Check(r.Intended()) {
+			r.QuotasExceeded = append(r.QuotasExceeded, err.Error())
+		}
+		if errResult := sr.Sink.Result(r, func(w io.Writer) { printPlan(w, sr.Sink.renderer(), r) }); errResult != nil {
+			return errResult
+		}
+		return Success()
+	}
+
+	opts := sous.RectifyOpts{
+		BlockDowngrades:   sr.Config.BlockDowngrades,
+		CompareRevisions:  sr.Config.CompareRevisions,
+		ForceDowngrades:   sr.flags.forceDowngrade,
+		BlastRadius:       blastRadius,
+		IgnoreBlastRadius: sr.flags.ignoreBlastRadius,
+		Quotas:            quotas,
+		IgnoreQuotas:      sr.flags.ignoreQuotas,
+		Freezes:           state.Defs.Freezes.In(state.Defs.Clusters),
+		Ordering:          ordering,
+	}
+	opts.CircuitThreshold, opts.CircuitCoolDown, err = sr.Config.CircuitOptions()
+	if err != nil {
+		return UsageErrorf("sous rectify: %s", err)
+	}
+	if len(opts.Freezes) != 0 || opts.CircuitThreshold > 0 {
+		events := make(chan sous.RectifyEvent, 256)
+		opts.Events = events
+		skipped := make(chan map[sous.RectifyEventKind]int)
+		go func() { skipped <- sr.reportSkipped(events) }()
+		defer func() {
+			close(events)
+			n := <-skipped
+			if n[sous.SkippedFrozen] != 0 {
+				sr.Sink.Infof("%d changes skipped for freezes", n[sous.SkippedFrozen])
+			}
+			if n[sous.ClusterCircuitOpen] != 0 {
+				sr.Sink.Infof("%d changes skipped for open circuits", n[sous.ClusterCircuitOpen])
+			}
+		}()
+	}
+	if sr.flags.auditLog != "" {
+		path, err := resolve.Resolve(sr.flags.auditLog)
+		if err != nil {
+			return UsageErrorf("sous rectify: -audit-log: %s", err)
+		}
+		audit, err := sous.NewAuditLog(path)
+		if err != nil {
+			return IOErrorf("unable to open audit log: %s", err)
+		}
+		defer audit.Close()
+		opts.Auditor = audit
+		opts.RunID = uuid.NewV4().String()
+		opts.StateRevision = stateRevision(dir)
+	}
+	sr.Sink.Infof("%s (%s)", ordering.Explain(), ordering)
+	err = sous.RectifyPlanWith(rc, plan, opts, sr.Sink.Error)
+	if err != nil {
+		return EnsureErrorResult(err)
+	}
 	return Success()
 }
+
+// reportSkipped reports each change skipped for a freeze or an open
+// circuit, as events are received, and returns how many there were of each
+// kind once events is closed.
+func (sr *SousRectify) reportSkipped(events <-chan sous.RectifyEvent) map[sous.RectifyEventKind]int {
+	n := map[sous.RectifyEventKind]int{}
+	for ev := range events {
+		if ev.Kind == sous.SkippedFrozen || ev.Kind == sous.ClusterCircuitOpen {
+			sr.Sink.Infof("%s", ev)
+			n[ev.Kind]++
+		}
+	}
+	return n
+}
+
+// dryRunPlan is the output of `sous rectify -dry-run`
+type dryRunPlan struct {
+	sous.DiffReport
+	// BlastRadiusExceeded explains how the plan exceeds the configured
+	// limits, if it does.
+	BlastRadiusExceeded string `json:",omitempty"`
+	// QuotasExceeded explains each quota of a cluster the plan would
+	// exceed.
+	QuotasExceeded []string `json:",omitempty"`
+	// Frozen are the changes in the plan that freezes would stop rectify
+	// making.
+	Frozen []frozenChange `json:",omitempty"`
+	// Ordering is the RectifyOrdering the plan would be rectified with.
+	Ordering string
+}
+
+// frozenChange is a change in a dryRunPlan that a freeze applies to.
+type frozenChange struct {
+	Action  string
+	Cluster sous.ClusterName
+	Source  sous.SourceLocation
+	Freeze  sous.Freeze
+	// deployment is the one in the plan, for marking it
+	deployment *sous.Deployment
+}
+
+// frozenChanges returns the changes in r that one of fs applies to at time
+// at.
+func frozenChanges(r sous.DiffReport, fs sous.Freezes, at time.Time) []frozenChange {
+	var frozen []frozenChange
+	check := func(action string, d *sous.Deployment) {
+		if f, ok := fs.Frozen(d, at); ok {
+			frozen = append(frozen, frozenChange{
+				Action:     action,
+				Cluster:    d.Cluster,
+				Source:     d.SourceVersion.CanonicalName(),
+				Freeze:     *f,
+				deployment: d,
+			})
+		}
+	}
+	for _, d := range r.Created {
+		check("create", d)
+	}
+	for _, d := range r.Deleted {
+		check("delete", d)
+	}
+	for _, p := range r.Modified {
+		check("modify", p.Post())
+	}
+	return frozen
+}
+
+func printPlan(out io.Writer, rn renderer, r dryRunPlan) {
+	frozen := map[*sous.Deployment]bool{}
+	for _, f := range r.Frozen {
+		frozen[f.deployment] = true
+	}
+	row := func(a string, d *sous.Deployment, changed map[int]bool) []cell {
+		note := ""
+		if frozen[d] {
+			note = "(frozen)"
+		}
+		cells := []cell{actionCell(a, note)}
+		for i, f := range strings.Split(d.Tabbed(), "\t") {
+			if changed[i] {
+				cells = append(cells, styled(f, modifyStyle))
+			} else {
+				cells = append(cells, plain(f))
+			}
+		}
+		return cells
+	}
+
+	t := table{header: append([]string{"Action"}, strings.Split(sous.TabbedDeploymentHeaders(), "\t")...)}
+	for _, d := range r.Created {
+		t.rows = append(t.rows, row("create", d, nil))
+	}
+	for _, d := range r.Deleted {
+		t.rows = append(t.rows, row("delete", d, nil))
+	}
+	for _, p := range r.Modified {
+		t.rows = append(t.rows, row("modify", p.Post(), changedColumns(p)))
+	}
+	rn.render(out, t)
+	fmt.Fprintf(out, "%d to create, %d to delete, %d to modify, %d unchanged\n",
+		r.Counts.Created, r.Counts.Deleted, r.Counts.Modified, r.Counts.Retained)
+	if len(r.Frozen) != 0 {
+		fmt.Fprintf(out, "%d of these changes are frozen, and would be skipped\n", len(r.Frozen))
+	}
+	if o, err := sous.ParseRectifyOrdering(r.Ordering); r.Ordering != "" && err == nil {
+		fmt.Fprintf(out, "%s (%s)\n", o.Explain(), o)
+	}
+	if r.BlastRadiusExceeded != "" {
+		fmt.Fprintf(out, "WARNING: this plan exceeds the blast radius, so rectify would be %s\n",
+			strings.TrimPrefix(r.BlastRadiusExceeded, "refusing to rectify: it would "))
+	}
+	for _, q := range r.QuotasExceeded {
+		fmt.Fprintf(out, "WARNING: rectify would refuse this plan: %s\n", q)
+	}
+}
+
+// tabbedColumns maps the prefixes of DeploymentPair.Differences to the
+// columns of Deployment.Tabbed they're about.
+var tabbedColumns = map[string]int{
+	"version":    2,
+	"instances":  4,
+	"owners":     5,
+	"resources.": 6,
+	"env ":       7,
+}
+
+// changedColumns returns the columns of Deployment.Tabbed that differ
+// between the deployments of p, so that they can be highlighted.
+func changedColumns(p *sous.DeploymentPair) map[int]bool {
+	changed := map[int]bool{}
+	for _, d := range p.Differences() {
+		for prefix, col := range tabbedColumns {
+			if strings.HasPrefix(d, prefix) {
+				changed[col] = true
+			}
+		}
+	}
+	return changed
+}
+
+// parseSourceLocationFlag parses a repo[:offset] flag value. A colon followed
+// by "//" is taken to be part of a URL scheme rather than an offset.
+func parseSourceLocationFlag(s string) sous.SourceLocation {
+	i := strings.LastIndex(s, ":")
+	if i < 0 || strings.HasPrefix(s[i+1:], "//") {
+		return sous.SourceLocation{RepoURL: sous.RepoURL(s)}
+	}
+	return sous.SourceLocation{
+		RepoURL:    sous.RepoURL(s[:i]),
+		RepoOffset: sous.RepoOffset(s[i+1:]),
+	}
+}
+
+// stateRevision returns the git revision checked out in the state directory
+// dir, or "" if it isn't in a git repository.
+func stateRevision(dir string) string {
+	sh, err := shell.DefaultInDir(dir)
+	if err != nil {
+		return ""
+	}
+	c, err := git.NewClient(sh)
+	if err != nil {
+		return ""
+	}
+	rev, err := c.Revision()
+	if err != nil {
+		return ""
+	}
+	return rev
+}
@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opentable/sous/lib"
+)
+
+func TestParseSourceLocationFlag(t *testing.T) {
+	cases := map[string]sous.SourceLocation{
+		"github.com/opentable/a":                {RepoURL: "github.com/opentable/a"},
+		"github.com/opentable/a:sub/dir":        {RepoURL: "github.com/opentable/a", RepoOffset: "sub/dir"},
+		"https://github.com/opentable/a":        {RepoURL: "https://github.com/opentable/a"},
+		"https://github.com/opentable/a:subdir": {RepoURL: "https://github.com/opentable/a", RepoOffset: "subdir"},
+	}
+	for in, want := range cases {
+		if got := parseSourceLocationFlag(in); got != want {
+			t.Errorf("parseSourceLocationFlag(%q) = %+v; want %+v", in, got, want)
+		}
+	}
+}
+
+func TestPrintPlanMarksFrozenChanges(t *testing.T) {
+	east := &sous.Deployment{Cluster: "east", SourceVersion: sous.SourceVersion{RepoURL: "github.com/opentable/a"}}
+	west := &sous.Deployment{Cluster: "west", SourceVersion: sous.SourceVersion{RepoURL: "github.com/opentable/a"}}
+	r := dryRunPlan{DiffReport: sous.DiffReport{
+		Created: sous.Deployments{east, west},
+		Counts:  sous.DiffCounts{Created: 2},
+	}}
+	r.Frozen = frozenChanges(r.DiffReport, sous.Freezes{{Cluster: "east"}}, time.Now())
+	if len(r.Frozen) != 1 || r.Frozen[0].Cluster != "east" || r.Frozen[0].Action != "create" {
+		t.Fatalf("got frozen changes %+v; want the create on east", r.Frozen)
+	}
+
+	out := &bytes.Buffer{}
+	printPlan(out, renderer{}, r)
+	lines := strings.Split(out.String(), "\n")
+	if !strings.HasPrefix(lines[1], "create (frozen)") || strings.Contains(lines[2], "frozen") {
+		t.Errorf("only the create on east should be marked frozen:\n%s", out)
+	}
+	if !strings.Contains(out.String(), "1 of these changes are frozen") {
+		t.Errorf("no count of frozen changes:\n%s", out)
+	}
+}
+
+func TestPrintPlanNotesOrdering(t *testing.T) {
+	for _, o := range []sous.RectifyOrdering{sous.CreatesFirst, sous.DeletesFirst, sous.Concurrent} {
+		out := &bytes.Buffer{}
+		printPlan(out, renderer{}, dryRunPlan{Ordering: o.String()})
+		if !strings.Contains(out.String(), "("+o.String()+")") {
+			t.Errorf("plan doesn't note the %s ordering:\n%s", o, out)
+		}
+	}
+}
+
+func TestPrintPlanNotesQuotasExceeded(t *testing.T) {
+	out := &bytes.Buffer{}
+	printPlan(out, renderer{}, dryRunPlan{QuotasExceeded: []string{"quota of cluster east exceeded: lots"}})
+	if !strings.Contains(out.String(), "WARNING: rectify would refuse this plan: quota of cluster east exceeded: lots\n") {
+		t.Errorf("plan doesn't note the quota exceeded:\n%s", out)
+	}
+}
@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/opentable/sous/ext/storage"
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+)
+
+// SousScale is the description of the `sous scale` command
+type SousScale struct {
+	Config       LocalSousConfig
+	DockerClient LocalDockerClient
+	User         LocalUser
+	Out          Out
+	ErrOut       ErrOut
+	// rc is the client used to scale, which is a SingularityClient for the
+	// clusters in the state unless set by tests
+	rc    sous.RectificationClient
+	flags struct {
+		message, stateDir string
+		updateState       bool
+	}
+}
+
+func init() { TopLevelCommands["scale"] = &SousScale{} }
+
+const sousScaleHelp = `
+change the number of instances of a service now
+
+args: <cluster> <source location> <count>
+
+scale sets the instance count of the service built from source location,
+such as github.com/opentable/sous, on the named cluster, without waiting for
+a rectify. The change is reverted by the next rectify unless the manifest is
+changed to match, which -update-state does as well.
+
+The cluster and manifest are found in the state directory given by
+-state-dir, $SOUS_STATE_DIR, or the nearest directory above the working
+directory containing defs.yaml or .sous-state.
+`
+
+// Help prints the help
+func (*SousScale) Help() string { return sousScaleHelp }
+
+// AddFlags adds flags for sous scale
+func (ss *SousScale) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&ss.flags.message, "message", "",
+		"why the service is being scaled, recorded with the change")
+	fs.BoolVar(&ss.flags.updateState, "update-state", false,
+		"also write the new count into the manifest")
+	addStateDirFlag(fs, &ss.flags.stateDir)
+}
+
+// Execute defines the behavior of `sous scale`
+func (ss *SousScale) Execute(args []string) cmdr.Result {
+	if len(args) != 3 {
+		return UsageErrorf("sous scale: cluster, source location and count required")
+	}
+	clusterName := args[0]
+	sl, err := sous.ParseCanonicalName(args[1])
+	if err != nil {
+		return UsageErrorf("sous scale: %s", err)
+	}
+	count, err := strconv.Atoi(args[2])
+	if err != nil || count < 0 {
+		return UsageErrorf("sous scale: count must be a whole number, not %q", args[2])
+	}
+
+	dir, errResult := stateDir("", ss.flags.stateDir)
+	if errResult != nil {
+		return errResult
+	}
+	state, err := sous.LoadState(dir)
+	if err != nil {
+		return stateLoadError(dir, err)
+	}
+	cluster, ok := state.Defs.Clusters[clusterName]
+	if !ok {
+		return UsageErrorf("sous scale: no cluster named %q is defined", clusterName)
+	}
+	name, m, ok := state.ManifestFor(sl)
+	if !ok {
+		return UsageErrorf("sous scale: no manifest for %s", sl)
+	}
+	spec, ok := m.Deployments[clusterName]
+	if !ok {
+		return UsageErrorf("sous scale: %s is not deployed to %s", sl, clusterName)
+	}
+	ds, err := state.DeploymentsFromManifest(m)
+	if err != nil {
+		return DataErrorf("invalid manifest for %s: %s", sl, err)
+	}
+	var deployed *sous.Deployment
+	for _, d := range ds {
+		if d.Cluster == sous.ClusterName(cluster.BaseURL) {
+			deployed = d
+		}
+	}
+	if deployed == nil {
+		return UsageErrorf("sous scale: %s is not deployed to %s", sl, clusterName)
+	}
+	reqID := sous.ComputeRequestID(deployed)
+
+	rc := ss.rc
+	if rc == nil {
+		nc, err := configNameCache(ss.Config.Config, ss.DockerClient)
+		if err != nil {
+			return EnsureErrorResult(err)
+		}
+		defer nc.Close()
+		rc = sous.NewSingularityClient(state.Defs.ClusterURLs(), nc)
+	}
+	if err := rc.Scale(sous.ClusterName(cluster.BaseURL), reqID, count, ss.scaleMessage()); err != nil {
+		return IOErrorf("unable to scale %s on %s: %s", sl, clusterName, err)
+	}
+	ss.Out.Printfln("scaled %s on %s to %d instances", sl, clusterName, count)
+
+	if !ss.flags.updateState {
+		ss.ErrOut.Printfln("WARNING: the manifest for %s still has %d instances on %s, so the "+
+			"next rectify will undo this change. Update the manifest to keep it, "+
+			"or use -update-state next time.", sl, deployed.NumInstances, clusterName)
+		return Success()
+	}
+	spec.SetNumInstances(count)
+	m.Deployments[clusterName] = spec
+	if err := storage.WriteManifest(dir, &state, name); err != nil {
+		return IOErrorf("scaled %s, but unable to update its manifest: %s", sl, err)
+	}
+	ss.Out.Printfln("updated the manifest for %s", sl)
+	return Success()
+}
+
+// scaleMessage names the operator making the change, and why.
+func (ss *SousScale) scaleMessage() string {
+	who := "an unknown user"
+	if ss.User.User != nil && ss.User.User.User != nil {
+		who = ss.User.Username
+	}
+	if ss.flags.message == "" {
+		return fmt.Sprintf("scaled by %s", who)
+	}
+	return fmt.Sprintf("scaled by %s: %s", who, ss.flags.message)
+}
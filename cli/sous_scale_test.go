@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+)
+
+var scaleState = map[string]string{
+	"defs.yaml": validState["defs.yaml"],
+	"manifests/github.com/opentable/one.yaml": `
+Source: github.com/opentable/one
+Kind: http-service
+Deployments:
+  us-west:
+    Version: 1.0.0
+    NumInstances: 2
+`,
+	"manifests/github.com/opentable/two.yaml": `# hand written
+Source: github.com/opentable/two
+Kind: http-service
+`,
+	"manifests/github.com/opentable/three.yaml": `
+Source: github.com/opentable/three
+Kind: http-service
+Deployments:
+  Global:
+    NumInstances: 4
+  us-west:
+    Version: 1.0.0
+`,
+}
+
+func newTestSousScale(t *testing.T, dir string) (*SousScale, *bytes.Buffer, *bytes.Buffer) {
+	logged, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+	rc := sous.NewDummyRectificationClient(sous.NewDummyNameCache())
+	rc.SetLogger(log.New(logged, "", 0))
+	ss := &SousScale{
+		User:   LocalUser{&User{&user.User{Username: "alice"}}},
+		Out:    Out{cmdr.NewOutput(&bytes.Buffer{})},
+		ErrOut: ErrOut{cmdr.NewOutput(errOut)},
+		rc:     rc,
+	}
+	ss.flags.stateDir = dir
+	return ss, logged, errOut
+}
+
+func TestSousScale(t *testing.T) {
+	dir := writeStateDir(t, scaleState)
+	defer os.RemoveAll(dir)
+	ss, logged, errOut := newTestSousScale(t, dir)
+	ss.flags.message = "incident 123"
+
+	r := ss.Execute([]string{"us-west", "github.com/opentable/one", "5"})
+	if r.ExitCode() != 0 {
+		t.Fatalf("got %T %v; want success", r, r)
+	}
+	want := "Scaling http://singularity.example.com github.comopentableone 5 scaled by alice: incident 123"
+	if !strings.Contains(logged.String(), want) {
+		t.Errorf("got log:\n%s\nwant it to contain %q", logged, want)
+	}
+	if !strings.Contains(errOut.String(), "WARNING") {
+		t.Errorf("got no warning about the next rectify:\n%s", errOut)
+	}
+}
+
+func TestSousScale_WarnsOfMergedCount(t *testing.T) {
+	dir := writeStateDir(t, scaleState)
+	defer os.RemoveAll(dir)
+	ss, _, errOut := newTestSousScale(t, dir)
+
+	r := ss.Execute([]string{"us-west", "github.com/opentable/three", "5"})
+	if r.ExitCode() != 0 {
+		t.Fatalf("got %T %v; want success", r, r)
+	}
+	if want := "still has 4 instances on us-west"; !strings.Contains(errOut.String(), want) {
+		t.Errorf("got warning:\n%s\nwant the count inherited from Global, %q", errOut, want)
+	}
+}
+
+func TestSousScale_UpdateState(t *testing.T) {
+	dir := writeStateDir(t, scaleState)
+	defer os.RemoveAll(dir)
+	ss, _, errOut := newTestSousScale(t, dir)
+	ss.flags.updateState = true
+
+	r := ss.Execute([]string{"us-west", "github.com/opentable/one", "5"})
+	if r.ExitCode() != 0 {
+		t.Fatalf("got %T %v; want success", r, r)
+	}
+	if errOut.Len() != 0 {
+		t.Errorf("got warning with -update-state:\n%s", errOut)
+	}
+	state, err := sous.LoadState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, m, _ := state.ManifestFor(sous.SourceLocation{RepoURL: "github.com/opentable/one"})
+	if n := m.Deployments["us-west"].NumInstances; n != 5 {
+		t.Errorf("manifest has %d instances; want 5", n)
+	}
+	two, err := ioutil.ReadFile(filepath.Join(dir, "manifests/github.com/opentable/two.yaml"))
+	if err != nil || string(two) != scaleState["manifests/github.com/opentable/two.yaml"] {
+		t.Errorf("other manifest was rewritten: %q, %v", two, err)
+	}
+}
+
+func TestSousScale_Errors(t *testing.T) {
+	dir := writeStateDir(t, scaleState)
+	defer os.RemoveAll(dir)
+	ss, _, _ := newTestSousScale(t, dir)
+
+	for _, args := range [][]string{
+		{"us-west", "github.com/opentable/one"},
+		{"us-west", "github.com/opentable/one", "many"},
+		{"nowhere", "github.com/opentable/one", "1"},
+		{"us-west", "github.com/opentable/missing", "1"},
+		{"eu-west", "github.com/opentable/one", "1"},
+	} {
+		if r := ss.Execute(args); r.ExitCode() != cmdr.EX_USAGE {
+			t.Errorf("%v: got exit code %d (%v); want %d", args, r.ExitCode(), r, cmdr.EX_USAGE)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package cli
+
+import (
+	"flag"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/opentable/sous/ext/storage"
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+	"github.com/samsalisbury/semv"
+)
+
+// SousSetVersion is the description of the `sous set-version` command
+type SousSetVersion struct {
+	Config       LocalSousConfig
+	DockerClient LocalDockerClient
+	Out          Out
+	ErrOut       ErrOut
+	// im is used to check that the version has an image, and is the name
+	// cache from config unless set by tests
+	im    sous.ImageMapper
+	flags struct {
+		cluster, source, stateDir string
+		allClusters, noVerify     bool
+	}
+}
+
+func init() { TopLevelCommands["set-version"] = &SousSetVersion{} }
+
+const sousSetVersionHelp = `
+deploy a version of a service, by changing its manifest
+
+args: <version>
+
+set-version sets the version of the service built from the source location
+given by -source, such as github.com/opentable/sous, in the manifest for that
+service, so that the next rectify deploys it. If -source names a repository
+with several manifests, at different offsets, they're listed, and one of
+them must be chosen.
+
+The version is set on the cluster given by -cluster, which may be left out
+if the service is only deployed to one, or on every cluster it's deployed to
+with -all-clusters.
+
+Unless -no-verify is given, the name cache must have an image for the new
+version, and the image must be in its registry, or nothing is changed. If
+Offline is set in your config, or $SOUS_OFFLINE, only the name cache is
+checked, with a warning. Only the service's manifest is rewritten,
+and each file changed is listed.
+
+The manifest is found in the state directory given by -state-dir,
+$SOUS_STATE_DIR, or the nearest directory above the working directory
+containing defs.yaml or .sous-state.
+`
+
+// Help prints the help
+func (*SousSetVersion) Help() string { return sousSetVersionHelp }
+
+// AddFlags adds flags for sous set-version
+func (sv *SousSetVersion) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&sv.flags.cluster, "cluster", "",
+		"the cluster to deploy the version to")
+	fs.StringVar(&sv.flags.source, "source", "",
+		"the source location of the service, e.g. github.com/opentable/sous")
+	fs.BoolVar(&sv.flags.allClusters, "all-clusters", false,
+		"deploy the version to every cluster the service is deployed to")
+	fs.BoolVar(&sv.flags.noVerify, "no-verify", false,
+		"don't check that the version has an image")
+	addStateDirFlag(fs, &sv.flags.stateDir)
+}
+
+// Execute defines the behavior of `sous set-version`
+func (sv *SousSetVersion) Execute(args []string) cmdr.Result {
+	if len(args) != 1 {
+		return UsageErrorf("sous set-version: exactly one version required")
+	}
+	version, err := semv.Parse(args[0])
+	if err != nil {
+		return UsageErrorf("sous set-version: %q is not a version: %s", args[0], err)
+	}
+	if sv.flags.source == "" {
+		return UsageErrorf("sous set-version: -source required")
+	}
+	sl, err := sous.ParseCanonicalName(sv.flags.source)
+	if err != nil {
+		return UsageErrorf("sous set-version: %s", err)
+	}
+	if sv.flags.allClusters && sv.flags.cluster != "" {
+		return UsageErrorf("sous set-version: -cluster and -all-clusters can't be used together")
+	}
+
+	dir, errResult := stateDir("", sv.flags.stateDir)
+	if errResult != nil {
+		return errResult
+	}
+	state, err := sous.LoadState(dir)
+	if err != nil {
+		return stateLoadError(dir, err)
+	}
+	name, m, errResult := findManifest(&state, sl)
+	if errResult != nil {
+		return errResult
+	}
+	clusters, errResult := sv.clusters(&state, m)
+	if errResult != nil {
+		return errResult
+	}
+
+	if !sv.flags.noVerify {
+		if errResult := sv.verify(m.Source, version); errResult != nil {
+			return errResult
+		}
+	}
+	for _, cluster := range clusters {
+		spec := m.Deployments[cluster]
+		spec.Version = version
+		m.Deployments[cluster] = spec
+	}
+	p, err := storage.PlanManifest(dir, &state, name)
+	if err != nil {
+		return IOErrorf("unable to update the manifest for %s: %s", m.Source, err)
+	}
+	if err := p.Apply(); err != nil {
+		return IOErrorf("unable to update the manifest for %s: %s", m.Source, err)
+	}
+	if len(p.Changes) == 0 {
+		sv.Out.Printfln("%s is already at %s on %s", m.Source, version, strings.Join(clusters, ", "))
+		return Success()
+	}
+	sv.Out.Printfln("set %s to %s on %s", m.Source, version, strings.Join(clusters, ", "))
+	for _, c := range p.Changes {
+		path, err := filepath.Rel(dir, c.Path)
+		if err != nil {
+			path = c.Path
+		}
+		sv.Out.Printfln("  %s %s", c.Action, path)
+	}
+	return Success()
+}
+
+// findManifest returns the key and manifest in state for sl. If there is
+// none, but sl has no offset and there's exactly one manifest for its
+// repository, that is used; if there are several, they're listed.
+func findManifest(state *sous.State, sl sous.SourceLocation) (string, *sous.Manifest, cmdr.ErrorResult) {
+	if name, m, ok := state.ManifestFor(sl); ok {
+		return name, m, nil
+	}
+	var candidates []string
+	if sl.RepoOffset == "" {
+		for name, m := range state.Manifests {
+			if m.Source.RepoURL == sl.RepoURL {
+				candidates = append(candidates, name)
+			}
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return "", nil, UsageErrorf("sous set-version: no manifest for %s", sl)
+	case 1:
+		return candidates[0], state.Manifests[candidates[0]], nil
+	}
+	sort.Strings(candidates)
+	sources := make([]string, len(candidates))
+	for i, name := range candidates {
+		sources[i] = state.Manifests[name].Source.String()
+	}
+	err := UsageErrorf("sous set-version: %s has %d manifests: %s",
+		sl, len(sources), strings.Join(sources, ", "))
+	err.Tip = "choose one with -source"
+	return "", nil, err
+}
+
+// clusters returns the names of the clusters to set the version on, sorted.
+func (sv *SousSetVersion) clusters(state *sous.State, m *sous.Manifest) ([]string, cmdr.ErrorResult) {
+	var deployed []string
+	for cluster := range m.Deployments {
+		if _, ok := state.Defs.Clusters[cluster]; ok {
+			deployed = append(deployed, cluster)
+		}
+	}
+	sort.Strings(deployed)
+	switch {
+	case sv.flags.cluster != "":
+		if _, ok := state.Defs.Clusters[sv.flags.cluster]; !ok {
+			return nil, UsageErrorf("sous set-version: no cluster named %q is defined", sv.flags.cluster)
+		}
+		if _, ok := m.Deployments[sv.flags.cluster]; !ok {
+			return nil, UsageErrorf("sous set-version: %s is not deployed to %s", m.Source, sv.flags.cluster)
+		}
+		return []string{sv.flags.cluster}, nil
+	case len(deployed) == 0:
+		return nil, UsageErrorf("sous set-version: %s is not deployed to any cluster", m.Source)
+	case sv.flags.allClusters || len(deployed) == 1:
+		return deployed, nil
+	}
+	err := UsageErrorf("sous set-version: %s is deployed to %s",
+		m.Source, strings.Join(deployed, ", "))
+	err.Tip = "choose one with -cluster, or use -all-clusters"
+	return nil, err
+}
+
+// verify checks that there's an image for version of sl, with
+// sous.VerifyImageExists. When offline, it can only check the name cache, and
+// warns that the image itself wasn't checked.
+func (sv *SousSetVersion) verify(sl sous.SourceLocation, version semv.Version) cmdr.ErrorResult {
+	im := sv.im
+	if im == nil {
+		nc, err := configNameCache(sv.Config.Config, sv.DockerClient)
+		if err != nil {
+			return EnsureErrorResult(err)
+		}
+		defer nc.Close()
+		im = nc
+	}
+	sourceVersion := sous.SourceVersion{RepoURL: sl.RepoURL, RepoOffset: sl.RepoOffset, Version: version}
+	switch err := sous.VerifyImageExists(im, sourceVersion).(type) {
+	case nil:
+		return nil
+	case sous.OfflineError:
+		sv.ErrOut.Printfln("WARNING: offline, so the image for %s was found in the name cache, "+
+			"but not checked in its registry", sourceVersion)
+		return nil
+	case sous.NoImageNameFound:
+		errResult := UsageErrorf("sous set-version: no image found for %s", sourceVersion)
+		errResult.Tip = "build it first, or use -no-verify"
+		return errResult
+	case sous.ImageMissing:
+		errResult := UsageErrorf("sous set-version: %s", err)
+		errResult.Tip = "push it first, or use -no-verify"
+		return errResult
+	default:
+		return EnsureErrorResult(err)
+	}
+}
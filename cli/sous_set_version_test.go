@@ -0,0 +1,222 @@
+package cli
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+	"github.com/opentable/sous/util/docker_registry/registrytest"
+	"github.com/samsalisbury/semv"
+)
+
+var setVersionState = map[string]string{
+	"defs.yaml": validState["defs.yaml"],
+	"manifests/github.com/opentable/one.yaml": `
+Source: github.com/opentable/one
+Kind: http-service
+Deployments:
+  eu-west:
+    Version: 1.0.0
+    NumInstances: 1
+  us-west:
+    Version: 1.0.0
+    NumInstances: 2
+`,
+	"manifests/github.com/opentable/two/api.yaml": `
+Source: github.com/opentable/two,api
+Kind: http-service
+Deployments:
+  us-west:
+    Version: 1.0.0
+    NumInstances: 1
+`,
+	"manifests/github.com/opentable/two/web.yaml": `# hand written
+Source: github.com/opentable/two,web
+Kind: http-service
+Deployments:
+  us-west:
+    Version: 1.0.0
+    NumInstances: 1
+`,
+}
+
+func newTestSousSetVersion(t *testing.T, dir string, images ...sous.SourceVersion) (*SousSetVersion, *bytes.Buffer) {
+	var known []sous.ImageMapping
+	for _, sv := range images {
+		known = append(known, sous.ImageMapping{SourceVersion: sv, ImageName: "docker.example.com" + sv.DockerImageName()})
+	}
+	im, err := sous.NewStaticImageMapper(known...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	sv := &SousSetVersion{Out: Out{cmdr.NewOutput(out)}, ErrOut: ErrOut{cmdr.NewOutput(ioutil.Discard)}, im: im}
+	sv.flags.stateDir = dir
+	return sv, out
+}
+
+func manifestVersions(t *testing.T, dir string, sl sous.SourceLocation) map[string]string {
+	state, err := sous.LoadState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, m, ok := state.ManifestFor(sl)
+	if !ok {
+		t.Fatalf("no manifest for %s", sl)
+	}
+	versions := map[string]string{}
+	for cluster, spec := range m.Deployments {
+		versions[cluster] = spec.Version.String()
+	}
+	return versions
+}
+
+func TestSousSetVersion(t *testing.T) {
+	dir := writeStateDir(t, setVersionState)
+	defer os.RemoveAll(dir)
+	sv, out := newTestSousSetVersion(t, dir, sous.SourceVersion{
+		RepoURL: "github.com/opentable/one",
+		Version: semv.MustParse("2.3.1"),
+	})
+	sv.flags.cluster = "us-west"
+	sv.flags.source = "github.com/opentable/one"
+
+	r := sv.Execute([]string{"2.3.1"})
+	if r.ExitCode() != 0 {
+		t.Fatalf("got %T %v; want success", r, r)
+	}
+	want := "set github.com/opentable/one to 2.3.1 on us-west\n" +
+		"  modify " + filepath.FromSlash("manifests/github.com/opentable/one.yaml") + "\n"
+	if out.String() != want {
+		t.Errorf("got output:\n%s\nwant:\n%s", out, want)
+	}
+	versions := manifestVersions(t, dir, sous.SourceLocation{RepoURL: "github.com/opentable/one"})
+	if versions["us-west"] != "2.3.1" || versions["eu-west"] != "1.0.0" {
+		t.Errorf("got versions %v; want only us-west changed", versions)
+	}
+}
+
+func TestSousSetVersion_AllClusters(t *testing.T) {
+	dir := writeStateDir(t, setVersionState)
+	defer os.RemoveAll(dir)
+	sv, out := newTestSousSetVersion(t, dir)
+	sv.flags.allClusters = true
+	sv.flags.noVerify = true
+	sv.flags.source = "github.com/opentable/one"
+
+	if r := sv.Execute([]string{"2.3.1"}); r.ExitCode() != 0 {
+		t.Fatalf("got %T %v; want success", r, r)
+	}
+	if !strings.HasPrefix(out.String(), "set github.com/opentable/one to 2.3.1 on eu-west, us-west\n") {
+		t.Errorf("got output:\n%s", out)
+	}
+	versions := manifestVersions(t, dir, sous.SourceLocation{RepoURL: "github.com/opentable/one"})
+	if versions["us-west"] != "2.3.1" || versions["eu-west"] != "2.3.1" {
+		t.Errorf("got versions %v; want both changed", versions)
+	}
+}
+
+func TestSousSetVersion_OnlyOneCluster(t *testing.T) {
+	dir := writeStateDir(t, setVersionState)
+	defer os.RemoveAll(dir)
+	sv, out := newTestSousSetVersion(t, dir, sous.SourceVersion{
+		RepoURL:    "github.com/opentable/two",
+		RepoOffset: "api",
+		Version:    semv.MustParse("1.1.0"),
+	})
+	sv.flags.source = "github.com/opentable/two,api"
+
+	if r := sv.Execute([]string{"1.1.0"}); r.ExitCode() != 0 {
+		t.Fatalf("got %T %v; want success", r, r)
+	}
+	if strings.Contains(out.String(), "web.yaml") {
+		t.Errorf("got output:\n%s\nwant only api.yaml changed", out)
+	}
+	web, err := ioutil.ReadFile(filepath.Join(dir, "manifests/github.com/opentable/two/web.yaml"))
+	if err != nil || string(web) != setVersionState["manifests/github.com/opentable/two/web.yaml"] {
+		t.Errorf("other manifest was rewritten: %q, %v", web, err)
+	}
+	sl := sous.SourceLocation{RepoURL: "github.com/opentable/two", RepoOffset: "api"}
+	if v := manifestVersions(t, dir, sl)["us-west"]; v != "1.1.0" {
+		t.Errorf("got version %s; want 1.1.0", v)
+	}
+}
+
+func TestSousSetVersion_Errors(t *testing.T) {
+	dir := writeStateDir(t, setVersionState)
+	defer os.RemoveAll(dir)
+
+	for _, c := range []struct {
+		source, cluster string
+		args            []string
+		want            string
+	}{
+		{"github.com/opentable/one", "us-west", nil, "exactly one version"},
+		{"github.com/opentable/one", "us-west", []string{"latest"}, "not a version"},
+		{"", "us-west", []string{"2.3.1"}, "-source required"},
+		{"github.com/opentable/missing", "us-west", []string{"2.3.1"}, "no manifest"},
+		{"github.com/opentable/two", "us-west", []string{"2.3.1"},
+			"github.com/opentable/two:api, github.com/opentable/two:web"},
+		{"github.com/opentable/one", "", []string{"2.3.1"}, "deployed to eu-west, us-west"},
+		{"github.com/opentable/one", "nowhere", []string{"2.3.1"}, "no cluster named"},
+		{"github.com/opentable/two,api", "eu-west", []string{"2.3.1"}, "not deployed to eu-west"},
+		{"github.com/opentable/one", "us-west", []string{"2.3.1"}, "no image found"},
+	} {
+		sv, _ := newTestSousSetVersion(t, dir)
+		sv.flags.source, sv.flags.cluster = c.source, c.cluster
+		r := sv.Execute(c.args)
+		if r.ExitCode() != cmdr.EX_USAGE {
+			t.Errorf("%+v: got exit code %d (%v); want %d", c, r.ExitCode(), r, cmdr.EX_USAGE)
+			continue
+		}
+		if err, ok := r.(error); !ok || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%+v: got %v; want it to contain %q", c, r, c.want)
+		}
+	}
+	versions := manifestVersions(t, dir, sous.SourceLocation{RepoURL: "github.com/opentable/one"})
+	if versions["us-west"] != "1.0.0" {
+		t.Errorf("got versions %v after errors; want nothing changed", versions)
+	}
+}
+
+func TestSousSetVersion_ImageMissing(t *testing.T) {
+	dir := writeStateDir(t, setVersionState)
+	defer os.RemoveAll(dir)
+	sv, _ := newTestSousSetVersion(t, dir)
+	dc := registrytest.NewFake()
+	nc := sous.NewNameCache(dc, "sqlite3", sous.InMemoryConnection("setversionmissing"))
+	version := sous.SourceVersion{RepoURL: "github.com/opentable/one", Version: semv.MustParse("2.3.1")}
+	in := "docker.example.com/one:2.3.1"
+	if _, err := dc.Add(in, version.DockerLabels()); err != nil {
+		t.Fatal(err)
+	}
+	if err := nc.Insert(version, in, "etag"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dc.Delete(in); err != nil {
+		t.Fatal(err)
+	}
+	sv.im = nc
+	sv.flags.source, sv.flags.cluster = "github.com/opentable/one", "us-west"
+
+	r := sv.Execute([]string{"2.3.1"})
+	if r.ExitCode() != cmdr.EX_USAGE {
+		t.Fatalf("got exit code %d (%v); want %d", r.ExitCode(), r, cmdr.EX_USAGE)
+	}
+	if err, ok := r.(error); !ok || !strings.Contains(err.Error(), "isn't in its registry") {
+		t.Errorf("got %v; want a missing image", r)
+	}
+	if v := manifestVersions(t, dir, sous.SourceLocation{RepoURL: "github.com/opentable/one"})["us-west"]; v != "1.0.0" {
+		t.Errorf("got version %s; want nothing changed", v)
+	}
+
+	sv.flags.noVerify = true
+	if r := sv.Execute([]string{"2.3.1"}); r.ExitCode() != 0 {
+		t.Errorf("got %T %v with -no-verify; want success", r, r)
+	}
+}
@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"os"
+
+	"github.com/opentable/sous/util/cmdr"
+	"github.com/opentable/sous/util/hy"
+)
+
+// SousState is the description of the `sous state` command
+type SousState struct{}
+
+// StateSubcommands are the subcommands of `sous state`
+var StateSubcommands = cmdr.Commands{}
+
+func init() { TopLevelCommands["state"] = &SousState{} }
+
+const sousStateHelp = `
+inspect the global deploy manifest
+
+args: <command>
+
+state commands read a state directory, containing defs.yaml and manifests/,
+without contacting any cluster. If the directory is not given, it is taken
+from -state-dir, $SOUS_STATE_DIR, or the nearest directory above the working
+directory containing defs.yaml or .sous-state.
+`
+
+func (*SousState) Help() string { return sousStateHelp }
+
+func (SousState) Subcommands() cmdr.Commands {
+	return StateSubcommands
+}
+
+func (*SousState) Execute(args []string) cmdr.Result {
+	err := UsageErrorf("usage: sous state [options] command")
+	err.Tip = "try `sous help state` for a list of commands"
+	return err
+}
+
+// stateLoadError turns an error from sous.LoadState into a result: failing
+// to read dir or the files in it is an IO error, and anything else means the
+// state itself is invalid.
+func stateLoadError(dir string, err error) cmdr.ErrorResult {
+	if isIOError(err) {
+		return IOErrorf("unable to read state from %s: %s", dir, err)
+	}
+	return DataErrorf("invalid state in %s:\n%s", dir, err)
+}
+
+func isIOError(err error) bool {
+	switch err := err.(type) {
+	case *os.PathError:
+		return true
+	case hy.Errors:
+		for _, e := range err {
+			if _, ok := e.Cause.(*os.PathError); !ok {
+				return false
+			}
+		}
+		return len(err) != 0
+	}
+	return false
+}
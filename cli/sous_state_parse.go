@@ -0,0 +1,141 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+	"github.com/opentable/sous/util/yaml"
+)
+
+// SousStateParse is the description of the `sous state parse` command
+type SousStateParse struct {
+	Sink  OutputSink
+	flags struct {
+		format, output, stateDir string
+		partial                  bool
+	}
+}
+
+func init() { StateSubcommands["parse"] = &SousStateParse{} }
+
+const sousStateParseHelp = `
+parse a state directory and print it
+
+args: [<dir>]
+
+parse reads the state in dir and prints it in the format given by -format:
+yaml (the default), json, or summary, which lists the names of manifests and
+clusters. The global -json flag is the same as -format json. If the state
+cannot be read the exit code is 74; if it can be read but not parsed, the exit
+code is 65.
+
+Every file that can't be parsed is reported, not just the first. With
+-partial, the state read from the rest is printed anyway, and the problems
+are listed on stderr as "path:line: message", still with exit code 65.
+`
+
+// Help prints the help
+func (*SousStateParse) Help() string { return sousStateParseHelp }
+
+func (sp *SousStateParse) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&sp.flags.format, "format", "yaml",
+		"output format: json, yaml or summary")
+	fs.StringVar(&sp.flags.output, "o", "",
+		"write output to this file instead of stdout")
+	fs.BoolVar(&sp.flags.partial, "partial", false,
+		"print what can be parsed even if some files can't be")
+	addStateDirFlag(fs, &sp.flags.stateDir)
+}
+
+// Execute defines the behavior of `sous state parse`
+func (sp *SousStateParse) Execute(args []string) cmdr.Result {
+	if len(args) > 1 {
+		return UsageErrorf("sous state parse: at most one state directory allowed")
+	}
+	dir, errResult := stateDir(stateDirArg(args), sp.flags.stateDir)
+	if errResult != nil {
+		return errResult
+	}
+	format := stateFormats[sp.flags.format]
+	if sp.Sink.JSON {
+		format = stateFormats["json"]
+	}
+	if format == nil {
+		return UsageErrorf("sous state parse: unknown format %q; want json, yaml or summary", sp.flags.format)
+	}
+
+	state, err := sous.LoadState(dir)
+	problems, partial := sous.ParseProblems(err)
+	if err != nil && (!sp.flags.partial || !partial || isIOError(err)) {
+		return stateLoadError(dir, err)
+	}
+	b, err := format(&state)
+	if err != nil {
+		return InternalErrorf("unable to format state: %s", err)
+	}
+	if len(problems) != 0 {
+		return sp.partialResult(dir, b, problems)
+	}
+	if sp.flags.output == "" {
+		return SuccessData(b)
+	}
+	if err := ioutil.WriteFile(sp.flags.output, b, 0644); err != nil {
+		return IOErrorf("unable to write %s: %s", sp.flags.output, err)
+	}
+	return SuccessData(nil)
+}
+
+// partialResult writes b, the state that could be parsed, and lists the
+// problems with the rest.
+func (sp *SousStateParse) partialResult(dir string, b []byte, problems []sous.StateProblem) cmdr.Result {
+	for _, p := range problems {
+		fmt.Fprintln(sp.Sink.ErrOut, p)
+	}
+	if sp.flags.output == "" {
+		sp.Sink.Out.Write(b)
+	} else if err := ioutil.WriteFile(sp.flags.output, b, 0644); err != nil {
+		return IOErrorf("unable to write %s: %s", sp.flags.output, err)
+	}
+	return DataErrorf("found %d problems in %s", len(problems), dir)
+}
+
+var stateFormats = map[string]func(*sous.State) ([]byte, error){
+	"yaml": func(s *sous.State) ([]byte, error) {
+		return yaml.Marshal(s)
+	},
+	"json": func(s *sous.State) ([]byte, error) {
+		b, err := json.MarshalIndent(s, "", "  ")
+		return append(b, '\n'), err
+	},
+	"summary": stateSummary,
+}
+
+// stateSummary lists the names of the manifests and clusters in s.
+func stateSummary(s *sous.State) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	manifests := make([]string, 0, len(s.Manifests))
+	for name := range s.Manifests {
+		manifests = append(manifests, name)
+	}
+	clusters := make([]string, 0, len(s.Defs.Clusters))
+	for name := range s.Defs.Clusters {
+		clusters = append(clusters, name)
+	}
+	for _, section := range []struct {
+		title string
+		names []string
+	}{{"manifests", manifests}, {"clusters", clusters}} {
+		sort.Strings(section.names)
+		fmt.Fprintf(buf, "%s: %d\n", section.title, len(section.names))
+		for _, name := range section.names {
+			fmt.Fprintf(buf, "  %s\n", name)
+		}
+	}
+	return buf.Bytes(), nil
+}
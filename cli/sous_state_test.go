@@ -0,0 +1,399 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+	"github.com/samsalisbury/semv"
+)
+
+func writeStateDir(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "sous-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+var validState = map[string]string{
+	"defs.yaml": `
+Clusters:
+  us-west:
+    Kind: singularity
+    BaseURL: http://singularity.example.com
+  eu-west:
+    Kind: singularity
+    BaseURL: http://singularity.example.eu
+`,
+	"manifests/github.com/opentable/one.yaml": `
+Source: github.com/opentable/one
+Kind: http-service
+`,
+}
+
+func TestSousStateParse_Summary(t *testing.T) {
+	dir := writeStateDir(t, validState)
+	defer os.RemoveAll(dir)
+
+	sp := &SousStateParse{}
+	sp.flags.format = "summary"
+	r := sp.Execute([]string{dir})
+	success, ok := r.(cmdr.SuccessResult)
+	if !ok {
+		t.Fatalf("got %T %v; want success", r, r)
+	}
+	want := "manifests: 1\n  github.com/opentable/one\nclusters: 2\n  eu-west\n  us-west\n"
+	if got := success.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSousStateParse_Output(t *testing.T) {
+	dir := writeStateDir(t, validState)
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out.json")
+
+	sp := &SousStateParse{}
+	sp.flags.format = "json"
+	sp.flags.output = out
+	r := sp.Execute([]string{dir})
+	if success, ok := r.(cmdr.SuccessResult); !ok || len(success.Data) != 0 {
+		t.Fatalf("got %T %v; want success with no data", r, r)
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"BaseURL": "http://singularity.example.eu"`) {
+		t.Errorf("%s does not contain the state:\n%s", out, b)
+	}
+}
+
+func TestSousStateParse_ExitCodes(t *testing.T) {
+	invalid := writeStateDir(t, map[string]string{
+		"defs.yaml": "Clusters: [\n",
+	})
+	defer os.RemoveAll(invalid)
+
+	cases := []struct {
+		format, dir string
+		want        int
+	}{
+		{"yaml", filepath.Join(invalid, "missing"), cmdr.EX_IOERR},
+		{"yaml", invalid, cmdr.EX_DATAERR},
+		{"xml", invalid, cmdr.EX_USAGE},
+	}
+	for _, c := range cases {
+		sp := &SousStateParse{}
+		sp.flags.format = c.format
+		if got := sp.Execute([]string{c.dir}).ExitCode(); got != c.want {
+			t.Errorf("parsing %s as %s: got exit code %d; want %d", c.dir, c.format, got, c.want)
+		}
+	}
+}
+
+func TestSousStateParse_UndefinedCluster(t *testing.T) {
+	dir := writeStateDir(t, map[string]string{
+		"defs.yaml": validState["defs.yaml"],
+		"manifests/github.com/opentable/wrong.yaml": `
+Source: github.com/opentable/wrong
+Kind: http-service
+Deployments:
+  mars:
+    Version: 1.0.0
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	sp := &SousStateParse{}
+	sp.flags.format = "yaml"
+	res := sp.Execute([]string{dir})
+	if got := res.ExitCode(); got != cmdr.EX_DATAERR {
+		t.Fatalf("got exit code %d; want %d", got, cmdr.EX_DATAERR)
+	}
+	want := `manifest github.com/opentable/wrong deploys to undefined cluster "mars"`
+	if msg := res.(cmdr.ErrorResult).Error(); !strings.Contains(msg, want) {
+		t.Errorf("got %q; want it to contain %q", msg, want)
+	}
+}
+
+func TestSousStateParse_Partial(t *testing.T) {
+	dir := writeStateDir(t, map[string]string{
+		"defs.yaml": validState["defs.yaml"],
+		"manifests/github.com/opentable/one.yaml":     validState["manifests/github.com/opentable/one.yaml"],
+		"manifests/github.com/opentable/broken.yaml":  "Source: [\n",
+		"manifests/github.com/opentable/broken2.yaml": "Kind: http-service\nKind: [oops\n",
+	})
+	defer os.RemoveAll(dir)
+
+	out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+	sink := testSink(out, false)
+	sink.ErrOut = cmdr.NewOutput(errOut)
+
+	sp := &SousStateParse{Sink: sink}
+	sp.flags.format = "summary"
+	r := sp.Execute([]string{dir})
+	if r.ExitCode() != cmdr.EX_DATAERR {
+		t.Errorf("got exit code %d; want %d", r.ExitCode(), cmdr.EX_DATAERR)
+	}
+	for _, file := range []string{"broken.yaml", "broken2.yaml"} {
+		if !strings.Contains(fmt.Sprint(r), file) {
+			t.Errorf("error doesn't mention %s:\n%v", file, r)
+		}
+	}
+	if out.Len() != 0 {
+		t.Errorf("printed %q without -partial", out)
+	}
+
+	sp.flags.partial = true
+	r = sp.Execute([]string{dir})
+	if r.ExitCode() != cmdr.EX_DATAERR {
+		t.Errorf("got exit code %d with -partial; want %d", r.ExitCode(), cmdr.EX_DATAERR)
+	}
+	if want := "manifests: 1\n  github.com/opentable/one\n"; !strings.HasPrefix(out.String(), want) {
+		t.Errorf("got %q; want it to begin %q", out, want)
+	}
+	lines := strings.Split(strings.TrimSpace(errOut.String()), "\n")
+	if len(lines) != 2 ||
+		!strings.HasPrefix(lines[0], "manifests/github.com/opentable/broken.yaml:") ||
+		!strings.HasPrefix(lines[1], "manifests/github.com/opentable/broken2.yaml:2: ") {
+		t.Errorf("got problems:\n%s", errOut)
+	}
+
+	sp.flags.partial = true
+	if code := sp.Execute([]string{filepath.Join(dir, "missing")}).ExitCode(); code != cmdr.EX_IOERR {
+		t.Errorf("got exit code %d for a missing dir with -partial; want %d", code, cmdr.EX_IOERR)
+	}
+}
+
+func TestSousStateValidate(t *testing.T) {
+	dir := writeStateDir(t, map[string]string{
+		"defs.yaml": validState["defs.yaml"],
+		"manifests/github.com/opentable/good.yaml": `
+Source: github.com/opentable/good
+Kind: http-service
+Deployments:
+  us-west:
+    Resources: {cpus: "0.1", memory: "100"}
+    Version: 1.0.0
+`,
+		"manifests/github.com/opentable/typo.yaml": `
+Source: github.com/opentable/typo
+Kind: http-service
+Onwers: [sam]
+`,
+		"manifests/github.com/opentable/bad-version.yaml": `
+Source: github.com/opentable/bad-version
+Kind: http-service
+Deployments:
+  us-west:
+    Version: not.a.version
+`,
+		"manifests/github.com/opentable/no-kind.yaml": `
+Source: github.com/opentable/no-kind
+`,
+		"manifests/github.com/opentable/no-schedule.yaml": `
+Source: github.com/opentable/no-schedule
+Kind: scheduled
+`,
+		"manifests/github.com/opentable/wrong.yaml": `
+Source: github.com/opentable/wrong
+Kind: http-service
+Deployments:
+  mars:
+    Version: 1.0.0
+  eu-west:
+    Resources: {cpus: lots, memory: "100"}
+    Version: 1.0.0
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	buf := &bytes.Buffer{}
+	sv := &SousStateValidate{Sink: testSink(buf, false)}
+	r := sv.Execute([]string{dir})
+	if r.ExitCode() != cmdr.EX_DATAERR {
+		t.Errorf("got exit code %d; want %d", r.ExitCode(), cmdr.EX_DATAERR)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []string{
+		"manifests/github.com/opentable/bad-version.yaml:",
+		"manifests/github.com/opentable/no-schedule.yaml: Kind scheduled requires a Schedule",
+		"manifests/github.com/opentable/typo.yaml:",
+		`manifests/github.com/opentable/wrong.yaml: deployment to undefined cluster "mars"`,
+		`manifests/github.com/opentable/wrong.yaml: cluster eu-west: resource "cpus" is not numeric`,
+	}
+	if len(lines) != len(want) {
+		t.Errorf("got %d problems; want %d:\n%s", len(lines), len(want), buf)
+	}
+	for _, w := range want {
+		found := false
+		for _, l := range lines {
+			found = found || strings.HasPrefix(l, w)
+		}
+		if !found {
+			t.Errorf("no problem starting %q in:\n%s", w, buf)
+		}
+	}
+}
+
+func TestSousStateValidate_JSON(t *testing.T) {
+	dir := writeStateDir(t, map[string]string{
+		"defs.yaml": "Clusters: {}\nDockerRpeo: typo\n",
+	})
+	defer os.RemoveAll(dir)
+
+	buf := &bytes.Buffer{}
+	sv := &SousStateValidate{Sink: testSink(buf, true)}
+	if code := sv.Execute([]string{dir}).ExitCode(); code != cmdr.EX_DATAERR {
+		t.Errorf("got exit code %d; want %d", code, cmdr.EX_DATAERR)
+	}
+	problems := []sous.StateProblem{}
+	if err := json.Unmarshal(buf.Bytes(), &problems); err != nil {
+		t.Fatalf("%s:\n%s", err, buf)
+	}
+	if len(problems) != 1 || problems[0].File != "defs.yaml" {
+		t.Errorf("got problems %+v; want one in defs.yaml", problems)
+	}
+
+	valid := writeStateDir(t, validState)
+	defer os.RemoveAll(valid)
+	buf.Reset()
+	if code := sv.Execute([]string{valid}).ExitCode(); code != cmdr.EX_OK {
+		t.Errorf("got exit code %d for valid state; want 0:\n%s", code, buf)
+	}
+}
+
+func TestSousStateValidate_Provenance(t *testing.T) {
+	dir := writeStateDir(t, map[string]string{
+		"defs.yaml": validState["defs.yaml"],
+		"manifests/github.com/opentable/inherits.yaml": `
+Source: github.com/opentable/inherits
+Kind: http-service
+Deployments:
+  Global:
+    Resources: {cpus: lots, memory: "100"}
+    Env: {DEBUG: "1"}
+    Version: 1.0.0
+  us-west:
+    Env: {DEBUG: null}
+    Version: 1.0.0
+  eu-west:
+    Resources: {memory: "1"}
+    Env: {"bad name": x}
+    Version: 1.0.0
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	buf := &bytes.Buffer{}
+	sv := &SousStateValidate{Sink: testSink(buf, false)}
+	if code := sv.Execute([]string{dir}).ExitCode(); code != cmdr.EX_DATAERR {
+		t.Errorf("got exit code %d; want %d", code, cmdr.EX_DATAERR)
+	}
+	file := "manifests/github.com/opentable/inherits.yaml: "
+	want := []string{
+		file + `cluster eu-west: resource "cpus" is not numeric: "lots" (from Deployments.Global)`,
+		file + `cluster eu-west: env var name "bad name" is not legal (from Deployments.eu-west)`,
+		file + `cluster us-west: resource "cpus" is not numeric: "lots" (from Deployments.Global)`,
+	}
+	got := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got problems:\n%s\nwant:\n%s", buf, strings.Join(want, "\n"))
+	}
+}
+
+func TestSousStateValidate_Quotas(t *testing.T) {
+	dir := writeStateDir(t, map[string]string{
+		"defs.yaml": validState["defs.yaml"] + `    Capacity: {CPUs: 1, MaxInstances: 3}
+`,
+		"manifests/github.com/opentable/one.yaml": `
+Source: github.com/opentable/one
+Kind: http-service
+Deployments:
+  us-west:
+    Resources: {cpus: "0.5", memory: "100"}
+    Version: 1.0.0
+    NumInstances: 2
+  eu-west:
+    Resources: {cpus: "0.5", memory: "100"}
+    Version: 1.0.0
+    NumInstances: 4
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	buf := &bytes.Buffer{}
+	sv := &SousStateValidate{Sink: testSink(buf, false)}
+	if code := sv.Execute([]string{dir}).ExitCode(); code != cmdr.EX_DATAERR {
+		t.Errorf("got exit code %d; want %d", code, cmdr.EX_DATAERR)
+	}
+	want := "defs.yaml: quota of cluster eu-west exceeded: 2 cpus, more than the limit of 1, " +
+		"and 1 deployments with more than the limit of 3 instances; " +
+		"most by github.com/opentable/one (4 instances, 2 cpus, 400 MB)\n"
+	if buf.String() != want {
+		t.Errorf("got problems:\n%s\nwant:\n%s", buf, want)
+	}
+}
+
+func TestSousStateValidate_CheckImages(t *testing.T) {
+	dir := writeStateDir(t, map[string]string{
+		"defs.yaml": validState["defs.yaml"],
+		"manifests/github.com/opentable/one.yaml": `
+Source: github.com/opentable/one
+Kind: http-service
+Deployments:
+  us-west:
+    Resources: {cpus: "0.1", memory: "100"}
+    Version: 1.0.0
+  eu-west:
+    Resources: {cpus: "0.1", memory: "100"}
+    Version: 1.1.0
+`,
+	})
+	defer os.RemoveAll(dir)
+	im, err := sous.NewStaticImageMapper(sous.ImageMapping{
+		SourceVersion: sous.SourceVersion{RepoURL: "github.com/opentable/one", Version: semv.MustParse("1.0.0")},
+		ImageName:     "docker.example.com/one:1.0.0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	sv := &SousStateValidate{Sink: testSink(buf, false), im: im}
+	if code := sv.Execute([]string{dir}).ExitCode(); code != cmdr.EX_OK {
+		t.Errorf("got exit code %d without -check-images; want 0:\n%s", code, buf)
+	}
+
+	buf.Reset()
+	sv.flags.checkImages, sv.flags.parallel = true, 2
+	if code := sv.Execute([]string{dir}).ExitCode(); code != cmdr.EX_DATAERR {
+		t.Errorf("got exit code %d; want %d", code, cmdr.EX_DATAERR)
+	}
+	want := []string{
+		"manifests/github.com/opentable/one.yaml: cluster eu-west: No image name for github.com/opentable/one 1.1.0",
+		"1 of 2 images missing",
+	}
+	got := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got:\n%s\nwant:\n%s", buf, strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,146 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/cmdr"
+)
+
+// SousStateValidate is the description of the `sous state validate` command
+type SousStateValidate struct {
+	Config       LocalSousConfig
+	DockerClient LocalDockerClient
+	Sink         OutputSink
+	// im is used by -check-images, and is the name cache from config
+	// unless set by tests
+	im    sous.ImageMapper
+	flags struct {
+		stateDir    string
+		checkImages bool
+		parallel    int
+	}
+}
+
+func init() { StateSubcommands["validate"] = &SousStateValidate{} }
+
+const sousStateValidateHelp = `
+check a state directory for problems
+
+args: [<dir>]
+
+validate reads the state in dir, rejecting unknown fields, and checks every
+manifest and the deployments it describes, and that those deployments fit
+the Capacity and OwnerQuotas of their clusters, as rectify does. It prints each problem found as
+"path: message", or with the global -json flag as a JSON array of objects with "file", "line"
+and "message" fields, and exits with code 65 if there were any.
+
+With -check-images, it also checks that every version deployed has an image
+in its registry, as set-version does, checking -parallel versions at once,
+and reports each that doesn't as a problem, followed by a count of those
+missing. If Offline is set in your config, or $SOUS_OFFLINE, images are only
+looked up in the name cache, with a warning.
+`
+
+// Help prints the help
+func (*SousStateValidate) Help() string { return sousStateValidateHelp }
+
+func (sv *SousStateValidate) AddFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&sv.flags.checkImages, "check-images", false,
+		"check that every version deployed has an image in its registry")
+	fs.IntVar(&sv.flags.parallel, "parallel", 4,
+		"with -check-images, how many images to check at once")
+	addStateDirFlag(fs, &sv.flags.stateDir)
+}
+
+// Execute defines the behavior of `sous state validate`
+func (sv *SousStateValidate) Execute(args []string) cmdr.Result {
+	if len(args) > 1 {
+		return UsageErrorf("sous state validate: at most one state directory allowed")
+	}
+	if sv.flags.checkImages && sv.flags.parallel < 1 {
+		return UsageErrorf("sous state validate: -parallel must be at least 1")
+	}
+	dir, errResult := stateDir(stateDirArg(args), sv.flags.stateDir)
+	if errResult != nil {
+		return errResult
+	}
+
+	problems, err := sous.ValidateState(dir)
+	if err != nil {
+		return IOErrorf("unable to read state from %s: %s", dir, err)
+	}
+	var images *sous.ImageReport
+	if sv.flags.checkImages {
+		if images, errResult = sv.checkImages(dir); errResult != nil {
+			return errResult
+		}
+		if images != nil {
+			problems = append(problems, images.Missing...)
+		}
+	}
+	errResult = sv.Sink.Result(problems, func(w io.Writer) {
+		for _, p := range problems {
+			fmt.Fprintln(w, p)
+		}
+	})
+	if errResult != nil {
+		return errResult
+	}
+	unchecked := 0
+	if images != nil {
+		unchecked = sv.summarizeImages(*images)
+	}
+	if len(problems) != 0 {
+		return DataErrorf("found %d problems in %s", len(problems), dir)
+	}
+	if unchecked != 0 {
+		return IOErrorf("unable to check %d images", unchecked)
+	}
+	return SuccessData(nil)
+}
+
+// checkImages checks that each version deployed by the state in dir has an
+// image. It returns nil if the state can't be read well enough to tell what's
+// deployed, the problems with which ValidateState has already found.
+func (sv *SousStateValidate) checkImages(dir string) (*sous.ImageReport, cmdr.ErrorResult) {
+	state, err := sous.LoadState(dir)
+	if _, ok := err.(sous.UndefinedClusters); err != nil && !ok {
+		sv.Sink.Infof("images not checked: the state can't be read")
+		return nil, nil
+	}
+	im := sv.im
+	if im == nil {
+		nc, err := configNameCache(sv.Config.Config, sv.DockerClient)
+		if err != nil {
+			return nil, EnsureErrorResult(err)
+		}
+		defer nc.Close()
+		im = nc
+	}
+	r := state.CheckImages(dir, im, sv.flags.parallel)
+	return &r, nil
+}
+
+// summarizeImages reports the images of r that couldn't be checked, and
+// counts those missing. It returns the number that couldn't be checked,
+// other than because of being offline, which is only warned about.
+func (sv *SousStateValidate) summarizeImages(r sous.ImageReport) int {
+	offline, unchecked := 0, 0
+	for _, err := range r.Errs {
+		if _, ok := err.(sous.OfflineError); ok {
+			offline++
+			continue
+		}
+		unchecked++
+		sv.Sink.Error(err)
+	}
+	sv.Sink.Infof("%d of %d images missing", len(r.Missing), r.Checked)
+	if offline != 0 {
+		sv.Sink.Infof("WARNING: offline, so %d images were found in the name cache, "+
+			"but not checked in their registries", offline)
+	}
+	return unchecked
+}
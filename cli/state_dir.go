@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/opentable/sous/util/cmdr"
+	"github.com/opentable/sous/util/resolve"
+)
+
+// StateDirEnv is the environment variable naming the state directory, used
+// when it isn't given on the command line.
+const StateDirEnv = "SOUS_STATE_DIR"
+
+// stateDirMarkers are the files that mark a directory as a state directory
+// when looking for one above the working directory.
+var stateDirMarkers = []string{".sous-state", "defs.yaml"}
+
+// addStateDirFlag adds the -state-dir flag shared by commands that read a
+// state directory.
+func addStateDirFlag(fs *flag.FlagSet, dir *string) {
+	fs.StringVar(dir, "state-dir", "",
+		"the state directory to use (default $"+StateDirEnv+
+			", or the nearest directory above this one containing defs.yaml)")
+}
+
+// stateDir works out which state directory a command should use. It is, in
+// order of precedence: arg, the -state-dir flag value, $SOUS_STATE_DIR, or
+// the working directory or the nearest of its parents containing one of
+// stateDirMarkers. If none of those yields a directory, the usage error
+// returned lists each place that was tried. The directory returned is
+// absolute, with ~ and environment variables expanded, so that paths can be
+// resolved against it.
+func stateDir(arg, flagValue string) (string, cmdr.ErrorResult) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", IOErrorf("unable to get working directory: %s", err)
+	}
+	dir, tried := findStateDir(arg, flagValue, os.Getenv(StateDirEnv), wd)
+	if dir == "" {
+		return "", UsageErrorf("no state directory found; tried:\n  %s",
+			strings.Join(tried, "\n  "))
+	}
+	abs, err := resolve.NewResolver(wd, nil).Resolve(dir)
+	if err != nil {
+		return "", UsageErrorf("state directory %s: %s", dir, err)
+	}
+	return abs, nil
+}
+
+// findStateDir implements stateDir. If it finds no directory it returns the
+// places it tried.
+func findStateDir(arg, flagValue, env, wd string) (string, []string) {
+	switch {
+	case arg != "":
+		return arg, nil
+	case flagValue != "":
+		return flagValue, nil
+	case env != "":
+		return env, nil
+	}
+	tried := []string{
+		"a directory argument (none given)",
+		"the -state-dir flag (not set)",
+		"$" + StateDirEnv + " (not set)",
+	}
+	for dir := wd; ; dir = filepath.Dir(dir) {
+		for _, m := range stateDirMarkers {
+			path := filepath.Join(dir, m)
+			if _, err := os.Stat(path); err == nil {
+				return dir, nil
+			}
+			tried = append(tried, path)
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			return "", tried
+		}
+	}
+}
+
+// stateDirArg returns args[0], or "" if args is empty.
+func stateDirArg(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0]
+}
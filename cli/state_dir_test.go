@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindStateDir_Precedence(t *testing.T) {
+	cases := []struct{ arg, flag, env, want string }{
+		{"arg", "flag", "env", "arg"},
+		{"", "flag", "env", "flag"},
+		{"", "", "env", "env"},
+	}
+	for _, c := range cases {
+		got, _ := findStateDir(c.arg, c.flag, c.env, "/nonexistent")
+		if got != c.want {
+			t.Errorf("findStateDir(%q, %q, %q) = %q; want %q", c.arg, c.flag, c.env, got, c.want)
+		}
+	}
+}
+
+func TestFindStateDir_Discovery(t *testing.T) {
+	dir := writeStateDir(t, map[string]string{
+		"state/defs.yaml":         "{}\n",
+		"state/manifests/a/.keep": "",
+		"other/.sous-state":       "",
+	})
+	defer os.RemoveAll(dir)
+
+	for wd, want := range map[string]string{
+		"state":             "state",
+		"state/manifests/a": "state",
+		"other":             "other",
+	} {
+		got, tried := findStateDir("", "", "", filepath.Join(dir, wd))
+		if got != filepath.Join(dir, want) {
+			t.Errorf("from %s found %q; want %s (tried %v)", wd, got, want, tried)
+		}
+	}
+}
+
+func TestFindStateDir_ReportsTried(t *testing.T) {
+	dir := writeStateDir(t, map[string]string{"a/b/.keep": ""})
+	defer os.RemoveAll(dir)
+	wd := filepath.Join(dir, "a/b")
+
+	got, tried := findStateDir("", "", "", wd)
+	if got != "" {
+		// a marker above the temp dir; nothing to test here
+		t.Skipf("found state directory %s", got)
+	}
+	all := strings.Join(tried, "\n")
+	for _, want := range []string{
+		"-state-dir", StateDirEnv,
+		filepath.Join(wd, "defs.yaml"),
+		filepath.Join(dir, "a", ".sous-state"),
+		filepath.Join("/", "defs.yaml"),
+	} {
+		if !strings.Contains(all, want) {
+			t.Errorf("tried list doesn't mention %s:\n%s", want, all)
+		}
+	}
+}
@@ -19,10 +19,10 @@ func TestSous(t *testing.T) {
 
 	log.Print(term.Stderr)
 	term.Stdout.ShouldHaveNumLines(0)
-	term.Stderr.ShouldHaveNumLines(20)
+	term.Stderr.ShouldHaveNumLines(40)
 
 	term.Stderr.ShouldHaveExactLine("usage: sous <command>")
-	term.Stderr.ShouldHaveLineContaining("help     get help with sous")
+	term.Stderr.ShouldHaveLineContaining("help            get help with sous")
 }
 
 func TestSousVersion(t *testing.T) {
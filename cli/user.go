@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 
 	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/resolve"
 )
 
 type (
@@ -19,28 +20,58 @@ const (
 	defaultConfigDir = "sous"
 	xdgConfigDefault = ".config"
 	configFileBase   = "config.yaml"
+	cacheDBBase      = "data.db"
+	previewsBase     = "previews.json"
 )
 
 // DefaultConfig builds a default configuration for this user
 func (u *User) DefaultConfig() sous.Config {
 	c := sous.DefaultConfig()
-	c.DatabaseConnection = filepath.Join(u.ConfigDir(), "data.db")
+	c.DatabaseConnection = filepath.Join(u.CacheDir(), cacheDBBase)
+	c.PreviewLedger = filepath.Join(u.ConfigDir(), previewsBase)
 	return c
 }
 
-// ConfigDir returns the directory we should use to store Sous configuration data
+// ConfigDir returns the directory we should use to store Sous configuration
+// data: $SOUS_CONFIG_DIR if it's set, or else sous in the user configuration
+// directory of the platform, unless the configuration is still in the
+// directory it used to be kept in everywhere, ~/.config/sous.
 func (u *User) ConfigDir() string {
 	if sd := os.Getenv("SOUS_CONFIG_DIR"); sd != "" {
+		if d, err := resolve.Resolve(sd); err == nil {
+			return d
+		}
 		return sd
 	}
-	xdgConfig := os.Getenv("XDG_CONFIG")
-	if xdgConfig == "" {
-		xdgConfig = filepath.Join(u.HomeDir, xdgConfigDefault)
+	legacy := filepath.Join(u.HomeDir, xdgConfigDefault, defaultConfigDir)
+	d, err := resolve.ConfigDir()
+	if err != nil {
+		return legacy
 	}
-	return filepath.Join(xdgConfig, defaultConfigDir)
+	d = filepath.Join(d, defaultConfigDir)
+	if d != legacy && !exists(d) && exists(legacy) {
+		return legacy
+	}
+	return d
+}
+
+// CacheDir returns the directory we should use to store the files Sous
+// caches, such as the name cache: sous in the user cache directory of the
+// platform.
+func (u *User) CacheDir() string {
+	d, err := resolve.CacheDir()
+	if err != nil {
+		return u.ConfigDir()
+	}
+	return filepath.Join(d, defaultConfigDir)
 }
 
 // ConfigFile returns the path to the local Sous config file
 func (u *User) ConfigFile() string {
 	return filepath.Join(u.ConfigDir(), configFileBase)
 }
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -13,8 +13,14 @@
 package storage
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
 	sous "github.com/opentable/sous/lib"
 	"github.com/opentable/sous/util/hy"
+	"github.com/opentable/sous/util/yaml"
 )
 
 // ReadState loads the state of the world from a dir
@@ -23,7 +29,75 @@ func ReadState(dir string) (*sous.State, error) {
 	return s, hy.Unmarshal(dir, s)
 }
 
-// WriteState records the state of the world to a dir
+// UnparseableState is returned by WriteState when the state already in Dir
+// can't be parsed, which may mean it's part way through being edited.
+type UnparseableState struct {
+	Dir string
+	Err error
+}
+
+func (e UnparseableState) Error() string {
+	return fmt.Sprintf("not overwriting the state in %s, which doesn't parse: %s", e.Dir, e.Err)
+}
+
+// WriteState records the state of the world to a dir, creating, modifying
+// and deleting files so that it holds exactly s. Files are only touched if
+// their content changes, and the same state is always written the same way.
+// If the state already in dir doesn't parse, WriteState returns
+// UnparseableState rather than overwrite it: see ForceWriteState.
 func WriteState(dir string, s *sous.State) error {
+	_, err := sous.LoadState(dir)
+	if _, undefined := err.(sous.UndefinedClusters); err != nil && !undefined && !isNotExist(err) {
+		return UnparseableState{Dir: dir, Err: err}
+	}
+	return hy.Marshal(dir, s)
+}
+
+// ForceWriteState is like WriteState, but overwrites the state in dir even
+// if it doesn't parse.
+func ForceWriteState(dir string, s *sous.State) error {
 	return hy.Marshal(dir, s)
 }
+
+// isNotExist is true if err, returned by LoadState, is only that files are
+// missing, so there's no state to overwrite.
+func isNotExist(err error) bool {
+	es, ok := err.(hy.Errors)
+	if !ok {
+		return os.IsNotExist(err)
+	}
+	for _, e := range es {
+		if !os.IsNotExist(e.Cause) {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteManifest records only the manifest with the given key in s.Manifests
+// to a dir, leaving the files for everything else in s as they are.
+func WriteManifest(dir string, s *sous.State, name string) error {
+	p, err := PlanManifest(dir, s, name)
+	if err != nil {
+		return err
+	}
+	return p.Apply()
+}
+
+// PlanManifest returns the changes WriteManifest would make, without making
+// them, so that they can be reported before they're applied.
+func PlanManifest(dir string, s *sous.State, name string) (*hy.Plan, error) {
+	p, err := hy.NewMarshaller(yaml.Marshal).Plan(dir, s)
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Join(dir, "manifests", filepath.FromSlash(name)) + "."
+	changes := p.Changes[:0]
+	for _, c := range p.Changes {
+		if strings.HasPrefix(c.Path, prefix) && !strings.ContainsRune(c.Path[len(prefix):], filepath.Separator) {
+			changes = append(changes, c)
+		}
+	}
+	p.Changes = changes
+	return p, nil
+}
@@ -1,7 +1,13 @@
 package storage
 
 import (
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
 	"testing"
 
 	"github.com/opentable/sous/lib"
@@ -120,3 +126,201 @@ func exampleState() *sous.State {
 		},
 	}
 }
+
+// copyDir copies the files in from to a new temporary directory.
+func copyDir(t *testing.T, from string) string {
+	to, err := ioutil.TempDir("", "sous-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = filepath.Walk(from, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(to, rel)
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(dest, b, info.Mode())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return to
+}
+
+// changedFiles returns the paths, relative to the directories, of the files
+// that differ between dirs a and b, as reported by git diff.
+func changedFiles(t *testing.T, a, b string) []string {
+	out, err := exec.Command("git", "diff", "--no-index", "--name-only", a, b).Output()
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*exec.ExitError); !ok || e.Sys().(syscall.WaitStatus).ExitStatus() != 1 {
+		t.Fatalf("git diff: %s", err)
+	}
+	seen := map[string]bool{}
+	files := []string{}
+	for _, f := range strings.Fields(string(out)) {
+		rel, err := filepath.Rel(b, "/"+strings.TrimPrefix(f, "/"))
+		if err != nil || strings.HasPrefix(rel, "..") {
+			rel, _ = filepath.Rel(a, f)
+		}
+		if !seen[rel] {
+			seen[rel] = true
+			files = append(files, rel)
+		}
+	}
+	return files
+}
+
+func TestWriteStateRoundTrip(t *testing.T) {
+	dir := copyDir(t, "test_data")
+	defer os.RemoveAll(dir)
+
+	s, err := ReadState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := s.Manifests["github.com/user/project"].Deployments["other-cluster"]
+	spec.Version = semv.MustParse("0.3.2")
+	s.Manifests["github.com/user/project"].Deployments["other-cluster"] = spec
+	if err := WriteState(dir, s); err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := ReadState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := exampleState()
+	spec = expected.Manifests["github.com/user/project"].Deployments["other-cluster"]
+	spec.Version = semv.MustParse("0.3.2")
+	expected.Manifests["github.com/user/project"].Deployments["other-cluster"] = spec
+	actualYAML, err := yaml.Marshal(actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedYAML, err := yaml.Marshal(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actualYAML) != string(expectedYAML) {
+		t.Errorf("got:\n%s\nwant:\n%s", actualYAML, expectedYAML)
+	}
+
+	changed := changedFiles(t, "test_data", dir)
+	if len(changed) != 1 || changed[0] != filepath.Join("manifests", "github.com", "user", "project.yaml") {
+		t.Errorf("got changes to %v; want only project.yaml", changed)
+	}
+
+	// writing the same state again changes nothing
+	before, err := ioutil.ReadFile(filepath.Join(dir, "defs.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteState(dir, actual); err != nil {
+		t.Fatal(err)
+	}
+	after, err := ioutil.ReadFile(filepath.Join(dir, "defs.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before) != string(after) {
+		t.Errorf("defs.yaml changed from:\n%s\nto:\n%s", before, after)
+	}
+}
+
+func TestWriteStateKeepsMetadata(t *testing.T) {
+	dir := copyDir(t, "test_data")
+	defer os.RemoveAll(dir)
+
+	s, err := ReadState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := s.Manifests["github.com/user/project"].Deployments["other-cluster"]
+	spec.Metadata = map[string]string{"team": "platform", "slack": "#platform"}
+	s.Manifests["github.com/user/project"].Deployments["other-cluster"] = spec
+	if err := WriteState(dir, s); err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := ReadState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := actual.Manifests["github.com/user/project"].Deployments["other-cluster"].Metadata
+	if !reflect.DeepEqual(got, spec.Metadata) {
+		t.Errorf("got metadata %v; want %v", got, spec.Metadata)
+	}
+}
+
+func TestWriteStateDeletesRemovedManifests(t *testing.T) {
+	dir := copyDir(t, "test_data")
+	defer os.RemoveAll(dir)
+
+	s, err := ReadState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(s.Manifests, "github.com/user/project")
+	if err := WriteState(dir, s); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "manifests", "github.com", "user", "project.yaml")); !os.IsNotExist(err) {
+		t.Errorf("project.yaml still exists: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "manifests", "github.com", "opentable", "sous.yaml")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWriteStateRefusesToOverwriteUnparseableState(t *testing.T) {
+	dir := copyDir(t, "test_data")
+	defer os.RemoveAll(dir)
+	broken := filepath.Join(dir, "manifests", "github.com", "user", "project.yaml")
+	if err := ioutil.WriteFile(broken, []byte("Source: [\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := WriteState(dir, exampleState())
+	if _, ok := err.(UnparseableState); !ok {
+		t.Fatalf("got %v; want UnparseableState", err)
+	}
+	if b, _ := ioutil.ReadFile(broken); string(b) != "Source: [\n" {
+		t.Errorf("overwrote %s with:\n%s", broken, b)
+	}
+
+	if err := ForceWriteState(dir, exampleState()); err != nil {
+		t.Fatal(err)
+	}
+	want, err := ioutil.ReadFile(filepath.Join("test_data", "manifests", "github.com", "user", "project.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(broken); string(b) != string(want) {
+		t.Errorf("forced write left %s with:\n%s\nwant:\n%s", broken, b, want)
+	}
+}
+
+func TestWriteStateToNewDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sous-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, d := range []string{filepath.Join(dir, "new"), dir} {
+		if err := WriteState(d, exampleState()); err != nil {
+			t.Errorf("writing to %s: %s", d, err)
+		}
+	}
+}
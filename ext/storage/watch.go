@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sous "github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/hy"
+)
+
+type (
+	// StateChange is sent by Watch each time the state in its directory
+	// changes.
+	StateChange struct {
+		// State is the state as it is now, or nil if Err is set.
+		State *sous.State
+		// Manifests are the keys of the manifests whose files were added,
+		// modified or removed, sorted.
+		Manifests []string
+		// DefsChanged is true if defs.yaml changed.
+		DefsChanged bool
+		// Err is set instead of State if the state no longer loads, as
+		// sous.LoadState would return it. The watch carries on, and the
+		// next change that fixes the state is sent as usual.
+		Err error
+	}
+
+	// WatchOpts configure WatchWith.
+	WatchOpts struct {
+		// Interval is how often the directory is checked for changes.
+		Interval time.Duration
+		// Settle is how long the directory must go unchanged after a change
+		// before it is read, so that a burst of changes, like an editor
+		// saving or a git checkout, is read once, and only once complete.
+		Settle time.Duration
+		// Clock times the checks and the settling; if nil, it's
+		// sous.SystemClock.
+		Clock sous.Clock
+	}
+
+	// fileStamp is what is compared to see whether a file has changed.
+	fileStamp struct {
+		size    int64
+		modTime time.Time
+	}
+)
+
+const (
+	// DefaultWatchInterval is how often Watch checks for changes.
+	DefaultWatchInterval = time.Second
+	// DefaultWatchSettle is how long Watch waits for changes to settle.
+	DefaultWatchSettle = 2 * time.Second
+)
+
+// Watch is WatchWith, using DefaultWatchInterval and DefaultWatchSettle.
+func Watch(dir string) (<-chan StateChange, func(), error) {
+	return WatchWith(dir, WatchOpts{Interval: DefaultWatchInterval, Settle: DefaultWatchSettle})
+}
+
+// WatchWith sends a StateChange each time the state in dir changes, until
+// stop is called, after which the channel is closed. Changes are found by
+// checking the size and modification time of the files under dir, other than
+// hidden ones, every opts.Interval; the state is then read again once they
+// have stopped changing for opts.Settle. The whole state is read again, with
+// sous.LoadStateManifest, however few files changed: there's no reading of
+// only the changed files. Which manifests changed is worked out by comparing
+// the hy.ReadManifests of the state before and after.
+//
+// If dir can't be checked, the error is sent as a StateChange, but only once
+// until it changes; once dir can be checked again, the state is sent again
+// after settling, even if no file changed.
+//
+// WatchWith reads the state once before returning, which it doesn't send;
+// the error is only an error reading dir, not a problem with the state.
+// Calling stop waits for the watch to finish.
+func WatchWith(dir string, opts WatchOpts) (changes <-chan StateChange, stop func(), err error) {
+	w := &watcher{
+		dir:     dir,
+		opts:    opts,
+		changes: make(chan StateChange),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if w.opts.Clock == nil {
+		w.opts.Clock = sous.SystemClock
+	}
+	if w.stamps, err = stampFiles(dir); err != nil {
+		return nil, nil, err
+	}
+	if _, w.manifest, err = sous.LoadStateManifest(dir); err != nil && !isStateError(err) {
+		return nil, nil, err
+	}
+	go w.run()
+	var once sync.Once
+	return w.changes, func() {
+		once.Do(func() { close(w.stop) })
+		<-w.done
+	}, nil
+}
+
+type watcher struct {
+	dir      string
+	opts     WatchOpts
+	stamps   map[string]fileStamp
+	manifest hy.ReadManifest
+	changes  chan StateChange
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func (w *watcher) run() {
+	defer close(w.done)
+	defer close(w.changes)
+	clock := w.opts.Clock
+	var changedAt time.Time
+	var stampErr error
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-clock.After(w.opts.Interval):
+		}
+		stamps, err := stampFiles(w.dir)
+		if err != nil {
+			if stampErr == nil || stampErr.Error() != err.Error() {
+				stampErr = err
+				if !w.send(StateChange{Err: err}) {
+					return
+				}
+			}
+			continue
+		}
+		if stampErr != nil {
+			// The error was the last thing sent, so send the state again
+			// even if no file has changed since.
+			stampErr = nil
+			changedAt = clock.Now()
+		}
+		if !sameStamps(stamps, w.stamps) {
+			w.stamps = stamps
+			changedAt = clock.Now()
+			continue
+		}
+		if changedAt.IsZero() || clock.Now().Sub(changedAt) < w.opts.Settle {
+			continue
+		}
+		changedAt = time.Time{}
+		if !w.send(w.reload()) {
+			return
+		}
+	}
+}
+
+// send sends c, unless the watch is stopped first, in which case it returns
+// false.
+func (w *watcher) send(c StateChange) bool {
+	select {
+	case w.changes <- c:
+		return true
+	case <-w.stop:
+		return false
+	}
+}
+
+// reload reads the whole state again, and works out what changed since it
+// was last read.
+func (w *watcher) reload() StateChange {
+	state, manifest, err := sous.LoadStateManifest(w.dir)
+	c := StateChange{State: &state, Err: err}
+	if err != nil {
+		c.State = nil
+	}
+	if manifest == nil {
+		return c
+	}
+	c.Manifests, c.DefsChanged = manifestChanges(w.manifest, manifest)
+	w.manifest = manifest
+	return c
+}
+
+// isStateError is true if err is a problem with the files in a state
+// directory, rather than with reading it.
+func isStateError(err error) bool {
+	_, ok := err.(hy.Errors)
+	return ok
+}
+
+// manifestChanges compares two reads of a state, and returns the keys of the
+// manifests whose files differ, and whether defs.yaml does.
+func manifestChanges(prior, post hy.ReadManifest) (manifests []string, defs bool) {
+	records := map[string]hy.ReadRecord{}
+	for _, r := range prior {
+		records[r.Path] = r
+	}
+	changed := map[string]hy.ReadRecord{}
+	for _, r := range post {
+		if p, ok := records[r.Path]; !ok || p.Hash != r.Hash {
+			changed[r.Path] = r
+		}
+		delete(records, r.Path)
+	}
+	for path, r := range records {
+		changed[path] = r
+	}
+	seen := map[string]bool{}
+	for _, r := range changed {
+		switch {
+		case r.Field == "Defs":
+			defs = true
+		case r.Field == "Manifests" && !seen[r.Key]:
+			seen[r.Key] = true
+			manifests = append(manifests, r.Key)
+		}
+	}
+	sort.Strings(manifests)
+	return manifests, defs
+}
+
+// stampFiles returns the stamps of the files under dir, skipping hidden files
+// and directories, such as .git.
+func stampFiles(dir string) (map[string]fileStamp, error) {
+	stamps := map[string]fileStamp{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() {
+			stamps[path] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+		}
+		return nil
+	})
+	return stamps, err
+}
+
+func sameStamps(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, s := range a {
+		if o, ok := b[path]; !ok || o.size != s.size || !o.modTime.Equal(s.modTime) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/opentable/sous/lib/clocktest"
+)
+
+var testWatchOpts = WatchOpts{Interval: 5 * time.Millisecond, Settle: 20 * time.Millisecond}
+
+func nextChange(t *testing.T, changes <-chan StateChange) StateChange {
+	select {
+	case c, ok := <-changes:
+		if !ok {
+			t.Fatal("changes closed")
+		}
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change within 5s")
+	}
+	panic("unreachable")
+}
+
+func TestWatch(t *testing.T) {
+	dir := copyDir(t, "test_data")
+	defer os.RemoveAll(dir)
+
+	changes, stop, err := WatchWith(dir, testWatchOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	s, err := ReadState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := s.Manifests["github.com/user/project"]
+	m.Owners = []string{"someone@example.com"}
+	s.Manifests["github.com/user/project"] = m
+	if err := WriteState(dir, s); err != nil {
+		t.Fatal(err)
+	}
+
+	c := nextChange(t, changes)
+	if c.Err != nil {
+		t.Fatal(c.Err)
+	}
+	if want := []string{"github.com/user/project"}; !reflect.DeepEqual(c.Manifests, want) {
+		t.Errorf("changed manifests %q; want %q", c.Manifests, want)
+	}
+	if c.DefsChanged {
+		t.Error("DefsChanged; want false")
+	}
+	if got := c.State.Manifests["github.com/user/project"].Owners; !reflect.DeepEqual(got, m.Owners) {
+		t.Errorf("owners %q; want %q", got, m.Owners)
+	}
+}
+
+func TestWatch_ParseErrorsDontStopWatching(t *testing.T) {
+	dir := copyDir(t, "test_data")
+	defer os.RemoveAll(dir)
+
+	changes, stop, err := WatchWith(dir, testWatchOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	path := filepath.Join(dir, "manifests", "github.com", "opentable", "sous.yaml")
+	good, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("Owners: [\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if c := nextChange(t, changes); c.Err == nil || c.State != nil {
+		t.Fatalf("got %+v; want only Err", c)
+	}
+
+	if err := ioutil.WriteFile(path, good, 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := nextChange(t, changes)
+	if c.Err != nil {
+		t.Fatal(c.Err)
+	}
+	if want := []string{"github.com/opentable/sous"}; !reflect.DeepEqual(c.Manifests, want) {
+		t.Errorf("changed manifests %q; want %q", c.Manifests, want)
+	}
+}
+
+func TestWatch_AddAndRemoveManifests(t *testing.T) {
+	dir := copyDir(t, "test_data")
+	defer os.RemoveAll(dir)
+
+	changes, stop, err := WatchWith(dir, testWatchOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	manifests := filepath.Join(dir, "manifests", "github.com")
+	if err := os.Rename(filepath.Join(manifests, "user", "project.yaml"),
+		filepath.Join(manifests, "user", "renamed.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	c := nextChange(t, changes)
+	if c.Err != nil {
+		t.Fatal(c.Err)
+	}
+	want := []string{"github.com/user/project", "github.com/user/renamed"}
+	if !reflect.DeepEqual(c.Manifests, want) {
+		t.Errorf("changed manifests %q; want %q", c.Manifests, want)
+	}
+}
+
+func TestWatch_StopClosesChanges(t *testing.T) {
+	dir := copyDir(t, "test_data")
+	defer os.RemoveAll(dir)
+
+	changes, stop, err := WatchWith(dir, testWatchOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Leave a change unreceived, which must not keep the watch running.
+	if err := ioutil.WriteFile(filepath.Join(dir, "defs.yaml"), []byte("Clusters: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	stop()
+	stop()
+	for range changes {
+	}
+}
+
+// awaitWaiting waits for the watch to be waiting for its next tick. It
+// returns false if the watch doesn't wait within 5s, as when it's blocked
+// sending a change.
+func awaitWaiting(clock *clocktest.Clock) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for clock.Waiters() == 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
+
+// awaitTick advances clock by interval once the watch is waiting for it,
+// returning false if it doesn't wait.
+func awaitTick(clock *clocktest.Clock, interval time.Duration) bool {
+	if !awaitWaiting(clock) {
+		return false
+	}
+	clock.Advance(interval)
+	return true
+}
+
+func TestWatch_Settles(t *testing.T) {
+	dir := copyDir(t, "test_data")
+	defer os.RemoveAll(dir)
+
+	clock := clocktest.NewClock(time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC))
+	opts := WatchOpts{Interval: time.Second, Settle: 3 * time.Second, Clock: clock}
+	changes, stop, err := WatchWith(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	defs := filepath.Join(dir, "defs.yaml")
+	b, err := ioutil.ReadFile(defs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(defs, append(b, "# changed\n"...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The first tick sees the change, which has settled by the fourth; a
+	// change sent before then would leave the watch blocked, not waiting for
+	// the next tick.
+	for i := 0; i < 4; i++ {
+		if !awaitTick(clock, opts.Interval) {
+			t.Fatalf("change sent after %d ticks; want 4", i)
+		}
+	}
+	if c := nextChange(t, changes); c.Err != nil || !c.DefsChanged {
+		t.Errorf("got %+v; want DefsChanged", c)
+	}
+}
+
+func TestWatch_StampErrorsSentOnce(t *testing.T) {
+	dir := copyDir(t, "test_data")
+	defer os.RemoveAll(dir)
+
+	clock := clocktest.NewClock(time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC))
+	opts := WatchOpts{Interval: time.Second, Settle: time.Second, Clock: clock}
+	changes, stop, err := WatchWith(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	moved := dir + ".moved"
+	if err := os.Rename(dir, moved); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(moved)
+	awaitTick(clock, opts.Interval)
+	if c := nextChange(t, changes); c.Err == nil {
+		t.Fatalf("got %+v; want Err", c)
+	}
+	for i := 0; i < 3; i++ {
+		if !awaitTick(clock, opts.Interval) {
+			t.Fatal("the same error was sent again")
+		}
+	}
+	if !awaitWaiting(clock) {
+		t.Fatal("the same error was sent again")
+	}
+
+	if err := os.Rename(moved, dir); err != nil {
+		t.Fatal(err)
+	}
+	// The state is sent again once it settles, though no file changed.
+	for i := 0; i < 2; i++ {
+		if !awaitTick(clock, opts.Interval) {
+			t.Fatal("state sent before settling")
+		}
+	}
+	c := nextChange(t, changes)
+	if c.Err != nil || c.State == nil {
+		t.Errorf("got %+v; want the state", c)
+	}
+}
+
+func TestWatch_MissingDir(t *testing.T) {
+	if _, _, err := Watch("does-not-exist"); err == nil {
+		t.Error("no error watching a missing directory")
+	}
+}
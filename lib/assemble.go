@@ -2,17 +2,34 @@ package sous
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 )
 
-// Deployments returns all deployments described by the state.
+// Deployments returns all deployments described by the state. If two
+// manifests describe the same deployment, as identified by Deployment.ID, it
+// returns a *DuplicateDeployment naming them.
 func (s *State) Deployments() (Deployments, error) {
+	names := make([]string, 0, len(s.Manifests))
+	for name := range s.Manifests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
 	ds := Deployments{}
-	for _, m := range s.Manifests {
-		deployments, err := s.DeploymentsFromManifest(m)
+	from := map[DeploymentID]string{}
+	for _, name := range names {
+		deployments, err := s.DeploymentsFromManifest(s.Manifests[name])
 		if err != nil {
 			return nil, err
 		}
+		for _, d := range deployments {
+			id := d.ID()
+			if other, ok := from[id]; ok {
+				return nil, &DuplicateDeployment{ID: id, Manifests: [2]string{other, name}}
+			}
+			from[id] = name
+		}
 		ds = append(ds, deployments...)
 	}
 	return ds, nil
@@ -23,11 +40,10 @@ func (s *State) Deployments() (Deployments, error) {
 // and configuration).
 func (s *State) DeploymentsFromManifest(m *Manifest) ([]*Deployment, error) {
 	ds := []*Deployment{}
-	inherit := DeploymentSpecs{}
-	if global, ok := m.Deployments["Global"]; ok {
-		inherit = append(inherit, global)
-	}
-	for clusterName, spec := range m.Deployments {
+	for clusterName := range m.Deployments {
+		if clusterName == GlobalDeploySpec {
+			continue
+		}
 		n, ok := s.Defs.Clusters[clusterName]
 		if !ok {
 			us := make([]string, 0, len(s.Defs.Clusters))
@@ -36,8 +52,7 @@ func (s *State) DeploymentsFromManifest(m *Manifest) ([]*Deployment, error) {
 			}
 			return nil, fmt.Errorf("Could not find an cluster configured for name '%s' in [%s] (for %+v)", clusterName, strings.Join(us, ", "), m)
 		}
-		spec.clusterName = n.BaseURL
-		d, err := BuildDeployment(m, spec, inherit)
+		d, _, err := buildClusterDeployment(m, clusterName, n)
 		if err != nil {
 			return nil, err
 		}
@@ -45,3 +60,23 @@ func (s *State) DeploymentsFromManifest(m *Manifest) ([]*Deployment, error) {
 	}
 	return ds, nil
 }
+
+// buildClusterDeployment builds the deployment of m to the cluster defined as
+// name, which inherits from the cluster's defaults and m's Global spec, and
+// returns it along with where each field of its DeployConfig came from. The
+// templates in its Env and volume host paths are resolved, so that diffs
+// compare final values.
+func buildClusterDeployment(m *Manifest, name string, cl Cluster) (*Deployment, Provenance, error) {
+	spec := m.Deployments[name]
+	spec.clusterName = cl.BaseURL
+	configs := append(cl.inheritance(name, m),
+		NamedDeployConfig{Name: "Deployments." + name, DeployConfig: spec.DeployConfig})
+	d, prov, err := newDeployment(m, spec, configs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := d.resolveTemplates(m, name, cl); err != nil {
+		return nil, nil, err
+	}
+	return d, prov, nil
+}
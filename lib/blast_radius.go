@@ -0,0 +1,96 @@
+package sous
+
+import (
+	"fmt"
+	"strings"
+)
+
+type (
+	// BlastRadius limits how much a single rectification may change, so that
+	// a bad change to the state can't, say, delete every request at once.
+	// Each limit is either a number of deployments, or a percentage of the
+	// deployments running before the rectification; zero means no limit.
+	// Creates are unlimited unless limited explicitly.
+	BlastRadius struct {
+		MaxDeletes, MaxDeletePercent  int
+		MaxModifies, MaxModifyPercent int
+		MaxCreates, MaxCreatePercent  int
+	}
+
+	// BlastRadiusExceeded is returned instead of rectifying a plan that
+	// would change more than its BlastRadius allows. Nothing is changed.
+	BlastRadiusExceeded struct {
+		Counts DiffCounts
+		Limits BlastRadius
+		// Exceeded describes each limit that was exceeded.
+		Exceeded []string
+	}
+)
+
+// IsZero reports whether b sets no limits at all.
+func (b BlastRadius) IsZero() bool {
+	return b == BlastRadius{}
+}
+
+// Check returns a *BlastRadiusExceeded if a plan with counts would exceed
+// any of b's limits, or else nil. Percentages are of the deployments
+// running, that is, those deleted, modified or retained; if there are none,
+// percentage limits don't apply. A recreated request counts as a delete and
+// a create, rather than a modify.
+func (b BlastRadius) Check(counts DiffCounts) *BlastRadiusExceeded {
+	running := counts.Deleted + counts.Modified + counts.Retained
+	deletes := counts.Deleted + counts.Recreated
+	modifies := counts.Modified - counts.Recreated
+	creates := counts.Created + counts.Recreated
+	var exceeded []string
+	for _, l := range []struct {
+		action      string
+		n, max, pct int
+	}{
+		{"delete", deletes, b.MaxDeletes, b.MaxDeletePercent},
+		{"modify", modifies, b.MaxModifies, b.MaxModifyPercent},
+		{"create", creates, b.MaxCreates, b.MaxCreatePercent},
+	} {
+		if l.max > 0 && l.n > l.max {
+			exceeded = append(exceeded, fmt.Sprintf("%s %d deployments, more than the limit of %d",
+				l.action, l.n, l.max))
+		}
+		if l.pct > 0 && running > 0 && l.n*100 > l.pct*running {
+			exceeded = append(exceeded, fmt.Sprintf("%s %d of %d running deployments, more than the limit of %d%%",
+				l.action, l.n, running, l.pct))
+		}
+	}
+	if len(exceeded) == 0 {
+		return nil
+	}
+	return &BlastRadiusExceeded{Counts: counts, Limits: b, Exceeded: exceeded}
+}
+
+func (e *BlastRadiusExceeded) Error() string {
+	return "refusing to rectify: it would " + strings.Join(e.Exceeded, ", and ")
+}
+
+// Chans returns DiffChans carrying the deployments in r, which are already
+// closed, so the plan r describes can be rectified.
+func (r DiffReport) Chans() DiffChans {
+	dcs := DiffChans{
+		Created:  make(chan *Deployment, len(r.Created)),
+		Deleted:  make(chan *Deployment, len(r.Deleted)),
+		Retained: make(chan *Deployment, len(r.Retained)),
+		Modified: make(chan *DeploymentPair, len(r.Modified)),
+	}
+	for _, d := range r.Created {
+		dcs.Created <- d
+	}
+	for _, d := range r.Deleted {
+		dcs.Deleted <- d
+	}
+	for _, d := range r.Retained {
+		dcs.Retained <- d
+	}
+	for _, p := range r.Modified {
+		dcs.Modified <- p
+	}
+	dcs.Close()
+	return dcs
+}
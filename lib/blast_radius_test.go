@@ -0,0 +1,126 @@
+package sous
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlastRadiusCheck(t *testing.T) {
+	counts := DiffCounts{Created: 5, Deleted: 3, Modified: 2, Retained: 5}
+
+	testCases := []struct {
+		limits   BlastRadius
+		exceeded int
+	}{
+		{BlastRadius{}, 0},
+		{BlastRadius{MaxDeletes: 3}, 0},
+		{BlastRadius{MaxDeletes: 2}, 1},
+		{BlastRadius{MaxModifies: 1}, 1},
+		{BlastRadius{MaxDeletePercent: 30}, 0},
+		{BlastRadius{MaxDeletePercent: 29}, 1},
+		{BlastRadius{MaxModifyPercent: 10}, 1},
+		{BlastRadius{MaxDeletes: 1, MaxDeletePercent: 10, MaxModifies: 1}, 3},
+		{BlastRadius{MaxCreates: 4}, 1},
+		{BlastRadius{MaxCreatePercent: 50}, 0},
+		{BlastRadius{MaxCreatePercent: 49}, 1},
+	}
+
+	for _, tc := range testCases {
+		err := tc.limits.Check(counts)
+		if tc.exceeded == 0 {
+			assert.Nil(t, err, "%+v", tc.limits)
+			continue
+		}
+		if assert.NotNil(t, err, "%+v", tc.limits) {
+			assert.Len(t, err.Exceeded, tc.exceeded, "%+v", tc.limits)
+			assert.Equal(t, counts, err.Counts)
+		}
+	}
+}
+
+func TestBlastRadiusCheck_NothingRunning(t *testing.T) {
+	assert := assert.New(t)
+
+	b := BlastRadius{MaxCreatePercent: 10, MaxDeletePercent: 10}
+	assert.Nil(b.Check(DiffCounts{Created: 20}))
+
+	b.MaxCreates = 10
+	assert.NotNil(b.Check(DiffCounts{Created: 20}))
+}
+
+func TestBlastRadiusCheck_Recreates(t *testing.T) {
+	assert := assert.New(t)
+	counts := DiffCounts{Modified: 2, Recreated: 2, Retained: 8}
+
+	assert.Nil(BlastRadius{MaxModifies: 1}.Check(counts))
+	if err := (BlastRadius{MaxDeletes: 1}.Check(counts)); assert.NotNil(err) {
+		assert.Equal([]string{"delete 2 deployments, more than the limit of 1"}, err.Exceeded)
+	}
+	if err := (BlastRadius{MaxCreates: 1}.Check(counts)); assert.NotNil(err) {
+		assert.Equal([]string{"create 2 deployments, more than the limit of 1"}, err.Exceeded)
+	}
+}
+
+func TestBlastRadiusExceeded_Error(t *testing.T) {
+	err := BlastRadius{MaxDeletes: 1}.Check(DiffCounts{Deleted: 2})
+	assert.EqualError(t, err,
+		"refusing to rectify: it would delete 2 deployments, more than the limit of 1")
+}
+
+func blastRadiusPlan(deletes int) DiffChans {
+	dcs := NewDiffChans(deletes + 1)
+	for i := 0; i < deletes; i++ {
+		dcs.Deleted <- &Deployment{
+			SourceVersion: SourceVersion{RepoURL: RepoURL(fmt.Sprintf("reqid%d", i))},
+			Cluster:       "cluster",
+		}
+	}
+	dcs.Retained <- &Deployment{
+		SourceVersion: SourceVersion{RepoURL: RepoURL("retained")},
+		Cluster:       "cluster",
+	}
+	dcs.Close()
+	return dcs
+}
+
+func TestRectifyPlanWith_BlastRadiusExceeded(t *testing.T) {
+	assert := assert.New(t)
+
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	var reported []error
+	err := RectifyPlanWith(client, blastRadiusPlan(3),
+		RectifyOpts{BlastRadius: BlastRadius{MaxDeletes: 2}},
+		func(err error) { reported = append(reported, err) })
+
+	if assert.IsType(&BlastRadiusExceeded{}, err) {
+		assert.Equal(3, err.(*BlastRadiusExceeded).Counts.Deleted)
+	}
+	assert.Equal([]error{err}, reported)
+	assert.Empty(client.Calls())
+}
+
+func TestRectifyPlanWith_IgnoreBlastRadius(t *testing.T) {
+	assert := assert.New(t)
+
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	err := RectifyPlanWith(client, blastRadiusPlan(3),
+		RectifyOpts{BlastRadius: BlastRadius{MaxDeletes: 2}, IgnoreBlastRadius: true},
+		func(err error) { t.Error(err) })
+
+	assert.NoError(err)
+	assert.Len(client.CallsTo("DeleteRequest"), 3)
+}
+
+func TestRectifyPlanWith_WithinBlastRadius(t *testing.T) {
+	assert := assert.New(t)
+
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	err := RectifyPlanWith(client, blastRadiusPlan(2),
+		RectifyOpts{BlastRadius: BlastRadius{MaxDeletes: 2}},
+		func(err error) { t.Error(err) })
+
+	assert.NoError(err)
+	assert.Len(client.CallsTo("DeleteRequest"), 2)
+}
@@ -0,0 +1,168 @@
+package sous
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultCircuitCoolDown is how long a cluster's circuit stays open when
+// RectifyOpts.CircuitCoolDown is zero.
+const DefaultCircuitCoolDown = time.Minute
+
+type (
+	// ClusterBreaker is a circuit breaker per cluster: once threshold
+	// consecutive calls against a cluster have failed with a TransientError,
+	// its circuit opens, and no more calls should be made against it until
+	// coolDown has passed. Then the circuit closes, but a single further
+	// transient failure opens it again, while any other result resets it. A
+	// single ClusterBreaker is safe to share between goroutines. A nil
+	// *ClusterBreaker never opens.
+	ClusterBreaker struct {
+		threshold int
+		coolDown  time.Duration
+		sync.Mutex
+		circuits map[ClusterName]*circuit
+	}
+
+	circuit struct {
+		failures  int
+		openUntil time.Time
+	}
+
+	// CircuitOpen is returned instead of making a call against a cluster
+	// whose circuit is open.
+	CircuitOpen struct {
+		Cluster ClusterName
+		Until   time.Time
+	}
+)
+
+// NewClusterBreaker returns a ClusterBreaker which opens a cluster's circuit
+// after threshold consecutive transient failures, for coolDown, or for
+// DefaultCircuitCoolDown if that's zero.
+func NewClusterBreaker(threshold int, coolDown time.Duration) *ClusterBreaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	if coolDown <= 0 {
+		coolDown = DefaultCircuitCoolDown
+	}
+	return &ClusterBreaker{
+		threshold: threshold,
+		coolDown:  coolDown,
+		circuits:  make(map[ClusterName]*circuit),
+	}
+}
+
+// CircuitOptions returns the threshold and cool-down of the circuit breaker
+// rectify uses, as c configures them.
+func (c Config) CircuitOptions() (threshold int, coolDown time.Duration, err error) {
+	if c.CircuitCoolDown != "" {
+		if coolDown, err = time.ParseDuration(c.CircuitCoolDown); err != nil {
+			return 0, 0, fmt.Errorf("CircuitCoolDown: %s", err)
+		}
+	}
+	return c.CircuitThreshold, coolDown, nil
+}
+
+// Open reports whether the circuit for cluster is open, and if so, until
+// when.
+func (b *ClusterBreaker) Open(cluster ClusterName) (time.Time, bool) {
+	return b.open(cluster, SystemClock.Now())
+}
+
+func (b *ClusterBreaker) open(cluster ClusterName, now time.Time) (time.Time, bool) {
+	if b == nil {
+		return time.Time{}, false
+	}
+	b.Lock()
+	defer b.Unlock()
+	c, ok := b.circuits[cluster]
+	if !ok || c.openUntil.IsZero() {
+		return time.Time{}, false
+	}
+	if now.Before(c.openUntil) {
+		return c.openUntil, true
+	}
+	// the next call probes the cluster: if it fails too, the circuit opens
+	// again straight away
+	c.openUntil = time.Time{}
+	c.failures = b.threshold - 1
+	return time.Time{}, false
+}
+
+// Record records the result of a call against cluster, and returns true if
+// it opened the cluster's circuit.
+func (b *ClusterBreaker) Record(cluster ClusterName, err error) bool {
+	return b.record(cluster, err, SystemClock.Now())
+}
+
+func (b *ClusterBreaker) record(cluster ClusterName, err error, now time.Time) bool {
+	if b == nil {
+		return false
+	}
+	b.Lock()
+	defer b.Unlock()
+	if err == nil || classifyError(err) != TransientError {
+		delete(b.circuits, cluster)
+		return false
+	}
+	c, ok := b.circuits[cluster]
+	if !ok {
+		c = &circuit{}
+		b.circuits[cluster] = c
+	}
+	c.failures++
+	if c.failures < b.threshold {
+		return false
+	}
+	opened := c.openUntil.IsZero()
+	c.openUntil = now.Add(b.coolDown)
+	return opened
+}
+
+func (e *CircuitOpen) Error() string {
+	return fmt.Sprintf("circuit open for %s until %s", e.Cluster, e.Until.Format(time.RFC3339))
+}
+
+// Temporary is always true: the call may be made once the circuit closes.
+func (e *CircuitOpen) Temporary() bool { return true }
+
+// circuitOpen reports whether d's cluster's circuit is open, and if so, emits
+// a ClusterCircuitOpen event, saying what would have been done.
+func (r *rectifier) circuitOpen(d *Deployment, reqID, would string) bool {
+	now := r.clock().Now()
+	until, open := r.breaker.open(d.Cluster, now)
+	if !open {
+		return false
+	}
+	msg := fmt.Sprintf("circuit open until %s: would have %s", until.Format(time.RFC3339), would)
+	r.logFor(d, reqID).Infof("Skipped, %s", msg)
+	r.send(RectifyEvent{
+		Kind:       ClusterCircuitOpen,
+		Deployment: d,
+		Cluster:    d.Cluster,
+		RequestID:  reqID,
+		Started:    now,
+		Occurred:   now,
+		Message:    msg,
+	})
+	return true
+}
+
+// call makes a call against cluster with f, once the rate limiter allows
+// it, giving up after the timeout. Its result is recorded by the breaker;
+// if cluster's circuit is open, f isn't called and *CircuitOpen is returned.
+func (r *rectifier) call(cluster ClusterName, op string, f func() error) error {
+	if until, open := r.breaker.open(cluster, r.clock().Now()); open {
+		return &CircuitOpen{Cluster: cluster, Until: until}
+	}
+	r.limit(cluster)
+	err := r.withTimeout(op, f)
+	if r.breaker.record(cluster, err, r.clock().Now()) {
+		r.logger().With("cluster", string(cluster)).Warnf(
+			"Circuit opened by %s: %s; skipping the cluster for %s", op, err, r.breaker.coolDown)
+	}
+	return err
+}
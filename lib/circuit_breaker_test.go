@@ -0,0 +1,116 @@
+package sous
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/opentable/sous/lib/clocktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterBreaker(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	transient := &TimeoutError{Op: "Deploy", After: time.Second}
+	b := NewClusterBreaker(2, time.Minute)
+
+	assert.False(b.record("east", transient, now))
+	_, open := b.open("east", now)
+	assert.False(open, "one failure shouldn't open the circuit")
+	assert.True(b.record("east", transient, now))
+	until, open := b.open("east", now.Add(time.Second))
+	assert.True(open)
+	assert.Equal(now.Add(time.Minute), until)
+	_, open = b.open("west", now)
+	assert.False(open, "circuits are per cluster")
+
+	_, open = b.open("east", now.Add(time.Minute))
+	assert.False(open, "the cool-down has passed")
+	assert.True(b.record("east", transient, now.Add(time.Minute)), "a failed probe should reopen it")
+	_, open = b.open("east", now.Add(time.Minute+time.Second))
+	assert.True(open)
+
+	assert.False(b.record("east", nil, now))
+	_, open = b.open("east", now.Add(time.Second))
+	assert.False(open, "a success should close it")
+	assert.False(b.record("east", transient, now))
+	assert.False(b.record("east", fmt.Errorf("invalid request"), now))
+	assert.False(b.record("east", transient, now), "a non-transient error should reset the count")
+
+	var none *ClusterBreaker
+	assert.False(none.Record("east", transient))
+	_, open = none.Open("east")
+	assert.False(open)
+}
+
+func TestRectifyOpensAndClosesCircuits(t *testing.T) {
+	assert := assert.New(t)
+
+	depl := func(name string) *Deployment {
+		d := makeDepl("github.com/opentable/"+name, 1)
+		d.Cluster = "east"
+		return d
+	}
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	for n := 1; n <= 2; n++ {
+		client.FailCall("InspectRequest", n, &TimeoutError{Op: "InspectRequest", After: time.Second})
+	}
+	events := make(chan RectifyEvent, 100)
+	clock := clocktest.NewClock(time.Now())
+	opts := RectifyOpts{
+		Events:           events,
+		Workers:          1,
+		CircuitThreshold: 2,
+		CircuitCoolDown:  time.Minute,
+		Clock:            clock,
+	}
+	chanset := NewDiffChans(4)
+	errs := RectifyWith(chanset, client, opts)
+	failed := make(chan int)
+	go func() {
+		n := 0
+		for range errs {
+			n++
+		}
+		failed <- n
+	}()
+
+	chanset.Created <- depl("one")
+	chanset.Created <- depl("two")
+	chanset.Created <- depl("three")
+	var skipped RectifyEvent
+	for ev := range events {
+		if ev.Kind == ClusterCircuitOpen {
+			skipped = ev
+			break
+		}
+	}
+	assert.Equal(RepoURL("github.com/opentable/three"), skipped.Deployment.SourceVersion.RepoURL)
+	assert.Contains(skipped.Message, "would have created")
+	assert.Len(client.CallsTo("InspectRequest"), 2, "no call should be made while the circuit is open")
+	assert.Empty(client.CallsTo("PostRequest"))
+
+	clock.Advance(time.Minute)
+	chanset.Created <- depl("four")
+	chanset.Created <- depl("five")
+	chanset.Close()
+	assert.Equal(2, <-failed)
+	close(events)
+	for ev := range events {
+		assert.NotEqual(ClusterCircuitOpen, ev.Kind, "%s", ev)
+	}
+	assert.Len(client.CallsTo("InspectRequest"), 4, "the circuit should close after the cool-down")
+	assert.Len(client.CallsTo("PostRequest"), 2)
+}
+
+func TestConfigCircuitOptions(t *testing.T) {
+	threshold, coolDown, err := Config{CircuitThreshold: 3, CircuitCoolDown: "5m"}.CircuitOptions()
+	if assert.NoError(t, err) {
+		assert.Equal(t, 3, threshold)
+		assert.Equal(t, 5*time.Minute, coolDown)
+	}
+	_, _, err = Config{CircuitThreshold: 3, CircuitCoolDown: "a while"}.CircuitOptions()
+	assert.Error(t, err)
+}
@@ -0,0 +1,33 @@
+package sous
+
+import (
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+type (
+	// Clock tells the time, and waits for it to pass. The rectifier, the
+	// NameCache and storage.WatchWith use one rather than the time package,
+	// so that tests can control time: see the clocktest package.
+	Clock interface {
+		Now() time.Time
+		// After sends the time on the returned channel once d has passed.
+		After(d time.Duration) <-chan time.Time
+	}
+
+	systemClock struct{}
+)
+
+// SystemClock is the Clock of the time package, used wherever no other is
+// given.
+var SystemClock Clock = systemClock{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// RandomID returns a random ID, for use where uniqueness is wanted rather
+// than idempotence. It's used wherever no other ID generator is given.
+func RandomID() string {
+	return SanitizeDeployID(uuid.NewV4().String())
+}
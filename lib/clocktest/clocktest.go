@@ -0,0 +1,89 @@
+// Package clocktest provides a sous.Clock whose time only moves when a test
+// moves it, and a predictable ID generator, so that tests of the rectifier
+// and the NameCache needn't sleep, or match timestamps and IDs loosely.
+package clocktest
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type (
+	// Clock is a sous.Clock that stands still until it's advanced. It's safe
+	// to use from several goroutines.
+	Clock struct {
+		sync.Mutex
+		now     time.Time
+		waiters []waiter
+	}
+
+	waiter struct {
+		at time.Time
+		c  chan time.Time
+	}
+)
+
+// NewClock returns a Clock that says it's now.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns c's time.
+func (c *Clock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives c's time once it has been advanced
+// by at least d. If d isn't positive, it receives it straight away.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	c.Lock()
+	defer c.Unlock()
+	w := waiter{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.c <- c.now
+		return w.c
+	}
+	c.waiters = append(c.waiters, w)
+	return w.c
+}
+
+// Advance moves c's time on by d, firing the channels returned by After
+// that are due.
+func (c *Clock) Advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = c.now.Add(d)
+	waiting := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiting = append(waiting, w)
+			continue
+		}
+		w.c <- c.now
+	}
+	c.waiters = waiting
+}
+
+// Waiters returns how many channels returned by After haven't fired yet,
+// so that a test can wait until something is waiting before advancing.
+func (c *Clock) Waiters() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.waiters)
+}
+
+// IDs returns an ID generator, for use in place of sous.RandomID, which
+// returns prefix followed by 1, 2, 3 and so on.
+func IDs(prefix string) func() string {
+	var m sync.Mutex
+	n := 0
+	return func() string {
+		m.Lock()
+		defer m.Unlock()
+		n++
+		return fmt.Sprintf("%s%d", prefix, n)
+	}
+}
@@ -0,0 +1,50 @@
+package clocktest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClock(t *testing.T) {
+	start := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := NewClock(start)
+	select {
+	case <-c.After(0):
+	default:
+		t.Error("After(0) didn't fire straight away")
+	}
+
+	soon, later := c.After(time.Second), c.After(time.Minute)
+	if n := c.Waiters(); n != 2 {
+		t.Errorf("got %d waiters; want 2", n)
+	}
+	c.Advance(time.Second)
+	select {
+	case at := <-soon:
+		if want := start.Add(time.Second); !at.Equal(want) {
+			t.Errorf("After(1s) sent %s; want %s", at, want)
+		}
+	default:
+		t.Error("After(1s) didn't fire after advancing 1s")
+	}
+	select {
+	case <-later:
+		t.Error("After(1m) fired after advancing 1s")
+	default:
+	}
+	if n := c.Waiters(); n != 1 {
+		t.Errorf("got %d waiters; want 1", n)
+	}
+	if got, want := c.Now(), start.Add(time.Second); !got.Equal(want) {
+		t.Errorf("Now() = %s; want %s", got, want)
+	}
+}
+
+func TestIDs(t *testing.T) {
+	ids := IDs("run")
+	for _, want := range []string{"run1", "run2", "run3"} {
+		if got := ids(); got != want {
+			t.Errorf("got ID %q; want %q", got, want)
+		}
+	}
+}
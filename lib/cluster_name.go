@@ -0,0 +1,102 @@
+package sous
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type (
+	// A ClusterName identifies a cluster defined in Defs.Clusters, either by
+	// its key there or by its BaseURL. Deployments built from a State, like
+	// those read from Singularity, are identified by the BaseURL. It's a
+	// type of its own so that the clusters deployments refer to can be
+	// checked against the State, rather than a typo silently making a new
+	// cluster in a diff.
+	ClusterName string
+
+	// UndefinedDeploymentClusters is returned when comparing deployments to
+	// clusters that aren't defined, and lists those deployments, sorted.
+	UndefinedDeploymentClusters []DeploymentID
+)
+
+func (c ClusterName) String() string { return string(c) }
+
+// ClusterNames converts strings to ClusterNames, for callers that still
+// deal in strings.
+func ClusterNames(ss ...string) []ClusterName {
+	cs := make([]ClusterName, len(ss))
+	for i, s := range ss {
+		cs[i] = ClusterName(s)
+	}
+	return cs
+}
+
+// Lookup returns the key and definition of the cluster c identifies, and
+// false if there is none.
+func (cs Clusters) Lookup(c ClusterName) (string, Cluster, bool) {
+	if cl, ok := cs[string(c)]; ok {
+		return string(c), cl, true
+	}
+	for name, cl := range cs {
+		if cl.BaseURL != "" && ClusterName(cl.BaseURL) == c {
+			return name, cl, true
+		}
+	}
+	return "", Cluster{}, false
+}
+
+// ClusterName returns s as a ClusterName, or UnknownCluster if it doesn't
+// identify one of cs.
+func (cs Clusters) ClusterName(s string) (ClusterName, error) {
+	c := ClusterName(s)
+	if _, _, ok := cs.Lookup(c); !ok {
+		return "", UnknownCluster{Cluster: c, Known: cs.names()}
+	}
+	return c, nil
+}
+
+// names returns the keys of cs, sorted.
+func (cs Clusters) names() []string {
+	names := make([]string, 0, len(cs))
+	for name := range cs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// UndefinedClusters returns the ID of each of ds whose cluster isn't one of
+// cs, sorted, or nil if there are none.
+func (ds Deployments) UndefinedClusters(cs Clusters) UndefinedDeploymentClusters {
+	var undefined UndefinedDeploymentClusters
+	for _, d := range ds {
+		if _, _, ok := cs.Lookup(d.Cluster); !ok {
+			undefined = append(undefined, d.ID())
+		}
+	}
+	sortDeploymentIDs(undefined)
+	return undefined
+}
+
+// sortDeploymentIDs sorts ids by cluster, then by source location, and then
+// by request ID.
+func sortDeploymentIDs(ids []DeploymentID) {
+	sort.Slice(ids, func(i, j int) bool {
+		if ids[i].Cluster != ids[j].Cluster {
+			return ids[i].Cluster < ids[j].Cluster
+		}
+		if si, sj := ids[i].Source.String(), ids[j].Source.String(); si != sj {
+			return si < sj
+		}
+		return ids[i].RequestID < ids[j].RequestID
+	})
+}
+
+func (e UndefinedDeploymentClusters) Error() string {
+	ids := make([]string, len(e))
+	for i, id := range e {
+		ids[i] = id.String()
+	}
+	return fmt.Sprintf("deployments to undefined clusters: %s", strings.Join(ids, "; "))
+}
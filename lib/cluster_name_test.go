@@ -0,0 +1,141 @@
+package sous
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testClusters = Clusters{
+	"us-west": {Name: "us-west", BaseURL: "http://us-west"},
+	"eu-west": {Name: "eu-west", BaseURL: "http://eu-west"},
+}
+
+func TestClustersLookup(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, c := range []ClusterName{"us-west", "http://us-west"} {
+		name, cl, ok := testClusters.Lookup(c)
+		if assert.True(ok, "%s", c) {
+			assert.Equal("us-west", name)
+			assert.Equal("http://us-west", cl.BaseURL)
+		}
+	}
+	_, _, ok := testClusters.Lookup("prod-uswest2")
+	assert.False(ok)
+
+	c, err := testClusters.ClusterName("eu-west")
+	assert.NoError(err)
+	assert.Equal(ClusterName("eu-west"), c)
+	_, err = testClusters.ClusterName("eu-wets")
+	assert.Equal(UnknownCluster{Cluster: "eu-wets", Known: []string{"eu-west", "us-west"}}, err)
+}
+
+func TestDiffClustersRefusesUndefinedClusters(t *testing.T) {
+	assert := assert.New(t)
+
+	intended := Deployments{makeDepl("github.com/opentable/one", 1), makeDepl("github.com/opentable/two", 1)}
+	intended[0].Cluster = "http://us-west"
+	intended[1].Cluster = "http://us-wset"
+	running := Deployments{makeDepl("github.com/opentable/one", 1)}
+	running[0].Cluster = "http://nowhere"
+
+	_, err := running.DiffClusters(testClusters, intended)
+	if assert.IsType(UndefinedDeploymentClusters{}, err) {
+		assert.Equal(UndefinedDeploymentClusters{
+			{Cluster: "http://nowhere", Source: SourceLocation{RepoURL: "github.com/opentable/one"}},
+			{Cluster: "http://us-wset", Source: SourceLocation{RepoURL: "github.com/opentable/two"}},
+		}, err)
+		assert.Contains(err.Error(), "github.com/opentable/two on http://us-wset")
+	}
+
+	dcs, err := running[:0].DiffClusters(testClusters, intended[:1])
+	if assert.NoError(err) {
+		r := CollectDiff(dcs)
+		assert.Len(r.Created, 1)
+	}
+}
+
+func TestFreezesInMatchesBaseURLs(t *testing.T) {
+	d := makeDepl("github.com/opentable/one", 1)
+	d.Cluster = "http://us-west"
+	fs := Freezes{{Cluster: "us-west"}}
+
+	_, ok := fs.Frozen(d, time.Now())
+	assert.False(t, ok, "a freeze by name shouldn't match a base URL without In")
+	f, ok := fs.In(testClusters).Frozen(d, time.Now())
+	if assert.True(t, ok) {
+		assert.Equal(t, "freeze on us-west", f.String())
+	}
+}
+
+// stringClient is a RectificationClient written before ClusterName.
+type stringClient struct {
+	LegacyRectificationClient
+	posted string
+}
+
+func (c *stringClient) PostRequest(cluster, reqID string, instanceCount int) error {
+	c.posted = cluster
+	return nil
+}
+
+func TestFromLegacyClient(t *testing.T) {
+	c := &stringClient{}
+	if err := FromLegacyClient(c).PostRequest("http://us-west", "reqid", 1); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "http://us-west", c.posted)
+}
+
+// inspectingClient is a stringClient that has since gained some of the
+// optional interfaces.
+type inspectingClient struct {
+	stringClient
+	inspected, posted string
+}
+
+func (c *inspectingClient) InspectRequest(cluster ClusterName, reqID string) (*Deployment, error) {
+	c.inspected = reqID
+	return nil, nil
+}
+
+func (c *inspectingClient) PostRequestOfKind(cluster ClusterName, reqID string, instanceCount int, kind ManifestKind, schedule string) error {
+	c.posted = reqID
+	return nil
+}
+
+func (c *inspectingClient) DeployHistory(cluster ClusterName, reqID string, count int) ([]DeployRecord, error) {
+	return []DeployRecord{{RequestID: reqID}}, nil
+}
+
+func TestFromLegacyClientKeepsOptionalInterfaces(t *testing.T) {
+	assert := assert.New(t)
+
+	plain := FromLegacyClient(&stringClient{})
+	_, isRI := plain.(RequestInspector)
+	_, isKP := plain.(KindPoster)
+	assert.False(isRI || isKP, "a client without optional interfaces shouldn't gain them")
+	_, err := plain.DeployHistory("cluster", "reqid", 1)
+	assert.Error(err)
+
+	c := &inspectingClient{}
+	rc := FromLegacyClient(c)
+	if ri, ok := rc.(RequestInspector); assert.True(ok, "RequestInspector") {
+		_, err := ri.InspectRequest("cluster", "inspected")
+		assert.NoError(err)
+		assert.Equal("inspected", c.inspected)
+	}
+	if kp, ok := rc.(KindPoster); assert.True(ok, "KindPoster") {
+		assert.NoError(kp.PostRequestOfKind("cluster", "posted", 1, ManifestKindWorker, ""))
+		assert.Equal("posted", c.posted)
+	}
+	_, isID := rc.(InstanceDeployer)
+	_, isDC := rc.(DeployCanceller)
+	assert.False(isID || isDC, "only the interfaces the wrapped client has should be kept")
+	rs, err := rc.DeployHistory("cluster", "reqid", 1)
+	if assert.NoError(err) && assert.Len(rs, 1) {
+		assert.Equal("reqid", rs[0].RequestID)
+	}
+}
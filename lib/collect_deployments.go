@@ -0,0 +1,104 @@
+package sous
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// MaxConcurrentClusters limits how many clusters CollectExistingDeployments
+// reads the running deployments of at once.
+const MaxConcurrentClusters = 8
+
+type (
+	// CollectError is a failure to read the deployments running on a
+	// cluster.
+	CollectError struct {
+		Cluster ClusterName
+		Err     error
+	}
+
+	// CollectErrors is returned when the running deployments of some
+	// clusters couldn't be read, and that's fatal: see ResolveOpts.
+	CollectErrors []error
+)
+
+func (e *CollectError) Error() string {
+	return fmt.Sprintf("reading the deployments running on %s: %s", e.Cluster, e.Err)
+}
+
+func (es CollectErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// CollectExistingDeployments reads the deployments running on each of
+// clusters from client, reading up to MaxConcurrentClusters at once, and
+// sets the Cluster of each to the cluster it was read from. It returns the
+// deployments of every cluster that could be read, in the order of
+// clusters, and a *CollectError for each that couldn't, so that one dead
+// cluster doesn't hide the others. If ctx is cancelled, the clusters not yet
+// read fail with its error; calls already made are left to finish in the
+// background.
+func CollectExistingDeployments(ctx context.Context, client RectificationClient, clusters []string) (Deployments, []error) {
+	type result struct {
+		ds  Deployments
+		err error
+	}
+	results := make([]result, len(clusters))
+	sem := make(chan struct{}, MaxConcurrentClusters)
+	wg := &sync.WaitGroup{}
+	wg.Add(len(clusters))
+	for i, cluster := range clusters {
+		go func(i int, cluster ClusterName) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				results[i].err = &CollectError{Cluster: cluster, Err: ctx.Err()}
+				return
+			}
+			if err := ctx.Err(); err != nil {
+				<-sem
+				results[i].err = &CollectError{Cluster: cluster, Err: err}
+				return
+			}
+
+			done := make(chan result, 1)
+			go func() {
+				defer func() { <-sem }()
+				ds, err := client.RunningDeployments(cluster)
+				done <- result{ds, err}
+			}()
+			select {
+			case r := <-done:
+				if r.err != nil {
+					r.err = &CollectError{Cluster: cluster, Err: r.err}
+				}
+				results[i] = r
+			case <-ctx.Done():
+				results[i].err = &CollectError{Cluster: cluster, Err: ctx.Err()}
+			}
+		}(i, ClusterName(cluster))
+	}
+	wg.Wait()
+
+	ds := Deployments{}
+	var errs []error
+	for i, r := range results {
+		if r.err != nil {
+			errs = append(errs, r.err)
+			continue
+		}
+		for _, d := range r.ds {
+			d.Cluster = ClusterName(clusters[i])
+		}
+		ds = append(ds, r.ds...)
+	}
+	return ds, errs
+}
@@ -0,0 +1,129 @@
+package sous
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+// collectTestClient reads running deployments from a DummyRectificationClient,
+// failing for some clusters and blocking on others, and records how many
+// clusters it was reading at once.
+type collectTestClient struct {
+	*DummyRectificationClient
+	fail    map[ClusterName]error
+	block   chan struct{}
+	latency time.Duration
+
+	sync.Mutex
+	reading, maxReading int
+}
+
+func (c *collectTestClient) RunningDeployments(cluster ClusterName) (Deployments, error) {
+	c.Lock()
+	c.reading++
+	if c.reading > c.maxReading {
+		c.maxReading = c.reading
+	}
+	c.Unlock()
+	defer func() {
+		c.Lock()
+		c.reading--
+		c.Unlock()
+	}()
+
+	if c.block != nil {
+		<-c.block
+	}
+	time.Sleep(c.latency)
+	if err := c.fail[cluster]; err != nil {
+		return nil, err
+	}
+	return c.DummyRectificationClient.RunningDeployments(cluster)
+}
+
+func TestCollectExistingDeploymentsReturnsPartialResults(t *testing.T) {
+	assert := assert.New(t)
+
+	rc := &collectTestClient{
+		DummyRectificationClient: resolveTestClient(),
+		fail:                     map[ClusterName]error{"http://one": fmt.Errorf("connection refused")},
+	}
+	ds, errs := CollectExistingDeployments(context.Background(), rc, []string{"http://one", "http://two"})
+	if assert.Len(ds, 1) {
+		assert.Equal(ClusterName("http://two"), ds[0].Cluster)
+	}
+	if assert.Len(errs, 1) {
+		assert.Equal(&CollectError{Cluster: "http://one", Err: fmt.Errorf("connection refused")}, errs[0])
+		assert.Equal("reading the deployments running on http://one: connection refused", errs[0].Error())
+	}
+}
+
+func TestCollectExistingDeploymentsBoundsConcurrency(t *testing.T) {
+	rc := &collectTestClient{DummyRectificationClient: resolveTestClient(), latency: 5 * time.Millisecond}
+	clusters := make([]string, 3*MaxConcurrentClusters)
+	for i := range clusters {
+		clusters[i] = fmt.Sprintf("http://cluster%d", i)
+	}
+	_, errs := CollectExistingDeployments(context.Background(), rc, clusters)
+	assert.Empty(t, errs)
+	assert.True(t, rc.maxReading > 1, "clusters should be read in parallel")
+	assert.True(t, rc.maxReading <= MaxConcurrentClusters, "read %d clusters at once", rc.maxReading)
+}
+
+func TestCollectExistingDeploymentsIsCancellable(t *testing.T) {
+	assert := assert.New(t)
+
+	rc := &collectTestClient{DummyRectificationClient: resolveTestClient(), block: make(chan struct{})}
+	defer close(rc.block)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	done := make(chan []error)
+	go func() {
+		_, errs := CollectExistingDeployments(ctx, rc, []string{"http://one", "http://two"})
+		done <- errs
+	}()
+	select {
+	case errs := <-done:
+		if assert.Len(errs, 2) {
+			assert.Equal(context.Canceled, errs[0].(*CollectError).Err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("CollectExistingDeployments didn't return when cancelled")
+	}
+}
+
+func TestResolvePlanWithUnreadableCluster(t *testing.T) {
+	assert := assert.New(t)
+	state := resolveTestState()
+	rc := &collectTestClient{
+		DummyRectificationClient: resolveTestClient(),
+		fail:                     map[ClusterName]error{"http://two": fmt.Errorf("connection refused")},
+	}
+
+	_, err := ResolvePlanWith(context.Background(), rc, state, state.BaseURLs(), nil, ResolveOpts{})
+	if assert.IsType(CollectErrors{}, err) {
+		assert.Len(err, 1)
+	}
+
+	var skipped []error
+	plan, err := ResolvePlanWith(context.Background(), rc, state, state.BaseURLs(), nil, ResolveOpts{
+		SkipUnreadableClusters: true,
+		Skipped:                func(err error) { skipped = append(skipped, err) },
+	})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Len(skipped, 1)
+	r := CollectDiff(plan)
+	assert.Equal(2, r.Counts.Created)
+	assert.Equal(1, r.Counts.Deleted)
+	for _, d := range append(r.Created, r.Deleted...) {
+		assert.Equal(ClusterName("http://one"), d.Cluster, "nothing should change on the unreadable cluster")
+	}
+}
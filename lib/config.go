@@ -18,9 +18,61 @@ type (
 		DatabaseDriver string `env:"SOUS_DB_DRIVER"`
 		// DatabaseConnection is the database connection string for local persistence
 		DatabaseConnection string `env:"SOUS_DB_CONN"`
+		// BlockDowngrades stops rectify deploying versions older than those
+		// running, except to deployments that set AllowDowngrade.
+		BlockDowngrades bool `env:"SOUS_BLOCK_DOWNGRADES"`
+		// CompareRevisions makes rectify redeploy a version whose image has
+		// been rebuilt from a different revision.
+		CompareRevisions bool `env:"SOUS_COMPARE_REVISIONS"`
+		// RectifyOrdering is "creates-first", the default, "deletes-first"
+		// or "concurrent". See RectifyOrdering.
+		RectifyOrdering string `env:"SOUS_RECTIFY_ORDERING"`
+		// IgnoreEtagHosts is a comma separated list of the docker registry
+		// hosts whose etags the name cache ignores, because they change on
+		// every request. Images from those registries are cached for
+		// IgnoredEtagMaxAge, e.g. "30m", or DefaultIgnoredEtagMaxAge if
+		// that's empty.
+		IgnoreEtagHosts   string `env:"SOUS_IGNORE_ETAG_HOSTS"`
+		IgnoredEtagMaxAge string `env:"SOUS_IGNORED_ETAG_MAX_AGE"`
+		// MaxDeletes, MaxModifies and MaxCreates limit the number of
+		// deployments a rectify may delete, modify or create, and the
+		// Percent fields limit them as percentages of the deployments
+		// running. Zero means no limit. See BlastRadius.
+		MaxDeletes       int `env:"SOUS_MAX_DELETES"`
+		MaxDeletePercent int `env:"SOUS_MAX_DELETE_PERCENT"`
+		MaxModifies      int `env:"SOUS_MAX_MODIFIES"`
+		MaxModifyPercent int `env:"SOUS_MAX_MODIFY_PERCENT"`
+		MaxCreates       int `env:"SOUS_MAX_CREATES"`
+		MaxCreatePercent int `env:"SOUS_MAX_CREATE_PERCENT"`
+		// CircuitThreshold, if positive, is how many consecutive transient
+		// failures against a cluster make rectify skip it for
+		// CircuitCoolDown, e.g. "5m", or DefaultCircuitCoolDown if that's
+		// empty. See RectifyOpts.CircuitThreshold.
+		CircuitThreshold int    `env:"SOUS_CIRCUIT_THRESHOLD"`
+		CircuitCoolDown  string `env:"SOUS_CIRCUIT_COOL_DOWN"`
+		// Offline stops the name cache querying docker registries, for
+		// machines that can't reach them: images are looked up in the
+		// cache alone. See NameCacheOffline.
+		Offline bool `env:"SOUS_OFFLINE"`
+		// PreviewLedger is the file in which the previews deployed by
+		// sous deploy-preview are recorded, until sous preview-gc deletes
+		// them. See PreviewLedger.
+		PreviewLedger string `env:"SOUS_PREVIEW_LEDGER"`
 	}
 )
 
+// BlastRadius returns the limits c sets on rectification.
+func (c Config) BlastRadius() BlastRadius {
+	return BlastRadius{
+		MaxDeletes:       c.MaxDeletes,
+		MaxDeletePercent: c.MaxDeletePercent,
+		MaxModifies:      c.MaxModifies,
+		MaxModifyPercent: c.MaxModifyPercent,
+		MaxCreates:       c.MaxCreates,
+		MaxCreatePercent: c.MaxCreatePercent,
+	}
+}
+
 // DefaultConfig builds a default configuation, which can be then overridden by
 // client code
 func DefaultConfig() Config {
@@ -0,0 +1,167 @@
+package sous
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DeployConfigConflict is returned when a cluster's DeployConfig overrides a
+// value of the global DeployConfig with one of a different type, e.g. a
+// numeric resource with a non-numeric one.
+type DeployConfigConflict struct {
+	Cluster, Key string
+	Global       string
+	Override     string
+}
+
+// NamedDeployConfig is a DeployConfig named for where it's defined, e.g.
+// "Deployments.Global".
+type NamedDeployConfig struct {
+	Name string
+	DeployConfig
+}
+
+// Provenance maps each field set in a merged DeployConfig, e.g.
+// "NumInstances" or "Env.LOG_LEVEL", to the name of the config its value
+// came from.
+type Provenance map[string]string
+
+func (e *DeployConfigConflict) Error() string {
+	return fmt.Sprintf("cluster %s overrides %s: %q conflicts with global %q", e.Cluster, e.Key, e.Override, e.Global)
+}
+
+// ResolveDeployments merges global with each of perCluster to produce a
+// Deployment per cluster. See MergeDeployConfig for the merge semantics.
+func ResolveDeployments(global DeployConfig, perCluster map[string]DeployConfig) (Deployments, error) {
+	clusters := make([]string, 0, len(perCluster))
+	for c := range perCluster {
+		clusters = append(clusters, c)
+	}
+	sort.Strings(clusters)
+
+	ds := make(Deployments, 0, len(clusters))
+	for _, c := range clusters {
+		dc, err := MergeDeployConfig(c, global, perCluster[c])
+		if err != nil {
+			return nil, err
+		}
+		ds = append(ds, &Deployment{Cluster: ClusterName(c), DeployConfig: dc})
+	}
+	return ds, nil
+}
+
+// MergeDeployConfigs merges each of configs over those before it, as
+// MergeDeployConfig does, starting from an empty DeployConfig, and returns
+// the result along with where each of its fields came from.
+func MergeDeployConfigs(cluster string, configs ...NamedDeployConfig) (DeployConfig, Provenance, error) {
+	merged := DeployConfig{}
+	prov := Provenance{}
+	for _, c := range configs {
+		var err error
+		if merged, err = MergeDeployConfig(cluster, merged, c.DeployConfig); err != nil {
+			return DeployConfig{}, nil, err
+		}
+		prov.record(c.Name, c.DeployConfig)
+	}
+	return merged, prov, nil
+}
+
+// MergeDeployConfig deep-merges the override for a cluster onto global:
+// Resources, Env and Metadata merge key-wise with the override winning, even
+// with an empty value, and the keys the override removes, as those set to
+// null in a manifest do, are removed (see RemoveKey). Scalars and pointers
+// set in the override replace the global ones, so AllowDowngrade can be
+// turned on but not off, while NumInstances can be set to zero (see
+// SetNumInstances). Slices set in the override replace the global ones
+// wholesale. Neither argument is modified.
+func MergeDeployConfig(cluster string, global, override DeployConfig) (DeployConfig, error) {
+	merged := global.Clone()
+	merged.numInstancesSet = false
+	merged.removed = nil
+	for _, k := range override.RemovedKeys("Resources") {
+		delete(merged.Resources, k)
+	}
+	for _, k := range override.RemovedKeys("Env") {
+		delete(merged.Env, k)
+	}
+	for _, k := range override.RemovedKeys("Metadata") {
+		delete(merged.Metadata, k)
+	}
+
+	if len(override.Resources) > 0 && merged.Resources == nil {
+		merged.Resources = Resources{}
+	}
+	for k, v := range override.Resources {
+		if gv, ok := merged.Resources[k]; ok && isNumeric(gv) != isNumeric(v) {
+			return DeployConfig{}, &DeployConfigConflict{
+				Cluster: cluster, Key: "Resources." + k, Global: gv, Override: v,
+			}
+		}
+		merged.Resources[k] = v
+	}
+
+	merged.Env = merged.Env.Merge(override.Env)
+
+	if len(override.Metadata) > 0 && merged.Metadata == nil {
+		merged.Metadata = map[string]string{}
+	}
+	for k, v := range override.Metadata {
+		merged.Metadata[k] = v
+	}
+
+	if override.NumInstancesSet() {
+		merged.NumInstances = override.NumInstances
+	}
+	if override.Args != nil {
+		merged.Args = append([]string{}, override.Args...)
+	}
+	if override.Volumes != nil {
+		merged.Volumes = override.Volumes.Clone()
+	}
+	if override.Rollout != nil {
+		r := *override.Rollout
+		merged.Rollout = &r
+	}
+	if override.AllowDowngrade {
+		merged.AllowDowngrade = true
+	}
+	return merged, nil
+}
+
+// record notes that the fields set in dc came from the config named source,
+// and that the keys it removes came from nowhere.
+func (p Provenance) record(source string, dc DeployConfig) {
+	recordKeys := func(field string, m map[string]string) {
+		for _, k := range dc.RemovedKeys(field) {
+			delete(p, field+"."+k)
+		}
+		for k := range m {
+			p[field+"."+k] = source
+		}
+	}
+	recordKeys("Resources", dc.Resources)
+	recordKeys("Env", dc.Env)
+	recordKeys("Metadata", dc.Metadata)
+	if dc.NumInstancesSet() {
+		p["NumInstances"] = source
+	}
+	if dc.Args != nil {
+		p["Args"] = source
+	}
+	if dc.Volumes != nil {
+		p["Volumes"] = source
+	}
+	if dc.Rollout != nil {
+		p["Rollout"] = source
+	}
+	if dc.AllowDowngrade {
+		p["AllowDowngrade"] = source
+	}
+}
+
+func isNumeric(s string) bool {
+	_, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return err == nil
+}
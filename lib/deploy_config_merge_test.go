@@ -0,0 +1,226 @@
+package sous
+
+import (
+	"testing"
+
+	"github.com/opentable/sous/util/yaml"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveDeployments(t *testing.T) {
+	assert := assert.New(t)
+
+	global := DeployConfig{
+		Resources:    Resources{"cpus": "0.1", "memory": "100"},
+		Env:          Env{"A": "1", "B": "2"},
+		Args:         []string{"-global"},
+		NumInstances: 2,
+		Volumes:      Volumes{&Volume{"/g", "/g", "RO"}},
+	}
+	perCluster := map[string]DeployConfig{
+		"east": {
+			Resources:    Resources{"memory": "200"},
+			Env:          Env{"B": "east"},
+			NumInstances: 4,
+		},
+		"west": {
+			Args:    []string{"-west"},
+			Volumes: Volumes{},
+		},
+	}
+
+	ds, err := ResolveDeployments(global, perCluster)
+	if !assert.NoError(err) || !assert.Len(ds, 2) {
+		return
+	}
+
+	east, west := ds[0], ds[1]
+	assert.Equal(ClusterName("east"), east.Cluster)
+	assert.Equal(Resources{"cpus": "0.1", "memory": "200"}, east.Resources)
+	assert.Equal(Env{"A": "1", "B": "east"}, east.Env)
+	assert.Equal(4, east.NumInstances)
+	assert.Equal([]string{"-global"}, east.Args)
+	assert.Len(east.DeployConfig.Volumes, 1)
+
+	assert.Equal(ClusterName("west"), west.Cluster)
+	assert.Equal(2, west.NumInstances)
+	assert.Equal([]string{"-west"}, west.Args)
+	assert.Len(west.DeployConfig.Volumes, 0)
+
+	// the inputs are untouched
+	assert.Equal("100", global.Resources["memory"])
+	assert.Equal("2", global.Env["B"])
+}
+
+func TestResolveDeploymentsConflict(t *testing.T) {
+	global := DeployConfig{Resources: Resources{"cpus": "0.1"}}
+	perCluster := map[string]DeployConfig{
+		"east": {Resources: Resources{"cpus": "lots"}},
+	}
+
+	_, err := ResolveDeployments(global, perCluster)
+	if assert.IsType(t, &DeployConfigConflict{}, err) {
+		conflict := err.(*DeployConfigConflict)
+		assert.Equal(t, "east", conflict.Cluster)
+		assert.Equal(t, "Resources.cpus", conflict.Key)
+	}
+}
+
+func TestMergeDeployConfigRemovesKeys(t *testing.T) {
+	assert := assert.New(t)
+	global := DeployConfig{
+		Resources: Resources{"cpus": "0.1", "ports": "1"},
+		Env:       Env{"A": "1", "B": "2"},
+		Metadata:  map[string]string{"team": "one"},
+	}
+	override := DeployConfig{Env: Env{"C": ""}}
+	override.RemoveKey("Resources", "ports")
+	override.RemoveKey("Env", "B")
+	override.RemoveKey("Metadata", "team")
+
+	merged, err := MergeDeployConfig("east", global, override)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(Resources{"cpus": "0.1"}, merged.Resources)
+	assert.Equal(Env{"A": "1", "C": ""}, merged.Env)
+	assert.Empty(merged.Metadata)
+	assert.Empty(merged.RemovedKeys("Env"), "the merged config removes nothing itself")
+	assert.Equal("2", global.Env["B"], "global should be untouched")
+}
+
+func TestMergeDeployConfigsKeepsEmptyValues(t *testing.T) {
+	assert := assert.New(t)
+	merged, _, err := MergeDeployConfigs("east",
+		NamedDeployConfig{Name: "global", DeployConfig: DeployConfig{
+			Env:      Env{"FLAG": "", "A": "1"},
+			Metadata: map[string]string{"note": ""},
+		}},
+		NamedDeployConfig{Name: "east", DeployConfig: DeployConfig{
+			Env: Env{"B": ""},
+		}},
+	)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(Env{"FLAG": "", "A": "1", "B": ""}, merged.Env, "empty vars should survive inheritance")
+	assert.Equal(map[string]string{"note": ""}, merged.Metadata)
+}
+
+func TestMergeDeployConfigsProvenance(t *testing.T) {
+	assert := assert.New(t)
+	east := DeployConfig{
+		Resources: Resources{"memory": "200"},
+		Args:      []string{"-east"},
+	}
+	east.RemoveKey("Env", "REGION")
+	merged, prov, err := MergeDeployConfigs("east",
+		NamedDeployConfig{Name: "defaults", DeployConfig: DeployConfig{
+			Env: Env{"REGION": "east", "LOG": "info"},
+		}},
+		NamedDeployConfig{Name: "global", DeployConfig: DeployConfig{
+			Resources:    Resources{"cpus": "0.1", "memory": "100"},
+			Env:          Env{"LOG": "debug"},
+			NumInstances: 2,
+		}},
+		NamedDeployConfig{Name: "east", DeployConfig: east},
+	)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(Env{"LOG": "debug"}, merged.Env)
+	assert.Equal(Provenance{
+		"Resources.cpus":   "global",
+		"Resources.memory": "east",
+		"Env.LOG":          "global",
+		"NumInstances":     "global",
+		"Args":             "east",
+	}, prov)
+}
+
+func TestMergeDeployConfigZeroInstances(t *testing.T) {
+	assert := assert.New(t)
+	global := DeployConfig{NumInstances: 3}
+	off := DeployConfig{}
+	off.SetNumInstances(0)
+
+	merged, err := MergeDeployConfig("east", global, off)
+	if assert.NoError(err) {
+		assert.Equal(0, merged.NumInstances, "an explicit 0 should override")
+	}
+	merged, err = MergeDeployConfig("east", global, DeployConfig{})
+	if assert.NoError(err) {
+		assert.Equal(3, merged.NumInstances, "an unset NumInstances should be inherited")
+	}
+}
+
+func TestPartialDeploySpecYAMLNumInstances(t *testing.T) {
+	assert := assert.New(t)
+	specs := DeploySpecs{}
+	err := yaml.UnmarshalStrict([]byte(`
+Global: {NumInstances: 3, Version: 1.0.0}
+east: {NumInstances: 0, Version: 1.0.0}
+west: {Version: 1.0.0}
+`), &specs)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.True(specs["east"].NumInstancesSet())
+	assert.False(specs["west"].NumInstancesSet())
+
+	// an unset NumInstances is left out, so that it's inherited when read
+	// back, but an explicit 0 is kept
+	b, err := yaml.Marshal(specs)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(`Global:
+  NumInstances: 3
+  Volumes: []
+  Version: 1.0.0
+east:
+  NumInstances: 0
+  Volumes: []
+  Version: 1.0.0
+west:
+  Volumes: []
+  Version: 1.0.0
+`, string(b))
+}
+
+func TestPartialDeploySpecYAMLNulls(t *testing.T) {
+	assert := assert.New(t)
+	specs := DeploySpecs{}
+	err := yaml.UnmarshalStrict([]byte(`
+Global: {Env: {FLAG: "", DEBUG: "1", A: "1"}, Version: 1.0.0}
+east:
+  Env: {DEBUG: null, A: ""}
+  Resources: {memory: ~}
+  Version: 1.0.0
+`), &specs)
+	if !assert.NoError(err) {
+		return
+	}
+	east := specs["east"]
+	assert.Equal(Env{"A": ""}, east.Env)
+	assert.Equal([]string{"DEBUG"}, east.RemovedKeys("Env"))
+	assert.Equal([]string{"memory"}, east.RemovedKeys("Resources"))
+	assert.Empty(specs["Global"].RemovedKeys("Env"), "an empty var isn't null")
+
+	merged, err := MergeDeployConfig("east", specs["Global"].DeployConfig, east.DeployConfig)
+	if assert.NoError(err) {
+		assert.Equal(Env{"FLAG": "", "A": ""}, merged.Env)
+	}
+
+	// nulls are written back as nulls
+	b, err := yaml.Marshal(east)
+	if !assert.NoError(err) {
+		return
+	}
+	var again PartialDeploySpec
+	if assert.NoError(yaml.UnmarshalStrict(b, &again), "%s", b) {
+		assert.Equal(east.RemovedKeys("Env"), again.RemovedKeys("Env"), "%s", b)
+		assert.Equal(east.RemovedKeys("Resources"), again.RemovedKeys("Resources"), "%s", b)
+		assert.Equal(Env{"A": ""}, again.Env)
+	}
+}
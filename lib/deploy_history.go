@@ -0,0 +1,101 @@
+package sous
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/opentable/go-singularity/dtos"
+)
+
+// DeployRecord is a deploy made on a request, as returned by
+// RectificationClient.DeployHistory.
+type DeployRecord struct {
+	Cluster   ClusterName
+	RequestID string
+	DeployID  string
+	// Time is when the deploy was made, and User who made it, if known.
+	Time time.Time
+	User string
+	// Message is the message the deploy was made with, if any.
+	Message string
+	// Result is the outcome of the deploy, e.g. "SUCCEEDED" or "FAILED", or
+	// empty if it hasn't finished. ResultMessage explains it, if need be.
+	Result        string
+	ResultMessage string
+	// ImageName is the image deployed. SourceVersion is the source version
+	// it was built from, or nil if the ImageMapper doesn't know it, e.g.
+	// because sous didn't build it.
+	ImageName     string
+	SourceVersion *SourceVersion
+}
+
+// DeployHistory reads the most recent count deploys made on the request reqID
+// from Singularity, newest first.
+func (ra *RectiAgent) DeployHistory(cluster ClusterName, reqID string, count int) ([]DeployRecord, error) {
+	client := ra.singularityClient(string(cluster))
+	hs, err := client.GetDeploys(reqID, int32(count), 1)
+	if err != nil {
+		return nil, err
+	}
+	rs := make([]DeployRecord, 0, len(hs))
+	for _, h := range hs {
+		if h.DeployMarker == nil {
+			return nil, malformedResponse{fmt.Sprintf("Singularity deploy history for %s included a deploy without a marker", reqID)}
+		}
+		r := DeployRecord{
+			Cluster:   cluster,
+			RequestID: reqID,
+			DeployID:  h.DeployMarker.DeployId,
+			Time:      millisTime(h.DeployMarker.Timestamp),
+			User:      h.DeployMarker.User,
+			Message:   h.DeployMarker.Message,
+		}
+		if res := h.DeployResult; res != nil {
+			r.Result, r.ResultMessage = string(res.DeployState), res.Message
+		}
+		r.ImageName = deployImage(h.Deploy)
+		r.SourceVersion = ra.resolveImage(r.ImageName)
+		rs = append(rs, r)
+	}
+	// the vendored client doesn't send count, so Singularity sends its
+	// default number of deploys
+	sortDeployRecords(rs)
+	if len(rs) > count {
+		rs = rs[:count]
+	}
+	return rs, nil
+}
+
+// resolveImage returns the source version the name cache has for the
+// image in, or nil if it doesn't have one.
+func (ra *RectiAgent) resolveImage(in string) *SourceVersion {
+	if in == "" {
+		return nil
+	}
+	sv, err := ra.nameCache.GetSourceVersion(in)
+	if err != nil {
+		Log.Debug.Printf("No source version for %s: %s", in, err)
+		return nil
+	}
+	return &sv
+}
+
+// deployImage returns the docker image of d, or "" if it hasn't one.
+func deployImage(d *dtos.SingularityDeploy) string {
+	if d == nil || d.ContainerInfo == nil || d.ContainerInfo.Docker == nil {
+		return ""
+	}
+	return d.ContainerInfo.Docker.Image
+}
+
+// millisTime converts a Singularity timestamp, in milliseconds since the
+// epoch, to a time.Time.
+func millisTime(ms int64) time.Time {
+	return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond))
+}
+
+// sortDeployRecords sorts rs newest first.
+func sortDeployRecords(rs []DeployRecord) {
+	sort.SliceStable(rs, func(i, j int) bool { return rs[i].Time.After(rs[j].Time) })
+}
@@ -16,10 +16,10 @@ type (
 		// DeployConfig contains configuration info for this deployment,
 		// including environment variables, resources, suggested instance count.
 		DeployConfig `yaml:"inline"`
-		// Cluster is the name of the cluster this deployment belongs to. Upon
-		// parsing the Manifest, this will be set to the key in
-		// Manifests.Deployments which points at this Deployment.
-		Cluster string
+		// Cluster is the cluster this deployment belongs to. Deployments
+		// built from a State have the BaseURL of the cluster named by the key
+		// in Manifests.Deployments which points at this Deployment.
+		Cluster ClusterName
 		// SourceVersion is the precise version of the software to be deployed.
 		SourceVersion SourceVersion
 		// Owners is a map of named owners of this repository. The type of this
@@ -27,6 +27,10 @@ type (
 		Owners OwnerSet
 		// Kind is the kind of software that SourceRepo represents.
 		Kind ManifestKind
+		// Schedule is the cron schedule of a scheduled deployment. It's used
+		// when the request is created, but isn't compared, since requests
+		// aren't rescheduled yet.
+		Schedule string `yaml:",omitempty"`
 
 		// Volumes enumerates the volume mappings required
 		Volumes Volumes
@@ -51,6 +55,9 @@ type (
 		// RequestID stores the Singularity Request ID that was used for this
 		// deployment
 		RequestID string
+		// ForeignImage is set to the docker image of a running deployment
+		// whose image wasn't built by sous, so its SourceVersion is unknown
+		ForeignImage string
 	}
 
 	// DeploymentPredicate takes a *Deployment and returns true if the deployment
@@ -74,10 +81,31 @@ type (
 		Sequence LogicalSequence
 	}
 
-	// A DepName is the name of a deployment
-	DepName struct {
-		cluster string
-		source  SourceLocation
+	// A DeploymentID identifies a deployment: there is at most one
+	// deployment of a source location to each cluster. It's comparable, so
+	// it can key maps of deployments.
+	DeploymentID struct {
+		Cluster ClusterName
+		// Source is canonical, so that deployments whose repo URLs differ
+		// only in case or trailing slashes have the same ID.
+		Source SourceLocation
+		// RequestID is only set for a running deployment of an image not
+		// built by sous, which has no source location to tell it apart.
+		RequestID string `json:",omitempty"`
+	}
+
+	// A DepName is the name of a deployment.
+	//
+	// Deprecated: use DeploymentID.
+	DepName = DeploymentID
+
+	// DuplicateDeployment is returned when two manifests describe the same
+	// deployment: they deploy to the same cluster from source locations that
+	// are the same once canonicalised.
+	DuplicateDeployment struct {
+		ID DeploymentID
+		// Manifests are the names of the two manifests.
+		Manifests [2]string
 	}
 
 	// OwnerSet collects the names of the owners of a deployment
@@ -144,24 +172,37 @@ func (ds Deployments) Filter(p DeploymentPredicate) Deployments {
 	return out
 }
 
-// BuildDeployment constructs a deployment out of a Manifest
+// BuildDeployment constructs a deployment out of a Manifest, merging spec
+// over the specs it inherits from, in order (see MergeDeployConfig)
 func BuildDeployment(m *Manifest, spec PartialDeploySpec, inherit DeploymentSpecs) (*Deployment, error) {
+	configs := make([]NamedDeployConfig, 0, len(inherit)+1)
+	for _, i := range inherit {
+		configs = append(configs, NamedDeployConfig{DeployConfig: i.DeployConfig})
+	}
+	configs = append(configs, NamedDeployConfig{DeployConfig: spec.DeployConfig})
+	d, _, err := newDeployment(m, spec, configs)
+	return d, err
+}
+
+// newDeployment constructs the deployment of spec out of a Manifest, with
+// the DeployConfig merged from configs (see MergeDeployConfigs).
+func newDeployment(m *Manifest, spec PartialDeploySpec, configs []NamedDeployConfig) (*Deployment, Provenance, error) {
 	ownMap := OwnerSet{}
 	for i := range m.Owners {
 		ownMap.Add(m.Owners[i])
 	}
+	dc, prov, err := MergeDeployConfigs(spec.clusterName, configs...)
+	if err != nil {
+		return nil, nil, err
+	}
 	return &Deployment{
-		Cluster: spec.clusterName,
-		DeployConfig: DeployConfig{
-			Resources:    spec.Resources,
-			Env:          spec.Env,
-			NumInstances: spec.NumInstances,
-			Volumes:      spec.Volumes,
-		},
+		Cluster:       ClusterName(spec.clusterName),
+		DeployConfig:  dc,
 		Owners:        ownMap,
 		Kind:          m.Kind,
+		Schedule:      m.Schedule,
 		SourceVersion: m.Source.SourceVersion(spec.Version),
-	}, nil
+	}, prov, nil
 }
 
 func (d *Deployment) String() string {
@@ -227,15 +268,36 @@ func (d *Deployment) Tabbed() string {
 	)
 }
 
-// Name returns the DepName for a Deployment
+// ID returns the DeploymentID of d.
+func (d *Deployment) ID() DeploymentID {
+	if d.ForeignImage != "" {
+		return DeploymentID{Cluster: d.Cluster, RequestID: d.RequestID}
+	}
+	return DeploymentID{
+		Cluster: d.Cluster,
+		Source:  d.SourceVersion.CanonicalName().Canonical(),
+	}
+}
+
+// Name returns the ID of d.
+//
+// Deprecated: use ID.
 func (d *Deployment) Name() DepName {
-	return DepName{
-		cluster: d.Cluster,
-		source:  d.SourceVersion.CanonicalName(),
+	return d.ID()
+}
+
+func (id DeploymentID) String() string {
+	if id.RequestID != "" {
+		return fmt.Sprintf("request %s on %s", id.RequestID, id.Cluster)
 	}
+	return fmt.Sprintf("%s on %s", id.Source, id.Cluster)
+}
+
+func (e *DuplicateDeployment) Error() string {
+	return fmt.Sprintf("manifests %q and %q both deploy %s", e.Manifests[0], e.Manifests[1], e.ID)
 }
 
-// Equal returns true if two Deployments are equal
+// Equal returns true if two Deployments are equal, ignoring Metadata
 func (d *Deployment) Equal(o *Deployment) bool {
 	Log.Debug.Printf("%+ v ?= %+ v", d, o)
 	if !(d.Cluster == o.Cluster && d.SourceVersion.Equal(o.SourceVersion) && d.Kind == o.Kind) { // && len(d.Owners) == len(o.Owners)) {
@@ -250,3 +312,54 @@ func (d *Deployment) Equal(o *Deployment) bool {
 	}
 	return d.DeployConfig.Equal(o.DeployConfig)
 }
+
+// Clone returns a deep copy of d, which shares no maps, slices or pointers
+// with it. Deployments are cloned as they're sent between goroutines, so that
+// the sender can go on changing its own copy.
+func (d *Deployment) Clone() *Deployment {
+	c := *d
+	c.DeployConfig = d.DeployConfig.Clone()
+	c.Owners = d.Owners.Clone()
+	c.Volumes = d.Volumes.Clone()
+	return &c
+}
+
+// Clone returns a deep copy of dc
+func (dc DeployConfig) Clone() DeployConfig {
+	c := dc
+	c.Resources = dc.Resources.Clone()
+	c.Env = dc.Env.Clone()
+	c.Volumes = dc.Volumes.Clone()
+	if dc.Metadata != nil {
+		c.Metadata = make(map[string]string, len(dc.Metadata))
+		for k, v := range dc.Metadata {
+			c.Metadata[k] = v
+		}
+	}
+	if dc.Args != nil {
+		c.Args = append([]string{}, dc.Args...)
+	}
+	if dc.Rollout != nil {
+		r := *dc.Rollout
+		c.Rollout = &r
+	}
+	if dc.removed != nil {
+		c.removed = make(map[string]bool, len(dc.removed))
+		for k := range dc.removed {
+			c.removed[k] = true
+		}
+	}
+	return c
+}
+
+// Clone returns a copy of os
+func (os OwnerSet) Clone() OwnerSet {
+	if os == nil {
+		return nil
+	}
+	c := make(OwnerSet, len(os))
+	for o := range os {
+		c[o] = struct{}{}
+	}
+	return c
+}
@@ -14,6 +14,9 @@ type (
 		request       sRequest
 		req           SingReq
 		rectification RectificationClient
+		// keepForeign makes images not built by sous be recorded in
+		// Target.ForeignImage instead of failing construction
+		keepForeign bool
 	}
 
 	canRetryRequest struct {
@@ -68,7 +71,7 @@ func (uc *deploymentBuilder) canRetry(err error) error {
 }
 
 func (uc *deploymentBuilder) CompleteConstruction() error {
-	uc.Target.Cluster = uc.req.SourceURL
+	uc.Target.Cluster = ClusterName(uc.req.SourceURL)
 	uc.request = uc.req.ReqParent.Request
 
 	err := uc.retrieveDeploy()
@@ -141,11 +144,25 @@ func (uc *deploymentBuilder) retrieveImageLabels() error {
 	labels, err := uc.rectification.ImageLabels(imageName)
 	Log.Debug.Print("Labels: ", labels, err)
 	if err != nil {
-		return malformedResponse{err.Error()}
+		if !uc.keepForeign {
+			return malformedResponse{err.Error()}
+		}
+		// only an image known not to have been built by sous is foreign: one
+		// that couldn't be looked up, e.g. because the registry is down, may
+		// well have been
+		if _, ok := err.(NoSourceVersionFound); !ok {
+			return err
+		}
+		uc.Target.ForeignImage = imageName
+		return nil
 	}
 
 	uc.Target.SourceVersion, err = SourceVersionFromLabels(labels)
 	if err != nil {
+		if uc.keepForeign {
+			uc.Target.ForeignImage = imageName
+			return nil
+		}
 		return malformedResponse{fmt.Sprintf("For reqID: %s, %s", uc.req.ReqParent.Request.Id, err.Error())}
 	}
 
@@ -171,14 +188,7 @@ func (uc *deploymentBuilder) unpackDeployConfig() error {
 		uc.Target.Owners.Add(o)
 	}
 
-	for _, v := range uc.deploy.ContainerInfo.Volumes {
-		uc.Target.DeployConfig.Volumes = append(uc.Target.DeployConfig.Volumes,
-			&Volume{
-				Host:      v.HostPath,
-				Container: v.ContainerPath,
-				Mode:      VolumeMode(v.Mode),
-			})
-	}
+	uc.Target.DeployConfig.Volumes = singularityVolumes(uc.deploy.ContainerInfo.Volumes)
 	Log.Debug.Printf("%+v", uc.Target.DeployConfig.Volumes)
 	if len(uc.Target.DeployConfig.Volumes) > 0 {
 		Log.Debug.Printf("%+v", uc.Target.DeployConfig.Volumes[0])
@@ -199,8 +209,22 @@ func (uc *deploymentBuilder) determineManifestKind() error {
 		uc.Target.Kind = ManifestKindOnDemand
 	case dtos.SingularityRequestRequestTypeSCHEDULED:
 		uc.Target.Kind = ManifestKindScheduled
+		uc.Target.Schedule = uc.request.Schedule
 	case dtos.SingularityRequestRequestTypeRUN_ONCE:
 		uc.Target.Kind = ManifestKindOnce
 	}
 	return nil
 }
+
+// singularityVolumes converts the volumes of a Singularity deploy.
+func singularityVolumes(svs dtos.SingularityVolumeList) Volumes {
+	var vols Volumes
+	for _, v := range svs {
+		vols = append(vols, &Volume{
+			Host:      v.HostPath,
+			Container: v.ContainerPath,
+			Mode:      VolumeMode(v.Mode),
+		})
+	}
+	return vols
+}
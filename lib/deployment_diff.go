@@ -1,9 +1,16 @@
 package sous
 
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
 type (
 	// DeploymentPair is a pair of deployments that represent a "before and after" style relationship
 	DeploymentPair struct {
-		name        DepName
+		name        DeploymentID
 		prior, post *Deployment
 	}
 	// DeploymentPairs is a list of DeploymentPair
@@ -15,39 +22,182 @@ type (
 	}
 
 	differ struct {
-		from map[DepName]*Deployment
+		from map[DeploymentID]*Deployment
 		DiffChans
 	}
 
 	// DiffChans is a set of channels that represent differences between two sets
-	// of Deployments as they're discovered
+	// of Deployments as they're discovered. Retained carries the deployments
+	// that matched exactly.
 	DiffChans struct {
 		Created, Deleted, Retained chan *Deployment
 		Modified                   chan *DeploymentPair
 	}
+
+	// DiffReport is a DiffChans drained into slices, e.g. for reporting what
+	// a rectification would change
+	DiffReport struct {
+		Created, Deleted, Retained Deployments
+		Modified                   DeploymentPairs
+		Counts                     DiffCounts
+	}
+
+	// DiffCounts counts the entries of each kind in a DiffReport
+	DiffCounts struct {
+		Created, Deleted, Modified, Retained int
+		// Recreated is how many of the Modified can only be made by
+		// deleting and creating the request again: see
+		// DeploymentPair.Recreates.
+		Recreated int `json:",omitempty"`
+	}
 )
 
+// CollectDiff drains all the channels of dcs, which must eventually be
+// closed, into a DiffReport
+func CollectDiff(dcs DiffChans) DiffReport {
+	r := DiffReport{
+		Created:  make(Deployments, 0),
+		Deleted:  make(Deployments, 0),
+		Retained: make(Deployments, 0),
+		Modified: make(DeploymentPairs, 0),
+	}
+
+	// drained concurrently, since the sender may block on any of them
+	drain := func(c chan *Deployment, into *Deployments, wg *sync.WaitGroup) {
+		for d := range c {
+			*into = append(*into, d)
+		}
+		wg.Done()
+	}
+	wg := &sync.WaitGroup{}
+	wg.Add(4)
+	go drain(dcs.Created, &r.Created, wg)
+	go drain(dcs.Deleted, &r.Deleted, wg)
+	go drain(dcs.Retained, &r.Retained, wg)
+	go func() {
+		for m := range dcs.Modified {
+			r.Modified = append(r.Modified, m)
+		}
+		wg.Done()
+	}()
+	wg.Wait()
+
+	r.Counts = DiffCounts{
+		Created:  len(r.Created),
+		Deleted:  len(r.Deleted),
+		Modified: len(r.Modified),
+		Retained: len(r.Retained),
+	}
+	for _, m := range r.Modified {
+		if m.Recreates() {
+			r.Counts.Recreated++
+		}
+	}
+	return r
+}
+
 func (d *DiffChans) collect() diffSet {
-	ds := diffSet{
-		make(Deployments, 0),
-		make(Deployments, 0),
-		make(Deployments, 0),
-		make(DeploymentPairs, 0),
+	r := CollectDiff(*d)
+	return diffSet{
+		New:     r.Created,
+		Gone:    r.Deleted,
+		Same:    r.Retained,
+		Changed: r.Modified,
 	}
+}
+
+// Prior returns the deployment as it was before the change
+func (dp *DeploymentPair) Prior() *Deployment {
+	return dp.prior
+}
 
-	for g := range d.Deleted {
-		ds.Gone = append(ds.Gone, g)
+// Post returns the deployment as it is after the change
+func (dp *DeploymentPair) Post() *Deployment {
+	return dp.post
+}
+
+// Recreates reports whether the change can't be made to the request in
+// place, because the kind of deployment changes, and Singularity can't change
+// the type of a request: it must be deleted and created again. A deployment
+// whose kind is unknown, as it is when it's read from a cluster by a client
+// that doesn't report kinds, is taken to keep its kind.
+func (dp *DeploymentPair) Recreates() bool {
+	return dp.prior.Kind != "" && dp.post.Kind != "" && dp.prior.Kind != dp.post.Kind
+}
+
+// MarshalJSON implements json.Marshaler
+func (dp *DeploymentPair) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Prior, Post *Deployment
+	}{dp.prior, dp.post})
+}
+
+// Differences explains how the post deployment differs from the prior one,
+// one line per difference, e.g. "version: 1.0.0 -> 1.1.0". Env var values
+// are left out, since they may be secret; only the names of the vars that
+// were added, removed or changed are given.
+func (dp *DeploymentPair) Differences() []string {
+	prior, post := dp.prior, dp.post
+	diffs := []string{}
+	if !prior.SourceVersion.Equal(post.SourceVersion) {
+		diffs = append(diffs, fmt.Sprintf("version: %s -> %s",
+			prior.SourceVersion.Version, post.SourceVersion.Version))
+	}
+	if dp.Recreates() {
+		diffs = append(diffs, fmt.Sprintf("kind: %s -> %s (recreates the request)", prior.Kind, post.Kind))
+	} else if prior.Kind != post.Kind {
+		diffs = append(diffs, fmt.Sprintf("kind: %s -> %s", prior.Kind, post.Kind))
+	}
+	if prior.NumInstances != post.NumInstances {
+		diffs = append(diffs, fmt.Sprintf("instances: %d -> %d",
+			prior.NumInstances, post.NumInstances))
+	}
+	for _, rd := range prior.Resources.Diff(post.Resources) {
+		diffs = append(diffs, "resources."+rd.String())
+	}
+	var added, removed, changed []string
+	for _, name := range unionKeys(prior.Env, post.Env) {
+		was, hadIt := prior.Env[name]
+		is, hasIt := post.Env[name]
+		switch {
+		case !hadIt:
+			added = append(added, name)
+		case !hasIt:
+			removed = append(removed, name)
+		case was != is:
+			changed = append(changed, name)
+		}
+	}
+	for _, e := range []struct {
+		what  string
+		names []string
+	}{{"added", added}, {"removed", removed}, {"changed", changed}} {
+		if len(e.names) != 0 {
+			diffs = append(diffs, fmt.Sprintf("env %s: %v", e.what, e.names))
+		}
 	}
-	for n := range d.Created {
-		ds.New = append(ds.New, n)
+	if !prior.Volumes.Equal(post.Volumes) {
+		diffs = append(diffs, "volumes changed")
 	}
-	for m := range d.Modified {
-		ds.Changed = append(ds.Changed, m)
+	if !prior.Owners.Equal(post.Owners) {
+		diffs = append(diffs, "owners changed")
+	}
+	return diffs
+}
+
+// unionKeys returns the sorted keys of both maps
+func unionKeys(a, b map[string]string) []string {
+	keys := []string{}
+	for k := range a {
+		keys = append(keys, k)
 	}
-	for s := range d.Retained {
-		ds.Same = append(ds.Same, s)
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
 	}
-	return ds
+	sort.Strings(keys)
+	return keys
 }
 
 // NewDiffChans constructs a DiffChans
@@ -83,12 +233,24 @@ func (d Deployments) Diff(other Deployments) DiffChans {
 	return difr.DiffChans
 }
 
+// DiffClusters is like Diff, but first checks that every deployment in d
+// and other is to one of clusters. If not, it compares nothing, and returns
+// UndefinedDeploymentClusters listing those that aren't.
+func (d Deployments) DiffClusters(clusters Clusters, other Deployments) (DiffChans, error) {
+	undefined := append(d.UndefinedClusters(clusters), other.UndefinedClusters(clusters)...)
+	if len(undefined) != 0 {
+		sortDeploymentIDs(undefined)
+		return DiffChans{}, undefined
+	}
+	return d.Diff(other), nil
+}
+
 func newDiffer(intended Deployments) *differ {
 	Log.Debug.Print("Computing diff from:", intended)
 
-	startMap := make(map[DepName]*Deployment)
+	startMap := make(map[DeploymentID]*Deployment)
 	for _, dep := range intended {
-		startMap[dep.Name()] = dep
+		startMap[dep.ID()] = dep
 	}
 	return &differ{
 		from:      startMap,
@@ -99,21 +261,21 @@ func newDiffer(intended Deployments) *differ {
 func (d *differ) diff(existing Deployments) {
 	Log.Debug.Print("Computing diff to: ", existing)
 	for i := range existing {
-		name := existing[i].Name()
+		name := existing[i].ID()
 		if indep, ok := d.from[name]; ok {
 			delete(d.from, name)
 			if indep.Equal(existing[i]) {
-				d.Retained <- indep
+				d.Retained <- indep.Clone()
 			} else {
-				d.Modified <- &DeploymentPair{name, indep, existing[i]}
+				d.Modified <- &DeploymentPair{name, indep.Clone(), existing[i].Clone()}
 			}
 		} else {
-			d.Created <- existing[i]
+			d.Created <- existing[i].Clone()
 		}
 	}
 
 	for _, dep := range d.from {
-		d.Deleted <- dep
+		d.Deleted <- dep.Clone()
 	}
 
 	d.DiffChans.Close()
@@ -1,6 +1,7 @@
 package sous
 
 import (
+	"encoding/json"
 	"log"
 	"testing"
 
@@ -80,7 +81,7 @@ func TestRealDiff(t *testing.T) {
 	}
 
 	if assert.Len(ds.Changed, 1, "Should have one modified item.") {
-		assert.Equal(repoThree, string(ds.Changed[0].name.source.RepoURL))
+		assert.Equal(repoThree, string(ds.Changed[0].name.Source.RepoURL))
 		assert.Equal(repoThree, string(ds.Changed[0].prior.SourceVersion.RepoURL))
 		assert.Equal(repoThree, string(ds.Changed[0].post.SourceVersion.RepoURL))
 		assert.Equal(ds.Changed[0].post.NumInstances, 1)
@@ -92,3 +93,77 @@ func TestRealDiff(t *testing.T) {
 	}
 
 }
+
+func TestCollectDiff(t *testing.T) {
+	assert := assert.New(t)
+
+	intended := Deployments{
+		makeDepl("https://github.com/opentable/one", 1),
+		makeDepl("https://github.com/opentable/two", 1),
+	}
+	existing := Deployments{
+		makeDepl("https://github.com/opentable/two", 3),
+		makeDepl("https://github.com/opentable/three", 1),
+		makeDepl("https://github.com/opentable/four", 1),
+		makeDepl("https://github.com/opentable/five", 1),
+	}
+
+	// more creates than the channels' buffers hold
+	r := CollectDiff(intended.Diff(existing))
+
+	assert.Equal(DiffCounts{Created: 3, Deleted: 1, Modified: 1, Retained: 0}, r.Counts)
+	if assert.Len(r.Modified, 1) {
+		assert.Equal(1, r.Modified[0].Prior().NumInstances)
+		assert.Equal(3, r.Modified[0].Post().NumInstances)
+	}
+
+	js, err := json.Marshal(r)
+	assert.NoError(err)
+	assert.Contains(string(js), `"Counts":{"Created":3,"Deleted":1,"Modified":1,"Retained":0}`)
+	assert.Contains(string(js), `"Prior":`)
+}
+
+func TestDeploymentPairDifferences(t *testing.T) {
+	prior := makeDepl("https://github.com/opentable/one", 1)
+	prior.Resources = Resources{"cpus": "0.1", "memory": "32"}
+	prior.Env = Env{"A": "a", "B": "secret"}
+	post := prior.Clone()
+	post.Resources["cpus"] = "0.2"
+	delete(post.Resources, "memory")
+	post.Env["B"] = "other secret"
+
+	dp := &DeploymentPair{prior: prior, post: post}
+	assert.Equal(t, []string{
+		"resources.cpus: 0.1 -> 0.2",
+		"resources.memory: 32 -> (none)",
+		"env changed: [B]",
+	}, dp.Differences())
+}
+
+func TestDiffMatchesCanonicalRepoURLs(t *testing.T) {
+	assert := assert.New(t)
+
+	intended := Deployments{makeDepl("github.com/opentable/one", 1)}
+	existing := Deployments{makeDepl("github.com/OpenTable/one/", 1)}
+
+	r := CollectDiff(intended.Diff(existing))
+	assert.Equal(DiffCounts{Modified: 1}, r.Counts)
+}
+
+func TestDeploymentPairRecreates(t *testing.T) {
+	assert := assert.New(t)
+	prior := makeDepl("https://github.com/opentable/one", 1)
+	prior.Kind = ManifestKindService
+	post := prior.Clone()
+
+	assert.False((&DeploymentPair{prior: prior, post: post}).Recreates())
+	post.Kind = ManifestKindWorker
+	dp := &DeploymentPair{prior: prior, post: post}
+	assert.True(dp.Recreates())
+	assert.Equal([]string{"kind: http-service -> worker (recreates the request)"}, dp.Differences())
+	r := DiffReport{Modified: DeploymentPairs{dp, {prior: prior, post: prior}}}
+	assert.Equal(DiffCounts{Modified: 2, Recreated: 1}, CollectDiff(r.Chans()).Counts)
+
+	prior.Kind = ""
+	assert.False(dp.Recreates(), "an unknown kind is taken to be unchanged")
+}
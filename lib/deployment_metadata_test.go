@@ -0,0 +1,68 @@
+package sous
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadataOnlyEditIsRetained(t *testing.T) {
+	assert := assert.New(t)
+
+	existing := makeDepl("github.com/opentable/one", 1)
+	existing.Metadata = map[string]string{"team": "platform"}
+	intended := existing.Clone()
+	intended.Metadata["team"] = "search"
+	intended.Metadata["slack"] = "#search"
+
+	assert.True(intended.Equal(existing))
+	r := CollectDiff(Deployments{intended}.Diff(Deployments{existing}))
+	assert.Equal(DiffCounts{Retained: 1}, r.Counts)
+}
+
+func TestRectifyMetadataOnlyEditMakesNoCalls(t *testing.T) {
+	prior := makeDepl("github.com/opentable/one", 1)
+	post := prior.Clone()
+	post.Metadata = map[string]string{"tier": "1"}
+
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	chanset := NewDiffChans(1)
+	errs := Rectify(chanset, client)
+	chanset.Modified <- &DeploymentPair{prior: prior, post: post}
+	chanset.Close()
+	for e := range errs {
+		t.Error(e)
+	}
+
+	assert.Empty(t, client.Calls())
+}
+
+func TestRectifyAuditsMetadata(t *testing.T) {
+	audit := NewMemoryAuditor()
+	d := makeDepl("github.com/opentable/one", 1)
+	d.Metadata = map[string]string{"team": "platform"}
+
+	chanset := NewDiffChans(1)
+	errs := RectifyWith(chanset, NewDummyRectificationClient(NewDummyNameCache()), RectifyOpts{Auditor: audit})
+	chanset.Deleted <- d
+	chanset.Close()
+	for e := range errs {
+		t.Error(e)
+	}
+
+	if entries := audit.Entries(); assert.Len(t, entries, 1) {
+		assert.Equal(t, d.Metadata, entries[0].Metadata)
+	}
+}
+
+func TestMergeDeployConfigMetadata(t *testing.T) {
+	global := DeployConfig{Metadata: map[string]string{"team": "platform", "tier": "2"}}
+	override := DeployConfig{Metadata: map[string]string{"tier": "1"}}
+	override.RemoveKey("Metadata", "team")
+
+	merged, err := MergeDeployConfig("east", global, override)
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]string{"tier": "1"}, merged.Metadata)
+	}
+	assert.Equal(t, "2", global.Metadata["tier"], "global was modified")
+}
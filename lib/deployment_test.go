@@ -1,6 +1,7 @@
 package sous
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/samsalisbury/semv"
@@ -37,6 +38,34 @@ func TestCanonName(t *testing.T) {
 	assert.Regexp("two", str)
 }
 
+func TestDeploymentID(t *testing.T) {
+	assert := assert.New(t)
+	id := func(cluster, repo, offset string) DeploymentID {
+		d := &Deployment{
+			Cluster:       ClusterName(cluster),
+			SourceVersion: SourceVersion{RepoURL: RepoURL(repo), RepoOffset: RepoOffset(offset)},
+		}
+		return d.ID()
+	}
+
+	base := id("cluster", "github.com/opentable/one", "")
+	assert.Equal(DeploymentID{Cluster: "cluster", Source: SourceLocation{RepoURL: "github.com/opentable/one"}}, base)
+	assert.Equal(base, id("cluster", "github.com/OpenTable/One", ""), "case shouldn't matter")
+	assert.Equal(base, id("cluster", "github.com/opentable/one/", ""), "trailing slashes shouldn't matter")
+	assert.Equal(id("cluster", "github.com/opentable/one", "sub"), id("cluster", "GitHub.com/opentable/one//", "sub/"))
+	assert.NotEqual(base, id("cluster", "github.com/opentable/one", "sub"))
+	assert.NotEqual(base, id("other", "github.com/opentable/one", ""))
+
+	foreign := func(reqID string) *Deployment {
+		d := &Deployment{Cluster: "cluster", ForeignImage: "docker.example.com/theirs:1"}
+		d.RequestID = reqID
+		return d
+	}
+	assert.NotEqual(foreign("one").ID(), foreign("two").ID(), "foreign deployments are told apart by request")
+	assert.Equal(foreign("one").ID(), foreign("one").Name())
+	assert.Equal("request one on cluster", foreign("one").ID().String())
+}
+
 func TestBuildDeployment(t *testing.T) {
 	assert := assert.New(t)
 	m := &Manifest{
@@ -67,3 +96,87 @@ func TestBuildDeployment(t *testing.T) {
 		}
 	}
 }
+
+func TestDeploymentClone(t *testing.T) {
+	assert := assert.New(t)
+
+	owners := OwnerSet{}
+	owners.Add("judson")
+	dep := &Deployment{
+		SourceVersion: SourceVersion{RepoURL: RepoURL("one")},
+		DeployConfig: DeployConfig{
+			NumInstances: 2,
+			Args:         []string{"-v"},
+			Env:          Env{"A": "1"},
+			Resources:    Resources{"cpus": "0.1"},
+			Volumes:      Volumes{&Volume{"/h", "/c", "RO"}},
+			Rollout:      &Rollout{Increment: "1"},
+		},
+		Cluster:    "cluster",
+		Owners:     owners,
+		Volumes:    Volumes{&Volume{"/th", "/tc", "RO"}},
+		Annotation: Annotation{RequestID: "req"},
+	}
+
+	c := dep.Clone()
+	assert.Equal(dep, c)
+
+	c.Env["A"] = "2"
+	c.Resources["cpus"] = "1"
+	c.Args[0] = "-q"
+	c.DeployConfig.Volumes[0].Mode = "RW"
+	c.Volumes[0].Mode = "RW"
+	c.Rollout.Increment = "2"
+	c.Owners.Add("sam")
+
+	assert.Equal("1", dep.Env["A"])
+	assert.Equal("0.1", dep.Resources["cpus"])
+	assert.Equal("-v", dep.Args[0])
+	assert.Equal(VolumeMode("RO"), dep.DeployConfig.Volumes[0].Mode)
+	assert.Equal(VolumeMode("RO"), dep.Volumes[0].Mode)
+	assert.Equal("1", dep.Rollout.Increment)
+	assert.Len(dep.Owners, 1)
+}
+
+// Meaningful under the race detector: the source is mutated while a clone of
+// it is rectified.
+func TestRectifyCloneWhileSourceMutates(t *testing.T) {
+	src := &Deployment{
+		SourceVersion: SourceVersion{RepoURL: RepoURL("one")},
+		DeployConfig: DeployConfig{
+			NumInstances: 1,
+			Env:          Env{"A": "1"},
+			Resources:    Resources{"cpus": "0.1"},
+		},
+		Cluster: "cluster",
+	}
+	changed := src.Clone()
+	changed.Env["A"] = "2"
+
+	// Once the pair has been received from the diff, it's the rectifier's
+	// own: the deployments it was cloned from may change meanwhile.
+	pair := <-Deployments{src}.Diff(Deployments{changed}).Modified
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			src.Env["A"] = fmt.Sprint(i)
+			src.Resources["cpus"] = "0.2"
+			changed.Env["A"] = fmt.Sprint(i)
+		}
+		close(done)
+	}()
+
+	chanset := NewDiffChans(1)
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	errs := Rectify(chanset, client)
+	chanset.Modified <- pair
+	chanset.Close()
+	for e := range errs {
+		t.Error(e)
+	}
+	<-done
+	if assert.Len(t, client.deployed, 1) {
+		assert.Equal(t, Env{"A": "2"}, client.deployed[0].e)
+		assert.Equal(t, "0.1", client.deployed[0].res["cpus"])
+	}
+}
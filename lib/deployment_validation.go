@@ -0,0 +1,168 @@
+package sous
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+)
+
+type (
+	// ResourceRule describes a resource a Deployment may or must specify.
+	// Resources listed in ResourceRules must be numeric, and are compared
+	// as numbers.
+	ResourceRule struct {
+		Name     string
+		Required bool
+		// Integer resources must be whole numbers
+		Integer bool
+		// Default is the value Singularity is sent when the resource is
+		// missing, so a missing resource compares equal to it.
+		Default string
+	}
+
+	// InvalidDeploymentError is returned in place of rectifying a deployment
+	// that failed validation
+	InvalidDeploymentError struct {
+		// Existing is the running deployment being changed, if any
+		Existing *Deployment
+		// Deployment is the intended deployment that failed validation
+		Deployment *Deployment
+		Errs       []error
+	}
+
+	// fieldError is a problem with the value of one field of a
+	// DeployConfig, named as in Provenance.
+	fieldError struct {
+		field string
+		error
+	}
+)
+
+// ResourceRules lists the resources Deployment.Validate checks.
+var ResourceRules = []ResourceRule{
+	{Name: "cpus", Required: true, Default: "0.1"},
+	{Name: "memory", Required: true, Default: "100"},
+	{Name: "ports", Required: false, Integer: true, Default: "1"},
+}
+
+// Validate checks that a deployment can be sent to a cluster, and returns
+// every problem it finds.
+func (d *Deployment) Validate() []error {
+	errs := []error{}
+	if d.Cluster == "" {
+		errs = append(errs, fmt.Errorf("cluster is empty"))
+	}
+	if d.NumInstances < 0 {
+		errs = append(errs, fieldError{"NumInstances", fmt.Errorf("instance count %d is negative", d.NumInstances)})
+	}
+	errs = append(errs, d.Resources.Validate(ResourceRules)...)
+	errs = append(errs, d.Env.Validate()...)
+	for _, v := range d.DeployConfig.Volumes {
+		if v == nil {
+			errs = append(errs, fieldError{"Volumes", fmt.Errorf("volume is nil")})
+			continue
+		}
+		if !path.IsAbs(v.Host) {
+			errs = append(errs, fieldError{"Volumes", fmt.Errorf("volume host path %q is not absolute", v.Host)})
+		}
+		if !path.IsAbs(v.Container) {
+			errs = append(errs, fieldError{"Volumes", fmt.Errorf("volume container path %q is not absolute", v.Container)})
+		}
+	}
+	return errs
+}
+
+func (e *InvalidDeploymentError) Error() string {
+	msgs := make([]string, 0, len(e.Errs))
+	for _, err := range e.Errs {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("Invalid deployment %+v: %s", e.Deployment, strings.Join(msgs, "; "))
+}
+
+// ExistingDeployment returns the running deployment, if any
+func (e *InvalidDeploymentError) ExistingDeployment() *Deployment {
+	return e.Existing
+}
+
+// IntendedDeployment returns the deployment that failed validation
+func (e *InvalidDeploymentError) IntendedDeployment() *Deployment {
+	return e.Deployment
+}
+
+// Kind is always ValidationError
+func (e *InvalidDeploymentError) Kind() ErrorKind { return ValidationError }
+
+// Retryable is always false: the deployment has to be fixed
+func (e *InvalidDeploymentError) Retryable() bool { return false }
+
+// ValidateAll passes the valid deployments from dcs on to the returned
+// DiffChans, and reports the invalid ones on the returned error channel
+// instead. Deletes aren't validated, so that invalid deployments can still be
+// removed. Both returned channels must be drained; the error channel is
+// closed once dcs has been.
+func ValidateAll(dcs DiffChans) (DiffChans, chan RectificationError) {
+	valid := NewDiffChans(cap(dcs.Created))
+	errs := make(chan RectificationError)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(4)
+	go func() {
+		for d := range dcs.Created {
+			if es := d.Validate(); len(es) > 0 {
+				errs <- &InvalidDeploymentError{Deployment: d, Errs: es}
+				continue
+			}
+			valid.Created <- d
+		}
+		close(valid.Created)
+		wg.Done()
+	}()
+	go func() {
+		for p := range dcs.Modified {
+			if es := p.post.Validate(); len(es) > 0 {
+				errs <- &InvalidDeploymentError{Existing: p.prior, Deployment: p.post, Errs: es}
+				continue
+			}
+			valid.Modified <- p
+		}
+		close(valid.Modified)
+		wg.Done()
+	}()
+	go func() {
+		for d := range dcs.Deleted {
+			valid.Deleted <- d
+		}
+		close(valid.Deleted)
+		wg.Done()
+	}()
+	go func() {
+		for d := range dcs.Retained {
+			valid.Retained <- d
+		}
+		close(valid.Retained)
+		wg.Done()
+	}()
+	go func() { wg.Wait(); close(errs) }()
+
+	return valid, errs
+}
+
+// mergeRectificationErrors forwards the errors from each of chans onto a
+// single channel, which is closed once they all have been.
+func mergeRectificationErrors(chans ...chan RectificationError) chan RectificationError {
+	out := make(chan RectificationError)
+	wg := &sync.WaitGroup{}
+	wg.Add(len(chans))
+	for _, c := range chans {
+		go func(c chan RectificationError) {
+			for err := range c {
+				out <- err
+			}
+			wg.Done()
+		}(c)
+	}
+	go func() { wg.Wait(); close(out) }()
+	return out
+}
@@ -0,0 +1,86 @@
+package sous
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func validDeployment() *Deployment {
+	return &Deployment{
+		SourceVersion: SourceVersion{RepoURL: RepoURL("reqid")},
+		DeployConfig: DeployConfig{
+			NumInstances: 1,
+			Resources:    Resources{"cpus": "0.1", "memory": "100", "ports": "1"},
+			Env:          Env{"GOOD_NAME": "x"},
+			Volumes:      Volumes{&Volume{"/host", "/container", "RO"}},
+		},
+		Cluster: "cluster",
+	}
+}
+
+func TestDeploymentValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Empty(validDeployment().Validate())
+
+	cases := []struct {
+		name   string
+		mutate func(*Deployment)
+	}{
+		{"empty cluster", func(d *Deployment) { d.Cluster = "" }},
+		{"negative instances", func(d *Deployment) { d.NumInstances = -1 }},
+		{"missing cpus", func(d *Deployment) { delete(d.Resources, "cpus") }},
+		{"missing memory", func(d *Deployment) { delete(d.Resources, "memory") }},
+		{"non-numeric memory", func(d *Deployment) { d.Resources["memory"] = "lots" }},
+		{"fractional ports", func(d *Deployment) { d.Resources["ports"] = "1.5" }},
+		{"env name with =", func(d *Deployment) { d.Env["A=B"] = "x" }},
+		{"empty env name", func(d *Deployment) { d.Env[""] = "x" }},
+		{"relative host path", func(d *Deployment) { d.DeployConfig.Volumes[0].Host = "host" }},
+		{"relative container path", func(d *Deployment) { d.DeployConfig.Volumes[0].Container = "c" }},
+	}
+	for _, c := range cases {
+		d := validDeployment()
+		c.mutate(d)
+		assert.Len(d.Validate(), 1, c.name)
+	}
+
+	d := validDeployment()
+	delete(d.Resources, "ports")
+	assert.Empty(d.Validate(), "ports is optional")
+}
+
+func TestValidateAll(t *testing.T) {
+	assert := assert.New(t)
+
+	invalid := validDeployment()
+	invalid.NumInstances = -3
+	invalid.SourceVersion.RepoURL = "invalid"
+	gone := &Deployment{Cluster: "cluster", SourceVersion: SourceVersion{RepoURL: "gone"}}
+
+	dcs := NewDiffChans(1)
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	valid, invalids := ValidateAll(dcs)
+	errs := mergeRectificationErrors(invalids, Rectify(valid, client))
+
+	go func() {
+		dcs.Created <- validDeployment()
+		dcs.Created <- invalid
+		dcs.Modified <- &DeploymentPair{prior: validDeployment(), post: invalid}
+		dcs.Deleted <- gone
+		dcs.Close()
+	}()
+
+	count := 0
+	for e := range errs {
+		count++
+		if assert.IsType(&InvalidDeploymentError{}, e) {
+			assert.Equal(invalid, e.IntendedDeployment())
+			assert.False(e.Retryable())
+		}
+	}
+	assert.Equal(2, count)
+	assert.Len(client.created, 1)
+	assert.Len(client.deleted, 1)
+	assert.Len(client.scaled, 0)
+}
@@ -6,3 +6,7 @@ const (
 	DockerVersionLabel  = "com.opentable.sous.version"
 	DockerRevisionLabel = "com.opentable.sous.revision"
 )
+
+// DockerLabels are the labels sous needs on an image to tell what source it
+// was built from: see SourceVersionFromLabels.
+var DockerLabels = []string{DockerRepoLabel, DockerPathLabel, DockerVersionLabel, DockerRevisionLabel}
@@ -0,0 +1,61 @@
+package sous
+
+import (
+	"fmt"
+
+	"github.com/samsalisbury/semv"
+)
+
+// DowngradeBlocked is returned instead of deploying a version older than
+// the one running, when RectifyOpts.BlockDowngrades is set and neither the
+// deployment's AllowDowngrade nor RectifyOpts.ForceDowngrades is.
+type DowngradeBlocked struct {
+	Deployments *DeploymentPair
+}
+
+func (e *DowngradeBlocked) Error() string {
+	return fmt.Sprintf("refusing to downgrade %s on %s from %s to %s: set AllowDowngrade: true in its manifest, or force the rectify",
+		e.Deployments.post.SourceVersion.RepoURL, e.Deployments.post.Cluster,
+		e.Deployments.prior.SourceVersion.Version, e.Deployments.post.SourceVersion.Version)
+}
+
+// ExistingDeployment returns the running deployment
+func (e *DowngradeBlocked) ExistingDeployment() *Deployment {
+	return e.Deployments.prior
+}
+
+// IntendedDeployment returns the older deployment that wasn't deployed
+func (e *DowngradeBlocked) IntendedDeployment() *Deployment {
+	return e.Deployments.post
+}
+
+// Kind is always ValidationError: the intended state has to change, or the
+// downgrade be allowed
+func (e *DowngradeBlocked) Kind() ErrorKind { return ValidationError }
+
+// Retryable is always false
+func (e *DowngradeBlocked) Retryable() bool { return false }
+
+// IsDowngrade reports whether deploying version to replace prior is a
+// downgrade: either its major, minor and patch are older, or they're the
+// same and both are prereleases, and version's prerelease is older. A
+// release and a prerelease of the same major, minor and patch, in either
+// order, are not a downgrade of one another, and metadata is ignored.
+func IsDowngrade(prior, version semv.Version) bool {
+	if version.MMPLess(prior) {
+		return true
+	}
+	if !version.MMPEqual(prior) || !version.IsPrerelease() || !prior.IsPrerelease() {
+		return false
+	}
+	return version.Less(prior)
+}
+
+// blocksDowngrade reports whether the deploy of pair.post should be skipped,
+// as it's a downgrade that isn't allowed.
+func (r *rectifier) blocksDowngrade(pair *DeploymentPair) bool {
+	if !r.BlockDowngrades || r.ForceDowngrades || pair.post.AllowDowngrade {
+		return false
+	}
+	return IsDowngrade(pair.prior.SourceVersion.Version, pair.post.SourceVersion.Version)
+}
@@ -0,0 +1,105 @@
+package sous
+
+import (
+	"testing"
+
+	"github.com/samsalisbury/semv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsDowngrade(t *testing.T) {
+	cases := []struct {
+		prior, version string
+		want           bool
+	}{
+		{"1.2.3", "1.2.3", false},
+		{"1.2.3", "1.2.4", false},
+		{"1.2.3", "2.0.0", false},
+		{"1.2.3", "1.2.2", true},
+		{"1.2.3", "1.1.9", true},
+		{"2.0.0", "1.9.9", true},
+		// prereleases and releases of the same version
+		{"1.2.3-rc.1", "1.2.3", false},
+		{"1.2.3", "1.2.3-rc.1", false},
+		{"1.2.3-rc.1", "1.2.3-rc.2", false},
+		{"1.2.3-rc.2", "1.2.3-rc.1", true},
+		{"1.2.3-beta", "1.2.3-alpha", true},
+		// prereleases of other versions
+		{"1.2.3-rc.1", "1.2.2", true},
+		{"1.2.3", "1.2.2-rc.1", true},
+		{"1.2.2", "1.2.3-rc.1", false},
+		// metadata is ignored
+		{"1.2.3+abc", "1.2.3+def", false},
+		{"1.2.3+def", "1.2.3+abc", false},
+	}
+	for _, c := range cases {
+		got := IsDowngrade(semv.MustParse(c.prior), semv.MustParse(c.version))
+		assert.Equal(t, c.want, got, "%s => %s", c.prior, c.version)
+	}
+}
+
+func downgradePair(allow bool) *DeploymentPair {
+	return &DeploymentPair{
+		prior: &Deployment{
+			SourceVersion: SourceVersion{RepoURL: "reqid", Version: semv.MustParse("2.0.0")},
+			DeployConfig:  DeployConfig{NumInstances: 1},
+			Cluster:       "cluster",
+		},
+		post: &Deployment{
+			SourceVersion: SourceVersion{RepoURL: "reqid", Version: semv.MustParse("1.0.0")},
+			DeployConfig:  DeployConfig{NumInstances: 2, AllowDowngrade: allow},
+			Cluster:       "cluster",
+		},
+	}
+}
+
+func rectifyPair(pair *DeploymentPair, opts RectifyOpts) (*DummyRectificationClient, []RectificationError) {
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	chanset := NewDiffChans(1)
+	errs := RectifyWith(chanset, client, opts)
+	chanset.Modified <- pair
+	chanset.Close()
+	got := []RectificationError{}
+	for e := range errs {
+		got = append(got, e)
+	}
+	return client, got
+}
+
+func TestRectifyBlocksDowngrades(t *testing.T) {
+	assert := assert.New(t)
+	pair := downgradePair(false)
+	client, errs := rectifyPair(pair, RectifyOpts{BlockDowngrades: true})
+
+	if assert.Len(errs, 1) {
+		blocked, ok := errs[0].(*DowngradeBlocked)
+		if assert.True(ok, "got %T; want *DowngradeBlocked", errs[0]) {
+			assert.Equal(pair.prior, blocked.ExistingDeployment())
+			assert.Equal(pair.post, blocked.IntendedDeployment())
+			assert.False(blocked.Retryable())
+		}
+	}
+	assert.Len(client.deployed, 0)
+	if assert.Len(client.scaled, 1, "the scale should still happen") {
+		assert.Equal(2, client.scaled[0].count)
+	}
+}
+
+func TestRectifyAllowsDowngrades(t *testing.T) {
+	cases := []struct {
+		name string
+		pair *DeploymentPair
+		opts RectifyOpts
+	}{
+		{"not blocked", downgradePair(false), RectifyOpts{}},
+		{"allowed by deployment", downgradePair(true), RectifyOpts{BlockDowngrades: true}},
+		{"forced", downgradePair(false), RectifyOpts{BlockDowngrades: true, ForceDowngrades: true}},
+	}
+	for _, c := range cases {
+		client, errs := rectifyPair(c.pair, c.opts)
+		assert.Len(t, errs, 0, c.name)
+		if assert.Len(t, client.deployed, 1, c.name) {
+			assert.Regexp(t, "1.0.0", client.deployed[0].imageName, c.name)
+		}
+	}
+}
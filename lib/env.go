@@ -0,0 +1,113 @@
+package sous
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/opentable/sous/util/yaml"
+)
+
+const (
+	// MaxEnvVarSize is the most bytes a single env var may take up, as
+	// NAME=value: Linux refuses to exec a process with a longer one.
+	MaxEnvVarSize = 128 * 1024
+	// MaxEnvSize is the most bytes an Env may take up in all, as
+	// NAME=value pairs. Singularity stores each deploy in a single
+	// ZooKeeper node, which is limited to 1MB, so this leaves room for the
+	// rest of the deploy.
+	MaxEnvSize = 512 * 1024
+)
+
+// envNameRE matches the env var names POSIX shells accept.
+var envNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Validate checks that e can be set as the environment of a process: that
+// every name is one POSIX shells accept, that no value contains a NUL or a
+// newline, and that neither any var nor the whole exceeds MaxEnvVarSize or
+// MaxEnvSize. It returns every problem it finds, in order of name.
+func (e Env) Validate() []error {
+	errs := []error{}
+	size := 0
+	for _, name := range e.names() {
+		value := e[name]
+		field := "Env." + name
+		if !envNameRE.MatchString(name) {
+			errs = append(errs, fieldError{field, fmt.Errorf("env var name %q is not legal", name)})
+		}
+		if strings.ContainsAny(value, "\x00\n") {
+			errs = append(errs, fieldError{field, fmt.Errorf("env var %s contains a NUL or newline", name)})
+		}
+		n := len(name) + 1 + len(value)
+		if n > MaxEnvVarSize {
+			errs = append(errs, fieldError{field, fmt.Errorf("env var %s is %d bytes, more than %d", name, n, MaxEnvVarSize)})
+		}
+		size += n
+	}
+	if size > MaxEnvSize {
+		errs = append(errs, fieldError{"Env", fmt.Errorf("env is %d bytes, more than %d", size, MaxEnvSize)})
+	}
+	return errs
+}
+
+// Clone returns a copy of e
+func (e Env) Clone() Env {
+	if e == nil {
+		return nil
+	}
+	c := make(Env, len(e))
+	for k, v := range e {
+		c[k] = v
+	}
+	return c
+}
+
+// Equal reports whether e and o set the same vars to the same values. A nil
+// Env is equal to an empty one.
+func (e Env) Equal(o Env) bool {
+	if len(e) != len(o) {
+		return false
+	}
+
+	for name, value := range e {
+		if ov, ok := o[name]; !ok || ov != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge returns a copy of e with overrides applied: each var in overrides
+// replaces the one in e, even with an empty value. Neither e nor overrides
+// is modified.
+func (e Env) Merge(overrides Env) Env {
+	merged := e.Clone()
+	if len(overrides) > 0 && merged == nil {
+		merged = Env{}
+	}
+	for name, value := range overrides {
+		merged[name] = value
+	}
+	return merged
+}
+
+// MarshalYAML serializes e as a YAML map with its keys in sorted order, so
+// that writing the same Env always produces the same YAML.
+func (e Env) MarshalYAML() (interface{}, error) {
+	m := make(yaml.MapSlice, 0, len(e))
+	for _, name := range e.names() {
+		m = append(m, yaml.MapItem{Key: name, Value: e[name]})
+	}
+	return m, nil
+}
+
+// names returns the names of the vars in e, sorted.
+func (e Env) names() []string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,47 @@
+package sous
+
+import (
+	"sort"
+	"strings"
+)
+
+// EnvFilter describes env vars that should be ignored when comparing the env
+// of deployments, typically because they are injected by the platform into
+// running deploys rather than set by sous.
+type EnvFilter struct {
+	// Names are ignored when a var's name matches one exactly.
+	Names []string
+	// Prefixes are ignored when a var's name starts with any of them.
+	Prefixes []string
+}
+
+// Ignores reports whether the filter ignores the env var called name.
+func (f EnvFilter) Ignores(name string) bool {
+	for _, n := range f.Names {
+		if name == n {
+			return true
+		}
+	}
+	for _, p := range f.Prefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Strip returns a copy of e without the vars the filter ignores, along with
+// the sorted names of the vars that were removed.
+func (f EnvFilter) Strip(e Env) (Env, []string) {
+	kept := make(Env, len(e))
+	ignored := []string{}
+	for name, value := range e {
+		if f.Ignores(name) {
+			ignored = append(ignored, name)
+			continue
+		}
+		kept[name] = value
+	}
+	sort.Strings(ignored)
+	return kept, ignored
+}
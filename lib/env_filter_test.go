@@ -0,0 +1,61 @@
+package sous
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvFilterStrip(t *testing.T) {
+	assert := assert.New(t)
+
+	f := EnvFilter{Names: []string{"TASK_HOST"}, Prefixes: []string{"OT_"}}
+	kept, ignored := f.Strip(Env{"TASK_HOST": "h", "OT_ENV_FLAVOR": "f", "TASK_HOSTNAME": "n", "APP": "1"})
+
+	assert.Equal(Env{"TASK_HOSTNAME": "n", "APP": "1"}, kept)
+	assert.Equal([]string{"OT_ENV_FLAVOR", "TASK_HOST"}, ignored)
+
+	kept, ignored = EnvFilter{}.Strip(Env{"A": "1"})
+	assert.Equal(Env{"A": "1"}, kept)
+	assert.Empty(ignored)
+}
+
+func TestModifyIgnoresInjectedEnv(t *testing.T) {
+	assert := assert.New(t)
+
+	pair := &DeploymentPair{
+		prior: &Deployment{
+			SourceVersion: SourceVersion{RepoURL: RepoURL("reqid")},
+			DeployConfig: DeployConfig{
+				NumInstances: 1,
+				Env:          Env{"APP": "1", "TASK_HOST": "h", "OT_ENV_FLAVOR": "f"},
+			},
+			Cluster: "cluster",
+		},
+		post: &Deployment{
+			SourceVersion: SourceVersion{RepoURL: RepoURL("reqid")},
+			DeployConfig: DeployConfig{
+				NumInstances: 1,
+				Env:          Env{"APP": "1"},
+			},
+			Cluster: "cluster",
+		},
+	}
+
+	rectify := func(opts RectifyOpts) *DummyRectificationClient {
+		chanset := NewDiffChans(1)
+		client := NewDummyRectificationClient(NewDummyNameCache())
+		errs := RectifyWith(chanset, client, opts)
+		chanset.Modified <- pair
+		chanset.Close()
+		for e := range errs {
+			t.Error(e)
+		}
+		return client
+	}
+
+	assert.Len(rectify(RectifyOpts{}).deployed, 1)
+
+	filter := EnvFilter{Names: []string{"TASK_HOST"}, Prefixes: []string{"OT_"}}
+	assert.Len(rectify(RectifyOpts{IgnoreEnv: filter}).deployed, 0)
+}
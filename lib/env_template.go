@@ -0,0 +1,103 @@
+package sous
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// EnvTemplateError is returned when a value of a deployment's Env, or the
+// host path of one of its volumes, is a template that can't be resolved,
+// e.g. because it refers to a cluster property that isn't defined.
+type EnvTemplateError struct {
+	Manifest SourceLocation
+	Cluster  string
+	// Var is the templated value, e.g. "Env.DATABASE_HOST" or
+	// "Volumes[0].Host".
+	Var string
+	Err error
+}
+
+func (e *EnvTemplateError) Error() string {
+	return fmt.Sprintf("manifest %s, cluster %s: %s: %s", e.Manifest, e.Cluster, e.Var, e.Err)
+}
+
+// templateContext returns what templates in the Env and volume host paths of
+// a deployment of sv to the cluster defined as name can refer to:
+//
+//	{{.Cluster.Name}}, {{.Cluster.BaseURL}} and {{.Cluster.<Property>}}
+//	{{.Source.RepoURL}}, {{.Source.RepoOffset}}, {{.Source.Version}} and
+//	{{.Source.Revision}}
+//
+// Nothing else is defined, so that a typo is an error rather than an empty
+// value.
+func templateContext(name string, cl Cluster, sv SourceVersion) map[string]map[string]string {
+	cluster := make(map[string]string, len(cl.Properties)+2)
+	for k, v := range cl.Properties {
+		cluster[k] = v
+	}
+	cluster["Name"] = name
+	cluster["BaseURL"] = cl.BaseURL
+	return map[string]map[string]string{
+		"Cluster": cluster,
+		"Source": {
+			"RepoURL":    string(sv.RepoURL),
+			"RepoOffset": string(sv.RepoOffset),
+			"Version":    sv.Version.Format("M.m.p-?"),
+			"Revision":   sv.RevID(),
+		},
+	}
+}
+
+// resolveTemplate executes value as a template with data. A value without
+// "{{" is returned as it is; a literal "{{" is written {{"{{"}}.
+func resolveTemplate(value string, data interface{}) (string, error) {
+	if !strings.Contains(value, "{{") {
+		return value, nil
+	}
+	t, err := template.New("").Option("missingkey=error").Parse(value)
+	if err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	if err := t.Execute(buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// resolveTemplates resolves the templates in d's Env and volume host paths,
+// for its deployment from m to the cluster defined as name.
+func (d *Deployment) resolveTemplates(m *Manifest, name string, cl Cluster) error {
+	data := templateContext(name, cl, d.SourceVersion)
+	fail := func(v string, err error) error {
+		return &EnvTemplateError{Manifest: m.Source, Cluster: name, Var: v, Err: err}
+	}
+
+	keys := make([]string, 0, len(d.Env))
+	for k := range d.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, err := resolveTemplate(d.Env[k], data)
+		if err != nil {
+			return fail("Env."+k, err)
+		}
+		d.Env[k] = v
+	}
+
+	for i, vol := range d.DeployConfig.Volumes {
+		if vol == nil {
+			continue
+		}
+		host, err := resolveTemplate(vol.Host, data)
+		if err != nil {
+			return fail(fmt.Sprintf("Volumes[%d].Host", i), err)
+		}
+		vol.Host = host
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package sous
+
+import (
+	"testing"
+
+	"github.com/samsalisbury/semv"
+	"github.com/stretchr/testify/assert"
+)
+
+func templatedState(env Env, vols Volumes) State {
+	return State{
+		Defs: Defs{Clusters: Clusters{
+			"us-west": {
+				Name:       "us-west",
+				BaseURL:    "http://us-west",
+				Properties: map[string]string{"DBHost": "db.us-west.example.com"},
+			},
+		}},
+		Manifests: Manifests{
+			"github.com/opentable/one": {
+				Source: SourceLocation{RepoURL: "github.com/opentable/one"},
+				Kind:   ManifestKindService,
+				Deployments: DeploySpecs{
+					"Global": {DeployConfig: DeployConfig{Env: env, Volumes: vols}},
+					"us-west": {
+						DeployConfig: DeployConfig{NumInstances: 1},
+						Version:      semv.MustParse("1.2.3"),
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestDeploymentsResolveTemplates(t *testing.T) {
+	assert := assert.New(t)
+
+	st := templatedState(Env{
+		"DATABASE_HOST": "{{.Cluster.DBHost}}",
+		"CLUSTER":       "{{.Cluster.Name}} at {{.Cluster.BaseURL}}",
+		"VERSION":       "{{.Source.RepoURL}}@{{.Source.Version}}",
+		"TEMPLATE":      `{{"{{"}}.Cluster.DBHost}}`,
+		"PLAIN":         "not a template",
+	}, Volumes{{Host: "/data/{{.Cluster.Name}}", Container: "/data", Mode: "RO"}})
+
+	ds, err := st.Deployments()
+	if !assert.NoError(err) || !assert.Len(ds, 1) {
+		return
+	}
+	assert.Equal(Env{
+		"DATABASE_HOST": "db.us-west.example.com",
+		"CLUSTER":       "us-west at http://us-west",
+		"VERSION":       "github.com/opentable/one@1.2.3",
+		"TEMPLATE":      "{{.Cluster.DBHost}}",
+		"PLAIN":         "not a template",
+	}, ds[0].Env)
+	assert.Equal("/data/us-west", ds[0].DeployConfig.Volumes[0].Host)
+
+	global := st.Manifests["github.com/opentable/one"].Deployments["Global"]
+	assert.Equal("{{.Cluster.DBHost}}", global.Env["DATABASE_HOST"], "the manifest shouldn't change")
+	assert.Equal("/data/{{.Cluster.Name}}", global.Volumes[0].Host)
+
+	// a deployment of the final values is the same deployment
+	final := Env{}
+	for k, v := range ds[0].Env {
+		final[k] = v
+	}
+	final["TEMPLATE"] = `{{"{{"}}.Cluster.DBHost}}`
+	st = templatedState(final, Volumes{{Host: "/data/us-west", Container: "/data", Mode: "RO"}})
+	literal, err := st.Deployments()
+	if assert.NoError(err) {
+		assert.True(ds[0].Equal(literal[0]))
+	}
+}
+
+func TestDeploymentsRejectUnknownTemplateReferences(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, c := range []struct {
+		env  Env
+		vols Volumes
+		v    string
+	}{
+		{Env{"DATABASE_PORT": "{{.Cluster.DBPort}}"}, nil, "Env.DATABASE_PORT"},
+		{Env{"OWNER": "{{.Manifest.Owner}}"}, nil, "Env.OWNER"},
+		{Env{"BROKEN": "{{.Cluster.DBHost"}, nil, "Env.BROKEN"},
+		{nil, Volumes{{Host: "/data/{{.Cluster.Region}}", Container: "/data"}}, "Volumes[0].Host"},
+	} {
+		st := templatedState(c.env, c.vols)
+		_, err := st.Deployments()
+		if assert.IsType(&EnvTemplateError{}, err, c.v) {
+			e := err.(*EnvTemplateError)
+			assert.Equal(SourceLocation{RepoURL: "github.com/opentable/one"}, e.Manifest)
+			assert.Equal("us-west", e.Cluster)
+			assert.Equal(c.v, e.Var)
+			assert.Contains(err.Error(), "manifest github.com/opentable/one, cluster us-west: "+c.v)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package sous
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/opentable/sous/util/yaml"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Empty(Env{"A": "1", "_B2": "", "LONG": strings.Repeat("x", MaxEnvVarSize-5)}.Validate())
+	assert.Empty(Env(nil).Validate())
+
+	errs := Env{
+		"2BAD":   "1",
+		"A-B":    "1",
+		"NUL":    "a\x00b",
+		"LINES":  "a\nb",
+		"HUGE":   strings.Repeat("x", MaxEnvVarSize),
+		"TABBED": "a\tb",
+	}.Validate()
+	msgs := []string{}
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	assert.Equal([]string{
+		`env var name "2BAD" is not legal`,
+		`env var name "A-B" is not legal`,
+		`env var HUGE is 131077 bytes, more than 131072`,
+		`env var LINES contains a NUL or newline`,
+		`env var NUL contains a NUL or newline`,
+	}, msgs)
+	if assert.IsType(fieldError{}, errs[0]) {
+		assert.Equal("Env.2BAD", errs[0].(fieldError).field)
+	}
+
+	big := Env{}
+	for _, name := range []string{"A", "B", "C", "D", "E"} {
+		big[name] = strings.Repeat("x", MaxEnvVarSize-2)
+	}
+	if errs := big.Validate(); assert.Len(errs, 1) {
+		assert.Equal("env is 655360 bytes, more than 524288", errs[0].Error())
+		assert.Equal("Env", errs[0].(fieldError).field)
+	}
+}
+
+func TestEnvEqual(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(Env(nil).Equal(Env{}))
+	assert.True(Env{}.Equal(nil))
+	assert.True(Env{"A": "1"}.Equal(Env{"A": "1"}))
+	assert.False(Env{"A": "1"}.Equal(Env{"A": "2"}))
+	assert.False(Env{"A": "1"}.Equal(Env{"B": "1"}))
+	assert.False(Env{"A": "1"}.Equal(nil))
+}
+
+func TestEnvMerge(t *testing.T) {
+	assert := assert.New(t)
+
+	global := Env{"A": "1", "B": "2"}
+	merged := global.Merge(Env{"B": "east", "C": "3", "A": ""})
+	assert.Equal(Env{"A": "", "B": "east", "C": "3"}, merged, "an empty value is still a value")
+	assert.Equal(Env{"A": "1", "B": "2"}, global, "global should be untouched")
+
+	assert.Nil(Env(nil).Merge(nil))
+	assert.Equal(Env{"A": "1"}, Env(nil).Merge(Env{"A": "1"}))
+	assert.Equal(Env{"A": ""}, Env(nil).Merge(Env{"A": ""}))
+}
+
+func TestEnvMarshalYAMLIsSorted(t *testing.T) {
+	assert := assert.New(t)
+
+	e := Env{}
+	for _, name := range []string{"ZED", "A10", "A2", "B", "a", "_X"} {
+		e[name] = "v"
+	}
+	want := "A10: v\nA2: v\nB: v\nZED: v\n_X: v\na: v\n"
+	for i := 0; i < 10; i++ {
+		b, err := yaml.Marshal(e)
+		if !assert.NoError(err) || !assert.Equal(want, string(b)) {
+			return
+		}
+	}
+
+	dc := DeployConfig{Env: e, NumInstances: 1}
+	b, err := yaml.Marshal(dc)
+	if assert.NoError(err) {
+		assert.Contains(string(b), "Env:\n  A10: v\n  A2: v\n")
+		var back DeployConfig
+		if assert.NoError(yaml.Unmarshal(b, &back)) {
+			assert.Equal(e, back.Env)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package sous
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type (
+	// A Freeze stops the rectifier changing the deployments it applies to,
+	// e.g. over holidays or during an incident, while they're still read and
+	// diffed as usual. With no Cluster or RepoURL it applies to every
+	// deployment.
+	Freeze struct {
+		// Cluster, if set, limits the freeze to deployments to the named
+		// cluster.
+		Cluster string `yaml:",omitempty"`
+		// RepoURL and RepoOffset, if RepoURL is set, limit the freeze to
+		// deployments of that source location.
+		RepoURL    RepoURL    `yaml:",omitempty"`
+		RepoOffset RepoOffset `yaml:",omitempty"`
+		// Start and End, if set, limit the freeze to that time: it applies
+		// from Start, and until End.
+		Start *time.Time `yaml:",omitempty"`
+		End   *time.Time `yaml:",omitempty"`
+		// Reason says why the freeze is in place.
+		Reason string `yaml:",omitempty"`
+		// baseURL is the BaseURL of Cluster, as set by Freezes.In.
+		baseURL ClusterName
+	}
+
+	// Freezes is a list of Freeze.
+	Freezes []Freeze
+)
+
+// Applies is true if f stops d being changed at time at.
+func (f Freeze) Applies(d *Deployment, at time.Time) bool {
+	if f.Start != nil && at.Before(*f.Start) {
+		return false
+	}
+	if f.End != nil && !at.Before(*f.End) {
+		return false
+	}
+	if f.Cluster != "" && ClusterName(f.Cluster) != d.Cluster && (f.baseURL == "" || f.baseURL != d.Cluster) {
+		return false
+	}
+	if f.RepoURL != "" && (f.RepoURL != d.SourceVersion.RepoURL || f.RepoOffset != d.SourceVersion.RepoOffset) {
+		return false
+	}
+	return true
+}
+
+// In returns a copy of fs that also applies to deployments identified by
+// the BaseURL of each freeze's Cluster in cs, as those built from a State
+// and read from Singularity are.
+func (fs Freezes) In(cs Clusters) Freezes {
+	in := make(Freezes, len(fs))
+	for i, f := range fs {
+		if cl, ok := cs[f.Cluster]; ok && cl.BaseURL != "" {
+			f.baseURL = ClusterName(cl.BaseURL)
+		}
+		in[i] = f
+	}
+	return in
+}
+
+// Frozen returns the first of fs that applies to d at time at, if any.
+func (fs Freezes) Frozen(d *Deployment, at time.Time) (*Freeze, bool) {
+	for i := range fs {
+		if fs[i].Applies(d, at) {
+			return &fs[i], true
+		}
+	}
+	return nil, false
+}
+
+func (f Freeze) String() string {
+	var b strings.Builder
+	b.WriteString("freeze")
+	switch {
+	case f.RepoURL != "" && f.Cluster != "":
+		fmt.Fprintf(&b, " of %s on %s", SourceLocation{RepoURL: f.RepoURL, RepoOffset: f.RepoOffset}, f.Cluster)
+	case f.RepoURL != "":
+		fmt.Fprintf(&b, " of %s", SourceLocation{RepoURL: f.RepoURL, RepoOffset: f.RepoOffset})
+	case f.Cluster != "":
+		fmt.Fprintf(&b, " on %s", f.Cluster)
+	}
+	if f.End != nil {
+		fmt.Fprintf(&b, " until %s", f.End.Format(time.RFC3339))
+	}
+	if f.Reason != "" {
+		fmt.Fprintf(&b, " (%s)", f.Reason)
+	}
+	return b.String()
+}
+
+// frozen is true if one of r.Freezes applies to d now, in which case a
+// SkippedFrozen event is emitted, saying what would have been done.
+func (r *rectifier) frozen(d *Deployment, reqID, would string) bool {
+	now := r.clock().Now()
+	f, ok := r.Freezes.Frozen(d, now)
+	if !ok {
+		return false
+	}
+	msg := fmt.Sprintf("%s: would have %s", f, would)
+	r.logFor(d, reqID).Infof("Skipped for %s", msg)
+	r.send(RectifyEvent{
+		Kind:       SkippedFrozen,
+		Deployment: d,
+		Cluster:    d.Cluster,
+		RequestID:  reqID,
+		Started:    now,
+		Occurred:   now,
+		Message:    msg,
+		Freeze:     f,
+	})
+	return true
+}
+
+// describeModify says what rectifying pair would do, for a SkippedFrozen or
+// ClusterCircuitOpen event.
+func describeModify(pair *DeploymentPair, scales, deploys bool) string {
+	var changes []string
+	if scales {
+		changes = append(changes, fmt.Sprintf("scaled from %d to %d instances",
+			pair.prior.NumInstances, pair.post.NumInstances))
+	}
+	if deploys {
+		changes = append(changes, fmt.Sprintf("deployed %s over %s",
+			pair.post.SourceVersion.Version, pair.prior.SourceVersion.Version))
+	}
+	return strings.Join(changes, " and ")
+}
@@ -0,0 +1,124 @@
+package sous
+
+import (
+	"testing"
+	"time"
+
+	"github.com/opentable/sous/util/yaml"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFreezeApplies(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	d := makeDepl("github.com/opentable/one", 1)
+	d.Cluster = "east"
+
+	cases := []struct {
+		Freeze
+		applies bool
+	}{
+		{Freeze{}, true},
+		{Freeze{Cluster: "east"}, true},
+		{Freeze{Cluster: "west"}, false},
+		{Freeze{RepoURL: "github.com/opentable/one"}, true},
+		{Freeze{RepoURL: "github.com/opentable/one", RepoOffset: "sub"}, false},
+		{Freeze{RepoURL: "github.com/opentable/two", Cluster: "east"}, false},
+		{Freeze{Start: &past, End: &future}, true},
+		{Freeze{Start: &future}, false},
+		{Freeze{End: &past}, false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.applies, c.Freeze.Applies(d, now), "%s", c.Freeze)
+	}
+
+	f, ok := Freezes{{Cluster: "west"}, {Reason: "incident"}}.Frozen(d, now)
+	if assert.True(t, ok) {
+		assert.Equal(t, "incident", f.Reason)
+	}
+}
+
+func TestDefsFreezesYAML(t *testing.T) {
+	in := []byte(`Freezes:
+- Cluster: east
+  Start: 2016-12-20T00:00:00Z
+  End: 2017-01-03T00:00:00Z
+  Reason: holidays
+`)
+	var defs Defs
+	if err := yaml.Unmarshal(in, &defs); err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, defs.Freezes, 1) {
+		f := defs.Freezes[0]
+		assert.Equal(t, "east", f.Cluster)
+		if assert.NotNil(t, f.End) {
+			assert.Equal(t, time.Date(2017, 1, 3, 0, 0, 0, 0, time.UTC), f.End.UTC())
+		}
+		assert.Equal(t, "freeze on east until 2017-01-03T00:00:00Z (holidays)", f.String())
+	}
+
+	out, err := yaml.Marshal(Defs{})
+	if assert.NoError(t, err) {
+		assert.NotContains(t, string(out), "Freezes")
+	}
+}
+
+func TestRectifySkipsFrozenDeployments(t *testing.T) {
+	assert := assert.New(t)
+
+	created := makeDepl("github.com/opentable/new", 2)
+	created.Cluster = "east"
+	deleted := makeDepl("github.com/opentable/gone", 1)
+	deleted.Cluster = "east"
+	prior := makeDepl("github.com/opentable/scaled", 1)
+	prior.Cluster = "east"
+	post := prior.Clone()
+	post.NumInstances = 3
+	unfrozen := makeDepl("github.com/opentable/new", 1)
+	unfrozen.Cluster = "west"
+
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	events := make(chan RectifyEvent, 20)
+	opts := RectifyOpts{
+		Events:  events,
+		Freezes: Freezes{{Cluster: "east", Reason: "incident"}},
+	}
+	chanset := NewDiffChans(4)
+	errs := RectifyWith(chanset, client, opts)
+	chanset.Created <- created
+	chanset.Created <- unfrozen
+	chanset.Deleted <- deleted
+	chanset.Modified <- &DeploymentPair{prior: prior, post: post}
+	chanset.Close()
+	for e := range errs {
+		t.Error(e)
+	}
+	close(events)
+
+	frozen := map[string]RectifyEvent{}
+	for ev := range events {
+		if ev.Kind == SkippedFrozen {
+			frozen[string(ev.Deployment.SourceVersion.RepoURL)] = ev
+		}
+	}
+	assert.Len(frozen, 3)
+	if ev, ok := frozen["github.com/opentable/scaled"]; assert.True(ok) {
+		assert.Contains(ev.Message, "freeze on east (incident)")
+		assert.Contains(ev.Message, "would have scaled from 1 to 3 instances")
+		if assert.NotNil(ev.Freeze) {
+			assert.Equal("incident", ev.Freeze.Reason)
+		}
+	}
+	if ev, ok := frozen["github.com/opentable/new"]; assert.True(ok) {
+		assert.Equal(ClusterName("east"), ev.Cluster)
+		assert.Contains(ev.Message, "would have created")
+	}
+
+	for _, c := range client.Calls() {
+		if cluster, ok := c.Args[0].(ClusterName); ok {
+			assert.NotEqual(ClusterName("east"), cluster, "%s was called for the frozen cluster", c.Method)
+		}
+	}
+	assert.Len(client.CallsTo("PostRequest"), 1, "the unfrozen create should still be made")
+}
@@ -2,14 +2,20 @@ package sous
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	// triggers the loading of sqlite3 as a database driver
 	"github.com/docker/distribution/reference"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/opentable/sous/util/docker_registry"
+	"github.com/opentable/sous/util/logging"
+	"github.com/opentable/sous/util/metrics"
 	"github.com/samsalisbury/semv"
+	"golang.org/x/net/context"
 )
 
 type (
@@ -18,6 +24,46 @@ type (
 	NameCache struct {
 		registryClient docker_registry.Client
 		db             *sql.DB
+		// instrumentation, if set, is told about each lookup
+		instrumentation NameCacheInstrumentation
+		log             Logger
+		// traceSQL is set by NameCacheTraceSQL
+		traceSQL bool
+		// etags is set by NameCacheIgnoreEtags
+		etags *etagPolicy
+		// offline is set by NameCacheOffline
+		offline bool
+		// metrics is set by NameCacheMetrics
+		metrics metrics.Collector
+		stats   nameCacheStats
+		// clock is SystemClock, unless set by NameCacheClock
+		clock Clock
+		// writes coordinates writes with other NameCaches sharing the
+		// database
+		writes *dbLock
+		// noRegistryPing is set by NameCacheNoRegistryPing
+		noRegistryPing bool
+		// closed is set by Close
+		closeMu sync.Mutex
+		closed  bool
+		// lookups and warms share the lookups of image names, and warming
+		// of source locations, made at once
+		lookups, warms flightGroup
+	}
+
+	// NameCacheOption configures a NameCache built with
+	// NewNameCacheWithOptions.
+	NameCacheOption func(*NameCache)
+
+	// NameCacheInstrumentation is told about the lookups a NameCache makes,
+	// as well as the registry requests they cause, so that the two can be
+	// compared.
+	NameCacheInstrumentation interface {
+		docker_registry.Instrumentation
+		// LookupCompleted is called after each lookup of the source version
+		// of an image name. fromCache is true if the cached source version
+		// was used because the registry said the image hadn't changed.
+		LookupCompleted(imageName string, duration time.Duration, fromCache bool, err error)
 	}
 
 	imageName string
@@ -32,30 +78,80 @@ type (
 		SourceVersion
 	}
 
+	// RegistryUnavailable is returned when a docker registry couldn't be
+	// queried while searching for an image name, so the image may exist even
+	// though it wasn't found
+	RegistryUnavailable struct {
+		Repo string
+		Err  error
+	}
+
 	// NoSourceVersionFound is returned when we cannot find a SourceVersion for a
 	// given image name
 	NoSourceVersionFound struct {
 		imageName
 	}
 
+	// noReposFound is returned when no docker repos are known to hold the
+	// images of a SourceLocation, so there's nothing to look in for them.
+	noReposFound struct {
+		SourceLocation
+	}
+
 	// ImageMapper interface describes the component responsible for mapping
 	// source versions to names
+	//
+	// Its methods keep to version ImageMapperContract of this error
+	// contract, which the imagemappertest package checks implementations
+	// against: an image or source version the mapper has no mapping for is
+	// reported with NoSourceVersionFound or NoImageNameFound, as values
+	// rather than pointers, and other errors mean the mapper couldn't find
+	// out, e.g. RegistryUnavailable. Whatever the error, the name or source
+	// version returned with it is empty. Once closed, a mapper's Ping
+	// returns ErrClosed.
 	ImageMapper interface {
 		// GetCanonicalName returns the canonical name for an image given any known
-		// name
+		// name, which GetSourceVersion maps to the same source version. A name
+		// that isn't mapped yet is looked up, and mapped, as GetSourceVersion
+		// would. It returns NoSourceVersionFound if the name isn't known.
 		GetCanonicalName(in string) (string, error)
 
 		// Insert puts a given SourceVersion/image name pair into the name cache
+		// It returns an error, and maps nothing, if in isn't a valid image
+		// name.
 		Insert(sv SourceVersion, in, etag string) error
 
 		// GetImageName returns the docker image name for a given source version
+		// or NoImageNameFound if there is none.
 		GetImageName(sv SourceVersion) (string, error)
 
 		// GetSourceVersion returns the source version for a given image name
+		// or NoSourceVersionFound if there is none.
 		GetSourceVersion(in string) (SourceVersion, error)
+
+		// GetLabels returns the sous labels of the image named in, as
+		// SourceVersion.DockerLabels computes them, or NoSourceVersionFound
+		// if it isn't a sous image. An image that's already mapped is
+		// answered without asking the registry; only on a miss is it
+		// looked up, and mapped, as GetSourceVersion would.
+		GetLabels(in string) (map[string]string, error)
+
+		// Ping checks that the mapper can still answer lookups, e.g. that
+		// its database and registry can be reached, for health checks. It
+		// returns ErrClosed once the mapper has been closed.
+		Ping(ctx context.Context) error
+
+		// Close releases what the mapper holds, e.g. its database
+		// connections. Closing it again does nothing and returns nil.
+		Close() error
 	}
 )
 
+// ImageMapperContract is the version of the error contract documented on
+// ImageMapper. It's incremented whenever the contract changes, so that
+// implementations outside this package can tell they need revisiting.
+const ImageMapperContract = 3
+
 // InMemory configures SQLite to use an in-memory database
 // The dummy file allows multiple goroutines see the same in-memory DB
 const InMemory = "file:dummy.db?mode=memory&cache=shared"
@@ -70,6 +166,10 @@ func (e NoImageNameFound) Error() string {
 	return fmt.Sprintf("No image name for %v", e.SourceVersion)
 }
 
+func (e RegistryUnavailable) Error() string {
+	return fmt.Sprintf("Registry unavailable for %s: %v", e.Repo, e.Err)
+}
+
 func (e NoSourceVersionFound) Error() string {
 	return fmt.Sprintf("No source version for %v", e.imageName)
 }
@@ -78,115 +178,320 @@ func (e NotModifiedErr) Error() string {
 	return "Not modified"
 }
 
+func (e noReposFound) Error() string {
+	return fmt.Sprintf("no repos found for %+v", e.SourceLocation)
+}
+
 // NewNameCache builds a new name cache
 func NewNameCache(cl docker_registry.Client, dbCfg ...string) *NameCache {
-	db, err := getDatabase(dbCfg...)
+	return NewNameCacheWithOptions(cl, dbCfg)
+}
+
+// NewNameCacheWithOptions builds a NameCache like NewNameCache, configured by
+// opts.
+func NewNameCacheWithOptions(cl docker_registry.Client, dbCfg []string, opts ...NameCacheOption) *NameCache {
+	nc := &NameCache{registryClient: cl, log: DefaultLogger, clock: SystemClock, writes: newDBLock(dbCfg)}
+	for _, opt := range opts {
+		opt(nc)
+	}
+	// The schema is brought up to date without other writers, which might
+	// be doing the same.
+	err := nc.writes.with(true, func() error {
+		var err error
+		nc.db, err = getDatabase(nc.clock, dbCfg...)
+		return err
+	})
 	if err != nil {
 		log.Fatal("Error building name cache DB: ", err)
 	}
+	nc.log = logging.ForSubsystem(nc.log, logging.NameCache)
+	return nc
+}
 
-	return &NameCache{cl, db}
+// NameCacheLogger has the NameCache log to l, rather than DefaultLogger,
+// with the image name or source version concerned as context. Either way,
+// it logs at the levels set for logging.NameCache.
+func NameCacheLogger(l Logger) NameCacheOption {
+	return func(nc *NameCache) {
+		nc.log = l
+	}
+}
+
+// NameCacheClock has the NameCache use c, rather than SystemClock, for the
+// times images are cached at, and to tell when they were cached too long
+// ago.
+func NameCacheClock(c Clock) NameCacheOption {
+	return func(nc *NameCache) {
+		nc.clock = c
+	}
+}
+
+// NewInstrumentedNameCache builds a NameCache like NewNameCache, which tells
+// i about its lookups, and has cl tell i about the registry requests they
+// make.
+func NewInstrumentedNameCache(cl docker_registry.Client, i NameCacheInstrumentation, dbCfg ...string) *NameCache {
+	nc := NewNameCache(cl, dbCfg...)
+	cl.SetInstrumentation(i)
+	nc.instrumentation = i
+	return nc
 }
 
 // GetSourceVersion looks up the source version for a given image name
 func (nc *NameCache) GetSourceVersion(in string) (SourceVersion, error) {
-	var sv SourceVersion
+	start := nc.clock.Now()
+	sv, fromCache, err := nc.getSourceVersion(in)
+	if nc.instrumentation != nil {
+		nc.instrumentation.LookupCompleted(in, nc.clock.Now().Sub(start), fromCache, err)
+	}
+	switch _, missing := err.(NoSourceVersionFound); {
+	case err == nil && fromCache:
+		nc.recordLookup("source version", lookupHit)
+	case err == nil, missing:
+		nc.recordLookup("source version", lookupMiss)
+	default:
+		nc.recordLookup("source version", lookupError)
+	}
+	if err != nil {
+		// whatever was cached mustn't be mistaken for the answer
+		return SourceVersion{}, err
+	}
+	return sv, nil
+}
+
+// getSourceVersion looks up the source version of in, and whether it was
+// cached and unchanged. Concurrent lookups of the same image share one
+// lookup, and so one registry fetch and one insert.
+func (nc *NameCache) getSourceVersion(in string) (SourceVersion, bool, error) {
+	r, err := nc.lookups.do(imageKey(in), func() (interface{}, error) {
+		sv, fromCache, err := nc.lookupSourceVersion(in)
+		return sharedLookup{sv, fromCache}, err
+	})
+	sl, ok := r.(sharedLookup)
+	if !ok && err == nil {
+		err = fmt.Errorf("lookup of %s returned %T", in, r)
+	}
+	return sl.sv, sl.fromCache, err
+}
+
+// sharedLookup is the outcome of a lookup shared between the callers of
+// getSourceVersion.
+type sharedLookup struct {
+	sv        SourceVersion
+	fromCache bool
+}
+
+// cachedImage is what the cache holds for an image name.
+type cachedImage struct {
+	sv       SourceVersion
+	etag, cn string
+	// ok is false if the image isn't cached.
+	ok bool
+}
 
-	Log.Debug.Print(in)
+func (nc *NameCache) lookupSourceVersion(in string) (SourceVersion, bool, error) {
+	log := nc.log.With("image", in)
+	log.Debugf("Looking up source version")
+	q := nc.sqlTrace("GetSourceVersion", log)
+	defer q.summarize("Looked up source version")
+
+	c, err := nc.readCached(q, log, in)
+	if err != nil {
+		return c.sv, false, err
+	}
+	if nc.offline {
+		if !c.ok {
+			return SourceVersion{}, false, NoSourceVersionFound{imageName(in)}
+		}
+		return c.sv, true, nil
+	}
+	return nc.refresh(q, log, in, c)
+}
 
-	etag, repo, offset, version, _, err := nc.dbQueryOnName(in)
+// readCached reads what's cached for in, without asking the registry.
+func (nc *NameCache) readCached(q *sqlTrace, log Logger, in string) (cachedImage, error) {
+	etag, repo, offset, version, revision, cn, err := nc.dbQueryOnName(q, in)
 	if nif, ok := err.(NoSourceVersionFound); ok {
-		Log.Debug.Print(nif)
-	} else if err != nil {
-		Log.Debug.Print("Err: ", err)
-		return SourceVersion{}, err
-	} else {
-		Log.Debug.Printf("Found: %v %v %v", repo, offset, version)
+		log.Debugf("Not cached: %s", nif)
+		return cachedImage{}, nil
+	}
+	if err != nil {
+		log.Debugf("Unable to query cache: %s", err)
+		return cachedImage{}, err
+	}
+	log.Debugf("Cached: %v %v %v", repo, offset, version)
+	sv, err := makeSourceVersion(repo, offset, version, revision)
+	return cachedImage{sv: sv, etag: etag, cn: cn, ok: true}, err
+}
 
-		sv, err = makeSourceVersion(repo, offset, version)
-		if err != nil {
-			return sv, err
+// refresh asks the registry whether in has changed since c was cached,
+// unless its etag is ignored and c is fresh enough, and caches it afresh if
+// it has. It returns the source version of in, and whether c was used.
+func (nc *NameCache) refresh(q *sqlTrace, log Logger, in string, c cachedImage) (SourceVersion, bool, error) {
+	sv, etag := c.sv, c.etag
+	ignoreEtag := nc.ignoresEtag(in)
+	if ignoreEtag {
+		if c.ok {
+			fresh, err := nc.dbCachedSince(q, in, nc.clock.Now().Add(-nc.etags.maxAge))
+			if err != nil {
+				return sv, false, err
+			}
+			if fresh {
+				log.Debugf("Ignoring etag: cached within %s", nc.etags.maxAge)
+				return sv, true, nil
+			}
 		}
+		etag = ""
 	}
 
+	done := nc.timeRegistry("GetImageMetadata")
 	md, err := nc.registryClient.GetImageMetadata(in, etag)
-	Log.Debug.Printf("%+ v %v", md, err)
-	if _, ok := err.(NotModifiedErr); ok {
-		return sv, nil
+	done()
+	log.Debugf("Registry metadata: %+ v %v", md, err)
+	if docker_registry.IsNotFound(err) {
+		return SourceVersion{}, false, NoSourceVersionFound{imageName(in)}
+	}
+	if isNotModified(err) {
+		err := nc.withWrite(func() error { return nc.dbRecordFetch(q, c.cn, FetchNotModified, "") })
+		if err != nil {
+			log.Warnf("Unable to record refresh: %s", err)
+		}
+		return sv, true, nil
 	}
 	if err != nil {
-		return sv, err
+		return sv, false, RegistryUnavailable{Repo: imageRepo(in), Err: err}
 	}
 
 	newSV, err := SourceVersionFromLabels(md.Labels)
 	if err != nil {
-		return sv, err
+		// not built by sous
+		log.Debugf("Unusable labels: %s", err)
+		return SourceVersion{}, false, NoSourceVersionFound{imageName(in)}
 	}
 
-	err = nc.dbInsert(newSV, md.CanonicalName, md.Etag)
+	// the etag can't be trusted to say whether the image changed, so only
+	// re-cache it if it did
+	if ignoreEtag && c.ok && newSV.Equal(sv) && md.CanonicalName == c.cn {
+		return newSV, false, nc.withWrite(func() error { return nc.dbRecordFetch(q, c.cn, FetchFull, md.Etag) })
+	}
+
+	err = nc.withWrite(func() error { return nc.dbInsert(q, newSV, md.CanonicalName, md.Etag, FetchFull) })
 	if err != nil {
-		return sv, err
+		return sv, false, err
 	}
 
-	Log.Debug.Printf("cn: %v all: %v", md.CanonicalName, md.AllNames)
-	err = nc.dbAddNames(md.CanonicalName, md.AllNames)
+	log.Debugf("cn: %v all: %v", md.CanonicalName, md.AllNames)
+	err = nc.dbAddNames(q, md.CanonicalName, md.AllNames)
 
-	return newSV, err
+	return newSV, false, err
 }
 
-func (nc *NameCache) harvest(sl SourceLocation) error {
-	repos, err := nc.dbQueryOnSL(sl)
+// GetLabels returns the sous labels of the image named in, reconstructed
+// from the source version cached for it, so that images already in the cache
+// cost no registry calls. Only if in isn't cached is it looked up, as
+// GetSourceVersion does. The revision label of an image cached before the
+// cache kept revisions is empty.
+func (nc *NameCache) GetLabels(in string) (map[string]string, error) {
+	sv, err := nc.GetSourceVersionCached(in)
+	if _, ok := err.(NoSourceVersionFound); ok {
+		sv, err = nc.GetSourceVersion(in)
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
-	for _, r := range repos {
-		ref, err := reference.ParseNamed(r)
-		if err != nil {
-			return fmt.Errorf("%v for %v", err, r)
-		}
-		ts, err := nc.registryClient.AllTags(r)
-		if err == nil {
-			for _, t := range ts {
-				in, err := reference.WithTag(ref, t)
-				if err == nil {
-					nc.GetSourceVersion(in.String()) //pull it into the cache...
-				}
-			}
-		}
+	return sv.DockerLabels(), nil
+}
+
+// isNotModified is true if err says that an image's metadata are unchanged
+// since we cached them.
+func isNotModified(err error) bool {
+	_, ok := err.(NotModifiedErr)
+	return ok || err == docker_registry.ErrNotModified
+}
+
+func isRateLimited(err error) bool {
+	if ru, ok := err.(RegistryUnavailable); ok {
+		err = ru.Err
 	}
-	return nil
+	_, ok := err.(docker_registry.RateLimited)
+	return ok
 }
 
 // GetImageName returns the docker image name for a given source version
 func (nc *NameCache) GetImageName(sv SourceVersion) (string, error) {
-	Log.Debug.Printf("Getting image name for %+v", sv)
-	cn, _, err := nc.dbQueryOnSV(sv)
-	if _, ok := err.(NoImageNameFound); ok {
-		err = nc.harvest(sv.CanonicalName())
-		if err != nil {
-			return "", err
+	cn, _, err := nc.GetImageNames(sv)
+	return cn, err
+}
+
+// GetImageNames returns the canonical docker image name for a given source
+// version, and all of the names known for that image.
+func (nc *NameCache) GetImageNames(sv SourceVersion) (string, []string, error) {
+	log := nc.log.With("source", sv)
+	log.Debugf("Getting image name")
+	q := nc.sqlTrace("GetImageNames", log)
+	defer q.summarize("Got image name")
+
+	cn, ins, err := nc.dbQueryOnSV(q, sv)
+	_, missing := err.(NoImageNameFound)
+	switch {
+	case err == nil:
+		nc.recordLookup("image name", lookupHit)
+	case missing:
+		nc.recordLookup("image name", lookupMiss)
+	default:
+		nc.recordLookup("image name", lookupError)
+	}
+	if missing && !nc.offline {
+		_, herr := nc.Warm(sv.CanonicalName())
+		if _, ok := herr.(noReposFound); ok {
+			return "", nil, err
+		}
+		if _, ok := herr.(RegistryUnavailable); herr != nil && !ok {
+			return "", nil, herr
 		}
 
-		cn, _, err = nc.dbQueryOnSV(sv)
+		cn, ins, err = nc.dbQueryOnSV(q, sv)
 		if err != nil {
-			return "", err
+			if herr != nil {
+				return "", nil, herr
+			}
+			return "", nil, err
 		}
 	} else if err != nil {
-		return "", err
+		return "", nil, err
 	}
-	return cn, nil
+	return cn, ins, nil
 }
 
-// GetCanonicalName returns the canonical name for an image given any known name
+// GetCanonicalName returns the canonical name for an image given any known
+// name. An image that isn't cached is looked up in its registry and cached,
+// as GetSourceVersion does, so a RegistryUnavailable is returned if the
+// registry can't be queried, and NoSourceVersionFound only if the image isn't
+// there, or the cache is offline.
 func (nc *NameCache) GetCanonicalName(in string) (string, error) {
-	_, _, _, _, cn, err := nc.dbQueryOnName(in)
-	Log.Debug.Print(cn)
-	return cn, err
+	cn, err := nc.GetCanonicalNameCached(in)
+	if _, missing := err.(NoSourceVersionFound); !missing {
+		return cn, err
+	}
+	if _, err := nc.GetSourceVersion(in); err != nil {
+		return "", err
+	}
+	return nc.GetCanonicalNameCached(in)
+}
+
+// imageRepo returns the name of the repository of the image in, or in itself
+// if it isn't a valid image name.
+func imageRepo(in string) string {
+	ref, err := reference.ParseNamed(in)
+	if err != nil {
+		return in
+	}
+	return ref.Name()
 }
 
 // Insert puts a given SourceVersion/image name pair into the name cache
 func (nc *NameCache) Insert(sv SourceVersion, in, etag string) error {
-	return nc.dbInsert(sv, in, etag)
+	q := nc.sqlTrace("Insert", nc.log.With("image", in))
+	return nc.withWrite(func() error { return nc.dbInsert(q, sv, in, etag, FetchNone) })
 }
 
 func union(left, right []string) []string {
@@ -208,7 +513,7 @@ func union(left, right []string) []string {
 	return res
 }
 
-func getDatabase(cfg ...string) (*sql.DB, error) {
+func getDatabase(clock Clock, cfg ...string) (*sql.DB, error) {
 	driver := "sqlite3"
 	conn := InMemory
 	if len(cfg) >= 1 {
@@ -219,11 +524,24 @@ func getDatabase(cfg ...string) (*sql.DB, error) {
 		conn = cfg[1]
 	}
 
+	file := driver == "sqlite3" && DatabaseFile(conn) != ""
+	if file {
+		conn = fileConnection(conn)
+	}
+
 	db, err := sql.Open(driver, conn) //only call once
 	if err != nil {
 		return nil, err
 	}
 
+	// Write-ahead logging lets processes sharing the file read while
+	// another writes.
+	if file {
+		if err := sqlExec(db, "pragma journal_mode = WAL;"); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := sqlExec(db, "pragma foreign_keys = ON;"); err != nil {
 		return nil, err
 	}
@@ -262,11 +580,27 @@ func getDatabase(cfg ...string) (*sql.DB, error) {
 		"etag text not null, "+
 		"canonicalName text not null, "+
 		"version text not null, "+
+		"cached_at integer not null default 0, "+
+		"refresh_count integer not null default 0, "+
+		"last_fetch text not null default '', "+
+		"revision text not null default '', "+
 		"constraint upsertable unique (location_id, version) on conflict replace"+
 		");"); err != nil {
 		return nil, err
 	}
 
+	if err := addCachedAt(db, clock.Now()); err != nil {
+		return nil, err
+	}
+
+	if err := addRefreshColumns(db); err != nil {
+		return nil, err
+	}
+
+	if err := addRevisionColumn(db); err != nil {
+		return nil, err
+	}
+
 	if err := sqlExec(db, "create table if not exists docker_search_name("+
 		"name_id integer primary key autoincrement, "+
 		"metadata_id references docker_search_metadata "+
@@ -286,43 +620,57 @@ func sqlExec(db *sql.DB, sql string) error {
 	return nil
 }
 
-func (nc *NameCache) dbInsert(sv SourceVersion, in, etag string) error {
+// dbInsert caches the image in, built from sv, which was fetched as fetch.
+// If the image was already cached for sv, it's replaced, and counts as
+// having been refreshed.
+func (nc *NameCache) dbInsert(q *sqlTrace, sv SourceVersion, in, etag string, fetch NameCacheFetch) error {
 	ref, err := reference.ParseNamed(in)
 	if err != nil {
 		return fmt.Errorf("%v for %v", err, in)
 	}
 
-	Log.Debug.Print(ref.Name())
-	nr, err := nc.db.Exec("insert into docker_repo_name "+
+	log := nc.log.With("image", in)
+	log.Debugf("Inserting repo %s", ref.Name())
+	// "or ignore" overrides the tables' "on conflict replace", which would
+	// delete the existing rows, and by cascading, every image cached for them.
+	var nid, id int64
+	_, err = q.exec("insert or ignore into docker_repo_name "+
 		"(name) values ($1);", ref.Name())
-	nid, err := nr.LastInsertId()
+	if err != nil {
+		return err
+	}
+	err = q.queryRowScan([]interface{}{&nid}, "select repo_name_id from docker_repo_name "+
+		"where name = $1", ref.Name())
 	if err != nil {
 		return err
 	}
 
-	res, err := nc.db.Exec("insert into docker_search_location "+
+	_, err = q.exec("insert or ignore into docker_search_location "+
 		"(repo, offset) values ($1, $2);",
 		string(sv.RepoURL), string(sv.RepoOffset))
-
 	if err != nil {
 		return err
 	}
-
-	id, err := res.LastInsertId()
+	err = q.queryRowScan([]interface{}{&id}, "select location_id from docker_search_location "+
+		"where repo = $1 and offset = $2",
+		string(sv.RepoURL), string(sv.RepoOffset))
 	if err != nil {
 		return err
 	}
 
-	_, err = nc.db.Exec("insert into repo_through_location "+
+	_, err = q.exec("insert into repo_through_location "+
 		"(repo_name_id, location_id) values ($1, $2)", nid, id)
 	if err != nil {
 		return err
 	}
 
-	Log.Debug.Printf("%v %v %v %v", id, etag, in, sv.Version)
-	res, err = nc.db.Exec("insert into docker_search_metadata "+
-		"(location_id, etag, canonicalName, version) values ($1, $2, $3, $4);",
-		id, etag, in, sv.Version.Format(semv.MMPPre))
+	log.Debugf("Inserting metadata: %v %v %v", id, etag, sv.Version)
+	res, err := q.exec("insert into docker_search_metadata "+
+		"(location_id, etag, canonicalName, version, cached_at, refresh_count, last_fetch, revision) "+
+		"values ($1, $2, $3, $4, $5, coalesce(("+
+		"select refresh_count + 1 from docker_search_metadata "+
+		"where location_id = $1 and version = $4), 0), $6, $7);",
+		id, etag, in, sv.Version.Format(semv.MMPPre), nc.clock.Now().Unix(), string(fetch), sv.RevID())
 
 	if err != nil {
 		return err
@@ -333,28 +681,29 @@ func (nc *NameCache) dbInsert(sv SourceVersion, in, etag string) error {
 		return err
 	}
 
-	res, err = nc.db.Exec("insert into docker_search_name "+
+	res, err = q.exec("insert into docker_search_name "+
 		"(metadata_id, name) values ($1, $2)", id, in)
 
 	return err
 }
 
-func (nc *NameCache) dbAddNames(cn string, ins []string) error {
+func (nc *NameCache) dbAddNames(q *sqlTrace, cn string, ins []string) error {
 	var id int
-	row := nc.db.QueryRow("select metadata_id from docker_search_metadata "+
-		"where canonicalName = $1", cn)
-	err := row.Scan(&id)
+	// the same image may be cached for several versions; the newest is the
+	// one just inserted
+	err := q.queryRowScan([]interface{}{&id}, "select metadata_id from docker_search_metadata "+
+		"where canonicalName = $1 order by metadata_id desc", cn)
 	if err != nil {
 		return err
 	}
-	add, err := nc.db.Prepare("insert into docker_search_name " +
+	add, err := q.prepare("insert into docker_search_name " +
 		"(metadata_id, name) values ($1, $2)")
 	if err != nil {
 		return err
 	}
 
 	for _, n := range ins {
-		_, err := add.Exec(id, n)
+		_, err := add.exec(id, n)
 		if err != nil {
 			return err
 		}
@@ -363,26 +712,26 @@ func (nc *NameCache) dbAddNames(cn string, ins []string) error {
 	return nil
 }
 
-func (nc *NameCache) dbQueryOnName(in string) (etag, repo, offset, version, cname string, err error) {
-	row := nc.db.QueryRow("select "+
+func (nc *NameCache) dbQueryOnName(q *sqlTrace, in string) (etag, repo, offset, version, revision, cname string, err error) {
+	err = q.queryRowScan([]interface{}{&etag, &repo, &offset, &version, &revision, &cname}, "select "+
 		"docker_search_metadata.etag, "+
 		"docker_search_location.repo, "+
 		"docker_search_location.offset, "+
 		"docker_search_metadata.version, "+
+		"docker_search_metadata.revision, "+
 		"docker_search_metadata.canonicalName "+
 		"from "+
 		"docker_search_name natural join docker_search_metadata "+
 		"natural join docker_search_location "+
 		"where docker_search_name.name = $1", in)
-	err = row.Scan(&etag, &repo, &offset, &version, &cname)
-	if err == sql.ErrNoRows {
+	if errors.Is(err, sql.ErrNoRows) {
 		err = NoSourceVersionFound{imageName(in)}
 	}
 	return
 }
 
-func (nc *NameCache) dbQueryOnSL(sl SourceLocation) (rs []string, err error) {
-	rows, err := nc.db.Query("select docker_repo_name.name "+
+func (nc *NameCache) dbQueryOnSL(q *sqlTrace, sl SourceLocation) (rs []string, err error) {
+	rows, err := q.query("select docker_repo_name.name "+
 		"from "+
 		"docker_repo_name natural join repo_through_location "+
 		"  natural join docker_search_location "+
@@ -391,7 +740,7 @@ func (nc *NameCache) dbQueryOnSL(sl SourceLocation) (rs []string, err error) {
 		"docker_search_location.offset = $2",
 		string(sl.RepoURL), string(sl.RepoOffset))
 
-	if err == sql.ErrNoRows {
+	if errors.Is(err, sql.ErrNoRows) {
 		return []string{}, err
 	}
 	if err != nil {
@@ -405,14 +754,14 @@ func (nc *NameCache) dbQueryOnSL(sl SourceLocation) (rs []string, err error) {
 	}
 	err = rows.Err()
 	if len(rs) == 0 {
-		err = fmt.Errorf("no repos found for %+v", sl)
+		err = noReposFound{sl}
 	}
 	return
 }
 
-func (nc *NameCache) dbQueryOnSV(sv SourceVersion) (cn string, ins []string, err error) {
+func (nc *NameCache) dbQueryOnSV(q *sqlTrace, sv SourceVersion) (cn string, ins []string, err error) {
 	ins = make([]string, 0)
-	rows, err := nc.db.Query("select docker_search_metadata.canonicalName, "+
+	rows, err := q.query("select docker_search_metadata.canonicalName, "+
 		"docker_search_name.name "+
 		"from "+
 		"docker_search_name natural join docker_search_metadata "+
@@ -423,7 +772,7 @@ func (nc *NameCache) dbQueryOnSV(sv SourceVersion) (cn string, ins []string, err
 		"docker_search_metadata.version = $3",
 		string(sv.RepoURL), string(sv.RepoOffset), sv.Version.String())
 
-	if err == sql.ErrNoRows {
+	if errors.Is(err, sql.ErrNoRows) {
 		err = NoImageNameFound{sv}
 		return
 	}
@@ -444,11 +793,12 @@ func (nc *NameCache) dbQueryOnSV(sv SourceVersion) (cn string, ins []string, err
 	return
 }
 
-func makeSourceVersion(repo, offset, version string) (SourceVersion, error) {
+func makeSourceVersion(repo, offset, version, revision string) (SourceVersion, error) {
 	v, err := semv.Parse(version)
 	if err != nil {
 		return SourceVersion{}, err
 	}
+	v.Meta = revision
 
 	return SourceVersion{
 		RepoURL(repo), v, RepoOffset(offset),
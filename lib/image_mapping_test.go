@@ -1,10 +1,12 @@
 package sous
 
 import (
+	"fmt"
 	"log"
 	"testing"
+	"time"
 
-	"github.com/opentable/sous/util/docker_registry"
+	"github.com/opentable/sous/util/docker_registry/registrytest"
 	"github.com/samsalisbury/semv"
 	"github.com/stretchr/testify/assert"
 )
@@ -12,7 +14,7 @@ import (
 func TestRoundTrip(t *testing.T) {
 	assert := assert.New(t)
 
-	dc := docker_registry.NewDummyClient()
+	dc := registrytest.NewFake()
 	nc := NewNameCache(dc, "sqlite3", InMemoryConnection("roundtrip"))
 
 	v := semv.MustParse("1.2.3")
@@ -43,13 +45,11 @@ func TestRoundTrip(t *testing.T) {
 		RepoOffset: RepoOffset("nested/there"),
 	}
 
-	cn = base + "@" + digest
-	dc.FeedMetadata(docker_registry.Metadata{
-		Labels:        newSV.DockerLabels(),
-		Etag:          digest,
-		CanonicalName: cn,
-		AllNames:      []string{cn, in},
-	})
+	newDigest, err := dc.Add(in, newSV.DockerLabels())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cn = base + "@" + newDigest
 	sv, err = nc.GetSourceVersion(in)
 	if assert.Nil(err) {
 		assert.Equal(newSV, sv)
@@ -64,8 +64,8 @@ func TestRoundTrip(t *testing.T) {
 func TestHarvesting(t *testing.T) {
 	assert := assert.New(t)
 
-	dc := docker_registry.NewDummyClient()
-	nc := NewNameCache(dc, "sqlite3", InMemoryConnection("roundtrip"))
+	dc := registrytest.NewFake()
+	nc := NewNameCache(dc, "sqlite3", InMemoryConnection("harvesting"))
 
 	v := semv.MustParse("1.2.3")
 	sv := SourceVersion{
@@ -82,33 +82,19 @@ func TestHarvesting(t *testing.T) {
 	}
 
 	base := "docker.repo.io/ot/wackadoo"
-	tag := "version-1.2.3"
-	digest := "sha256:012345678901234567890123456789AB012345678901234567890123456789AB"
-	cn := base + "@" + digest
-	in := base + ":" + tag
-
-	dc.FeedMetadata(docker_registry.Metadata{
-		Labels:        sv.DockerLabels(),
-		Etag:          digest,
-		CanonicalName: cn,
-		AllNames:      []string{cn, in},
-	})
+	if _, err := dc.Add(base+":version-1.2.3", sv.DockerLabels()); err != nil {
+		t.Fatal(err)
+	}
 
 	// a la a SetCollector getting the SV
-	_, err := nc.GetSourceVersion(in)
+	_, err := nc.GetSourceVersion(base + ":version-1.2.3")
 	assert.Nil(err)
 
-	tag = "version-2.3.4"
-	dc.FeedTags([]string{tag})
-	cn = base + "@" + digest
-	in = base + ":" + tag
-	digest = "sha256:abcdefabcdeabcdeabcdeabcdeabcdeabcdeabcdeabcdeabcdeabcdefffffffff"
-	dc.FeedMetadata(docker_registry.Metadata{
-		Labels:        sisterSV.DockerLabels(),
-		Etag:          digest,
-		CanonicalName: cn,
-		AllNames:      []string{cn, in},
-	})
+	digest, err := dc.Add(base+":version-2.3.4", sisterSV.DockerLabels())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cn := base + "@" + digest
 
 	nin, err := nc.GetImageName(sisterSV)
 	if assert.NoError(err) {
@@ -119,7 +105,7 @@ func TestHarvesting(t *testing.T) {
 func TestMissingName(t *testing.T) {
 	assert := assert.New(t)
 	log.SetFlags(log.Flags() | log.Lshortfile)
-	dc := docker_registry.NewDummyClient()
+	dc := registrytest.NewFake()
 	nc := NewNameCache(dc, "sqlite3", InMemory)
 
 	v := semv.MustParse("4.5.6")
@@ -134,6 +120,116 @@ func TestMissingName(t *testing.T) {
 	assert.Error(err)
 }
 
+func TestGetCanonicalNameReadsThrough(t *testing.T) {
+	assert := assert.New(t)
+
+	dc := registrytest.NewFake()
+	nc := NewNameCache(dc, "sqlite3", InMemoryConnection("canonicalmiss"))
+	sv := SourceVersion{
+		Version: semv.MustParse("1.2.3"),
+		RepoURL: RepoURL("github.com/opentable/wackadoo"),
+	}
+	in := "docker.repo.io/ot/wackadoo:1.2.3"
+	digest, err := dc.Add(in, sv.DockerLabels())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cn := "docker.repo.io/ot/wackadoo@" + digest
+
+	_, err = nc.GetCanonicalNameCached(in)
+	assert.IsType(NoSourceVersionFound{}, err)
+	assert.Zero(dc.Calls(registrytest.GetImageMetadata), "the cached lookup shouldn't query the registry")
+
+	for i := 0; i < 2; i++ {
+		got, err := nc.GetCanonicalName(in)
+		if assert.NoError(err) {
+			assert.Equal(cn, got)
+		}
+	}
+	assert.Equal(1, dc.Calls(registrytest.GetImageMetadata), "only the miss should query the registry")
+	got, err := nc.GetCanonicalNameCached(in)
+	if assert.NoError(err) {
+		assert.Equal(cn, got)
+	}
+	if got, err := nc.GetSourceVersion(in); assert.NoError(err) {
+		assert.True(got.Equal(sv))
+	}
+
+	got, err = nc.GetCanonicalName("docker.repo.io/ot/unknown:1.0.0")
+	assert.IsType(NoSourceVersionFound{}, err)
+	assert.Equal("", got)
+}
+
+func TestGetCanonicalNameRegistryUnavailable(t *testing.T) {
+	assert := assert.New(t)
+
+	dc := registrytest.NewFake()
+	nc := NewNameCache(dc, "sqlite3", InMemoryConnection("canonicalunavailable"))
+	in := "docker.repo.io/ot/wackadoo:1.2.3"
+	boom := fmt.Errorf("connection refused")
+	dc.FailNext(registrytest.GetImageMetadata, boom)
+
+	cn, err := nc.GetCanonicalName(in)
+	assert.Equal("", cn)
+	if assert.IsType(RegistryUnavailable{}, err) {
+		assert.Equal("docker.repo.io/ot/wackadoo", err.(RegistryUnavailable).Repo)
+		assert.Equal(boom, err.(RegistryUnavailable).Err)
+	}
+}
+
+func TestGetLabelsOfForeignImage(t *testing.T) {
+	assert := assert.New(t)
+
+	dc := registrytest.NewFake()
+	nc := NewNameCache(dc, "sqlite3", InMemoryConnection("foreignlabels"))
+	in := "docker.repo.io/ot/hand-made:1.0.0"
+	if _, err := dc.Add(in, map[string]string{"maintainer": "someone"}); err != nil {
+		t.Fatal(err)
+	}
+
+	labels, err := nc.GetLabels(in)
+	assert.IsType(NoSourceVersionFound{}, err)
+	assert.Empty(labels)
+}
+
+func TestNameCacheNotModified(t *testing.T) {
+	assert := assert.New(t)
+
+	dc := registrytest.NewFake()
+	nc := NewNameCache(dc, "sqlite3", InMemoryConnection("notmodified"))
+
+	sv := SourceVersion{
+		Version: semv.MustParse("1.2.3"),
+		RepoURL: RepoURL("github.com/opentable/wackadoo"),
+	}
+	in := "docker.repo.io/ot/wackadoo:1.2.3"
+	if _, err := dc.Add(in, sv.DockerLabels()); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := nc.GetSourceVersion(in)
+	if assert.NoError(err) {
+		assert.True(got.Equal(sv))
+	}
+	// The second lookup sends the etag, and the registry says nothing changed.
+	got, err = nc.GetSourceVersion(in)
+	if assert.NoError(err) {
+		assert.True(got.Equal(sv))
+	}
+	assert.Equal(2, dc.Calls(registrytest.GetImageMetadata))
+
+	// A new image pushed under the same tag replaces the cached one.
+	newSV := sv
+	newSV.Version = semv.MustParse("1.2.4")
+	if _, err := dc.Add(in, newSV.DockerLabels()); err != nil {
+		t.Fatal(err)
+	}
+	got, err = nc.GetSourceVersion(in)
+	if assert.NoError(err) {
+		assert.True(got.Equal(newSV))
+	}
+}
+
 func TestUnion(t *testing.T) {
 	assert := assert.New(t)
 
@@ -147,3 +243,74 @@ func TestUnion(t *testing.T) {
 	assert.Contains(all, "c")
 	assert.Contains(all, "d")
 }
+
+type lookupRecorder struct {
+	lookups   []string
+	fromCache []bool
+}
+
+func (lr *lookupRecorder) RequestStarted(method, host, path string) {}
+
+func (lr *lookupRecorder) RequestCompleted(method, host, path string, status int, d time.Duration, fromCache bool) {
+}
+
+func (lr *lookupRecorder) LookupCompleted(in string, d time.Duration, fromCache bool, err error) {
+	lr.lookups = append(lr.lookups, in)
+	lr.fromCache = append(lr.fromCache, fromCache)
+}
+
+func TestInstrumentedNameCache(t *testing.T) {
+	assert := assert.New(t)
+
+	dc := registrytest.NewFake()
+	lr := &lookupRecorder{}
+	nc := NewInstrumentedNameCache(dc, lr, "sqlite3", InMemoryConnection("instrumented"))
+
+	sv := SourceVersion{
+		Version: semv.MustParse("1.2.3"),
+		RepoURL: RepoURL("github.com/opentable/wackadoo"),
+	}
+	in := "docker.repo.io/ot/wackadoo:1.2.3"
+	if _, err := dc.Add(in, sv.DockerLabels()); err != nil {
+		t.Fatal(err)
+	}
+	nc.GetSourceVersion(in)
+	nc.GetSourceVersion(in)
+	assert.Equal([]string{in, in}, lr.lookups)
+	assert.Equal([]bool{false, true}, lr.fromCache)
+}
+
+func TestImageLabelsOnWarmCacheMakeNoRegistryCalls(t *testing.T) {
+	assert := assert.New(t)
+
+	dc := registrytest.NewFake()
+	nc := NewNameCache(dc, "sqlite3", InMemoryConnection("warmlabels"))
+	ra := NewRectiAgent(nc)
+	const n = 300
+	images := make(map[string]SourceVersion, n)
+	for i := 0; i < n; i++ {
+		sv := SourceVersion{
+			RepoURL: RepoURL(fmt.Sprintf("github.com/opentable/service%d", i)),
+			Version: semv.MustParse("1.2.3"),
+		}
+		in := fmt.Sprintf("docker.repo.io/ot/service%d:1.2.3", i)
+		if _, err := dc.Add(in, sv.DockerLabels()); err != nil {
+			t.Fatal(err)
+		}
+		images[in] = sv
+	}
+
+	for pass := 1; pass <= 2; pass++ {
+		for in, sv := range images {
+			labels, err := ra.ImageLabels(in)
+			if assert.NoError(err) {
+				assert.Equal(sv.DockerLabels(), labels)
+			}
+		}
+		// the first pass misses, and looks each image up once
+		assert.Equal(n, dc.Calls(registrytest.GetImageMetadata), "after pass %d", pass)
+	}
+
+	_, err := ra.ImageLabels("docker.repo.io/ot/unknown:1.0.0")
+	assert.IsType(NoSourceVersionFound{}, err)
+}
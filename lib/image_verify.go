@@ -0,0 +1,72 @@
+package sous
+
+import (
+	"fmt"
+
+	"github.com/opentable/sous/util/docker_registry"
+)
+
+type (
+	// ImageVerifier is implemented by ImageMappers that can check that an
+	// image is in its registry, rather than only that they have a name for
+	// it. NameCache implements it.
+	ImageVerifier interface {
+		// VerifyImage checks that the manifest of the image named in can be
+		// fetched from its registry. It returns NoSourceVersionFound if the
+		// registry doesn't have it, and RegistryUnavailable if the registry
+		// couldn't be asked.
+		VerifyImage(in string) error
+	}
+
+	// ImageMissing is returned by VerifyImageExists when there's a name for
+	// the image of a source version, but the image can't be fetched from
+	// its registry, e.g. because it was never pushed.
+	ImageMissing struct {
+		SourceVersion
+		ImageName string
+	}
+)
+
+func (e ImageMissing) Error() string {
+	return fmt.Sprintf("image %s for %v isn't in its registry", e.ImageName, e.SourceVersion)
+}
+
+// VerifyImageExists checks that there's an image of sv to deploy: that mapper
+// has a name for it, and if mapper is an ImageVerifier, that the image can be
+// fetched from its registry. It returns NoImageNameFound if there's no name,
+// and ImageMissing if the image can't be fetched. If mapper is offline, only
+// the name is checked, and an OfflineError is returned if it's found, so
+// that callers can warn that the image itself wasn't.
+func VerifyImageExists(mapper ImageMapper, sv SourceVersion) error {
+	in, err := mapper.GetImageName(sv)
+	if err != nil {
+		return err
+	}
+	verifier, ok := mapper.(ImageVerifier)
+	if !ok {
+		return nil
+	}
+	err = verifier.VerifyImage(in)
+	if _, ok := err.(NoSourceVersionFound); ok {
+		return ImageMissing{SourceVersion: sv, ImageName: in}
+	}
+	return err
+}
+
+// VerifyImage implements ImageVerifier. An offline NameCache doesn't query
+// its registry, and returns an OfflineError instead.
+func (nc *NameCache) VerifyImage(in string) error {
+	if nc.offline {
+		return OfflineError{Op: fmt.Sprintf("verify %s", in)}
+	}
+	done := nc.timeRegistry("GetImageMetadata")
+	_, err := nc.registryClient.GetImageMetadata(in, "")
+	done()
+	if docker_registry.IsNotFound(err) {
+		return NoSourceVersionFound{imageName(in)}
+	}
+	if err != nil {
+		return RegistryUnavailable{Repo: imageRepo(in), Err: err}
+	}
+	return nil
+}
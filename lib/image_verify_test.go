@@ -0,0 +1,90 @@
+package sous
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/opentable/sous/util/docker_registry/registrytest"
+	"github.com/samsalisbury/semv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyImageExists(t *testing.T) {
+	assert := assert.New(t)
+	dc := registrytest.NewFake()
+	nc := NewNameCache(dc, "sqlite3", InMemoryConnection("verifyimage"))
+	sv := func(version string) SourceVersion {
+		return SourceVersion{RepoURL: "github.com/opentable/one", Version: semv.MustParse(version)}
+	}
+	for _, v := range []string{"1.0.0", "1.1.0"} {
+		in, v := "docker.example.com/one:"+v, sv(v)
+		if _, err := dc.Add(in, v.DockerLabels()); err != nil {
+			t.Fatal(err)
+		}
+		if err := nc.Insert(v, in, "etag"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// cached, but since gone from the registry
+	if err := dc.Delete("docker.example.com/one:1.1.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(VerifyImageExists(nc, sv("1.0.0")))
+	assert.Equal(ImageMissing{SourceVersion: sv("1.1.0"), ImageName: "docker.example.com/one:1.1.0"},
+		VerifyImageExists(nc, sv("1.1.0")))
+	assert.IsType(NoImageNameFound{}, VerifyImageExists(nc, sv("2.0.0")))
+
+	dc.FailNext(registrytest.GetImageMetadata, fmt.Errorf("connection refused"))
+	assert.IsType(RegistryUnavailable{}, VerifyImageExists(nc, sv("1.0.0")))
+
+	offline := NewNameCacheWithOptions(dc, []string{"sqlite3", InMemoryConnection("verifyimage")}, NameCacheOffline())
+	assert.IsType(OfflineError{}, VerifyImageExists(offline, sv("1.1.0")), "offline, only the name is checked")
+	assert.IsType(NoImageNameFound{}, VerifyImageExists(offline, sv("2.0.0")))
+
+	static, err := NewStaticImageMapper(ImageMapping{SourceVersion: sv("1.1.0"), ImageName: "docker.example.com/one:1.1.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(VerifyImageExists(static, sv("1.1.0")), "a mapper that can't verify images only checks names")
+}
+
+func TestStateCheckImages(t *testing.T) {
+	assert := assert.New(t)
+	manifest := func(repo string, versions map[string]string) *Manifest {
+		m := &Manifest{Source: SourceLocation{RepoURL: RepoURL(repo)}, Kind: ManifestKindService, Deployments: DeploySpecs{}}
+		for cluster, v := range versions {
+			m.Deployments[cluster] = PartialDeploySpec{Version: semv.MustParse(v)}
+		}
+		return m
+	}
+	st := State{
+		Defs: Defs{Clusters: Clusters{
+			"eu-west": {Name: "eu-west", BaseURL: "http://eu-west"},
+			"us-west": {Name: "us-west", BaseURL: "http://us-west"},
+		}},
+		Manifests: Manifests{
+			"github.com/opentable/one": manifest("github.com/opentable/one",
+				map[string]string{"eu-west": "2.0.0", "us-west": "2.0.0", "mars": "3.0.0"}),
+			"github.com/opentable/two": manifest("github.com/opentable/two",
+				map[string]string{"eu-west": "1.0.0", "us-west": "1.1.0"}),
+		},
+	}
+	im, err := NewStaticImageMapper(
+		ImageMapping{SourceVersion: SourceVersion{RepoURL: "github.com/opentable/two", Version: semv.MustParse("1.0.0")},
+			ImageName: "docker.example.com/two:1.0.0"},
+		ImageMapping{SourceVersion: SourceVersion{RepoURL: "github.com/opentable/two", Version: semv.MustParse("1.1.0")},
+			ImageName: "docker.example.com/two:1.1.0"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := st.CheckImages("", im, 2)
+	assert.Equal(3, r.Checked, "each version is checked once, and undefined clusters not at all")
+	assert.Empty(r.Errs)
+	if assert.Len(r.Missing, 1) {
+		assert.Equal("manifests/github.com/opentable/one.yaml", r.Missing[0].File)
+		assert.Contains(r.Missing[0].Message, "cluster eu-west, us-west: ")
+	}
+}
@@ -0,0 +1,187 @@
+// Package imagemappertest checks implementations of sous.ImageMapper
+// against the error contract documented on it, so that the NameCache and
+// the registry-free mappers used in its place can't drift apart.
+package imagemappertest
+
+import (
+	"testing"
+
+	"github.com/opentable/sous/lib"
+	"github.com/samsalisbury/semv"
+	"golang.org/x/net/context"
+)
+
+// Contract is the version of the contract these tests check. It must be
+// sous.ImageMapperContract, or they fail, as a reminder to bring them up to
+// date.
+const Contract = 3
+
+// NewMapper builds the ImageMapper under test, knowing the images in known.
+type NewMapper func(t *testing.T, known []sous.ImageMapping) sous.ImageMapper
+
+// Known are the images every mapper under test is built knowing. Their
+// names are all in docker.example.com.
+var Known = []sous.ImageMapping{
+	{
+		SourceVersion: sous.SourceVersion{
+			RepoURL: "github.com/opentable/one",
+			Version: semv.MustParse("1.0.0"),
+		},
+		ImageName: "docker.example.com/ot/one:1.0.0",
+	},
+	{
+		SourceVersion: sous.SourceVersion{
+			RepoURL:    "github.com/opentable/two",
+			RepoOffset: "sub",
+			Version:    semv.MustParse("2.1.0"),
+		},
+		ImageName: "docker.example.com/ot/two:2.1.0",
+	},
+}
+
+// Run checks the mappers built by newMapper against the contract, each
+// test with a fresh one.
+func Run(t *testing.T, newMapper NewMapper) {
+	if Contract != sous.ImageMapperContract {
+		t.Fatalf("imagemappertest checks contract %d; ImageMapper is at %d", Contract, sous.ImageMapperContract)
+	}
+	tests := []struct {
+		name string
+		test func(*testing.T, sous.ImageMapper)
+	}{
+		{"Known", testKnown},
+		{"Labels", testLabels},
+		{"UnknownImageName", testUnknownImageName},
+		{"UnknownSourceVersion", testUnknownSourceVersion},
+		{"Insert", testInsert},
+		{"InsertInvalid", testInsertInvalid},
+		{"Close", testClose},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.test(t, newMapper(t, Known))
+		})
+	}
+}
+
+func testKnown(t *testing.T, m sous.ImageMapper) {
+	for _, k := range Known {
+		sv, err := m.GetSourceVersion(k.ImageName)
+		if err != nil || !sv.Equal(k.SourceVersion) {
+			t.Errorf("GetSourceVersion(%q) = %v, %v; want %v", k.ImageName, sv, err, k.SourceVersion)
+		}
+
+		cn, err := m.GetCanonicalName(k.ImageName)
+		if err != nil {
+			t.Errorf("GetCanonicalName(%q): %v", k.ImageName, err)
+		} else if sv, err := m.GetSourceVersion(cn); err != nil || !sv.Equal(k.SourceVersion) {
+			t.Errorf("canonical name %q maps to %v, %v; want %v", cn, sv, err, k.SourceVersion)
+		}
+
+		in, err := m.GetImageName(k.SourceVersion)
+		if err != nil {
+			t.Errorf("GetImageName(%v): %v", k.SourceVersion, err)
+		} else if sv, err := m.GetSourceVersion(in); err != nil || !sv.Equal(k.SourceVersion) {
+			t.Errorf("image name %q maps to %v, %v; want %v", in, sv, err, k.SourceVersion)
+		}
+	}
+}
+
+func testLabels(t *testing.T, m sous.ImageMapper) {
+	for _, k := range Known {
+		labels, err := m.GetLabels(k.ImageName)
+		if err != nil {
+			t.Errorf("GetLabels(%q): %v", k.ImageName, err)
+			continue
+		}
+		if sv, err := sous.SourceVersionFromLabels(labels); err != nil || !sv.Equal(k.SourceVersion) {
+			t.Errorf("GetLabels(%q) = %v, which is %v, %v; want %v", k.ImageName, labels, sv, err, k.SourceVersion)
+		}
+	}
+}
+
+func testUnknownImageName(t *testing.T, m sous.ImageMapper) {
+	const in = "docker.example.com/ot/unknown:1.0.0"
+	sv, err := m.GetSourceVersion(in)
+	if _, ok := err.(sous.NoSourceVersionFound); !ok {
+		t.Errorf("GetSourceVersion(%q) returned %T %v; want NoSourceVersionFound", in, err, err)
+	}
+	if sv != (sous.SourceVersion{}) {
+		t.Errorf("GetSourceVersion(%q) returned %v with its error; want nothing", in, sv)
+	}
+
+	cn, err := m.GetCanonicalName(in)
+	if _, ok := err.(sous.NoSourceVersionFound); !ok {
+		t.Errorf("GetCanonicalName(%q) returned %T %v; want NoSourceVersionFound", in, err, err)
+	}
+	if cn != "" {
+		t.Errorf("GetCanonicalName(%q) returned %q with its error; want nothing", in, cn)
+	}
+
+	labels, err := m.GetLabels(in)
+	if _, ok := err.(sous.NoSourceVersionFound); !ok {
+		t.Errorf("GetLabels(%q) returned %T %v; want NoSourceVersionFound", in, err, err)
+	}
+	if len(labels) != 0 {
+		t.Errorf("GetLabels(%q) returned %v with its error; want nothing", in, labels)
+	}
+}
+
+func testUnknownSourceVersion(t *testing.T, m sous.ImageMapper) {
+	otherVersion := Known[0].SourceVersion
+	otherVersion.Version = semv.MustParse("9.9.9")
+	unknown := []sous.SourceVersion{
+		otherVersion,
+		{RepoURL: "github.com/opentable/unknown", Version: semv.MustParse("1.0.0")},
+	}
+	for _, sv := range unknown {
+		in, err := m.GetImageName(sv)
+		if _, ok := err.(sous.NoImageNameFound); !ok {
+			t.Errorf("GetImageName(%v) returned %T %v; want NoImageNameFound", sv, err, err)
+		}
+		if in != "" {
+			t.Errorf("GetImageName(%v) returned %q with its error; want nothing", sv, in)
+		}
+	}
+}
+
+func testInsert(t *testing.T, m sous.ImageMapper) {
+	sv := sous.SourceVersion{RepoURL: "github.com/opentable/three", Version: semv.MustParse("3.0.0")}
+	const in = "docker.example.com/ot/three:3.0.0"
+	if err := m.Insert(sv, in, ""); err != nil {
+		t.Fatalf("Insert(%v, %q): %v", sv, in, err)
+	}
+	if got, err := m.GetImageName(sv); err != nil || got != in {
+		t.Errorf("GetImageName(%v) = %q, %v after Insert; want %q", sv, got, err, in)
+	}
+	if cn, err := m.GetCanonicalName(in); err != nil || cn == "" {
+		t.Errorf("GetCanonicalName(%q) = %q, %v after Insert; want a name", in, cn, err)
+	}
+}
+
+func testInsertInvalid(t *testing.T, m sous.ImageMapper) {
+	sv := sous.SourceVersion{RepoURL: "github.com/opentable/invalid", Version: semv.MustParse("1.0.0")}
+	for _, in := range []string{"", "Not A Name"} {
+		if err := m.Insert(sv, in, ""); err == nil {
+			t.Errorf("Insert(%v, %q) succeeded; want an error", sv, in)
+		}
+	}
+	if _, err := m.GetImageName(sv); err == nil {
+		t.Errorf("GetImageName(%v) found a name after invalid Inserts", sv)
+	}
+}
+
+func testClose(t *testing.T, m sous.ImageMapper) {
+	if err := m.Ping(context.Background()); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if err := m.Ping(context.Background()); err != (sous.ErrClosed{}) {
+		t.Errorf("Ping after Close returned %T %v; want ErrClosed", err, err)
+	}
+}
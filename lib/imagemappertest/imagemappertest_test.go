@@ -0,0 +1,34 @@
+package imagemappertest
+
+import (
+	"testing"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/util/docker_registry/registrytest"
+)
+
+func TestStaticImageMapper(t *testing.T) {
+	Run(t, func(t *testing.T, known []sous.ImageMapping) sous.ImageMapper {
+		m, err := sous.NewStaticImageMapper(known...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	})
+}
+
+func TestNameCache(t *testing.T) {
+	Run(t, func(t *testing.T, known []sous.ImageMapping) sous.ImageMapper {
+		dc := registrytest.NewFake()
+		nc := sous.NewNameCache(dc, "sqlite3", sous.InMemoryConnection(t.Name()))
+		for _, k := range known {
+			if _, err := dc.Add(k.ImageName, k.SourceVersion.DockerLabels()); err != nil {
+				t.Fatal(err)
+			}
+			if err := nc.Insert(k.SourceVersion, k.ImageName, ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nc
+	})
+}
@@ -0,0 +1,9 @@
+// Package integration tests the whole of a rectification together: a state
+// directory is parsed, the NameCache is warmed from a docker registry, the
+// deployments running on Singularity are collected and diffed with those
+// intended, and the differences rectified, all with the real NameCache,
+// docker registry client and SingularityClient. The registry and
+// Singularity are fakes served over HTTP by registrytest and
+// singularitytest, so the tests need no docker daemon, and are run by plain
+// go test.
+package integration
@@ -0,0 +1,291 @@
+package integration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/opentable/sous/lib"
+	"github.com/opentable/sous/lib/singularitytest"
+	"github.com/opentable/sous/util/docker_registry"
+	"github.com/opentable/sous/util/docker_registry/registrytest"
+	"golang.org/x/net/context"
+)
+
+// clusters are the clusters every harness's state defines, each served by
+// a singularitytest.Fake of its own.
+var clusters = []string{"eu-west", "us-west"}
+
+// harness runs rectifications of a state directory against a fake registry
+// and fake Singularities.
+type harness struct {
+	t        *testing.T
+	registry *registrytest.Server
+	sings    map[string]*singularitytest.Fake
+	dir      string
+	dc       docker_registry.Client
+	nc       *sous.NameCache
+	// images are the names pushed, relative to the registry, by the
+	// canonical names they're deployed as.
+	images map[string]string
+	// built are the docker repos the name cache has been told of, as a
+	// build would tell it, by caching their first image.
+	built map[string]bool
+}
+
+func newHarness(t *testing.T, name string) *harness {
+	dir, err := ioutil.TempDir("", "sous-integration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc := docker_registry.NewClient()
+	dc.BecomeFoolishlyTrusting()
+	h := &harness{
+		t:        t,
+		registry: registrytest.NewServer(),
+		sings:    map[string]*singularitytest.Fake{},
+		dir:      dir,
+		dc:       dc,
+		images:   map[string]string{},
+		nc:       sous.NewNameCache(dc, "sqlite3", sous.InMemoryConnection("integration"+name)),
+		built:    map[string]bool{},
+	}
+	for _, c := range clusters {
+		h.sings[c] = singularitytest.NewFake()
+	}
+	return h
+}
+
+func (h *harness) close() {
+	h.registry.Close()
+	for _, fs := range h.sings {
+		fs.Close()
+	}
+	os.RemoveAll(h.dir)
+}
+
+// push adds the image of the source version in sourceVersion, e.g.
+// github.com/opentable/one,1.0.0, to the registry, as ot/one:1.0.0. Only
+// the first image of each repo is cached; the rest must be found by
+// warming the cache.
+func (h *harness) push(sourceVersion string) {
+	sv, err := sous.ParseSourceVersion(sourceVersion)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	repo := "ot/" + filepath.Base(string(sv.RepoURL))
+	in, err := h.registry.Add(repo+":"+sv.Version.String(), sv.DockerLabels())
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	md, err := h.dc.GetImageMetadata(in, "")
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	h.images[md.CanonicalName] = strings.TrimPrefix(in, h.registry.Host+"/")
+	if h.built[repo] {
+		return
+	}
+	h.built[repo] = true
+	if _, err := h.nc.GetSourceVersion(in); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// writeState replaces the state directory with one defining clusters, at
+// the URLs of their fakes, and holding manifests, keyed by source location.
+func (h *harness) writeState(manifests map[string]string) {
+	if err := os.RemoveAll(filepath.Join(h.dir, "manifests")); err != nil {
+		h.t.Fatal(err)
+	}
+	defs := "Clusters:\n"
+	for _, c := range clusters {
+		defs += fmt.Sprintf("  %s:\n    Kind: singularity\n    BaseURL: %s\n", c, h.sings[c].URL)
+	}
+	files := map[string]string{"defs.yaml": defs}
+	for sl, m := range manifests {
+		files[filepath.Join("manifests", sl+".yaml")] = m
+	}
+	for name, contents := range files {
+		path := filepath.Join(h.dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			h.t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0666); err != nil {
+			h.t.Fatal(err)
+		}
+	}
+}
+
+// plan parses the state directory, warms the name cache for each of its
+// manifests, collects what's running and returns the differences, as sous
+// rectify does.
+func (h *harness) plan() (*sous.SingularityClient, sous.DiffChans) {
+	state, err := sous.LoadState(h.dir)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	harvest := h.nc.NewHarvest()
+	for _, m := range state.Manifests {
+		if _, err := harvest.Warm(m.Source); err != nil {
+			h.t.Fatalf("warming %s: %s", m.Source, err)
+		}
+	}
+	rc := sous.NewSingularityClient(state.Defs.ClusterURLs(), h.nc)
+	dcs, err := sous.ResolvePlanWith(context.Background(), rc, state, state.BaseURLs(), nil, sous.ResolveOpts{})
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return rc, dcs
+}
+
+// rectify makes the fakes match the state directory.
+func (h *harness) rectify() {
+	rc, dcs := h.plan()
+	err := sous.RectifyPlan(rc, dcs, func(err error) {
+		h.t.Errorf("rectifying: %s", err)
+	})
+	if err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// running describes a request running on a fake Singularity: its cluster,
+// ID, instance count, and the images of its deploys, oldest first.
+type running struct {
+	cluster, reqID string
+	instances      int
+	images         []string
+}
+
+func (r running) String() string {
+	return fmt.Sprintf("%s %s x%d %s", r.cluster, r.reqID, r.instances, strings.Join(r.images, ", "))
+}
+
+// running lists the requests on the fakes, naming images as they were
+// pushed.
+func (h *harness) running() []string {
+	var rs []string
+	for _, c := range clusters {
+		for _, req := range h.sings[c].Requests() {
+			r := running{cluster: c, reqID: req.ID, instances: req.Instances}
+			for _, dep := range h.sings[c].Deploys(req.ID) {
+				in, ok := h.images[dep.Image]
+				if !ok {
+					in = "unknown image " + dep.Image
+				}
+				r.images = append(r.images, in)
+			}
+			rs = append(rs, r.String())
+		}
+	}
+	sort.Strings(rs)
+	return rs
+}
+
+func manifest(repo, version string, instances map[string]int) string {
+	m := fmt.Sprintf("Source: github.com/opentable/%s\nKind: http-service\nDeployments:\n", repo)
+	for _, c := range clusters {
+		n, ok := instances[c]
+		if !ok {
+			continue
+		}
+		m += fmt.Sprintf("  %s:\n    Resources: {cpus: \"0.1\", memory: \"100\", ports: \"1\"}\n"+
+			"    Version: %s\n    NumInstances: %d\n", c, version, n)
+	}
+	return m
+}
+
+func TestRectifyPipeline(t *testing.T) {
+	one := func(version string, instances map[string]int) map[string]string {
+		return map[string]string{"github.com/opentable/one": manifest("one", version, instances)}
+	}
+	both := map[string]int{"eu-west": 1, "us-west": 2}
+
+	for _, test := range []struct {
+		name string
+		// before is the state rectified first, after pushing builtBefore,
+		// and after is the state then rectified, after pushing builtAfter.
+		before, after           map[string]string
+		builtBefore, builtAfter []string
+		want                    []string
+	}{
+		{
+			name:        "create",
+			after:       one("1.0.0", both),
+			builtBefore: []string{"github.com/opentable/one,1.0.0"},
+			want: []string{
+				"eu-west github.comopentableone x1 ot/one:1.0.0",
+				"us-west github.comopentableone x2 ot/one:1.0.0",
+			},
+		},
+		{
+			name:        "modify-scale",
+			before:      one("1.0.0", both),
+			after:       one("1.0.0", map[string]int{"eu-west": 1, "us-west": 5}),
+			builtBefore: []string{"github.com/opentable/one,1.0.0"},
+			want: []string{
+				"eu-west github.comopentableone x1 ot/one:1.0.0",
+				"us-west github.comopentableone x5 ot/one:1.0.0",
+			},
+		},
+		{
+			name:        "modify-deploy",
+			before:      one("1.0.0", both),
+			after:       one("1.1.0", both),
+			builtBefore: []string{"github.com/opentable/one,1.0.0"},
+			// only found by warming the cache with the repo's tags
+			builtAfter: []string{"github.com/opentable/one,1.1.0"},
+			want: []string{
+				"eu-west github.comopentableone x1 ot/one:1.0.0, ot/one:1.1.0",
+				"us-west github.comopentableone x2 ot/one:1.0.0, ot/one:1.1.0",
+			},
+		},
+		{
+			name: "delete",
+			before: map[string]string{
+				"github.com/opentable/one": manifest("one", "1.0.0", both),
+				"github.com/opentable/two": manifest("two", "2.0.0", both),
+			},
+			after: map[string]string{
+				"github.com/opentable/one": manifest("one", "1.0.0", both),
+				"github.com/opentable/two": manifest("two", "2.0.0", map[string]int{"us-west": 2}),
+			},
+			builtBefore: []string{"github.com/opentable/one,1.0.0", "github.com/opentable/two,2.0.0"},
+			want: []string{
+				"eu-west github.comopentableone x1 ot/one:1.0.0",
+				"us-west github.comopentableone x2 ot/one:1.0.0",
+				"us-west github.comopentabletwo x2 ot/two:2.0.0",
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHarness(t, test.name)
+			defer h.close()
+			for _, sv := range test.builtBefore {
+				h.push(sv)
+			}
+			if test.before != nil {
+				h.writeState(test.before)
+				h.rectify()
+			}
+			for _, sv := range test.builtAfter {
+				h.push(sv)
+			}
+			h.writeState(test.after)
+			h.rectify()
+
+			if got := h.running(); strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Errorf("running:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+			_, dcs := h.plan()
+			if r := sous.CollectDiff(dcs); r.Counts != (sous.DiffCounts{Retained: len(test.want)}) {
+				t.Errorf("after rectifying, got %+v; want only retained deployments", r.Counts)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package sous
+
+import "fmt"
+
+type (
+	// LegacyRectificationClient is RectificationClient as it was before
+	// clusters were identified by ClusterName.
+	//
+	// Deprecated: implement RectificationClient instead. Until then, wrap
+	// implementations with FromLegacyClient.
+	LegacyRectificationClient interface {
+		Deploy(cluster, depID, reqID, dockerImage string, r Resources, e Env, vols Volumes) error
+		PostRequest(cluster, reqID string, instanceCount int) error
+		Scale(cluster, reqID string, instanceCount int, message string) error
+		DeleteRequest(cluster, reqID, message string) error
+		ImageName(d *Deployment) (string, error)
+		ImageLabels(imageName string) (labels map[string]string, err error)
+		RunningDeployments(cluster string) (Deployments, error)
+		PendingDeploy(cluster, reqID string) (pending bool, depID string, err error)
+	}
+
+	legacyClient struct {
+		LegacyRectificationClient
+	}
+
+	// deployHistorian is the method of RectificationClient that
+	// LegacyRectificationClient lacks.
+	deployHistorian interface {
+		DeployHistory(cluster ClusterName, reqID string, count int) ([]DeployRecord, error)
+	}
+)
+
+// FromLegacyClient adapts c to RectificationClient. If c implements any of
+// InstanceDeployer, DeployCanceller, RequestInspector or KindPoster, so does
+// the client returned, since the rectifier only uses them if it can find
+// them by type assertion.
+//
+// Deprecated: implement RectificationClient directly.
+func FromLegacyClient(c LegacyRectificationClient) RectificationClient {
+	lc := legacyClient{c}
+	id, isID := c.(InstanceDeployer)
+	dc, isDC := c.(DeployCanceller)
+	ri, isRI := c.(RequestInspector)
+	kp, isKP := c.(KindPoster)
+	// one bit for each optional interface, in the order above
+	var has uint
+	for i, is := range []bool{isID, isDC, isRI, isKP} {
+		if is {
+			has |= 1 << uint(i)
+		}
+	}
+	switch has {
+	case 0:
+		return lc
+	case 1:
+		return struct {
+			legacyClient
+			InstanceDeployer
+		}{lc, id}
+	case 2:
+		return struct {
+			legacyClient
+			DeployCanceller
+		}{lc, dc}
+	case 3:
+		return struct {
+			legacyClient
+			InstanceDeployer
+			DeployCanceller
+		}{lc, id, dc}
+	case 4:
+		return struct {
+			legacyClient
+			RequestInspector
+		}{lc, ri}
+	case 5:
+		return struct {
+			legacyClient
+			InstanceDeployer
+			RequestInspector
+		}{lc, id, ri}
+	case 6:
+		return struct {
+			legacyClient
+			DeployCanceller
+			RequestInspector
+		}{lc, dc, ri}
+	case 7:
+		return struct {
+			legacyClient
+			InstanceDeployer
+			DeployCanceller
+			RequestInspector
+		}{lc, id, dc, ri}
+	case 8:
+		return struct {
+			legacyClient
+			KindPoster
+		}{lc, kp}
+	case 9:
+		return struct {
+			legacyClient
+			InstanceDeployer
+			KindPoster
+		}{lc, id, kp}
+	case 10:
+		return struct {
+			legacyClient
+			DeployCanceller
+			KindPoster
+		}{lc, dc, kp}
+	case 11:
+		return struct {
+			legacyClient
+			InstanceDeployer
+			DeployCanceller
+			KindPoster
+		}{lc, id, dc, kp}
+	case 12:
+		return struct {
+			legacyClient
+			RequestInspector
+			KindPoster
+		}{lc, ri, kp}
+	case 13:
+		return struct {
+			legacyClient
+			InstanceDeployer
+			RequestInspector
+			KindPoster
+		}{lc, id, ri, kp}
+	case 14:
+		return struct {
+			legacyClient
+			DeployCanceller
+			RequestInspector
+			KindPoster
+		}{lc, dc, ri, kp}
+	default:
+		return struct {
+			legacyClient
+			InstanceDeployer
+			DeployCanceller
+			RequestInspector
+			KindPoster
+		}{lc, id, dc, ri, kp}
+	}
+}
+
+func (c legacyClient) Deploy(cluster ClusterName, depID, reqID, dockerImage string, r Resources, e Env, vols Volumes) error {
+	return c.LegacyRectificationClient.Deploy(string(cluster), depID, reqID, dockerImage, r, e, vols)
+}
+
+func (c legacyClient) PostRequest(cluster ClusterName, reqID string, instanceCount int) error {
+	return c.LegacyRectificationClient.PostRequest(string(cluster), reqID, instanceCount)
+}
+
+func (c legacyClient) Scale(cluster ClusterName, reqID string, instanceCount int, message string) error {
+	return c.LegacyRectificationClient.Scale(string(cluster), reqID, instanceCount, message)
+}
+
+func (c legacyClient) DeleteRequest(cluster ClusterName, reqID, message string) error {
+	return c.LegacyRectificationClient.DeleteRequest(string(cluster), reqID, message)
+}
+
+func (c legacyClient) RunningDeployments(cluster ClusterName) (Deployments, error) {
+	return c.LegacyRectificationClient.RunningDeployments(string(cluster))
+}
+
+func (c legacyClient) PendingDeploy(cluster ClusterName, reqID string) (bool, string, error) {
+	return c.LegacyRectificationClient.PendingDeploy(string(cluster), reqID)
+}
+
+// DeployHistory calls the wrapped client's DeployHistory, if it has one.
+// Otherwise it fails: LegacyRectificationClient has no way to read the history
+// of a request.
+func (c legacyClient) DeployHistory(cluster ClusterName, reqID string, count int) ([]DeployRecord, error) {
+	if h, ok := c.LegacyRectificationClient.(deployHistorian); ok {
+		return h.DeployHistory(cluster, reqID, count)
+	}
+	return nil, fmt.Errorf("%T can't read deploy history", c.LegacyRectificationClient)
+}
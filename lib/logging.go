@@ -4,6 +4,17 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+
+	"github.com/opentable/sous/util/logging"
+)
+
+type (
+	// Logger is the structured logger the NameCache and the rectifier log
+	// through. Adapters to the standard library's logger and to lines of
+	// JSON are in util/logging.
+	Logger interface {
+		logging.Logger
+	}
 )
 
 var (
@@ -18,4 +29,8 @@ var (
 		Info:  log.New(ioutil.Discard, "info: ", 0),
 		Warn:  log.New(os.Stderr, "warn: ", 0),
 	}
+
+	// DefaultLogger logs through Log. It's used wherever no Logger is
+	// given.
+	DefaultLogger Logger = logging.NewStdLogger(Log.Debug, Log.Info, Log.Warn)
 )
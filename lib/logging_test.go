@@ -0,0 +1,103 @@
+package sous
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/opentable/sous/util/docker_registry/registrytest"
+	"github.com/opentable/sous/util/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+type (
+	recordingLogger struct {
+		*logRecord
+		context []interface{}
+	}
+
+	logRecord struct {
+		sync.Mutex
+		lines []logLine
+	}
+
+	logLine struct {
+		level   string
+		msg     string
+		context map[string]interface{}
+	}
+)
+
+func newRecordingLogger() recordingLogger {
+	return recordingLogger{logRecord: &logRecord{}}
+}
+
+func (l recordingLogger) record(level, format string, args []interface{}) {
+	line := logLine{level, fmt.Sprintf(format, args...), map[string]interface{}{}}
+	for i := 0; i+1 < len(l.context); i += 2 {
+		line.context[fmt.Sprint(l.context[i])] = l.context[i+1]
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.lines = append(l.lines, line)
+}
+
+func (l recordingLogger) Debugf(f string, args ...interface{}) { l.record("debug", f, args) }
+func (l recordingLogger) Infof(f string, args ...interface{})  { l.record("info", f, args) }
+func (l recordingLogger) Warnf(f string, args ...interface{})  { l.record("warn", f, args) }
+
+func (l recordingLogger) With(kv ...interface{}) logging.Logger {
+	context := append(append([]interface{}{}, l.context...), kv...)
+	return recordingLogger{logRecord: l.logRecord, context: context}
+}
+
+// debugLogging has subsystem log at debug level, until the function it
+// returns is called.
+func debugLogging(subsystem string) func() {
+	prior := logging.DefaultLevels.Level(subsystem)
+	logging.DefaultLevels.SetLevel(subsystem, logging.DebugLevel)
+	return func() { logging.DefaultLevels.SetLevel(subsystem, prior) }
+}
+
+func TestRectifierLogsWithContext(t *testing.T) {
+	defer debugLogging(logging.Rectify)()
+	l := newRecordingLogger()
+	pair := rolloutPair("25%")
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	chanset := NewDiffChans(1)
+	errs := RectifyWith(chanset, client, RectifyOpts{Logger: l})
+	chanset.Modified <- pair
+	chanset.Close()
+	for e := range errs {
+		t.Error(e)
+	}
+
+	assert.NotEmpty(t, l.lines)
+	for _, line := range l.lines {
+		assert.Equal(t, map[string]interface{}{
+			"request": ComputeRequestID(pair.post), "cluster": "cluster",
+		}, line.context, line.msg)
+	}
+}
+
+func TestNameCacheLogsWithContext(t *testing.T) {
+	defer debugLogging(logging.NameCache)()
+	l := newRecordingLogger()
+	fake := registrytest.NewFake()
+	name := "docker.example.com/repo:1.2.3"
+	sv := SourceVersion{RepoURL: "github.com/example/repo"}
+	_, err := fake.Add(name, sv.DockerLabels())
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc := NewNameCacheWithOptions(fake, []string{"sqlite3", InMemoryConnection("logging")}, NameCacheLogger(l))
+
+	if _, err := nc.GetSourceVersion(name); err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEmpty(t, l.lines)
+	for _, line := range l.lines {
+		// inserts are logged with the canonical name
+		assert.Contains(t, line.context["image"], "docker.example.com/repo", line.msg)
+	}
+}
@@ -1,15 +1,21 @@
 package sous
 
 import (
-	"math"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 
 	"fmt"
 
+	"github.com/opentable/sous/util/yaml"
 	"github.com/samsalisbury/semv"
 )
 
+// GlobalDeploySpec is the key in a Manifest's Deployments of the spec that
+// the spec of each cluster is merged over.
+const GlobalDeploySpec = "Global"
+
 type (
 	// Manifests is a collection of Manifest.
 	Manifests map[string]*Manifest
@@ -29,8 +35,14 @@ type (
 		// Owners is a list of named owners of this repository. The type of this
 		// field is subject to change.
 		Owners []string
-		// Kind is the kind of software that SourceRepo represents.
+		// Kind is the kind of software that SourceRepo represents. A
+		// manifest read without one is an http-service, which is what every
+		// manifest was before kinds were distinguished.
 		Kind ManifestKind `validate:"nonzero"`
+		// Schedule is when a scheduled manifest runs, as a cron expression,
+		// e.g. "0 3 * * *". It's required for that kind, and not allowed for
+		// any other.
+		Schedule string `yaml:",omitempty"`
 		// Deployments is a map of cluster names to DeploymentSpecs
 		Deployments DeploySpecs `validate:"keys=nonempty,values=nonzero"`
 	}
@@ -83,22 +95,49 @@ type (
 	// of instances.
 	DeployConfig struct {
 		// Resources represents the resources each instance of this software
-		// will be given by the execution environment.
-		Resources Resources `yaml:",omitempty" validate:"keys=nonempty,values=nonempty"`
+		// will be given by the execution environment. A resource that's null
+		// removes the one inherited, as in MergeDeployConfig.
+		Resources Resources `yaml:",omitempty" validate:"keys=nonempty"`
 		// Env is a list of environment variables to set for each instance of
 		// of this deployment. It will be checked for conflict with the
 		// definitions found in State.Defs.EnvVars, and if not in conflict
-		// assumes the greatest priority.
+		// assumes the greatest priority. A variable that's null removes the
+		// one inherited, as in MergeDeployConfig; one that's empty is set
+		// empty.
 		Args []string `yaml:",omitempty" validate:"values=nonempty"`
-		Env  `yaml:",omitempty" validate:"keys=nonempty,values=nonempty"`
+		Env  Env      `yaml:",omitempty" validate:"keys=nonempty"`
 		// NumInstances is a guide to the number of instances that should be
 		// deployed in this cluster, note that the actual number may differ due
 		// to decisions made by Sous. If set to zero, Sous will decide how many
-		// instances to launch.
+		// instances to launch. A cluster's spec that sets it, even to zero,
+		// overrides its Global spec's: see SetNumInstances.
 		NumInstances int
+		// numInstancesSet records that NumInstances was set although it's
+		// zero, e.g. by "NumInstances: 0" in a manifest.
+		numInstancesSet bool
 
 		// Volumes lists the volume mappings for this deploy
 		Volumes Volumes
+
+		// Rollout, if set, makes changes of image roll out to a few
+		// instances at a time rather than all at once.
+		Rollout *Rollout `yaml:",omitempty"`
+
+		// AllowDowngrade lets rectification deploy a version older than the
+		// one running, even if downgrades are blocked.
+		AllowDowngrade bool `yaml:",omitempty"`
+
+		// Metadata is operational information about the deployment, like
+		// the team that owns it, which is recorded in audit entries. It is
+		// never compared, so changing only Metadata changes nothing on the
+		// cluster. A key that's null removes the one inherited, as in
+		// MergeDeployConfig.
+		Metadata map[string]string `yaml:",omitempty"`
+
+		// removed holds the keys of Resources, Env and Metadata that were
+		// set to null, e.g. "Env.DEBUG", which aren't in those maps: see
+		// RemoveKey.
+		removed map[string]bool
 	}
 
 	// Resources is a mapping of resource name to value, used to provision
@@ -108,7 +147,8 @@ type (
 	Resources map[string]string
 
 	// Env is a mapping of environment variable name to value, used to provision
-	// single instances of an application.
+	// single instances of an application. See Env.Validate for the names and
+	// values it may hold.
 	Env map[string]string
 
 	// Volume describes a deployment's volume mapping
@@ -131,11 +171,159 @@ const (
 	ReadWrite VolumeMode = "RW"
 )
 
+// Validate defaults m's Kind to ManifestKindService, and checks the
+// constraints of its kind. It implements hy.Validator, so it's called as each
+// manifest is parsed.
+func (m *Manifest) Validate() error {
+	if m.Kind == "" {
+		m.Kind = ManifestKindService
+	}
+	switch m.Kind {
+	default:
+		return fmt.Errorf("unknown Kind %q: want %s, %s, %s, %s or %s", m.Kind,
+			ManifestKindService, ManifestKindWorker, ManifestKindScheduled, ManifestKindOnDemand, ManifestKindOnce)
+	case ManifestKindScheduled:
+		if m.Schedule == "" {
+			return fmt.Errorf("Kind %s requires a Schedule", m.Kind)
+		}
+	case ManifestKindService, ManifestKindWorker, ManifestKindOnDemand, ManifestKindOnce:
+		if m.Schedule != "" {
+			return fmt.Errorf("Schedule %q is only allowed for Kind %s, not %s", m.Schedule, ManifestKindScheduled, m.Kind)
+		}
+	}
+	return nil
+}
+
 // FileLocation returns the path that the manifest should be saved to
 func (m *Manifest) FileLocation() string {
 	return filepath.Join(string(m.Source.RepoURL), string(m.Source.RepoOffset))
 }
 
+// SetNumInstances sets dc's NumInstances to n, so that it overrides the one
+// it's merged over even if n is zero.
+func (dc *DeployConfig) SetNumInstances(n int) {
+	dc.NumInstances = n
+	dc.numInstancesSet = n == 0
+}
+
+// NumInstancesSet reports whether dc sets NumInstances, so that it overrides
+// the one it's merged over, as any non-zero NumInstances does.
+func (dc DeployConfig) NumInstancesSet() bool {
+	return dc.NumInstances != 0 || dc.numInstancesSet
+}
+
+// removableFields are the fields of DeployConfig whose keys can be removed
+// by RemoveKey.
+var removableFields = []string{"Resources", "Env", "Metadata"}
+
+// RemoveKey makes dc remove key from its field, which is "Resources", "Env"
+// or "Metadata", when it's merged over another DeployConfig, as a key set to
+// null in a manifest does. The key is deleted from dc's own field.
+func (dc *DeployConfig) RemoveKey(field, key string) {
+	switch field {
+	default:
+		panic(fmt.Sprintf("RemoveKey: DeployConfig has no field %q with keys to remove", field))
+	case "Resources":
+		delete(dc.Resources, key)
+	case "Env":
+		delete(dc.Env, key)
+	case "Metadata":
+		delete(dc.Metadata, key)
+	}
+	if dc.removed == nil {
+		dc.removed = map[string]bool{}
+	}
+	dc.removed[field+"."+key] = true
+}
+
+// RemovedKeys returns the keys that dc removes from field, sorted: see
+// RemoveKey.
+func (dc DeployConfig) RemovedKeys(field string) []string {
+	var keys []string
+	for fk := range dc.removed {
+		if k := strings.TrimPrefix(fk, field+"."); k != fk {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// UnmarshalYAML reads ds as usual, noting whether its NumInstances was set
+// to zero or just left out, and which keys of its Resources, Env and
+// Metadata are null, rather than empty.
+func (ds *PartialDeploySpec) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain PartialDeploySpec
+	if err := unmarshal((*plain)(ds)); err != nil {
+		return err
+	}
+	keys := map[string]interface{}{}
+	if err := unmarshal(&keys); err != nil {
+		return err
+	}
+	_, set := keys["NumInstances"]
+	ds.numInstancesSet = set && ds.NumInstances == 0
+	for _, field := range removableFields {
+		m, ok := keys[field].(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+		for k, v := range m {
+			if v == nil {
+				ds.RemoveKey(field, fmt.Sprint(k))
+			}
+		}
+	}
+	return nil
+}
+
+// UnmarshalsFields implements yaml.FieldUnmarshaler, so that strict reading
+// still checks ds's keys.
+func (ds *PartialDeploySpec) UnmarshalsFields() {}
+
+// MarshalYAML writes ds as usual, but leaves NumInstances out unless it's
+// set, and writes the keys it removes as null, so that reading ds back gives
+// the same merge over its Global spec.
+func (ds PartialDeploySpec) MarshalYAML() (interface{}, error) {
+	type plain PartialDeploySpec
+	if ds.NumInstancesSet() && len(ds.removed) == 0 {
+		return plain(ds), nil
+	}
+	b, err := yaml.Marshal(plain(ds))
+	if err != nil {
+		return nil, err
+	}
+	m := yaml.MapSlice{}
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	out := make(yaml.MapSlice, 0, len(m)+len(removableFields))
+	for _, item := range m {
+		if item.Key != "NumInstances" || ds.NumInstancesSet() {
+			out = append(out, item)
+		}
+	}
+	for _, field := range removableFields {
+		removed := ds.RemovedKeys(field)
+		if len(removed) == 0 {
+			continue
+		}
+		i := 0
+		for i < len(out) && out[i].Key != field {
+			i++
+		}
+		if i == len(out) {
+			out = append(out, yaml.MapItem{Key: field, Value: yaml.MapSlice{}})
+		}
+		keys, _ := out[i].Value.(yaml.MapSlice)
+		for _, k := range removed {
+			keys = append(keys, yaml.MapItem{Key: k, Value: nil})
+		}
+		out[i].Value = keys
+	}
+	return out, nil
+}
+
 func (dc *DeployConfig) String() string {
 	return fmt.Sprintf("#%d %+v : %+v %+v", dc.NumInstances, dc.Resources, dc.Env, dc.Volumes)
 }
@@ -143,37 +331,98 @@ func (dc *DeployConfig) String() string {
 const (
 	// HTTP Service represents an HTTP service which is a long-running process,
 	// and listens and responds to HTTP requests.
-	ManifestKindService   (ManifestKind) = "http-service"
-	ManifestKindWorker    (ManifestKind) = "worker"
-	ManifestKindOnDemand  (ManifestKind) = "on-demand"
+	ManifestKindService (ManifestKind) = "http-service"
+	// ManifestKindWorker is a long-running process that doesn't serve
+	// HTTP, e.g. a queue consumer.
+	ManifestKindWorker (ManifestKind) = "worker"
+	// ManifestKindOnDemand is a process that's run when asked to, and exits
+	// when it completes its task.
+	ManifestKindOnDemand (ManifestKind) = "on-demand"
+	// ManifestKindScheduled is a process that's run on the manifest's
+	// Schedule, and exits when it completes its task.
 	ManifestKindScheduled (ManifestKind) = "scheduled"
-	ManifestKindOnce      (ManifestKind) = "once"
+	// ManifestKindOnce is the kind of requests that Singularity runs only
+	// once. Sous doesn't create them, but reads them from clusters.
+	ManifestKindOnce (ManifestKind) = "once"
 	// ScheduledJob represents a process which starts on some schedule, and
 	// exits when it completes its task.
 	ScheduledJob = "scheduled-job"
 )
 
-// Equal is used to compare DeployConfigs
+// Equal is used to compare DeployConfigs. Metadata is ignored.
 func (dc *DeployConfig) Equal(o DeployConfig) bool {
 	Log.Debug.Printf("%+ v ?= %+ v", dc, o)
 	return (dc.NumInstances == o.NumInstances && dc.Env.Equal(o.Env) && dc.Resources.Equal(o.Resources) && dc.Volumes.Equal(o.Volumes))
 }
 
-// Equal is used to compare Volumes pairs
+// Equal is used to compare Volumes pairs. The comparison ignores order,
+// trailing slashes on paths and the difference between an empty and the
+// default (read-only) mode; nil and empty Volumes are equal.
 func (vs Volumes) Equal(o Volumes) bool {
-	if len(vs) != len(o) {
+	nvs, no := vs.normalized(), o.normalized()
+	if len(nvs) != len(no) {
 		return false
 	}
-	c := append(Volumes{}, o...)
+	for i := range nvs {
+		if nvs[i] != no[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// normalized returns the volumes as values with cleaned paths and explicit
+// modes, sorted by host then container path.
+func (vs Volumes) normalized() []Volume {
+	n := make([]Volume, 0, len(vs))
 	for _, v := range vs {
-		for i, ov := range c {
-			if v == ov {
-				c[i] = c[len(c)-1]
-				c = c[:len(c)-1]
-			}
+		if v == nil {
+			continue
+		}
+		nv := Volume{
+			Host:      normalizeVolumePath(v.Host),
+			Container: normalizeVolumePath(v.Container),
+			Mode:      v.Mode,
+		}
+		if nv.Mode == "" {
+			nv.Mode = ReadOnly
+		}
+		n = append(n, nv)
+	}
+	sort.Slice(n, func(i, j int) bool {
+		if n[i].Host != n[j].Host {
+			return n[i].Host < n[j].Host
+		}
+		if n[i].Container != n[j].Container {
+			return n[i].Container < n[j].Container
+		}
+		return n[i].Mode < n[j].Mode
+	})
+	return n
+}
+
+func normalizeVolumePath(p string) string {
+	if len(p) > 1 {
+		return strings.TrimRight(p, "/")
+	}
+	return p
+}
+
+// Clone returns a deep copy of vs
+func (vs Volumes) Clone() Volumes {
+	if vs == nil {
+		return nil
+	}
+	c := make(Volumes, 0, len(vs))
+	for _, v := range vs {
+		if v == nil {
+			c = append(c, nil)
+			continue
 		}
+		cv := *v
+		c = append(c, &cv)
 	}
-	return len(c) == 0
+	return c
 }
 
 func (vs Volumes) String() string {
@@ -236,41 +485,14 @@ func (r Resources) ports() int32 {
 	return int32(ports)
 }
 
-// Equal checks equivalence between resource maps
-func (r Resources) Equal(o Resources) bool {
-	Log.Debug.Printf("Comparing resources: %+ v ?= %+ v", r, o)
-	if len(r) != len(o) {
-		Log.Debug.Println("Lengths differ")
-		return false
-	}
-
-	if r.ports() != o.ports() {
-		Log.Debug.Println("Ports differ")
-		return false
-	}
-
-	if math.Abs(r.cpus()-o.cpus()) > 0.001 {
-		Log.Debug.Println("Cpus differ")
-		return false
-	}
-
-	if math.Abs(r.memory()-o.memory()) > 0.001 {
-		Log.Debug.Println("Memory differ")
-		return false
+// Clone returns a copy of r
+func (r Resources) Clone() Resources {
+	if r == nil {
+		return nil
 	}
-
-	return true
-}
-
-func (e Env) Equal(o Env) bool {
-	if len(e) != len(o) {
-		return false
+	c := make(Resources, len(r))
+	for k, v := range r {
+		c[k] = v
 	}
-
-	for name, value := range e {
-		if ov, ok := o[name]; !ok || ov != value {
-			return false
-		}
-	}
-	return true
+	return c
 }
@@ -0,0 +1,53 @@
+package sous
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVolumesEqual(t *testing.T) {
+	assert := assert.New(t)
+
+	a := &Volume{"/host/a", "/container/a", "RO"}
+	b := &Volume{"/host/b", "/container/b", "RW"}
+
+	assert.True(Volumes(nil).Equal(Volumes{}))
+	assert.True(Volumes{a, b}.Equal(Volumes{b, a}), "order is ignored")
+	assert.True(Volumes{a}.Equal(Volumes{&Volume{"/host/a/", "/container/a/", "RO"}}), "trailing slashes are ignored")
+	assert.True(Volumes{a}.Equal(Volumes{&Volume{"/host/a", "/container/a", ""}}), "empty mode is read-only")
+	assert.True(Volumes{&Volume{"/", "/", "RO"}}.Equal(Volumes{&Volume{"/", "/", "RO"}}))
+
+	assert.False(Volumes{a}.Equal(Volumes{&Volume{"/host/a", "/container/a", "RW"}}), "modes differ")
+	assert.False(Volumes{&Volume{"/host/b", "/container/b", ""}}.Equal(Volumes{b}), "empty mode isn't read-write")
+	assert.False(Volumes{a}.Equal(Volumes{b}))
+
+	assert.True(Volumes{a, a, b}.Equal(Volumes{a, b, a}), "duplicates are counted")
+	assert.False(Volumes{a, a, b}.Equal(Volumes{a, b, b}), "duplicates are counted")
+	assert.False(Volumes{a, a}.Equal(Volumes{a}))
+}
+
+func TestManifestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &Manifest{}
+	assert.NoError(m.Validate())
+	assert.Equal(ManifestKindService, m.Kind, "a missing kind is an http-service")
+
+	for _, ok := range []*Manifest{
+		{Kind: ManifestKindWorker},
+		{Kind: ManifestKindOnDemand},
+		{Kind: ManifestKindOnce},
+		{Kind: ManifestKindScheduled, Schedule: "0 3 * * *"},
+	} {
+		assert.NoError(ok.Validate(), "%s", ok.Kind)
+	}
+	for _, bad := range []*Manifest{
+		{Kind: "cron-job"},
+		{Kind: ManifestKindScheduled},
+		{Kind: ManifestKindService, Schedule: "0 3 * * *"},
+		{Kind: ManifestKindOnce, Schedule: "0 3 * * *"},
+	} {
+		assert.Error(bad.Validate(), "%s %q", bad.Kind, bad.Schedule)
+	}
+}
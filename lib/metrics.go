@@ -0,0 +1,115 @@
+package sous
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/opentable/sous/util/metrics"
+)
+
+// The metrics reported to the metrics.Collector set by RectifyOpts.Metrics
+// and NameCacheMetrics.
+const (
+	// MetricRectifyDuration is a histogram of how long each rectification
+	// took, in seconds, from RectifyWith being called to its error channel
+	// closing.
+	MetricRectifyDuration = "sous_rectify_duration_seconds"
+	// MetricRectifyCallDuration is a histogram of how long each call the
+	// rectifier made to its RectificationClient took, in seconds, labelled
+	// by the "op" called, e.g. "Deploy".
+	MetricRectifyCallDuration = "sous_rectify_call_duration_seconds"
+	// MetricRectifyActions counts the RectifyEvents of each "kind", e.g.
+	// "deployed", whether or not RectifyOpts.Events is set.
+	MetricRectifyActions = "sous_rectify_actions_total"
+	// MetricRectifyErrors counts the RectificationErrors of each "type",
+	// i.e. ErrorKind, e.g. "transient".
+	MetricRectifyErrors = "sous_rectify_errors_total"
+	// MetricNameCacheLookups counts the lookups of source versions and
+	// image names, labelled by "lookup" ("source version" or "image name")
+	// and "result": "hit" if the cache had the answer, "miss" if the
+	// registry was asked for it, or "error".
+	MetricNameCacheLookups = "sous_name_cache_lookups_total"
+	// MetricNameCacheHitRatio is a gauge of the proportion of the lookups
+	// made by a NameCache that were hits.
+	MetricNameCacheHitRatio = "sous_name_cache_hit_ratio"
+	// MetricRegistryDuration is a histogram of how long each query a
+	// NameCache made of its registry client took, in seconds, labelled by
+	// the "op" called, e.g. "GetImageMetadata".
+	MetricRegistryDuration = "sous_registry_request_duration_seconds"
+)
+
+type (
+	// nameCacheStats counts the lookups made by a NameCache, to work out
+	// its hit ratio.
+	nameCacheStats struct {
+		hits, lookups int64
+	}
+
+	lookupResult string
+)
+
+const (
+	lookupHit   lookupResult = "hit"
+	lookupMiss  lookupResult = "miss"
+	lookupError lookupResult = "error"
+)
+
+// NameCacheMetrics has the NameCache report its lookups, and how long its
+// registry queries take, to c.
+func NameCacheMetrics(c metrics.Collector) NameCacheOption {
+	return func(nc *NameCache) {
+		nc.metrics = c
+	}
+}
+
+// recordLookup reports a lookup to the NameCache's metrics.Collector, if it
+// has one.
+func (nc *NameCache) recordLookup(lookup string, result lookupResult) {
+	if nc.metrics == nil {
+		return
+	}
+	nc.metrics.AddCounter(MetricNameCacheLookups, metrics.Labels{"lookup": lookup, "result": string(result)}, 1)
+	hits := atomic.LoadInt64(&nc.stats.hits)
+	if result == lookupHit {
+		hits = atomic.AddInt64(&nc.stats.hits, 1)
+	}
+	lookups := atomic.AddInt64(&nc.stats.lookups, 1)
+	nc.metrics.SetGauge(MetricNameCacheHitRatio, nil, float64(hits)/float64(lookups))
+}
+
+// timeRegistry starts timing a query of the registry client, and returns
+// the func to call once it has returned.
+func (nc *NameCache) timeRegistry(op string) func() {
+	start := nc.clock.Now()
+	return func() {
+		observeSince(metrics.Or(nc.metrics), nc.clock, MetricRegistryDuration, metrics.Labels{"op": op}, start)
+	}
+}
+
+// metrics returns the rectifier's metrics.Collector, which discards
+// everything unless RectifyOpts.Metrics is set.
+func (r *rectifier) metrics() metrics.Collector {
+	return metrics.Or(r.Metrics)
+}
+
+// measureRectify forwards errs, counting them by kind, and records the
+// duration of the rectification, as clock tells it, once errs closes.
+func measureRectify(c metrics.Collector, clock Clock, errs chan RectificationError) chan RectificationError {
+	start := clock.Now()
+	measured := make(chan RectificationError)
+	go func() {
+		for err := range errs {
+			c.AddCounter(MetricRectifyErrors, metrics.Labels{"type": err.Kind().String()}, 1)
+			measured <- err
+		}
+		observeSince(c, clock, MetricRectifyDuration, nil, start)
+		close(measured)
+	}()
+	return measured
+}
+
+// observeSince records the time clock says has passed since start, in
+// seconds, in the histogram name. It's metrics.ObserveSince, for a Clock.
+func observeSince(c metrics.Collector, clock Clock, name string, labels metrics.Labels, start time.Time) {
+	c.Observe(name, labels, clock.Now().Sub(start).Seconds())
+}
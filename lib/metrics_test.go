@@ -0,0 +1,216 @@
+package sous
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opentable/go-singularity"
+	"github.com/opentable/sous/lib/clocktest"
+	"github.com/opentable/sous/util/docker_registry/registrytest"
+	"github.com/opentable/sous/util/metrics"
+	"github.com/samsalisbury/semv"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingCollector is a metrics.Collector that remembers everything it's
+// told.
+type recordingCollector struct {
+	sync.Mutex
+	counters     map[string]float64
+	gauges       map[string]float64
+	observations map[string][]float64
+}
+
+func newRecordingCollector() *recordingCollector {
+	return &recordingCollector{
+		counters:     map[string]float64{},
+		gauges:       map[string]float64{},
+		observations: map[string][]float64{},
+	}
+}
+
+// series names a series like name{a=b,c=d}, with its labels in order.
+func series(name string, labels metrics.Labels) string {
+	pairs := []string{}
+	for n, v := range labels {
+		pairs = append(pairs, n+"="+v)
+	}
+	if len(pairs) == 0 {
+		return name
+	}
+	sort.Strings(pairs)
+	return fmt.Sprintf("%s%v", name, pairs)
+}
+
+func (rc *recordingCollector) AddCounter(name string, labels metrics.Labels, delta float64) {
+	rc.Lock()
+	defer rc.Unlock()
+	rc.counters[series(name, labels)] += delta
+}
+
+func (rc *recordingCollector) SetGauge(name string, labels metrics.Labels, v float64) {
+	rc.Lock()
+	defer rc.Unlock()
+	rc.gauges[series(name, labels)] = v
+}
+
+func (rc *recordingCollector) Observe(name string, labels metrics.Labels, v float64) {
+	rc.Lock()
+	defer rc.Unlock()
+	s := series(name, labels)
+	rc.observations[s] = append(rc.observations[s], v)
+}
+
+func TestRectifyMetrics(t *testing.T) {
+	assert := assert.New(t)
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	client.FailWith("DeleteRequest", &singularity.ReqError{Status: http.StatusServiceUnavailable})
+	rc := newRecordingCollector()
+
+	chanset := NewDiffChans(2)
+	errs := RectifyWith(chanset, client, RectifyOpts{Metrics: rc})
+	chanset.Created <- &Deployment{
+		SourceVersion: SourceVersion{RepoURL: RepoURL("one"), Version: semv.MustParse("1.0.0")},
+		DeployConfig:  DeployConfig{NumInstances: 1},
+		Cluster:       "cluster",
+	}
+	chanset.Deleted <- &Deployment{SourceVersion: SourceVersion{RepoURL: RepoURL("two")}, Cluster: "cluster"}
+	chanset.Close()
+	n := 0
+	for range errs {
+		n++
+	}
+	assert.Equal(1, n)
+
+	assert.Equal(map[string]float64{
+		"sous_rectify_actions_total[kind=started]":        2,
+		"sous_rectify_actions_total[kind=request posted]": 1,
+		"sous_rectify_actions_total[kind=deployed]":       1,
+		"sous_rectify_errors_total[type=transient]":       1,
+	}, rc.counters)
+	assert.Len(rc.observations["sous_rectify_duration_seconds"], 1)
+	for _, op := range []string{"InspectRequest", "ImageName", "PostRequest", "Deploy", "DeleteRequest"} {
+		assert.Len(rc.observations["sous_rectify_call_duration_seconds[op="+op+"]"], 1, op)
+	}
+}
+
+func TestRectifyMetricsUseClock(t *testing.T) {
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	rc := newRecordingCollector()
+	clock := clocktest.NewClock(time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	chanset := NewDiffChans(1)
+	errs := RectifyWith(chanset, client, RectifyOpts{Metrics: rc, Clock: clock})
+	chanset.Created <- &Deployment{SourceVersion: SourceVersion{RepoURL: "one"}, Cluster: "cluster"}
+	chanset.Close()
+	for e := range errs {
+		t.Error(e)
+	}
+
+	// The clock stands still, so everything takes no time at all.
+	assert.Equal(t, []float64{0}, rc.observations["sous_rectify_duration_seconds"])
+	assert.Equal(t, []float64{0}, rc.observations["sous_rectify_call_duration_seconds[op=Deploy]"])
+}
+
+func TestPerClusterRectifyMetrics(t *testing.T) {
+	assert := assert.New(t)
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	rc := newRecordingCollector()
+
+	chanset := NewDiffChans(2)
+	errs := RectifyWith(chanset, client, RectifyOpts{Metrics: rc, PerCluster: true})
+	chanset.Created <- &Deployment{SourceVersion: SourceVersion{RepoURL: "one"}, Cluster: "a"}
+	chanset.Created <- &Deployment{SourceVersion: SourceVersion{RepoURL: "two"}, Cluster: "b"}
+	chanset.Close()
+	for e := range errs {
+		t.Error(e)
+	}
+
+	assert.Equal(2.0, rc.counters["sous_rectify_actions_total[kind=deployed]"])
+	assert.Len(rc.observations["sous_rectify_duration_seconds"], 1,
+		"the rectification should be timed once, not once per cluster")
+}
+
+func TestNameCacheMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	dc := registrytest.NewFake()
+	rc := newRecordingCollector()
+	nc := NewNameCacheWithOptions(dc, []string{"sqlite3", InMemoryConnection("metrics")}, NameCacheMetrics(rc))
+	sv := SourceVersion{
+		Version: semv.MustParse("1.2.3"),
+		RepoURL: RepoURL("github.com/opentable/wackadoo"),
+	}
+	in := "docker.repo.io/ot/wackadoo:1.2.3"
+	if _, err := dc.Add(in, sv.DockerLabels()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first lookup misses, the second is answered by the etag cached
+	if _, err := nc.GetSourceVersion(in); !assert.NoError(err) {
+		return
+	}
+	if _, err := nc.GetSourceVersion(in); !assert.NoError(err) {
+		return
+	}
+	if _, err := nc.GetImageName(sv); !assert.NoError(err) {
+		return
+	}
+	_, err := nc.GetSourceVersion("docker.repo.io/ot/wackadoo:9.9.9")
+	assert.IsType(NoSourceVersionFound{}, err)
+
+	assert.Equal(map[string]float64{
+		"sous_name_cache_lookups_total[lookup=source version result=miss]": 2,
+		"sous_name_cache_lookups_total[lookup=source version result=hit]":  1,
+		"sous_name_cache_lookups_total[lookup=image name result=hit]":      1,
+	}, rc.counters)
+	assert.Equal(0.5, rc.gauges["sous_name_cache_hit_ratio"])
+	assert.Len(rc.observations["sous_registry_request_duration_seconds[op=GetImageMetadata]"], 3)
+}
+
+func TestNameCacheMetricsUseClock(t *testing.T) {
+	dc := registrytest.NewFake()
+	rc := newRecordingCollector()
+	clock := clocktest.NewClock(time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC))
+	nc := NewNameCacheWithOptions(dc, []string{"sqlite3", InMemoryConnection("metricsclock")},
+		NameCacheMetrics(rc), NameCacheClock(clock))
+	sv := SourceVersion{
+		Version: semv.MustParse("1.2.3"),
+		RepoURL: RepoURL("github.com/opentable/wackadoo"),
+	}
+	in := "docker.repo.io/ot/wackadoo:1.2.3"
+	if _, err := dc.Add(in, sv.DockerLabels()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := nc.GetSourceVersion(in); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []float64{0}, rc.observations["sous_registry_request_duration_seconds[op=GetImageMetadata]"])
+}
+
+func TestNameCacheMetricsTimeHarvests(t *testing.T) {
+	dc := registrytest.NewFake()
+	rc := newRecordingCollector()
+	nc := NewNameCacheWithOptions(dc, []string{"sqlite3", InMemoryConnection("metricsharvest")}, NameCacheMetrics(rc))
+	sv := SourceVersion{
+		Version: semv.MustParse("1.2.3"),
+		RepoURL: RepoURL("github.com/opentable/wackadoo"),
+	}
+	in := "docker.repo.io/ot/wackadoo:1.2.3"
+	if _, err := dc.Add(in, sv.DockerLabels()); err != nil {
+		t.Fatal(err)
+	}
+	if err := nc.Insert(sv, in, "etag"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := nc.Warm(sv.CanonicalName()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, rc.observations["sous_registry_request_duration_seconds[op=AllTags]"], 1)
+}
@@ -0,0 +1,232 @@
+package sous
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/samsalisbury/semv"
+)
+
+// NameCacheEntry is one image recorded in a NameCache
+type NameCacheEntry struct {
+	SourceVersion SourceVersion
+	CanonicalName string
+	// Names are all the names known for the image, including CanonicalName
+	Names []string
+	Etag  string
+	// CachedAt is when the image was last looked up in its registry
+	CachedAt time.Time
+	// Refreshes counts the times the image has been looked up in its
+	// registry since it was first cached, and LastFetch says how it was last
+	// fetched.
+	Refreshes int
+	LastFetch NameCacheFetch
+}
+
+// Entries returns the images in the cache built from repo, or every image if
+// repo is empty, ordered by source version.
+func (nc *NameCache) Entries(repo RepoURL) ([]NameCacheEntry, error) {
+	q := nc.sqlTrace("Entries", nc.log.With("repo", repo))
+	defer q.summarize("Listed cached images")
+
+	rows, err := q.query("select "+
+		"docker_search_metadata.metadata_id, "+
+		"docker_search_location.repo, "+
+		"docker_search_location.offset, "+
+		"docker_search_metadata.version, "+
+		"docker_search_metadata.revision, "+
+		"docker_search_metadata.canonicalName, "+
+		"docker_search_metadata.etag, "+
+		"docker_search_metadata.cached_at, "+
+		"docker_search_metadata.refresh_count, "+
+		"docker_search_metadata.last_fetch, "+
+		"docker_search_name.name "+
+		"from "+
+		"docker_search_name natural join docker_search_metadata "+
+		"natural join docker_search_location "+
+		"where $1 = '' or docker_search_location.repo = $1",
+		string(repo))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byID := map[int64]*NameCacheEntry{}
+	for rows.Next() {
+		var id, cachedAt int64
+		var refreshes int
+		var r, offset, version, revision, cn, etag, lastFetch, name string
+		if err := rows.Scan(&id, &r, &offset, &version, &revision, &cn, &etag, &cachedAt, &refreshes, &lastFetch, &name); err != nil {
+			return nil, q.wrap(rows.query, rows.args, err)
+		}
+		e, ok := byID[id]
+		if !ok {
+			sv, err := makeSourceVersion(r, offset, version, revision)
+			if err != nil {
+				return nil, err
+			}
+			e = &NameCacheEntry{
+				SourceVersion: sv,
+				CanonicalName: cn,
+				Etag:          etag,
+				CachedAt:      time.Unix(cachedAt, 0),
+				Refreshes:     refreshes,
+				LastFetch:     NameCacheFetch(lastFetch),
+			}
+			byID[id] = e
+		}
+		e.Names = append(e.Names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	es := make([]NameCacheEntry, 0, len(byID))
+	for _, e := range byID {
+		sort.Strings(e.Names)
+		es = append(es, *e)
+	}
+	sort.Slice(es, func(i, j int) bool {
+		return es[i].SourceVersion.String() < es[j].SourceVersion.String()
+	})
+	return es, nil
+}
+
+// Prune removes the images that were cached before cutoff, and returns
+// them. If verify is true, each of those images is looked up in its
+// registry first, and those that are still there are refreshed instead of
+// being removed. Other writers to the cache wait for it to finish: see
+// WithExclusiveWrite.
+func (nc *NameCache) Prune(cutoff time.Time, verify bool) ([]NameCacheEntry, error) {
+	if verify && nc.offline {
+		return nil, OfflineError{Op: "verify pruned images"}
+	}
+	var removed []NameCacheEntry
+	err := nc.WithExclusiveWrite(func() error {
+		var err error
+		removed, err = nc.prune(cutoff, verify)
+		return err
+	})
+	return removed, err
+}
+
+func (nc *NameCache) prune(cutoff time.Time, verify bool) ([]NameCacheEntry, error) {
+	es, err := nc.Entries("")
+	if err != nil {
+		return nil, err
+	}
+	q := nc.sqlTrace("Prune", nc.log)
+	defer q.summarize("Pruned cached images")
+	removed := []NameCacheEntry{}
+	for _, e := range es {
+		if !e.CachedAt.Before(cutoff) {
+			continue
+		}
+		if verify {
+			done := nc.timeRegistry("GetImageMetadata")
+			_, err := nc.registryClient.GetImageMetadata(e.CanonicalName, e.Etag)
+			done()
+			if isNotModified(err) || err == nil {
+				if err := nc.dbTouch(q, e.CanonicalName); err != nil {
+					return removed, err
+				}
+				continue
+			}
+		}
+		if _, err := nc.dbDelete(q, "canonicalName = $1", e.CanonicalName); err != nil {
+			return removed, err
+		}
+		removed = append(removed, e)
+	}
+	return removed, nil
+}
+
+// Invalidate removes the image built from sv from the cache, so that it will
+// be looked up in the registry again when next needed. It returns the number
+// of images removed.
+func (nc *NameCache) Invalidate(sv SourceVersion) (int64, error) {
+	return nc.delete(nc.sqlTrace("Invalidate", nc.log.With("source", sv)), "version = $1 and location_id in ("+
+		"select location_id from docker_search_location "+
+		"where repo = $2 and offset = $3)",
+		sv.Version.Format(semv.MMPPre), string(sv.RepoURL), string(sv.RepoOffset))
+}
+
+// InvalidateImage is like Invalidate, but removes the image known by the
+// name in.
+func (nc *NameCache) InvalidateImage(in string) (int64, error) {
+	return nc.delete(nc.sqlTrace("InvalidateImage", nc.log.With("image", in)), "metadata_id in ("+
+		"select metadata_id from docker_search_name where name = $1)", in)
+}
+
+// delete is dbDelete as an ordinary write.
+func (nc *NameCache) delete(q *sqlTrace, where string, args ...interface{}) (int64, error) {
+	var n int64
+	err := nc.withWrite(func() error {
+		var err error
+		n, err = nc.dbDelete(q, where, args...)
+		return err
+	})
+	return n, err
+}
+
+// dbDelete deletes the metadata rows matching where, and with them, all
+// their names.
+func (nc *NameCache) dbDelete(q *sqlTrace, where string, args ...interface{}) (int64, error) {
+	res, err := q.exec("delete from docker_search_metadata where "+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (nc *NameCache) dbTouch(q *sqlTrace, cn string) error {
+	_, err := q.exec("update docker_search_metadata set cached_at = $1 "+
+		"where canonicalName = $2", nc.clock.Now().Unix(), cn)
+	return err
+}
+
+// addCachedAt adds the cached_at column to a docker_search_metadata table
+// created before it existed. Images already cached are treated as if they
+// were cached at now.
+func addCachedAt(db *sql.DB, now time.Time) error {
+	has, err := hasColumn(db, "docker_search_metadata", "cached_at")
+	if err != nil || has {
+		return err
+	}
+
+	if err := sqlExec(db, "alter table docker_search_metadata "+
+		"add column cached_at integer not null default 0;"); err != nil {
+		return err
+	}
+	_, err = db.Exec("update docker_search_metadata set cached_at = $1;", now.Unix())
+	return err
+}
+
+// hasColumn reports whether table has the column col.
+func hasColumn(db *sql.DB, table, col string) (bool, error) {
+	rows, err := db.Query("pragma table_info(" + table + ");")
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return false, err
+	}
+	for rows.Next() {
+		vals := make([]interface{}, len(cols))
+		var name string
+		for i := range vals {
+			vals[i] = new(interface{})
+		}
+		vals[1] = &name
+		if err := rows.Scan(vals...); err != nil {
+			return false, err
+		}
+		if name == col {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
@@ -0,0 +1,142 @@
+package sous
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opentable/sous/lib/clocktest"
+	"github.com/opentable/sous/util/docker_registry/registrytest"
+	"github.com/samsalisbury/semv"
+	"github.com/stretchr/testify/assert"
+)
+
+func entriesTestCache(t *testing.T, db string) (*NameCache, *registrytest.Fake, []SourceVersion) {
+	dc := registrytest.NewFake()
+	nc := NewNameCache(dc, "sqlite3", InMemoryConnection(db))
+	svs := []SourceVersion{
+		{RepoURL: "github.com/opentable/one", Version: semv.MustParse("1.0.0")},
+		{RepoURL: "github.com/opentable/one", Version: semv.MustParse("1.1.0")},
+		{RepoURL: "github.com/opentable/two", Version: semv.MustParse("2.0.0")},
+	}
+	for _, sv := range svs {
+		in := "docker.example.com/" + path.Base(string(sv.RepoURL)) + ":" + sv.Version.String()
+		if _, err := dc.Add(in, sv.DockerLabels()); err != nil {
+			t.Fatal(err)
+		}
+		if err := nc.Insert(sv, in, "etag"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return nc, dc, svs
+}
+
+func TestNameCacheEntries(t *testing.T) {
+	assert := assert.New(t)
+	nc, _, svs := entriesTestCache(t, "entries")
+
+	es, err := nc.Entries("")
+	if assert.NoError(err) && assert.Len(es, 3) {
+		assert.True(es[0].SourceVersion.Equal(svs[0]))
+		assert.Equal("docker.example.com/one:1.0.0", es[0].CanonicalName)
+		assert.Equal([]string{"docker.example.com/one:1.0.0"}, es[0].Names)
+		assert.WithinDuration(time.Now(), es[0].CachedAt, time.Minute)
+	}
+
+	es, err = nc.Entries("github.com/opentable/two")
+	if assert.NoError(err) && assert.Len(es, 1) {
+		assert.True(es[0].SourceVersion.Equal(svs[2]))
+	}
+}
+
+func TestNameCacheInvalidate(t *testing.T) {
+	assert := assert.New(t)
+	nc, _, svs := entriesTestCache(t, "invalidate")
+
+	n, err := nc.Invalidate(svs[1])
+	assert.NoError(err)
+	assert.Equal(int64(1), n)
+	n, err = nc.InvalidateImage("docker.example.com/two:2.0.0")
+	assert.NoError(err)
+	assert.Equal(int64(1), n)
+	n, err = nc.InvalidateImage("docker.example.com/two:2.0.0")
+	assert.NoError(err)
+	assert.Equal(int64(0), n)
+
+	es, err := nc.Entries("")
+	if assert.NoError(err) && assert.Len(es, 1) {
+		assert.True(es[0].SourceVersion.Equal(svs[0]))
+	}
+}
+
+func TestNameCachePrune(t *testing.T) {
+	assert := assert.New(t)
+	nc, dc, _ := entriesTestCache(t, "prune")
+
+	removed, err := nc.Prune(time.Now().Add(-time.Hour), false)
+	assert.NoError(err)
+	assert.Len(removed, 0)
+
+	// Only one image is still in the registry.
+	dc.Delete("docker.example.com/one:1.1.0")
+	dc.Delete("docker.example.com/two:2.0.0")
+	removed, err = nc.Prune(time.Now().Add(time.Hour), true)
+	assert.NoError(err)
+	assert.Len(removed, 2)
+
+	removed, err = nc.Prune(time.Now().Add(time.Hour), false)
+	assert.NoError(err)
+	assert.Len(removed, 1)
+}
+
+func TestNameCacheAddsCachedAt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sous-namecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "old.db")
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("create table docker_search_metadata(" +
+		"metadata_id integer primary key autoincrement, " +
+		"location_id not null, " +
+		"etag text not null, " +
+		"canonicalName text not null, " +
+		"version text not null);"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("insert into docker_search_metadata" +
+		"(location_id, etag, canonicalName, version) " +
+		"values (1, 'old', 'docker.example.com/old@sha256:0', '0.1.0');"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	migrated := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	nc := NewNameCacheWithOptions(registrytest.NewFake(), []string{"sqlite3", path},
+		NameCacheClock(clocktest.NewClock(migrated)))
+	var cachedAt int64
+	if err := nc.db.QueryRow("select cached_at from docker_search_metadata " +
+		"where etag = 'old';").Scan(&cachedAt); err != nil {
+		t.Fatal(err)
+	}
+	if cachedAt != migrated.Unix() {
+		t.Errorf("image cached before the migration has cached_at %d; want %d", cachedAt, migrated.Unix())
+	}
+	sv := SourceVersion{RepoURL: "github.com/opentable/one", Version: semv.MustParse("1.0.0")}
+	if err := nc.Insert(sv, "docker.example.com/one:1.0.0", "etag"); err != nil {
+		t.Fatal(err)
+	}
+	es, err := nc.Entries("")
+	if err != nil || len(es) != 1 || es[0].CachedAt.Unix() == 0 {
+		t.Errorf("got %v, %v; want one entry with a cached_at time", es, err)
+	}
+}
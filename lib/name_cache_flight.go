@@ -0,0 +1,95 @@
+package sous
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+
+	"github.com/docker/distribution/reference"
+)
+
+type (
+	// flightGroup makes one call at a time for each key: callers asking for
+	// a key while a call for it is in flight wait for that call and share
+	// its result, rather than repeating its work. Once the call returns,
+	// the next caller makes a fresh one. The zero flightGroup is ready to
+	// use.
+	flightGroup struct {
+		sync.Mutex
+		calls map[string]*flight
+	}
+
+	// flight is a call made by a flightGroup.
+	flight struct {
+		// done is closed once the call has returned, and val and err set.
+		done chan struct{}
+		val  interface{}
+		err  error
+	}
+
+	// flightPanic is the error of a call made by a flightGroup that
+	// panicked, so that the callers waiting for it aren't left waiting.
+	flightPanic struct {
+		key   string
+		value interface{}
+		stack []byte
+	}
+)
+
+func (p flightPanic) Error() string {
+	return fmt.Sprintf("call for %s panicked: %v\n%s", p.key, p.value, p.stack)
+}
+
+// do returns the result of calling f for key, or of the call for key
+// already in flight. If f panics, the callers that waited for it get a nil
+// value and a flightPanic, and the caller that made the call panics with the
+// flightPanic, once the others are released. Callers should check the type
+// of the value, which is nil whenever f's was.
+func (g *flightGroup) do(key string, f func() (interface{}, error)) (interface{}, error) {
+	g.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*flight{}
+	}
+	if c, ok := g.calls[key]; ok {
+		g.Unlock()
+		<-c.done
+		return c.val, c.err
+	}
+	c := &flight{done: make(chan struct{})}
+	g.calls[key] = c
+	g.Unlock()
+
+	defer func() {
+		g.Lock()
+		delete(g.calls, key)
+		g.Unlock()
+		close(c.done)
+	}()
+	if !c.call(key, f) {
+		panic(c.err)
+	}
+	return c.val, c.err
+}
+
+// call sets c.val and c.err to the result of f, or to a flightPanic if f
+// panics, in which case it returns false.
+func (c *flight) call(key string, f func() (interface{}, error)) (ok bool) {
+	defer func() {
+		if p := recover(); p != nil {
+			c.val, c.err = nil, flightPanic{key: key, value: p, stack: debug.Stack()}
+		}
+	}()
+	c.val, c.err = f()
+	return true
+}
+
+// imageKey is the key of lookups of the image named in: its name as
+// reference parses it, without surrounding space, or else in as given.
+func imageKey(in string) string {
+	in = strings.TrimSpace(in)
+	if ref, err := reference.ParseNamed(in); err == nil {
+		return ref.String()
+	}
+	return in
+}
@@ -0,0 +1,159 @@
+package sous
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opentable/sous/util/docker_registry/registrytest"
+	"github.com/samsalisbury/semv"
+	"github.com/stretchr/testify/assert"
+)
+
+// concurrently calls f n times at once, and waits for them all to return.
+func concurrently(n int, f func(i int)) {
+	start := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			f(i)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+}
+
+func TestGetSourceVersionSharesConcurrentLookups(t *testing.T) {
+	assert := assert.New(t)
+	dc := registrytest.NewFake()
+	dc.SetLatency(registrytest.GetImageMetadata, 100*time.Millisecond)
+	nc := NewNameCache(dc, "sqlite3", InMemoryConnection("flightlookups"))
+	sv := SourceVersion{RepoURL: "github.com/opentable/wackadoo", Version: semv.MustParse("1.2.3")}
+	in := "docker.repo.io/ot/wackadoo:1.2.3"
+	if _, err := dc.Add(in, sv.DockerLabels()); err != nil {
+		t.Fatal(err)
+	}
+
+	svs := make([]SourceVersion, 100)
+	errs := make([]error, 100)
+	concurrently(100, func(i int) {
+		svs[i], errs[i] = nc.GetSourceVersion(in)
+	})
+
+	assert.Equal(1, dc.Calls(registrytest.GetImageMetadata))
+	for i := range svs {
+		assert.NoError(errs[i])
+		assert.True(sv.Equal(svs[i]), "got %v; want %v", svs[i], sv)
+	}
+	_, err := nc.GetImageName(sv)
+	assert.NoError(err)
+}
+
+func TestGetSourceVersionSharesConcurrentErrors(t *testing.T) {
+	assert := assert.New(t)
+	dc := registrytest.NewFake()
+	dc.SetLatency(registrytest.GetImageMetadata, 100*time.Millisecond)
+	boom := errors.New("boom")
+	dc.FailNext(registrytest.GetImageMetadata, boom)
+	nc := NewNameCache(dc, "sqlite3", InMemoryConnection("flighterrors"))
+	in := "docker.repo.io/ot/wackadoo:1.2.3"
+
+	errs := make([]error, 20)
+	concurrently(20, func(i int) {
+		_, errs[i] = nc.GetSourceVersion(in)
+	})
+
+	assert.Equal(1, dc.Calls(registrytest.GetImageMetadata))
+	for _, err := range errs {
+		assert.Equal(RegistryUnavailable{Repo: "docker.repo.io/ot/wackadoo", Err: boom}, err)
+	}
+
+	// the failure isn't remembered
+	_, err := nc.GetSourceVersion(in)
+	assert.IsType(NoSourceVersionFound{}, err)
+	assert.Equal(2, dc.Calls(registrytest.GetImageMetadata))
+}
+
+func TestWarmSharesConcurrentWarms(t *testing.T) {
+	assert := assert.New(t)
+	dc := registrytest.NewFake()
+	dc.SetLatency(registrytest.AllTags, 100*time.Millisecond)
+	nc := NewNameCache(dc, "sqlite3", InMemoryConnection("flightwarms"))
+	sl := monoRepo(t, dc, nc, 1, "1.0.0", "1.1.0")[0]
+
+	cached := make([]int, 20)
+	concurrently(20, func(i int) {
+		var err error
+		// each with a harvest of its own, as NameCache.Warm has
+		cached[i], err = nc.Warm(sl)
+		assert.NoError(err)
+	})
+
+	assert.Equal(1, dc.Calls(registrytest.AllTags))
+	for _, n := range cached {
+		assert.Equal(2, n)
+	}
+}
+
+func TestFlightGroupRecoversPanics(t *testing.T) {
+	assert := assert.New(t)
+	g := &flightGroup{}
+	release := make(chan struct{})
+	vals := make([]interface{}, 10)
+	errs := make([]error, 10)
+	panics := make([]interface{}, 10)
+	concurrently(10, func(i int) {
+		if i == 0 {
+			go func() {
+				// let the others join the call before it panics
+				time.Sleep(50 * time.Millisecond)
+				close(release)
+			}()
+		}
+		defer func() { panics[i] = recover() }()
+		vals[i], errs[i] = g.do("key", func() (interface{}, error) {
+			<-release
+			panic("boom")
+		})
+	})
+	leaders := 0
+	for i := range errs {
+		if panics[i] != nil {
+			// the caller that made the call panics too, as f did
+			leaders++
+			errs[i] = panics[i].(error)
+		}
+		assert.Nil(vals[i])
+		if assert.IsType(flightPanic{}, errs[i]) {
+			assert.Contains(errs[i].Error(), "call for key panicked: boom")
+		}
+	}
+	assert.Equal(1, leaders)
+
+	v, err := g.do("key", func() (interface{}, error) { return 1, nil })
+	assert.NoError(err)
+	assert.Equal(1, v, "a panic shouldn't leave the key in flight")
+}
+
+func TestFlightGroupLeaderPanics(t *testing.T) {
+	g := &flightGroup{}
+	defer func() {
+		r := recover()
+		p, ok := r.(flightPanic)
+		if !ok {
+			t.Fatalf("panicked with %#v; want a flightPanic", r)
+		}
+		assert.Equal(t, "boom", p.value)
+	}()
+	g.do("key", func() (interface{}, error) { panic("boom") })
+	t.Error("the caller that made the call didn't panic")
+}
+
+func TestImageKey(t *testing.T) {
+	assert.Equal(t, "docker.repo.io/ot/wackadoo:1.2.3", imageKey(" docker.repo.io/ot/wackadoo:1.2.3\n"))
+	assert.Equal(t, "Not A Name", imageKey("Not A Name"))
+}
@@ -0,0 +1,142 @@
+package sous
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/docker/distribution/reference"
+)
+
+type (
+	// A Harvest warms a NameCache with the tags of many source locations
+	// in one run, as sous harvest does. Source locations often share docker
+	// repos, e.g. the offsets of a mono-repo, so each repo is only fetched
+	// once per Harvest, however many locations it's known for, and however
+	// many of them are warmed at once: the tags of the repo are all cached
+	// in that one pass, and each location is credited with its own. A
+	// Harvest is safe to use from several goroutines, and is meant to be
+	// thrown away at the end of the run, so that the next one fetches
+	// afresh.
+	Harvest struct {
+		nc *NameCache
+		sync.Mutex
+		repos map[string]*harvestedRepo
+	}
+
+	// harvestedRepo is the outcome of fetching a docker repo for a
+	// Harvest.
+	harvestedRepo struct {
+		// done is closed once the fetch has finished, and the rest set.
+		done chan struct{}
+		// cached counts the tags cached by the location they're of.
+		cached map[SourceLocation]int
+		err    error
+	}
+)
+
+// NewHarvest starts a Harvest into nc.
+func (nc *NameCache) NewHarvest() *Harvest {
+	return &Harvest{nc: nc, repos: map[string]*harvestedRepo{}}
+}
+
+// Warm pulls every tag of the docker repos known for sl into the cache, so
+// that later lookups of its source versions needn't query the registry. It
+// returns the number of tags of sl cached. If any registry couldn't be
+// queried, the remaining repos are still warmed and a RegistryUnavailable is
+// returned, unless the registry is rate limiting us, in which case warming
+// stops there rather than making things worse.
+func (nc *NameCache) Warm(sl SourceLocation) (int, error) {
+	return nc.NewHarvest().Warm(sl)
+}
+
+// Warm is like NameCache.Warm, but a repo already fetched by h, or being
+// fetched, isn't fetched again: the tags of sl cached by that fetch are
+// counted instead. Concurrent warms of sl into the same NameCache, by any
+// Harvest, share one warm, and its result.
+func (h *Harvest) Warm(sl SourceLocation) (int, error) {
+	n, err := h.nc.warms.do(sl.String(), func() (interface{}, error) {
+		return h.warm(sl)
+	})
+	cached, ok := n.(int)
+	if !ok && err == nil {
+		err = fmt.Errorf("warm of %s returned %T", sl, n)
+	}
+	return cached, err
+}
+
+func (h *Harvest) warm(sl SourceLocation) (int, error) {
+	nc := h.nc
+	if nc.offline {
+		return 0, OfflineError{Op: fmt.Sprintf("harvest %s", sl)}
+	}
+	repos, err := nc.dbQueryOnSL(nc.sqlTrace("Warm", nc.log.With("source", sl)), sl)
+	if err != nil {
+		return 0, err
+	}
+	cached := 0
+	var unavailable error
+	for _, r := range repos {
+		hr := h.repo(r)
+		cached += hr.cached[sl]
+		if isRateLimited(hr.err) {
+			return cached, hr.err
+		}
+		if _, ok := hr.err.(RegistryUnavailable); ok {
+			unavailable = hr.err
+		} else if hr.err != nil {
+			return cached, hr.err
+		}
+	}
+	return cached, unavailable
+}
+
+// repo returns the outcome of fetching the docker repo r, fetching it if
+// no call has yet, or waiting for the call that is.
+func (h *Harvest) repo(r string) *harvestedRepo {
+	h.Lock()
+	hr, ok := h.repos[r]
+	if !ok {
+		hr = &harvestedRepo{done: make(chan struct{}), cached: map[SourceLocation]int{}}
+		h.repos[r] = hr
+	}
+	h.Unlock()
+	if ok {
+		<-hr.done
+		return hr
+	}
+	hr.err = h.nc.warmRepo(r, hr.cached)
+	close(hr.done)
+	return hr
+}
+
+// warmRepo pulls every tag of the docker repo r into the cache, counting
+// each in cached by the source location it's of. A registry that can't be
+// queried is reported as RegistryUnavailable; if it's rate limiting us,
+// warming stops there.
+func (nc *NameCache) warmRepo(r string, cached map[SourceLocation]int) error {
+	ref, err := reference.ParseNamed(r)
+	if err != nil {
+		return fmt.Errorf("%v for %v", err, r)
+	}
+	done := nc.timeRegistry("AllTags")
+	ts, err := nc.registryClient.AllTags(r)
+	done()
+	if err != nil {
+		return RegistryUnavailable{Repo: r, Err: err}
+	}
+	for _, t := range ts {
+		in, err := reference.WithTag(ref, t)
+		if err != nil {
+			continue
+		}
+		//pull it into the cache...
+		sv, err := nc.GetSourceVersion(in.String())
+		if err == nil {
+			cached[sv.CanonicalName()]++
+		}
+		if isRateLimited(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package sous
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opentable/sous/util/docker_registry/registrytest"
+	"github.com/samsalisbury/semv"
+	"github.com/stretchr/testify/assert"
+)
+
+// monoRepo sets up a mono-repo with offsets, all of whose images are pushed
+// to the one docker repo, with versions of each, the first of which nc has
+// cached. It returns the source locations of the offsets.
+func monoRepo(t *testing.T, dc *registrytest.Fake, nc *NameCache, offsets int, versions ...string) []SourceLocation {
+	sls := []SourceLocation{}
+	for i := 0; i < offsets; i++ {
+		sl := SourceLocation{RepoURL: "github.com/opentable/mono", RepoOffset: RepoOffset(fmt.Sprintf("svc%d", i))}
+		for j, v := range versions {
+			sv := SourceVersion{RepoURL: sl.RepoURL, RepoOffset: sl.RepoOffset, Version: semv.MustParse(v)}
+			in := fmt.Sprintf("docker.repo.io/ot/mono:svc%d-%s", i, v)
+			if _, err := dc.Add(in, sv.DockerLabels()); err != nil {
+				t.Fatal(err)
+			}
+			if j == 0 {
+				if err := nc.Insert(sv, in, "etag"); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		sls = append(sls, sl)
+	}
+	return sls
+}
+
+func TestHarvestFetchesSharedReposOnce(t *testing.T) {
+	assert := assert.New(t)
+	dc := registrytest.NewFake()
+	dc.SetLatency(registrytest.AllTags, 20*time.Millisecond)
+	nc := NewNameCache(dc, "sqlite3", InMemoryConnection("harvestshared"))
+	sls := monoRepo(t, dc, nc, 10, "1.0.0", "1.1.0")
+
+	for cycle := 1; cycle <= 2; cycle++ {
+		h := nc.NewHarvest()
+		cached := make([]int, len(sls))
+		wg := &sync.WaitGroup{}
+		wg.Add(len(sls))
+		for i, sl := range sls {
+			go func(i int, sl SourceLocation) {
+				defer wg.Done()
+				n, err := h.Warm(sl)
+				assert.NoError(err)
+				cached[i] = n
+			}(i, sl)
+		}
+		wg.Wait()
+
+		assert.Equal(cycle, dc.Calls(registrytest.AllTags), "the repo should be fetched once per harvest")
+		for i, sl := range sls {
+			assert.Equal(2, cached[i], "tags cached for %s", sl)
+			_, err := nc.GetImageName(SourceVersion{RepoURL: sl.RepoURL, RepoOffset: sl.RepoOffset, Version: semv.MustParse("1.1.0")})
+			assert.NoError(err, "1.1.0 of %s should have been cached", sl)
+		}
+	}
+}
+
+func TestHarvestReportsUnavailableRepoToEachLocation(t *testing.T) {
+	dc := registrytest.NewFake()
+	nc := NewNameCache(dc, "sqlite3", InMemoryConnection("harvestunavailable"))
+	sls := monoRepo(t, dc, nc, 3, "1.0.0")
+
+	dc.FailNext(registrytest.AllTags, fmt.Errorf("connection refused"))
+	h := nc.NewHarvest()
+	for _, sl := range sls {
+		_, err := h.Warm(sl)
+		assert.IsType(t, RegistryUnavailable{}, err, "warming %s", sl)
+	}
+	assert.Equal(t, 1, dc.Calls(registrytest.AllTags))
+}
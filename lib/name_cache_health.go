@@ -0,0 +1,77 @@
+package sous
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/opentable/sous/util/docker_registry"
+	"golang.org/x/net/context"
+)
+
+// ErrClosed is returned by Ping on an ImageMapper that has been closed.
+type ErrClosed struct{}
+
+func (e ErrClosed) Error() string {
+	return "the image mapper is closed"
+}
+
+// NameCacheNoRegistryPing has Ping only check the NameCache's database,
+// and not probe its registry, e.g. for health checks that shouldn't fail
+// just because a registry is down.
+func NameCacheNoRegistryPing() NameCacheOption {
+	return func(nc *NameCache) {
+		nc.noRegistryPing = true
+	}
+}
+
+// Close implements ImageMapper: it closes the NameCache's database. Closing
+// it again does nothing. Other NameCaches sharing the database file are
+// unaffected.
+func (nc *NameCache) Close() error {
+	nc.closeMu.Lock()
+	defer nc.closeMu.Unlock()
+	if nc.closed {
+		return nil
+	}
+	nc.closed = true
+	return nc.db.Close()
+}
+
+// Ping implements ImageMapper: it checks that the database can be reached
+// and, unless the NameCache is offline or built with
+// NameCacheNoRegistryPing, that the registry answers for a docker repo the
+// cache knows. A registry that doesn't is reported as RegistryUnavailable.
+// A cache that knows no repos has no registry to probe.
+func (nc *NameCache) Ping(ctx context.Context) error {
+	nc.closeMu.Lock()
+	closed := nc.closed
+	nc.closeMu.Unlock()
+	if closed {
+		return ErrClosed{}
+	}
+	if err := nc.db.PingContext(ctx); err != nil {
+		return err
+	}
+	if nc.offline || nc.noRegistryPing {
+		return nil
+	}
+
+	q := nc.sqlTrace("Ping", nc.log)
+	defer q.summarize("Pinged")
+	var repo string
+	err := q.queryRowScan([]interface{}{&repo}, "select name from docker_repo_name "+
+		"order by name limit 1")
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	done := nc.timeRegistry("Ping")
+	_, err = nc.registryClient.AllTagsContext(ctx, repo)
+	done()
+	if err != nil && !docker_registry.IsNotFound(err) {
+		return RegistryUnavailable{Repo: repo, Err: err}
+	}
+	return nil
+}
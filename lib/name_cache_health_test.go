@@ -0,0 +1,71 @@
+package sous
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/opentable/sous/util/docker_registry/registrytest"
+	"github.com/samsalisbury/semv"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestNameCachePing(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	dc := registrytest.NewFake()
+	nc := NewNameCache(dc, "sqlite3", InMemoryConnection("ping"))
+	defer nc.Close()
+	assert.NoError(nc.Ping(ctx))
+	assert.Zero(dc.Calls(registrytest.AllTags), "there's no registry to probe without repos")
+
+	sv := SourceVersion{
+		Version: semv.MustParse("1.2.3"),
+		RepoURL: RepoURL("github.com/opentable/wackadoo"),
+	}
+	in := "docker.repo.io/ot/wackadoo:1.2.3"
+	if err := nc.Insert(sv, in, ""); err != nil {
+		t.Fatal(err)
+	}
+	// the registry answers, though it doesn't have the repo
+	assert.NoError(nc.Ping(ctx))
+	assert.Equal(1, dc.Calls(registrytest.AllTags))
+
+	boom := errors.New("boom")
+	dc.FailNext(registrytest.AllTags, boom)
+	assert.Equal(RegistryUnavailable{Repo: "docker.repo.io/ot/wackadoo", Err: boom}, nc.Ping(ctx))
+}
+
+func TestNameCachePingWithoutRegistry(t *testing.T) {
+	assert := assert.New(t)
+
+	for name, opt := range map[string]NameCacheOption{
+		"noregistryping": NameCacheNoRegistryPing(),
+		"offlineping":    NameCacheOffline(),
+	} {
+		dc := registrytest.NewFake()
+		nc := NewNameCacheWithOptions(dc, []string{"sqlite3", InMemoryConnection(name)}, opt)
+		sv := SourceVersion{Version: semv.MustParse("1.2.3"), RepoURL: RepoURL("github.com/opentable/wackadoo")}
+		if err := nc.Insert(sv, "docker.repo.io/ot/wackadoo:1.2.3", ""); err != nil {
+			t.Fatal(err)
+		}
+		dc.FailNext(registrytest.AllTags, errors.New("boom"))
+
+		assert.NoError(nc.Ping(context.Background()), name)
+		assert.Zero(dc.Calls(registrytest.AllTags), name)
+		nc.Close()
+	}
+}
+
+func TestNameCacheClose(t *testing.T) {
+	assert := assert.New(t)
+
+	nc := NewNameCache(registrytest.NewFake(), "sqlite3", InMemoryConnection("close"))
+	ro := nc.ReadOnly()
+	assert.NoError(nc.Close())
+	assert.NoError(nc.Close(), "closing twice should be harmless")
+	assert.NoError(ro.Close())
+	assert.Equal(ErrClosed{}, nc.Ping(context.Background()))
+	assert.Equal(ErrClosed{}, ro.Ping(context.Background()))
+}
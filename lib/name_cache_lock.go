@@ -0,0 +1,104 @@
+package sous
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dbBusyTimeout is how long a statement on a database file waits for
+// another connection, perhaps in another process, to release its lock,
+// rather than failing with "database is locked".
+const dbBusyTimeout = 30 * time.Second
+
+// dbLock coordinates writes to a NameCache's database. A bulk change, made
+// with WithExclusiveWrite, excludes every other write, while ordinary writes
+// only exclude bulk changes. Writers in this process are coordinated by the
+// mutex, and those in other processes sharing the database file by
+// lockFile. A nil dbLock coordinates nothing.
+type dbLock struct {
+	// path is the lock file, or "" if the database is in memory.
+	path string
+	sync.RWMutex
+}
+
+// DatabaseFile returns the path of the file that the sqlite3 connection
+// string conn names, or "" if conn is for an in-memory database, e.g.
+// InMemory.
+func DatabaseFile(conn string) string {
+	if strings.Contains(conn, "mode=memory") {
+		return ""
+	}
+	path := strings.TrimPrefix(conn, "file:")
+	if i := strings.Index(path, "?"); i >= 0 {
+		path = path[:i]
+	}
+	if path == ":memory:" {
+		return ""
+	}
+	return path
+}
+
+// fileConnection returns the connection string conn, for a database file,
+// with the options needed to share it with other processes, unless they're
+// already set.
+func fileConnection(conn string) string {
+	if strings.Contains(conn, "_busy_timeout=") {
+		return conn
+	}
+	sep := "?"
+	if strings.Contains(conn, "?") {
+		sep = "&"
+	}
+	return conn + sep + "_busy_timeout=" + strconv.Itoa(int(dbBusyTimeout/time.Millisecond))
+}
+
+// newDBLock returns the dbLock for the database named by the driver and
+// connection string of dbCfg, as passed to NewNameCache.
+func newDBLock(dbCfg []string) *dbLock {
+	driver, conn := "sqlite3", InMemory
+	if len(dbCfg) >= 1 {
+		driver = dbCfg[0]
+	}
+	if len(dbCfg) >= 2 {
+		conn = dbCfg[1]
+	}
+	l := &dbLock{}
+	if path := DatabaseFile(conn); driver == "sqlite3" && path != "" {
+		l.path = path + ".lock"
+	}
+	return l
+}
+
+// WithExclusiveWrite calls f while no other writes are made to the cache,
+// by this process or any other sharing its database file, and returns its
+// error. Bulk changes, like Prune, use it so that they aren't interleaved
+// with other writers. f mustn't write to the cache through other NameCaches,
+// which would wait for it forever.
+func (nc *NameCache) WithExclusiveWrite(f func() error) error {
+	return nc.writes.with(true, f)
+}
+
+// withWrite calls f as an ordinary write to the cache, which waits for any
+// bulk change in progress to finish.
+func (nc *NameCache) withWrite(f func() error) error {
+	return nc.writes.with(false, f)
+}
+
+func (l *dbLock) with(exclusive bool, f func() error) error {
+	if l == nil {
+		return f()
+	}
+	if exclusive {
+		l.Lock()
+		defer l.Unlock()
+	} else {
+		l.RLock()
+		defer l.RUnlock()
+	}
+	if l.path == "" {
+		return f()
+	}
+	return lockFile(l.path, exclusive, f)
+}
@@ -0,0 +1,30 @@
+// +build linux darwin
+
+package sous
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockFile calls f while holding an advisory lock on the file at path,
+// shared by writers unless exclusive is true, so that writers in other
+// processes are coordinated too.
+func lockFile(path string, exclusive bool, f func() error) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	// Each write opens the lock file afresh, since flock locks belong to
+	// the open file, and so don't exclude others made through it.
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("opening name cache lock: %v", err)
+	}
+	defer file.Close()
+	if err := syscall.Flock(int(file.Fd()), how); err != nil {
+		return fmt.Errorf("locking name cache: %v", err)
+	}
+	return f()
+}
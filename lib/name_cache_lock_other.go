@@ -0,0 +1,33 @@
+// +build !linux,!darwin
+
+package sous
+
+import "sync"
+
+var (
+	fileLocksMu sync.Mutex
+	// fileLocks are the locks of lockFile, by path.
+	fileLocks = map[string]*sync.RWMutex{}
+)
+
+// lockFile calls f while holding a lock on path, shared by writers unless
+// exclusive is true. Without flock, only the NameCaches of this process that
+// share the database file are coordinated: other processes writing to it
+// rely on its busy timeout alone.
+func lockFile(path string, exclusive bool, f func() error) error {
+	fileLocksMu.Lock()
+	l, ok := fileLocks[path]
+	if !ok {
+		l = &sync.RWMutex{}
+		fileLocks[path] = l
+	}
+	fileLocksMu.Unlock()
+	if exclusive {
+		l.Lock()
+		defer l.Unlock()
+	} else {
+		l.RLock()
+		defer l.RUnlock()
+	}
+	return f()
+}
@@ -0,0 +1,114 @@
+package sous
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opentable/sous/util/docker_registry/registrytest"
+	"github.com/samsalisbury/semv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDatabaseFile(t *testing.T) {
+	for conn, want := range map[string]string{
+		"/home/sous/.cache/sous/data.db":       "/home/sous/.cache/sous/data.db",
+		"data.db?_busy_timeout=100":            "data.db",
+		"file:/tmp/cache.db?cache=shared":      "/tmp/cache.db",
+		InMemory:                               "",
+		InMemoryConnection("test"):             "",
+		":memory:":                             "",
+		"file::memory:":                        "",
+		"file:cache.db?mode=memory&cache=shar": "",
+		"":                                     "",
+	} {
+		assert.Equal(t, want, DatabaseFile(conn), "DatabaseFile(%q)", conn)
+	}
+}
+
+func sharedCacheFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "sous-name-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "cache.db"), func() { os.RemoveAll(dir) }
+}
+
+func sharedCacheSV(writer, i int) (SourceVersion, string) {
+	sv := SourceVersion{
+		RepoURL:    "github.com/opentable/shared",
+		RepoOffset: RepoOffset(fmt.Sprintf("w%d", writer)),
+		Version:    semv.MustParse(fmt.Sprintf("1.0.%d", i)),
+	}
+	return sv, fmt.Sprintf("docker.example.com/shared:w%d-%d", writer, i)
+}
+
+func TestNameCacheSharedFile(t *testing.T) {
+	assert := assert.New(t)
+	db, cleanup := sharedCacheFile(t)
+	defer cleanup()
+
+	const writers, inserts = 6, 20
+	wg := &sync.WaitGroup{}
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			// Each writer has a NameCache, and so a sql.DB, of its own, as
+			// separate processes would.
+			nc := NewNameCache(registrytest.NewFake(), "sqlite3", db)
+			for i := 0; i < inserts; i++ {
+				sv, in := sharedCacheSV(w, i)
+				assert.NoError(nc.Insert(sv, in, "etag"))
+			}
+		}(w)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		nc := NewNameCache(registrytest.NewFake(), "sqlite3", db)
+		for i := 0; i < 5; i++ {
+			// Nothing was cached before the epoch, so this only takes
+			// the lock.
+			_, err := nc.Prune(time.Unix(0, 0), false)
+			assert.NoError(err)
+		}
+	}()
+	wg.Wait()
+
+	nc := NewNameCache(registrytest.NewFake(), "sqlite3", db)
+	es, err := nc.Entries("")
+	assert.NoError(err)
+	assert.Len(es, writers*inserts)
+	var mode string
+	assert.NoError(nc.db.QueryRow("pragma journal_mode").Scan(&mode))
+	assert.Equal("wal", mode)
+}
+
+func TestWithExclusiveWriteExcludesWriters(t *testing.T) {
+	assert := assert.New(t)
+	db, cleanup := sharedCacheFile(t)
+	defer cleanup()
+	bulk := NewNameCache(registrytest.NewFake(), "sqlite3", db)
+	other := NewNameCache(registrytest.NewFake(), "sqlite3", db)
+
+	inserted := make(chan error, 1)
+	err := bulk.WithExclusiveWrite(func() error {
+		go func() {
+			sv, in := sharedCacheSV(0, 0)
+			inserted <- other.Insert(sv, in, "etag")
+		}()
+		select {
+		case <-inserted:
+			t.Error("another cache wrote during an exclusive write")
+		case <-time.After(50 * time.Millisecond):
+		}
+		return nil
+	})
+	assert.NoError(err)
+	assert.NoError(<-inserted)
+}
@@ -0,0 +1,26 @@
+package sous
+
+import "fmt"
+
+// OfflineError is returned by a NameCache that is offline instead of
+// querying a docker registry, e.g. to harvest tags.
+type OfflineError struct {
+	// Op is what the registry would have been queried for.
+	Op string
+}
+
+func (e OfflineError) Error() string {
+	return fmt.Sprintf("offline: not querying the registry to %s", e.Op)
+}
+
+// NameCacheOffline has the NameCache never query its registry client, for
+// machines that can't reach any registry. Lookups are answered from the
+// cache alone: an image or source version that isn't cached is reported
+// missing straight away, with NoSourceVersionFound or NoImageNameFound.
+// Warm, and Prune when asked to verify, return an OfflineError. Insert still
+// works, so the cache can be filled from elsewhere.
+func NameCacheOffline() NameCacheOption {
+	return func(nc *NameCache) {
+		nc.offline = true
+	}
+}
@@ -0,0 +1,94 @@
+package sous
+
+import (
+	"testing"
+	"time"
+
+	"github.com/opentable/sous/util/docker_registry/registrytest"
+	"github.com/samsalisbury/semv"
+	"github.com/stretchr/testify/assert"
+)
+
+func registryCalls(dc *registrytest.Fake) int {
+	return dc.Calls(registrytest.GetImageMetadata) + dc.Calls(registrytest.AllTags)
+}
+
+func TestOfflineNameCacheMisses(t *testing.T) {
+	assert := assert.New(t)
+
+	dc := registrytest.NewFake()
+	nc := NewNameCacheWithOptions(dc, []string{"sqlite3", InMemoryConnection("offlinemisses")}, NameCacheOffline())
+	sv := SourceVersion{
+		Version: semv.MustParse("1.2.3"),
+		RepoURL: RepoURL("github.com/opentable/wackadoo"),
+	}
+	in := "docker.repo.io/ot/wackadoo:1.2.3"
+	if _, err := dc.Add(in, sv.DockerLabels()); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := nc.GetSourceVersion(in)
+	assert.Equal(NoSourceVersionFound{imageName(in)}, err)
+	_, err = nc.GetLabels(in)
+	assert.IsType(NoSourceVersionFound{}, err)
+	_, err = nc.GetCanonicalName(in)
+	assert.IsType(NoSourceVersionFound{}, err)
+	_, err = nc.GetImageName(sv)
+	assert.IsType(NoImageNameFound{}, err)
+	n, err := nc.Warm(sv.CanonicalName())
+	assert.Equal(0, n)
+	if assert.IsType(OfflineError{}, err) {
+		assert.Equal("offline: not querying the registry to harvest github.com/opentable/wackadoo", err.Error())
+	}
+	_, err = nc.Prune(time.Now(), true)
+	assert.IsType(OfflineError{}, err)
+
+	assert.Zero(registryCalls(dc), "an offline name cache shouldn't query the registry")
+}
+
+func TestOfflineNameCacheHits(t *testing.T) {
+	assert := assert.New(t)
+
+	dc := registrytest.NewFake()
+	nc := NewNameCacheWithOptions(dc, []string{"sqlite3", InMemoryConnection("offlinehits")},
+		NameCacheOffline(), NameCacheIgnoreEtags(time.Nanosecond, "docker.repo.io"))
+	sv := SourceVersion{
+		Version: semv.MustParse("1.2.3"),
+		RepoURL: RepoURL("github.com/opentable/wackadoo"),
+	}
+	in := "docker.repo.io/ot/wackadoo:1.2.3"
+	if err := nc.Insert(sv, in, "etag"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := nc.GetSourceVersion(in)
+	if assert.NoError(err) {
+		assert.Equal(sv, got)
+	}
+	name, err := nc.GetImageName(sv)
+	if assert.NoError(err) {
+		assert.Equal(in, name)
+	}
+
+	// what rectify -dry-run asks of the name cache
+	sc := NewSingularityClient(map[string]string{}, nc)
+	labels, err := sc.ImageLabels(in)
+	if assert.NoError(err) {
+		assert.Equal(sv.DockerLabels(), labels)
+	}
+	name, err = sc.ImageName(&Deployment{SourceVersion: sv})
+	if assert.NoError(err) {
+		assert.Equal(in, name)
+	}
+
+	assert.Zero(registryCalls(dc), "an offline name cache shouldn't query the registry")
+}
+
+func TestConfigOffline(t *testing.T) {
+	opts, err := Config{Offline: true}.NameCacheOptions()
+	if assert.NoError(t, err) && assert.Len(t, opts, 1) {
+		nc := &NameCache{}
+		opts[0](nc)
+		assert.True(t, nc.offline)
+	}
+}
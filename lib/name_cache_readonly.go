@@ -0,0 +1,102 @@
+package sous
+
+import (
+	"errors"
+
+	"golang.org/x/net/context"
+)
+
+// readOnlyNameCache is an ImageMapper view of a NameCache that only ever
+// reads what's already cached.
+type readOnlyNameCache struct {
+	nc *NameCache
+}
+
+// ErrNameCacheReadOnly is returned by Insert on a read only view of a
+// NameCache.
+var ErrNameCacheReadOnly = errors.New("the name cache is read only")
+
+// GetSourceVersionCached returns the source version cached for an image
+// name, or NoSourceVersionFound if there is none. Unlike GetSourceVersion, it
+// neither asks the registry whether the image has changed nor updates the
+// cache.
+func (nc *NameCache) GetSourceVersionCached(in string) (SourceVersion, error) {
+	log := nc.log.With("image", in)
+	q := nc.sqlTrace("GetSourceVersionCached", log)
+	defer q.summarize("Looked up cached source version")
+
+	_, repo, offset, version, revision, _, err := nc.dbQueryOnName(q, in)
+	if err != nil {
+		return SourceVersion{}, err
+	}
+	return makeSourceVersion(repo, offset, version, revision)
+}
+
+// GetCanonicalNameCached returns the canonical name cached for an image
+// given any known name, or NoSourceVersionFound if there is none. Unlike
+// GetCanonicalName, it never looks the image up in its registry.
+func (nc *NameCache) GetCanonicalNameCached(in string) (string, error) {
+	log := nc.log.With("image", in)
+	_, _, _, _, _, cn, err := nc.dbQueryOnName(nc.sqlTrace("GetCanonicalName", log), in)
+	log.Debugf("Canonical name: %s", cn)
+	return cn, err
+}
+
+// GetImageNameCached returns the image name cached for a source version, or
+// NoImageNameFound if there is none. Unlike GetImageName, it never harvests
+// the registry for names it doesn't know.
+func (nc *NameCache) GetImageNameCached(sv SourceVersion) (string, error) {
+	log := nc.log.With("source", sv)
+	q := nc.sqlTrace("GetImageNameCached", log)
+	defer q.summarize("Got cached image name")
+
+	cn, _, err := nc.dbQueryOnSV(q, sv)
+	return cn, err
+}
+
+// ReadOnly returns an ImageMapper that looks names up in nc's cache only,
+// as GetSourceVersionCached and GetImageNameCached do, and refuses to
+// Insert.
+func (nc *NameCache) ReadOnly() ImageMapper {
+	return readOnlyNameCache{nc}
+}
+
+// GetCanonicalName implements ImageMapper
+func (r readOnlyNameCache) GetCanonicalName(in string) (string, error) {
+	return r.nc.GetCanonicalNameCached(in)
+}
+
+// Insert implements ImageMapper: it always returns ErrNameCacheReadOnly.
+func (r readOnlyNameCache) Insert(sv SourceVersion, in, etag string) error {
+	return ErrNameCacheReadOnly
+}
+
+// GetImageName implements ImageMapper
+func (r readOnlyNameCache) GetImageName(sv SourceVersion) (string, error) {
+	return r.nc.GetImageNameCached(sv)
+}
+
+// GetSourceVersion implements ImageMapper
+func (r readOnlyNameCache) GetSourceVersion(in string) (SourceVersion, error) {
+	return r.nc.GetSourceVersionCached(in)
+}
+
+// GetLabels implements ImageMapper: it only reconstructs the labels of
+// images that are already cached.
+func (r readOnlyNameCache) GetLabels(in string) (map[string]string, error) {
+	sv, err := r.nc.GetSourceVersionCached(in)
+	if err != nil {
+		return nil, err
+	}
+	return sv.DockerLabels(), nil
+}
+
+// Ping implements ImageMapper: it pings the NameCache viewed.
+func (r readOnlyNameCache) Ping(ctx context.Context) error {
+	return r.nc.Ping(ctx)
+}
+
+// Close implements ImageMapper: it closes the NameCache viewed.
+func (r readOnlyNameCache) Close() error {
+	return r.nc.Close()
+}
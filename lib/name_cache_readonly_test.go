@@ -0,0 +1,82 @@
+package sous
+
+import (
+	"testing"
+
+	"github.com/opentable/sous/util/docker_registry/registrytest"
+	"github.com/samsalisbury/semv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNameCacheCachedLookups(t *testing.T) {
+	assert := assert.New(t)
+
+	dc := registrytest.NewFake()
+	nc := NewNameCache(dc, "sqlite3", InMemoryConnection("cachedlookups"))
+
+	sv := SourceVersion{
+		Version: semv.MustParse("1.2.3"),
+		RepoURL: RepoURL("github.com/opentable/wackadoo"),
+	}
+	in := "docker.repo.io/ot/wackadoo:1.2.3"
+	if _, err := dc.Add(in, sv.DockerLabels()); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := nc.GetSourceVersionCached(in)
+	assert.IsType(NoSourceVersionFound{}, err)
+	_, err = nc.GetImageNameCached(sv)
+	assert.IsType(NoImageNameFound{}, err)
+	assert.Equal(0, dc.Calls(registrytest.GetImageMetadata)+dc.Calls(registrytest.AllTags),
+		"cached lookups shouldn't query the registry")
+
+	if _, err := nc.GetSourceVersion(in); err != nil {
+		t.Fatal(err)
+	}
+	calls := dc.Calls(registrytest.GetImageMetadata)
+
+	cached, err := nc.GetSourceVersionCached(in)
+	if assert.NoError(err) {
+		assert.Equal(sv, cached)
+	}
+	cn, err := nc.GetImageNameCached(sv)
+	if assert.NoError(err) {
+		assert.Regexp("^docker.repo.io/ot/wackadoo@sha256:", cn)
+	}
+	assert.Equal(calls, dc.Calls(registrytest.GetImageMetadata))
+}
+
+func TestNameCacheReadOnly(t *testing.T) {
+	assert := assert.New(t)
+
+	dc := registrytest.NewFake()
+	nc := NewNameCache(dc, "sqlite3", InMemoryConnection("readonly"))
+	ro := nc.ReadOnly()
+
+	sv := SourceVersion{
+		Version: semv.MustParse("1.2.3"),
+		RepoURL: RepoURL("github.com/opentable/wackadoo"),
+	}
+	in := "docker.repo.io/ot/wackadoo:1.2.3"
+	if _, err := dc.Add(in, sv.DockerLabels()); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(ErrNameCacheReadOnly, ro.Insert(sv, in, "etag"))
+	_, err := nc.GetCanonicalNameCached(in)
+	assert.IsType(NoSourceVersionFound{}, err, "the refused Insert shouldn't be cached")
+
+	_, err = ro.GetSourceVersion(in)
+	assert.IsType(NoSourceVersionFound{}, err)
+	_, err = ro.GetImageName(sv)
+	assert.IsType(NoImageNameFound{}, err)
+	assert.Equal(0, dc.Calls(registrytest.GetImageMetadata)+dc.Calls(registrytest.AllTags))
+
+	if err := nc.Insert(sv, in, "etag"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ro.GetSourceVersion(in)
+	if assert.NoError(err) {
+		assert.Equal(sv, got)
+	}
+}
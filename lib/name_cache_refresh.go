@@ -0,0 +1,146 @@
+package sous
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/reference"
+)
+
+type (
+	// NameCacheFetch says how the metadata of a cached image were last
+	// fetched from its registry.
+	NameCacheFetch string
+
+	// etagPolicy is how a NameCache treats the etags of some registries.
+	etagPolicy struct {
+		// ignoreHosts are the registries whose etags are ignored.
+		ignoreHosts map[string]struct{}
+		// maxAge is how long images from those registries are served from
+		// the cache before being fetched again.
+		maxAge time.Duration
+	}
+)
+
+const (
+	// FetchNone means the image was inserted into the cache, rather than
+	// fetched.
+	FetchNone NameCacheFetch = ""
+	// FetchNotModified means the registry said the image hadn't changed.
+	FetchNotModified NameCacheFetch = "not-modified"
+	// FetchFull means the image's metadata were fetched in full.
+	FetchFull NameCacheFetch = "full"
+)
+
+// DefaultIgnoredEtagMaxAge is how long images from registries whose etags
+// are ignored are cached for, unless configured otherwise.
+const DefaultIgnoredEtagMaxAge = time.Hour
+
+// NameCacheIgnoreEtags has the NameCache ignore the etags of the registries
+// at hosts, e.g. docker.example.com:5000, which is worth doing if they
+// return weak etags that change on every request: every lookup would
+// otherwise re-fetch and re-cache the image. Instead, images from those
+// registries are served from the cache until they're maxAge old, and then
+// fetched in full, and only re-cached if they've changed.
+func NameCacheIgnoreEtags(maxAge time.Duration, hosts ...string) NameCacheOption {
+	return func(nc *NameCache) {
+		p := &etagPolicy{ignoreHosts: map[string]struct{}{}, maxAge: maxAge}
+		for _, h := range hosts {
+			p.ignoreHosts[h] = struct{}{}
+		}
+		nc.etags = p
+	}
+}
+
+// NameCacheOptions returns the options for NameCaches that c configures.
+func (c Config) NameCacheOptions() ([]NameCacheOption, error) {
+	var opts []NameCacheOption
+	if c.Offline {
+		opts = append(opts, NameCacheOffline())
+	}
+	var hosts []string
+	for _, h := range strings.Split(c.IgnoreEtagHosts, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	if len(hosts) == 0 {
+		return opts, nil
+	}
+	maxAge := DefaultIgnoredEtagMaxAge
+	if c.IgnoredEtagMaxAge != "" {
+		var err error
+		if maxAge, err = time.ParseDuration(c.IgnoredEtagMaxAge); err != nil {
+			return nil, fmt.Errorf("IgnoredEtagMaxAge: %s", err)
+		}
+	}
+	return append(opts, NameCacheIgnoreEtags(maxAge, hosts...)), nil
+}
+
+// ignoresEtag reports whether the etags of the registry holding the image
+// in are ignored.
+func (nc *NameCache) ignoresEtag(in string) bool {
+	if nc.etags == nil {
+		return false
+	}
+	ref, err := reference.ParseNamed(in)
+	if err != nil {
+		return false
+	}
+	host, _ := reference.SplitHostname(ref)
+	_, ok := nc.etags.ignoreHosts[host]
+	return ok
+}
+
+// dbCachedSince reports whether the image named in was cached after t.
+func (nc *NameCache) dbCachedSince(q *sqlTrace, in string, t time.Time) (bool, error) {
+	var cachedAt int64
+	err := q.queryRowScan([]interface{}{&cachedAt}, "select "+
+		"docker_search_metadata.cached_at "+
+		"from docker_search_name natural join docker_search_metadata "+
+		"where docker_search_name.name = $1", in)
+	if err != nil {
+		return false, err
+	}
+	return cachedAt > t.Unix(), nil
+}
+
+// dbRecordFetch counts a refresh of the image cn, which was fetched as
+// fetch. If etag isn't empty, it's the etag the image now has, and the
+// image counts as cached now.
+func (nc *NameCache) dbRecordFetch(q *sqlTrace, cn string, fetch NameCacheFetch, etag string) error {
+	if etag == "" {
+		_, err := q.exec("update docker_search_metadata "+
+			"set refresh_count = refresh_count + 1, last_fetch = $1 "+
+			"where canonicalName = $2", string(fetch), cn)
+		return err
+	}
+	_, err := q.exec("update docker_search_metadata "+
+		"set refresh_count = refresh_count + 1, last_fetch = $1, etag = $2, cached_at = $3 "+
+		"where canonicalName = $4", string(fetch), etag, nc.clock.Now().Unix(), cn)
+	return err
+}
+
+// addRefreshColumns adds the refresh_count and last_fetch columns to a
+// docker_search_metadata table created before they existed.
+func addRefreshColumns(db *sql.DB) error {
+	for _, col := range []string{
+		"refresh_count integer not null default 0",
+		"last_fetch text not null default ''",
+	} {
+		name := strings.Fields(col)[0]
+		has, err := hasColumn(db, "docker_search_metadata", name)
+		if err != nil {
+			return err
+		}
+		if has {
+			continue
+		}
+		if err := sqlExec(db, "alter table docker_search_metadata add column "+col+";"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
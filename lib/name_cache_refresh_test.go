@@ -0,0 +1,145 @@
+package sous
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/opentable/sous/lib/clocktest"
+	"github.com/opentable/sous/util/docker_registry"
+	"github.com/opentable/sous/util/docker_registry/registrytest"
+	"github.com/samsalisbury/semv"
+	"github.com/stretchr/testify/assert"
+)
+
+// weakEtagRegistry is a registry whose etags change on every request, so
+// that conditional requests never come back not modified.
+type weakEtagRegistry struct {
+	*registrytest.Fake
+	requests int
+}
+
+func (r *weakEtagRegistry) GetImageMetadata(in, etag string) (docker_registry.Metadata, error) {
+	md, err := r.Fake.GetImageMetadata(in, "")
+	r.requests++
+	md.Etag = fmt.Sprintf("W/\"%d\"", r.requests)
+	return md, err
+}
+
+func lookupThrice(t *testing.T, name string, opts ...NameCacheOption) (*weakEtagRegistry, NameCacheEntry) {
+	dc := &weakEtagRegistry{Fake: registrytest.NewFake()}
+	nc := NewNameCacheWithOptions(dc, []string{"sqlite3", InMemoryConnection(name)}, opts...)
+	sv := SourceVersion{
+		Version: semv.MustParse("1.2.3"),
+		RepoURL: RepoURL("github.com/opentable/wackadoo"),
+	}
+	in := "docker.repo.io/ot/wackadoo:1.2.3"
+	if _, err := dc.Add(in, sv.DockerLabels()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		got, err := nc.GetSourceVersion(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, sv, got)
+	}
+	es, err := nc.Entries("")
+	if err != nil || len(es) != 1 {
+		t.Fatalf("got entries %v, %v; want one", es, err)
+	}
+	return dc, es[0]
+}
+
+func TestNameCacheWeakEtags(t *testing.T) {
+	dc, e := lookupThrice(t, "weaketags")
+	assert.Equal(t, 3, dc.requests)
+	assert.Equal(t, 2, e.Refreshes)
+	assert.Equal(t, FetchFull, e.LastFetch)
+	assert.Equal(t, `W/"3"`, e.Etag, "each lookup should have re-cached the image")
+}
+
+func TestNameCacheIgnoreEtags(t *testing.T) {
+	dc, e := lookupThrice(t, "ignoreetags", NameCacheIgnoreEtags(time.Hour, "docker.repo.io"))
+	assert.Equal(t, 1, dc.requests, "the image should be served from the cache")
+	assert.Equal(t, 0, e.Refreshes)
+	assert.Equal(t, `W/"1"`, e.Etag)
+
+	dc, _ = lookupThrice(t, "ignoreetagsotherhost", NameCacheIgnoreEtags(time.Hour, "docker.example.com"))
+	assert.Equal(t, 3, dc.requests, "only the named hosts' etags should be ignored")
+}
+
+func TestNameCacheIgnoredEtagsExpire(t *testing.T) {
+	assert := assert.New(t)
+	clock := clocktest.NewClock(time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC))
+	dc, e := lookupThrice(t, "ignoredetagsexpire",
+		NameCacheIgnoreEtags(time.Hour, "docker.repo.io"), NameCacheClock(clock))
+	assert.Equal(1, dc.requests)
+	assert.Equal(clock.Now().Unix(), e.CachedAt.Unix())
+
+	clock.Advance(time.Hour - time.Second)
+	nc := NewNameCacheWithOptions(dc, []string{"sqlite3", InMemoryConnection("ignoredetagsexpire")},
+		NameCacheIgnoreEtags(time.Hour, "docker.repo.io"), NameCacheClock(clock))
+	in := "docker.repo.io/ot/wackadoo:1.2.3"
+	if _, err := nc.GetSourceVersion(in); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(1, dc.requests, "the image should be served from the cache within the hour")
+
+	clock.Advance(2 * time.Second)
+	if _, err := nc.GetSourceVersion(in); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(2, dc.requests, "the image should be fetched again after the hour")
+	es, err := nc.Entries("")
+	if err != nil || len(es) != 1 {
+		t.Fatalf("got entries %v, %v; want one", es, err)
+	}
+	assert.Equal(FetchFull, es[0].LastFetch)
+	assert.Equal(clock.Now().Unix(), es[0].CachedAt.Unix(), "the refetched image should count as cached now")
+}
+
+func TestNameCacheCountsNotModified(t *testing.T) {
+	assert := assert.New(t)
+	dc := registrytest.NewFake()
+	nc := NewNameCache(dc, "sqlite3", InMemoryConnection("countsnotmodified"))
+	sv := SourceVersion{
+		Version: semv.MustParse("1.2.3"),
+		RepoURL: RepoURL("github.com/opentable/wackadoo"),
+	}
+	in := "docker.repo.io/ot/wackadoo:1.2.3"
+	if _, err := dc.Add(in, sv.DockerLabels()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := nc.GetSourceVersion(in); err != nil {
+			t.Fatal(err)
+		}
+	}
+	es, err := nc.Entries("")
+	if assert.NoError(err) && assert.Len(es, 1) {
+		assert.Equal(2, es[0].Refreshes)
+		assert.Equal(FetchNotModified, es[0].LastFetch)
+	}
+}
+
+func TestConfigNameCacheOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	opts, err := Config{}.NameCacheOptions()
+	assert.NoError(err)
+	assert.Empty(opts)
+
+	opts, err = Config{IgnoreEtagHosts: " docker.repo.io, other:5000 ", IgnoredEtagMaxAge: "5m"}.NameCacheOptions()
+	if assert.NoError(err) && assert.Len(opts, 1) {
+		nc := &NameCache{}
+		opts[0](nc)
+		assert.Equal(5*time.Minute, nc.etags.maxAge)
+		assert.True(nc.ignoresEtag("docker.repo.io/ot/wackadoo:1.2.3"))
+		assert.True(nc.ignoresEtag("other:5000/wackadoo:1.2.3"))
+		assert.False(nc.ignoresEtag("docker.example.com/wackadoo:1.2.3"))
+	}
+
+	_, err = Config{IgnoreEtagHosts: "docker.repo.io", IgnoredEtagMaxAge: "a while"}.NameCacheOptions()
+	assert.Error(err)
+}
@@ -0,0 +1,238 @@
+package sous
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opentable/sous/util/logging"
+)
+
+// maxTracedArgLen is the length SQL arguments are truncated to when traced.
+const maxTracedArgLen = 64
+
+type (
+	// sqlTrace runs the SQL statements of one call on a NameCache. If it's
+	// tracing, it logs each statement, with its arguments, the rows it
+	// returned or affected, and how long it took, and totals them.
+	sqlTrace struct {
+		db *sql.DB
+		// op is the NameCache method the statements are run for.
+		op string
+		// log is nil unless tracing.
+		log     Logger
+		queries int
+		total   time.Duration
+	}
+
+	// tracedRows logs its query once its rows have been read.
+	tracedRows struct {
+		*sql.Rows
+		trace *sqlTrace
+		query string
+		args  []interface{}
+		start time.Time
+		count int
+		done  bool
+	}
+
+	// tracedStmt traces each execution of a prepared statement.
+	tracedStmt struct {
+		*sql.Stmt
+		trace *sqlTrace
+		query string
+	}
+
+	// CacheSQLError is a failure of an SQL statement run by a NameCache. Op
+	// is the NameCache method it was run for, e.g. "Insert", and Args are
+	// its arguments, with long ones truncated. It unwraps to Err, so that
+	// e.g. errors.Is(err, sql.ErrNoRows) still works.
+	CacheSQLError struct {
+		Op    string
+		Query string
+		Args  []interface{}
+		Err   error
+	}
+)
+
+// NameCacheTraceSQL has the NameCache log every SQL statement it runs, at
+// debug level, and the number of statements each lookup ran and how long
+// they took. Nothing is traced unless logging.NameCache is at debug level.
+func NameCacheTraceSQL() NameCacheOption {
+	return func(nc *NameCache) {
+		nc.traceSQL = true
+	}
+}
+
+// sqlTrace returns an sqlTrace for a call of the method op that logs to
+// log, which is only tracing if the NameCache was asked to trace and debug
+// messages would be logged.
+func (nc *NameCache) sqlTrace(op string, log Logger) *sqlTrace {
+	q := &sqlTrace{db: nc.db, op: op}
+	if nc.traceSQL && logging.Enabled(logging.NameCache, logging.DebugLevel) {
+		q.log = log
+	}
+	return q
+}
+
+// summarize logs msg at debug level, with the number of statements run and
+// how long they took, if tracing.
+func (q *sqlTrace) summarize(msg string) {
+	if q.log == nil {
+		return
+	}
+	q.log.With("queries", q.queries, "query_time", q.total).Debugf(msg)
+}
+
+func (q *sqlTrace) record(query string, args []interface{}, start time.Time, rows int64, err error) {
+	took := time.Since(start)
+	q.queries++
+	q.total += took
+	log := q.log.With("sql", query, "args", traceArgs(args), "rows", rows, "duration", took)
+	if err != nil {
+		log = log.With("error", err)
+	}
+	log.Debugf("Ran SQL")
+}
+
+func (q *sqlTrace) exec(query string, args ...interface{}) (sql.Result, error) {
+	if q.log == nil {
+		res, err := q.db.Exec(query, args...)
+		return res, q.wrap(query, args, err)
+	}
+	start := time.Now()
+	res, err := q.db.Exec(query, args...)
+	q.record(query, args, start, rowsAffected(res, err), err)
+	return res, q.wrap(query, args, err)
+}
+
+// queryRowScan runs a query expected to return one row, and scans it into
+// dest.
+func (q *sqlTrace) queryRowScan(dest []interface{}, query string, args ...interface{}) error {
+	if q.log == nil {
+		return q.wrap(query, args, q.db.QueryRow(query, args...).Scan(dest...))
+	}
+	start := time.Now()
+	err := q.db.QueryRow(query, args...).Scan(dest...)
+	var rows int64 = 1
+	if err != nil {
+		rows = 0
+	}
+	q.record(query, args, start, rows, err)
+	return q.wrap(query, args, err)
+}
+
+func (q *sqlTrace) query(query string, args ...interface{}) (*tracedRows, error) {
+	start := time.Now()
+	rows, err := q.db.Query(query, args...)
+	if err != nil {
+		if q.log != nil {
+			q.record(query, args, start, 0, err)
+		}
+		return nil, q.wrap(query, args, err)
+	}
+	return &tracedRows{Rows: rows, trace: q, query: query, args: args, start: start}, nil
+}
+
+func (q *sqlTrace) prepare(query string) (*tracedStmt, error) {
+	stmt, err := q.db.Prepare(query)
+	if err != nil {
+		return nil, q.wrap(query, nil, err)
+	}
+	return &tracedStmt{Stmt: stmt, trace: q, query: query}, nil
+}
+
+// wrap returns err as a *CacheSQLError for query run with args, or nil if
+// err is.
+func (q *sqlTrace) wrap(query string, args []interface{}, err error) error {
+	return sqlError(q.op, query, args, err)
+}
+
+// Next counts the rows read, and records the query once they run out.
+func (r *tracedRows) Next() bool {
+	if r.Rows.Next() {
+		r.count++
+		return true
+	}
+	if r.trace.log != nil && !r.done {
+		r.done = true
+		r.trace.record(r.query, r.args, r.start, int64(r.count), r.Rows.Err())
+	}
+	return false
+}
+
+// Err returns the error, if any, met reading the rows.
+func (r *tracedRows) Err() error {
+	return r.trace.wrap(r.query, r.args, r.Rows.Err())
+}
+
+func (s *tracedStmt) exec(args ...interface{}) (sql.Result, error) {
+	if s.trace.log == nil {
+		res, err := s.Stmt.Exec(args...)
+		return res, s.trace.wrap(s.query, args, err)
+	}
+	start := time.Now()
+	res, err := s.Stmt.Exec(args...)
+	s.trace.record(s.query, args, start, rowsAffected(res, err), err)
+	return res, s.trace.wrap(s.query, args, err)
+}
+
+func rowsAffected(res sql.Result, err error) int64 {
+	if err != nil {
+		return 0
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// traceArgs formats SQL arguments for the log, truncating long ones, and
+// quoting strings.
+func traceArgs(args []interface{}) string {
+	strs := make([]string, len(args))
+	for i, a := range args {
+		s := fmt.Sprint(a)
+		if len(s) > maxTracedArgLen {
+			s = s[:maxTracedArgLen] + "..."
+		}
+		if _, ok := a.(string); ok {
+			s = strconv.Quote(s)
+		}
+		strs[i] = s
+	}
+	return "[" + strings.Join(strs, ", ") + "]"
+}
+
+// sqlError returns err as a *CacheSQLError, or nil if err is nil.
+func sqlError(op, query string, args []interface{}, err error) error {
+	if err == nil {
+		return nil
+	}
+	kept := make([]interface{}, len(args))
+	for i, a := range args {
+		switch a := a.(type) {
+		default:
+			kept[i] = a
+		case string:
+			if len(a) > maxTracedArgLen {
+				a = a[:maxTracedArgLen] + "..."
+			}
+			kept[i] = a
+		case []byte:
+			kept[i] = fmt.Sprintf("<%d bytes>", len(a))
+		}
+	}
+	return &CacheSQLError{Op: op, Query: query, Args: kept, Err: err}
+}
+
+func (e *CacheSQLError) Error() string {
+	return fmt.Sprintf("name cache %s: %s; running %s with %s",
+		e.Op, e.Err, strings.Join(strings.Fields(e.Query), " "), traceArgs(e.Args))
+}
+
+// Unwrap returns the error from the database.
+func (e *CacheSQLError) Unwrap() error { return e.Err }
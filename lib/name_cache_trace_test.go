@@ -0,0 +1,118 @@
+package sous
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/opentable/sous/util/docker_registry/registrytest"
+	"github.com/opentable/sous/util/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func tracedLookup(t *testing.T, db string, opts ...NameCacheOption) recordingLogger {
+	l := newRecordingLogger()
+	fake := registrytest.NewFake()
+	name := "docker.example.com/repo:1.2.3"
+	sv := SourceVersion{RepoURL: "github.com/example/repo"}
+	if _, err := fake.Add(name, sv.DockerLabels()); err != nil {
+		t.Fatal(err)
+	}
+	opts = append(opts, NameCacheLogger(l))
+	nc := NewNameCacheWithOptions(fake, []string{"sqlite3", InMemoryConnection(db)}, opts...)
+	if _, err := nc.GetSourceVersion(name); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestNameCacheTracesSQL(t *testing.T) {
+	defer debugLogging(logging.NameCache)()
+	l := tracedLookup(t, "tracesql", NameCacheTraceSQL())
+
+	queries := 0
+	var summary *logLine
+	for i, line := range l.lines {
+		if line.msg == "Ran SQL" {
+			queries++
+			assert.Contains(t, line.context, "sql")
+			assert.Contains(t, line.context, "args")
+			assert.Contains(t, line.context, "rows")
+			assert.Contains(t, line.context, "duration")
+		}
+		if line.msg == "Looked up source version" {
+			summary = &l.lines[i]
+		}
+	}
+	assert.NotZero(t, queries)
+	if assert.NotNil(t, summary) {
+		assert.Equal(t, queries, summary.context["queries"])
+		assert.Contains(t, summary.context, "query_time")
+	}
+}
+
+func TestNameCacheSQLTracingIsOptIn(t *testing.T) {
+	defer debugLogging(logging.NameCache)()
+	l := tracedLookup(t, "untraced")
+	assert.NotEmpty(t, l.lines)
+	for _, line := range l.lines {
+		assert.NotContains(t, line.context, "sql", line.msg)
+		assert.NotContains(t, line.context, "queries", line.msg)
+	}
+}
+
+func TestNameCacheSQLTracingNeedsDebugLevel(t *testing.T) {
+	l := tracedLookup(t, "tracedinfo", NameCacheTraceSQL())
+	assert.Empty(t, l.lines)
+}
+
+func TestTraceArgsTruncates(t *testing.T) {
+	long := strings.Repeat("x", maxTracedArgLen+10)
+	assert.Equal(t,
+		`["short", 3, "`+long[:maxTracedArgLen]+`..."]`,
+		traceArgs([]interface{}{"short", 3, long}))
+}
+
+func TestCacheSQLErrorsSayWhatFailed(t *testing.T) {
+	assert := assert.New(t)
+	nc := NewNameCache(registrytest.NewFake(), "sqlite3", InMemoryConnection("sqlerrors"))
+	if _, err := nc.db.Exec("drop table docker_search_name;"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := nc.GetCanonicalName("docker.example.com/repo:1.2.3")
+	var sqlErr *CacheSQLError
+	if assert.True(errors.As(err, &sqlErr), "%#v", err) {
+		assert.Equal("GetCanonicalName", sqlErr.Op)
+		assert.Contains(sqlErr.Query, "docker_search_name")
+		assert.Contains(sqlErr.Args, "docker.example.com/repo:1.2.3")
+		assert.Contains(err.Error(), "name cache GetCanonicalName: no such table: docker_search_name")
+	}
+
+	_, err = nc.Entries("")
+	if assert.True(errors.As(err, &sqlErr), "%#v", err) {
+		assert.Equal("Entries", sqlErr.Op)
+	}
+}
+
+func TestCacheSQLErrorUnwraps(t *testing.T) {
+	assert := assert.New(t)
+	nc := NewNameCache(registrytest.NewFake(), "sqlite3", InMemoryConnection("sqlnorows"))
+
+	var id int64
+	err := nc.sqlTrace("Test", nc.log).queryRowScan([]interface{}{&id},
+		"select metadata_id from docker_search_name where name = $1", "nothing")
+	assert.True(errors.Is(err, sql.ErrNoRows))
+	assert.IsType(&CacheSQLError{}, err)
+}
+
+func TestCacheSQLErrorTruncatesArgs(t *testing.T) {
+	long := strings.Repeat("x", maxTracedArgLen+10)
+	err := sqlError("Insert", "insert into t values ($1, $2, $3)",
+		[]interface{}{long, 3, []byte("etag")}, errors.New("oops")).(*CacheSQLError)
+	assert.Equal(t, []interface{}{long[:maxTracedArgLen] + "...", 3, "<4 bytes>"}, err.Args)
+	assert.Equal(t,
+		`name cache Insert: oops; running insert into t values ($1, $2, $3) with ["`+long[:maxTracedArgLen]+`...", 3, "<4 bytes>"]`,
+		err.Error())
+}
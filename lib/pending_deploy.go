@@ -0,0 +1,82 @@
+package sous
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// pendingDeployPollInterval is how often a pending deploy of different
+// content is rechecked while waiting for it to finish.
+const pendingDeployPollInterval = time.Second
+
+// DeployPending is the cause of a RectificationError when a deploy of
+// different content was still pending on the request after waiting for it.
+type DeployPending struct {
+	DeployID string
+	After    time.Duration
+}
+
+func (e *DeployPending) Error() string {
+	return fmt.Sprintf("deploy %s of different content is still pending after %s", e.DeployID, e.After)
+}
+
+// Temporary is always true: the pending deploy should finish eventually.
+func (e *DeployPending) Temporary() bool { return true }
+
+// pendingDeployWait returns how long to wait for a pending deploy.
+func (r *rectifier) pendingDeployWait() time.Duration {
+	if r.PendingDeployWait <= 0 {
+		return r.timeout()
+	}
+	return r.PendingDeployWait
+}
+
+// awaitPendingDeploy checks for a deploy already pending on the request
+// before d is deployed with the ID baseID. If the pending deploy is of the
+// same content, it returns true, and d needn't be deployed again. If it's
+// of different content, it's cancelled, if the options say so and the
+// client can, or else waited for, for at most pendingDeployWait.
+func (r *rectifier) awaitPendingDeploy(d *Deployment, reqID, baseID string, started time.Time) (bool, error) {
+	log := r.logFor(d, reqID)
+	wait := r.pendingDeployWait()
+	deadline := r.clock().Now().Add(wait)
+	for {
+		var pending bool
+		var depID string
+		err := r.call(d.Cluster, "PendingDeploy", func() error {
+			var err error
+			pending, depID, err = r.sing.PendingDeploy(d.Cluster, reqID)
+			return err
+		})
+		if err != nil || !pending {
+			return false, err
+		}
+
+		// suffixed IDs are only used for the same content as baseID
+		if depID == baseID || strings.HasPrefix(depID, baseID+"_") {
+			log.Debugf("Deploy %s already pending", depID)
+			r.emit(Skipped, d, reqID, started, "deploy "+depID+" already pending")
+			return true, nil
+		}
+
+		if canceller, ok := r.sing.(DeployCanceller); ok && r.CancelPendingDeploys {
+			log.Infof("Cancelling pending deploy %s", depID)
+			err := r.call(d.Cluster, "CancelDeploy", func() error {
+				return canceller.CancelDeploy(d.Cluster, reqID, depID)
+			})
+			r.audit(AuditCancelDeploy, d, reqID, depID, d.NumInstances, "cancelled pending deploy", err)
+			return false, err
+		}
+
+		remaining := deadline.Sub(r.clock().Now())
+		if remaining <= 0 {
+			return false, &DeployPending{DeployID: depID, After: wait}
+		}
+		log.Debugf("Waiting for pending deploy %s", depID)
+		if remaining > pendingDeployPollInterval {
+			remaining = pendingDeployPollInterval
+		}
+		<-r.clock().After(remaining)
+	}
+}
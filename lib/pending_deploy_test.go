@@ -0,0 +1,102 @@
+package sous
+
+import (
+	"testing"
+	"time"
+
+	"github.com/samsalisbury/semv"
+	"github.com/stretchr/testify/assert"
+)
+
+func pendingPair() (*DeploymentPair, string) {
+	prior := &Deployment{
+		SourceVersion: SourceVersion{RepoURL: RepoURL("reqid"), Version: semv.MustParse("1.2.3")},
+		DeployConfig:  DeployConfig{NumInstances: 2},
+		Cluster:       "cluster",
+	}
+	post := &Deployment{
+		SourceVersion: SourceVersion{RepoURL: RepoURL("reqid"), Version: semv.MustParse("2.3.4")},
+		DeployConfig:  DeployConfig{NumInstances: 2},
+		Cluster:       "cluster",
+	}
+	reqID := ComputeRequestID(post)
+	depID := computeDeployID(reqID, post.SourceVersion.String(), post.Resources, post.Env, post.DeployConfig.Volumes)
+	return &DeploymentPair{prior: prior, post: post}, depID
+}
+
+func rectifyPending(client *DummyRectificationClient, opts RectifyOpts) ([]RectifyEvent, []RectificationError) {
+	pair, _ := pendingPair()
+	events := make(chan RectifyEvent, 100)
+	opts.Events = events
+	chanset := NewDiffChans(1)
+	errs := RectifyWith(chanset, client, opts)
+	chanset.Modified <- pair
+	chanset.Close()
+	rerrs := []RectificationError{}
+	for e := range errs {
+		rerrs = append(rerrs, e)
+	}
+	close(events)
+	evs := []RectifyEvent{}
+	for e := range events {
+		evs = append(evs, e)
+	}
+	return evs, rerrs
+}
+
+func TestPendingDeployOfSameContentIsSkipped(t *testing.T) {
+	assert := assert.New(t)
+	pair, depID := pendingPair()
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	client.SetPendingDeploy("cluster", ComputeRequestID(pair.post), depID, 1)
+
+	events, errs := rectifyPending(client, RectifyOpts{})
+	assert.Empty(errs)
+	assert.Empty(client.deployed)
+	last := events[len(events)-1]
+	assert.Equal(Skipped, last.Kind)
+	assert.Equal("deploy "+depID+" already pending", last.Message)
+}
+
+func TestPendingDeployOfDifferentContentIsWaitedFor(t *testing.T) {
+	assert := assert.New(t)
+	pair, depID := pendingPair()
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	client.SetPendingDeploy("cluster", ComputeRequestID(pair.post), "olddepid", 1)
+
+	_, errs := rectifyPending(client, RectifyOpts{})
+	assert.Empty(errs)
+	if assert.Len(client.deployed, 1) {
+		assert.Equal(depID, client.deployed[0].depID)
+	}
+	assert.Empty(client.cancelled)
+}
+
+func TestPendingDeployWaitIsBounded(t *testing.T) {
+	assert := assert.New(t)
+	pair, _ := pendingPair()
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	client.SetPendingDeploy("cluster", ComputeRequestID(pair.post), "olddepid", 1000)
+
+	_, errs := rectifyPending(client, RectifyOpts{PendingDeployWait: 10 * time.Millisecond})
+	assert.Empty(client.deployed)
+	if assert.Len(errs, 1) {
+		assert.IsType(&DeployPending{}, errs[0].(*ChangeError).Err)
+		assert.True(errs[0].Retryable())
+	}
+}
+
+func TestPendingDeployOfDifferentContentIsCancelled(t *testing.T) {
+	assert := assert.New(t)
+	pair, depID := pendingPair()
+	reqID := ComputeRequestID(pair.post)
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	client.SetPendingDeploy("cluster", reqID, "olddepid", 1000)
+
+	_, errs := rectifyPending(client, RectifyOpts{CancelPendingDeploys: true})
+	assert.Empty(errs)
+	assert.Equal([]dummyPending{{"cluster", reqID, "olddepid", 0}}, client.cancelled)
+	if assert.Len(client.deployed, 1) {
+		assert.Equal(depID, client.deployed[0].depID)
+	}
+}
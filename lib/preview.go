@@ -0,0 +1,251 @@
+package sous
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// A Preview is an ephemeral deployment, e.g. of a pull request, made
+	// outside the state and deleted once it expires. Previews are recorded in
+	// a PreviewLedger.
+	Preview struct {
+		RequestID string
+		// Cluster is the name of the cluster the preview runs on, and
+		// BaseURL the URL of its Singularity.
+		Cluster string
+		BaseURL string
+		// SourceVersion is the source version deployed, as
+		// SourceVersion.String formats it.
+		SourceVersion string
+		Instances     int
+		Created       time.Time
+		Expires       time.Time
+	}
+
+	// A PreviewLedger is a JSON file listing the previews that have been
+	// deployed and not yet deleted.
+	PreviewLedger struct {
+		sync.Mutex
+		path string
+	}
+
+	// PreviewOpts configures a preview deployment built by
+	// NewPreviewDeployment.
+	PreviewOpts struct {
+		// Instances is how many instances the preview runs.
+		Instances int
+		// Env is merged over the env of the deployment previewed, as
+		// Env.Merge does.
+		Env Env
+		// TTL is how long the preview lasts before PreviewLedger.Expired
+		// returns it.
+		TTL time.Duration
+	}
+)
+
+const (
+	// PreviewRequestIDPrefix starts the ID of every preview's request. The
+	// rectifier leaves requests with IDs starting with it alone, so that
+	// previews aren't deleted for being missing from the state.
+	PreviewRequestIDPrefix = "sous-preview-"
+	// PreviewExpiresKey is the Metadata key under which a preview
+	// deployment records when it expires, in RFC 3339 format.
+	PreviewExpiresKey = "sous.preview.expires"
+)
+
+// IsPreviewRequestID reports whether id names the request of a preview.
+func IsPreviewRequestID(id string) bool {
+	return strings.HasPrefix(id, PreviewRequestIDPrefix)
+}
+
+// IsPreview reports whether d is deployed as a preview, and so isn't
+// rectified.
+func IsPreview(d *Deployment) bool {
+	return IsPreviewRequestID(d.RequestID)
+}
+
+// preview reports whether reqID is the request of a preview, which the
+// rectifier mustn't delete or modify, and if so, emits a Skipped event for d.
+func (r *rectifier) preview(d *Deployment, reqID string) bool {
+	if !IsPreviewRequestID(reqID) {
+		return false
+	}
+	r.emit(Skipped, d, reqID, r.started(d, reqID), "request is a preview")
+	return true
+}
+
+// PreviewRequestID returns the ID of the request a preview of sv is
+// deployed as: the default ID for its source location, and its version,
+// marked with PreviewRequestIDPrefix.
+func PreviewRequestID(sv SourceVersion) string {
+	return FitRequestID(PreviewRequestIDPrefix + sanitizeID(sv.CanonicalName().String(), true) +
+		"-" + sv.Version.Format("M.m.p-?"))
+}
+
+// NewPreviewDeployment returns a preview of d, as it would be deployed to its
+// cluster, but of sv, and configured by opts. d isn't modified. The preview
+// expires opts.TTL after now.
+func NewPreviewDeployment(d *Deployment, sv SourceVersion, opts PreviewOpts, now time.Time) *Deployment {
+	p := d.Clone()
+	p.SourceVersion = sv
+	p.RequestID = PreviewRequestID(sv)
+	p.NumInstances = opts.Instances
+	p.Env = p.Env.Merge(opts.Env)
+	if p.Metadata == nil {
+		p.Metadata = map[string]string{}
+	}
+	p.Metadata[PreviewExpiresKey] = now.Add(opts.TTL).UTC().Format(time.RFC3339)
+	return p
+}
+
+// DeployPreview creates the preview deployment d, as built by
+// NewPreviewDeployment, with rc, and records it in ledger under the cluster
+// name cluster, as created at now. Like any other create, it deploys over a
+// preview of the same version that already exists.
+func DeployPreview(rc RectificationClient, d *Deployment, cluster string, ledger *PreviewLedger, now time.Time) (Preview, error) {
+	if !IsPreview(d) {
+		return Preview{}, fmt.Errorf("%s is not a preview deployment", d.ID())
+	}
+	expires, err := time.Parse(time.RFC3339, d.Metadata[PreviewExpiresKey])
+	if err != nil {
+		return Preview{}, fmt.Errorf("preview %s has no expiry: %s", d.RequestID, err)
+	}
+	if errs := d.Validate(); len(errs) > 0 {
+		return Preview{}, &InvalidDeploymentError{Deployment: d, Errs: errs}
+	}
+
+	dcs := NewDiffChans(1)
+	dcs.Created <- d
+	dcs.Close()
+	var causes []error
+	for err := range Rectify(dcs, rc) {
+		causes = append(causes, err)
+	}
+	if len(causes) > 0 {
+		return Preview{}, &ResolveErrors{causes}
+	}
+
+	p := Preview{
+		RequestID:     d.RequestID,
+		Cluster:       cluster,
+		BaseURL:       string(d.Cluster),
+		SourceVersion: d.SourceVersion.String(),
+		Instances:     d.NumInstances,
+		Created:       now,
+		Expires:       expires,
+	}
+	return p, ledger.Add(p)
+}
+
+// DeletePreview deletes the request of p with rc, and removes p from ledger.
+func DeletePreview(rc RectificationClient, p Preview, ledger *PreviewLedger) error {
+	err := rc.DeleteRequest(ClusterName(p.BaseURL), p.RequestID, "deleting expired preview")
+	if err != nil {
+		return err
+	}
+	return ledger.Remove(p.RequestID)
+}
+
+// NewPreviewLedger returns the PreviewLedger kept in the file at path, which
+// is created when the first preview is added to it.
+func NewPreviewLedger(path string) *PreviewLedger {
+	return &PreviewLedger{path: path}
+}
+
+// Previews returns every preview in the ledger, soonest to expire first.
+func (l *PreviewLedger) Previews() ([]Preview, error) {
+	l.Lock()
+	defer l.Unlock()
+	return l.read()
+}
+
+// Expired returns the previews in the ledger that expired before now,
+// soonest to expire first.
+func (l *PreviewLedger) Expired(now time.Time) ([]Preview, error) {
+	ps, err := l.Previews()
+	if err != nil {
+		return nil, err
+	}
+	expired := []Preview{}
+	for _, p := range ps {
+		if p.Expires.Before(now) {
+			expired = append(expired, p)
+		}
+	}
+	return expired, nil
+}
+
+// Add records p in the ledger, replacing any preview with the same request
+// ID.
+func (l *PreviewLedger) Add(p Preview) error {
+	l.Lock()
+	defer l.Unlock()
+	ps, err := l.read()
+	if err != nil {
+		return err
+	}
+	return l.write(append(withoutPreview(ps, p.RequestID), p))
+}
+
+// Remove removes the preview with the request ID reqID from the ledger, if
+// it's there.
+func (l *PreviewLedger) Remove(reqID string) error {
+	l.Lock()
+	defer l.Unlock()
+	ps, err := l.read()
+	if err != nil {
+		return err
+	}
+	return l.write(withoutPreview(ps, reqID))
+}
+
+func (l *PreviewLedger) read() ([]Preview, error) {
+	ps := []Preview{}
+	b, err := ioutil.ReadFile(l.path)
+	if os.IsNotExist(err) {
+		return ps, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &ps); err != nil {
+		return nil, fmt.Errorf("reading preview ledger %s: %s", l.path, err)
+	}
+	sort.SliceStable(ps, func(i, j int) bool { return ps[i].Expires.Before(ps[j].Expires) })
+	return ps, nil
+}
+
+// write replaces the ledger with ps, by way of a temporary file, so that
+// it's never left half written.
+func (l *PreviewLedger) write(ps []Preview) error {
+	b, err := json.MarshalIndent(ps, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	tmp := l.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
+
+func withoutPreview(ps []Preview, reqID string) []Preview {
+	out := make([]Preview, 0, len(ps))
+	for _, p := range ps {
+		if p.RequestID != reqID {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,148 @@
+package sous
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/samsalisbury/semv"
+	"github.com/stretchr/testify/assert"
+)
+
+func previewTestLedger(t *testing.T) (*PreviewLedger, func()) {
+	dir, err := ioutil.TempDir("", "sous-previews")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewPreviewLedger(filepath.Join(dir, "previews.json")), func() { os.RemoveAll(dir) }
+}
+
+func previewedDeployment() *Deployment {
+	return &Deployment{
+		SourceVersion: SourceVersion{RepoURL: "github.com/opentable/one", Version: semv.MustParse("1.0.0")},
+		DeployConfig: DeployConfig{
+			Resources:    Resources{"cpus": "0.1", "memory": "100", "ports": "1"},
+			Env:          Env{"A": "1", "B": "2"},
+			NumInstances: 4,
+		},
+		Cluster: "cluster",
+	}
+}
+
+func TestNewPreviewDeployment(t *testing.T) {
+	assert := assert.New(t)
+	d := previewedDeployment()
+	sv := SourceVersion{RepoURL: "github.com/opentable/one", Version: semv.MustParse("1.1.0-pr42")}
+	now := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	p := NewPreviewDeployment(d, sv, PreviewOpts{Instances: 1, Env: Env{"B": "preview"}, TTL: 48 * time.Hour}, now)
+	assert.Equal("sous-preview-github.comopentableone-1.1.0-pr42", p.RequestID)
+	assert.True(IsPreview(p))
+	assert.Equal(sv, p.SourceVersion)
+	assert.Equal(1, p.NumInstances)
+	assert.Equal(Env{"A": "1", "B": "preview"}, p.Env)
+	assert.Equal("2017-01-04T03:04:05Z", p.Metadata[PreviewExpiresKey])
+	assert.Equal(d.Resources, p.Resources)
+
+	assert.False(IsPreview(d), "the previewed deployment should be untouched")
+	assert.Equal(Env{"A": "1", "B": "2"}, d.Env)
+	assert.Nil(d.Metadata)
+}
+
+func TestDeployPreview(t *testing.T) {
+	assert := assert.New(t)
+	ledger, cleanup := previewTestLedger(t)
+	defer cleanup()
+	client := NewDummyRectificationClient(NewDummyNameCache())
+
+	now := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	d := NewPreviewDeployment(previewedDeployment(), previewedDeployment().SourceVersion,
+		PreviewOpts{Instances: 1, TTL: time.Hour}, now)
+	p, err := DeployPreview(client, d, "staging", ledger, now)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(d.RequestID, p.RequestID)
+	assert.Equal("staging", p.Cluster)
+	assert.Equal("cluster", p.BaseURL)
+	assert.True(client.Created(d.RequestID))
+	assert.True(client.Deployed(d.RequestID))
+
+	ps, err := ledger.Previews()
+	if assert.NoError(err) && assert.Len(ps, 1) {
+		assert.Equal(p.RequestID, ps[0].RequestID)
+		assert.True(now.Equal(ps[0].Created), "created %s; want %s", ps[0].Created, now)
+		assert.True(now.Add(time.Hour).Equal(ps[0].Expires), "expires %s; want %s", ps[0].Expires, now.Add(time.Hour))
+	}
+
+	_, err = DeployPreview(client, previewedDeployment(), "staging", ledger, now)
+	assert.Error(err, "a deployment that isn't a preview should be refused")
+}
+
+func TestPreviewLedgerExpired(t *testing.T) {
+	assert := assert.New(t)
+	ledger, cleanup := previewTestLedger(t)
+	defer cleanup()
+	now := time.Now()
+
+	for _, p := range []Preview{
+		{RequestID: "sous-preview-later", Expires: now.Add(time.Hour)},
+		{RequestID: "sous-preview-gone", Expires: now.Add(-time.Hour)},
+		{RequestID: "sous-preview-later", Expires: now.Add(2 * time.Hour)},
+	} {
+		if err := ledger.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ps, err := ledger.Previews()
+	if assert.NoError(err) && assert.Len(ps, 2, "adding a preview again should replace it") {
+		assert.Equal("sous-preview-gone", ps[0].RequestID)
+		assert.WithinDuration(now.Add(2*time.Hour), ps[1].Expires, time.Second)
+	}
+
+	expired, err := ledger.Expired(now)
+	if assert.NoError(err) && assert.Len(expired, 1) {
+		assert.Equal("sous-preview-gone", expired[0].RequestID)
+	}
+
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	if assert.NoError(DeletePreview(client, expired[0], ledger)) {
+		assert.Len(client.CallsTo("DeleteRequest"), 1)
+		ps, err := ledger.Previews()
+		assert.NoError(err)
+		assert.Len(ps, 1)
+	}
+}
+
+func TestRectifyLeavesPreviewsAlone(t *testing.T) {
+	assert := assert.New(t)
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	preview := previewedDeployment()
+	preview.RequestID = PreviewRequestID(preview.SourceVersion)
+	changed := preview.Clone()
+	changed.NumInstances = 1
+
+	chanset := NewDiffChans(2)
+	events := make(chan RectifyEvent, 10)
+	errs := RectifyWith(chanset, client, RectifyOpts{Events: events})
+	chanset.Deleted <- preview
+	chanset.Modified <- &DeploymentPair{prior: preview, post: changed}
+	chanset.Close()
+	for err := range errs {
+		t.Error(err)
+	}
+	close(events)
+
+	assert.Empty(client.CallsTo("DeleteRequest"))
+	assert.Empty(client.CallsTo("Scale"))
+	skipped := 0
+	for ev := range events {
+		if ev.Kind == Skipped {
+			assert.Equal("request is a preview", ev.Message)
+			skipped++
+		}
+	}
+	assert.Equal(2, skipped)
+}
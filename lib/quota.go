@@ -0,0 +1,245 @@
+package sous
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type (
+	// A Quota limits the deployments to a cluster: the cpus and memory of
+	// all their instances together, and the instances of each. Zero means
+	// no limit.
+	Quota struct {
+		CPUs     float64 `yaml:",omitempty"`
+		MemoryMB float64 `yaml:",omitempty"`
+		// MaxInstances limits the instances of each deployment.
+		MaxInstances int `yaml:",omitempty"`
+	}
+
+	// ClusterQuotas are the quotas set on a cluster by its definition.
+	ClusterQuotas struct {
+		// Name is the name the cluster is defined as.
+		Name string
+		// Capacity limits every deployment to the cluster.
+		Capacity Quota
+		// Owners limit the deployments owned by each owner.
+		Owners map[string]Quota
+	}
+
+	// Quotas are the ClusterQuotas of clusters, by their BaseURL, which is
+	// how deployments built from a State and read from Singularity identify
+	// them.
+	Quotas map[ClusterName]ClusterQuotas
+
+	// QuotaExceeded is returned instead of rectifying a plan whose
+	// deployments would exceed one of the quotas of a cluster. Nothing is
+	// changed.
+	QuotaExceeded struct {
+		// Cluster is the name of the cluster.
+		Cluster string
+		// Owner is the owner whose quota was exceeded, or "" if it was the
+		// cluster's Capacity.
+		Owner string
+		Quota Quota
+		// CPUs and MemoryMB are the totals of the deployments counted.
+		CPUs, MemoryMB float64
+		// Exceeded describes each limit that was exceeded.
+		Exceeded []string
+		// Offenders are the deployments that use the most of the limits
+		// exceeded, most first.
+		Offenders []QuotaOffender
+	}
+
+	// QuotaOffender is a deployment named by a QuotaExceeded, with what it
+	// would use.
+	QuotaOffender struct {
+		Source         SourceLocation
+		Instances      int
+		CPUs, MemoryMB float64
+	}
+)
+
+// maxQuotaOffenders is how many offenders a QuotaExceeded names.
+const maxQuotaOffenders = 3
+
+// IsZero reports whether q sets no limits at all.
+func (q Quota) IsZero() bool {
+	return q == Quota{}
+}
+
+// Quotas returns the quotas set by each of cs that sets any.
+func (cs Clusters) Quotas() Quotas {
+	qs := Quotas{}
+	for name, cl := range cs {
+		cq := ClusterQuotas{Name: name, Capacity: cl.Capacity}
+		for owner, q := range cl.OwnerQuotas {
+			if q.IsZero() {
+				continue
+			}
+			if cq.Owners == nil {
+				cq.Owners = map[string]Quota{}
+			}
+			cq.Owners[owner] = q
+		}
+		if !cq.Capacity.IsZero() || len(cq.Owners) != 0 {
+			qs[ClusterName(cl.BaseURL)] = cq
+		}
+	}
+	return qs
+}
+
+// Check sums the cpus and memory of all the instances of ds in each cluster
+// of qs, and returns a *QuotaExceeded for each quota they exceed, ordered by
+// cluster and then owner, or nil if they exceed none. Resources missing from
+// a deployment count as their ResourceRules default.
+func (qs Quotas) Check(ds Deployments) []*QuotaExceeded {
+	byCluster := map[ClusterName]Deployments{}
+	for _, d := range ds {
+		if _, ok := qs[d.Cluster]; ok {
+			byCluster[d.Cluster] = append(byCluster[d.Cluster], d)
+		}
+	}
+	var exceeded []*QuotaExceeded
+	for url, cq := range qs {
+		ds := byCluster[url]
+		if err := cq.Capacity.check(cq.Name, "", ds); err != nil {
+			exceeded = append(exceeded, err)
+		}
+		for owner, q := range cq.Owners {
+			owned := Deployments{}
+			for _, d := range ds {
+				if _, ok := d.Owners[owner]; ok {
+					owned = append(owned, d)
+				}
+			}
+			if err := q.check(cq.Name, owner, owned); err != nil {
+				exceeded = append(exceeded, err)
+			}
+		}
+	}
+	sort.Slice(exceeded, func(i, j int) bool {
+		if exceeded[i].Cluster != exceeded[j].Cluster {
+			return exceeded[i].Cluster < exceeded[j].Cluster
+		}
+		return exceeded[i].Owner < exceeded[j].Owner
+	})
+	return exceeded
+}
+
+// check returns a *QuotaExceeded if ds exceed q, or else nil.
+func (q Quota) check(cluster, owner string, ds Deployments) *QuotaExceeded {
+	if q.IsZero() {
+		return nil
+	}
+	e := &QuotaExceeded{Cluster: cluster, Owner: owner, Quota: q}
+	offenders := make([]QuotaOffender, len(ds))
+	for i, d := range ds {
+		n := float64(d.NumInstances)
+		offenders[i] = QuotaOffender{
+			Source:    d.SourceVersion.CanonicalName(),
+			Instances: d.NumInstances,
+			CPUs:      n * d.Resources.value("cpus").num,
+			MemoryMB:  n * d.Resources.value("memory").num,
+		}
+		e.CPUs += offenders[i].CPUs
+		e.MemoryMB += offenders[i].MemoryMB
+	}
+
+	// share is how much of the limits exceeded o uses, by the largest
+	// fraction of any of them
+	var shares []func(QuotaOffender) float64
+	if q.CPUs > 0 && e.CPUs > q.CPUs+resourceTolerance {
+		e.Exceeded = append(e.Exceeded, fmt.Sprintf("%s cpus, more than the limit of %s",
+			formatQuantity(e.CPUs), formatQuantity(q.CPUs)))
+		shares = append(shares, func(o QuotaOffender) float64 { return o.CPUs / q.CPUs })
+	}
+	if q.MemoryMB > 0 && e.MemoryMB > q.MemoryMB+resourceTolerance {
+		e.Exceeded = append(e.Exceeded, fmt.Sprintf("%s MB of memory, more than the limit of %s",
+			formatQuantity(e.MemoryMB), formatQuantity(q.MemoryMB)))
+		shares = append(shares, func(o QuotaOffender) float64 { return o.MemoryMB / q.MemoryMB })
+	}
+	if q.MaxInstances > 0 {
+		over := 0
+		for _, o := range offenders {
+			if o.Instances > q.MaxInstances {
+				over++
+			}
+		}
+		if over != 0 {
+			e.Exceeded = append(e.Exceeded, fmt.Sprintf("%d deployments with more than the limit of %d instances",
+				over, q.MaxInstances))
+			shares = append(shares, func(o QuotaOffender) float64 {
+				if o.Instances <= q.MaxInstances {
+					return 0
+				}
+				return float64(o.Instances) / float64(q.MaxInstances)
+			})
+		}
+	}
+	if len(e.Exceeded) == 0 {
+		return nil
+	}
+
+	share := func(o QuotaOffender) float64 {
+		max := 0.0
+		for _, s := range shares {
+			if f := s(o); f > max {
+				max = f
+			}
+		}
+		return max
+	}
+	sort.SliceStable(offenders, func(i, j int) bool {
+		si, sj := share(offenders[i]), share(offenders[j])
+		if si != sj {
+			return si > sj
+		}
+		return offenders[i].Source.String() < offenders[j].Source.String()
+	})
+	for _, o := range offenders {
+		if len(e.Offenders) == maxQuotaOffenders || share(o) == 0 {
+			break
+		}
+		e.Offenders = append(e.Offenders, o)
+	}
+	return e
+}
+
+func formatQuantity(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func (o QuotaOffender) String() string {
+	return fmt.Sprintf("%s (%d instances, %s cpus, %s MB)",
+		o.Source, o.Instances, formatQuantity(o.CPUs), formatQuantity(o.MemoryMB))
+}
+
+func (e *QuotaExceeded) Error() string {
+	whose := "cluster " + e.Cluster
+	if e.Owner != "" {
+		whose = fmt.Sprintf("owner %s in cluster %s", e.Owner, e.Cluster)
+	}
+	msg := fmt.Sprintf("quota of %s exceeded: %s", whose, strings.Join(e.Exceeded, ", and "))
+	if len(e.Offenders) == 0 {
+		return msg
+	}
+	offenders := make([]string, len(e.Offenders))
+	for i, o := range e.Offenders {
+		offenders[i] = o.String()
+	}
+	return msg + "; most by " + strings.Join(offenders, ", ")
+}
+
+// Intended returns the deployments r would leave running: those created,
+// retained, and modified, as modified.
+func (r DiffReport) Intended() Deployments {
+	ds := make(Deployments, 0, len(r.Created)+len(r.Retained)+len(r.Modified))
+	ds = append(ds, r.Created...)
+	ds = append(ds, r.Retained...)
+	for _, p := range r.Modified {
+		ds = append(ds, p.Post())
+	}
+	return ds
+}
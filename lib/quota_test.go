@@ -0,0 +1,171 @@
+package sous
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func quotaDeployment(repo string, instances int, cpus, memory string, owners ...string) *Deployment {
+	d := validDeployment()
+	d.SourceVersion.RepoURL = RepoURL(repo)
+	d.Cluster = "http://west"
+	d.NumInstances = instances
+	d.Resources = Resources{"cpus": cpus, "memory": memory}
+	d.Owners = OwnerSet{}
+	for _, o := range owners {
+		d.Owners.Add(o)
+	}
+	return d
+}
+
+func TestClustersQuotas(t *testing.T) {
+	cs := Clusters{
+		"west": {BaseURL: "http://west", Capacity: Quota{CPUs: 4}},
+		"east": {BaseURL: "http://east", OwnerQuotas: map[string]Quota{"a": {MaxInstances: 2}, "b": {}}},
+		"none": {BaseURL: "http://none"},
+	}
+	assert.Equal(t, Quotas{
+		"http://west": {Name: "west", Capacity: Quota{CPUs: 4}},
+		"http://east": {Name: "east", Owners: map[string]Quota{"a": {MaxInstances: 2}}},
+	}, cs.Quotas())
+}
+
+func TestQuotasCheck(t *testing.T) {
+	ds := Deployments{
+		quotaDeployment("small", 1, "1", "100", "a"),
+		quotaDeployment("wide", 4, "0.5", "512", "a"),
+		quotaDeployment("big", 2, "1.5", "1024", "b"),
+		// memory defaults to 100
+		quotaDeployment("default", 1, "0.5", "", "b"),
+	}
+	ds[3].Resources = Resources{"cpus": "0.5"}
+	elsewhere := quotaDeployment("elsewhere", 100, "100", "100000", "a")
+	elsewhere.Cluster = "http://east"
+	ds = append(ds, elsewhere)
+
+	testCases := []struct {
+		name     string
+		quota    Quota
+		exceeded []string
+		// offenders are the repos of the offenders, most first
+		offenders []string
+	}{
+		{"none", Quota{}, nil, nil},
+		{"within", Quota{CPUs: 6.5, MemoryMB: 4296, MaxInstances: 4}, nil, nil},
+		{"cpus", Quota{CPUs: 6},
+			[]string{"6.5 cpus, more than the limit of 6"},
+			[]string{"big", "wide", "small"}},
+		{"memory", Quota{MemoryMB: 4000},
+			[]string{"4296 MB of memory, more than the limit of 4000"},
+			// ties are broken by name
+			[]string{"big", "wide", "default"}},
+		{"instances", Quota{MaxInstances: 1},
+			[]string{"2 deployments with more than the limit of 1 instances"},
+			[]string{"wide", "big"}},
+		{"all", Quota{CPUs: 1, MemoryMB: 1, MaxInstances: 3}, []string{
+			"6.5 cpus, more than the limit of 1",
+			"4296 MB of memory, more than the limit of 1",
+			"1 deployments with more than the limit of 3 instances",
+		}, []string{"big", "wide", "default"}},
+	}
+	for _, tc := range testCases {
+		qs := Quotas{"http://west": {Name: "west", Capacity: tc.quota}}
+		es := qs.Check(ds)
+		if tc.exceeded == nil {
+			assert.Empty(t, es, tc.name)
+			continue
+		}
+		if !assert.Len(t, es, 1, tc.name) {
+			continue
+		}
+		assert.Equal(t, "west", es[0].Cluster)
+		assert.Equal(t, 6.5, es[0].CPUs)
+		assert.Equal(t, 4296.0, es[0].MemoryMB)
+		assert.Equal(t, tc.exceeded, es[0].Exceeded, tc.name)
+		var offenders []string
+		for _, o := range es[0].Offenders {
+			offenders = append(offenders, string(o.Source.RepoURL))
+		}
+		assert.Equal(t, tc.offenders, offenders, tc.name)
+	}
+}
+
+func TestQuotasCheck_Owners(t *testing.T) {
+	assert := assert.New(t)
+
+	ds := Deployments{
+		quotaDeployment("one", 2, "1", "100", "a"),
+		quotaDeployment("two", 2, "1", "100", "a", "b"),
+		quotaDeployment("three", 2, "1", "100", "b"),
+	}
+	qs := Quotas{
+		"http://west": {Name: "west", Capacity: Quota{CPUs: 5}, Owners: map[string]Quota{
+			"a": {CPUs: 4},
+			"b": {CPUs: 3},
+		}},
+	}
+	es := qs.Check(ds)
+	if assert.Len(es, 2) {
+		assert.Equal("", es[0].Owner)
+		assert.Equal(6.0, es[0].CPUs)
+		assert.Equal("b", es[1].Owner)
+		assert.Equal(4.0, es[1].CPUs)
+	}
+}
+
+func TestQuotaExceeded_Error(t *testing.T) {
+	qs := Quotas{"http://west": {Name: "west", Owners: map[string]Quota{"a": {CPUs: 2, MaxInstances: 3}}}}
+	es := qs.Check(Deployments{
+		quotaDeployment("github.com/opentable/one", 4, "1", "100", "a"),
+		quotaDeployment("github.com/opentable/two", 1, "0.5", "100", "a"),
+	})
+	if assert.Len(t, es, 1) {
+		assert.EqualError(t, es[0], "quota of owner a in cluster west exceeded: "+
+			"4.5 cpus, more than the limit of 2, "+
+			"and 1 deployments with more than the limit of 3 instances; "+
+			"most by github.com/opentable/one (4 instances, 4 cpus, 400 MB), "+
+			"github.com/opentable/two (1 instances, 0.5 cpus, 100 MB)")
+	}
+}
+
+func quotaPlan() DiffChans {
+	dcs := NewDiffChans(3)
+	dcs.Created <- quotaDeployment("created", 2, "1", "100")
+	dcs.Retained <- quotaDeployment("retained", 1, "1", "100")
+	dcs.Deleted <- quotaDeployment("deleted", 10, "1", "100")
+	dcs.Close()
+	return dcs
+}
+
+func TestRectifyPlanWith_QuotaExceeded(t *testing.T) {
+	assert := assert.New(t)
+
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	var reported []error
+	err := RectifyPlanWith(client, quotaPlan(),
+		RectifyOpts{Quotas: Quotas{"http://west": {Name: "west", Capacity: Quota{CPUs: 2}}}},
+		func(err error) { reported = append(reported, err) })
+
+	if assert.Len(reported, 1) {
+		if assert.IsType(&QuotaExceeded{}, reported[0]) {
+			// the deleted deployment isn't counted
+			assert.Equal(3.0, reported[0].(*QuotaExceeded).CPUs)
+		}
+		assert.Equal(&ResolveErrors{reported}, err)
+	}
+	assert.Empty(client.Calls())
+}
+
+func TestRectifyPlanWith_IgnoreQuotas(t *testing.T) {
+	assert := assert.New(t)
+
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	err := RectifyPlanWith(client, quotaPlan(),
+		RectifyOpts{Quotas: Quotas{"http://west": {Name: "west", Capacity: Quota{CPUs: 2}}}, IgnoreQuotas: true},
+		func(err error) { t.Error(err) })
+
+	assert.NoError(err)
+	assert.Len(client.CallsTo("Deploy"), 1)
+	assert.Len(client.CallsTo("DeleteRequest"), 1)
+}
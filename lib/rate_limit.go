@@ -0,0 +1,76 @@
+package sous
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// ClusterRateLimiter limits the rate of calls made against each cluster
+	// using a token bucket per cluster. A single ClusterRateLimiter is safe
+	// to share between goroutines. A nil *ClusterRateLimiter never limits.
+	ClusterRateLimiter struct {
+		perSecond float64
+		burst     float64
+		sync.Mutex
+		buckets map[ClusterName]*tokenBucket
+	}
+
+	tokenBucket struct {
+		tokens float64
+		last   time.Time
+	}
+)
+
+// NewClusterRateLimiter returns a ClusterRateLimiter which allows perSecond
+// calls per second against each cluster, with bursts of up to burst calls.
+func NewClusterRateLimiter(perSecond float64, burst int) *ClusterRateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &ClusterRateLimiter{
+		perSecond: perSecond,
+		burst:     float64(burst),
+		buckets:   make(map[ClusterName]*tokenBucket),
+	}
+}
+
+// Wait blocks until a call may be made against cluster, and returns how long
+// it waited.
+func (l *ClusterRateLimiter) Wait(cluster ClusterName) time.Duration {
+	return l.wait(cluster, SystemClock)
+}
+
+// wait is Wait, telling the time and waiting for it to pass with clock.
+func (l *ClusterRateLimiter) wait(cluster ClusterName, clock Clock) time.Duration {
+	if l == nil || l.perSecond <= 0 {
+		return 0
+	}
+	wait := l.reserve(cluster, clock.Now())
+	if wait > 0 {
+		<-clock.After(wait)
+	}
+	return wait
+}
+
+// reserve takes a token from cluster's bucket, possibly going into debt, and
+// returns how long the caller must wait before the token is really available.
+func (l *ClusterRateLimiter) reserve(cluster ClusterName, now time.Time) time.Duration {
+	l.Lock()
+	defer l.Unlock()
+	b, ok := l.buckets[cluster]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[cluster] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.perSecond
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / l.perSecond * float64(time.Second))
+}
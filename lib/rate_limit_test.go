@@ -0,0 +1,76 @@
+package sous
+
+import (
+	"testing"
+	"time"
+
+	"github.com/opentable/sous/lib/clocktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNilRateLimiter(t *testing.T) {
+	var l *ClusterRateLimiter
+	assert.Equal(t, time.Duration(0), l.Wait("cluster"))
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	assert := assert.New(t)
+	l := NewClusterRateLimiter(10, 2)
+	now := time.Now()
+
+	assert.Equal(time.Duration(0), l.reserve("a", now))
+	assert.Equal(time.Duration(0), l.reserve("a", now))
+	assert.Equal(100*time.Millisecond, l.reserve("a", now))
+	assert.Equal(200*time.Millisecond, l.reserve("a", now))
+
+	// Clusters don't share buckets.
+	assert.Equal(time.Duration(0), l.reserve("b", now))
+
+	// Tokens refill with time, up to the burst size.
+	later := now.Add(time.Second)
+	assert.Equal(time.Duration(0), l.reserve("a", later))
+	assert.Equal(time.Duration(0), l.reserve("a", later))
+	assert.NotEqual(time.Duration(0), l.reserve("a", later))
+}
+
+func TestRectifyWithRateLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	chanset := NewDiffChans(3)
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	clock := clocktest.NewClock(time.Now())
+	opts := RectifyOpts{Limiter: NewClusterRateLimiter(10, 1), Clock: clock}
+
+	errs := RectifyWith(chanset, client, opts)
+	for _, repo := range []string{"one", "two", "three"} {
+		chanset.Deleted <- &Deployment{
+			SourceVersion: SourceVersion{RepoURL: RepoURL(repo)},
+			Cluster:       "cluster",
+		}
+	}
+	chanset.Close()
+
+	done := make(chan struct{})
+	go func() {
+		for e := range errs {
+			t.Error(e)
+		}
+		close(done)
+	}()
+
+	// The first delete is allowed straight away, and each of the others
+	// waits for the clock to move on by a tenth of a second.
+	start := clock.Now()
+	for waiting := true; waiting; {
+		select {
+		case <-done:
+			waiting = false
+		case <-time.After(time.Millisecond):
+			if clock.Waiters() > 0 {
+				clock.Advance(100 * time.Millisecond)
+			}
+		}
+	}
+	assert.Equal(200*time.Millisecond, clock.Now().Sub(start))
+	assert.Len(client.deleted, 3)
+}
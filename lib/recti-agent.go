@@ -1,11 +1,12 @@
 package sous
 
 import (
+	"fmt"
+	"net/http"
 	"sync"
 
 	"github.com/opentable/go-singularity"
 	"github.com/opentable/go-singularity/dtos"
-	"github.com/satori/go.uuid"
 )
 
 // RectiAgent is an implementation of the RectificationClient interface
@@ -24,7 +25,103 @@ func NewRectiAgent(nc ImageMapper) *RectiAgent {
 }
 
 // Deploy sends requests to Singularity to make a deployment happen
-func (ra *RectiAgent) Deploy(cluster, depID, reqID, dockerImage string, r Resources, e Env, vols Volumes) error {
+func (ra *RectiAgent) Deploy(cluster ClusterName, depID, reqID, dockerImage string, r Resources, e Env, vols Volumes) error {
+	return ra.deploy(cluster, depID, reqID, dockerImage, r, e, vols, dtoMap{})
+}
+
+// DeployIncrementally starts a deploy that brings up instancesPerStep
+// instances of the new image, then waits for AdvanceDeploy
+func (ra *RectiAgent) DeployIncrementally(cluster ClusterName, depID, reqID, dockerImage string, r Resources, e Env, vols Volumes, instancesPerStep int) error {
+	return ra.deploy(cluster, depID, reqID, dockerImage, r, e, vols, dtoMap{
+		"DeployInstanceCountPerStep": int32(instancesPerStep),
+		"AutoAdvanceDeploySteps":     false,
+	})
+}
+
+// AdvanceDeploy moves a pending incremental deploy on to its next step
+func (ra *RectiAgent) AdvanceDeploy(cluster ClusterName, reqID, depID string, targetInstances int) error {
+	Log.Debug.Printf("Advancing deploy %s %s %s to %d", cluster, reqID, depID, targetInstances)
+	ur, err := dtos.LoadMap(&dtos.SingularityUpdatePendingDeployRequest{}, dtoMap{
+		"RequestId":             reqID,
+		"DeployId":              depID,
+		"TargetActiveInstances": int32(targetInstances),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = ra.singularityClient(string(cluster)).UpdatePendingDeploy(ur.(*dtos.SingularityUpdatePendingDeployRequest))
+	return err
+}
+
+// DeployStatus reports how far a deploy has progressed
+func (ra *RectiAgent) DeployStatus(cluster ClusterName, reqID, depID string) (DeployStatus, error) {
+	client := ra.singularityClient(string(cluster))
+	pds, err := client.GetPendingDeploys()
+	if err != nil {
+		return DeployStatus{}, err
+	}
+	for _, pd := range pds {
+		dm := pd.DeployMarker
+		if dm == nil || dm.RequestId != reqID || dm.DeployId != depID {
+			continue
+		}
+		status := DeployStatus{Pending: true}
+		switch pd.CurrentDeployState {
+		case dtos.SingularityPendingDeployDeployStateFAILED,
+			dtos.SingularityPendingDeployDeployStateFAILED_INTERNAL_STATE,
+			dtos.SingularityPendingDeployDeployStateCANCELING,
+			dtos.SingularityPendingDeployDeployStateCANCELED:
+			status.Failed = true
+			status.Message = string(pd.CurrentDeployState)
+		}
+		if pd.DeployProgress != nil {
+			status.StepComplete = pd.DeployProgress.StepComplete
+			status.TargetInstances = int(pd.DeployProgress.TargetActiveInstances)
+		}
+		return status, nil
+	}
+
+	dh, err := client.GetDeploy(reqID, depID)
+	if err != nil {
+		return DeployStatus{}, err
+	}
+	if dh.DeployResult == nil {
+		return DeployStatus{}, malformedResponse{"Singularity deploy history included no result for " + depID}
+	}
+	status := DeployStatus{StepComplete: true, Message: dh.DeployResult.Message}
+	if dh.DeployResult.DeployState != dtos.SingularityDeployResultDeployStateSUCCEEDED {
+		status.Failed = true
+		if status.Message == "" {
+			status.Message = string(dh.DeployResult.DeployState)
+		}
+	}
+	return status, nil
+}
+
+// PendingDeploy reports whether a deploy is pending on a request, and if
+// so, its ID
+func (ra *RectiAgent) PendingDeploy(cluster ClusterName, reqID string) (bool, string, error) {
+	pds, err := ra.singularityClient(string(cluster)).GetPendingDeploys()
+	if err != nil {
+		return false, "", err
+	}
+	for _, pd := range pds {
+		if dm := pd.DeployMarker; dm != nil && dm.RequestId == reqID {
+			return true, dm.DeployId, nil
+		}
+	}
+	return false, "", nil
+}
+
+// CancelDeploy cancels a pending deploy
+func (ra *RectiAgent) CancelDeploy(cluster ClusterName, reqID, depID string) error {
+	Log.Debug.Printf("Cancelling deploy %s %s %s", cluster, reqID, depID)
+	_, err := ra.singularityClient(string(cluster)).CancelDeploy(reqID, depID)
+	return err
+}
+
+func (ra *RectiAgent) deploy(cluster ClusterName, depID, reqID, dockerImage string, r Resources, e Env, vols Volumes, extra dtoMap) error {
 	Log.Debug.Printf("Deploying instance %s %s %s %s %v %v", cluster, depID, reqID, dockerImage, r, e)
 	dockerInfo, err := dtos.LoadMap(&dtos.SingularityDockerInfo{}, dtoMap{
 		"Image": dockerImage,
@@ -60,13 +157,17 @@ func (ra *RectiAgent) Deploy(cluster, depID, reqID, dockerImage string, r Resour
 		return err
 	}
 
-	dep, err := dtos.LoadMap(&dtos.SingularityDeploy{}, dtoMap{
-		"Id":            idify(uuid.NewV4().String()),
+	depMap := dtoMap{
+		"Id":            depID,
 		"RequestId":     reqID,
 		"Resources":     res,
 		"ContainerInfo": ci,
 		"Env":           map[string]string(e),
-	})
+	}
+	for k, v := range extra {
+		depMap[k] = v
+	}
+	dep, err := dtos.LoadMap(&dtos.SingularityDeploy{}, depMap)
 	Log.Debug.Printf("Deploy: %+ v", dep)
 
 	depReq, err := dtos.LoadMap(&dtos.SingularityDeployRequest{}, dtoMap{"Deploy": dep})
@@ -75,47 +176,104 @@ func (ra *RectiAgent) Deploy(cluster, depID, reqID, dockerImage string, r Resour
 	}
 
 	Log.Debug.Printf("Deploy req: %+ v", depReq)
-	_, err = ra.singularityClient(cluster).Deploy(depReq.(*dtos.SingularityDeployRequest))
-	return err
+	_, err = ra.singularityClient(string(cluster)).Deploy(depReq.(*dtos.SingularityDeployRequest))
+	return ra.deployConflict(cluster, depID, reqID, dockerImage, r, e, vols, err)
+}
+
+// deployConflict checks whether an error returned from a deploy was caused by
+// the deploy ID already being in use, and if so returns a *DeployIDConflict
+// recording whether the existing deploy has the same content, and whether
+// it's the request's active or pending deploy.
+func (ra *RectiAgent) deployConflict(cluster ClusterName, depID, reqID, dockerImage string, r Resources, e Env, vols Volumes, err error) error {
+	rerr, ok := err.(*singularity.ReqError)
+	if !ok || (rerr.Status != http.StatusBadRequest && rerr.Status != http.StatusConflict) {
+		return err
+	}
+	client := ra.singularityClient(string(cluster))
+	dh, gerr := client.GetDeploy(reqID, depID)
+	if gerr != nil || dh.Deploy == nil {
+		return err
+	}
+	existing := dh.Deploy
+	same := existing.ContainerInfo != nil &&
+		existing.ContainerInfo.Docker != nil &&
+		existing.ContainerInfo.Docker.Image == dockerImage &&
+		existing.Resources != nil &&
+		existing.Resources.Cpus == r.cpus() &&
+		existing.Resources.MemoryMb == r.memory() &&
+		existing.Resources.NumPorts == int32(r.ports()) &&
+		e.Equal(existing.Env) &&
+		vols.Equal(singularityVolumes(existing.ContainerInfo.Volumes))
+
+	current := false
+	if rp, gerr := client.GetRequest(reqID); gerr == nil && rp.RequestDeployState != nil {
+		rds := rp.RequestDeployState
+		current = (rds.ActiveDeploy != nil && rds.ActiveDeploy.DeployId == depID) ||
+			(rds.PendingDeploy != nil && rds.PendingDeploy.DeployId == depID)
+	}
+	return &DeployIDConflict{DeployID: depID, SameContent: same, Current: current}
 }
 
 // PostRequest sends requests to Singularity to create a new Request
-func (ra *RectiAgent) PostRequest(cluster, reqID string, instanceCount int) error {
-	Log.Debug.Printf("Creating application %s %s %d", cluster, reqID, instanceCount)
-	req, err := dtos.LoadMap(&dtos.SingularityRequest{}, dtoMap{
+func (ra *RectiAgent) PostRequest(cluster ClusterName, reqID string, instanceCount int) error {
+	return ra.PostRequestOfKind(cluster, reqID, instanceCount, ManifestKindService, "")
+}
+
+// requestTypes maps each kind of manifest to the type of Singularity request
+// that runs it.
+var requestTypes = map[ManifestKind]dtos.SingularityRequestRequestType{
+	ManifestKindService:   dtos.SingularityRequestRequestTypeSERVICE,
+	ManifestKindWorker:    dtos.SingularityRequestRequestTypeWORKER,
+	ManifestKindOnDemand:  dtos.SingularityRequestRequestTypeON_DEMAND,
+	ManifestKindScheduled: dtos.SingularityRequestRequestTypeSCHEDULED,
+	ManifestKindOnce:      dtos.SingularityRequestRequestTypeRUN_ONCE,
+}
+
+// PostRequestOfKind implements KindPoster
+func (ra *RectiAgent) PostRequestOfKind(cluster ClusterName, reqID string, instanceCount int, kind ManifestKind, schedule string) error {
+	Log.Debug.Printf("Creating application %s %s %d %s", cluster, reqID, instanceCount, kind)
+	rt, ok := requestTypes[kind]
+	if !ok {
+		return fmt.Errorf("no Singularity request type for Kind %q", kind)
+	}
+	fields := dtoMap{
 		"Id":          reqID,
-		"RequestType": dtos.SingularityRequestRequestTypeSERVICE,
+		"RequestType": rt,
 		"Instances":   int32(instanceCount),
-	})
+	}
+	if kind == ManifestKindScheduled {
+		fields["Schedule"] = schedule
+	}
+	req, err := dtos.LoadMap(&dtos.SingularityRequest{}, fields)
 
 	if err != nil {
 		return err
 	}
 
 	Log.Debug.Printf("Create Request: %+ v", req)
-	_, err = ra.singularityClient(cluster).PostRequest(req.(*dtos.SingularityRequest))
+	_, err = ra.singularityClient(string(cluster)).PostRequest(req.(*dtos.SingularityRequest))
 	return err
 }
 
 // DeleteRequest sends a request to Singularity to delete a request
-func (ra *RectiAgent) DeleteRequest(cluster, reqID, message string) error {
+func (ra *RectiAgent) DeleteRequest(cluster ClusterName, reqID, message string) error {
 	Log.Debug.Printf("Deleting application %s %s %s", cluster, reqID, message)
 	req, err := dtos.LoadMap(&dtos.SingularityDeleteRequestRequest{}, dtoMap{
 		"Message": "Sous: " + message,
 	})
 
 	Log.Debug.Printf("Delete req: %+ v", req)
-	_, err = ra.singularityClient(cluster).DeleteRequest(reqID,
+	_, err = ra.singularityClient(string(cluster)).DeleteRequest(reqID,
 		req.(*dtos.SingularityDeleteRequestRequest))
 	return err
 }
 
 // Scale sends requests to Singularity to change the number of instances
 // running for a given Request
-func (ra *RectiAgent) Scale(cluster, reqID string, instanceCount int, message string) error {
+func (ra *RectiAgent) Scale(cluster ClusterName, reqID string, instanceCount int, message string) error {
 	Log.Debug.Printf("Scaling %s %s %d %s", cluster, reqID, instanceCount, message)
 	sr, err := dtos.LoadMap(&dtos.SingularityScaleRequest{}, dtoMap{
-		"ActionId": idify(uuid.NewV4().String()), // not positive this is appropriate
+		"ActionId": RandomID(), // not positive this is appropriate
 		// omitting DurationMillis - bears discussion
 		"Instances":        int32(instanceCount),
 		"Message":          "Sous" + message,
@@ -123,23 +281,78 @@ func (ra *RectiAgent) Scale(cluster, reqID string, instanceCount int, message st
 	})
 
 	Log.Debug.Printf("Scale req: %+ v", sr)
-	_, err = ra.singularityClient(cluster).Scale(reqID, sr.(*dtos.SingularityScaleRequest))
+	_, err = ra.singularityClient(string(cluster)).Scale(reqID, sr.(*dtos.SingularityScaleRequest))
 	return err
 }
 
+// RunningDeployments reads the active requests on a Singularity cluster and
+// reconstructs a Deployment from the current deploy of each
+func (ra *RectiAgent) RunningDeployments(cluster ClusterName) (Deployments, error) {
+	reqs, err := getRequestsFromSingularity(ra.singularityClient(string(cluster)))
+	if err != nil {
+		return nil, err
+	}
+
+	deps := make(Deployments, 0, len(reqs))
+	for _, req := range reqs {
+		if req.ReqParent.State != dtos.SingularityRequestParentRequestStateACTIVE {
+			continue
+		}
+		db := NewDeploymentBuilder(ra, req)
+		db.keepForeign = true
+		if err := db.CompleteConstruction(); err != nil {
+			return nil, err
+		}
+		db.Target.RequestID = req.ReqParent.Request.Id
+		deps = append(deps, &db.Target)
+	}
+	return deps, nil
+}
+
+// InspectRequest reads a single request from Singularity and reconstructs
+// the Deployment of its current deploy, as RunningDeployments does. It
+// returns nil if there's no such request.
+func (ra *RectiAgent) InspectRequest(cluster ClusterName, reqID string) (*Deployment, error) {
+	client := ra.singularityClient(string(cluster))
+	rp, err := client.GetRequest(reqID)
+	if rerr, ok := err.(*singularity.ReqError); ok && rerr.Status == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rp.Request == nil {
+		return nil, malformedResponse{"Singularity response didn't include a request. ReqID: " + reqID}
+	}
+	rds := rp.RequestDeployState
+	if rds == nil || (rds.PendingDeploy == nil && rds.ActiveDeploy == nil) {
+		// posted, but never deployed
+		d := &Deployment{Cluster: cluster, Annotation: Annotation{RequestID: reqID}}
+		d.NumInstances = int(rp.Request.Instances)
+		return d, nil
+	}
+	db := NewDeploymentBuilder(ra, SingReq{client.BaseUrl, client, rp})
+	db.keepForeign = true
+	if err := db.CompleteConstruction(); err != nil {
+		return nil, err
+	}
+	db.Target.RequestID = reqID
+	return &db.Target, nil
+}
+
 // ImageName gets the container image name for a given deployment
 func (ra *RectiAgent) ImageName(d *Deployment) (string, error) {
 	return ra.nameCache.GetImageName(d.SourceVersion)
 }
 
-// ImageLabels gets the labels for an image name
+// ImageLabels gets the labels for an image name from the name cache, which
+// only asks the registry for images it doesn't know yet
 func (ra *RectiAgent) ImageLabels(in string) (map[string]string, error) {
-	sv, err := ra.nameCache.GetSourceVersion(in)
+	labels, err := ra.nameCache.GetLabels(in)
 	if err != nil {
 		return map[string]string{}, err
 	}
-
-	return sv.DockerLabels(), nil
+	return labels, nil
 }
 
 func (ra *RectiAgent) getSingularityClient(url string) (*singularity.Client, bool) {
@@ -0,0 +1,240 @@
+package sous
+
+import (
+	"testing"
+
+	"github.com/samsalisbury/semv"
+)
+
+// ConformanceCluster is the cluster TestRectificationClient acts on.
+const ConformanceCluster ClusterName = "conformance"
+
+const (
+	conformanceReqID  = "conformance-one"
+	conformanceImage  = "docker.example.com/conformance/one:1.0.0"
+	conformanceImage2 = "docker.example.com/conformance/one:1.1.0"
+)
+
+// TestRectificationClient checks the RectificationClients built by factory
+// against the behaviour documented on RectificationClient, each test with a
+// fresh one, so that implementations for other schedulers can be held to the
+// contract the rectifier relies on. factory must return a client for a
+// cluster named ConformanceCluster that has no requests on it, and whose
+// ImageMapper knows no images.
+func TestRectificationClient(t *testing.T, factory func() RectificationClient) {
+	tests := []struct {
+		name string
+		test func(*testing.T, RectificationClient)
+	}{
+		{"Lifecycle", testClientLifecycle},
+		{"PostRequestTwice", testClientPostRequestTwice},
+		{"DeployIDConflict", testClientDeployIDConflict},
+		{"MissingRequest", testClientMissingRequest},
+		{"ImageMisses", testClientImageMisses},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			tc.test(t, factory())
+		})
+	}
+}
+
+func conformanceResources() Resources {
+	return Resources{"cpus": "0.1", "memory": "100", "ports": "1"}
+}
+
+func conformanceVolumes() Volumes {
+	return Volumes{{Host: "/tmp", Container: "/scratch", Mode: "RO"}}
+}
+
+// deployConformance deploys image as depID on the conformance request,
+// failing t if it can't.
+func deployConformance(t *testing.T, c RectificationClient, depID, image string) {
+	err := c.Deploy(ConformanceCluster, depID, conformanceReqID, image,
+		conformanceResources(), Env{"GREETING": "hello"}, conformanceVolumes())
+	if err != nil {
+		t.Fatalf("Deploy(%s, %s): %v", depID, image, err)
+	}
+}
+
+// running returns the deployment c reports running on the request reqID, or
+// nil if there isn't one, failing t if the request is listed more than once.
+func running(t *testing.T, c RectificationClient, reqID string) *Deployment {
+	ds, err := c.RunningDeployments(ConformanceCluster)
+	if err != nil {
+		t.Fatalf("RunningDeployments: %v", err)
+	}
+	var found *Deployment
+	for _, d := range ds {
+		if d.RequestID != reqID {
+			continue
+		}
+		if found != nil {
+			t.Fatalf("RunningDeployments listed %s more than once", reqID)
+		}
+		found = d
+	}
+	return found
+}
+
+func testClientLifecycle(t *testing.T, c RectificationClient) {
+	if ds, err := c.RunningDeployments(ConformanceCluster); err != nil || len(ds) != 0 {
+		t.Fatalf("RunningDeployments of an empty cluster = %v, %v; want nothing", ds, err)
+	}
+
+	if err := c.PostRequest(ConformanceCluster, conformanceReqID, 2); err != nil {
+		t.Fatalf("PostRequest: %v", err)
+	}
+	deployConformance(t, c, "dep1", conformanceImage)
+	d := running(t, c, conformanceReqID)
+	if d == nil {
+		t.Fatalf("RunningDeployments didn't list %s once it was deployed", conformanceReqID)
+	}
+	if d.NumInstances != 2 {
+		t.Errorf("running NumInstances = %d; want 2", d.NumInstances)
+	}
+	if d.ForeignImage != conformanceImage {
+		t.Errorf("running ForeignImage = %q; want %q, which the ImageMapper doesn't know", d.ForeignImage, conformanceImage)
+	}
+	if !d.Resources.Equal(conformanceResources()) {
+		t.Errorf("running Resources = %v; want %v", d.Resources, conformanceResources())
+	}
+	if !d.Env.Equal(Env{"GREETING": "hello"}) {
+		t.Errorf("running Env = %v; want GREETING=hello", d.Env)
+	}
+	if !d.DeployConfig.Volumes.Equal(conformanceVolumes()) {
+		t.Errorf("running Volumes = %v; want %v", d.DeployConfig.Volumes, conformanceVolumes())
+	}
+
+	if err := c.Scale(ConformanceCluster, conformanceReqID, 3, "scaling"); err != nil {
+		t.Fatalf("Scale: %v", err)
+	}
+	if d := running(t, c, conformanceReqID); d == nil || d.NumInstances != 3 {
+		t.Errorf("running %s after Scale to 3 = %v; want 3 instances", conformanceReqID, d)
+	}
+
+	deployConformance(t, c, "dep2", conformanceImage2)
+	rs, err := c.DeployHistory(ConformanceCluster, conformanceReqID, 5)
+	if err != nil {
+		t.Fatalf("DeployHistory: %v", err)
+	}
+	if len(rs) != 2 || rs[0].DeployID != "dep2" || rs[1].DeployID != "dep1" {
+		t.Fatalf("DeployHistory = %v; want dep2, then dep1", rs)
+	}
+	if rs[0].ImageName != conformanceImage2 || rs[0].SourceVersion != nil {
+		t.Errorf("DeployHistory gave %q, %v for dep2; want %q with no source version", rs[0].ImageName, rs[0].SourceVersion, conformanceImage2)
+	}
+	if rs, err := c.DeployHistory(ConformanceCluster, conformanceReqID, 1); err != nil || len(rs) != 1 || rs[0].DeployID != "dep2" {
+		t.Errorf("DeployHistory of 1 deploy = %v, %v; want dep2", rs, err)
+	}
+	if d := running(t, c, conformanceReqID); d == nil || d.ForeignImage != conformanceImage2 {
+		t.Errorf("running %s after the second deploy = %v; want %s", conformanceReqID, d, conformanceImage2)
+	}
+
+	if err := c.DeleteRequest(ConformanceCluster, conformanceReqID, "deleting"); err != nil {
+		t.Fatalf("DeleteRequest: %v", err)
+	}
+	if d := running(t, c, conformanceReqID); d != nil {
+		t.Errorf("RunningDeployments listed %s after it was deleted", conformanceReqID)
+	}
+}
+
+func testClientPostRequestTwice(t *testing.T, c RectificationClient) {
+	for _, n := range []int{1, 3} {
+		if err := c.PostRequest(ConformanceCluster, conformanceReqID, n); err != nil {
+			t.Fatalf("PostRequest with %d instances: %v", n, err)
+		}
+	}
+	deployConformance(t, c, "dep1", conformanceImage)
+	if d := running(t, c, conformanceReqID); d == nil || d.NumInstances != 3 {
+		t.Errorf("running %s = %v; want the 3 instances it was last posted with", conformanceReqID, d)
+	}
+}
+
+func testClientDeployIDConflict(t *testing.T, c RectificationClient) {
+	if err := c.PostRequest(ConformanceCluster, conformanceReqID, 1); err != nil {
+		t.Fatalf("PostRequest: %v", err)
+	}
+	deployConformance(t, c, "dep1", conformanceImage)
+
+	bigger := conformanceResources()
+	bigger["cpus"] = "0.5"
+	conflicts := []struct {
+		image string
+		res   Resources
+		vols  Volumes
+		same  bool
+	}{
+		{conformanceImage, conformanceResources(), conformanceVolumes(), true},
+		{conformanceImage2, conformanceResources(), conformanceVolumes(), false},
+		{conformanceImage, bigger, conformanceVolumes(), false},
+		{conformanceImage, conformanceResources(), Volumes{}, false},
+	}
+	deployAgain := func(image string, res Resources, vols Volumes) *DeployIDConflict {
+		err := c.Deploy(ConformanceCluster, "dep1", conformanceReqID, image, res, Env{"GREETING": "hello"}, vols)
+		conflict, ok := err.(*DeployIDConflict)
+		if !ok {
+			t.Errorf("Deploy of dep1 again with %s %v %v returned %T %v; want a *DeployIDConflict", image, res, vols, err, err)
+		}
+		return conflict
+	}
+	for _, tc := range conflicts {
+		conflict := deployAgain(tc.image, tc.res, tc.vols)
+		if conflict != nil && (conflict.DeployID != "dep1" || conflict.SameContent != tc.same || !conflict.Current) {
+			t.Errorf("Deploy of dep1 again with %s %v %v returned %+v; want SameContent %t of the current deploy",
+				tc.image, tc.res, tc.vols, conflict, tc.same)
+		}
+	}
+
+	// once dep2 has replaced it, dep1 is no longer applied
+	deployConformance(t, c, "dep2", conformanceImage2)
+	if conflict := deployAgain(conformanceImage, conformanceResources(), conformanceVolumes()); conflict != nil &&
+		(!conflict.SameContent || conflict.Current || conflict.Applied()) {
+		t.Errorf("Deploy of dep1 again after dep2 returned %+v; want SameContent of a deploy that isn't current", conflict)
+	}
+}
+
+func testClientMissingRequest(t *testing.T, c RectificationClient) {
+	const missing = "conformance-missing"
+	notFound := func(method string, err error) {
+		if err == nil {
+			return
+		}
+		if nf, ok := err.(*RequestNotFound); !ok || nf.RequestID != missing {
+			t.Errorf("%s of a missing request returned %T %v; want nil or a *RequestNotFound", method, err, err)
+		}
+	}
+	notFound("Scale", c.Scale(ConformanceCluster, missing, 2, "scaling"))
+	notFound("DeleteRequest", c.DeleteRequest(ConformanceCluster, missing, "deleting"))
+	if d := running(t, c, missing); d != nil {
+		t.Errorf("RunningDeployments listed %s, which was never posted", missing)
+	}
+
+	pending, depID, err := c.PendingDeploy(ConformanceCluster, missing)
+	if pending || depID != "" || err != nil {
+		t.Errorf("PendingDeploy of a missing request = %t, %q, %v; want nothing pending", pending, depID, err)
+	}
+}
+
+func testClientImageMisses(t *testing.T, c RectificationClient) {
+	d := &Deployment{
+		SourceVersion: SourceVersion{RepoURL: "github.com/opentable/conformance", Version: semv.MustParse("1.0.0")},
+		Cluster:       ConformanceCluster,
+	}
+	in, err := c.ImageName(d)
+	if _, ok := err.(NoImageNameFound); !ok {
+		t.Errorf("ImageName of an unknown source version returned %T %v; want NoImageNameFound", err, err)
+	}
+	if in != "" {
+		t.Errorf("ImageName of an unknown source version returned %q with its error; want nothing", in)
+	}
+
+	labels, err := c.ImageLabels(conformanceImage)
+	if _, ok := err.(NoSourceVersionFound); !ok {
+		t.Errorf("ImageLabels of an unknown image returned %T %v; want NoSourceVersionFound", err, err)
+	}
+	if len(labels) != 0 {
+		t.Errorf("ImageLabels of an unknown image returned %v with its error; want nothing", labels)
+	}
+}
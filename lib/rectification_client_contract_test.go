@@ -0,0 +1,45 @@
+package sous
+
+import (
+	"testing"
+
+	"github.com/opentable/sous/lib/singularitytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func contractNameCache(name string) ImageMapper {
+	return NewNameCacheWithOptions(nil, []string{"sqlite3", InMemoryConnection(name)}, NameCacheOffline())
+}
+
+func TestRectificationClient_Dummy(t *testing.T) {
+	TestRectificationClient(t, func() RectificationClient {
+		return NewDummyRectificationClient(contractNameCache("contractdummy"))
+	})
+}
+
+func TestRectificationClient_Singularity(t *testing.T) {
+	servers := []*singularitytest.Fake{}
+	defer func() {
+		for _, fs := range servers {
+			fs.Close()
+		}
+	}()
+	TestRectificationClient(t, func() RectificationClient {
+		fs := singularitytest.NewFake()
+		servers = append(servers, fs)
+		return NewSingularityClient(map[string]string{string(ConformanceCluster): fs.URL},
+			contractNameCache("contractsingularity"))
+	})
+}
+
+func TestSingularityClient_RequestNotFound(t *testing.T) {
+	fs := singularitytest.NewFake()
+	defer fs.Close()
+	sc := NewSingularityClient(map[string]string{"test": fs.URL}, NewDummyNameCache())
+
+	err := sc.Scale("test", "missing", 2, "scaling")
+	assert.Equal(t, &RequestNotFound{Cluster: "test", RequestID: "missing"}, err)
+	assert.Equal(t, ValidationError, classifyError(err))
+	err = sc.DeleteRequest("test", "missing", "deleting")
+	assert.Equal(t, &RequestNotFound{Cluster: "test", RequestID: "missing"}, err)
+}
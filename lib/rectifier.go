@@ -1,11 +1,16 @@
 package sous
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"regexp"
-	"sync"
+	"io"
+	"sort"
+	"strings"
+	"time"
 
-	"github.com/satori/go.uuid"
+	"github.com/opentable/sous/util/logging"
+	"github.com/opentable/sous/util/metrics"
 )
 
 /*
@@ -22,30 +27,209 @@ Rectify(dChans)
 type (
 	rectifier struct {
 		sing RectificationClient
+		RectifyOpts
+		// breaker is shared by all the operations the rectifier runs
+		breaker *ClusterBreaker
+	}
+
+	// RectifyOpts configures the behaviour of RectifyWith. The zero value
+	// configures the same behaviour as Rectify.
+	RectifyOpts struct {
+		// Limiter, if not nil, limits the rate of calls made against each
+		// cluster. It should be shared between concurrent rectifications
+		// against the same clusters.
+		Limiter *ClusterRateLimiter
+		// Events, if not nil, receives a RectifyEvent for each operation the
+		// rectifier starts, completes or skips. Sends never block: if the
+		// channel isn't ready to receive, the event is dropped, so Events
+		// should be buffered generously. Events is never closed by the
+		// rectifier; the returned error channel closing signals that no more
+		// events will be sent.
+		Events chan<- RectifyEvent
+		// IgnoreEnv lists env vars that are not considered when deciding
+		// whether a deployment needs to be redeployed. By default no vars
+		// are ignored.
+		IgnoreEnv EnvFilter
+		// Timeout bounds each call made to the RectificationClient. Zero
+		// means DefaultRectifyTimeout.
+		Timeout time.Duration
+		// PerCluster runs an independent pipeline for each cluster, so that
+		// a slow or unreachable cluster doesn't hold up the others. Errors
+		// are then returned as *ClusterError.
+		PerCluster bool
+		// ClusterOpts replaces these options for the pipelines of the named
+		// clusters when PerCluster is set.
+		ClusterOpts map[ClusterName]RectifyOpts
+		// PendingDeployWait bounds how long a deploy waits for a deploy of
+		// different content that's already pending on the request to finish.
+		// Zero means Timeout.
+		PendingDeployWait time.Duration
+		// CancelPendingDeploys cancels a pending deploy of different content,
+		// rather than waiting for it, if the client is a DeployCanceller.
+		CancelPendingDeploys bool
+		// Logger is logged to, with the request ID and cluster concerned as
+		// context. Nil means DefaultLogger.
+		Logger Logger
+		// BlockDowngrades skips deploying a version older than the one
+		// running (see IsDowngrade), and returns DowngradeBlocked instead,
+		// unless the deployment sets AllowDowngrade.
+		BlockDowngrades bool
+		// ForceDowngrades deploys older versions even if BlockDowngrades is
+		// set.
+		ForceDowngrades bool
+		// Auditor, if not nil, records each create, deploy, scale and delete
+		// the rectifier attempts, and whether it succeeded. RunID and
+		// StateRevision are recorded with each entry. If RunID is empty,
+		// one is made with NewID.
+		Auditor       Auditor
+		RunID         string
+		StateRevision string
+		// BlastRadius limits how many deployments RectifyPlanWith will
+		// create, delete or modify: if a plan exceeds it, nothing is changed
+		// and a *BlastRadiusExceeded is returned, unless IgnoreBlastRadius
+		// is set.
+		BlastRadius       BlastRadius
+		IgnoreBlastRadius bool
+		// Quotas are checked by RectifyPlanWith against the deployments the
+		// plan would leave running: if any quota is exceeded, nothing is
+		// changed and a *QuotaExceeded is reported for each, unless
+		// IgnoreQuotas is set.
+		Quotas       Quotas
+		IgnoreQuotas bool
+		// Workers is how many operations are run at once. Operations on the
+		// same request are always run one at a time, in the order they're
+		// received, whichever of the DiffChans they come from. Zero means
+		// DefaultRectifyWorkers.
+		Workers int
+		// Ordering says whether deletes wait for creates and modifies to
+		// finish, or the other way around, or neither. The zero value is
+		// CreatesFirst.
+		Ordering RectifyOrdering
+		// Clock is used for every timestamp, deadline and wait. Nil means
+		// SystemClock.
+		Clock Clock
+		// NewID makes IDs that must be unique. Nil means RandomID.
+		NewID func() string
+		// RequestIDer names the request each deployment is rectified as.
+		// Nil means DefaultRequestIDer.
+		RequestIDer RequestIDer
+		// Freezes stop the rectifier acting on the deployments they apply
+		// to: instead of each create, delete or modify of one, a
+		// SkippedFrozen event is emitted.
+		Freezes Freezes
+		// CircuitThreshold, if positive, is how many consecutive transient
+		// failures of calls against a cluster open its circuit: until
+		// CircuitCoolDown has passed, or DefaultCircuitCoolDown if that's
+		// zero, each create, delete or modify on the cluster is skipped, and
+		// a ClusterCircuitOpen event emitted instead. See ClusterBreaker.
+		CircuitThreshold int
+		CircuitCoolDown  time.Duration
+		// Metrics, if not nil, is told how long the rectification and each
+		// call it makes take, and about every event and error; see
+		// MetricRectifyDuration and the other Metric constants.
+		Metrics metrics.Collector
+		// CompareRevisions redeploys a deployment whose version hasn't
+		// changed if the image it would be deployed with was built from a
+		// different revision than the one running, as happens when a tag is
+		// rebuilt. By default only versions are compared.
+		CompareRevisions bool
 	}
 
 	// RectificationClient abstracts the raw interactions with Singularity.
 	// The methods on this interface are tightly bound to the semantics of Singularity itself -
 	// it's recommended to interact with the Sous Recify function or the recitification driver
 	// rather than with implentations of this interface directly.
+	//
+	// TestRectificationClient checks implementations against the behaviour
+	// the rectifier relies on, as documented on each method.
 	RectificationClient interface {
-		// Deploy creates a new deploy on a particular requeust
-		Deploy(cluster, depID, reqID, dockerImage string, r Resources, e Env, vols Volumes) error
+		// Deploy creates a new deploy on a particular requeust. A deploy ID
+		// that's already been used on the request is refused with a
+		// *DeployIDConflict.
+		Deploy(cluster ClusterName, depID, reqID, dockerImage string, r Resources, e Env, vols Volumes) error
+
+		// PostRequest sends a request to a Singularity cluster to initiate.
+		// Posting a request that already exists succeeds, and sets its
+		// instance count.
+		PostRequest(cluster ClusterName, reqID string, instanceCount int) error
+
+		// Scale updates the instanceCount associated with a request. Scaling
+		// a request that doesn't exist either does nothing or fails with a
+		// *RequestNotFound; it never creates the request.
+		Scale(cluster ClusterName, reqID string, instanceCount int, message string) error
+
+		// DeleteRequest instructs Singularity to delete a particular request.
+		// Deleting a request that doesn't exist either does nothing or fails
+		// with a *RequestNotFound.
+		DeleteRequest(cluster ClusterName, reqID, message string) error
+
+		//ImageName finds or guesses a docker image name for a Deployment,
+		// failing with NoImageNameFound if there isn't one
+		ImageName(d *Deployment) (string, error)
 
-		// PostRequest sends a request to a Singularity cluster to initiate
-		PostRequest(cluster, reqID string, instanceCount int) error
+		//ImageLabels finds the (sous) docker labels for a given image name,
+		// failing with the ImageMapper's error, e.g. NoSourceVersionFound, if
+		// they can't be found
+		ImageLabels(imageName string) (labels map[string]string, err error)
 
-		// Scale updates the instanceCount associated with a request
-		Scale(cluster, reqID string, instanceCount int, message string) error
+		// RunningDeployments reads the deployments currently active on a
+		// cluster. Deployments whose images weren't built by sous are
+		// included, with their ForeignImage set. Deleted requests aren't
+		// included, and a request posted more than once is included once.
+		RunningDeployments(cluster ClusterName) (Deployments, error)
+
+		// PendingDeploy reports whether a deploy is still pending on a
+		// request, and if so, its ID. A request that doesn't exist has no
+		// pending deploy.
+		PendingDeploy(cluster ClusterName, reqID string) (pending bool, depID string, err error)
+
+		// DeployHistory reads the most recent count deploys made on a
+		// request, newest first, with the source version of each deploy's
+		// image if the image is known. count must be positive.
+		DeployHistory(cluster ClusterName, reqID string, count int) ([]DeployRecord, error)
+	}
 
-		// DeleteRequest instructs Singularity to delete a particular request
-		DeleteRequest(cluster, reqID, message string) error
+	// InstanceDeployer is implemented by RectificationClients that can set a
+	// request's instance count as part of a deploy. The rectifier uses it to
+	// apply a change of both image and scale in a single call. (RectiAgent
+	// doesn't implement it yet: the vendored SingularityDeployRequest can't
+	// carry an updated request.)
+	InstanceDeployer interface {
+		// DeployWithInstances is like Deploy, but also scales the request to
+		// instanceCount
+		DeployWithInstances(cluster ClusterName, depID, reqID, dockerImage string, r Resources, e Env, vols Volumes, instanceCount int) error
+	}
 
-		//ImageName finds or guesses a docker image name for a Deployment
-		ImageName(d *Deployment) (string, error)
+	// DeployCanceller is implemented by RectificationClients that can
+	// cancel a pending deploy. The rectifier uses it when
+	// RectifyOpts.CancelPendingDeploys is set.
+	DeployCanceller interface {
+		// CancelDeploy cancels the pending deploy depID on a request
+		CancelDeploy(cluster ClusterName, reqID, depID string) error
+	}
 
-		//ImageLabels finds the (sous) docker labels for a given image name
-		ImageLabels(imageName string) (labels map[string]string, err error)
+	// RequestInspector is implemented by RectificationClients that can
+	// read a single request. The rectifier uses it before each create, in
+	// case the request was created after the running deployments were
+	// read, so that it's modified rather than posted over.
+	RequestInspector interface {
+		// InspectRequest reconstructs the deployment on the request reqID,
+		// as RunningDeployments would, or returns nil if there's no such
+		// request. A request with no deploy yet gives a deployment with
+		// just its instance count.
+		InspectRequest(cluster ClusterName, reqID string) (*Deployment, error)
+	}
+
+	// KindPoster is implemented by RectificationClients that can create
+	// requests of kinds other than http-service. The rectifier uses it to
+	// create each request as its deployment's kind, and to recreate a
+	// request whose kind has changed, since Singularity can't change a
+	// request's type in place. With other clients, every request is created
+	// as an http-service, and a change of kind is left alone.
+	KindPoster interface {
+		// PostRequestOfKind is like PostRequest, but creates a request of
+		// kind, run on schedule if kind is ManifestKindScheduled.
+		PostRequestOfKind(cluster ClusterName, reqID string, instanceCount int, kind ManifestKind, schedule string) error
 	}
 
 	dtoMap map[string]interface{}
@@ -68,12 +252,37 @@ type (
 		Err         error
 	}
 
+	// DeployIDConflict is returned by a RectificationClient's Deploy when a
+	// deploy with the requested ID already exists on the request.
+	DeployIDConflict struct {
+		DeployID string
+		// SameContent is true when the existing deploy carries the same
+		// content as the one requested.
+		SameContent bool
+		// Current is true when the existing deploy is the request's active
+		// or pending deploy, rather than one it has since moved on from.
+		Current bool
+	}
+
+	// RequestNotFound is returned by a RectificationClient asked to change a
+	// request that doesn't exist.
+	RequestNotFound struct {
+		Cluster   ClusterName
+		RequestID string
+	}
+
 	// RectificationError is an interface that extends error with methods to get
-	// the deployments the preceeded and were intended when the error occurred
+	// the deployments the preceeded and were intended when the error occurred,
+	// and to classify its cause
 	RectificationError interface {
 		error
 		ExistingDeployment() *Deployment
 		IntendedDeployment() *Deployment
+		// Kind classifies the underlying cause of the error
+		Kind() ErrorKind
+		// Retryable is true if the same rectification might succeed later,
+		// so the deployment can be re-queued rather than reported
+		Retryable() bool
 	}
 )
 
@@ -119,95 +328,492 @@ func (e *ChangeError) IntendedDeployment() *Deployment {
 	return e.Deployments.post
 }
 
+func (e *DeployIDConflict) Error() string {
+	switch {
+	case e.Applied():
+		return fmt.Sprintf("Deploy %s has already been applied", e.DeployID)
+	case e.SameContent:
+		return fmt.Sprintf("Deploy ID %s is already in use by an earlier deploy", e.DeployID)
+	}
+	return fmt.Sprintf("Deploy ID %s is already in use for different content", e.DeployID)
+}
+
+// Applied is true when the existing deploy is the one requested, and still
+// in effect, so there's nothing to do. An earlier deploy of the same
+// content, e.g. one being rolled back to, must be deployed again.
+func (e *DeployIDConflict) Applied() bool {
+	return e.SameContent && e.Current
+}
+
+func (e *RequestNotFound) Error() string {
+	return fmt.Sprintf("no request %s exists on %s", e.RequestID, e.Cluster)
+}
+
 // Rectify takes a DiffChans and issues the commands to the infrastructure to reconcile the differences
 func Rectify(dcs DiffChans, s RectificationClient) chan RectificationError {
+	return RectifyWith(dcs, s, RectifyOpts{})
+}
+
+// RectifyWith is like Rectify, but its behaviour can be adjusted with
+// RectifyOpts.
+func RectifyWith(dcs DiffChans, s RectificationClient, opts RectifyOpts) chan RectificationError {
+	if opts.Metrics != nil {
+		return measureRectify(opts.Metrics, (&rectifier{RectifyOpts: opts}).clock(), rectify(dcs, s, opts))
+	}
+	return rectify(dcs, s, opts)
+}
+
+// rectify is RectifyWith, without the metrics of the rectification as a
+// whole, which a pipeline per cluster mustn't report again.
+func rectify(dcs DiffChans, s RectificationClient, opts RectifyOpts) chan RectificationError {
+	if opts.Auditor != nil && opts.RunID == "" {
+		opts.RunID = (&rectifier{RectifyOpts: opts}).newID()
+	}
+	if opts.PerCluster {
+		return rectifyPerCluster(dcs, s, opts)
+	}
 	errs := make(chan RectificationError)
-	rect := rectifier{s}
-	wg := &sync.WaitGroup{}
-	wg.Add(3)
-	go func() { rect.rectifyCreates(dcs.Created, errs); wg.Done() }()
-	go func() { rect.rectifyDeletes(dcs.Deleted, errs); wg.Done() }()
-	go func() { rect.rectifyModifys(dcs.Modified, errs); wg.Done() }()
-	go func() { wg.Wait(); close(errs) }()
+	rect := &rectifier{sing: s, RectifyOpts: opts}
+	if opts.CircuitThreshold > 0 {
+		rect.breaker = NewClusterBreaker(opts.CircuitThreshold, opts.CircuitCoolDown)
+	}
+	go func() {
+		rect.dispatch(rect.operations(dcs, errs))
+		close(errs)
+	}()
 
 	return errs
 }
 
-func (r *rectifier) rectifyCreates(cc chan *Deployment, errs chan<- RectificationError) {
-	for d := range cc {
-		name, err := r.sing.ImageName(d)
-		if err != nil {
-			// log.Printf("% +v", d)
-			errs <- &CreateError{Deployment: d, Err: err}
-			continue
+func (r *rectifier) rectifyCreate(d *Deployment, errs chan<- RectificationError) {
+	reqID := r.requestID(d)
+	if err := ValidateRequestID(reqID); err != nil {
+		errs <- &CreateError{Deployment: d, Err: err}
+		return
+	}
+	would := fmt.Sprintf("created %s at %d instances", d.SourceVersion, d.NumInstances)
+	if r.frozen(d, reqID, would) || r.circuitOpen(d, reqID, would) {
+		return
+	}
+	existing, err := r.inspectRequest(d, reqID)
+	if err != nil {
+		errs <- &CreateError{Deployment: d, Err: err}
+		return
+	}
+	if existing != nil {
+		r.rectifyExisting(existing, d, reqID, errs)
+		return
+	}
+	started := r.started(d, reqID)
+
+	name, err := r.imageName(d)
+	if err != nil {
+		errs <- &CreateError{Deployment: d, Err: err}
+		return
+	}
+
+	err = r.postRequest(d, reqID, started)
+	if err != nil {
+		errs <- &CreateError{Deployment: d, Err: err}
+		return
+	}
+
+	err = r.deploy(d, reqID, name, nil, started, false, d.NumInstances)
+	if err != nil {
+		errs <- &CreateError{Deployment: d, Err: err}
+	}
+}
+
+// inspectRequest returns the deployment already on the request reqID that d
+// is about to be created as, or nil if there is none, or the client isn't a
+// RequestInspector.
+func (r *rectifier) inspectRequest(d *Deployment, reqID string) (*Deployment, error) {
+	ri, ok := r.sing.(RequestInspector)
+	if !ok {
+		return nil, nil
+	}
+	var existing *Deployment
+	err := r.call(d.Cluster, "InspectRequest", func() error {
+		var err error
+		existing, err = ri.InspectRequest(d.Cluster, reqID)
+		return err
+	})
+	if err != nil || existing == nil {
+		return nil, err
+	}
+	existing.Cluster = d.Cluster
+	existing.RequestID = reqID
+	return existing, nil
+}
+
+// rectifyExisting rectifies the create of d as the request reqID, which
+// turns out to exist already, running existing: if that has d's content,
+// nothing is done, and otherwise the request is modified into d, rather than
+// posted over.
+func (r *rectifier) rectifyExisting(existing, d *Deployment, reqID string, errs chan<- RectificationError) {
+	pair := &DeploymentPair{name: d.ID(), prior: existing, post: d}
+	if !r.changesReq(pair) && !r.changesDep(pair) {
+		started := r.started(d, reqID)
+		r.emit(Skipped, d, reqID, started, "request already exists with the same content")
+		return
+	}
+	r.logFor(d, reqID).Infof("Request already exists with different content; modifying it instead")
+	r.modify(pair, reqID, errs)
+}
+
+func (r *rectifier) rectifyDelete(d *Deployment, errs chan<- RectificationError) {
+	reqID := r.requestID(d)
+	if err := ValidateRequestID(reqID); err != nil {
+		errs <- &DeleteError{Deployment: d, Err: err}
+		return
+	}
+	if r.preview(d, reqID) {
+		return
+	}
+	if r.frozen(d, reqID, "deleted the request") || r.circuitOpen(d, reqID, "deleted the request") {
+		return
+	}
+	started := r.started(d, reqID)
+	err := r.deleteRequest(d, reqID, "deleting request for removed manifest", started)
+	if err != nil {
+		errs <- &DeleteError{Deployment: d, Err: err}
+	}
+}
+
+func (r *rectifier) rectifyModify(pair *DeploymentPair, errs chan<- RectificationError) {
+	reqID := r.requestID(pair.prior)
+	if err := ValidateRequestID(reqID); err != nil {
+		errs <- &ChangeError{Deployments: pair, Err: err}
+		return
+	}
+	if r.preview(pair.post, reqID) {
+		return
+	}
+	r.modify(pair, reqID, errs)
+}
+
+// modify changes the request reqID from pair.prior into pair.post, scaling
+// and deploying as needed.
+func (r *rectifier) modify(pair *DeploymentPair, reqID string, errs chan<- RectificationError) {
+	log := r.logFor(pair.post, reqID)
+	log.Debugf("Rectifying modify: \n  %+ v \n    =>  \n  %+ v", pair.prior, pair.post)
+	if pair.Recreates() {
+		if _, ok := r.sing.(KindPoster); ok {
+			r.recreate(pair, reqID, errs)
+			return
 		}
+		log.Warnf("Client can't change the kind of a request; modifying it in place as a %s", pair.prior.Kind)
+	}
+	scales, deploys := r.changesReq(pair), r.changesDep(pair)
+	if scales || deploys {
+		would := describeModify(pair, scales, deploys)
+		if r.frozen(pair.post, reqID, would) || r.circuitOpen(pair.post, reqID, would) {
+			return
+		}
+	}
+	started := r.started(pair.post, reqID)
 
-		reqID := computeRequestID(d)
-		err = r.sing.PostRequest(d.Cluster, reqID, d.NumInstances)
-		if err != nil {
-			// log.Printf("%T %#v", d, d)
-			errs <- &CreateError{Deployment: d, Err: err}
-			continue
+	if !scales && !deploys {
+		r.emit(Skipped, pair.post, reqID, started, "no changes to the request or deploy")
+		return
+	}
+
+	if deploys && r.blocksDowngrade(pair) {
+		err := &DowngradeBlocked{Deployments: pair}
+		r.emit(Skipped, pair.post, reqID, started, err.Error())
+		errs <- err
+		if deploys = false; !scales {
+			return
 		}
+	}
+
+	_, canRollout := r.sing.(IncrementalDeployer)
+	rollout := deploys && pair.post.Rollout != nil && canRollout
+	if deploys && pair.post.Rollout != nil && !canRollout {
+		log.Warnf("Client can't roll out incrementally; deploying all at once")
+	}
 
-		err = r.sing.Deploy(d.Cluster, newDepID(), reqID, name, d.Resources, d.Env, d.DeployConfig.Volumes)
+	_, canCoalesce := r.sing.(InstanceDeployer)
+	coalesce := scales && deploys && canCoalesce && !rollout
+
+	if scales && !coalesce {
+		log.Debugf("Scaling...")
+		err := r.scale(pair.post, r.requestID(pair.post), "rectified scaling", started, pair.prior.NumInstances)
 		if err != nil {
-			// log.Printf("% +v", d)
-			errs <- &CreateError{Deployment: d, Err: err}
-			continue
+			errs <- &ChangeError{Deployments: pair, Err: err}
+			return
+		}
+	}
+
+	if !deploys {
+		return
+	}
+	log.Debugf("Deploying...")
+	name, err := r.imageName(pair.post)
+	if err != nil {
+		errs <- &ChangeError{Deployments: pair, Err: err}
+		return
+	}
+
+	if rollout {
+		err = r.rollout(pair.post, reqID, name, started)
+	} else {
+		prior := pair.post.NumInstances
+		if coalesce {
+			prior = pair.prior.NumInstances
 		}
+		err = r.deploy(pair.post, reqID, name, r.revisionChange(pair), started, coalesce, prior)
+	}
+	if err != nil {
+		errs <- &ChangeError{Deployments: pair, Err: err}
 	}
 }
 
-func (r *rectifier) rectifyDeletes(dc chan *Deployment, errs chan<- RectificationError) {
-	for d := range dc {
-		err := r.sing.DeleteRequest(d.Cluster, computeRequestID(d), "deleting request for removed manifest")
-		if err != nil {
-			errs <- &DeleteError{Deployment: d, Err: err}
-			continue
+// recreate deletes the request reqID and creates it again as pair.post,
+// since a request's kind can't be changed in place. The image is looked up
+// first, so that a missing one doesn't leave the request deleted.
+func (r *rectifier) recreate(pair *DeploymentPair, reqID string, errs chan<- RectificationError) {
+	would := fmt.Sprintf("recreated the request as a %s", pair.post.Kind)
+	if r.frozen(pair.post, reqID, would) || r.circuitOpen(pair.post, reqID, would) {
+		return
+	}
+	started := r.started(pair.post, reqID)
+	if r.blocksDowngrade(pair) {
+		err := &DowngradeBlocked{Deployments: pair}
+		r.emit(Skipped, pair.post, reqID, started, err.Error())
+		errs <- err
+		return
+	}
+	name, err := r.imageName(pair.post)
+	if err != nil {
+		errs <- &ChangeError{Deployments: pair, Err: err}
+		return
+	}
+	message := fmt.Sprintf("recreating request as a %s", pair.post.Kind)
+	if err := r.deleteRequest(pair.prior, reqID, message, started); err != nil {
+		errs <- &ChangeError{Deployments: pair, Err: err}
+		return
+	}
+	if err := r.awaitDeleted(pair.post, reqID); err != nil {
+		errs <- &ChangeError{Deployments: pair, Err: err}
+		return
+	}
+	if err := r.postRequest(pair.post, reqID, started); err != nil {
+		errs <- &ChangeError{Deployments: pair, Err: err}
+		return
+	}
+	if err := r.deploy(pair.post, reqID, name, nil, started, false, pair.post.NumInstances); err != nil {
+		errs <- &ChangeError{Deployments: pair, Err: err}
+	}
+}
+
+// RequestDeleting is the cause of a RectificationError when a request being
+// recreated was still being deleted after waiting for it.
+type RequestDeleting struct {
+	RequestID string
+	After     time.Duration
+}
+
+func (e *RequestDeleting) Error() string {
+	return fmt.Sprintf("request %s is still being deleted after %s", e.RequestID, e.After)
+}
+
+// Temporary is always true: the delete should finish eventually.
+func (e *RequestDeleting) Temporary() bool { return true }
+
+// awaitDeleted waits for the request reqID, just deleted so that it can be
+// created as d, to be gone, for at most pendingDeployWait. Singularity
+// deletes requests asynchronously, and refuses to post one that's still
+// being deleted. A client that isn't a RequestInspector is taken to delete
+// synchronously.
+func (r *rectifier) awaitDeleted(d *Deployment, reqID string) error {
+	wait := r.pendingDeployWait()
+	deadline := r.clock().Now().Add(wait)
+	for {
+		existing, err := r.inspectRequest(d, reqID)
+		if err != nil || existing == nil {
+			return err
+		}
+		remaining := deadline.Sub(r.clock().Now())
+		if remaining <= 0 {
+			return &RequestDeleting{RequestID: reqID, After: wait}
 		}
+		r.logFor(d, reqID).Debugf("Waiting for the request to be deleted")
+		if remaining > pendingDeployPollInterval {
+			remaining = pendingDeployPollInterval
+		}
+		<-r.clock().After(remaining)
 	}
 }
 
-func (r *rectifier) rectifyModifys(
-	mc chan *DeploymentPair, errs chan<- RectificationError) {
-	for pair := range mc {
-		Log.Debug.Printf("Rectifying modify: \n  %+ v \n    =>  \n  %+ v", pair.prior, pair.post)
-		if r.changesReq(pair) {
-			Log.Debug.Printf("Scaling...")
-			err := r.sing.Scale(
-				pair.post.Cluster,
-				computeRequestID(pair.post),
-				pair.post.NumInstances,
-				"rectified scaling")
-			if err != nil {
-				errs <- &ChangeError{Deployments: pair, Err: err}
-				continue
-			}
+// maxDeployIDSuffix limits how many suffixed deploy IDs are tried when the
+// content-derived ID is already taken by different content.
+const maxDeployIDSuffix = 10
+
+// deploy issues a Deploy of d with an ID derived from its content, so that
+// retrying the same intended state can't create a second identical deploy.
+// If withInstances is true, the client must be an InstanceDeployer, and the
+// request is scaled to d.NumInstances as part of the deploy. prior is the
+// request's instance count before the deploy. rev, if not nil, is noted in
+// the message the deploy is audited and reported with.
+func (r *rectifier) deploy(d *Deployment, reqID, imageName string, rev *revisionChange, started time.Time, withInstances bool, prior int) error {
+	res, e, vols := d.Resources, d.Env, d.DeployConfig.Volumes
+	baseID := computeDeployID(reqID, imageName, res, e, vols)
+	alreadyPending, err := r.awaitPendingDeploy(d, reqID, baseID, started)
+	if err != nil {
+		return err
+	}
+	if alreadyPending {
+		if withInstances {
+			// the pending deploy may not carry this instance count
+			return r.scale(d, r.requestID(d), "rectified scaling", started, prior)
 		}
+		return nil
+	}
 
-		if changesDep(pair) {
-			Log.Debug.Printf("Deploying...")
-			name, err := r.sing.ImageName(pair.post)
-			if err != nil {
-				errs <- &ChangeError{Deployments: pair, Err: err}
-				continue
+	depID, applied, err := r.deployWithUniqueID(baseID, func(depID string) error {
+		return r.call(d.Cluster, "Deploy", func() error {
+			if withInstances {
+				return r.sing.(InstanceDeployer).DeployWithInstances(
+					d.Cluster, depID, reqID, imageName, res, e, vols, d.NumInstances)
 			}
+			return r.sing.Deploy(d.Cluster, depID, reqID, imageName, res, e, vols)
+		})
+	})
+	if applied {
+		r.logFor(d, reqID).Debugf("Deploy %s already applied", depID)
+		r.emit(Skipped, d, reqID, started, "deploy "+depID+" already applied")
+		if withInstances {
+			// the earlier deploy may not have carried this instance count
+			return r.scale(d, r.requestID(d), "rectified scaling", started, prior)
+		}
+		return nil
+	}
+	msg := "deployed " + imageName
+	if withInstances {
+		msg = fmt.Sprintf("%s at %d instances", msg, d.NumInstances)
+	}
+	if rev != nil {
+		msg = fmt.Sprintf("%s (%s)", msg, rev)
+	}
+	r.audit(AuditDeploy, d, reqID, depID, prior, msg, err)
+	if err == nil {
+		r.emit(Deployed, d, reqID, started, msg)
+	}
+	return err
+}
 
-			err = r.sing.Deploy(
-				pair.post.Cluster,
-				newDepID(),
-				computeRequestID(pair.prior),
-				name,
-				pair.post.Resources,
-				pair.post.Env,
-				pair.post.DeployConfig.Volumes,
-			)
-			if err != nil {
-				errs <- &ChangeError{Deployments: pair, Err: err}
-				continue
-			}
+// deployWithUniqueID calls post with baseID, and then with suffixed IDs
+// while the ID tried is taken by another deploy, up to maxDeployIDSuffix
+// times. It returns the ID last tried, and whether it was taken by the
+// deploy requested, already applied.
+func (r *rectifier) deployWithUniqueID(baseID string, post func(depID string) error) (string, bool, error) {
+	depID := baseID
+	for i := 1; ; i++ {
+		err := post(depID)
+		conflict, ok := err.(*DeployIDConflict)
+		if !ok {
+			return depID, false, err
+		}
+		if conflict.Applied() {
+			return depID, true, nil
 		}
+		if i > maxDeployIDSuffix {
+			return depID, false, err
+		}
+		depID = fmt.Sprintf("%s_%d", baseID, i)
+	}
+}
+
+func (r *rectifier) postRequest(d *Deployment, reqID string, started time.Time) error {
+	err := r.call(d.Cluster, "PostRequest", func() error {
+		if kp, ok := r.sing.(KindPoster); ok && d.Kind != "" && d.Kind != ManifestKindService {
+			return kp.PostRequestOfKind(d.Cluster, reqID, d.NumInstances, d.Kind, d.Schedule)
+		}
+		return r.sing.PostRequest(d.Cluster, reqID, d.NumInstances)
+	})
+	r.audit(AuditCreate, d, reqID, "", 0, "", err)
+	if err == nil {
+		r.emit(RequestPosted, d, reqID, started, "")
+	}
+	return err
+}
+
+func (r *rectifier) scale(d *Deployment, reqID, message string, started time.Time, prior int) error {
+	err := r.call(d.Cluster, "Scale", func() error {
+		return r.sing.Scale(d.Cluster, reqID, d.NumInstances, message)
+	})
+	r.audit(AuditScale, d, reqID, "", prior, message, err)
+	if err == nil {
+		r.emit(Scaled, d, reqID, started, fmt.Sprintf("scaled to %d", d.NumInstances))
+	}
+	return err
+}
+
+func (r *rectifier) deleteRequest(d *Deployment, reqID, message string, started time.Time) error {
+	err := r.call(d.Cluster, "DeleteRequest", func() error {
+		return r.sing.DeleteRequest(d.Cluster, reqID, message)
+	})
+	r.audit(AuditDelete, d, reqID, "", d.NumInstances, message, err)
+	if err == nil {
+		r.emit(Deleted, d, reqID, started, "")
+	}
+	return err
+}
+
+func (r *rectifier) imageName(d *Deployment) (string, error) {
+	var name string
+	err := r.withTimeout("ImageName", func() error {
+		var err error
+		name, err = r.sing.ImageName(d)
+		return err
+	})
+	if err != nil {
+		// name may still be written by a call that timed out
+		return "", err
+	}
+	return name, nil
+}
+
+// requestID returns the ID of the request r rectifies d as.
+func (r *rectifier) requestID(d *Deployment) string {
+	return RequestIDWith(r.RequestIDer, d)
+}
+
+// logger returns the Logger the rectifier logs to, at the levels set for
+// logging.Rectify.
+func (r *rectifier) logger() Logger {
+	if r.Logger == nil {
+		return logging.ForSubsystem(DefaultLogger, logging.Rectify)
+	}
+	return logging.ForSubsystem(r.Logger, logging.Rectify)
+}
+
+// clock returns the Clock r is configured with, or SystemClock.
+func (r *rectifier) clock() Clock {
+	if r.Clock == nil {
+		return SystemClock
+	}
+	return r.Clock
+}
+
+// newID returns a new unique ID from r's ID generator, or RandomID.
+func (r *rectifier) newID() string {
+	if r.NewID == nil {
+		return RandomID()
+	}
+	return r.NewID()
+}
+
+// logFor returns a Logger for the rectification of d as the request reqID.
+func (r *rectifier) logFor(d *Deployment, reqID string) Logger {
+	return r.logger().With("request", reqID, "cluster", string(d.Cluster))
+}
+
+// limit waits for the rate limiter, if any, to allow a call against cluster.
+func (r *rectifier) limit(cluster ClusterName) {
+	if wait := r.Limiter.wait(cluster, r.clock()); wait > 0 {
+		r.logger().With("cluster", string(cluster)).Debugf("Waited %s for rate limit", wait)
 	}
 }
 
@@ -215,25 +821,66 @@ func (r rectifier) changesReq(pair *DeploymentPair) bool {
 	return pair.prior.NumInstances != pair.post.NumInstances
 }
 
-func changesDep(pair *DeploymentPair) bool {
-	return !(pair.prior.SourceVersion.Equal(pair.post.SourceVersion) &&
-		pair.prior.Resources.Equal(pair.post.Resources) &&
-		pair.prior.Env.Equal(pair.post.Env))
+func (r rectifier) changesDep(pair *DeploymentPair) bool {
+	diffs := r.depDiffs(pair)
+	if len(diffs) > 0 && logging.Enabled(logging.Rectify, logging.DebugLevel) {
+		r.logFor(pair.post, r.requestID(pair.post)).Debugf("Deploy changes: %s", strings.Join(diffs, "; "))
+	}
+	return len(diffs) > 0
 }
 
-func computeRequestID(d *Deployment) string {
-	if len(d.RequestID) > 0 {
-		return d.RequestID
+// depDiffs explains, field by field, why pair needs a new deploy. Env vars
+// ignored by r.IgnoreEnv are noted, so the filter can be audited.
+func (r rectifier) depDiffs(pair *DeploymentPair) []string {
+	diffs := []string{}
+	if !pair.prior.SourceVersion.Equal(pair.post.SourceVersion) {
+		diffs = append(diffs, fmt.Sprintf("source version %v => %v", pair.prior.SourceVersion, pair.post.SourceVersion))
+	}
+	if rev := r.revisionChange(pair); rev != nil {
+		diffs = append(diffs, rev.String())
+	}
+	for _, rd := range pair.prior.Resources.Diff(pair.post.Resources) {
+		diffs = append(diffs, fmt.Sprintf("resources.%s %s => %s", rd.Name, rd.Prior, rd.Post))
 	}
-	return idify(d.SourceVersion.CanonicalName().String())
-}
 
-var notInIDRE = regexp.MustCompile(`[-/:]`)
+	if !pair.prior.DeployConfig.Volumes.Equal(pair.post.DeployConfig.Volumes) {
+		diffs = append(diffs, fmt.Sprintf("volumes %v => %v", pair.prior.DeployConfig.Volumes, pair.post.DeployConfig.Volumes))
+	}
 
-func idify(in string) string {
-	return notInIDRE.ReplaceAllString(in, "")
+	priorEnv, priorIgnored := r.IgnoreEnv.Strip(pair.prior.Env)
+	postEnv, postIgnored := r.IgnoreEnv.Strip(pair.post.Env)
+	if !priorEnv.Equal(postEnv) {
+		diffs = append(diffs, fmt.Sprintf("env %v => %v", priorEnv, postEnv))
+	}
+	if len(priorIgnored)+len(postIgnored) > 0 {
+		r.logFor(pair.post, r.requestID(pair.post)).Debugf(
+			"Ignored env vars: existing %v, intended %v", priorIgnored, postIgnored)
+	}
+	return diffs
 }
 
-func newDepID() string {
-	return idify(uuid.NewV4().String())
+// computeDeployID returns a deploy ID derived from the content of a deploy:
+// the same request, image, resources, env and volumes always produce the same
+// ID.
+func computeDeployID(reqID, imageName string, r Resources, e Env, vols Volumes) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", reqID, imageName)
+	writeSortedMap(h, r)
+	writeSortedMap(h, e)
+	for _, v := range vols.normalized() {
+		fmt.Fprintf(h, "vol:%s:%s:%s\n", v.Host, v.Container, v.Mode)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+func writeSortedMap(w io.Writer, m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%q=%q\n", k, m[k])
+	}
+	fmt.Fprintln(w)
 }
@@ -1,11 +1,14 @@
 package sous
 
 import (
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/opentable/sous/lib/clocktest"
 	"github.com/samsalisbury/semv"
 	"github.com/stretchr/testify/assert"
 )
@@ -47,11 +50,11 @@ func TestModifyScale(t *testing.T) {
 		t.Error(e)
 	}
 
-	assert.Len(client.deployed, 0)
-	assert.Len(client.created, 0)
+	assert.False(client.Deployed("reqid"))
+	assert.False(client.Created("reqid"))
 
-	if assert.Len(client.scaled, 1) {
-		assert.Equal(24, client.scaled[0].count)
+	if scales := client.CallsTo("Scale"); assert.Len(scales, 1) {
+		assert.Equal(24, scales[0].Args[2])
 	}
 }
 
@@ -94,11 +97,11 @@ func TestModifyImage(t *testing.T) {
 		t.Error(e)
 	}
 
-	assert.Len(client.created, 0)
-	assert.Len(client.scaled, 0)
+	assert.Empty(client.CallsTo("PostRequest"))
+	assert.Empty(client.CallsTo("Scale"))
 
-	if assert.Len(client.deployed, 1) {
-		assert.Regexp("2.3.4", client.deployed[0].imageName)
+	if deploys := client.CallsTo("Deploy"); assert.Len(deploys, 1) {
+		assert.Regexp("2.3.4", deploys[0].Args[3])
 	}
 }
 
@@ -145,11 +148,11 @@ func TestModifyResources(t *testing.T) {
 		t.Error(e)
 	}
 
-	assert.Len(client.created, 0)
+	assert.False(client.Created("reqid"))
 
-	if assert.Len(client.deployed, 1) {
-		assert.Regexp("1.2.3", client.deployed[0].imageName)
-		assert.Regexp("500", client.deployed[0].res["memory"])
+	if deploys := client.CallsTo("Deploy"); assert.Len(deploys, 1) {
+		assert.Regexp("1.2.3", deploys[0].Args[3])
+		assert.Equal("500", deploys[0].Args[4].(Resources)["memory"])
 	}
 }
 
@@ -199,16 +202,17 @@ func TestModify(t *testing.T) {
 		t.Error(e)
 	}
 
-	assert.Len(client.created, 0)
+	assert.False(client.Created("reqid"))
 
-	if assert.Len(client.deployed, 1) {
-		assert.Regexp("2.3.4", client.deployed[0].imageName)
-		log.Print(client.deployed[0].vols)
-		assert.Equal("RW", string(client.deployed[0].vols[0].Mode))
+	if deploys := client.CallsTo("Deploy"); assert.Len(deploys, 1) {
+		assert.Regexp("2.3.4", deploys[0].Args[3])
+		vols := deploys[0].Args[6].(Volumes)
+		log.Print(vols)
+		assert.Equal("RW", string(vols[0].Mode))
 	}
 
-	if assert.Len(client.scaled, 1) {
-		assert.Equal(24, client.scaled[0].count)
+	if scales := client.CallsTo("Scale"); assert.Len(scales, 1) {
+		assert.Equal(24, scales[0].Args[2])
 	}
 }
 
@@ -236,13 +240,12 @@ func TestDeletes(t *testing.T) {
 		t.Error(e)
 	}
 
-	assert.Len(client.deployed, 0)
-	assert.Len(client.created, 0)
+	assert.False(client.Deployed("reqid"))
+	assert.False(client.Created("reqid"))
 
-	if assert.Len(client.deleted, 1) {
-		req := client.deleted[0]
-		assert.Equal("cluster", req.cluster)
-		assert.Equal("reqid", req.reqid)
+	if deletes := client.CallsTo("DeleteRequest"); assert.Len(deletes, 1) {
+		assert.Equal(ClusterName("cluster"), deletes[0].Args[0])
+		assert.Equal("reqid", deletes[0].Args[1])
 	}
 }
 
@@ -273,17 +276,448 @@ func TestCreates(t *testing.T) {
 		t.Error(e)
 	}
 
-	assert.Len(client.scaled, 0)
-	if assert.Len(client.deployed, 1) {
-		dep := client.deployed[0]
-		assert.Equal("cluster", dep.cluster)
-		assert.Equal("reqid 0.0.0", dep.imageName)
+	assert.Empty(client.CallsTo("Scale"))
+	if deploys := client.CallsTo("Deploy"); assert.Len(deploys, 1) {
+		assert.Equal(ClusterName("cluster"), deploys[0].Args[0])
+		assert.Equal("reqid 0.0.0", deploys[0].Args[3])
 	}
 
-	if assert.Len(client.created, 1) {
-		req := client.created[0]
-		assert.Equal("cluster", req.cluster)
-		assert.Equal("reqid", req.id)
-		assert.Equal(12, req.count)
+	if posts := client.CallsTo("PostRequest"); assert.Len(posts, 1) {
+		assert.Equal([]interface{}{ClusterName("cluster"), "reqid", 12}, posts[0].Args)
 	}
 }
+
+func TestComputeDeployID(t *testing.T) {
+	assert := assert.New(t)
+
+	res := Resources{"cpus": "0.1", "memory": "100"}
+	env := Env{"A": "1", "B": "2"}
+	vols := Volumes{&Volume{"host", "container", "RO"}}
+
+	id := computeDeployID("reqid", "image:1.2.3", res, env, vols)
+	assert.Len(id, 32)
+	assert.Equal(id, computeDeployID("reqid", "image:1.2.3",
+		Resources{"memory": "100", "cpus": "0.1"}, Env{"B": "2", "A": "1"}, vols))
+	assert.NotEqual(id, computeDeployID("reqid", "image:1.2.4", res, env, vols))
+	assert.NotEqual(id, computeDeployID("reqid", "image:1.2.3", res, Env{"A": "1"}, vols))
+	assert.NotEqual(id, computeDeployID("reqid", "image:1.2.3", res, env, Volumes{}))
+}
+
+func TestRetriedCreateDeploysOnce(t *testing.T) {
+	assert := assert.New(t)
+
+	nc := NewDummyNameCache()
+	client := NewDummyRectificationClient(nc)
+
+	created := &Deployment{
+		SourceVersion: SourceVersion{
+			RepoURL: RepoURL("reqid"),
+		},
+		DeployConfig: DeployConfig{
+			NumInstances: 12,
+		},
+		Cluster: "cluster",
+	}
+
+	for i := 0; i < 2; i++ {
+		chanset := NewDiffChans(1)
+		// without a RequestInspector, the create is retried in full
+		errs := Rectify(chanset, uninspected{client})
+		chanset.Created <- created
+		chanset.Close()
+		for e := range errs {
+			t.Error(e)
+		}
+	}
+
+	assert.Len(client.CallsTo("PostRequest"), 2)
+	if deploys := client.CallsTo("Deploy"); assert.Len(deploys, 2) {
+		assert.NoError(deploys[0].Err)
+		assert.Error(deploys[1].Err, "the retried deploy should be refused")
+	}
+}
+
+func TestDeployIDConflictWithDifferentContent(t *testing.T) {
+	assert := assert.New(t)
+
+	nc := NewDummyNameCache()
+	client := NewDummyRectificationClient(nc)
+
+	created := &Deployment{
+		SourceVersion: SourceVersion{
+			RepoURL: RepoURL("reqid"),
+		},
+		Cluster: "cluster",
+	}
+	depID := computeDeployID("reqid", "reqid 0.0.0", nil, nil, nil)
+	if err := client.Deploy("cluster", depID, "reqid", "something-else", nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	chanset := NewDiffChans(1)
+	errs := Rectify(chanset, client)
+	chanset.Created <- created
+	chanset.Close()
+	for e := range errs {
+		t.Error(e)
+	}
+
+	if deploys := client.CallsTo("Deploy"); assert.Len(deploys, 3) {
+		assert.Error(deploys[1].Err)
+		assert.Equal(depID+"_1", deploys[2].Args[1])
+		assert.NoError(deploys[2].Err)
+	}
+}
+
+func TestDeployRollsBackToEarlierContent(t *testing.T) {
+	assert := assert.New(t)
+
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	created := &Deployment{
+		SourceVersion: SourceVersion{
+			RepoURL: RepoURL("reqid"),
+		},
+		Cluster: "cluster",
+	}
+	// the same content was deployed once, but has since been replaced
+	depID := computeDeployID("reqid", "reqid 0.0.0", nil, nil, nil)
+	if err := client.Deploy("cluster", depID, "reqid", "reqid 0.0.0", nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Deploy("cluster", "newer", "reqid", "something-else", nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	chanset := NewDiffChans(1)
+	errs := Rectify(chanset, client)
+	chanset.Created <- created
+	chanset.Close()
+	for e := range errs {
+		t.Error(e)
+	}
+
+	if deploys := client.CallsTo("Deploy"); assert.Len(deploys, 4) {
+		assert.Error(deploys[2].Err)
+		assert.Equal(depID+"_1", deploys[3].Args[1])
+		assert.NoError(deploys[3].Err)
+	}
+}
+
+// instanceDeployingClient is a DummyRectificationClient that can also scale
+// as part of a deploy.
+type instanceDeployingClient struct {
+	*DummyRectificationClient
+}
+
+func (c instanceDeployingClient) DeployWithInstances(
+	cluster ClusterName, depID, reqID, imageName string, res Resources, e Env, vols Volumes, instances int) error {
+	return c.deployWithInstances(cluster, depID, reqID, imageName, res, e, vols, instances)
+}
+
+func TestModifyCoalescesScaleAndDeploy(t *testing.T) {
+	assert := assert.New(t)
+	pair := &DeploymentPair{
+		prior: &Deployment{
+			SourceVersion: SourceVersion{
+				RepoURL: RepoURL("reqid"),
+				Version: semv.MustParse("1.2.3"),
+			},
+			DeployConfig: DeployConfig{NumInstances: 1},
+			Cluster:      "cluster",
+		},
+		post: &Deployment{
+			SourceVersion: SourceVersion{
+				RepoURL: RepoURL("reqid"),
+				Version: semv.MustParse("2.3.4"),
+			},
+			DeployConfig: DeployConfig{NumInstances: 24},
+			Cluster:      "cluster",
+		},
+	}
+
+	client := instanceDeployingClient{NewDummyRectificationClient(NewDummyNameCache())}
+	chanset := NewDiffChans(1)
+	errs := Rectify(chanset, client)
+	chanset.Modified <- pair
+	chanset.Close()
+	for e := range errs {
+		t.Error(e)
+	}
+
+	assert.Empty(client.CallsTo("Scale"))
+	if deploys := client.CallsTo("DeployWithInstances"); assert.Len(deploys, 1) {
+		assert.Regexp("2.3.4", deploys[0].Args[3])
+		assert.Equal(24, deploys[0].Args[7])
+	}
+}
+
+func TestRectifyFailsOnNthCall(t *testing.T) {
+	assert := assert.New(t)
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	client.FailCall("Deploy", 2, fmt.Errorf("out of capacity"))
+
+	chanset := NewDiffChans(2)
+	errs := Rectify(chanset, client)
+	for _, id := range []string{"one", "two"} {
+		chanset.Created <- &Deployment{
+			SourceVersion: SourceVersion{RepoURL: RepoURL(id)},
+			DeployConfig:  DeployConfig{NumInstances: 1},
+			Cluster:       "cluster",
+		}
+	}
+	chanset.Close()
+	var failed []error
+	for e := range errs {
+		failed = append(failed, e)
+	}
+
+	assert.Len(failed, 1)
+	deploys := client.CallsTo("Deploy")
+	if assert.Len(deploys, 2) {
+		assert.NoError(deploys[0].Err)
+		assert.EqualError(deploys[1].Err, "out of capacity")
+		assert.True(client.Deployed(deploys[0].Args[2].(string)))
+		assert.False(client.Deployed(deploys[1].Args[2].(string)))
+	}
+}
+
+func TestRectifyUsesScriptedImageName(t *testing.T) {
+	assert := assert.New(t)
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	created := &Deployment{
+		SourceVersion: SourceVersion{RepoURL: RepoURL("reqid")},
+		DeployConfig:  DeployConfig{NumInstances: 1},
+		Cluster:       "cluster",
+	}
+	client.SetImageName(created, "docker.example.com/reqid:scripted")
+
+	chanset := NewDiffChans(1)
+	errs := Rectify(chanset, client)
+	chanset.Created <- created
+	chanset.Close()
+	for e := range errs {
+		t.Error(e)
+	}
+
+	var methods []string
+	for _, c := range client.Calls() {
+		methods = append(methods, c.Method)
+	}
+	assert.Equal([]string{"InspectRequest", "ImageName", "PostRequest", "PendingDeploy", "Deploy"}, methods)
+	if deploys := client.CallsTo("Deploy"); assert.Len(deploys, 1) {
+		assert.Equal("docker.example.com/reqid:scripted", deploys[0].Args[3])
+	}
+}
+
+// uninspected hides every method of a RectificationClient but those of the
+// interface, e.g. InspectRequest.
+type uninspected struct{ RectificationClient }
+
+// rectifyCreates rectifies the creation of ds with client, and returns the
+// events emitted.
+func rectifyCreates(t *testing.T, client RectificationClient, ds ...*Deployment) []RectifyEvent {
+	chanset := NewDiffChans(len(ds))
+	events := make(chan RectifyEvent, 20)
+	errs := RectifyWith(chanset, client, RectifyOpts{Events: events})
+	for _, d := range ds {
+		chanset.Created <- d
+	}
+	chanset.Close()
+	for e := range errs {
+		t.Error(e)
+	}
+	close(events)
+	evs := []RectifyEvent{}
+	for ev := range events {
+		evs = append(evs, ev)
+	}
+	return evs
+}
+
+func TestCreateOfExistingRequestWithSameContentIsSkipped(t *testing.T) {
+	assert := assert.New(t)
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	created := &Deployment{
+		SourceVersion: SourceVersion{RepoURL: RepoURL("reqid"), Version: semv.MustParse("1.0.0")},
+		DeployConfig:  DeployConfig{NumInstances: 2},
+		Cluster:       "cluster",
+	}
+	rectifyCreates(t, client, created)
+
+	evs := rectifyCreates(t, client, created)
+	if assert.Len(evs, 2) {
+		assert.Equal(Skipped, evs[1].Kind)
+		assert.Equal("request already exists with the same content", evs[1].Message)
+	}
+	assert.Len(client.CallsTo("InspectRequest"), 2)
+	assert.Len(client.CallsTo("PostRequest"), 1)
+	assert.Len(client.CallsTo("Deploy"), 1)
+}
+
+func TestCreateOfExistingRequestWithDifferentContentModifiesIt(t *testing.T) {
+	assert := assert.New(t)
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	d := func(version string, instances int) *Deployment {
+		return &Deployment{
+			SourceVersion: SourceVersion{RepoURL: RepoURL("reqid"), Version: semv.MustParse(version)},
+			DeployConfig:  DeployConfig{NumInstances: instances},
+			Cluster:       "cluster",
+		}
+	}
+	rectifyCreates(t, client, d("1.0.0", 2))
+
+	rectifyCreates(t, client, d("1.0.0", 3))
+	assert.Len(client.CallsTo("PostRequest"), 1, "the request shouldn't be posted over")
+	if scales := client.CallsTo("Scale"); assert.Len(scales, 1) {
+		assert.Equal(3, scales[0].Args[2])
+	}
+	assert.Len(client.CallsTo("Deploy"), 1, "the deploy is unchanged")
+
+	rectifyCreates(t, client, d("2.0.0", 3))
+	assert.Len(client.CallsTo("PostRequest"), 1)
+	assert.Len(client.CallsTo("Scale"), 1)
+	if deploys := client.CallsTo("Deploy"); assert.Len(deploys, 2) {
+		assert.Equal("reqid 2.0.0", deploys[1].Args[3])
+	}
+
+	running, err := client.RunningDeployments("cluster")
+	if assert.NoError(err) && assert.Len(running, 1) {
+		assert.Equal(3, running[0].NumInstances)
+		assert.Equal("2.0.0", running[0].SourceVersion.Version.String())
+	}
+}
+
+func TestCreateOfRequestWithoutDeployDeploysIt(t *testing.T) {
+	assert := assert.New(t)
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	if err := client.PostRequest("cluster", "reqid", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	rectifyCreates(t, client, &Deployment{
+		SourceVersion: SourceVersion{RepoURL: RepoURL("reqid"), Version: semv.MustParse("1.0.0")},
+		DeployConfig:  DeployConfig{NumInstances: 2},
+		Cluster:       "cluster",
+	})
+	assert.Len(client.CallsTo("PostRequest"), 1)
+	assert.Empty(client.CallsTo("Scale"))
+	assert.Len(client.CallsTo("Deploy"), 1)
+}
+
+func TestModifyOfKindRecreatesRequest(t *testing.T) {
+	assert := assert.New(t)
+	d := func(kind ManifestKind) *Deployment {
+		return &Deployment{
+			SourceVersion: SourceVersion{RepoURL: RepoURL("reqid"), Version: semv.MustParse("1.0.0")},
+			DeployConfig:  DeployConfig{NumInstances: 2},
+			Kind:          kind,
+			Cluster:       "cluster",
+		}
+	}
+	pair := &DeploymentPair{prior: d(ManifestKindService), post: d(ManifestKindWorker)}
+
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	chanset := NewDiffChans(1)
+	errs := Rectify(chanset, client)
+	chanset.Modified <- pair
+	chanset.Close()
+	for e := range errs {
+		t.Error(e)
+	}
+
+	methods := []string{}
+	for _, c := range client.Calls() {
+		switch c.Method {
+		case "DeleteRequest", "PostRequest", "PostRequestOfKind", "Deploy":
+			methods = append(methods, c.Method)
+		}
+	}
+	assert.Equal([]string{"DeleteRequest", "PostRequestOfKind", "Deploy"}, methods)
+	if posts := client.CallsTo("PostRequestOfKind"); assert.Len(posts, 1) {
+		assert.Equal(ManifestKindWorker, posts[0].Args[3])
+	}
+}
+
+// deletingClient is a DummyRectificationClient whose deletes finish only
+// once InspectRequest has found the request still there deleting times.
+type deletingClient struct {
+	*DummyRectificationClient
+	deleting int
+}
+
+func (c *deletingClient) InspectRequest(cluster ClusterName, reqID string) (*Deployment, error) {
+	if c.deleting > 0 {
+		c.deleting--
+		return &Deployment{Cluster: cluster}, nil
+	}
+	return c.DummyRectificationClient.InspectRequest(cluster, reqID)
+}
+
+func TestRecreateWaitsForDelete(t *testing.T) {
+	assert := assert.New(t)
+	d := func(kind ManifestKind) *Deployment {
+		return &Deployment{
+			SourceVersion: SourceVersion{RepoURL: RepoURL("reqid"), Version: semv.MustParse("1.0.0")},
+			DeployConfig:  DeployConfig{NumInstances: 2},
+			Kind:          kind,
+			Cluster:       "cluster",
+		}
+	}
+	recreate := func(deleting int) (*DummyRectificationClient, []error) {
+		client := &deletingClient{NewDummyRectificationClient(NewDummyNameCache()), deleting}
+		clock := clocktest.NewClock(time.Now())
+		opts := RectifyOpts{Clock: clock, PendingDeployWait: 3 * time.Second}
+		chanset := NewDiffChans(1)
+		errs := RectifyWith(chanset, client, opts)
+		chanset.Modified <- &DeploymentPair{prior: d(ManifestKindService), post: d(ManifestKindWorker)}
+		chanset.Close()
+		var got []error
+		for {
+			select {
+			case err, ok := <-errs:
+				if !ok {
+					return client.DummyRectificationClient, got
+				}
+				got = append(got, err)
+			case <-time.After(time.Millisecond):
+				if clock.Waiters() > 0 {
+					clock.Advance(time.Second)
+				}
+			}
+		}
+	}
+
+	client, errs := recreate(2)
+	assert.Empty(errs)
+	assert.Len(client.CallsTo("InspectRequest"), 1, "only once the delete has finished")
+	assert.Len(client.CallsTo("PostRequestOfKind"), 1)
+
+	client, errs = recreate(10)
+	if assert.Len(errs, 1) {
+		assert.IsType(&RequestDeleting{}, errs[0].(*ChangeError).Err)
+	}
+	assert.Empty(client.CallsTo("PostRequestOfKind"), "a request still being deleted isn't posted over")
+}
+
+func TestCreatesRequestOfKind(t *testing.T) {
+	assert := assert.New(t)
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	d := &Deployment{
+		SourceVersion: SourceVersion{RepoURL: RepoURL("reqid"), Version: semv.MustParse("1.0.0")},
+		DeployConfig:  DeployConfig{NumInstances: 1},
+		Kind:          ManifestKindScheduled,
+		Schedule:      "0 3 * * *",
+		Cluster:       "cluster",
+	}
+	rectifyCreates(t, client, d)
+	assert.Empty(client.CallsTo("PostRequest"))
+	if posts := client.CallsTo("PostRequestOfKind"); assert.Len(posts, 1) {
+		assert.Equal(ManifestKindScheduled, posts[0].Args[3])
+		assert.Equal("0 3 * * *", posts[0].Args[4])
+	}
+
+	service := d.Clone()
+	service.SourceVersion.RepoURL, service.Kind, service.Schedule = "other", ManifestKindService, ""
+	rectifyCreates(t, client, service)
+	assert.Len(client.CallsTo("PostRequest"), 1, "http-services are posted as before")
+}
@@ -0,0 +1,160 @@
+package sous
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+type (
+	// AuditAction identifies what was done in an AuditEntry.
+	AuditAction string
+
+	// An AuditEntry records one action sous took against a cluster, whether
+	// it succeeded or failed.
+	AuditEntry struct {
+		Time time.Time
+		// RunID identifies the rectification the action was part of, and
+		// StateRevision the revision of the state it was rectifying, if
+		// they're known.
+		RunID         string `json:",omitempty"`
+		StateRevision string `json:",omitempty"`
+		// Ordering is the RectifyOrdering of the rectification the action
+		// was part of, if it was part of one.
+		Ordering      string `json:",omitempty"`
+		Action        AuditAction
+		Cluster       ClusterName
+		RequestID     string
+		DeployID      string `json:",omitempty"`
+		SourceVersion string `json:",omitempty"`
+		// PriorInstances and PostInstances are the request's instance
+		// count before and after the action.
+		PriorInstances int
+		PostInstances  int
+		Message        string `json:",omitempty"`
+		// Metadata is the Metadata of the deployment acted upon.
+		Metadata map[string]string `json:",omitempty"`
+		// Error is the error the action failed with, or empty if it
+		// succeeded.
+		Error string `json:",omitempty"`
+	}
+
+	// An Auditor keeps a record of actions taken against clusters. Record
+	// is called as soon as each action has succeeded or failed, possibly
+	// from several goroutines at once, and should only return once the
+	// entry is safely kept, so that it isn't lost if sous is stopped.
+	Auditor interface {
+		Record(AuditEntry) error
+	}
+
+	// AuditLog is an Auditor that appends each entry to a file as a line of
+	// JSON, and syncs the file before Record returns.
+	AuditLog struct {
+		sync.Mutex
+		f *os.File
+	}
+
+	// MemoryAuditor is an Auditor that collects entries in memory, for
+	// tests.
+	MemoryAuditor struct {
+		sync.Mutex
+		entries []AuditEntry
+	}
+)
+
+// The actions recorded in AuditEntries.
+const (
+	AuditCreate       AuditAction = "create"
+	AuditDeploy       AuditAction = "deploy"
+	AuditScale        AuditAction = "scale"
+	AuditDelete       AuditAction = "delete"
+	AuditCancelDeploy AuditAction = "cancel-deploy"
+)
+
+// NewAuditLog opens the file at path for appending audit entries to,
+// creating it if need be.
+func NewAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{f: f}, nil
+}
+
+// Record implements Auditor
+func (l *AuditLog) Record(e AuditEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	l.Lock()
+	defer l.Unlock()
+	// one write per entry, so that an interrupted run never leaves half a
+	// line for the next to append to
+	if _, err := l.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return l.f.Sync()
+}
+
+// Close closes the file.
+func (l *AuditLog) Close() error {
+	l.Lock()
+	defer l.Unlock()
+	return l.f.Close()
+}
+
+// NewMemoryAuditor returns an empty MemoryAuditor.
+func NewMemoryAuditor() *MemoryAuditor {
+	return &MemoryAuditor{}
+}
+
+// Record implements Auditor
+func (m *MemoryAuditor) Record(e AuditEntry) error {
+	m.Lock()
+	defer m.Unlock()
+	m.entries = append(m.entries, e)
+	return nil
+}
+
+// Entries returns the entries recorded so far, in the order they were
+// recorded.
+func (m *MemoryAuditor) Entries() []AuditEntry {
+	m.Lock()
+	defer m.Unlock()
+	return append([]AuditEntry{}, m.entries...)
+}
+
+// audit records an action on d with r.Auditor, if there is one. A failure
+// to record it is logged, rather than failing the rectification.
+func (r *rectifier) audit(action AuditAction, d *Deployment, reqID, depID string, prior int, message string, err error) {
+	if r.Auditor == nil {
+		return
+	}
+	post := d.NumInstances
+	if action == AuditDelete {
+		post = 0
+	}
+	e := AuditEntry{
+		Time:           r.clock().Now(),
+		RunID:          r.RunID,
+		StateRevision:  r.StateRevision,
+		Ordering:       r.Ordering.String(),
+		Action:         action,
+		Cluster:        d.Cluster,
+		RequestID:      reqID,
+		DeployID:       depID,
+		SourceVersion:  d.SourceVersion.String(),
+		PriorInstances: prior,
+		PostInstances:  post,
+		Message:        message,
+		Metadata:       d.Metadata,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if err := r.Auditor.Record(e); err != nil {
+		r.logFor(d, reqID).Warnf("Unable to record %s in the audit log: %s", action, err)
+	}
+}
@@ -0,0 +1,162 @@
+package sous
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opentable/sous/lib/clocktest"
+	"github.com/samsalisbury/semv"
+	"github.com/stretchr/testify/assert"
+)
+
+func auditedActions(entries []AuditEntry) []AuditAction {
+	actions := make([]AuditAction, len(entries))
+	for i, e := range entries {
+		actions[i] = e.Action
+	}
+	return actions
+}
+
+func TestRectifyAuditsActions(t *testing.T) {
+	assert := assert.New(t)
+	audit := NewMemoryAuditor()
+	clock := clocktest.NewClock(time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC))
+	opts := RectifyOpts{Auditor: audit, RunID: "run1", StateRevision: "abc123", Clock: clock, Ordering: DeletesFirst}
+	v1, v2 := semv.MustParse("1.0.0"), semv.MustParse("2.0.0")
+
+	create := &Deployment{
+		SourceVersion: SourceVersion{RepoURL: "created", Version: v1},
+		DeployConfig:  DeployConfig{NumInstances: 2},
+		Cluster:       "cluster",
+	}
+	modify := &DeploymentPair{
+		prior: &Deployment{
+			SourceVersion: SourceVersion{RepoURL: "modified", Version: v1},
+			DeployConfig:  DeployConfig{NumInstances: 1},
+			Cluster:       "cluster",
+		},
+		post: &Deployment{
+			SourceVersion: SourceVersion{RepoURL: "modified", Version: v2},
+			DeployConfig:  DeployConfig{NumInstances: 3},
+			Cluster:       "cluster",
+		},
+	}
+	remove := &Deployment{
+		SourceVersion: SourceVersion{RepoURL: "deleted", Version: v1},
+		DeployConfig:  DeployConfig{NumInstances: 4},
+		Cluster:       "cluster",
+	}
+
+	for _, send := range []func(DiffChans){
+		func(dc DiffChans) { dc.Created <- create },
+		func(dc DiffChans) { dc.Modified <- modify },
+		func(dc DiffChans) { dc.Deleted <- remove },
+	} {
+		chanset := NewDiffChans(1)
+		errs := RectifyWith(chanset, NewDummyRectificationClient(NewDummyNameCache()), opts)
+		send(chanset)
+		chanset.Close()
+		for e := range errs {
+			t.Error(e)
+		}
+	}
+
+	entries := audit.Entries()
+	if !assert.Equal([]AuditAction{
+		AuditCreate, AuditDeploy,
+		AuditScale, AuditDeploy,
+		AuditDelete,
+	}, auditedActions(entries)) {
+		return
+	}
+	for _, e := range entries {
+		assert.Equal("run1", e.RunID)
+		assert.Equal("abc123", e.StateRevision)
+		assert.Equal("deletes-first", e.Ordering)
+		assert.Equal(ClusterName("cluster"), e.Cluster)
+		assert.Empty(e.Error)
+		assert.Equal(clock.Now(), e.Time)
+	}
+	assert.Equal(ComputeRequestID(create), entries[0].RequestID)
+	assert.Equal(create.SourceVersion.String(), entries[0].SourceVersion)
+	assert.Equal([2]int{0, 2}, [2]int{entries[0].PriorInstances, entries[0].PostInstances})
+	assert.Equal(computeDeployID(entries[1].RequestID, "created 1.0.0", nil, nil, nil), entries[1].DeployID)
+	assert.Equal([2]int{1, 3}, [2]int{entries[2].PriorInstances, entries[2].PostInstances})
+	assert.Equal(modify.post.SourceVersion.String(), entries[3].SourceVersion)
+	assert.Equal([2]int{4, 0}, [2]int{entries[4].PriorInstances, entries[4].PostInstances})
+}
+
+func TestRectifyAuditsFailures(t *testing.T) {
+	assert := assert.New(t)
+	audit := NewMemoryAuditor()
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	client.FailWith("Deploy", fmt.Errorf("boom"))
+
+	chanset := NewDiffChans(1)
+	errs := RectifyWith(chanset, client, RectifyOpts{Auditor: audit, NewID: clocktest.IDs("run")})
+	chanset.Created <- &Deployment{
+		SourceVersion: SourceVersion{RepoURL: "reqid"},
+		DeployConfig:  DeployConfig{NumInstances: 1},
+		Cluster:       "cluster",
+	}
+	chanset.Close()
+	n := 0
+	for range errs {
+		n++
+	}
+	assert.Equal(1, n)
+
+	entries := audit.Entries()
+	if assert.Equal([]AuditAction{AuditCreate, AuditDeploy}, auditedActions(entries)) {
+		assert.Empty(entries[0].Error)
+		assert.Equal("boom", entries[1].Error)
+		assert.Equal("run1", entries[1].RunID, "a RunID should be made if none is given")
+	}
+}
+
+func TestAuditLogWritesEachEntryAtOnce(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "sous-audit")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.jsonl")
+
+	for _, reqID := range []string{"one", "two"} {
+		l, err := NewAuditLog(path)
+		if !assert.NoError(err) {
+			return
+		}
+		assert.NoError(l.Record(AuditEntry{Action: AuditScale, Cluster: "cluster", RequestID: reqID, PostInstances: 2}))
+		// read before closing, as a run that's killed never closes it
+		f, err := os.Open(path)
+		if !assert.NoError(err) {
+			return
+		}
+		got := []AuditEntry{}
+		lines := bufio.NewScanner(f)
+		for lines.Scan() {
+			e := AuditEntry{}
+			assert.NoError(json.Unmarshal(lines.Bytes(), &e))
+			got = append(got, e)
+		}
+		f.Close()
+		if assert.NotEmpty(got) {
+			assert.Equal(reqID, got[len(got)-1].RequestID)
+		}
+		assert.NoError(l.Close())
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if assert.NoError(err) {
+		assert.Equal(2, strings.Count(string(b), "\n"), "entries should be appended")
+	}
+}
@@ -0,0 +1,118 @@
+package sous
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ClusterError is a RectificationError from the pipeline of a particular
+// cluster, when rectifying with RectifyOpts.PerCluster.
+type ClusterError struct {
+	Cluster ClusterName
+	RectificationError
+}
+
+func (e *ClusterError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Cluster, e.RectificationError)
+}
+
+// clusterPipelines dispatches deployments to a rectification pipeline per
+// cluster, starting each pipeline when its first deployment arrives.
+type clusterPipelines struct {
+	sing RectificationClient
+	opts RectifyOpts
+	errs chan RectificationError
+
+	sync.Mutex
+	pipes map[ClusterName]DiffChans
+	// sends tracks deployments on their way into a pipeline, and forwards
+	// tracks the pipelines' error channels
+	sends, forwards sync.WaitGroup
+}
+
+func rectifyPerCluster(dcs DiffChans, s RectificationClient, opts RectifyOpts) chan RectificationError {
+	cp := &clusterPipelines{
+		sing:  s,
+		opts:  opts,
+		errs:  make(chan RectificationError),
+		pipes: map[ClusterName]DiffChans{},
+	}
+
+	dispatch := &sync.WaitGroup{}
+	dispatch.Add(4)
+	go func() {
+		for d := range dcs.Created {
+			cp.send(d.Cluster, func(p DiffChans) { p.Created <- d })
+		}
+		dispatch.Done()
+	}()
+	go func() {
+		for d := range dcs.Deleted {
+			cp.send(d.Cluster, func(p DiffChans) { p.Deleted <- d })
+		}
+		dispatch.Done()
+	}()
+	go func() {
+		for pair := range dcs.Modified {
+			cp.send(pair.post.Cluster, func(p DiffChans) { p.Modified <- pair })
+		}
+		dispatch.Done()
+	}()
+	go func() {
+		for range dcs.Retained {
+		}
+		dispatch.Done()
+	}()
+
+	go func() {
+		dispatch.Wait()
+		cp.sends.Wait()
+		cp.Lock()
+		for _, p := range cp.pipes {
+			p.Close()
+		}
+		cp.Unlock()
+		cp.forwards.Wait()
+		close(cp.errs)
+	}()
+
+	return cp.errs
+}
+
+// send hands a deployment to the pipeline for cluster without waiting for
+// the pipeline to accept it, so that a stuck cluster can't block dispatch to
+// the others.
+func (cp *clusterPipelines) send(cluster ClusterName, f func(DiffChans)) {
+	p := cp.pipeline(cluster)
+	cp.sends.Add(1)
+	go func() {
+		f(p)
+		cp.sends.Done()
+	}()
+}
+
+func (cp *clusterPipelines) pipeline(cluster ClusterName) DiffChans {
+	cp.Lock()
+	defer cp.Unlock()
+	if p, ok := cp.pipes[cluster]; ok {
+		return p
+	}
+
+	opts, ok := cp.opts.ClusterOpts[cluster]
+	if !ok {
+		opts = cp.opts
+	}
+	opts.PerCluster, opts.ClusterOpts = false, nil
+
+	p := NewDiffChans()
+	cp.pipes[cluster] = p
+	errs := rectify(p, cp.sing, opts)
+	cp.forwards.Add(1)
+	go func() {
+		for err := range errs {
+			cp.errs <- &ClusterError{Cluster: cluster, RectificationError: err}
+		}
+		cp.forwards.Done()
+	}()
+	return p
+}
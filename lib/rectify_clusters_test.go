@@ -0,0 +1,100 @@
+package sous
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// clusterHangingClient blocks PostRequest calls against one cluster until
+// released, and serializes calls to the underlying dummy client.
+type clusterHangingClient struct {
+	*DummyRectificationClient
+	hangOn  ClusterName
+	release chan struct{}
+	posted  chan ClusterName
+	sync.Mutex
+}
+
+func (c *clusterHangingClient) PostRequest(cluster ClusterName, id string, count int) error {
+	if cluster == c.hangOn {
+		<-c.release
+	}
+	c.Lock()
+	defer c.Unlock()
+	err := c.DummyRectificationClient.PostRequest(cluster, id, count)
+	c.posted <- cluster
+	return err
+}
+
+func (c *clusterHangingClient) Deploy(cluster ClusterName, depID, reqID, imageName string, r Resources, e Env, vols Volumes) error {
+	c.Lock()
+	defer c.Unlock()
+	return c.DummyRectificationClient.Deploy(cluster, depID, reqID, imageName, r, e, vols)
+}
+
+func (c *clusterHangingClient) ImageName(d *Deployment) (string, error) {
+	c.Lock()
+	defer c.Unlock()
+	return c.DummyRectificationClient.ImageName(d)
+}
+
+func TestPerClusterRectificationIsolatesClusters(t *testing.T) {
+	assert := assert.New(t)
+
+	client := &clusterHangingClient{
+		DummyRectificationClient: NewDummyRectificationClient(NewDummyNameCache()),
+		hangOn:                   "a",
+		release:                  make(chan struct{}),
+		posted:                   make(chan ClusterName, 2),
+	}
+
+	chanset := NewDiffChans(2)
+	errs := RectifyWith(chanset, client, RectifyOpts{PerCluster: true})
+	chanset.Created <- &Deployment{SourceVersion: SourceVersion{RepoURL: "one"}, Cluster: "a"}
+	chanset.Created <- &Deployment{SourceVersion: SourceVersion{RepoURL: "two"}, Cluster: "b"}
+	chanset.Close()
+
+	select {
+	case cluster := <-client.posted:
+		assert.Equal(ClusterName("b"), cluster)
+	case <-time.After(time.Second):
+		t.Fatal("cluster b was held up by cluster a")
+	}
+
+	close(client.release)
+	for e := range errs {
+		t.Error(e)
+	}
+	assert.Equal(ClusterName("a"), <-client.posted)
+	assert.Len(client.deployed, 2)
+}
+
+func TestPerClusterErrorsAreTagged(t *testing.T) {
+	assert := assert.New(t)
+
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	client.FailWith("DeleteRequest", errors.New("nope"))
+
+	chanset := NewDiffChans(1)
+	opts := RectifyOpts{
+		PerCluster:  true,
+		ClusterOpts: map[ClusterName]RectifyOpts{"a": {Timeout: time.Second}},
+	}
+	errs := RectifyWith(chanset, client, opts)
+	chanset.Deleted <- &Deployment{SourceVersion: SourceVersion{RepoURL: "one"}, Cluster: "a"}
+	chanset.Close()
+
+	count := 0
+	for e := range errs {
+		count++
+		if assert.IsType(&ClusterError{}, e) {
+			assert.Equal(ClusterName("a"), e.(*ClusterError).Cluster)
+			assert.IsType(&DeleteError{}, e.(*ClusterError).RectificationError)
+		}
+	}
+	assert.Equal(1, count)
+}
@@ -0,0 +1,125 @@
+package sous
+
+import "sync"
+
+// DefaultRectifyWorkers is how many operations a rectification runs at once
+// if RectifyOpts.Workers is zero.
+const DefaultRectifyWorkers = 3
+
+// rectifyOp is a create, delete or modify to be run by a rectifier.
+type rectifyOp struct {
+	// key identifies the request the operation changes: operations with
+	// the same key are never run at once.
+	key string
+	run func()
+}
+
+// opKey returns the key of operations on the request reqID in cluster.
+func opKey(cluster ClusterName, reqID string) string {
+	return string(cluster) + "\x00" + reqID
+}
+
+// operations reads the creates, deletes and modifies from dcs as they're
+// sent, and returns them as one stream of operations, in the order
+// r.Ordering says, which is closed once dcs all are. Operations send their
+// errors to errs.
+func (r *rectifier) operations(dcs DiffChans, errs chan<- RectificationError) <-chan rectifyOp {
+	ops := make(chan rectifyOp)
+	creates, deletes, modifies := make(chan rectifyOp), make(chan rectifyOp), make(chan rectifyOp)
+	go func() {
+		for d := range dcs.Created {
+			d := d
+			creates <- rectifyOp{opKey(d.Cluster, r.requestID(d)), func() { r.rectifyCreate(d, errs) }}
+		}
+		close(creates)
+	}()
+	go func() {
+		for d := range dcs.Deleted {
+			d := d
+			deletes <- rectifyOp{opKey(d.Cluster, r.requestID(d)), func() { r.rectifyDelete(d, errs) }}
+		}
+		close(deletes)
+	}()
+	go func() {
+		for pair := range dcs.Modified {
+			pair := pair
+			modifies <- rectifyOp{opKey(pair.post.Cluster, r.requestID(pair.prior)), func() { r.rectifyModify(pair, errs) }}
+		}
+		close(modifies)
+	}()
+	// nothing to do for retained deployments, but the sender mustn't block
+	retained := make(chan struct{})
+	go func() {
+		for range dcs.Retained {
+		}
+		close(retained)
+	}()
+	go func() {
+		r.order(creates, deletes, modifies, ops)
+		<-retained
+		close(ops)
+	}()
+	return ops
+}
+
+// dispatch runs ops on r.Workers workers, and returns once they have all
+// finished. An operation whose key is the same as one that's running, or
+// queued, waits for it to finish, so operations on each request run one at
+// a time, in the order they were received.
+func (r *rectifier) dispatch(ops <-chan rectifyOp) {
+	workers := r.Workers
+	if workers <= 0 {
+		workers = DefaultRectifyWorkers
+	}
+	work := make(chan rectifyOp)
+	done := make(chan string)
+	wg := &sync.WaitGroup{}
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for op := range work {
+				op.run()
+				done <- op.key
+			}
+			wg.Done()
+		}()
+	}
+
+	// queued holds the operations waiting behind the one running, or ready
+	// to run, for each key; a key is busy as long as it's present.
+	queued := map[string][]rectifyOp{}
+	// ready are operations that can run as soon as a worker is free.
+	ready := []rectifyOp{}
+	for ops != nil || len(queued) != 0 {
+		var send chan rectifyOp
+		var next rectifyOp
+		if len(ready) != 0 {
+			send, next = work, ready[0]
+		}
+		select {
+		case op, ok := <-ops:
+			if !ok {
+				ops = nil
+				continue
+			}
+			if q, busy := queued[op.key]; busy {
+				queued[op.key] = append(q, op)
+				continue
+			}
+			queued[op.key] = nil
+			ready = append(ready, op)
+		case send <- next:
+			ready = ready[1:]
+		case key := <-done:
+			q := queued[key]
+			if len(q) == 0 {
+				delete(queued, key)
+				continue
+			}
+			ready = append(ready, q[0])
+			queued[key] = q[1:]
+		}
+	}
+	close(work)
+	wg.Wait()
+}
@@ -0,0 +1,169 @@
+package sous
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serialCheckingClient records the calls made for each request, and counts
+// calls for the same request that overlap.
+type serialCheckingClient struct {
+	*DummyRectificationClient
+	sync.Mutex
+	inFlight map[string]bool
+	overlaps int
+	// calls has a letter for each call made for a request, in order: see
+	// requestCall.
+	calls map[string]*strings.Builder
+}
+
+func newSerialCheckingClient() *serialCheckingClient {
+	return &serialCheckingClient{
+		DummyRectificationClient: NewDummyRectificationClient(NewDummyNameCache()),
+		inFlight:                 map[string]bool{},
+		calls:                    map[string]*strings.Builder{},
+	}
+}
+
+// requestCall records a call for reqID, abbreviated as letter, and makes it
+// last long enough that another call for the same request would overlap.
+func (c *serialCheckingClient) requestCall(reqID, letter string, f func() error) error {
+	c.Lock()
+	if c.inFlight[reqID] {
+		c.overlaps++
+	}
+	c.inFlight[reqID] = true
+	if c.calls[reqID] == nil {
+		c.calls[reqID] = &strings.Builder{}
+	}
+	c.calls[reqID].WriteString(letter)
+	c.Unlock()
+
+	time.Sleep(100 * time.Microsecond)
+	err := f()
+
+	c.Lock()
+	c.inFlight[reqID] = false
+	c.Unlock()
+	return err
+}
+
+func (c *serialCheckingClient) ImageName(d *Deployment) (name string, err error) {
+	err = c.requestCall(ComputeRequestID(d), "I", func() error {
+		name, err = c.DummyRectificationClient.ImageName(d)
+		return err
+	})
+	return name, err
+}
+
+func (c *serialCheckingClient) PostRequest(cluster ClusterName, reqID string, instanceCount int) error {
+	return c.requestCall(reqID, "P", func() error {
+		return c.DummyRectificationClient.PostRequest(cluster, reqID, instanceCount)
+	})
+}
+
+func (c *serialCheckingClient) PendingDeploy(cluster ClusterName, reqID string) (pending bool, depID string, err error) {
+	err = c.requestCall(reqID, "Q", func() error {
+		pending, depID, err = c.DummyRectificationClient.PendingDeploy(cluster, reqID)
+		return err
+	})
+	return pending, depID, err
+}
+
+func (c *serialCheckingClient) Deploy(cluster ClusterName, depID, reqID, imageName string, r Resources, e Env, vols Volumes) error {
+	return c.requestCall(reqID, "D", func() error {
+		return c.DummyRectificationClient.Deploy(cluster, depID, reqID, imageName, r, e, vols)
+	})
+}
+
+func (c *serialCheckingClient) DeleteRequest(cluster ClusterName, reqID, message string) error {
+	return c.requestCall(reqID, "X", func() error {
+		return c.DummyRectificationClient.DeleteRequest(cluster, reqID, message)
+	})
+}
+
+// wholeOperations matches the calls for a request when each create (image
+// name, post request, pending deploy, deploys) or delete finishes before the
+// next starts. A create stops early if one of its calls fails.
+var wholeOperations = regexp.MustCompile(`^(I(P(QD*)?)?|X)*$`)
+
+func TestRectifySerializesOperationsPerRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	client := newSerialCheckingClient()
+	chanset := NewDiffChans()
+	errs := RectifyWith(chanset, client, RectifyOpts{Workers: 8})
+
+	const requests, rounds = 4, 25
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	send := func(c chan *Deployment, n int) {
+		for i := 0; i < rounds; i++ {
+			c <- &Deployment{
+				SourceVersion: SourceVersion{RepoURL: RepoURL(fmt.Sprintf("req%d", (i+n)%requests))},
+				DeployConfig:  DeployConfig{NumInstances: 1},
+				Cluster:       "cluster",
+			}
+		}
+		wg.Done()
+	}
+	go send(chanset.Created, 0)
+	go send(chanset.Deleted, 1)
+	wg.Wait()
+	chanset.Close()
+	for range errs {
+	}
+
+	client.Lock()
+	defer client.Unlock()
+	assert.Zero(client.overlaps, "calls for the same request overlapped")
+	assert.Len(client.calls, requests)
+	for reqID, calls := range client.calls {
+		assert.Regexp(wholeOperations, calls.String(), "operations on %s interleaved", reqID)
+	}
+}
+
+func TestRectifyRunsOperationsOnDifferentRequestsAtOnce(t *testing.T) {
+	client := newSerialCheckingClient()
+	block := make(chan struct{})
+	started := make(chan string, 2)
+	blocking := &blockingDeleteClient{serialCheckingClient: client, block: block, started: started}
+
+	chanset := NewDiffChans(2)
+	errs := RectifyWith(chanset, blocking, RectifyOpts{Workers: 2})
+	for _, id := range []string{"one", "two"} {
+		chanset.Deleted <- &Deployment{SourceVersion: SourceVersion{RepoURL: RepoURL(id)}, Cluster: "cluster"}
+	}
+	chanset.Close()
+
+	// Both deletes must start before either is allowed to finish.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("deletes of different requests didn't run at once")
+		}
+	}
+	close(block)
+	for e := range errs {
+		t.Error(e)
+	}
+}
+
+type blockingDeleteClient struct {
+	*serialCheckingClient
+	block   chan struct{}
+	started chan string
+}
+
+func (c *blockingDeleteClient) DeleteRequest(cluster ClusterName, reqID, message string) error {
+	c.started <- reqID
+	<-c.block
+	return c.serialCheckingClient.DeleteRequest(cluster, reqID, message)
+}
@@ -0,0 +1,99 @@
+package sous
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/opentable/go-singularity"
+)
+
+// ErrorKind classifies the cause of a RectificationError.
+type ErrorKind uint
+
+const (
+	// UnknownError is an error whose cause couldn't be classified. It is
+	// treated as terminal, so that a human gets to look at it.
+	UnknownError ErrorKind = iota
+	// TransientError is a failure talking to the cluster that is expected to
+	// clear up on its own, e.g. a timeout or a 5xx response.
+	TransientError
+	// RegistryUnavailableError means no image name was found because a
+	// docker registry couldn't be reached.
+	RegistryUnavailableError
+	// ImageNotFoundError means no image has been built for the deployment's
+	// source version.
+	ImageNotFoundError
+	// ValidationError means the cluster rejected the request as invalid.
+	ValidationError
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	default:
+		return fmt.Sprintf("ErrorKind(%d)", uint(k))
+	case UnknownError:
+		return "unknown"
+	case TransientError:
+		return "transient"
+	case RegistryUnavailableError:
+		return "registry unavailable"
+	case ImageNotFoundError:
+		return "image not found"
+	case ValidationError:
+		return "validation"
+	}
+}
+
+// Retryable reports whether an error of this kind is likely to succeed if
+// the same rectification is attempted again later.
+func (k ErrorKind) Retryable() bool {
+	return k == TransientError || k == RegistryUnavailableError
+}
+
+// classifyError determines the ErrorKind of the cause of a rectification
+// failure.
+func classifyError(err error) ErrorKind {
+	switch e := err.(type) {
+	default:
+		if t, ok := err.(interface {
+			Temporary() bool
+		}); ok && t.Temporary() {
+			return TransientError
+		}
+		return UnknownError
+	case NoImageNameFound, *NoImageNameFound:
+		return ImageNotFoundError
+	case RegistryUnavailable, *RegistryUnavailable:
+		return RegistryUnavailableError
+	case *DeployIDConflict, *RequestNotFound:
+		return ValidationError
+	case *RolloutError:
+		return classifyError(e.Err)
+	case *singularity.ReqError:
+		if e.Status >= http.StatusInternalServerError || e.Status == http.StatusTooManyRequests {
+			return TransientError
+		}
+		if e.Status >= http.StatusBadRequest {
+			return ValidationError
+		}
+		return UnknownError
+	}
+}
+
+// Kind classifies the cause of the error
+func (e *CreateError) Kind() ErrorKind { return classifyError(e.Err) }
+
+// Retryable reports whether the create might succeed if attempted again
+func (e *CreateError) Retryable() bool { return e.Kind().Retryable() }
+
+// Kind classifies the cause of the error
+func (e *DeleteError) Kind() ErrorKind { return classifyError(e.Err) }
+
+// Retryable reports whether the delete might succeed if attempted again
+func (e *DeleteError) Retryable() bool { return e.Kind().Retryable() }
+
+// Kind classifies the cause of the error
+func (e *ChangeError) Kind() ErrorKind { return classifyError(e.Err) }
+
+// Retryable reports whether the change might succeed if attempted again
+func (e *ChangeError) Retryable() bool { return e.Kind().Retryable() }
@@ -0,0 +1,77 @@
+package sous
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/opentable/go-singularity"
+	"github.com/stretchr/testify/assert"
+)
+
+type temporaryErr struct{}
+
+func (temporaryErr) Error() string   { return "temporary" }
+func (temporaryErr) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(UnknownError, classifyError(errors.New("boom")))
+	assert.Equal(TransientError, classifyError(temporaryErr{}))
+	assert.Equal(ImageNotFoundError, classifyError(NoImageNameFound{}))
+	assert.Equal(RegistryUnavailableError, classifyError(RegistryUnavailable{Repo: "repo", Err: errors.New("down")}))
+	assert.Equal(ValidationError, classifyError(&DeployIDConflict{DeployID: "x"}))
+	assert.Equal(TransientError, classifyError(&singularity.ReqError{Status: http.StatusBadGateway}))
+	assert.Equal(TransientError, classifyError(&singularity.ReqError{Status: http.StatusTooManyRequests}))
+	assert.Equal(ValidationError, classifyError(&singularity.ReqError{Status: http.StatusBadRequest}))
+}
+
+func TestRectificationErrorsAreClassified(t *testing.T) {
+	d := &Deployment{
+		SourceVersion: SourceVersion{RepoURL: RepoURL("reqid")},
+		DeployConfig:  DeployConfig{NumInstances: 1},
+		Cluster:       "cluster",
+	}
+	changed := &Deployment{
+		SourceVersion: SourceVersion{RepoURL: RepoURL("reqid")},
+		DeployConfig:  DeployConfig{NumInstances: 2},
+		Cluster:       "cluster",
+	}
+
+	cases := []struct {
+		method    string
+		cause     error
+		send      func(DiffChans)
+		kind      ErrorKind
+		retryable bool
+	}{
+		{"ImageName", NoImageNameFound{d.SourceVersion},
+			func(dc DiffChans) { dc.Created <- d }, ImageNotFoundError, false},
+		{"ImageName", RegistryUnavailable{"repo", errors.New("down")},
+			func(dc DiffChans) { dc.Created <- d }, RegistryUnavailableError, true},
+		{"PostRequest", &singularity.ReqError{Status: http.StatusBadRequest},
+			func(dc DiffChans) { dc.Created <- d }, ValidationError, false},
+		{"DeleteRequest", &singularity.ReqError{Status: http.StatusServiceUnavailable},
+			func(dc DiffChans) { dc.Deleted <- d }, TransientError, true},
+		{"Scale", temporaryErr{},
+			func(dc DiffChans) { dc.Modified <- &DeploymentPair{prior: d, post: changed} }, TransientError, true},
+	}
+
+	for _, c := range cases {
+		client := NewDummyRectificationClient(NewDummyNameCache())
+		client.FailWith(c.method, c.cause)
+		chanset := NewDiffChans(1)
+		errs := Rectify(chanset, client)
+		c.send(chanset)
+		chanset.Close()
+
+		count := 0
+		for e := range errs {
+			count++
+			assert.Equal(t, c.kind, e.Kind(), "%s failing with %v", c.method, c.cause)
+			assert.Equal(t, c.retryable, e.Retryable(), "%s failing with %v", c.method, c.cause)
+		}
+		assert.Equal(t, 1, count, "%s failing with %v", c.method, c.cause)
+	}
+}
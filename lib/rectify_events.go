@@ -0,0 +1,119 @@
+package sous
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/opentable/sous/util/metrics"
+)
+
+type (
+	// RectifyEventKind identifies what happened in a RectifyEvent.
+	RectifyEventKind uint
+
+	// A RectifyEvent reports the progress of an operation performed by the
+	// rectifier.
+	RectifyEvent struct {
+		Kind RectifyEventKind
+		// Deployment is the intended deployment being rectified, or for
+		// deletes the deployment being removed. Its Metadata describes it
+		// for whoever handles the event.
+		Deployment *Deployment
+		// Cluster and RequestID identify the Singularity request acted upon.
+		Cluster   ClusterName
+		RequestID string
+		// Started is when the rectifier began working on Deployment, and
+		// Occurred is when this event happened.
+		Started, Occurred time.Time
+		// Message describes the event, e.g. the reason for a skip.
+		Message string
+		// Freeze is the freeze that caused a SkippedFrozen event.
+		Freeze *Freeze
+	}
+)
+
+const (
+	// OperationStarted is emitted when the rectifier begins working on a
+	// deployment.
+	OperationStarted RectifyEventKind = iota
+	// RequestPosted is emitted once a new request has been created.
+	RequestPosted
+	// Deployed is emitted once a deploy has been issued for a request.
+	Deployed
+	// Scaled is emitted once a request's instance count has been changed.
+	Scaled
+	// Deleted is emitted once a request has been deleted.
+	Deleted
+	// Skipped is emitted when the rectifier decides not to act on a
+	// deployment.
+	Skipped
+	// SkippedFrozen is emitted instead of acting on a deployment that a
+	// freeze applies to. Its Message says what would have been done.
+	SkippedFrozen
+	// ClusterCircuitOpen is emitted instead of acting on a deployment on a
+	// cluster whose circuit is open. Its Message says what would have been
+	// done.
+	ClusterCircuitOpen
+)
+
+func (k RectifyEventKind) String() string {
+	switch k {
+	default:
+		return fmt.Sprintf("RectifyEventKind(%d)", uint(k))
+	case OperationStarted:
+		return "started"
+	case RequestPosted:
+		return "request posted"
+	case Deployed:
+		return "deployed"
+	case Scaled:
+		return "scaled"
+	case Deleted:
+		return "deleted"
+	case Skipped:
+		return "skipped"
+	case SkippedFrozen:
+		return "skipped (frozen)"
+	case ClusterCircuitOpen:
+		return "skipped (circuit open)"
+	}
+}
+
+func (e RectifyEvent) String() string {
+	return fmt.Sprintf("%s %s on %s: %s", e.Kind, e.RequestID, e.Cluster, e.Message)
+}
+
+// started emits an OperationStarted event, and returns its time for use in
+// later events about the same operation.
+func (r *rectifier) started(d *Deployment, reqID string) time.Time {
+	now := r.clock().Now()
+	r.emit(OperationStarted, d, reqID, now, "")
+	return now
+}
+
+// emit sends an event to r.Events without blocking. If the channel is not
+// ready, the event is dropped.
+func (r *rectifier) emit(kind RectifyEventKind, d *Deployment, reqID string, started time.Time, message string) {
+	r.send(RectifyEvent{
+		Kind:       kind,
+		Deployment: d,
+		Cluster:    d.Cluster,
+		RequestID:  reqID,
+		Started:    started,
+		Occurred:   r.clock().Now(),
+		Message:    message,
+	})
+}
+
+// send sends ev to r.Events without blocking, as emit does.
+func (r *rectifier) send(ev RectifyEvent) {
+	r.metrics().AddCounter(MetricRectifyActions, metrics.Labels{"kind": ev.Kind.String()}, 1)
+	if r.Events == nil {
+		return
+	}
+	select {
+	case r.Events <- ev:
+	default:
+		r.logFor(ev.Deployment, ev.RequestID).Debugf("Dropped rectify event: %s", ev)
+	}
+}
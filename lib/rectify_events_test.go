@@ -0,0 +1,82 @@
+package sous
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func eventKinds(events chan RectifyEvent) []RectifyEventKind {
+	kinds := []RectifyEventKind{}
+	for {
+		select {
+		case ev := <-events:
+			kinds = append(kinds, ev.Kind)
+		default:
+			return kinds
+		}
+	}
+}
+
+func TestRectifyEventsForCreate(t *testing.T) {
+	assert := assert.New(t)
+
+	chanset := NewDiffChans(1)
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	events := make(chan RectifyEvent, 10)
+
+	errs := RectifyWith(chanset, client, RectifyOpts{Events: events})
+	chanset.Created <- &Deployment{
+		SourceVersion: SourceVersion{RepoURL: RepoURL("reqid")},
+		DeployConfig:  DeployConfig{NumInstances: 1},
+		Cluster:       "cluster",
+	}
+	chanset.Close()
+	for e := range errs {
+		t.Error(e)
+	}
+
+	assert.Equal([]RectifyEventKind{OperationStarted, RequestPosted, Deployed}, eventKinds(events))
+}
+
+func TestRectifyEventsForUnchangedModify(t *testing.T) {
+	assert := assert.New(t)
+
+	d := &Deployment{
+		SourceVersion: SourceVersion{RepoURL: RepoURL("reqid")},
+		DeployConfig:  DeployConfig{NumInstances: 1},
+		Cluster:       "cluster",
+	}
+	chanset := NewDiffChans(1)
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	events := make(chan RectifyEvent, 10)
+
+	errs := RectifyWith(chanset, client, RectifyOpts{Events: events})
+	chanset.Modified <- &DeploymentPair{prior: d, post: d}
+	chanset.Close()
+	for e := range errs {
+		t.Error(e)
+	}
+
+	assert.Equal([]RectifyEventKind{OperationStarted, Skipped}, eventKinds(events))
+}
+
+func TestRectifyEventsDropWhenFull(t *testing.T) {
+	assert := assert.New(t)
+
+	chanset := NewDiffChans(1)
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	events := make(chan RectifyEvent)
+
+	errs := RectifyWith(chanset, client, RectifyOpts{Events: events})
+	chanset.Deleted <- &Deployment{
+		SourceVersion: SourceVersion{RepoURL: RepoURL("reqid")},
+		Cluster:       "cluster",
+	}
+	chanset.Close()
+	for e := range errs {
+		t.Error(e)
+	}
+
+	assert.Len(client.deleted, 1)
+}
@@ -0,0 +1,207 @@
+package sous
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RectifyOrdering says whether a rectification runs its deletes before,
+// after, or alongside its creates and modifies.
+type RectifyOrdering uint
+
+const (
+	// CreatesFirst runs deletes only once every create and modify has
+	// finished, so that a deployment moving between manifests is running in
+	// its new place before it's removed from its old one. It's the default.
+	CreatesFirst RectifyOrdering = iota
+	// DeletesFirst runs creates and modifies only once every delete has
+	// finished, e.g. to free resources on a full cluster.
+	DeletesFirst
+	// Concurrent runs creates, deletes and modifies in whatever order
+	// they're received.
+	Concurrent
+)
+
+func (o RectifyOrdering) String() string {
+	switch o {
+	default:
+		return fmt.Sprintf("RectifyOrdering(%d)", uint(o))
+	case CreatesFirst:
+		return "creates-first"
+	case DeletesFirst:
+		return "deletes-first"
+	case Concurrent:
+		return "concurrent"
+	}
+}
+
+// Explain says what o makes a rectification do, for reports of one.
+func (o RectifyOrdering) Explain() string {
+	switch o {
+	default:
+		return fmt.Sprintf("Changes are run in the unknown order %s", o)
+	case CreatesFirst:
+		return "Deletes wait for creates and modifies to finish"
+	case DeletesFirst:
+		return "Creates and modifies wait for deletes to finish"
+	case Concurrent:
+		return "Creates, deletes and modifies run at once"
+	}
+}
+
+// ParseRectifyOrdering returns the RectifyOrdering named s, as its String
+// method names it. An empty s means CreatesFirst.
+func ParseRectifyOrdering(s string) (RectifyOrdering, error) {
+	if s == "" {
+		return CreatesFirst, nil
+	}
+	for _, o := range []RectifyOrdering{CreatesFirst, DeletesFirst, Concurrent} {
+		if strings.EqualFold(s, o.String()) {
+			return o, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown rectify ordering %q: want creates-first, deletes-first or concurrent", s)
+}
+
+// rectifyPhase tracks the operations of the phase of an ordered
+// rectification that runs first, so that the next phase can wait for it.
+type rectifyPhase struct {
+	sync.Mutex
+	// open is how many of the phase's channels haven't been closed yet.
+	open int
+	// running is how many operations have been received from them but
+	// haven't finished.
+	running int
+	// changed is sent to, without blocking, whenever either changes.
+	changed chan struct{}
+}
+
+func newRectifyPhase(channels int) *rectifyPhase {
+	return &rectifyPhase{open: channels, changed: make(chan struct{}, 1)}
+}
+
+func (p *rectifyPhase) update(open, running int) {
+	p.Lock()
+	p.open += open
+	p.running += running
+	p.Unlock()
+	select {
+	case p.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (p *rectifyPhase) state() (open, running int) {
+	p.Lock()
+	defer p.Unlock()
+	return p.open, p.running
+}
+
+// track forwards the operations received from in to out, counting each as
+// running until it has been run.
+func (p *rectifyPhase) track(in <-chan rectifyOp, out chan<- rectifyOp) {
+	for op := range in {
+		p.update(0, 1)
+		run := op.run
+		op.run = func() {
+			defer p.update(0, -1)
+			run()
+		}
+		out <- op
+	}
+	p.update(-1, 0)
+}
+
+// wait returns true once all of p's channels have closed and all of its
+// operations have finished. An operation that's running is waited for, since
+// each of its calls has a timeout; but if nothing is running, and nothing is
+// received for idle, the phase's channels are taken never to be closing, and
+// wait returns false.
+func (p *rectifyPhase) wait(clock Clock, idle time.Duration) bool {
+	for {
+		open, running := p.state()
+		if open == 0 && running == 0 {
+			return true
+		}
+		var timeout <-chan time.Time
+		if running == 0 {
+			timeout = clock.After(idle)
+		}
+		select {
+		case <-p.changed:
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+// hold forwards the operations received from in to out, but only once gate
+// is closed. It keeps receiving from in meanwhile, so that whatever is
+// sending isn't blocked.
+func hold(gate <-chan struct{}, in <-chan rectifyOp, out chan<- rectifyOp) {
+	var held []rectifyOp
+	open := false
+	for in != nil || len(held) != 0 {
+		var send chan<- rectifyOp
+		var next rectifyOp
+		if open && len(held) != 0 {
+			send, next = out, held[0]
+		}
+		select {
+		case <-gate:
+			open, gate = true, nil
+		case op, ok := <-in:
+			if !ok {
+				in = nil
+				continue
+			}
+			held = append(held, op)
+		case send <- next:
+			held = held[1:]
+		}
+	}
+}
+
+// order sends the creates, deletes and modifies received on their channels
+// to ops, in the order r.Ordering says, and returns once they're all closed
+// and everything received has been sent.
+func (r *rectifier) order(creates, deletes, modifies <-chan rectifyOp, ops chan<- rectifyOp) {
+	forward := func(in <-chan rectifyOp) {
+		for op := range in {
+			ops <- op
+		}
+	}
+	first, second := []<-chan rectifyOp{creates, modifies}, []<-chan rectifyOp{deletes}
+	switch r.Ordering {
+	case Concurrent:
+		first, second = append(first, deletes), nil
+	case DeletesFirst:
+		first, second = second, first
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(len(first) + len(second))
+	if second == nil {
+		for _, in := range first {
+			go func(in <-chan rectifyOp) { forward(in); wg.Done() }(in)
+		}
+		wg.Wait()
+		return
+	}
+
+	phase := newRectifyPhase(len(first))
+	for _, in := range first {
+		go func(in <-chan rectifyOp) { phase.track(in, ops); wg.Done() }(in)
+	}
+	gate := make(chan struct{})
+	for _, in := range second {
+		go func(in <-chan rectifyOp) { hold(gate, in, ops); wg.Done() }(in)
+	}
+	if idle := r.timeout(); !phase.wait(r.clock(), idle) {
+		r.logger().Warnf("Rectifying %s: nothing received for %s, but the first phase's channels are still open; running the next phase anyway", r.Ordering, idle)
+	}
+	close(gate)
+	wg.Wait()
+}
@@ -0,0 +1,88 @@
+package sous
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// rectifyMove rectifies a deployment moving from the request "old" to the
+// request "new", sending the delete before the create, and returns the
+// position of the calls that delete old and deploy new among those made.
+func rectifyMove(t *testing.T, ordering RectifyOrdering) (deleted, deployed int) {
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	chanset := NewDiffChans(1)
+	errs := RectifyWith(chanset, client, RectifyOpts{Ordering: ordering})
+	chanset.Deleted <- &Deployment{SourceVersion: SourceVersion{RepoURL: "old"}, Cluster: "cluster"}
+	chanset.Created <- &Deployment{
+		SourceVersion: SourceVersion{RepoURL: "new"},
+		DeployConfig:  DeployConfig{NumInstances: 1},
+		Cluster:       "cluster",
+	}
+	chanset.Close()
+	for e := range errs {
+		t.Errorf("%s: %v", ordering, e)
+	}
+
+	deleted, deployed = -1, -1
+	for i, c := range client.Calls() {
+		switch c.Method {
+		case "DeleteRequest":
+			deleted = i
+		case "Deploy":
+			deployed = i
+		}
+	}
+	if deleted < 0 || deployed < 0 {
+		t.Fatalf("%s: old wasn't deleted or new wasn't deployed: %v", ordering, client.Calls())
+	}
+	return deleted, deployed
+}
+
+func TestRectifyOrdering(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		deleted, deployed := rectifyMove(t, CreatesFirst)
+		assert.True(t, deployed < deleted, "creates-first deleted old before new was deployed")
+		deleted, deployed = rectifyMove(t, DeletesFirst)
+		assert.True(t, deleted < deployed, "deletes-first deployed new before old was deleted")
+	}
+	rectifyMove(t, Concurrent)
+}
+
+func TestRectifyOrderingDoesntWaitForUnclosedPhase(t *testing.T) {
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	chanset := NewDiffChans(1)
+	errs := RectifyWith(chanset, client, RectifyOpts{Timeout: 20 * time.Millisecond})
+	chanset.Deleted <- &Deployment{SourceVersion: SourceVersion{RepoURL: "old"}, Cluster: "cluster"}
+	close(chanset.Deleted)
+	close(chanset.Modified)
+	close(chanset.Retained)
+
+	// Created is left open, but the delete mustn't wait for it forever.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(client.CallsTo("DeleteRequest")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the delete waited for a create phase that never finished")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	close(chanset.Created)
+	for e := range errs {
+		t.Error(e)
+	}
+}
+
+func TestParseRectifyOrdering(t *testing.T) {
+	for _, o := range []RectifyOrdering{CreatesFirst, DeletesFirst, Concurrent} {
+		got, err := ParseRectifyOrdering(o.String())
+		assert.NoError(t, err)
+		assert.Equal(t, o, got)
+	}
+	got, err := ParseRectifyOrdering("")
+	assert.NoError(t, err)
+	assert.Equal(t, CreatesFirst, got)
+	_, err = ParseRectifyOrdering("sideways")
+	assert.Error(t, err)
+}
@@ -0,0 +1,57 @@
+package sous
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/opentable/sous/util/metrics"
+)
+
+// DefaultRectifyTimeout is how long the rectifier waits for a single call to
+// its RectificationClient when RectifyOpts doesn't specify a Timeout.
+const DefaultRectifyTimeout = 2 * time.Minute
+
+// TimeoutError is the cause of a RectificationError when a call to the
+// RectificationClient didn't return in time.
+type TimeoutError struct {
+	// Op is the name of the RectificationClient method that was called.
+	Op string
+	// After is how long the call was waited for.
+	After time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %s", e.Op, e.After)
+}
+
+// Timeout is always true; it lets TimeoutError be recognized like a net.Error.
+func (e *TimeoutError) Timeout() bool { return true }
+
+// Temporary is always true: a timed out call may well succeed later.
+func (e *TimeoutError) Temporary() bool { return true }
+
+func (r *rectifier) timeout() time.Duration {
+	if r.Timeout <= 0 {
+		return DefaultRectifyTimeout
+	}
+	return r.Timeout
+}
+
+// withTimeout runs f, but gives up waiting for it after the configured
+// timeout. A call that is given up on keeps running in the background, but
+// its result is discarded, so a stuck client can't stop the rectifier from
+// finishing.
+func (r *rectifier) withTimeout(op string, f func() error) error {
+	timeout := r.timeout()
+	defer observeSince(r.metrics(), r.clock(), MetricRectifyCallDuration, metrics.Labels{"op": op}, r.clock().Now())
+	done := make(chan error, 1)
+	go func() { done <- f() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-r.clock().After(timeout):
+		r.logger().Warnf("%s timed out after %s", op, timeout)
+		return &TimeoutError{Op: op, After: timeout}
+	}
+}
@@ -0,0 +1,60 @@
+package sous
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stuckDeployClient never returns from Deploy.
+type stuckDeployClient struct {
+	*DummyRectificationClient
+	block chan struct{}
+}
+
+func (c stuckDeployClient) Deploy(cluster ClusterName, depID, reqID, dockerImage string, r Resources, e Env, vols Volumes) error {
+	<-c.block
+	return nil
+}
+
+func TestRectifyCompletesWhenClientHangs(t *testing.T) {
+	assert := assert.New(t)
+
+	client := stuckDeployClient{
+		DummyRectificationClient: NewDummyRectificationClient(NewDummyNameCache()),
+		block:                    make(chan struct{}),
+	}
+	defer close(client.block)
+
+	chanset := NewDiffChans(1)
+	errs := RectifyWith(chanset, client, RectifyOpts{Timeout: 10 * time.Millisecond})
+	chanset.Created <- &Deployment{
+		SourceVersion: SourceVersion{RepoURL: RepoURL("reqid")},
+		DeployConfig:  DeployConfig{NumInstances: 1},
+		Cluster:       "cluster",
+	}
+	chanset.Close()
+
+	done := make(chan []RectificationError)
+	go func() {
+		collected := []RectificationError{}
+		for e := range errs {
+			collected = append(collected, e)
+		}
+		done <- collected
+	}()
+
+	select {
+	case <-time.After(time.Second):
+		t.Fatal("Rectify didn't complete while Deploy was stuck")
+	case collected := <-done:
+		if assert.Len(collected, 1) {
+			e := collected[0]
+			assert.IsType(&CreateError{}, e)
+			assert.IsType(&TimeoutError{}, e.(*CreateError).Err)
+			assert.Equal(TransientError, e.Kind())
+			assert.True(e.Retryable())
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package sous
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+type (
+	// RequestIDer names the Singularity request that a Deployment is
+	// rectified as. The rectifier passes the IDs it returns through
+	// FitRequestID, and refuses to rectify deployments whose IDs still fail
+	// ValidateRequestID.
+	RequestIDer interface {
+		RequestID(d *Deployment) string
+	}
+
+	// RequestIDFunc is a RequestIDer that calls itself.
+	RequestIDFunc func(d *Deployment) string
+
+	// InvalidRequestID is returned by ValidateRequestID for IDs Singularity
+	// would reject.
+	InvalidRequestID struct {
+		ID, Reason string
+	}
+)
+
+// MaxRequestIDLength is the longest request ID Singularity accepts by
+// default.
+const MaxRequestIDLength = 100
+
+// requestIDHashLength is how many hex digits of a long ID's hash are kept
+// when FitRequestID shortens it.
+const requestIDHashLength = 8
+
+// MaxDeployIDLength is the longest deploy ID Singularity accepts by default.
+const MaxDeployIDLength = 50
+
+// DefaultRequestIDer is the naming scheme used by ComputeRequestID, and by the
+// rectifier unless RectifyOpts.RequestIDer is set: a deployment's RequestID,
+// if it has one, or else its canonical source location, passed through
+// SanitizeRequestID. For example, github.com/opentable/sous with the offset
+// util becomes "github.comopentablesousutil".
+var DefaultRequestIDer RequestIDer = RequestIDFunc(func(d *Deployment) string {
+	if len(d.RequestID) > 0 {
+		return d.RequestID
+	}
+	return SanitizeRequestID(d.SourceVersion.CanonicalName().String())
+})
+
+var validRequestIDRE = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// RequestID implements RequestIDer.
+func (f RequestIDFunc) RequestID(d *Deployment) string {
+	return f(d)
+}
+
+// ComputeRequestID returns the ID of the Singularity request the rectifier
+// uses for d by default: the ID DefaultRequestIDer gives it, shortened by
+// FitRequestID.
+func ComputeRequestID(d *Deployment) string {
+	return RequestIDWith(nil, d)
+}
+
+// RequestIDWith returns the ID ider gives d, shortened by FitRequestID. A
+// nil ider means DefaultRequestIDer.
+func RequestIDWith(ider RequestIDer, d *Deployment) string {
+	if ider == nil {
+		ider = DefaultRequestIDer
+	}
+	return FitRequestID(ider.RequestID(d))
+}
+
+// FitRequestID returns id if it is at most MaxRequestIDLength long. Longer
+// IDs are truncated, and end with "_" and part of the hash of the whole ID
+// instead, so that IDs differing only after the cut stay different.
+func FitRequestID(id string) string {
+	return fitID(id, MaxRequestIDLength)
+}
+
+func fitID(id string, max int) string {
+	if len(id) <= max {
+		return id
+	}
+	h := sha256.Sum256([]byte(id))
+	suffix := "_" + hex.EncodeToString(h[:])[:requestIDHashLength]
+	return id[:max-len(suffix)] + suffix
+}
+
+// ValidateRequestID returns an *InvalidRequestID if Singularity would reject
+// id: if it's empty, longer than MaxRequestIDLength, or has characters other
+// than letters, digits, "_", "." and "-".
+func ValidateRequestID(id string) error {
+	switch {
+	case id == "":
+		return &InvalidRequestID{ID: id, Reason: "it is empty"}
+	case len(id) > MaxRequestIDLength:
+		return &InvalidRequestID{ID: id, Reason: fmt.Sprintf("it is longer than %d characters", MaxRequestIDLength)}
+	case !validRequestIDRE.MatchString(id):
+		return &InvalidRequestID{ID: id, Reason: `it may only contain letters, digits, "_", "." and "-"`}
+	}
+	return nil
+}
+
+func (e *InvalidRequestID) Error() string {
+	return fmt.Sprintf("invalid request ID %q: %s", e.ID, e.Reason)
+}
+
+// SanitizeRequestID returns a request ID Singularity accepts, made from in.
+// Letters, digits, "_" and "." are kept, accented letters lose their accents,
+// and everything else is dropped, so that IDs made before, which only had
+// "-", "/" and ":" removed, are unchanged. The result is shortened by
+// FitRequestID. It is empty if nothing in in can be kept.
+func SanitizeRequestID(in string) string {
+	return FitRequestID(sanitizeID(in, true))
+}
+
+// SanitizeDeployID returns a deploy ID Singularity accepts, made from in,
+// like SanitizeRequestID, except that "." is dropped too, and it is
+// shortened to MaxDeployIDLength.
+func SanitizeDeployID(in string) string {
+	return fitID(sanitizeID(in, false), MaxDeployIDLength)
+}
+
+// sanitizeID keeps only the ASCII letters, digits and "_" of in, and ".",
+// if dots is set. Characters are decomposed first, so that e.g. "ü" and
+// "ﬁ" become "u" and "fi" rather than being dropped.
+func sanitizeID(in string, dots bool) string {
+	out := &bytes.Buffer{}
+	for _, r := range norm.NFKD.String(in) {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '_':
+		case r == '.' && dots:
+		default:
+			continue
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
@@ -0,0 +1,164 @@
+package sous
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultRequestIDer(t *testing.T) {
+	assert := assert.New(t)
+
+	d := &Deployment{SourceVersion: SourceVersion{
+		RepoURL:    RepoURL("github.com/opentable/sous-test"),
+		RepoOffset: RepoOffset("util/hy"),
+	}}
+	assert.Equal("github.comopentablesoustestutilhy", DefaultRequestIDer.RequestID(d))
+	assert.Equal("github.comopentablesoustestutilhy", ComputeRequestID(d))
+
+	d.RequestID = "explicit-id"
+	assert.Equal("explicit-id", ComputeRequestID(d))
+}
+
+func TestFitRequestID(t *testing.T) {
+	assert := assert.New(t)
+
+	short := strings.Repeat("a", MaxRequestIDLength)
+	assert.Equal(short, FitRequestID(short))
+
+	long := strings.Repeat("a", MaxRequestIDLength) + "one"
+	fitted := FitRequestID(long)
+	assert.Len(fitted, MaxRequestIDLength)
+	assert.Regexp(`^a+_[0-9a-f]{8}$`, fitted)
+	assert.Equal(fitted, FitRequestID(long), "fitting should be stable")
+	assert.NotEqual(fitted, FitRequestID(strings.Repeat("a", MaxRequestIDLength)+"two"))
+	assert.NoError(ValidateRequestID(fitted))
+}
+
+func TestSanitizeRequestID(t *testing.T) {
+	for in, want := range map[string]string{
+		// already sanitized IDs are unchanged
+		"github.comopentablesous":     "github.comopentablesous",
+		"github.comopentablesousutil": "github.comopentablesousutil",
+		"a_b.c":                       "a_b.c",
+		"A1":                          "A1",
+		// "-", "/" and ":" are dropped, as they always were
+		"github.com/opentable/sous-test:util/hy": "github.comopentablesoustestutilhy",
+		// characters Singularity rejects
+		"github.com/open table/sous":        "github.comopentablesous",
+		"git@github.com:opentable/sous":     "gitgithub.comopentablesous",
+		"github.com/opentable/sous\t\n":     "github.comopentablesous",
+		"github.com/opentable/sous?x=1&y#z": "github.comopentablesousx1yz",
+		"github.com/opentable/süß":          "github.comopentablesu",
+		"github.com/opentable/café":         "github.comopentablecafe",
+		"github.com/opentable/ﬁle":          "github.comopentablefile",
+		"github.com/opentable/日本":           "github.comopentable",
+		"日本":                                "",
+		"":                                  "",
+	} {
+		got := SanitizeRequestID(in)
+		assert.Equal(t, want, got, "SanitizeRequestID(%q)", in)
+		assert.Equal(t, got, SanitizeRequestID(got), "sanitizing %q again", got)
+		if got != "" {
+			assert.NoError(t, ValidateRequestID(got), in)
+		}
+	}
+}
+
+func TestSanitizeRequestIDTruncates(t *testing.T) {
+	assert := assert.New(t)
+
+	offset := "github.com/opentable/mono:" + strings.Repeat("deeply/nested/", 10)
+	seen := map[string]string{}
+	for _, leaf := range []string{"one", "two", "on/e", "über", "a", "b"} {
+		id := SanitizeRequestID(offset + leaf)
+		assert.Len(id, MaxRequestIDLength)
+		assert.NoError(ValidateRequestID(id))
+		assert.Equal(id, SanitizeRequestID(offset+leaf), "sanitizing should be stable")
+		if other, ok := seen[id]; ok && sanitizeID(other, true) != sanitizeID(leaf, true) {
+			t.Errorf("%q and %q both sanitize to %q", other, leaf, id)
+		}
+		seen[id] = leaf
+	}
+	// on/e and one only differ in what's dropped
+	assert.Len(seen, 5)
+}
+
+func TestSanitizeDeployID(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("0f6a3c2e9b1d4e5f8a7b6c5d4e3f2a1b", SanitizeDeployID("0f6a3c2e9b1d4e5f8a7b6c5d4e3f2a1b"))
+	assert.Equal("0f6a3c2e9b1d4e5f8a7b6c5d4e3f2a1b_2", SanitizeDeployID("0f6a3c2e9b1d4e5f8a7b6c5d4e3f2a1b_2"))
+	assert.Equal("6ba7b8109dad11d180b400c04fd430c8", SanitizeDeployID("6ba7b810-9dad-11d1-80b4-00c04fd430c8"))
+	assert.Equal("v12_3", SanitizeDeployID("v1.2_3"))
+
+	long := SanitizeDeployID(strings.Repeat("x", MaxDeployIDLength) + "one")
+	assert.Len(long, MaxDeployIDLength)
+	assert.Regexp(`^x+_[0-9a-f]{8}$`, long)
+	assert.NotEqual(long, SanitizeDeployID(strings.Repeat("x", MaxDeployIDLength)+"two"))
+
+	id := RandomID()
+	assert.Regexp(`^[0-9a-f]{32}$`, id)
+	assert.NotEqual(id, RandomID())
+}
+
+func TestValidateRequestID(t *testing.T) {
+	for _, id := range []string{"github.comopentablesous", "a_b-c.d", "A1"} {
+		assert.NoError(t, ValidateRequestID(id), id)
+	}
+	for _, id := range []string{"", "a/b", "a b", "a:b", "ü", strings.Repeat("a", MaxRequestIDLength+1)} {
+		assert.IsType(t, &InvalidRequestID{}, ValidateRequestID(id), id)
+	}
+}
+
+func TestRectifyWithRequestIDer(t *testing.T) {
+	assert := assert.New(t)
+
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	chanset := NewDiffChans(1)
+	opts := RectifyOpts{RequestIDer: RequestIDFunc(func(d *Deployment) string {
+		return "custom-" + strings.Repeat("x", MaxRequestIDLength)
+	})}
+	errs := RectifyWith(chanset, client, opts)
+	chanset.Created <- &Deployment{
+		SourceVersion: SourceVersion{RepoURL: RepoURL("reqid")},
+		DeployConfig:  DeployConfig{NumInstances: 1},
+		Cluster:       "cluster",
+	}
+	chanset.Close()
+	for e := range errs {
+		t.Error(e)
+	}
+
+	if posts := client.CallsTo("PostRequest"); assert.Len(posts, 1) {
+		reqID := posts[0].Args[1].(string)
+		assert.Len(reqID, MaxRequestIDLength)
+		assert.True(strings.HasPrefix(reqID, "custom-x"), reqID)
+	}
+}
+
+func TestRectifyRefusesInvalidRequestIDs(t *testing.T) {
+	assert := assert.New(t)
+
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	chanset := NewDiffChans(1)
+	opts := RectifyOpts{RequestIDer: RequestIDFunc(func(d *Deployment) string {
+		return "not/valid"
+	})}
+	errs := RectifyWith(chanset, client, opts)
+	chanset.Deleted <- &Deployment{
+		SourceVersion: SourceVersion{RepoURL: RepoURL("reqid")},
+		Cluster:       "cluster",
+	}
+	chanset.Close()
+
+	var got []RectificationError
+	for e := range errs {
+		got = append(got, e)
+	}
+	if assert.Len(got, 1) {
+		assert.IsType(&InvalidRequestID{}, got[0].(*DeleteError).Err)
+	}
+	assert.Empty(client.Calls())
+}
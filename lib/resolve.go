@@ -1,14 +1,48 @@
 package sous
 
 import (
+	"fmt"
 	"log"
 	"strings"
+
+	"golang.org/x/net/context"
 )
 
-// MissingImageNamesError reports that we couldn't get names for one or more source versions
-type MissingImageNamesError struct {
-	Causes []error
-}
+type (
+	// MissingImageNamesError reports that we couldn't get names for one or more source versions
+	MissingImageNamesError struct {
+		Causes []error
+	}
+
+	// ResolveErrors collects the errors that occurred while rectifying
+	ResolveErrors struct {
+		Causes []error
+	}
+
+	// ResolveFilter restricts a resolution to some of the deployments in a
+	// State. The zero value selects all of them.
+	ResolveFilter struct {
+		// Cluster, if not empty, is the name of the only cluster to resolve.
+		Cluster string
+		// Source, if not nil, is the only source location to resolve.
+		Source *SourceLocation
+	}
+
+	// ResolveOpts configures ResolvePlanWith. The zero value configures the
+	// same behaviour as ResolvePlan.
+	ResolveOpts struct {
+		// SkipUnreadableClusters plans around clusters whose running
+		// deployments couldn't be read, leaving them out of the plan
+		// altogether, rather than failing with CollectErrors. A cluster
+		// that can't be read mustn't look empty, or everything intended for
+		// it would be created again, and so its intended deployments are
+		// left out too.
+		SkipUnreadableClusters bool
+		// Skipped, if not nil, is called with the error of each cluster
+		// skipped.
+		Skipped func(error)
+	}
+)
 
 // Resolve drives the Sous deployment resolution process. It calls out to the
 // appropriate components to compute the intended deployment set, collect the
@@ -20,37 +54,146 @@ func Resolve(rc RectificationClient, state State) error {
 
 // ResolveFilteredDeployments is similar to Resolve, but also accepts a
 // predicate to filter those deployments. See Deploments.Filter for details.
+// The running deployments are filtered too, so that deployments excluded by
+// the predicate are left alone rather than deleted.
 func ResolveFilteredDeployments(rc RectificationClient, state State, pr DeploymentPredicate) error {
+	dcs, err := ResolvePlan(rc, state, state.BaseURLs(), pr)
+	if err != nil {
+		return err
+	}
+	return RectifyPlan(rc, dcs, func(err error) {
+		log.Printf("err = %+v\n", err)
+	})
+}
+
+// ResolvePlan computes the changes needed to make the clusters at urls match
+// the intended deployments in state. The running deployments are read from
+// rc. Both sets are filtered by pr before they are compared, so deployments
+// that pr excludes are neither created nor deleted. The returned DiffChans
+// can be drained with CollectDiff to report the plan, or passed to
+// RectifyPlan to carry it out.
+func ResolvePlan(rc RectificationClient, state State, urls []string, pr DeploymentPredicate) (DiffChans, error) {
+	return ResolvePlanWith(context.Background(), rc, state, urls, pr, ResolveOpts{})
+}
+
+// ResolvePlanWith is like ResolvePlan, but reads the clusters at urls with
+// CollectExistingDeployments, which ctx can cancel, and deals with clusters
+// that can't be read as opts say.
+func ResolvePlanWith(ctx context.Context, rc RectificationClient, state State, urls []string, pr DeploymentPredicate, opts ResolveOpts) (DiffChans, error) {
 	Log.Debug.Print("Loading GDM")
 	gdm, err := state.Deployments()
-	gdm = gdm.Filter(pr)
 	if err != nil {
-		return err
+		return DiffChans{}, err
 	}
+	gdm = gdm.Filter(pr)
 
 	Log.Debug.Print("Loaded. Collecting ADC...")
 
-	sc := NewSetCollector(rc)
-	ads, err := sc.GetRunningDeployment(state.BaseURLs())
-	if err != nil {
-		return err
+	ads, errs := CollectExistingDeployments(ctx, rc, urls)
+	if err := ctx.Err(); err != nil {
+		return DiffChans{}, err
+	}
+	if len(errs) != 0 {
+		if !opts.SkipUnreadableClusters {
+			return DiffChans{}, CollectErrors(errs)
+		}
+		unread := map[ClusterName]bool{}
+		for _, err := range errs {
+			unread[err.(*CollectError).Cluster] = true
+			if opts.Skipped != nil {
+				opts.Skipped(err)
+			}
+		}
+		gdm = gdm.Filter(func(d *Deployment) bool { return !unread[d.Cluster] })
 	}
+	// previews aren't in the state, and must be left running
+	ads = ads.Filter(pr).Filter(func(d *Deployment) bool { return !IsPreview(d) })
+	// nor are requests sous didn't deploy, which no intended deployment can
+	// match, and which mustn't be deleted for that
+	ads = ads.Filter(func(d *Deployment) bool {
+		if d.ForeignImage == "" {
+			return true
+		}
+		Log.Info.Printf("Skipping request %s on %s: its image %s wasn't built by sous",
+			d.RequestID, d.Cluster, d.ForeignImage)
+		return false
+	})
 
 	Log.Debug.Print("Collected. Checking readiness to deploy...")
 
 	err = guardImageNamesKnown(rc, gdm)
 	if err != nil {
-		return err
+		return DiffChans{}, err
 	}
 
 	Log.Debug.Print("Looks good. Proceeding...")
 
-	differ := ads.Diff(gdm)
+	return ads.DiffClusters(state.Defs.Clusters, gdm)
+}
 
-	errs := Rectify(differ, rc)
+// RunningDeployments collects the deployments rc reports running on each of
+// the clusters at urls, as CollectExistingDeployments does, but fails with
+// CollectErrors if any of them can't be read.
+func RunningDeployments(rc RectificationClient, urls []string) (Deployments, error) {
+	ads, errs := CollectExistingDeployments(context.Background(), rc, urls)
+	if len(errs) != 0 {
+		return nil, CollectErrors(errs)
+	}
+	return ads, nil
+}
+
+// RectifyPlan validates and rectifies the changes in dcs, passing each error
+// to report as soon as it occurs. If there were any errors, it returns a
+// *ResolveErrors collecting them.
+func RectifyPlan(rc RectificationClient, dcs DiffChans, report func(error)) error {
+	return RectifyPlanWith(rc, dcs, RectifyOpts{}, report)
+}
 
+// RectifyPlanWith is like RectifyPlan, but rectifies with opts, as
+// RectifyWith does.
+//
+// If opts sets a BlastRadius, the whole plan is read before anything is
+// changed, and if it exceeds the BlastRadius, the *BlastRadiusExceeded is
+// reported and returned, unless opts.IgnoreBlastRadius is set. Likewise,
+// if opts sets Quotas, a *QuotaExceeded is reported for each quota the
+// plan would exceed, and a *ResolveErrors collecting them returned, unless
+// opts.IgnoreQuotas is set.
+func RectifyPlanWith(rc RectificationClient, dcs DiffChans, opts RectifyOpts, report func(error)) error {
+	if !opts.BlastRadius.IsZero() || len(opts.Quotas) != 0 {
+		plan := CollectDiff(dcs)
+		log := (&rectifier{RectifyOpts: opts}).logger()
+		if err := opts.BlastRadius.Check(plan.Counts); err != nil {
+			if !opts.IgnoreBlastRadius {
+				report(err)
+				return err
+			}
+			log.Warnf("Rectifying anyway: %s", err)
+		}
+		if exceeded := opts.Quotas.Check(plan.Intended()); len(exceeded) != 0 {
+			if !opts.IgnoreQuotas {
+				causes := make([]error, len(exceeded))
+				for i, err := range exceeded {
+					report(err)
+					causes[i] = err
+				}
+				return &ResolveErrors{causes}
+			}
+			for _, err := range exceeded {
+				log.Warnf("Rectifying anyway: %s", err)
+			}
+		}
+		dcs = plan.Chans()
+	}
+	valid, invalid := ValidateAll(dcs)
+	errs := mergeRectificationErrors(invalid, RectifyWith(valid, rc, opts))
+
+	var causes []error
 	for err := range errs {
-		log.Printf("err = %+v\n", err)
+		report(err)
+		causes = append(causes, err)
+	}
+	if len(causes) > 0 {
+		return &ResolveErrors{causes}
 	}
 	return nil
 }
@@ -64,6 +207,44 @@ func (e *MissingImageNamesError) Error() string {
 	return strings.Join(causeStrs, "  \n")
 }
 
+func (e *ResolveErrors) Error() string {
+	if len(e.Causes) == 1 {
+		return "1 error during rectification"
+	}
+	return fmt.Sprintf("%d errors during rectification", len(e.Causes))
+}
+
+// BaseURLs returns the URLs of the clusters in st selected by f. It is an
+// error if f names a cluster that st doesn't define.
+func (f ResolveFilter) BaseURLs(st *State) ([]string, error) {
+	if f.Cluster == "" {
+		return st.BaseURLs(), nil
+	}
+	cl, ok := st.Defs.Clusters[f.Cluster]
+	if !ok {
+		return nil, fmt.Errorf("no cluster named %q is defined", f.Cluster)
+	}
+	return []string{cl.BaseURL}, nil
+}
+
+// Predicate returns a DeploymentPredicate matching the deployments selected
+// by f. It returns nil, which matches everything, for the zero ResolveFilter.
+func (f ResolveFilter) Predicate(st *State) DeploymentPredicate {
+	if f.Cluster == "" && f.Source == nil {
+		return nil
+	}
+	url := st.Defs.Clusters[f.Cluster].BaseURL
+	return func(d *Deployment) bool {
+		if f.Cluster != "" && d.Cluster != ClusterName(url) {
+			return false
+		}
+		if f.Source != nil && d.SourceVersion.CanonicalName() != *f.Source {
+			return false
+		}
+		return true
+	}
+}
+
 func guardImageNamesKnown(rc RectificationClient, gdm Deployments) error {
 	es := make([]error, 0, len(gdm))
 	for _, d := range gdm {
@@ -0,0 +1,154 @@
+package sous
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/samsalisbury/semv"
+	"github.com/stretchr/testify/assert"
+)
+
+func resolveTestState() State {
+	spec := PartialDeploySpec{
+		DeployConfig: DeployConfig{
+			NumInstances: 1,
+			Resources:    Resources{"cpus": "0.1", "memory": "32"},
+		},
+		Version: semv.MustParse("1.0.0"),
+	}
+	manifest := func(repo string) *Manifest {
+		return &Manifest{
+			Source:      SourceLocation{RepoURL: RepoURL(repo)},
+			Kind:        ManifestKindService,
+			Deployments: DeploySpecs{"one": spec, "two": spec},
+		}
+	}
+	return State{
+		Defs: Defs{Clusters: Clusters{
+			"one": {Name: "one", BaseURL: "http://one"},
+			"two": {Name: "two", BaseURL: "http://two"},
+		}},
+		Manifests: Manifests{
+			"a": manifest("github.com/opentable/a"),
+			"b": manifest("github.com/opentable/b"),
+		},
+	}
+}
+
+func resolveTestClient() *DummyRectificationClient {
+	rc := NewDummyRectificationClient(NewDummyNameCache())
+	stale := &Deployment{SourceVersion: SourceVersion{
+		RepoURL: "github.com/opentable/stale",
+		Version: semv.MustParse("1.0.0"),
+	}}
+	rc.SetImageName(stale, "docker.example.com/stale:1")
+	if _, err := rc.ImageName(stale); err != nil {
+		panic(err)
+	}
+	for _, cluster := range []ClusterName{"http://one", "http://two"} {
+		rc.PostRequest(cluster, "stale", 1)
+		rc.Deploy(cluster, "dep1", "stale", "docker.example.com/stale:1", Resources{}, Env{}, Volumes{})
+	}
+	return rc
+}
+
+func TestResolvePlan(t *testing.T) {
+	assert := assert.New(t)
+	state := resolveTestState()
+
+	plan, err := ResolvePlan(resolveTestClient(), state, state.BaseURLs(), nil)
+	if !assert.NoError(err) {
+		return
+	}
+	r := CollectDiff(plan)
+	assert.Equal(4, r.Counts.Created)
+	assert.Equal(2, r.Counts.Deleted)
+}
+
+func TestResolvePlan_LeavesPreviewsAlone(t *testing.T) {
+	state := resolveTestState()
+	rc := resolveTestClient()
+	rc.PostRequest("http://one", PreviewRequestIDPrefix+"a", 1)
+	rc.Deploy("http://one", "dep1", PreviewRequestIDPrefix+"a", "docker.example.com/a:2", Resources{}, Env{}, Volumes{})
+
+	plan, err := ResolvePlan(rc, state, state.BaseURLs(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := CollectDiff(plan)
+	assert.Equal(t, 2, r.Counts.Deleted, "only the stale requests should be deleted")
+	for _, d := range r.Deleted {
+		assert.False(t, IsPreview(d), "preview %s planned for deletion", d.RequestID)
+	}
+}
+
+func TestResolvePlan_LeavesForeignRequestsAlone(t *testing.T) {
+	assert := assert.New(t)
+	state := resolveTestState()
+	rc := resolveTestClient()
+	// never named by the client's ImageMapper, so not built by sous
+	rc.PostRequest("http://one", "foreign", 1)
+	rc.Deploy("http://one", "dep1", "foreign", "docker.example.com/foreign:1", Resources{}, Env{}, Volumes{})
+
+	plan, err := ResolvePlan(rc, state, state.BaseURLs(), nil)
+	if !assert.NoError(err) {
+		return
+	}
+	if !assert.NoError(RectifyPlan(rc, plan, func(err error) { t.Error(err) })) {
+		return
+	}
+	assert.False(rc.Deleted("foreign"), "foreign request was deleted")
+	assert.True(rc.Deleted("stale"))
+}
+
+func TestResolvePlan_FilteredBeforeDiffing(t *testing.T) {
+	assert := assert.New(t)
+	state := resolveTestState()
+	filter := ResolveFilter{
+		Cluster: "one",
+		Source:  &SourceLocation{RepoURL: "github.com/opentable/a"},
+	}
+
+	urls, err := filter.BaseURLs(&state)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal([]string{"http://one"}, urls)
+
+	plan, err := ResolvePlan(resolveTestClient(), state, urls, filter.Predicate(&state))
+	if !assert.NoError(err) {
+		return
+	}
+	r := CollectDiff(plan)
+	assert.Equal(0, r.Counts.Deleted)
+	if assert.Len(r.Created, 1) {
+		assert.Equal(ClusterName("http://one"), r.Created[0].Cluster)
+		assert.Equal(RepoURL("github.com/opentable/a"), r.Created[0].SourceVersion.RepoURL)
+	}
+}
+
+func TestResolveFilter_UnknownCluster(t *testing.T) {
+	state := resolveTestState()
+	if _, err := (ResolveFilter{Cluster: "three"}).BaseURLs(&state); err == nil {
+		t.Error("got nil error for an undefined cluster")
+	}
+}
+
+func TestRectifyPlan_ReportsErrors(t *testing.T) {
+	assert := assert.New(t)
+	state := resolveTestState()
+	rc := resolveTestClient()
+	rc.FailWith("PostRequest", fmt.Errorf("cluster unavailable"))
+
+	plan, err := ResolvePlan(rc, state, state.BaseURLs(), nil)
+	if !assert.NoError(err) {
+		return
+	}
+	reported := 0
+	err = RectifyPlan(rc, plan, func(error) { reported++ })
+	if assert.IsType(&ResolveErrors{}, err) {
+		assert.Len(err.(*ResolveErrors).Causes, 4)
+	}
+	assert.Equal(4, reported)
+	assert.Len(rc.deleted, 2)
+}
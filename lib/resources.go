@@ -0,0 +1,126 @@
+package sous
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type (
+	// ResourceDiff is a difference between the value of a resource in two
+	// Resources, each given as it was compared: numbers as parsed, and
+	// missing values as "(none)".
+	ResourceDiff struct {
+		Name, Prior, Post string
+	}
+
+	// resourceValue is a resource's value as it's compared: a number, if
+	// the resource has a rule and the value parses, or else the string given.
+	resourceValue struct {
+		raw     string
+		num     float64
+		numeric bool
+		present bool
+	}
+)
+
+// resourceTolerance is how far apart two numeric resource values can be and
+// still be equal.
+const resourceTolerance = 0.001
+
+func (r ResourceDiff) String() string {
+	return fmt.Sprintf("%s: %s -> %s", r.Name, r.Prior, r.Post)
+}
+
+// resourceRule returns the rule for the named resource, if there is one.
+func resourceRule(name string) (ResourceRule, bool) {
+	for _, rule := range ResourceRules {
+		if rule.Name == name {
+			return rule, true
+		}
+	}
+	return ResourceRule{}, false
+}
+
+// value returns the named resource's value for comparison. A missing
+// resource with a rule has the rule's default value.
+func (r Resources) value(name string) resourceValue {
+	raw, present := r[name]
+	v := resourceValue{raw: raw, present: present}
+	rule, known := resourceRule(name)
+	if !known {
+		return v
+	}
+	if !present {
+		raw = rule.Default
+	}
+	if n, err := strconv.ParseFloat(strings.TrimSpace(raw), 64); err == nil {
+		v.num, v.numeric = n, true
+	}
+	return v
+}
+
+func (v resourceValue) equal(o resourceValue) bool {
+	if v.numeric && o.numeric {
+		return math.Abs(v.num-o.num) <= resourceTolerance
+	}
+	return v.present == o.present && v.raw == o.raw
+}
+
+func (v resourceValue) String() string {
+	switch {
+	case !v.present:
+		return "(none)"
+	case v.numeric:
+		return strconv.FormatFloat(v.num, 'f', -1, 64)
+	default:
+		return v.raw
+	}
+}
+
+// Diff compares r with o resource by resource, and returns the differences,
+// sorted by name. Resources with rules are compared as numbers, so "1024"
+// and "1024.0" are the same, and a missing one has its default value; any
+// other resources, or values that don't parse, must match exactly.
+func (r Resources) Diff(o Resources) []ResourceDiff {
+	diffs := []ResourceDiff{}
+	for _, name := range unionKeys(r, o) {
+		was, is := r.value(name), o.value(name)
+		if !was.equal(is) {
+			diffs = append(diffs, ResourceDiff{Name: name, Prior: was.String(), Post: is.String()})
+		}
+	}
+	return diffs
+}
+
+// Equal checks equivalence between resource maps, as Diff compares them.
+func (r Resources) Equal(o Resources) bool {
+	return len(r.Diff(o)) == 0
+}
+
+// Validate checks r against rules: that every required resource is present,
+// and that every resource with a rule is a number, and a whole one if the
+// rule says so.
+func (r Resources) Validate(rules []ResourceRule) []error {
+	errs := []error{}
+	for _, rule := range rules {
+		v, ok := r[rule.Name]
+		if !ok {
+			if rule.Required {
+				errs = append(errs, fmt.Errorf("resource %q is missing", rule.Name))
+			}
+			continue
+		}
+		var err error
+		if rule.Integer {
+			_, err = strconv.ParseInt(strings.TrimSpace(v), 10, 32)
+		} else {
+			_, err = strconv.ParseFloat(strings.TrimSpace(v), 64)
+		}
+		if err != nil {
+			errs = append(errs, fieldError{"Resources." + rule.Name, fmt.Errorf("resource %q is not numeric: %q", rule.Name, v)})
+		}
+	}
+	return errs
+}
@@ -0,0 +1,49 @@
+package sous
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourcesEqualParsesNumbers(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(Resources{"cpus": "0.5", "memory": "1024"}.Equal(Resources{"cpus": ".5", "memory": "1024.0"}))
+	assert.True(Resources{"cpus": "0.1"}.Equal(Resources{"cpus": "0.1", "ports": "1"}),
+		"a missing resource should equal its default")
+	assert.False(Resources{"cpus": "0.1", "memory": "100"}.Equal(Resources{"cpus": "0.1", "memory": "200"}))
+	assert.True(Resources{"disk": "ssd"}.Equal(Resources{"disk": "ssd"}))
+	assert.False(Resources{"disk": "10"}.Equal(Resources{"disk": "10.0"}),
+		"resources without rules should be compared as strings")
+	assert.False(Resources{"disk": "ssd"}.Equal(Resources{}))
+}
+
+func TestResourcesDiff(t *testing.T) {
+	prior := Resources{"cpus": "0.50", "memory": "1024", "disk": "ssd"}
+	post := Resources{"cpus": "1", "memory": "1024.0", "ports": "3"}
+
+	assert.Equal(t, []ResourceDiff{
+		{Name: "cpus", Prior: "0.5", Post: "1"},
+		{Name: "disk", Prior: "ssd", Post: "(none)"},
+		{Name: "ports", Prior: "(none)", Post: "3"},
+	}, prior.Diff(post))
+}
+
+func TestResourcesValidate(t *testing.T) {
+	assert := assert.New(t)
+	rules := []ResourceRule{
+		{Name: "cpus", Required: true},
+		{Name: "memory", Required: true},
+		{Name: "ports", Integer: true},
+	}
+
+	assert.Empty(Resources{"cpus": "0.1", "memory": "100", "other": "x"}.Validate(rules))
+
+	errs := Resources{"cpus": "lots", "ports": "1.5"}.Validate(rules)
+	if assert.Len(errs, 3) {
+		assert.EqualError(errs[0], `resource "cpus" is not numeric: "lots"`)
+		assert.EqualError(errs[1], `resource "memory" is missing`)
+		assert.EqualError(errs[2], `resource "ports" is not numeric: "1.5"`)
+	}
+}
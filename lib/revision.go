@@ -0,0 +1,72 @@
+package sous
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// revisionChange is a redeploy of the same version built from a different
+// revision, as when a tag is rebuilt.
+type revisionChange struct {
+	prior, post string
+}
+
+func (c *revisionChange) String() string {
+	return fmt.Sprintf("revision %s => %s", c.prior, c.post)
+}
+
+// revisionChange returns the change of revision pair makes, if
+// r.CompareRevisions is set and pair.post deploys an image of the same
+// version as pair.prior's, but built from another revision. It is nil
+// otherwise, including when either revision is unknown, as it is for images
+// sous didn't build, or that were cached before the name cache kept
+// revisions.
+func (r rectifier) revisionChange(pair *DeploymentPair) *revisionChange {
+	if !r.CompareRevisions || !pair.prior.SourceVersion.Equal(pair.post.SourceVersion) {
+		return nil
+	}
+	prior := pair.prior.SourceVersion.RevID()
+	if prior == "" {
+		return nil
+	}
+	post, err := r.revision(pair.post)
+	if err != nil {
+		r.logFor(pair.post, r.requestID(pair.post)).Debugf("Unable to find the revision to deploy: %s", err)
+		return nil
+	}
+	if post == "" || post == prior {
+		return nil
+	}
+	return &revisionChange{prior: prior, post: post}
+}
+
+// revision returns the revision the image d would be deployed with was
+// built from, according to its labels.
+func (r *rectifier) revision(d *Deployment) (string, error) {
+	name, err := r.imageName(d)
+	if err != nil {
+		return "", err
+	}
+	var labels map[string]string
+	err = r.withTimeout("ImageLabels", func() error {
+		ls, err := r.sing.ImageLabels(name)
+		labels = ls
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return labels[DockerRevisionLabel], nil
+}
+
+// addRevisionColumn adds the revision column to a docker_search_metadata
+// table created before it existed. The images already cached have no
+// revision until they're refreshed.
+func addRevisionColumn(db *sql.DB) error {
+	has, err := hasColumn(db, "docker_search_metadata", "revision")
+	if err != nil || has {
+		return err
+	}
+	return sqlExec(db, "alter table docker_search_metadata "+
+		"add column revision text not null default '';")
+}
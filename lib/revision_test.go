@@ -0,0 +1,120 @@
+package sous
+
+import (
+	"testing"
+
+	"github.com/samsalisbury/semv"
+	"github.com/stretchr/testify/assert"
+)
+
+// rebuiltClient is a DummyRectificationClient whose images all carry the
+// revision label revision, as if each tag had been rebuilt from it.
+type rebuiltClient struct {
+	*DummyRectificationClient
+	revision string
+}
+
+func (c rebuiltClient) ImageLabels(in string) (map[string]string, error) {
+	labels, err := c.DummyRectificationClient.ImageLabels(in)
+	if err != nil {
+		return nil, err
+	}
+	labels[DockerRevisionLabel] = c.revision
+	return labels, nil
+}
+
+// rectifyRebuilt rectifies a deployment of 1.0.0 running from revision
+// prior into one whose image has been rebuilt from revision post, and
+// returns the client and the events emitted.
+func rectifyRebuilt(t *testing.T, prior, post string, compare bool) (*DummyRectificationClient, []RectifyEvent) {
+	d := func(revision string) *Deployment {
+		v := semv.MustParse("1.0.0")
+		v.Meta = revision
+		return &Deployment{
+			SourceVersion: SourceVersion{RepoURL: "github.com/opentable/one", Version: v},
+			DeployConfig:  DeployConfig{NumInstances: 1},
+			Cluster:       "cluster",
+		}
+	}
+	dummy := NewDummyRectificationClient(NewDummyNameCache())
+	chanset := NewDiffChans(1)
+	events := make(chan RectifyEvent, 10)
+	errs := RectifyWith(chanset, rebuiltClient{dummy, post}, RectifyOpts{Events: events, CompareRevisions: compare})
+	chanset.Modified <- &DeploymentPair{prior: d(prior), post: d("")}
+	chanset.Close()
+	for err := range errs {
+		t.Error(err)
+	}
+	close(events)
+	evs := []RectifyEvent{}
+	for ev := range events {
+		evs = append(evs, ev)
+	}
+	return dummy, evs
+}
+
+func TestCompareRevisionsRedeploysRebuiltImages(t *testing.T) {
+	assert := assert.New(t)
+
+	client, evs := rectifyRebuilt(t, "abc123", "def456", true)
+	assert.Len(client.CallsTo("Deploy"), 1)
+	if assert.Len(evs, 2) {
+		assert.Equal(Deployed, evs[1].Kind)
+		assert.Contains(evs[1].Message, "(revision abc123 => def456)")
+	}
+}
+
+func TestCompareRevisionsIsOffByDefault(t *testing.T) {
+	client, _ := rectifyRebuilt(t, "abc123", "def456", false)
+	assert.Empty(t, client.CallsTo("Deploy"))
+	assert.Empty(t, client.CallsTo("ImageLabels"))
+}
+
+func TestCompareRevisionsIgnoresUnknownRevisions(t *testing.T) {
+	assert := assert.New(t)
+	for _, c := range []struct{ prior, post string }{
+		{"", "def456"},
+		{"abc123", ""},
+		{"abc123", "abc123"},
+	} {
+		client, _ := rectifyRebuilt(t, c.prior, c.post, true)
+		assert.Empty(client.CallsTo("Deploy"), "%q => %q", c.prior, c.post)
+	}
+}
+
+func TestRectifierDepDiffsRevision(t *testing.T) {
+	dummy := NewDummyRectificationClient(NewDummyNameCache())
+	r := rectifier{sing: rebuiltClient{dummy, "def456"}, RectifyOpts: RectifyOpts{CompareRevisions: true}}
+	prior := semv.MustParse("1.0.0")
+	prior.Meta = "abc123"
+	pair := &DeploymentPair{
+		prior: &Deployment{SourceVersion: SourceVersion{RepoURL: "one", Version: prior}, Cluster: "cluster"},
+		post:  &Deployment{SourceVersion: SourceVersion{RepoURL: "one", Version: semv.MustParse("1.0.0")}, Cluster: "cluster"},
+	}
+	assert.Equal(t, []string{"revision abc123 => def456"}, r.depDiffs(pair))
+}
+
+func TestNameCacheKeepsRevisions(t *testing.T) {
+	assert := assert.New(t)
+	nc, _, _ := entriesTestCache(t, "revisions")
+
+	v := semv.MustParse("3.0.0")
+	v.Meta = "abc123"
+	sv := SourceVersion{RepoURL: "github.com/opentable/three", Version: v}
+	if err := nc.Insert(sv, "docker.example.com/three:3.0.0", "etag"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := nc.GetSourceVersionCached("docker.example.com/three:3.0.0")
+	if assert.NoError(err) {
+		assert.Equal("abc123", got.RevID())
+	}
+	labels, err := nc.GetLabels("docker.example.com/three:3.0.0")
+	if assert.NoError(err) {
+		assert.Equal("abc123", labels[DockerRevisionLabel])
+	}
+	es, err := nc.Entries("github.com/opentable/three")
+	if assert.NoError(err) && assert.Len(es, 1) {
+		assert.Equal("abc123", es[0].SourceVersion.RevID())
+	}
+}
@@ -0,0 +1,181 @@
+package sous
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// Rollout describes how a new image is rolled out across the instances of
+	// a deployment. It's a strategy for reaching the intended state rather
+	// than part of the state, so it doesn't participate in equality checks.
+	Rollout struct {
+		// Increment is how many instances move to the new image at each step:
+		// either an instance count, e.g. "2", or a percentage of NumInstances,
+		// e.g. "25%".
+		Increment string
+		// PauseBetween is how long to wait after each step before checking
+		// its progress and starting the next.
+		PauseBetween time.Duration `yaml:",omitempty"`
+	}
+
+	// IncrementalDeployer is implemented by RectificationClients that can
+	// roll a deploy out in steps. The rectifier uses it for deployments that
+	// have a Rollout.
+	IncrementalDeployer interface {
+		// DeployIncrementally is like Deploy, but only brings up
+		// instancesPerStep instances of the new deploy, and waits for
+		// AdvanceDeploy before bringing up more.
+		DeployIncrementally(cluster ClusterName, depID, reqID, dockerImage string, r Resources, e Env, vols Volumes, instancesPerStep int) error
+		// AdvanceDeploy sets the number of instances a pending incremental
+		// deploy should have running.
+		AdvanceDeploy(cluster ClusterName, reqID, depID string, targetInstances int) error
+		// DeployStatus reports how far a deploy has progressed.
+		DeployStatus(cluster ClusterName, reqID, depID string) (DeployStatus, error)
+	}
+
+	// DeployStatus describes the progress of a deploy.
+	DeployStatus struct {
+		// Pending is true until the deploy has finished, successfully or not.
+		Pending bool
+		// Failed is true if the deploy failed or was cancelled.
+		Failed bool
+		// StepComplete is true when the current step of an incremental deploy
+		// has reached its target.
+		StepComplete bool
+		// TargetInstances is the target of the current step.
+		TargetInstances int
+		// Message explains the status, e.g. why the deploy failed.
+		Message string
+	}
+
+	// RolloutError is the cause of a ChangeError when a rollout fails
+	// partway. Reached of Of instances had been rolled out when it stopped.
+	RolloutError struct {
+		DeployID string
+		Reached  int
+		Of       int
+		Err      error
+	}
+)
+
+// rolloutPollInterval is how often an incomplete rollout step is rechecked.
+const rolloutPollInterval = time.Second
+
+func (e *RolloutError) Error() string {
+	return fmt.Sprintf("rollout of %s stopped at %d of %d instances: %v", e.DeployID, e.Reached, e.Of, e.Err)
+}
+
+// InstancesPerStep resolves Increment to a number of instances, given the
+// total number being rolled out. Every step moves at least one instance.
+func (r *Rollout) InstancesPerStep(total int) (int, error) {
+	inc := strings.TrimSpace(r.Increment)
+	var n int
+	if strings.HasSuffix(inc, "%") {
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(inc, "%"), 64)
+		if err != nil || pct <= 0 || pct > 100 {
+			return 0, fmt.Errorf("invalid rollout increment %q: percentage must be in (0, 100]", r.Increment)
+		}
+		n = int(float64(total) * pct / 100)
+	} else {
+		var err error
+		n, err = strconv.Atoi(inc)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid rollout increment %q: must be a positive count or a percentage", r.Increment)
+		}
+	}
+	if n < 1 {
+		n = 1
+	}
+	if n > total {
+		n = total
+	}
+	return n, nil
+}
+
+// rollout deploys d in the steps described by d.Rollout, checking each step
+// has completed before starting the next. The client must be an
+// IncrementalDeployer.
+func (r *rectifier) rollout(d *Deployment, reqID, imageName string, started time.Time) error {
+	client := r.sing.(IncrementalDeployer)
+	total := d.NumInstances
+	step, err := d.Rollout.InstancesPerStep(total)
+	if err != nil {
+		return err
+	}
+
+	res, e, vols := d.Resources, d.Env, d.DeployConfig.Volumes
+	baseID := computeDeployID(reqID, imageName, res, e, vols)
+	depID, resumed, err := r.deployWithUniqueID(baseID, func(depID string) error {
+		return r.call(d.Cluster, "DeployIncrementally", func() error {
+			return client.DeployIncrementally(d.Cluster, depID, reqID, imageName, res, e, vols, step)
+		})
+	})
+	if resumed {
+		// picking up a rollout that was already started
+		r.logFor(d, reqID).Debugf("Deploy %s already started", depID)
+	} else {
+		r.audit(AuditDeploy, d, reqID, depID, total,
+			fmt.Sprintf("rolling %s out %d instances at a time", imageName, step), err)
+	}
+	if err != nil {
+		return err
+	}
+
+	reached := step
+	for {
+		r.emit(Deployed, d, reqID, started,
+			fmt.Sprintf("rolled %s out to %d of %d instances", imageName, reached, total))
+		if err := r.awaitStep(client, d, reqID, depID); err != nil {
+			return &RolloutError{DeployID: depID, Reached: reached, Of: total, Err: err}
+		}
+		if reached >= total {
+			return nil
+		}
+
+		next := reached + step
+		if next > total {
+			next = total
+		}
+		err := r.call(d.Cluster, "AdvanceDeploy", func() error {
+			return client.AdvanceDeploy(d.Cluster, reqID, depID, next)
+		})
+		r.audit(AuditDeploy, d, reqID, depID, total,
+			fmt.Sprintf("advanced %s to %d of %d instances", imageName, next, total), err)
+		if err != nil {
+			return &RolloutError{DeployID: depID, Reached: reached, Of: total, Err: err}
+		}
+		reached = next
+	}
+}
+
+// awaitStep waits for the current step of an incremental deploy to
+// complete, giving up after the rectifier's timeout.
+func (r *rectifier) awaitStep(client IncrementalDeployer, d *Deployment, reqID, depID string) error {
+	timeout := r.timeout()
+	deadline := r.clock().Now().Add(timeout)
+	<-r.clock().After(d.Rollout.PauseBetween)
+	for {
+		var status DeployStatus
+		err := r.call(d.Cluster, "DeployStatus", func() error {
+			var err error
+			status, err = client.DeployStatus(d.Cluster, reqID, depID)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if status.Failed {
+			return fmt.Errorf("deploy failed: %s", status.Message)
+		}
+		if status.StepComplete || !status.Pending {
+			return nil
+		}
+		if r.clock().Now().After(deadline) {
+			return &TimeoutError{Op: "rollout step", After: timeout}
+		}
+		<-r.clock().After(rolloutPollInterval)
+	}
+}
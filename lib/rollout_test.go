@@ -0,0 +1,131 @@
+package sous
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/samsalisbury/semv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRolloutInstancesPerStep(t *testing.T) {
+	assert := assert.New(t)
+
+	cases := []struct {
+		increment string
+		total     int
+		step      int
+	}{
+		{"25%", 8, 2},
+		{"25%", 3, 1},
+		{"100%", 5, 5},
+		{"2", 8, 2},
+		{"10", 4, 4},
+	}
+	for _, c := range cases {
+		n, err := (&Rollout{Increment: c.increment}).InstancesPerStep(c.total)
+		assert.NoError(err)
+		assert.Equal(c.step, n, "%s of %d", c.increment, c.total)
+	}
+
+	for _, bad := range []string{"", "0", "-1", "0%", "150%", "lots"} {
+		_, err := (&Rollout{Increment: bad}).InstancesPerStep(4)
+		assert.Error(err, bad)
+	}
+}
+
+func rolloutPair(increment string) *DeploymentPair {
+	return &DeploymentPair{
+		prior: &Deployment{
+			SourceVersion: SourceVersion{
+				RepoURL: RepoURL("reqid"),
+				Version: semv.MustParse("1.2.3"),
+			},
+			DeployConfig: DeployConfig{NumInstances: 8},
+			Cluster:      "cluster",
+		},
+		post: &Deployment{
+			SourceVersion: SourceVersion{
+				RepoURL: RepoURL("reqid"),
+				Version: semv.MustParse("2.3.4"),
+			},
+			DeployConfig: DeployConfig{
+				NumInstances: 8,
+				Rollout:      &Rollout{Increment: increment, PauseBetween: time.Millisecond},
+			},
+			Cluster: "cluster",
+		},
+	}
+}
+
+func TestModifyRollsOutIncrementally(t *testing.T) {
+	assert := assert.New(t)
+
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	chanset := NewDiffChans(1)
+	errs := Rectify(chanset, client)
+	chanset.Modified <- rolloutPair("25%")
+	chanset.Close()
+	for e := range errs {
+		t.Error(e)
+	}
+
+	assert.Len(client.deployed, 1)
+	targets := []int{}
+	for _, s := range client.steps {
+		targets = append(targets, s.target)
+	}
+	assert.Equal([]int{2, 4, 6, 8}, targets)
+}
+
+func TestRolloutFailurePartway(t *testing.T) {
+	assert := assert.New(t)
+
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	client.FailWith("AdvanceDeploy", errors.New("singularity says no"))
+	chanset := NewDiffChans(1)
+	errs := Rectify(chanset, client)
+	chanset.Modified <- rolloutPair("50%")
+	chanset.Close()
+
+	count := 0
+	for e := range errs {
+		count++
+		if assert.IsType(&ChangeError{}, e) {
+			re, ok := e.(*ChangeError).Err.(*RolloutError)
+			if assert.True(ok, "%T", e.(*ChangeError).Err) {
+				assert.Equal(4, re.Reached)
+				assert.Equal(8, re.Of)
+			}
+		}
+	}
+	assert.Equal(1, count)
+}
+
+func TestRolloutDeployIDConflictWithDifferentContent(t *testing.T) {
+	assert := assert.New(t)
+
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	depID := computeDeployID("reqid", "reqid 2.3.4", nil, nil, nil)
+	if err := client.Deploy("cluster", depID, "reqid", "something-else", nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	chanset := NewDiffChans(1)
+	errs := Rectify(chanset, client)
+	chanset.Modified <- rolloutPair("50%")
+	chanset.Close()
+	for e := range errs {
+		t.Error(e)
+	}
+
+	if deploys := client.CallsTo("DeployIncrementally"); assert.Len(deploys, 2) {
+		assert.Error(deploys[0].Err)
+		assert.Equal(depID+"_1", deploys[1].Args[1])
+		assert.NoError(deploys[1].Err)
+	}
+	if advances := client.CallsTo("AdvanceDeploy"); assert.Len(advances, 1) {
+		assert.Equal(depID+"_1", advances[0].Args[2])
+	}
+}
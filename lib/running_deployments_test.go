@@ -0,0 +1,96 @@
+package sous
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/opentable/go-singularity/dtos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDummyRunningDeploymentsRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	intended := &Deployment{
+		SourceVersion: SourceVersion{RepoURL: RepoURL("reqid")},
+		DeployConfig: DeployConfig{
+			NumInstances: 3,
+			Env:          Env{"A": "1"},
+			Resources:    Resources{"cpus": "0.1"},
+		},
+		Cluster: "cluster",
+	}
+
+	chanset := NewDiffChans(1)
+	errs := Rectify(chanset, client)
+	chanset.Created <- intended
+	chanset.Close()
+	for e := range errs {
+		t.Error(e)
+	}
+
+	running, err := client.RunningDeployments("cluster")
+	assert.NoError(err)
+	if assert.Len(running, 1) {
+		d := running[0]
+		assert.Equal("reqid", d.RequestID)
+		assert.Empty(d.ForeignImage)
+		assert.True(d.SourceVersion.Equal(intended.SourceVersion))
+		assert.Equal(3, d.NumInstances)
+		assert.True(d.Env.Equal(intended.Env))
+	}
+
+	running, err = client.RunningDeployments("other")
+	assert.NoError(err)
+	assert.Len(running, 0)
+}
+
+func TestDummyRunningDeploymentsMarksForeignImages(t *testing.T) {
+	assert := assert.New(t)
+
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	client.PostRequest("cluster", "hand-made", 1)
+	client.Deploy("cluster", "dep1", "hand-made", "docker.example.com/hand-made:1", Resources{}, Env{}, Volumes{})
+
+	running, err := client.RunningDeployments("cluster")
+	assert.NoError(err)
+	if assert.Len(running, 1) {
+		assert.Equal("docker.example.com/hand-made:1", running[0].ForeignImage)
+	}
+}
+
+func foreignTestBuilder(client RectificationClient) deploymentBuilder {
+	db := NewDeploymentBuilder(client, SingReq{ReqParent: &dtos.SingularityRequestParent{
+		Request: &dtos.SingularityRequest{Id: "req"},
+	}})
+	db.deploy = &dtos.SingularityDeploy{ContainerInfo: &dtos.SingularityContainerInfo{
+		Type:   dtos.SingularityContainerInfoSingularityContainerTypeDOCKER,
+		Docker: &dtos.SingularityDockerInfo{Image: "docker.example.com/req:1"},
+	}}
+	db.keepForeign = true
+	return db
+}
+
+func TestRetrieveImageLabels_Foreign(t *testing.T) {
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	client.FailWith("ImageLabels", NoSourceVersionFound{imageName("docker.example.com/req:1")})
+	db := foreignTestBuilder(client)
+
+	if err := db.retrieveImageLabels(); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "docker.example.com/req:1", db.Target.ForeignImage)
+}
+
+func TestRetrieveImageLabels_RegistryUnavailable(t *testing.T) {
+	// a sous image mustn't be taken for a foreign one, and so deleted,
+	// because the registry couldn't be asked about it
+	client := NewDummyRectificationClient(NewDummyNameCache())
+	unavailable := RegistryUnavailable{Repo: "docker.example.com/req", Err: fmt.Errorf("timeout")}
+	client.FailWith("ImageLabels", unavailable)
+	db := foreignTestBuilder(client)
+
+	assert.Equal(t, unavailable, db.retrieveImageLabels())
+	assert.Empty(t, db.Target.ForeignImage)
+}
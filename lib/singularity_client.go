@@ -0,0 +1,267 @@
+package sous
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/opentable/go-singularity"
+)
+
+type (
+	// SingularityClient is a RectificationClient for the Singularity clusters
+	// it's built with. It accepts either a cluster's name or its base URL
+	// wherever a cluster is called for, and translates the errors got talking
+	// to Singularity into those the rectifier can classify.
+	SingularityClient struct {
+		// Auditor, if not nil, records each call that changes a cluster.
+		// The rectifier records its own actions in more detail (see
+		// RectifyOpts), so this is for calls made without it.
+		Auditor Auditor
+		// Clock tells the times of audit entries. If it's nil, SystemClock
+		// is used.
+		Clock Clock
+		agent *RectiAgent
+		// clusters maps cluster names to base URLs.
+		clusters map[string]string
+	}
+
+	// UnknownCluster is returned by a SingularityClient asked to act on a
+	// cluster it wasn't built with.
+	UnknownCluster struct {
+		Cluster ClusterName
+		Known   []string
+	}
+
+	// SingularityUnavailable is returned by a SingularityClient when a
+	// cluster couldn't be reached, so the call may well succeed later.
+	SingularityUnavailable struct {
+		Cluster ClusterName
+		Err     error
+	}
+)
+
+func (e UnknownCluster) Error() string {
+	return fmt.Sprintf("no Singularity cluster %q is known (known clusters: %s)",
+		e.Cluster, strings.Join(e.Known, ", "))
+}
+
+func (e *SingularityUnavailable) Error() string {
+	return fmt.Sprintf("Singularity cluster %s is unavailable: %v", e.Cluster, e.Err)
+}
+
+// Temporary is always true: the cluster may be reachable later.
+func (e *SingularityUnavailable) Temporary() bool { return true }
+
+// NewSingularityClient returns a SingularityClient for the clusters named by
+// the keys of clusterURLs, whose base URLs are the values. Image names are
+// found with imageMapper, which is also asked for image labels, so that they
+// come from the registry.
+func NewSingularityClient(clusterURLs map[string]string, imageMapper ImageMapper) *SingularityClient {
+	clusters := make(map[string]string, len(clusterURLs))
+	for name, u := range clusterURLs {
+		clusters[name] = u
+	}
+	return &SingularityClient{agent: NewRectiAgent(imageMapper), clusters: clusters}
+}
+
+// Deploy implements part of RectificationClient
+func (sc *SingularityClient) Deploy(cluster ClusterName, depID, reqID, dockerImage string, r Resources, e Env, vols Volumes) error {
+	return sc.audit(AuditEntry{Action: AuditDeploy, Cluster: cluster, RequestID: reqID, DeployID: depID,
+		Message: "deployed " + dockerImage},
+		sc.call(cluster, func(u ClusterName) error {
+			return sc.agent.Deploy(u, depID, reqID, dockerImage, r, e, vols)
+		}))
+}
+
+// DeployIncrementally implements part of IncrementalDeployer
+func (sc *SingularityClient) DeployIncrementally(cluster ClusterName, depID, reqID, dockerImage string, r Resources, e Env, vols Volumes, instancesPerStep int) error {
+	return sc.audit(AuditEntry{Action: AuditDeploy, Cluster: cluster, RequestID: reqID, DeployID: depID,
+		Message: fmt.Sprintf("rolling %s out %d instances at a time", dockerImage, instancesPerStep)},
+		sc.call(cluster, func(u ClusterName) error {
+			return sc.agent.DeployIncrementally(u, depID, reqID, dockerImage, r, e, vols, instancesPerStep)
+		}))
+}
+
+// AdvanceDeploy implements part of IncrementalDeployer
+func (sc *SingularityClient) AdvanceDeploy(cluster ClusterName, reqID, depID string, targetInstances int) error {
+	return sc.audit(AuditEntry{Action: AuditDeploy, Cluster: cluster, RequestID: reqID, DeployID: depID,
+		Message: fmt.Sprintf("advanced to %d instances", targetInstances)},
+		sc.call(cluster, func(u ClusterName) error {
+			return sc.agent.AdvanceDeploy(u, reqID, depID, targetInstances)
+		}))
+}
+
+// DeployStatus implements part of IncrementalDeployer
+func (sc *SingularityClient) DeployStatus(cluster ClusterName, reqID, depID string) (DeployStatus, error) {
+	var status DeployStatus
+	err := sc.call(cluster, func(u ClusterName) error {
+		var err error
+		status, err = sc.agent.DeployStatus(u, reqID, depID)
+		return err
+	})
+	return status, err
+}
+
+// PendingDeploy implements part of RectificationClient
+func (sc *SingularityClient) PendingDeploy(cluster ClusterName, reqID string) (bool, string, error) {
+	var pending bool
+	var depID string
+	err := sc.call(cluster, func(u ClusterName) error {
+		var err error
+		pending, depID, err = sc.agent.PendingDeploy(u, reqID)
+		return err
+	})
+	return pending, depID, err
+}
+
+// CancelDeploy implements DeployCanceller
+func (sc *SingularityClient) CancelDeploy(cluster ClusterName, reqID, depID string) error {
+	return sc.audit(AuditEntry{Action: AuditCancelDeploy, Cluster: cluster, RequestID: reqID, DeployID: depID},
+		sc.call(cluster, func(u ClusterName) error {
+			return sc.agent.CancelDeploy(u, reqID, depID)
+		}))
+}
+
+// PostRequest implements part of RectificationClient
+func (sc *SingularityClient) PostRequest(cluster ClusterName, reqID string, instanceCount int) error {
+	return sc.audit(AuditEntry{Action: AuditCreate, Cluster: cluster, RequestID: reqID, PostInstances: instanceCount},
+		sc.call(cluster, func(u ClusterName) error {
+			return sc.agent.PostRequest(u, reqID, instanceCount)
+		}))
+}
+
+// PostRequestOfKind implements KindPoster
+func (sc *SingularityClient) PostRequestOfKind(cluster ClusterName, reqID string, instanceCount int, kind ManifestKind, schedule string) error {
+	return sc.audit(AuditEntry{Action: AuditCreate, Cluster: cluster, RequestID: reqID, PostInstances: instanceCount,
+		Message: fmt.Sprintf("kind %s", kind)},
+		sc.call(cluster, func(u ClusterName) error {
+			return sc.agent.PostRequestOfKind(u, reqID, instanceCount, kind, schedule)
+		}))
+}
+
+// Scale implements part of RectificationClient
+func (sc *SingularityClient) Scale(cluster ClusterName, reqID string, instanceCount int, message string) error {
+	return sc.audit(AuditEntry{Action: AuditScale, Cluster: cluster, RequestID: reqID, PostInstances: instanceCount,
+		Message: message},
+		requestNotFound(cluster, reqID, sc.call(cluster, func(u ClusterName) error {
+			return sc.agent.Scale(u, reqID, instanceCount, message)
+		})))
+}
+
+// DeleteRequest implements part of RectificationClient
+func (sc *SingularityClient) DeleteRequest(cluster ClusterName, reqID, message string) error {
+	return sc.audit(AuditEntry{Action: AuditDelete, Cluster: cluster, RequestID: reqID, Message: message},
+		requestNotFound(cluster, reqID, sc.call(cluster, func(u ClusterName) error {
+			return sc.agent.DeleteRequest(u, reqID, message)
+		})))
+}
+
+// RunningDeployments implements part of RectificationClient. As with
+// intended deployments, the Cluster of each is the cluster's base URL.
+func (sc *SingularityClient) RunningDeployments(cluster ClusterName) (Deployments, error) {
+	var deps Deployments
+	err := sc.call(cluster, func(u ClusterName) error {
+		var err error
+		deps, err = sc.agent.RunningDeployments(u)
+		return err
+	})
+	return deps, err
+}
+
+// DeployHistory implements part of RectificationClient. The Cluster of each
+// record is the cluster's base URL.
+func (sc *SingularityClient) DeployHistory(cluster ClusterName, reqID string, count int) ([]DeployRecord, error) {
+	var rs []DeployRecord
+	err := sc.call(cluster, func(u ClusterName) error {
+		var err error
+		rs, err = sc.agent.DeployHistory(u, reqID, count)
+		return err
+	})
+	return rs, err
+}
+
+// ImageName implements part of RectificationClient
+func (sc *SingularityClient) ImageName(d *Deployment) (string, error) {
+	return sc.agent.ImageName(d)
+}
+
+// ImageLabels implements part of RectificationClient
+func (sc *SingularityClient) ImageLabels(imageName string) (map[string]string, error) {
+	return sc.agent.ImageLabels(imageName)
+}
+
+// BaseURL returns the base URL of cluster, which may be named either by its
+// name or by the URL itself.
+func (sc *SingularityClient) BaseURL(cluster ClusterName) (string, error) {
+	if u, ok := sc.clusters[string(cluster)]; ok {
+		return u, nil
+	}
+	known := make([]string, 0, len(sc.clusters))
+	for name, u := range sc.clusters {
+		if ClusterName(u) == cluster {
+			return u, nil
+		}
+		known = append(known, name)
+	}
+	sort.Strings(known)
+	return "", UnknownCluster{Cluster: cluster, Known: known}
+}
+
+// call runs f with the base URL of cluster, and translates the error it
+// returns.
+func (sc *SingularityClient) call(cluster ClusterName, f func(baseURL ClusterName) error) error {
+	u, err := sc.BaseURL(cluster)
+	if err != nil {
+		return err
+	}
+	return translateSingularityError(cluster, f(ClusterName(u)))
+}
+
+// audit records e, which err failed if it isn't nil, with sc.Auditor, if
+// there is one, and returns err. The client doesn't know the instance counts
+// of requests, so they're only recorded when they're being set.
+func (sc *SingularityClient) audit(e AuditEntry, err error) error {
+	if sc.Auditor == nil {
+		return err
+	}
+	clock := sc.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+	e.Time = clock.Now()
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if rerr := sc.Auditor.Record(e); rerr != nil {
+		DefaultLogger.Warnf("Unable to record %s of %s on %s in the audit log: %s", e.Action, e.RequestID, e.Cluster, rerr)
+	}
+	return err
+}
+
+// translateSingularityError makes the errors got talking to a Singularity
+// cluster classifiable: failures to reach it are transient, and responses
+// that can't be understood are malformed. Error responses are left as
+// *singularity.ReqError, which is classified by its status.
+func translateSingularityError(cluster ClusterName, err error) error {
+	switch err := err.(type) {
+	default:
+		return err
+	case *url.Error:
+		return &SingularityUnavailable{Cluster: cluster, Err: err}
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		return malformedResponse{fmt.Sprintf("Singularity cluster %s sent a response that couldn't be read: %s", cluster, err)}
+	}
+}
+
+// requestNotFound translates the 404 Singularity responds with when asked to
+// change a request it doesn't have into a *RequestNotFound.
+func requestNotFound(cluster ClusterName, reqID string, err error) error {
+	if rerr, ok := err.(*singularity.ReqError); ok && rerr.Status == http.StatusNotFound {
+		return &RequestNotFound{Cluster: cluster, RequestID: reqID}
+	}
+	return err
+}
@@ -0,0 +1,246 @@
+package sous
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opentable/go-singularity"
+	"github.com/opentable/sous/lib/clocktest"
+	"github.com/samsalisbury/semv"
+	"github.com/stretchr/testify/assert"
+)
+
+type (
+	fakeSingularity struct {
+		*httptest.Server
+		sync.Mutex
+		requests []fakeSingRequest
+		// status, if not zero, is sent in reply to every request
+		status int
+	}
+
+	fakeSingRequest struct {
+		method, path, body string
+	}
+)
+
+func newFakeSingularity() *fakeSingularity {
+	fs := &fakeSingularity{}
+	fs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		compact := &bytes.Buffer{}
+		if len(body) > 0 {
+			json.Compact(compact, body)
+		}
+		fs.Lock()
+		fs.requests = append(fs.requests, fakeSingRequest{r.Method, r.URL.Path, compact.String()})
+		status := fs.status
+		fs.Unlock()
+		if status != 0 {
+			w.WriteHeader(status)
+		}
+	}))
+	return fs
+}
+
+func (fs *fakeSingularity) only(t *testing.T) fakeSingRequest {
+	fs.Lock()
+	defer fs.Unlock()
+	if len(fs.requests) != 1 {
+		t.Fatalf("got requests %v; want 1", fs.requests)
+	}
+	req := fs.requests[0]
+	fs.requests = nil
+	return req
+}
+
+func TestSingularityClient_PostRequest(t *testing.T) {
+	fs := newFakeSingularity()
+	defer fs.Close()
+	sc := NewSingularityClient(map[string]string{"test": fs.URL}, NewDummyNameCache())
+
+	if err := sc.PostRequest("test", "reqid", 2); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, fakeSingRequest{"POST", "/api/requests",
+		`{"id":"reqid","instances":2,"requestType":"SERVICE"}`}, fs.only(t))
+
+	// clusters can be named by their URLs, as the rectifier does
+	if err := sc.PostRequest(ClusterName(fs.URL), "reqid", 2); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "/api/requests", fs.only(t).path)
+}
+
+func TestSingularityClient_Deploy(t *testing.T) {
+	fs := newFakeSingularity()
+	defer fs.Close()
+	sc := NewSingularityClient(map[string]string{"test": fs.URL}, NewDummyNameCache())
+
+	err := sc.Deploy("test", "depid", "reqid", "docker.example.com/hello:1.2.3",
+		Resources{"cpus": "0.1", "memory": "100", "ports": "1"},
+		Env{"GREETING": "hello"},
+		Volumes{{Host: "/tmp", Container: "/scratch", Mode: "RO"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, fakeSingRequest{"POST", "/api/deploys", `{"deploy":{` +
+		`"containerInfo":{"docker":{"image":"docker.example.com/hello:1.2.3"},"type":"DOCKER",` +
+		`"volumes":[{"containerPath":"/scratch","hostPath":"/tmp","mode":"RO"}]},` +
+		`"env":{"GREETING":"hello"},"id":"depid","requestId":"reqid",` +
+		`"resources":{"cpus":0.1,"memoryMb":100,"numPorts":1}}}`}, fs.only(t))
+}
+
+func TestSingularityClient_ScaleAndDelete(t *testing.T) {
+	fs := newFakeSingularity()
+	defer fs.Close()
+	sc := NewSingularityClient(map[string]string{"test": fs.URL}, NewDummyNameCache())
+
+	if err := sc.Scale("test", "reqid", 3, " rectified scaling"); err != nil {
+		t.Fatal(err)
+	}
+	req := fs.only(t)
+	assert.Equal(t, "PUT", req.method)
+	assert.Equal(t, "/api/requests/request/reqid/scale", req.path)
+	scale := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(req.body), &scale); err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEmpty(t, scale["actionId"])
+	delete(scale, "actionId")
+	assert.Equal(t, map[string]interface{}{
+		"instances": 3.0, "message": "Sous rectified scaling", "skipHealthchecks": false,
+	}, scale)
+
+	if err := sc.DeleteRequest("test", "reqid", "removed"); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, fakeSingRequest{"DELETE", "/api/requests/request/reqid",
+		`{"message":"Sous: removed"}`}, fs.only(t))
+}
+
+func TestSingularityClient_ImageName(t *testing.T) {
+	sc := NewSingularityClient(map[string]string{}, NewDummyNameCache())
+	sv := SourceVersion{RepoURL: "github.com/opentable/hello", Version: semv.MustParse("1.2.3")}
+
+	name, err := sc.ImageName(&Deployment{SourceVersion: sv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, sv.String(), name)
+}
+
+func TestSingularityClient_Errors(t *testing.T) {
+	fs := newFakeSingularity()
+	sc := NewSingularityClient(map[string]string{"test": fs.URL}, NewDummyNameCache())
+
+	err := sc.PostRequest("nowhere", "reqid", 1)
+	assert.Equal(t, UnknownCluster{Cluster: "nowhere", Known: []string{"test"}}, err)
+
+	for status, kind := range map[int]ErrorKind{
+		http.StatusBadRequest:         ValidationError,
+		http.StatusServiceUnavailable: TransientError,
+	} {
+		fs.Lock()
+		fs.status = status
+		fs.Unlock()
+		err = sc.PostRequest("test", "reqid", 1)
+		assert.IsType(t, &singularity.ReqError{}, err)
+		assert.Equal(t, kind, classifyError(err), fmt.Sprintf("status %d", status))
+	}
+
+	fs.Close()
+	err = sc.PostRequest("test", "reqid", 1)
+	assert.IsType(t, &SingularityUnavailable{}, err)
+	assert.Equal(t, TransientError, classifyError(err))
+}
+
+func TestSingularityClient_Audits(t *testing.T) {
+	assert := assert.New(t)
+	fs := newFakeSingularity()
+	defer fs.Close()
+	sc := NewSingularityClient(map[string]string{"test": fs.URL}, NewDummyNameCache())
+	audit := NewMemoryAuditor()
+	sc.Auditor = audit
+	now := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	sc.Clock = clocktest.NewClock(now)
+
+	assert.NoError(sc.Scale("test", "reqid", 3, "scaling"))
+	fs.Lock()
+	fs.status = http.StatusInternalServerError
+	fs.Unlock()
+	assert.Error(sc.DeleteRequest("test", "reqid", "removed"))
+
+	entries := audit.Entries()
+	if !assert.Len(entries, 2) {
+		return
+	}
+	assert.Equal(AuditScale, entries[0].Action)
+	assert.Equal(ClusterName("test"), entries[0].Cluster)
+	assert.Equal("reqid", entries[0].RequestID)
+	assert.Equal(3, entries[0].PostInstances)
+	assert.Equal(now, entries[0].Time)
+	assert.Empty(entries[0].Error)
+	assert.Equal(AuditDelete, entries[1].Action)
+	assert.NotEmpty(entries[1].Error)
+}
+
+func TestSingularityClient_DeployHistory(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/history/request/reqid/deploys" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `[
+			{"deployMarker": {"deployId": "old", "requestId": "reqid", "timestamp": 1500000000000, "user": "alice"},
+			 "deployResult": {"deployState": "FAILED", "message": "health checks failed", "timestamp": 1500000060000},
+			 "deploy": {"id": "old", "containerInfo": {"type": "DOCKER", "docker": {"image": "docker.example.com/one:1.0.0"}}}},
+			{"deployMarker": {"deployId": "new", "requestId": "reqid", "timestamp": 1500000600000, "message": "incident 123"},
+			 "deploy": {"id": "new", "containerInfo": {"type": "DOCKER", "docker": {"image": "docker.example.com/foreign:latest"}}}}
+		]`)
+	}))
+	defer srv.Close()
+
+	nc := NewNameCacheWithOptions(nil, []string{"sqlite3", InMemoryConnection("deployhistory")}, NameCacheOffline())
+	sv := SourceVersion{RepoURL: "github.com/opentable/one", Version: semv.MustParse("1.0.0")}
+	if err := nc.Insert(sv, "docker.example.com/one:1.0.0", "etag"); err != nil {
+		t.Fatal(err)
+	}
+	sc := NewSingularityClient(map[string]string{"test": srv.URL}, nc)
+
+	rs, err := sc.DeployHistory("test", "reqid", 5)
+	if !assert.NoError(err) {
+		return
+	}
+	if !assert.Len(rs, 2) {
+		return
+	}
+	assert.Equal(DeployRecord{
+		Cluster: ClusterName(srv.URL), RequestID: "reqid", DeployID: "new",
+		Time: time.Unix(1500000600, 0), Message: "incident 123",
+		ImageName: "docker.example.com/foreign:latest",
+	}, rs[0], "the newest deploy should be first, and its foreign image unresolved")
+	assert.Equal(DeployRecord{
+		Cluster: ClusterName(srv.URL), RequestID: "reqid", DeployID: "old",
+		Time: time.Unix(1500000000, 0), User: "alice",
+		Result: "FAILED", ResultMessage: "health checks failed",
+		ImageName: "docker.example.com/one:1.0.0", SourceVersion: &sv,
+	}, rs[1])
+
+	rs, err = sc.DeployHistory("test", "reqid", 1)
+	if assert.NoError(err) && assert.Len(rs, 1) {
+		assert.Equal("new", rs[0].DeployID)
+	}
+
+	_, err = sc.DeployHistory("test", "missing", 5)
+	assert.IsType(&singularity.ReqError{}, err)
+}
@@ -0,0 +1,243 @@
+// Package singularitytest provides a fake Singularity API server, for
+// testing code built on go-singularity, such as sous.SingularityClient,
+// without a Singularity to talk to.
+package singularitytest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type (
+	// Fake is a Singularity that keeps the requests and deploys made on it,
+	// and answers the calls sous makes as Singularity would. Every deploy
+	// succeeds as soon as it's made, so none are ever pending. Deleted
+	// requests are forgotten.
+	Fake struct {
+		*httptest.Server
+		sync.Mutex
+		requests map[string]*Request
+		// deploys are the deploys on each request, oldest first
+		deploys map[string][]Deploy
+		// timestamp is the time, in milliseconds, of the latest deploy
+		timestamp int64
+	}
+
+	// Request is a request on a Fake.
+	Request struct {
+		ID          string   `json:"id"`
+		Instances   int      `json:"instances"`
+		RequestType string   `json:"requestType"`
+		Schedule    string   `json:"schedule,omitempty"`
+		Owners      []string `json:"owners,omitempty"`
+		// ActiveDeploy is the ID of the latest deploy of the request, or ""
+		// if it hasn't been deployed.
+		ActiveDeploy string `json:"-"`
+	}
+
+	// Deploy is a deploy made on a Fake.
+	Deploy struct {
+		ID        string             `json:"id"`
+		RequestID string             `json:"requestId"`
+		Env       map[string]string  `json:"env"`
+		Resources map[string]float64 `json:"resources"`
+		// Image is the docker image deployed.
+		Image     string `json:"-"`
+		timestamp int64
+		raw       json.RawMessage
+	}
+)
+
+// NewFake starts a Fake with no requests. It must be closed.
+func NewFake() *Fake {
+	fs := &Fake{
+		requests:  map[string]*Request{},
+		deploys:   map[string][]Deploy{},
+		timestamp: 1500000000000,
+	}
+	fs.Server = httptest.NewServer(http.HandlerFunc(fs.serve))
+	return fs
+}
+
+// Requests returns the requests on fs, ordered by ID.
+func (fs *Fake) Requests() []Request {
+	fs.Lock()
+	defer fs.Unlock()
+	rs := make([]Request, 0, len(fs.requests))
+	for _, req := range fs.requests {
+		rs = append(rs, req.copy())
+	}
+	sort.Slice(rs, func(i, j int) bool { return rs[i].ID < rs[j].ID })
+	return rs
+}
+
+// Request returns the request reqID, if there is one.
+func (fs *Fake) Request(reqID string) (Request, bool) {
+	fs.Lock()
+	defer fs.Unlock()
+	req, ok := fs.requests[reqID]
+	if !ok {
+		return Request{}, false
+	}
+	return req.copy(), true
+}
+
+// Deploys returns the deploys made on the request reqID, oldest first.
+// Those of a deleted request are kept.
+func (fs *Fake) Deploys(reqID string) []Deploy {
+	fs.Lock()
+	defer fs.Unlock()
+	return append([]Deploy{}, fs.deploys[reqID]...)
+}
+
+// ActiveDeploy returns the active deploy of the request reqID, if it has
+// one.
+func (fs *Fake) ActiveDeploy(reqID string) (Deploy, bool) {
+	fs.Lock()
+	defer fs.Unlock()
+	req, ok := fs.requests[reqID]
+	if !ok || req.ActiveDeploy == "" {
+		return Deploy{}, false
+	}
+	return *fs.deploy(reqID, req.ActiveDeploy), true
+}
+
+func (req *Request) copy() Request {
+	c := *req
+	c.Owners = append([]string(nil), req.Owners...)
+	return c
+}
+
+func (fs *Fake) serve(w http.ResponseWriter, r *http.Request) {
+	fs.Lock()
+	defer fs.Unlock()
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/api/requests":
+		parents := []interface{}{}
+		for _, req := range fs.requests {
+			parents = append(parents, fs.parent(req))
+		}
+		fs.reply(w, parents)
+	case r.Method == "POST" && r.URL.Path == "/api/requests":
+		req := &Request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if existing, ok := fs.requests[req.ID]; ok {
+			req.ActiveDeploy = existing.ActiveDeploy
+		}
+		fs.requests[req.ID] = req
+		fs.reply(w, fs.parent(req))
+	case r.Method == "GET" && len(path) == 4 && path[2] == "request":
+		req, ok := fs.requests[path[3]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fs.reply(w, fs.parent(req))
+	case r.Method == "PUT" && len(path) == 5 && path[4] == "scale":
+		req, ok := fs.requests[path[3]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var scale struct{ Instances int }
+		if err := json.NewDecoder(r.Body).Decode(&scale); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		req.Instances = scale.Instances
+		fs.reply(w, fs.parent(req))
+	case r.Method == "DELETE" && len(path) == 4 && path[2] == "request":
+		if _, ok := fs.requests[path[3]]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(fs.requests, path[3])
+	case r.Method == "POST" && r.URL.Path == "/api/deploys":
+		var body struct{ Deploy json.RawMessage }
+		dep := Deploy{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || json.Unmarshal(body.Deploy, &dep) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var ci struct {
+			ContainerInfo struct {
+				Docker struct{ Image string } `json:"docker"`
+			} `json:"containerInfo"`
+		}
+		json.Unmarshal(body.Deploy, &ci)
+		req, ok := fs.requests[dep.RequestID]
+		if !ok || fs.deploy(dep.RequestID, dep.ID) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fs.timestamp += 1000
+		dep.Image, dep.timestamp, dep.raw = ci.ContainerInfo.Docker.Image, fs.timestamp, body.Deploy
+		fs.deploys[dep.RequestID] = append(fs.deploys[dep.RequestID], dep)
+		req.ActiveDeploy = dep.ID
+		fs.reply(w, fs.parent(req))
+	case r.Method == "GET" && r.URL.Path == "/api/deploys/pending":
+		fs.reply(w, []interface{}{})
+	case r.Method == "GET" && len(path) == 6 && path[4] == "deploy":
+		dep := fs.deploy(path[3], path[5])
+		if dep == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fs.reply(w, dep.history())
+	case r.Method == "GET" && len(path) == 5 && path[4] == "deploys":
+		hs := []interface{}{}
+		deps := fs.deploys[path[3]]
+		for i := len(deps) - 1; i >= 0; i-- {
+			hs = append(hs, deps[i].history())
+		}
+		fs.reply(w, hs)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (fs *Fake) reply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// deploy returns the deploy depID on the request reqID, or nil if there
+// isn't one. fs must be locked.
+func (fs *Fake) deploy(reqID, depID string) *Deploy {
+	for i, dep := range fs.deploys[reqID] {
+		if dep.ID == depID {
+			return &fs.deploys[reqID][i]
+		}
+	}
+	return nil
+}
+
+// parent is the SingularityRequestParent of req.
+func (fs *Fake) parent(req *Request) map[string]interface{} {
+	state := map[string]interface{}{"requestId": req.ID}
+	if req.ActiveDeploy != "" {
+		state["activeDeploy"] = fs.deploy(req.ID, req.ActiveDeploy).marker()
+	}
+	return map[string]interface{}{"request": req, "state": "ACTIVE", "requestDeployState": state}
+}
+
+func (dep Deploy) marker() map[string]interface{} {
+	return map[string]interface{}{"requestId": dep.RequestID, "deployId": dep.ID, "timestamp": dep.timestamp}
+}
+
+// history is the SingularityDeployHistory of dep, which succeeded.
+func (dep Deploy) history() map[string]interface{} {
+	return map[string]interface{}{
+		"deploy":       dep.raw,
+		"deployMarker": dep.marker(),
+		"deployResult": map[string]interface{}{"deployState": "SUCCEEDED", "timestamp": dep.timestamp},
+	}
+}
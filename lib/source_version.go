@@ -53,9 +53,14 @@ type (
 	}
 )
 
-// MarshalYAML serializes this SourceLocation to a YAML document.
+// MarshalYAML serializes this SourceLocation to a YAML document, in the
+// form ParseCanonicalName reads, which isn't the form String returns if it
+// has an offset.
 func (sl SourceLocation) MarshalYAML() (interface{}, error) {
-	return sl.String(), nil
+	if sl.RepoOffset == "" {
+		return string(sl.RepoURL), nil
+	}
+	return string(sl.RepoURL) + DefaultDelim + string(sl.RepoOffset), nil
 }
 
 // UnmarshalYAML deserializes a YAML document into this SourceLocation
@@ -76,6 +81,12 @@ func (sl SourceLocation) String() string {
 	return fmt.Sprintf("%s:%s", sl.RepoURL, sl.RepoOffset)
 }
 
+// Canonical returns the canonical form of r, which is the same for URLs that
+// differ only in case or in trailing slashes.
+func (r RepoURL) Canonical() RepoURL {
+	return RepoURL(strings.TrimRight(strings.ToLower(string(r)), "/"))
+}
+
 // Repo return the repository URL for this SourceLocation
 func (sl SourceLocation) Repo() RepoURL {
 	return sl.RepoURL
@@ -146,6 +157,9 @@ func (err *MissingPath) Error() string {
 
 func parseChunks(sourceStr string) []string {
 	source := norm.NFC.String(sourceStr)
+	if source == "" {
+		return []string{""}
+	}
 
 	delim := DefaultDelim
 	if !('A' <= source[0] && source[0] <= 'Z') && !('a' <= source[0] && source[0] <= 'z') {
@@ -164,6 +178,10 @@ func sourceVersionFromChunks(source string, chunks []string) (sv SourceVersion,
 
 	sv.RepoURL = RepoURL(chunks[0])
 
+	if len(chunks) < 2 {
+		err = &MissingVersion{repo: chunks[0], parsing: source}
+		return
+	}
 	sv.Version, err = semv.Parse(string(chunks[1]))
 	if err != nil {
 		return
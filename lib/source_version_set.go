@@ -0,0 +1,178 @@
+package sous
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/samsalisbury/semv"
+)
+
+type (
+	// SourceLocationSet is a set of SourceLocations. Locations that are the
+	// same once canonicalised, as by SourceLocation.Canonical, are the same
+	// member, which is spelled as it was when first added.
+	SourceLocationSet map[SourceLocation]SourceLocation
+
+	// SourceVersionSet is a set of SourceVersions. Versions are the same
+	// member if their locations are the same once canonicalised and their
+	// versions are equal, ignoring metadata, as by semv.Version.Equals. Each
+	// member is spelled as it was when first added.
+	SourceVersionSet map[sourceVersionKey]SourceVersion
+
+	sourceVersionKey struct {
+		location SourceLocation
+		version  string
+	}
+)
+
+// Canonical returns the canonical form of sl, which is the same for
+// locations whose repo URLs differ only as RepoURL.Canonical allows, and
+// whose offsets differ only in trailing slashes.
+func (sl SourceLocation) Canonical() SourceLocation {
+	return SourceLocation{
+		RepoURL:    sl.RepoURL.Canonical(),
+		RepoOffset: RepoOffset(strings.TrimRight(string(sl.RepoOffset), "/")),
+	}
+}
+
+// NewSourceLocationSet returns a set of sls.
+func NewSourceLocationSet(sls ...SourceLocation) SourceLocationSet {
+	s := make(SourceLocationSet, len(sls))
+	s.Add(sls...)
+	return s
+}
+
+// Add adds sls to s.
+func (s SourceLocationSet) Add(sls ...SourceLocation) {
+	for _, sl := range sls {
+		c := sl.Canonical()
+		if _, ok := s[c]; !ok {
+			s[c] = sl
+		}
+	}
+}
+
+// Contains reports whether sl is a member of s.
+func (s SourceLocationSet) Contains(sl SourceLocation) bool {
+	_, ok := s[sl.Canonical()]
+	return ok
+}
+
+// Union returns a new set of the members of s and o. Members of both are
+// spelled as in s.
+func (s SourceLocationSet) Union(o SourceLocationSet) SourceLocationSet {
+	u := NewSourceLocationSet(s.Slice()...)
+	u.Add(o.Slice()...)
+	return u
+}
+
+// Intersect returns a new set of the members of s that are also in o.
+func (s SourceLocationSet) Intersect(o SourceLocationSet) SourceLocationSet {
+	i := SourceLocationSet{}
+	for c, sl := range s {
+		if _, ok := o[c]; ok {
+			i[c] = sl
+		}
+	}
+	return i
+}
+
+// Slice returns the members of s, sorted by their canonical forms.
+func (s SourceLocationSet) Slice() []SourceLocation {
+	keys := make([]SourceLocation, 0, len(s))
+	for c := range s {
+		keys = append(keys, c)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	sls := make([]SourceLocation, len(keys))
+	for i, c := range keys {
+		sls[i] = s[c]
+	}
+	return sls
+}
+
+func sourceVersionKeyOf(sv SourceVersion) sourceVersionKey {
+	return sourceVersionKey{
+		location: sv.CanonicalName().Canonical(),
+		version:  sv.Version.Format(semv.MMPPre),
+	}
+}
+
+// NewSourceVersionSet returns a set of svs.
+func NewSourceVersionSet(svs ...SourceVersion) SourceVersionSet {
+	s := make(SourceVersionSet, len(svs))
+	s.Add(svs...)
+	return s
+}
+
+// Add adds svs to s.
+func (s SourceVersionSet) Add(svs ...SourceVersion) {
+	for _, sv := range svs {
+		k := sourceVersionKeyOf(sv)
+		if _, ok := s[k]; !ok {
+			s[k] = sv
+		}
+	}
+}
+
+// Contains reports whether sv is a member of s.
+func (s SourceVersionSet) Contains(sv SourceVersion) bool {
+	_, ok := s[sourceVersionKeyOf(sv)]
+	return ok
+}
+
+// Union returns a new set of the members of s and o. Members of both are
+// spelled as in s.
+func (s SourceVersionSet) Union(o SourceVersionSet) SourceVersionSet {
+	u := NewSourceVersionSet(s.Slice()...)
+	u.Add(o.Slice()...)
+	return u
+}
+
+// Intersect returns a new set of the members of s that are also in o.
+func (s SourceVersionSet) Intersect(o SourceVersionSet) SourceVersionSet {
+	i := SourceVersionSet{}
+	for k, sv := range s {
+		if _, ok := o[k]; ok {
+			i[k] = sv
+		}
+	}
+	return i
+}
+
+// Slice returns the members of s, sorted by their canonical locations and
+// then by version.
+func (s SourceVersionSet) Slice() []SourceVersion {
+	keys := make([]sourceVersionKey, 0, len(s))
+	for k := range s {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if li, lj := keys[i].location.String(), keys[j].location.String(); li != lj {
+			return li < lj
+		}
+		return s[keys[i]].Version.Less(s[keys[j]].Version)
+	})
+	svs := make([]SourceVersion, len(keys))
+	for i, k := range keys {
+		svs[i] = s[k]
+	}
+	return svs
+}
+
+// GroupByLocation groups svs by their canonical source locations, dropping
+// duplicates as a SourceVersionSet does. Each group is sorted by version, and
+// keyed by the location as spelled by the first of svs there.
+func GroupByLocation(svs []SourceVersion) map[SourceLocation][]SourceVersion {
+	set := NewSourceVersionSet(svs...)
+	spelling := NewSourceLocationSet()
+	for _, sv := range svs {
+		spelling.Add(sv.CanonicalName())
+	}
+	groups := map[SourceLocation][]SourceVersion{}
+	for _, sv := range set.Slice() {
+		sl := spelling[sv.CanonicalName().Canonical()]
+		groups[sl] = append(groups[sl], sv)
+	}
+	return groups
+}
@@ -0,0 +1,65 @@
+package sous
+
+import (
+	"testing"
+
+	"github.com/samsalisbury/semv"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeSV(repo, offset, version string) SourceVersion {
+	return SourceVersion{RepoURL: RepoURL(repo), RepoOffset: RepoOffset(offset), Version: semv.MustParse(version)}
+}
+
+func TestSourceLocationSet(t *testing.T) {
+	assert := assert.New(t)
+	one := SourceLocation{RepoURL: "github.com/opentable/one"}
+	two := SourceLocation{RepoURL: "github.com/opentable/two", RepoOffset: "sub"}
+
+	s := NewSourceLocationSet(two, one, SourceLocation{RepoURL: "github.com/OpenTable/One/"})
+	assert.Equal([]SourceLocation{one, two}, s.Slice(), "spelling variants should collapse")
+	assert.True(s.Contains(SourceLocation{RepoURL: "GITHUB.COM/opentable/two", RepoOffset: "sub/"}))
+	assert.False(s.Contains(SourceLocation{RepoURL: "github.com/opentable/two"}))
+
+	three := SourceLocation{RepoURL: "github.com/opentable/three"}
+	o := NewSourceLocationSet(SourceLocation{RepoURL: "github.com/opentable/TWO", RepoOffset: "sub"}, three)
+	assert.Equal([]SourceLocation{one, three, two}, s.Union(o).Slice())
+	assert.Equal([]SourceLocation{two}, s.Intersect(o).Slice())
+	assert.Len(s, 2, "Union and Intersect shouldn't change their operands")
+}
+
+func TestSourceVersionSet(t *testing.T) {
+	assert := assert.New(t)
+	a1 := makeSV("github.com/opentable/a", "", "1.0.0")
+	a2 := makeSV("github.com/opentable/a", "", "2.0.0-rc1")
+	b1 := makeSV("github.com/opentable/b", "", "1.0.0")
+
+	withMeta := a1
+	withMeta.Version.Meta = "abc123"
+	s := NewSourceVersionSet(a2, b1, a1, makeSV("github.com/OpenTable/A/", "", "1.0.0"), withMeta)
+	assert.Equal([]SourceVersion{a1, a2, b1}, s.Slice())
+	assert.True(s.Contains(makeSV("github.com/opentable/B", "", "1.0.0")))
+	assert.False(s.Contains(makeSV("github.com/opentable/b", "", "1.0.1")))
+
+	o := NewSourceVersionSet(makeSV("github.com/opentable/b/", "", "1.0.0"), makeSV("github.com/opentable/c", "", "0.1.0"))
+	assert.Equal([]SourceVersion{a1, a2, b1, makeSV("github.com/opentable/c", "", "0.1.0")}, s.Union(o).Slice())
+	assert.Equal([]SourceVersion{b1}, s.Intersect(o).Slice())
+}
+
+func TestGroupByLocation(t *testing.T) {
+	groups := GroupByLocation([]SourceVersion{
+		makeSV("github.com/opentable/a", "", "2.0.0"),
+		makeSV("github.com/opentable/b", "sub", "1.0.0"),
+		makeSV("github.com/OpenTable/a/", "", "1.0.0"),
+		makeSV("github.com/opentable/a", "", "2.0.0"),
+	})
+	assert.Equal(t, map[SourceLocation][]SourceVersion{
+		{RepoURL: "github.com/opentable/a"}: {
+			makeSV("github.com/OpenTable/a/", "", "1.0.0"),
+			makeSV("github.com/opentable/a", "", "2.0.0"),
+		},
+		{RepoURL: "github.com/opentable/b", RepoOffset: "sub"}: {
+			makeSV("github.com/opentable/b", "sub", "1.0.0"),
+		},
+	}, groups)
+}
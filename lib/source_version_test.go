@@ -3,6 +3,7 @@ package sous
 import (
 	"testing"
 
+	"github.com/opentable/sous/util/yaml"
 	"github.com/samsalisbury/semv"
 	"github.com/stretchr/testify/assert"
 )
@@ -57,3 +58,27 @@ func TestParseSourceVersion(t *testing.T) {
 	assert.Equal(SourceVersion{"github.com/opentable/sous", semv.MustParse("1"), ""}, mustParse(":github.com/opentable/sous:1:"))
 	assert.Equal(SourceVersion{"github.com/opentable/sous", semv.MustParse("1"), ""}, mustParse("github.com/opentable/sous,1"))
 }
+
+func TestParseSourceVersion_Invalid(t *testing.T) {
+	for _, str := range []string{"", "github.com/opentable/sous", ",github.com/opentable/sous"} {
+		if _, err := ParseSourceVersion(str); err == nil {
+			t.Errorf("got nil error parsing %q", str)
+		}
+	}
+}
+
+func TestSourceLocation_YAMLRoundTrip(t *testing.T) {
+	for _, sl := range []SourceLocation{
+		{RepoURL: "github.com/opentable/sous"},
+		{RepoURL: "github.com/opentable/sous", RepoOffset: "util"},
+	} {
+		out, err := yaml.Marshal(sl)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		var got SourceLocation
+		if assert.NoError(t, yaml.Unmarshal(out, &got)) {
+			assert.Equal(t, sl, got)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package sous
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+
 	"github.com/opentable/sous/util/hy"
 	"github.com/opentable/sous/util/yaml"
 )
@@ -27,6 +31,9 @@ type (
 		// Resources contains definitions for resource types available to
 		// deployment manifests.
 		Resources ResDefs
+		// Freezes stop rectification changing some or all deployments for
+		// a time: see Freeze.
+		Freezes Freezes `yaml:",omitempty"`
 	}
 
 	// EnvDefs is a collection of EnvDef
@@ -62,6 +69,19 @@ type (
 		BaseURL string
 		// Env is the default environment for all deployments in this region.
 		Env EnvDefaults
+		// Resources are the default resources of all deployments in this
+		// region.
+		Resources Resources `yaml:",omitempty"`
+		// Properties are values particular to this cluster, such as the
+		// hosts of services it depends on, which the Env values and volume
+		// host paths of deployments to it can refer to as
+		// {{.Cluster.<Name>}}.
+		Properties map[string]string `yaml:",omitempty"`
+		// Capacity limits the deployments to the cluster as a whole, and
+		// OwnerQuotas those of each owner, by owner: rectify refuses to
+		// exceed them. See Clusters.Quotas.
+		Capacity    Quota            `yaml:",omitempty"`
+		OwnerQuotas map[string]Quota `yaml:",omitempty"`
 	}
 
 	// EnvDefaults is a list of named environment variables along with their values.
@@ -72,15 +92,137 @@ type (
 	Var string
 	// VarType represents the type of a Var (not yet implemented).
 	VarType string
+
+	// UndefinedCluster is a deployment in the manifest named Manifest to a
+	// cluster that isn't defined in Defs.
+	UndefinedCluster struct {
+		Manifest, Cluster string
+	}
+
+	// UndefinedClusters is returned by LoadState when manifests deploy to
+	// clusters that aren't defined.
+	UndefinedClusters []UndefinedCluster
 )
 
-// LoadState loads the state from a directory
-func LoadState(dir string) (st State, err error) {
+// LoadState loads the state from a directory. It carries on past files that
+// can't be parsed, and returns the state read from the rest along with
+// hy.Errors, which gives the path relative to dir and, where known, the line
+// of each file that failed: see ParseProblems. Any other error, such as dir
+// being unreadable, is returned as soon as it happens. If every file parses
+// but manifests deploy to clusters that aren't defined, the state is
+// returned along with UndefinedClusters.
+func LoadState(dir string) (State, error) {
+	st, _, err := LoadStateManifest(dir)
+	return st, err
+}
+
+// LoadStateManifest is like LoadState, but also returns the manifest of the
+// files read, which is nil if dir couldn't be read at all.
+func LoadStateManifest(dir string) (st State, m hy.ReadManifest, err error) {
 	u := hy.NewUnmarshaler(yaml.Unmarshal)
-	err = u.Unmarshal(dir, &st)
+	if m, err = u.UnmarshalManifest(dir, &st); err != nil {
+		if _, ok := err.(hy.Errors); !ok {
+			m = nil
+		}
+		return
+	}
+	if undefined := st.UndefinedClusters(); len(undefined) != 0 {
+		err = undefined
+	}
 	return
 }
 
+func (e UndefinedCluster) Error() string {
+	return fmt.Sprintf("manifest %s deploys to undefined cluster %q", e.Manifest, e.Cluster)
+}
+
+func (es UndefinedClusters) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// UndefinedClusters returns each deployment in the manifests to a cluster
+// that isn't defined in Defs, sorted by manifest and cluster.
+func (st *State) UndefinedClusters() UndefinedClusters {
+	undefined := UndefinedClusters{}
+	for name, m := range st.Manifests {
+		for cluster := range m.Deployments {
+			if _, ok := st.Defs.Clusters[cluster]; !ok && cluster != GlobalDeploySpec {
+				undefined = append(undefined, UndefinedCluster{Manifest: name, Cluster: cluster})
+			}
+		}
+	}
+	sort.Slice(undefined, func(i, j int) bool {
+		if undefined[i].Manifest != undefined[j].Manifest {
+			return undefined[i].Manifest < undefined[j].Manifest
+		}
+		return undefined[i].Cluster < undefined[j].Cluster
+	})
+	return undefined
+}
+
+// SourceLocations returns the source locations of all the manifests in this
+// state, sorted and without duplicates, as a SourceLocationSet would have
+// them.
+func (st *State) SourceLocations() []SourceLocation {
+	sls := NewSourceLocationSet()
+	for _, m := range st.Manifests {
+		sls.Add(m.Source)
+	}
+	return sls.Slice()
+}
+
+// ManifestFor returns the key and manifest in Manifests for sl. It returns
+// false if there is none.
+func (st *State) ManifestFor(sl SourceLocation) (string, *Manifest, bool) {
+	for name, m := range st.Manifests {
+		if m.Source == sl {
+			return name, m, true
+		}
+	}
+	return "", nil, false
+}
+
+// ClusterURLs maps the name of each cluster defined to its base URL, as
+// NewSingularityClient wants.
+func (d Defs) ClusterURLs() map[string]string {
+	urls := make(map[string]string, len(d.Clusters))
+	for name, cl := range d.Clusters {
+		urls[name] = cl.BaseURL
+	}
+	return urls
+}
+
+// inheritance returns the configs a deployment of m to the cluster, which is
+// defined as name, inherits from: the cluster's defaults, and then m's Global
+// spec, if it has one.
+func (cl Cluster) inheritance(name string, m *Manifest) []NamedDeployConfig {
+	inherit := []NamedDeployConfig{{Name: "Defs.Clusters." + name, DeployConfig: cl.defaults()}}
+	if global, ok := m.Deployments[GlobalDeploySpec]; ok {
+		inherit = append(inherit, NamedDeployConfig{Name: "Deployments." + GlobalDeploySpec, DeployConfig: global.DeployConfig})
+	}
+	return inherit
+}
+
+// defaults returns the config made of the cluster's default Env and
+// Resources.
+func (cl Cluster) defaults() DeployConfig {
+	dc := DeployConfig{}
+	if len(cl.Env) != 0 {
+		dc.Env = make(Env, len(cl.Env))
+		for k, v := range cl.Env {
+			dc.Env[k] = string(v)
+		}
+	}
+	if len(cl.Resources) != 0 {
+		dc.Resources = cl.Resources.Clone()
+	}
+	return dc
+}
+
 // BaseURLs returns the urls for all the clusters referred to in this state
 func (st *State) BaseURLs() []string {
 	urls := make([]string, 0, len(st.Defs.Clusters))
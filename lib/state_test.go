@@ -0,0 +1,260 @@
+package sous
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/samsalisbury/semv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadState_UndefinedClusters(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "sous-state")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"defs.yaml": "Clusters:\n  us-west:\n    BaseURL: http://us-west\n",
+		"manifests/github.com/opentable/one.yaml": `
+Source: github.com/opentable/one
+Kind: http-service
+Deployments:
+  Global:
+    NumInstances: 1
+  us-west:
+    Version: 1.0.0
+  mars:
+    Version: 1.0.0
+  venus:
+    Version: 1.0.0
+`,
+	}
+	if !writeFiles(t, dir, files) {
+		return
+	}
+
+	st, err := LoadState(dir)
+	assert.Equal(UndefinedClusters{
+		{Manifest: "github.com/opentable/one", Cluster: "mars"},
+		{Manifest: "github.com/opentable/one", Cluster: "venus"},
+	}, err)
+	assert.Contains(err.Error(), `manifest github.com/opentable/one deploys to undefined cluster "mars"`)
+	assert.Len(st.Manifests, 1, "the state should still be returned")
+}
+
+// writeFiles writes files, a map of paths relative to dir to their
+// contents, reporting whether it succeeded.
+func writeFiles(t *testing.T, dir string, files map[string]string) bool {
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if !assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0777)) {
+			return false
+		}
+		if !assert.NoError(t, ioutil.WriteFile(path, []byte(contents), 0666)) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLoadState_Kinds(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "sous-state")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"defs.yaml": "Clusters:\n  us-west:\n    BaseURL: http://us-west\n",
+		"manifests/github.com/opentable/one.yaml": `
+Source: github.com/opentable/one
+Deployments:
+  us-west:
+    Version: 1.0.0
+`,
+	}
+	if !writeFiles(t, dir, files) {
+		return
+	}
+	st, err := LoadState(dir)
+	if assert.NoError(err) {
+		if m := st.Manifests["github.com/opentable/one"]; assert.NotNil(m) {
+			assert.Equal(ManifestKindService, m.Kind, "a manifest without a Kind is an http-service")
+		}
+	}
+
+	files = map[string]string{"manifests/github.com/opentable/two.yaml": `
+Source: github.com/opentable/two
+Kind: scheduled
+Deployments:
+  us-west:
+    Version: 1.0.0
+`}
+	if !writeFiles(t, dir, files) {
+		return
+	}
+	_, err = LoadState(dir)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "two.yaml")
+		assert.Contains(err.Error(), "Kind scheduled requires a Schedule")
+	}
+}
+
+func TestDeploymentsFromManifest_ClusterDefaults(t *testing.T) {
+	assert := assert.New(t)
+	st := State{
+		Defs: Defs{Clusters: Clusters{
+			"us-west": {
+				Name:      "us-west",
+				BaseURL:   "http://us-west",
+				Env:       EnvDefaults{"REGION": "us-west", "LOG": "info"},
+				Resources: Resources{"cpus": "0.5", "memory": "256"},
+			},
+			"bare": {Name: "bare", BaseURL: "http://bare"},
+		}},
+	}
+	m := &Manifest{
+		Source: SourceLocation{RepoURL: "github.com/opentable/one"},
+		Kind:   ManifestKindService,
+		Deployments: DeploySpecs{
+			"Global": {DeployConfig: DeployConfig{
+				Env:       Env{"LOG": "debug"},
+				Resources: Resources{"memory": "512"},
+			}},
+			"us-west": {
+				DeployConfig: DeployConfig{Resources: Resources{"cpus": "1"}},
+				Version:      semv.MustParse("1.0.0"),
+			},
+			"bare": {Version: semv.MustParse("1.0.0")},
+		},
+	}
+
+	ds, err := st.DeploymentsFromManifest(m)
+	if !assert.NoError(err) {
+		return
+	}
+	byCluster := map[ClusterName]*Deployment{}
+	for _, d := range ds {
+		byCluster[d.Cluster] = d
+	}
+	if !assert.Len(byCluster, 2) {
+		return
+	}
+	usWest := byCluster["http://us-west"]
+	assert.Equal(Env{"REGION": "us-west", "LOG": "debug"}, usWest.Env)
+	assert.Equal(Resources{"cpus": "1", "memory": "512"}, usWest.Resources)
+	bare := byCluster["http://bare"]
+	assert.Equal(Env{"LOG": "debug"}, bare.Env)
+	assert.Equal(Resources{"memory": "512"}, bare.Resources)
+	assert.Equal(EnvDefaults{"REGION": "us-west", "LOG": "info"}, st.Defs.Clusters["us-west"].Env,
+		"cluster defaults should not be modified")
+}
+
+func TestStateDeployments_Duplicates(t *testing.T) {
+	manifest := func(repo string) *Manifest {
+		return &Manifest{
+			Source: SourceLocation{RepoURL: RepoURL(repo)},
+			Kind:   ManifestKindService,
+			Deployments: DeploySpecs{
+				"us-west": {Version: semv.MustParse("1.0.0")},
+			},
+		}
+	}
+	for _, dup := range []string{"github.com/OpenTable/One", "github.com/opentable/one/"} {
+		st := State{
+			Defs: Defs{Clusters: Clusters{
+				"us-west": {Name: "us-west", BaseURL: "http://us-west"},
+			}},
+			Manifests: Manifests{
+				"github.com/opentable/one": manifest("github.com/opentable/one"),
+				dup:                        manifest(dup),
+			},
+		}
+
+		names := [2]string{"github.com/opentable/one", dup}
+		if names[1] < names[0] {
+			names[0], names[1] = names[1], names[0]
+		}
+		want := &DuplicateDeployment{
+			ID: DeploymentID{
+				Cluster: "http://us-west",
+				Source:  SourceLocation{RepoURL: "github.com/opentable/one"},
+			},
+			Manifests: names,
+		}
+		// repeated, since the manifests are a map
+		for i := 0; i < 5; i++ {
+			ds, err := st.Deployments()
+			assert.Nil(t, ds, dup)
+			assert.Equal(t, want, err, dup)
+		}
+	}
+}
+
+func TestDefsClusterURLs(t *testing.T) {
+	defs := Defs{Clusters: Clusters{
+		"one": {Name: "one", BaseURL: "http://one"},
+		"two": {Name: "two", BaseURL: "http://two"},
+	}}
+	assert.Equal(t, map[string]string{"one": "http://one", "two": "http://two"}, defs.ClusterURLs())
+}
+
+func TestDeploymentsFromManifest_Inheritance(t *testing.T) {
+	st := State{
+		Defs: Defs{Clusters: Clusters{
+			"east": {Name: "east", BaseURL: "http://east"},
+		}},
+	}
+	global := PartialDeploySpec{DeployConfig: DeployConfig{
+		Resources:    Resources{"cpus": "0.1", "memory": "100"},
+		Env:          Env{"A": "1"},
+		NumInstances: 2,
+	}}
+	version := semv.MustParse("1.0.0")
+	removing := PartialDeploySpec{Version: version}
+	removing.RemoveKey("Env", "A")
+	removing.RemoveKey("Resources", "memory")
+	cases := []struct {
+		name  string
+		specs DeploySpecs
+		want  DeployConfig
+	}{
+		{
+			name:  "no global section",
+			specs: DeploySpecs{"east": {DeployConfig: global.DeployConfig, Version: version}},
+			want:  global.DeployConfig,
+		},
+		{
+			name:  "no overrides",
+			specs: DeploySpecs{"Global": global, "east": {Version: version}},
+			want:  global.DeployConfig,
+		},
+		{
+			name:  "null removes keys",
+			specs: DeploySpecs{"Global": global, "east": removing},
+			want:  DeployConfig{Resources: Resources{"cpus": "0.1"}, Env: Env{}, NumInstances: 2},
+		},
+		{
+			name: "empty is a value",
+			specs: DeploySpecs{"Global": global, "east": {
+				DeployConfig: DeployConfig{Env: Env{"A": ""}},
+				Version:      version,
+			}},
+			want: DeployConfig{Resources: global.Resources, Env: Env{"A": ""}, NumInstances: 2},
+		},
+	}
+	for _, c := range cases {
+		m := &Manifest{Kind: ManifestKindService, Deployments: c.specs}
+		ds, err := st.DeploymentsFromManifest(m)
+		if !assert.NoError(t, err, c.name) || !assert.Len(t, ds, 1, c.name) {
+			continue
+		}
+		assert.Equal(t, c.want.Resources, ds[0].Resources, c.name)
+		assert.Equal(t, c.want.Env, ds[0].Env, c.name)
+		assert.Equal(t, c.want.NumInstances, ds[0].NumInstances, c.name)
+	}
+}
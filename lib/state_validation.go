@@ -0,0 +1,225 @@
+package sous
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/opentable/sous/util/hy"
+	"github.com/opentable/sous/util/validator"
+)
+
+// StateProblem is a single problem found by ValidateState.
+type StateProblem struct {
+	// File is the path of the file with the problem, relative to the state
+	// directory.
+	File string `json:"file"`
+	// Line is the line of File the problem is on, if known, or zero.
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+func (p StateProblem) String() string {
+	if p.Line != 0 {
+		return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.File, p.Message)
+}
+
+// ValidateState reads the state in dir like LoadState, but rejects unknown
+// fields, and then checks each manifest and the deployments assembled from
+// it, and that those deployments fit the quotas of their clusters, which are
+// reported against defs.yaml. It carries on past each problem, and returns
+// all of them. The error is only non-nil if dir could not be read at all.
+func ValidateState(dir string) ([]StateProblem, error) {
+	st := State{}
+	u := hy.NewStrictUnmarshaler()
+	u.Validate = func(v interface{}) error {
+		if m, ok := v.(*Manifest); ok {
+			return validator.Validate(*m)
+		}
+		return nil
+	}
+	problems := []StateProblem{}
+	if err := u.Unmarshal(dir, &st); err != nil {
+		var ok bool
+		if problems, ok = ParseProblems(err); !ok {
+			return nil, err
+		}
+	}
+
+	names := make([]string, 0, len(st.Manifests))
+	for name := range st.Manifests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		file := manifestFile(dir, name)
+		for _, err := range st.validateDeployments(st.Manifests[name]) {
+			problems = append(problems, StateProblem{File: file, Message: err.Error()})
+		}
+	}
+	// if the deployments can't all be built, the problems stopping them
+	// have been found above, and the quotas can't be checked
+	if ds, err := st.Deployments(); err == nil {
+		for _, e := range st.Defs.Clusters.Quotas().Check(ds) {
+			problems = append(problems, StateProblem{File: "defs.yaml", Message: e.Error()})
+		}
+	}
+	return problems, nil
+}
+
+// ParseProblems returns a StateProblem for each file that couldn't be
+// parsed, given the error returned by LoadState. It returns false if err
+// isn't made of failures of individual files, and so the state wasn't read.
+func ParseProblems(err error) ([]StateProblem, bool) {
+	es, ok := err.(hy.Errors)
+	if !ok {
+		return nil, false
+	}
+	problems := make([]StateProblem, 0, len(es))
+	for _, e := range es {
+		problems = append(problems, StateProblem{File: e.File, Line: e.Line, Message: e.Cause.Error()})
+	}
+	return problems, true
+}
+
+// validateDeployments checks that every cluster m deploys to is defined, and
+// that the resulting deployments are valid.
+func (st *State) validateDeployments(m *Manifest) []error {
+	clusterNames := make([]string, 0, len(m.Deployments))
+	for name := range m.Deployments {
+		clusterNames = append(clusterNames, name)
+	}
+	sort.Strings(clusterNames)
+	errs := []error{}
+	for _, name := range clusterNames {
+		if name == GlobalDeploySpec {
+			continue
+		}
+		cluster, ok := st.Defs.Clusters[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("deployment to undefined cluster %q", name))
+			continue
+		}
+		d, prov, err := buildClusterDeployment(m, name, cluster)
+		if _, ok := err.(*EnvTemplateError); ok {
+			errs = append(errs, err)
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cluster %s: %s", name, err))
+			continue
+		}
+		for _, err := range d.Validate() {
+			if fe, ok := err.(fieldError); ok && prov[fe.field] != "" {
+				err = fmt.Errorf("%s (from %s)", err, prov[fe.field])
+			}
+			errs = append(errs, fmt.Errorf("cluster %s: %s", name, err))
+		}
+	}
+	return errs
+}
+
+// manifestFile returns the path of the file that the manifest named name was
+// read from, relative to the state directory dir.
+func manifestFile(dir, name string) string {
+	file := filepath.Join("manifests", filepath.FromSlash(name))
+	for _, ext := range []string{".yaml", ".json"} {
+		if _, err := os.Stat(filepath.Join(dir, file+ext)); err == nil {
+			return file + ext
+		}
+	}
+	return file + ".yaml"
+}
+
+// ImageReport is the outcome of State.CheckImages.
+type ImageReport struct {
+	// Checked is the number of source versions checked.
+	Checked int
+	// Missing has a problem for each version of a manifest that has no
+	// image, against the manifest's file.
+	Missing []StateProblem
+	// Errs are the errors of checks that couldn't be made, e.g.
+	// RegistryUnavailable, or OfflineError if only names were checked.
+	Errs []error
+}
+
+// CheckImages checks that every deployment of st, which was read from dir,
+// has an image, with VerifyImageExists. Each source version is checked once,
+// however many clusters it's deployed to, and at most parallel are checked
+// at once.
+func (st *State) CheckImages(dir string, mapper ImageMapper, parallel int) ImageReport {
+	type check struct {
+		sv       SourceVersion
+		file     string
+		clusters []string
+		err      error
+	}
+	checks := []*check{}
+	bySV := map[SourceVersion]*check{}
+	names := make([]string, 0, len(st.Manifests))
+	for name := range st.Manifests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := st.Manifests[name]
+		clusterNames := make([]string, 0, len(m.Deployments))
+		for cn := range m.Deployments {
+			clusterNames = append(clusterNames, cn)
+		}
+		sort.Strings(clusterNames)
+		for _, cn := range clusterNames {
+			cluster, ok := st.Defs.Clusters[cn]
+			if !ok {
+				continue
+			}
+			d, _, err := buildClusterDeployment(m, cn, cluster)
+			if err != nil {
+				continue
+			}
+			c, ok := bySV[d.SourceVersion]
+			if !ok {
+				c = &check{sv: d.SourceVersion, file: manifestFile(dir, name)}
+				bySV[d.SourceVersion] = c
+				checks = append(checks, c)
+			}
+			c.clusters = append(c.clusters, cn)
+		}
+	}
+
+	if parallel < 1 {
+		parallel = 1
+	}
+	slots := make(chan struct{}, parallel)
+	wg := sync.WaitGroup{}
+	wg.Add(len(checks))
+	for _, c := range checks {
+		go func(c *check) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			c.err = VerifyImageExists(mapper, c.sv)
+		}(c)
+	}
+	wg.Wait()
+
+	r := ImageReport{Checked: len(checks)}
+	for _, c := range checks {
+		switch c.err.(type) {
+		case nil:
+		case NoImageNameFound, ImageMissing:
+			r.Missing = append(r.Missing, StateProblem{
+				File:    c.file,
+				Message: fmt.Sprintf("cluster %s: %s", strings.Join(c.clusters, ", "), c.err),
+			})
+		default:
+			r.Errs = append(r.Errs, c.err)
+		}
+	}
+	return r
+}
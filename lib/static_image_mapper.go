@@ -0,0 +1,125 @@
+package sous
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/docker/distribution/reference"
+	"golang.org/x/net/context"
+)
+
+type (
+	// ImageMapping pairs a source version with the name of its image.
+	ImageMapping struct {
+		SourceVersion SourceVersion
+		ImageName     string
+	}
+
+	// StaticImageMapper is an ImageMapper that knows only the images it's
+	// built with, and those Inserted into it, and never talks to a registry,
+	// for tests and offline tools. Each image name is its own canonical name.
+	StaticImageMapper struct {
+		sync.RWMutex
+		versions map[string]SourceVersion
+		names    map[svKey]string
+		closed   bool
+	}
+
+	// svKey identifies a source version as the NameCache does: by its
+	// location and the string form of its version.
+	svKey struct {
+		repo, offset, version string
+	}
+)
+
+// NewStaticImageMapper builds a StaticImageMapper that knows the images in
+// ms, or returns an error if any of their names isn't valid.
+func NewStaticImageMapper(ms ...ImageMapping) (*StaticImageMapper, error) {
+	sm := &StaticImageMapper{
+		versions: map[string]SourceVersion{},
+		names:    map[svKey]string{},
+	}
+	for _, m := range ms {
+		if err := sm.Insert(m.SourceVersion, m.ImageName, ""); err != nil {
+			return nil, err
+		}
+	}
+	return sm, nil
+}
+
+func keyOf(sv SourceVersion) svKey {
+	return svKey{string(sv.RepoURL), string(sv.RepoOffset), sv.Version.String()}
+}
+
+// GetCanonicalName implements ImageMapper
+func (sm *StaticImageMapper) GetCanonicalName(in string) (string, error) {
+	sm.RLock()
+	defer sm.RUnlock()
+	if _, ok := sm.versions[in]; !ok {
+		return "", NoSourceVersionFound{imageName(in)}
+	}
+	return in, nil
+}
+
+// Insert implements ImageMapper. The etag is ignored. If sv already has an
+// image, in becomes its name, though the old name still maps to sv.
+func (sm *StaticImageMapper) Insert(sv SourceVersion, in, etag string) error {
+	if _, err := reference.ParseNamed(in); err != nil {
+		return fmt.Errorf("%v for %v", err, in)
+	}
+	sm.Lock()
+	defer sm.Unlock()
+	sm.versions[in] = sv
+	sm.names[keyOf(sv)] = in
+	return nil
+}
+
+// GetImageName implements ImageMapper
+func (sm *StaticImageMapper) GetImageName(sv SourceVersion) (string, error) {
+	sm.RLock()
+	defer sm.RUnlock()
+	in, ok := sm.names[keyOf(sv)]
+	if !ok {
+		return "", NoImageNameFound{sv}
+	}
+	return in, nil
+}
+
+// GetSourceVersion implements ImageMapper
+func (sm *StaticImageMapper) GetSourceVersion(in string) (SourceVersion, error) {
+	sm.RLock()
+	defer sm.RUnlock()
+	sv, ok := sm.versions[in]
+	if !ok {
+		return SourceVersion{}, NoSourceVersionFound{imageName(in)}
+	}
+	return sv, nil
+}
+
+// GetLabels implements ImageMapper
+func (sm *StaticImageMapper) GetLabels(in string) (map[string]string, error) {
+	sv, err := sm.GetSourceVersion(in)
+	if err != nil {
+		return nil, err
+	}
+	return sv.DockerLabels(), nil
+}
+
+// Ping implements ImageMapper: it only fails once sm is closed.
+func (sm *StaticImageMapper) Ping(ctx context.Context) error {
+	sm.RLock()
+	defer sm.RUnlock()
+	if sm.closed {
+		return ErrClosed{}
+	}
+	return nil
+}
+
+// Close implements ImageMapper. sm holds nothing to release, and still
+// answers lookups afterwards.
+func (sm *StaticImageMapper) Close() error {
+	sm.Lock()
+	defer sm.Unlock()
+	sm.closed = true
+	return nil
+}
@@ -1,10 +1,19 @@
 package sous
 
-import "log"
+import (
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
 
 type (
 	// DummyRectificationClient implements RectificationClient but doesn't act on the Mesos scheduler;
-	// instead it collects the changes that would be performed and options
+	// instead it collects the changes that would be performed and options.
+	// It records every call made to it, in order (see Calls), and can be
+	// told what image names to give deployments and when to fail. It's
+	// safe for concurrent use, as the rectifier makes it.
 	DummyRectificationClient struct {
 		logger    *log.Logger
 		nameCache ImageMapper
@@ -12,37 +21,82 @@ type (
 		deployed  []dummyDeploy
 		scaled    []dummyScale
 		deleted   []dummyDelete
+		steps     []dummyStep
+		pending   []dummyPending
+		cancelled []dummyPending
+		failures  map[string]error
+		// nthFailures maps methods to the errors to return from their nth
+		// calls, counting from 1
+		nthFailures map[string]map[int]error
+		calls       []Call
+		imageNames  map[SourceVersion]string
+		images      map[string]SourceVersion
+		sync.Mutex
+	}
+
+	// Call is a call made to a DummyRectificationClient.
+	Call struct {
+		// Method is the name of the method called, e.g. "Deploy".
+		Method string
+		// Args are the arguments of the call, in order.
+		Args []interface{}
+		// Err is the error the call returned.
+		Err error
 	}
 
 	dummyDeploy struct {
-		cluster   string
+		cluster   ClusterName
 		depID     string
 		reqID     string
 		imageName string
 		res       Resources
 		e         Env
 		vols      Volumes
+		// instances is set by deploys that also scale the request
+		instances int
+		// at is set when the deploy is recorded
+		at time.Time
+	}
+
+	dummyStep struct {
+		cluster      ClusterName
+		reqID, depID string
+		target       int
+	}
+
+	dummyPending struct {
+		cluster      ClusterName
+		reqID, depID string
+		// polls is how many more times the deploy is reported pending
+		polls int
 	}
 
 	dummyRequest struct {
-		cluster string
+		cluster ClusterName
 		id      string
 		count   int
+		// kind and schedule are set by PostRequestOfKind
+		kind     ManifestKind
+		schedule string
 	}
 
 	dummyScale struct {
-		cluster, reqid string
-		count          int
-		message        string
+		cluster ClusterName
+		reqid   string
+		count   int
+		message string
 	}
 
 	dummyDelete struct {
-		cluster, reqid, message string
+		cluster        ClusterName
+		reqid, message string
 	}
 
 	// DummyNameCache implements the ImageMapper interface by returning a
 	// computed image name for a given source version
 	DummyNameCache struct {
+		sync.Mutex
+		closed bool
 	}
 )
 
@@ -51,6 +105,120 @@ func NewDummyRectificationClient(nc ImageMapper) *DummyRectificationClient {
 	return &DummyRectificationClient{nameCache: nc}
 }
 
+// FailWith makes every subsequent call to the named method (e.g. "Deploy" or
+// "ImageName") return err instead of succeeding. A nil err clears the failure.
+func (t *DummyRectificationClient) FailWith(method string, err error) {
+	t.Lock()
+	defer t.Unlock()
+	if t.failures == nil {
+		t.failures = map[string]error{}
+	}
+	t.failures[method] = err
+}
+
+// FailCall makes the nth call to the named method, counting from 1 and
+// including calls already made, return err instead of succeeding. It takes
+// precedence over FailWith.
+func (t *DummyRectificationClient) FailCall(method string, n int, err error) {
+	t.Lock()
+	defer t.Unlock()
+	if t.nthFailures == nil {
+		t.nthFailures = map[string]map[int]error{}
+	}
+	if t.nthFailures[method] == nil {
+		t.nthFailures[method] = map[int]error{}
+	}
+	t.nthFailures[method][n] = err
+}
+
+// SetImageName makes ImageName return name for any deployment of d's
+// source version, rather than asking the ImageMapper.
+func (t *DummyRectificationClient) SetImageName(d *Deployment, name string) {
+	t.Lock()
+	defer t.Unlock()
+	if t.imageNames == nil {
+		t.imageNames = map[SourceVersion]string{}
+	}
+	t.imageNames[d.SourceVersion] = name
+}
+
+// Calls returns every call made to the client so far, in order.
+func (t *DummyRectificationClient) Calls() []Call {
+	t.Lock()
+	defer t.Unlock()
+	return append([]Call{}, t.calls...)
+}
+
+// CallsTo returns the calls made to the named method so far, in order.
+func (t *DummyRectificationClient) CallsTo(method string) []Call {
+	t.Lock()
+	defer t.Unlock()
+	calls := []Call{}
+	for _, c := range t.calls {
+		if c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// Created reports whether a request with the ID reqID has been posted.
+func (t *DummyRectificationClient) Created(reqID string) bool {
+	t.Lock()
+	defer t.Unlock()
+	for _, r := range t.created {
+		if r.id == reqID {
+			return true
+		}
+	}
+	return false
+}
+
+// Deployed reports whether anything has been deployed to the request reqID.
+func (t *DummyRectificationClient) Deployed(reqID string) bool {
+	t.Lock()
+	defer t.Unlock()
+	for _, d := range t.deployed {
+		if d.reqID == reqID {
+			return true
+		}
+	}
+	return false
+}
+
+// Deleted reports whether the request reqID has been deleted.
+func (t *DummyRectificationClient) Deleted(reqID string) bool {
+	t.Lock()
+	defer t.Unlock()
+	for _, d := range t.deleted {
+		if d.reqid == reqID {
+			return true
+		}
+	}
+	return false
+}
+
+// call runs f, which does the work of a call to method with args, unless
+// the call should fail, and records the call. It must be called with t
+// locked.
+func (t *DummyRectificationClient) call(method string, args []interface{}, f func() error) error {
+	n := 1
+	for _, c := range t.calls {
+		if c.Method == method {
+			n++
+		}
+	}
+	err := t.nthFailures[method][n]
+	if err == nil {
+		err = t.failures[method]
+	}
+	if err == nil {
+		err = f()
+	}
+	t.calls = append(t.calls, Call{Method: method, Args: args, Err: err})
+	return err
+}
+
 // SetLogger sets the logger for the client
 func (t *DummyRectificationClient) SetLogger(l *log.Logger) {
 	l.Println("dummy begin")
@@ -71,49 +239,359 @@ func (t *DummyRectificationClient) logf(f string, v ...interface{}) {
 
 // Deploy implements part of the RectificationClient interface
 func (t *DummyRectificationClient) Deploy(
-	cluster, depID, reqID, imageName string, res Resources, e Env, vols Volumes) error {
+	cluster ClusterName, depID, reqID, imageName string, res Resources, e Env, vols Volumes) error {
 	t.logf("Deploying instance %s %s %s %s %v %v %v", cluster, depID, reqID, imageName, res, e, vols)
-	t.deployed = append(t.deployed, dummyDeploy{cluster, depID, reqID, imageName, res, e, vols})
+	t.Lock()
+	defer t.Unlock()
+	args := []interface{}{cluster, depID, reqID, imageName, res, e, vols}
+	return t.call("Deploy", args, func() error {
+		return t.recordDeploy(dummyDeploy{cluster, depID, reqID, imageName, res, e, vols, 0, time.Time{}})
+	})
+}
+
+// deployWithInstances records a call to DeployWithInstances, for
+// tests to implement InstanceDeployer with.
+func (t *DummyRectificationClient) deployWithInstances(
+	cluster ClusterName, depID, reqID, imageName string, res Resources, e Env, vols Volumes, instances int) error {
+	t.Lock()
+	defer t.Unlock()
+	args := []interface{}{cluster, depID, reqID, imageName, res, e, vols, instances}
+	return t.call("DeployWithInstances", args, func() error {
+		return t.recordDeploy(dummyDeploy{cluster, depID, reqID, imageName, res, e, vols, instances, time.Time{}})
+	})
+}
+
+// recordDeploy must be called with t locked. The last deploy recorded on a
+// request is its current one.
+func (t *DummyRectificationClient) recordDeploy(dep dummyDeploy) error {
+	current := ""
+	for _, d := range t.deployed {
+		if d.cluster == dep.cluster && d.reqID == dep.reqID {
+			current = d.depID
+		}
+	}
+	for _, d := range t.deployed {
+		if d.cluster == dep.cluster && d.reqID == dep.reqID && d.depID == dep.depID {
+			same := d.imageName == dep.imageName && d.res.Equal(dep.res) && d.e.Equal(dep.e) && d.vols.Equal(dep.vols)
+			return &DeployIDConflict{DeployID: dep.depID, SameContent: same, Current: d.depID == current}
+		}
+	}
+	dep.at = time.Now()
+	t.deployed = append(t.deployed, dep)
 	return nil
 }
 
-// PostRequest (cluster, request id, instance count)
+// DeployIncrementally implements part of IncrementalDeployer, recording the
+// deploy and its first step
+func (t *DummyRectificationClient) DeployIncrementally(
+	cluster ClusterName, depID, reqID, imageName string, res Resources, e Env, vols Volumes, instancesPerStep int) error {
+	t.logf("Deploying incrementally %s %s %s %s %d", cluster, depID, reqID, imageName, instancesPerStep)
+	t.Lock()
+	defer t.Unlock()
+	args := []interface{}{cluster, depID, reqID, imageName, res, e, vols, instancesPerStep}
+	return t.call("DeployIncrementally", args, func() error {
+		err := t.recordDeploy(dummyDeploy{cluster, depID, reqID, imageName, res, e, vols, 0, time.Time{}})
+		if err != nil {
+			return err
+		}
+		t.steps = append(t.steps, dummyStep{cluster, reqID, depID, instancesPerStep})
+		return nil
+	})
+}
+
+// AdvanceDeploy implements part of IncrementalDeployer
+func (t *DummyRectificationClient) AdvanceDeploy(cluster ClusterName, reqID, depID string, target int) error {
+	t.logf("Advancing deploy %s %s %s %d", cluster, reqID, depID, target)
+	t.Lock()
+	defer t.Unlock()
+	return t.call("AdvanceDeploy", []interface{}{cluster, reqID, depID, target}, func() error {
+		t.steps = append(t.steps, dummyStep{cluster, reqID, depID, target})
+		return nil
+	})
+}
+
+// DeployStatus implements part of IncrementalDeployer. Every step is
+// reported complete as soon as it's been requested.
+func (t *DummyRectificationClient) DeployStatus(cluster ClusterName, reqID, depID string) (DeployStatus, error) {
+	t.Lock()
+	defer t.Unlock()
+	status := DeployStatus{Pending: true, StepComplete: true}
+	err := t.call("DeployStatus", []interface{}{cluster, reqID, depID}, func() error {
+		for _, s := range t.steps {
+			if s.cluster == cluster && s.reqID == reqID && s.depID == depID {
+				status.TargetInstances = s.target
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return DeployStatus{}, err
+	}
+	return status, nil
+}
+
+// SetPendingDeploy makes PendingDeploy report depID as pending on reqID for
+// the next polls calls.
+func (t *DummyRectificationClient) SetPendingDeploy(cluster ClusterName, reqID, depID string, polls int) {
+	t.Lock()
+	defer t.Unlock()
+	t.pending = append(t.pending, dummyPending{cluster, reqID, depID, polls})
+}
+
+// PendingDeploy implements part of the RectificationClient interface
+func (t *DummyRectificationClient) PendingDeploy(cluster ClusterName, reqID string) (bool, string, error) {
+	t.Lock()
+	defer t.Unlock()
+	var pending bool
+	var depID string
+	err := t.call("PendingDeploy", []interface{}{cluster, reqID}, func() error {
+		for i := range t.pending {
+			p := &t.pending[i]
+			if p.cluster == cluster && p.reqID == reqID && p.polls > 0 {
+				p.polls--
+				pending, depID = true, p.depID
+				return nil
+			}
+		}
+		return nil
+	})
+	return pending, depID, err
+}
+
+// CancelDeploy implements DeployCanceller, recording the cancellation and
+// ending the deploy's pendency
+func (t *DummyRectificationClient) CancelDeploy(cluster ClusterName, reqID, depID string) error {
+	t.logf("Cancelling deploy %s %s %s", cluster, reqID, depID)
+	t.Lock()
+	defer t.Unlock()
+	return t.call("CancelDeploy", []interface{}{cluster, reqID, depID}, func() error {
+		for i := range t.pending {
+			p := &t.pending[i]
+			if p.cluster == cluster && p.reqID == reqID && p.depID == depID {
+				p.polls = 0
+			}
+		}
+		t.cancelled = append(t.cancelled, dummyPending{cluster, reqID, depID, 0})
+		return nil
+	})
+}
+
+// PostRequest (cluster, request id, instance count). Posting a request
+// again replaces it.
 func (t *DummyRectificationClient) PostRequest(
-	cluster, id string, count int) error {
+	cluster ClusterName, id string, count int) error {
 	t.logf("Creating application %s %s %d", cluster, id, count)
-	t.created = append(t.created, dummyRequest{cluster, id, count})
-	return nil
+	t.Lock()
+	defer t.Unlock()
+	return t.call("PostRequest", []interface{}{cluster, id, count}, func() error {
+		t.post(dummyRequest{cluster: cluster, id: id, count: count})
+		return nil
+	})
+}
+
+// PostRequestOfKind (cluster, request id, instance count, kind, schedule)
+// implements KindPoster. Posting a request again replaces it.
+func (t *DummyRectificationClient) PostRequestOfKind(
+	cluster ClusterName, id string, count int, kind ManifestKind, schedule string) error {
+	t.logf("Creating application %s %s %d %s", cluster, id, count, kind)
+	t.Lock()
+	defer t.Unlock()
+	return t.call("PostRequestOfKind", []interface{}{cluster, id, count, kind, schedule}, func() error {
+		t.post(dummyRequest{cluster: cluster, id: id, count: count, kind: kind, schedule: schedule})
+		return nil
+	})
+}
+
+// post records req, replacing any request of the same ID.
+func (t *DummyRectificationClient) post(req dummyRequest) {
+	created := t.created[:0]
+	for _, r := range t.created {
+		if r.cluster != req.cluster || r.id != req.id {
+			created = append(created, r)
+		}
+	}
+	t.created = append(created, req)
 }
 
 //Scale (cluster url, request id, instance count, message)
 func (t *DummyRectificationClient) Scale(
-	cluster, reqid string, count int, message string) error {
+	cluster ClusterName, reqid string, count int, message string) error {
 	t.logf("Scaling %s %s %d %s", cluster, reqid, count, message)
-	t.scaled = append(t.scaled, dummyScale{cluster, reqid, count, message})
-	return nil
+	t.Lock()
+	defer t.Unlock()
+	return t.call("Scale", []interface{}{cluster, reqid, count, message}, func() error {
+		t.scaled = append(t.scaled, dummyScale{cluster, reqid, count, message})
+		return nil
+	})
 }
 
 // DeleteRequest (cluster url, request id, instance count, message)
 func (t *DummyRectificationClient) DeleteRequest(
-	cluster, reqid, message string) error {
+	cluster ClusterName, reqid, message string) error {
 	t.logf("Deleting application %s %s %s", cluster, reqid, message)
-	t.deleted = append(t.deleted, dummyDelete{cluster, reqid, message})
-	return nil
+	t.Lock()
+	defer t.Unlock()
+	return t.call("DeleteRequest", []interface{}{cluster, reqid, message}, func() error {
+		t.deleted = append(t.deleted, dummyDelete{cluster, reqid, message})
+		return nil
+	})
 }
 
-//ImageName finds or guesses a docker image name for a Deployment
+//ImageName finds or guesses a docker image name for a Deployment: the one
+// set with SetImageName, if any, or else the one its ImageMapper gives.
 func (t *DummyRectificationClient) ImageName(d *Deployment) (string, error) {
-	return t.nameCache.GetImageName(d.SourceVersion)
+	t.Lock()
+	defer t.Unlock()
+	var name string
+	err := t.call("ImageName", []interface{}{d}, func() error {
+		if n, ok := t.imageNames[d.SourceVersion]; ok {
+			name = n
+		} else {
+			var err error
+			if name, err = t.nameCache.GetImageName(d.SourceVersion); err != nil {
+				return err
+			}
+		}
+		if t.images == nil {
+			t.images = map[string]SourceVersion{}
+		}
+		t.images[name] = d.SourceVersion
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return name, nil
 }
 
-// ImageLabels gets the labels for an image name
-func (t *DummyRectificationClient) ImageLabels(in string) (map[string]string, error) {
-	sv, err := t.nameCache.GetSourceVersion(in)
+// RunningDeployments reconstructs the deployments on a cluster from the
+// requests, deploys, scales and deletes the client has recorded. Images that
+// weren't named by ImageName are reported as foreign.
+func (t *DummyRectificationClient) RunningDeployments(cluster ClusterName) (Deployments, error) {
+	t.Lock()
+	defer t.Unlock()
+	if err := t.call("RunningDeployments", []interface{}{cluster}, func() error { return nil }); err != nil {
+		return nil, err
+	}
+
+	deps := Deployments{}
+	for _, req := range t.created {
+		if req.cluster != cluster || t.wasDeleted(cluster, req.id) {
+			continue
+		}
+		deps = append(deps, t.running(req))
+	}
+	return deps, nil
+}
+
+// InspectRequest implements RequestInspector, reconstructing the deployment
+// on the request reqID as RunningDeployments does.
+func (t *DummyRectificationClient) InspectRequest(cluster ClusterName, reqID string) (*Deployment, error) {
+	t.Lock()
+	defer t.Unlock()
+	var d *Deployment
+	err := t.call("InspectRequest", []interface{}{cluster, reqID}, func() error {
+		for _, req := range t.created {
+			if req.cluster == cluster && req.id == reqID && !t.wasDeleted(cluster, reqID) {
+				d = t.running(req)
+			}
+		}
+		return nil
+	})
+	return d, err
+}
+
+// DeployHistory implements part of RectificationClient, listing the deploys
+// recorded on the request reqID, newest first. Every deploy succeeded. As in
+// RunningDeployments, images that weren't named by ImageName are unresolved.
+func (t *DummyRectificationClient) DeployHistory(cluster ClusterName, reqID string, count int) ([]DeployRecord, error) {
+	t.Lock()
+	defer t.Unlock()
+	var rs []DeployRecord
+	err := t.call("DeployHistory", []interface{}{cluster, reqID, count}, func() error {
+		rs = []DeployRecord{}
+		for i := len(t.deployed) - 1; i >= 0 && len(rs) < count; i-- {
+			dep := t.deployed[i]
+			if dep.cluster != cluster || dep.reqID != reqID {
+				continue
+			}
+			r := DeployRecord{
+				Cluster: cluster, RequestID: reqID, DeployID: dep.depID,
+				Time: dep.at, Result: "SUCCEEDED", ImageName: dep.imageName,
+			}
+			if sv, ok := t.images[dep.imageName]; ok {
+				r.SourceVersion = &sv
+			}
+			rs = append(rs, r)
+		}
+		return nil
+	})
 	if err != nil {
-		return map[string]string{}, nil
+		return nil, err
 	}
+	return rs, nil
+}
 
-	return sv.DockerLabels(), nil
+// running reconstructs the deployment on req. It must be called with t
+// locked.
+func (t *DummyRectificationClient) running(req dummyRequest) *Deployment {
+	cluster := req.cluster
+	d := &Deployment{Cluster: cluster, Annotation: Annotation{RequestID: req.id}}
+	d.NumInstances = req.count
+	d.Kind, d.Schedule = req.kind, req.schedule
+	for _, s := range t.scaled {
+		if s.cluster == cluster && s.reqid == req.id {
+			d.NumInstances = s.count
+		}
+	}
+	for _, dep := range t.deployed {
+		if dep.cluster != cluster || dep.reqID != req.id {
+			continue
+		}
+		d.Resources, d.Env, d.DeployConfig.Volumes = dep.res, dep.e, dep.vols
+		if dep.instances > 0 {
+			d.NumInstances = dep.instances
+		}
+		if sv, ok := t.images[dep.imageName]; ok {
+			d.SourceVersion, d.ForeignImage = sv, ""
+		} else {
+			d.SourceVersion, d.ForeignImage = SourceVersion{}, dep.imageName
+		}
+	}
+	return d
+}
+
+func (t *DummyRectificationClient) wasDeleted(cluster ClusterName, reqID string) bool {
+	for _, d := range t.deleted {
+		if d.cluster == cluster && d.reqid == reqID {
+			return true
+		}
+	}
+	return false
+}
+
+// ImageLabels gets the labels for an image name: those of the source
+// version ImageName named it for, or else those its ImageMapper gives.
+func (t *DummyRectificationClient) ImageLabels(in string) (map[string]string, error) {
+	t.Lock()
+	defer t.Unlock()
+	labels := map[string]string{}
+	err := t.call("ImageLabels", []interface{}{in}, func() error {
+		if sv, ok := t.images[in]; ok {
+			labels = sv.DockerLabels()
+			return nil
+		}
+		ls, err := t.nameCache.GetLabels(in)
+		if err != nil {
+			return err
+		}
+		labels = ls
+		return nil
+	})
+	if err != nil {
+		return map[string]string{}, err
+	}
+	return labels, nil
 }
 
 // NewDummyNameCache builds a new DummyNameCache
@@ -142,3 +620,29 @@ func (dc *DummyNameCache) Insert(sv SourceVersion, in, etag string) error {
 func (dc *DummyNameCache) GetSourceVersion(in string) (SourceVersion, error) {
 	return SourceVersion{}, nil
 }
+
+// GetLabels implements part of ImageMapper
+// It returns the labels of an empty source version
+func (dc *DummyNameCache) GetLabels(in string) (map[string]string, error) {
+	sv := SourceVersion{}
+	return sv.DockerLabels(), nil
+}
+
+// Ping implements part of ImageMapper
+// It only fails once the cache is closed
+func (dc *DummyNameCache) Ping(ctx context.Context) error {
+	dc.Lock()
+	defer dc.Unlock()
+	if dc.closed {
+		return ErrClosed{}
+	}
+	return nil
+}
+
+// Close implements part of ImageMapper
+func (dc *DummyNameCache) Close() error {
+	dc.Lock()
+	defer dc.Unlock()
+	dc.closed = true
+	return nil
+}
@@ -1,6 +1,7 @@
 package cmdr
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
@@ -31,6 +32,9 @@ type (
 		// output when Output.Indent() is called inside a command. If left
 		// empty, defaults to DefaultIndentString.
 		IndentString string
+		// JSONErrors makes Invoke write error results to Err as JSON objects,
+		// for the benefit of programs reading sous's output.
+		JSONErrors bool
 	}
 	// Hooks is a collection of command hooks. If a hook returns a non-nil error
 	// it cancels execution and the error is displayed to the user.
@@ -104,10 +108,30 @@ func (c *CLI) handleSuccessResult(s SuccessResult) {
 }
 
 func (c *CLI) handleErrorResult(e ErrorResult) {
+	if c.JSONErrors {
+		c.printJSONError(e)
+		return
+	}
 	c.Err.Println(e)
 	c.printTip(e.UserTip())
 }
 
+// jsonError is how an ErrorResult is written when JSONErrors is set.
+type jsonError struct {
+	Error    string `json:"error"`
+	Tip      string `json:"tip,omitempty"`
+	ExitCode int    `json:"exitCode"`
+}
+
+func (c *CLI) printJSONError(e ErrorResult) {
+	b, err := json.Marshal(jsonError{e.Error(), e.UserTip(), e.ExitCode()})
+	if err != nil {
+		c.Err.Println(e)
+		return
+	}
+	c.Err.Write(append(b, '\n'))
+}
+
 func (c *CLI) printTip(tip string) {
 	if tip == "" {
 		return
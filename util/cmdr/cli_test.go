@@ -60,6 +60,40 @@ func TestCli(t *testing.T) {
 
 }
 
+type FailingCommand struct{}
+
+func (fc *FailingCommand) Help() string { return "" }
+
+func (fc *FailingCommand) Execute(args []string) Result {
+	err := UsageErrorf("bad args: %v", args)
+	err.Tip = "try harder"
+	return err
+}
+
+func TestCli_JSONErrors(t *testing.T) {
+	outBuf := &bytes.Buffer{}
+	errBuf := &bytes.Buffer{}
+
+	c := &CLI{
+		Root:       &FailingCommand{},
+		Out:        NewOutput(outBuf),
+		Err:        NewOutput(errBuf),
+		JSONErrors: true,
+	}
+
+	result := c.Invoke(makeArgs("a-command x"))
+	if result.ExitCode() != EX_USAGE {
+		t.Errorf("got exit code %d; want %d", result.ExitCode(), EX_USAGE)
+	}
+	expected := `{"error":"bad args: [x]","tip":"try harder","exitCode":64}` + "\n"
+	if errBuf.String() != expected {
+		t.Errorf("got %q; want %q", errBuf, expected)
+	}
+	if outBuf.Len() != 0 {
+		t.Errorf("unexpected write to stdout: %q", outBuf)
+	}
+}
+
 func makeArgs(s string) []string {
 	return strings.Split(s, " ")
 }
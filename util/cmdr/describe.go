@@ -0,0 +1,84 @@
+package cmdr
+
+import "flag"
+
+type (
+	// CommandInfo describes a command, its flags and its subcommands, for
+	// tools such as shell completion that need to know what can be typed.
+	CommandInfo struct {
+		// Name is the name the command is invoked by.
+		Name string
+		// Short is the first line of the command's help.
+		Short string
+		// Flags are all the flags the command accepts, including those
+		// forwarded from its parents, sorted by name.
+		Flags []FlagInfo
+		// Subcommands are the command's subcommands, sorted by name.
+		Subcommands []CommandInfo
+	}
+	// FlagInfo describes a single flag.
+	FlagInfo struct {
+		Name, Usage, Default string
+		// IsBool is true for flags that take no value.
+		IsBool bool
+	}
+)
+
+// Describe returns a description of the command c, invoked as name, and all
+// its subcommands.
+func Describe(name string, c Command) CommandInfo {
+	return describe(name, c, nil)
+}
+
+func describe(name string, c Command, ff []func(*flag.FlagSet)) CommandInfo {
+	info := CommandInfo{Name: name, Short: ParseHelp(c.Help()).Short}
+	if command, ok := c.(AddsFlags); ok {
+		ff = append(ff[:len(ff):len(ff)], command.AddFlags)
+	}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	for _, addFlags := range ff {
+		addFlags(fs)
+	}
+	// VisitAll visits flags in lexical order.
+	fs.VisitAll(func(f *flag.Flag) {
+		b, ok := f.Value.(interface {
+			IsBoolFlag() bool
+		})
+		info.Flags = append(info.Flags, FlagInfo{
+			Name:    f.Name,
+			Usage:   f.Usage,
+			Default: f.DefValue,
+			IsBool:  ok && b.IsBoolFlag(),
+		})
+	})
+	if command, ok := c.(Subcommander); ok {
+		cs := command.Subcommands()
+		for _, sub := range cs.SortedKeys() {
+			info.Subcommands = append(info.Subcommands, describe(sub, cs[sub], ff))
+		}
+	}
+	return info
+}
+
+// Walk calls f for c and each of its subcommands, depth first, with the
+// names of the commands leading to each, starting with c's own.
+func (c CommandInfo) Walk(f func(path []string, c CommandInfo)) {
+	c.walk(nil, f)
+}
+
+func (c CommandInfo) walk(path []string, f func([]string, CommandInfo)) {
+	path = append(path[:len(path):len(path)], c.Name)
+	f(path, c)
+	for _, sub := range c.Subcommands {
+		sub.walk(path, f)
+	}
+}
+
+// FlagNames returns the names of c's flags.
+func (c CommandInfo) FlagNames() []string {
+	names := make([]string, len(c.Flags))
+	for i, f := range c.Flags {
+		names[i] = f.Name
+	}
+	return names
+}
@@ -0,0 +1,57 @@
+package cmdr
+
+import (
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type describeRoot struct{ verbose bool }
+
+func (*describeRoot) Help() string { return "the root\n\nargs: <command>\n" }
+
+func (r *describeRoot) AddFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&r.verbose, "v", false, "be verbose")
+}
+
+func (*describeRoot) Subcommands() Commands {
+	return Commands{"sub": &describeSub{}, "plain": &TestCommand{}}
+}
+
+type describeSub struct{ name string }
+
+func (*describeSub) Help() string { return "a subcommand\n" }
+
+func (s *describeSub) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&s.name, "name", "x", "a name")
+}
+
+func TestDescribe(t *testing.T) {
+	info := Describe("root", &describeRoot{})
+	if info.Short != "the root" {
+		t.Errorf("got short help %q", info.Short)
+	}
+	if len(info.Subcommands) != 2 {
+		t.Fatalf("got %d subcommands; want 2", len(info.Subcommands))
+	}
+	sub := info.Subcommands[1]
+	want := []FlagInfo{
+		{Name: "name", Usage: "a name", Default: "x"},
+		{Name: "v", Usage: "be verbose", Default: "false", IsBool: true},
+	}
+	if sub.Name != "sub" || !reflect.DeepEqual(sub.Flags, want) {
+		t.Errorf("got %+v; want flags %+v", sub, want)
+	}
+	if got := info.Subcommands[0].FlagNames(); !reflect.DeepEqual(got, []string{"v"}) {
+		t.Errorf("plain inherits flags %v; want [v]", got)
+	}
+
+	paths := []string{}
+	info.Walk(func(path []string, c CommandInfo) {
+		paths = append(paths, strings.Join(path, " "))
+	})
+	if want := []string{"root", "root plain", "root sub"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("walked %v; want %v", paths, want)
+	}
+}
@@ -39,6 +39,13 @@ type (
 	// IOErr signifies that something went wrong with io, to files, or across
 	// the network, for example.
 	IOErr struct{ *cliErr }
+	// DataErr signifies that input was read successfully, but was not valid,
+	// for example a file with a syntax error.
+	DataErr struct{ *cliErr }
+	// FailureErr signifies that the command worked, but its answer was
+	// negative, like grep finding no matches. It exits with code 1, so that
+	// scripts can test for it.
+	FailureErr struct{ *cliErr }
 	// UnknownErr is the error of last resort, only to be used if none of the
 	// other error types is applicable.
 	UnknownErr struct{ *cliErr }
@@ -78,6 +85,14 @@ func IOErrorf(format string, v ...interface{}) IOErr {
 	return IOErr{newError(format, v...)}
 }
 
+func DataErrorf(format string, v ...interface{}) DataErr {
+	return DataErr{newError(format, v...)}
+}
+
+func FailureErrorf(format string, v ...interface{}) FailureErr {
+	return FailureErr{newError(format, v...)}
+}
+
 func UnknownErrorf(format string, v ...interface{}) UnknownErr {
 	return UnknownErr{newError(format, v...)}
 }
@@ -86,6 +101,8 @@ func (e InternalErr) ExitCode() int { return EX_SOFTWARE }
 func (e UsageErr) ExitCode() int    { return EX_USAGE }
 func (e OSErr) ExitCode() int       { return EX_OSERR }
 func (e IOErr) ExitCode() int       { return EX_IOERR }
+func (e DataErr) ExitCode() int     { return EX_DATAERR }
+func (e FailureErr) ExitCode() int  { return 1 }
 func (e UnknownErr) ExitCode() int  { return 255 }
 func (e *cliErr) ExitCode() int     { return 255 }
 
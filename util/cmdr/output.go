@@ -64,6 +64,25 @@ func NewOutput(w io.Writer, configFunc ...func(*Output)) *Output {
 	return out
 }
 
+// IsTerminal reports whether o is connected to a terminal.
+func (o *Output) IsTerminal() bool {
+	return o.isTerm
+}
+
+// TerminalWidth returns the width in columns of the terminal o is connected
+// to, or zero if it isn't connected to one, or the width can't be read.
+func (o *Output) TerminalWidth() int {
+	file, isFile := o.writer.(*os.File)
+	if !o.isTerm || !isFile {
+		return 0
+	}
+	width, _, err := terminal.GetSize(int(file.Fd()))
+	if err != nil {
+		return 0
+	}
+	return width
+}
+
 func (o *Output) PushStyle(s style.Style) {
 	o.styleStack = append(o.styleStack, o.Style)
 	o.Style = s
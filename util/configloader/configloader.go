@@ -97,6 +97,12 @@ func (cl ConfigLoader) SetValue(target interface{}, name, value string) error {
 				return err
 			}
 			val.Set(reflect.ValueOf(v))
+		case reflect.Bool:
+			v, err := strconv.ParseBool(value)
+			if err != nil {
+				return err
+			}
+			val.Set(reflect.ValueOf(v))
 		}
 		return nil
 	})
@@ -123,6 +129,12 @@ func (cl ConfigLoader) overrideField(sf reflect.StructField, originalVal reflect
 			return err
 		}
 		finalVal = reflect.ValueOf(i)
+	case bool:
+		b, err := strconv.ParseBool(envStr)
+		if err != nil {
+			return err
+		}
+		finalVal = reflect.ValueOf(b)
 	}
 	originalVal.Set(finalVal)
 	return nil
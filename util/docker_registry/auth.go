@@ -0,0 +1,390 @@
+package docker_registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/registry/client"
+)
+
+type (
+	// authTransport authenticates requests to registries that don't allow
+	// anonymous pulls. When a request is refused with a 401, it answers the
+	// challenge in its WWW-Authenticate header, either with the credentials
+	// for the registry or with a bearer token got for them from the
+	// registry's token service, and tries the request once more.
+	authTransport struct {
+		base   http.RoundTripper
+		creds  *credentialStore
+		tokens *tokenCache
+	}
+
+	challenge struct {
+		scheme string
+		params map[string]string
+	}
+
+	token struct {
+		value   string
+		expires time.Time
+	}
+
+	// tokenCache holds the bearer tokens for each registry host and
+	// repository, and makes sure only one request for each is made to the
+	// token service at a time.
+	tokenCache struct {
+		sync.Mutex
+		tokens     map[string]token
+		fetching   map[string]*tokenFetch
+		challenges map[string]challenge
+		basicHosts map[string]bool
+	}
+
+	tokenFetch struct {
+		done chan struct{}
+		tok  token
+		err  error
+	}
+
+	tokenResponse struct {
+		Token       string    `json:"token"`
+		AccessToken string    `json:"access_token"`
+		ExpiresIn   int       `json:"expires_in"`
+		IssuedAt    time.Time `json:"issued_at"`
+	}
+)
+
+// defaultTokenLifetime is how long a token lasts if the token service
+// doesn't say, as the spec has it.
+const defaultTokenLifetime = 60 * time.Second
+
+// tokenExpiryMargin is how long before they expire tokens are refreshed, so
+// that they don't expire in flight.
+const tokenExpiryMargin = 5 * time.Second
+
+func newAuthTransport(base http.RoundTripper, creds *credentialStore, tokens *tokenCache) *authTransport {
+	return &authTransport{base: base, creds: creds, tokens: tokens}
+}
+
+func newTokenCache() *tokenCache {
+	return &tokenCache{
+		tokens:     map[string]token{},
+		fetching:   map[string]*tokenFetch{},
+		challenges: map[string]challenge{},
+		basicHosts: map[string]bool{},
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	scope := repositoryScope(req.URL.Path)
+	key := host + " " + scope
+
+	r := req.Clone(req.Context())
+	sent := ""
+	if tok, ok := t.tokens.get(key); ok {
+		sent = tok.value
+		r.Header.Set("Authorization", "Bearer "+sent)
+	} else if ch, ok := t.tokens.challenge(key); ok {
+		// The token has expired, so get a new one before it's refused.
+		tok, err := t.bearerToken(ch, key, host, scope, "")
+		if err != nil {
+			return nil, err
+		}
+		sent = tok.value
+		r.Header.Set("Authorization", "Bearer "+sent)
+	} else if t.tokens.isBasic(host) {
+		if err := t.setBasicAuth(r, host); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := t.base.RoundTrip(r)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	// Only requests that can be sent again are retried, and only once.
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+	ch, ok := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+	if !ok {
+		return resp, nil
+	}
+
+	r = req.Clone(req.Context())
+	if req.Body != nil {
+		if r.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	switch ch.scheme {
+	default:
+		return resp, nil
+	case "basic":
+		t.tokens.setBasic(host)
+		if err := t.setBasicAuth(r, host); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		if r.Header.Get("Authorization") == "" {
+			return resp, nil
+		}
+	case "bearer":
+		t.tokens.setChallenge(key, ch)
+		tok, err := t.bearerToken(ch, key, host, scope, sent)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		r.Header.Set("Authorization", "Bearer "+tok.value)
+	}
+	resp.Body.Close()
+	return t.base.RoundTrip(r)
+}
+
+// bearerToken returns a token for key in place of stale, fetching it from
+// the token service named by ch if need be.
+func (t *authTransport) bearerToken(ch challenge, key, host, scope, stale string) (token, error) {
+	if s := ch.params["scope"]; s != "" {
+		scope = s
+	}
+	return t.tokens.refresh(key, stale, func() (token, error) {
+		return t.fetchToken(ch, host, scope)
+	})
+}
+
+// setBasicAuth adds the credentials for host to r, if there are any.
+func (t *authTransport) setBasicAuth(r *http.Request, host string) error {
+	creds, ok, err := t.creds.credentials(host)
+	if err != nil || !ok {
+		return err
+	}
+	r.SetBasicAuth(creds.Username, creds.Password)
+	return nil
+}
+
+// fetchToken gets a bearer token for scope on host from the token service
+// named by ch, using the credentials for host if there are any.
+func (t *authTransport) fetchToken(ch challenge, host, scope string) (token, error) {
+	realm := ch.params["realm"]
+	if realm == "" {
+		return token{}, fmt.Errorf("registry %s asked for a bearer token without saying where to get one", host)
+	}
+	u, err := url.Parse(realm)
+	if err != nil {
+		return token{}, err
+	}
+	creds, haveCreds, err := t.creds.credentials(host)
+	if err != nil {
+		return token{}, err
+	}
+
+	var req *http.Request
+	if haveCreds && creds.IdentityToken != "" {
+		form := url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {creds.IdentityToken},
+			"service":       {ch.params["service"]},
+			"client_id":     {"sous"},
+		}
+		if scope != "" {
+			form.Set("scope", scope)
+		}
+		req, err = http.NewRequest("POST", u.String(), strings.NewReader(form.Encode()))
+		if err != nil {
+			return token{}, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		q := u.Query()
+		if s := ch.params["service"]; s != "" {
+			q.Set("service", s)
+		}
+		if scope != "" {
+			q.Set("scope", scope)
+		}
+		u.RawQuery = q.Encode()
+		req, err = http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			return token{}, err
+		}
+		if haveCreds {
+			req.SetBasicAuth(creds.Username, creds.Password)
+		}
+	}
+
+	resp, err := (&http.Client{Transport: t.base}).Do(req)
+	if err != nil {
+		return token{}, err
+	}
+	defer resp.Body.Close()
+	if !client.SuccessStatus(resp.StatusCode) {
+		return token{}, fmt.Errorf("unable to get a token for %s from %s: %s", host, realm, resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return token{}, err
+	}
+	var tr tokenResponse
+	if err := json.Unmarshal(b, &tr); err != nil {
+		return token{}, err
+	}
+
+	tok := token{value: tr.Token}
+	if tok.value == "" {
+		tok.value = tr.AccessToken
+	}
+	if tok.value == "" {
+		return token{}, fmt.Errorf("token service %s returned no token for %s", realm, host)
+	}
+	lifetime := defaultTokenLifetime
+	if tr.ExpiresIn > 0 {
+		lifetime = time.Duration(tr.ExpiresIn) * time.Second
+	}
+	issued := tr.IssuedAt
+	if issued.IsZero() {
+		issued = time.Now()
+	}
+	tok.expires = issued.Add(lifetime - tokenExpiryMargin)
+	return tok, nil
+}
+
+// get returns the unexpired token for key, if there is one.
+func (tc *tokenCache) get(key string) (token, bool) {
+	tc.Lock()
+	defer tc.Unlock()
+	tok, ok := tc.tokens[key]
+	if !ok || time.Now().After(tok.expires) {
+		return token{}, false
+	}
+	return tok, true
+}
+
+// refresh returns a new token for key in place of stale, which was refused,
+// either by calling fetch or by waiting for a call already under way. If
+// another request has replaced stale already, that token is returned.
+func (tc *tokenCache) refresh(key, stale string, fetch func() (token, error)) (token, error) {
+	tc.Lock()
+	if tok, ok := tc.tokens[key]; ok && tok.value != stale && time.Now().Before(tok.expires) {
+		tc.Unlock()
+		return tok, nil
+	}
+	if f, ok := tc.fetching[key]; ok {
+		tc.Unlock()
+		<-f.done
+		return f.tok, f.err
+	}
+	f := &tokenFetch{done: make(chan struct{})}
+	tc.fetching[key] = f
+	tc.Unlock()
+
+	f.tok, f.err = fetch()
+
+	tc.Lock()
+	delete(tc.fetching, key)
+	if f.err == nil {
+		tc.tokens[key] = f.tok
+	}
+	tc.Unlock()
+	close(f.done)
+	return f.tok, f.err
+}
+
+// challenge returns the bearer challenge last seen for key, so that its
+// token can be refreshed when it expires.
+func (tc *tokenCache) challenge(key string) (challenge, bool) {
+	tc.Lock()
+	defer tc.Unlock()
+	ch, ok := tc.challenges[key]
+	return ch, ok
+}
+
+func (tc *tokenCache) setChallenge(key string, ch challenge) {
+	tc.Lock()
+	defer tc.Unlock()
+	tc.challenges[key] = ch
+}
+
+func (tc *tokenCache) isBasic(host string) bool {
+	tc.Lock()
+	defer tc.Unlock()
+	return tc.basicHosts[host]
+}
+
+func (tc *tokenCache) setBasic(host string) {
+	tc.Lock()
+	defer tc.Unlock()
+	tc.basicHosts[host] = true
+}
+
+// repositoryScope is the token scope needed to pull from the repository
+// that path, a registry API path, is in, or "" if it isn't in one.
+func repositoryScope(path string) string {
+	path = strings.TrimPrefix(path, "/v2/")
+	for _, sep := range []string{"/manifests/", "/blobs/", "/tags/"} {
+		if i := strings.LastIndex(path, sep); i > 0 {
+			return "repository:" + path[:i] + ":pull"
+		}
+	}
+	return ""
+}
+
+// parseChallenge parses a WWW-Authenticate header, e.g.
+//
+//	Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+//
+// The scheme is lowercased.
+func parseChallenge(header string) (challenge, bool) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return challenge{}, false
+	}
+	i := strings.IndexAny(header, " \t")
+	ch := challenge{params: map[string]string{}}
+	if i < 0 {
+		ch.scheme = strings.ToLower(header)
+		return ch, true
+	}
+	ch.scheme = strings.ToLower(header[:i])
+	rest := header[i:]
+	for {
+		rest = strings.TrimLeft(rest, " \t,")
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			return ch, true
+		}
+		name := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = strings.TrimLeft(rest[eq+1:], " \t")
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := 1
+			for end < len(rest) && rest[end] != '"' {
+				if rest[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(rest) {
+				return challenge{}, false
+			}
+			value = strings.Replace(rest[1:end], `\`, "", -1)
+			rest = rest[end+1:]
+		} else {
+			end := strings.Index(rest, ",")
+			if end < 0 {
+				end = len(rest)
+			}
+			value = strings.TrimSpace(rest[:end])
+			rest = rest[end:]
+		}
+		ch.params[name] = value
+	}
+}
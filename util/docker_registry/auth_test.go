@@ -0,0 +1,257 @@
+package docker_registry
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testAuthRegistry serves tags for example/repo to requests bearing the
+// token its token service gives out for user:secret.
+type testAuthRegistry struct {
+	*httptest.Server
+	tokenRequests, requests int32
+	expiresIn               int
+	// gate, if set, holds token requests until it's closed.
+	gate chan struct{}
+}
+
+func newTestAuthRegistry(t *testing.T) *testAuthRegistry {
+	reg := &testAuthRegistry{expiresIn: 300}
+	reg.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/token" {
+			atomic.AddInt32(&reg.tokenRequests, 1)
+			if reg.gate != nil {
+				<-reg.gate
+			}
+			if u, p, _ := req.BasicAuth(); u != "user" || p != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if got := req.URL.Query().Get("scope"); got != "repository:example/repo:pull" {
+				t.Errorf("got scope %q", got)
+			}
+			fmt.Fprintf(w, `{"token": "tok%d", "expires_in": %d}`,
+				atomic.LoadInt32(&reg.tokenRequests), reg.expiresIn)
+			return
+		}
+		atomic.AddInt32(&reg.requests, 1)
+		if req.Header.Get("Authorization") == "" || req.Header.Get("Authorization") == "Bearer revoked" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+				`Bearer realm="%s/token",service="test",scope="repository:example/repo:pull"`,
+				reg.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"tags": ["1.0.0"]}`)
+	}))
+	return reg
+}
+
+func (reg *testAuthRegistry) get(t *testing.T, c *http.Client) int {
+	resp, err := c.Get(reg.URL + "/v2/example/repo/tags/list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func testAuthClient(reg *testAuthRegistry, creds Credentials) (*http.Client, *authTransport) {
+	cs := newCredentialStore()
+	cs.configPath = "/nonexistent/config.json"
+	cs.set(reg.Listener.Addr().String(), creds)
+	at := newAuthTransport(&http.Transport{}, cs, newTokenCache())
+	return &http.Client{Transport: at}, at
+}
+
+func TestAuthTransport_BearerToken(t *testing.T) {
+	reg := newTestAuthRegistry(t)
+	defer reg.Close()
+	c, _ := testAuthClient(reg, Credentials{Username: "user", Password: "secret"})
+
+	for i := 0; i < 3; i++ {
+		if status := reg.get(t, c); status != http.StatusOK {
+			t.Fatalf("got status %d", status)
+		}
+	}
+	if reg.tokenRequests != 1 {
+		t.Errorf("got %d token requests; want the token to be cached", reg.tokenRequests)
+	}
+	if reg.requests != 4 {
+		t.Errorf("got %d requests; want one refused, then 3 with the token", reg.requests)
+	}
+}
+
+func TestAuthTransport_SingleFlight(t *testing.T) {
+	reg := newTestAuthRegistry(t)
+	defer reg.Close()
+	reg.gate = make(chan struct{})
+	c, _ := testAuthClient(reg, Credentials{Username: "user", Password: "secret"})
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if status := reg.get(t, c); status != http.StatusOK {
+				t.Errorf("got status %d", status)
+			}
+		}()
+	}
+	// Give the requests time to be refused and queue for the token.
+	time.Sleep(50 * time.Millisecond)
+	close(reg.gate)
+	wg.Wait()
+	if reg.tokenRequests != 1 {
+		t.Errorf("got %d token requests; want 1", reg.tokenRequests)
+	}
+}
+
+func TestAuthTransport_RetriesOnce(t *testing.T) {
+	reg := newTestAuthRegistry(t)
+	defer reg.Close()
+	c, at := testAuthClient(reg, Credentials{Username: "user", Password: "secret"})
+	reg.get(t, c)
+
+	// A token the registry no longer accepts is replaced.
+	key := reg.Listener.Addr().String() + " repository:example/repo:pull"
+	at.tokens.tokens[key] = token{value: "revoked", expires: time.Now().Add(time.Hour)}
+	reg.requests = 0
+	if status := reg.get(t, c); status != http.StatusOK {
+		t.Fatalf("got status %d", status)
+	}
+	if reg.requests != 2 || reg.tokenRequests != 2 {
+		t.Errorf("got %d requests, %d token requests; want 2 of each", reg.requests, reg.tokenRequests)
+	}
+
+	// But bad credentials aren't tried forever.
+	c, _ = testAuthClient(reg, Credentials{Username: "user", Password: "wrong"})
+	reg.requests = 0
+	resp, err := c.Get(reg.URL + "/v2/example/repo/tags/list")
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("expected an error getting a token with bad credentials")
+	}
+	if reg.requests != 1 {
+		t.Errorf("got %d requests; want 1", reg.requests)
+	}
+}
+
+func TestAuthTransport_Expiry(t *testing.T) {
+	reg := newTestAuthRegistry(t)
+	defer reg.Close()
+	// Tokens that last no longer than the expiry margin are already stale.
+	reg.expiresIn = 1
+	c, _ := testAuthClient(reg, Credentials{Username: "user", Password: "secret"})
+
+	reg.get(t, c)
+	reg.requests = 0
+	if status := reg.get(t, c); status != http.StatusOK {
+		t.Fatalf("got status %d", status)
+	}
+	if reg.tokenRequests != 2 || reg.requests != 1 {
+		t.Errorf("got %d token requests, %d requests; want the token refreshed before the request",
+			reg.tokenRequests, reg.requests)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	ch, ok := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry",scope="repository:a/b:pull,push"`)
+	if !ok || ch.scheme != "bearer" {
+		t.Fatalf("got %v, %t", ch, ok)
+	}
+	if ch.params["realm"] != "https://auth.example.com/token" ||
+		ch.params["service"] != "registry" ||
+		ch.params["scope"] != "repository:a/b:pull,push" {
+		t.Errorf("got params %v", ch.params)
+	}
+	if ch, ok := parseChallenge(`Basic realm=registry`); !ok || ch.scheme != "basic" || ch.params["realm"] != "registry" {
+		t.Errorf("got %v, %t", ch, ok)
+	}
+	if _, ok := parseChallenge(""); ok {
+		t.Error("parsed an empty challenge")
+	}
+}
+
+func writeTestDockerConfig(t *testing.T, config string) (string, func()) {
+	dir, err := ioutil.TempDir("", "docker-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestCredentialStore_DockerConfig(t *testing.T) {
+	auth := base64.StdEncoding.EncodeToString([]byte("user:pa:ss"))
+	path, done := writeTestDockerConfig(t, fmt.Sprintf(`{"auths": {
+		"https://registry.example.com/v1/": {"auth": %q},
+		"tokens.example.com": {"identitytoken": "refresh"}
+	}}`, auth))
+	defer done()
+	cs := newCredentialStore()
+	cs.configPath = path
+
+	creds, ok, err := cs.credentials("registry.example.com")
+	if err != nil || !ok || creds.Username != "user" || creds.Password != "pa:ss" {
+		t.Errorf("got %v, %t, %v", creds, ok, err)
+	}
+	if creds, ok, _ := cs.credentials("tokens.example.com"); !ok || creds.IdentityToken != "refresh" {
+		t.Errorf("got %v, %t; want the identity token", creds, ok)
+	}
+	if _, ok, err := cs.credentials("other.example.com"); ok || err != nil {
+		t.Errorf("got credentials for an unknown host: %t, %v", ok, err)
+	}
+
+	cs.set("registry.example.com", Credentials{Username: "override"})
+	if creds, _, _ := cs.credentials("registry.example.com"); creds.Username != "override" {
+		t.Errorf("got %v; want the credentials set", creds)
+	}
+}
+
+func TestCredentialStore_Helper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credential-helper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	helper := `#!/bin/sh
+read host
+if [ "$host" = registry.example.com ]; then
+	echo '{"ServerURL": "registry.example.com", "Username": "helped", "Secret": "secret"}'
+else
+	echo "credentials not found in native keychain"
+	exit 1
+fi
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "docker-credential-test"), []byte(helper), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	path, done := writeTestDockerConfig(t, `{"credsStore": "test"}`)
+	defer done()
+	cs := newCredentialStore()
+	cs.configPath = path
+
+	creds, ok, err := cs.credentials("registry.example.com")
+	if err != nil || !ok || creds.Username != "helped" || creds.Password != "secret" {
+		t.Errorf("got %v, %t, %v", creds, ok, err)
+	}
+	if _, ok, err := cs.credentials("other.example.com"); ok || err != nil {
+		t.Errorf("got credentials for an unknown host: %t, %v", ok, err)
+	}
+}
@@ -6,14 +6,19 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/digest"
 	"github.com/docker/distribution/manifest/schema1"
 	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/api/errcode"
 	"github.com/docker/distribution/registry/api/v2"
 	"github.com/docker/distribution/registry/client"
+	"github.com/opentable/sous/util/logging"
 	"golang.org/x/net/context"
 )
 
@@ -23,6 +28,9 @@ type (
 		//ContainerConfig ContainerConfig `json:"container_config"`
 		CC        ContainerConfig `json:"container_config""`
 		Container string          `json:"container"`
+		// Config is the image config as of the layer, which, for the top
+		// layer, is what a schema 2 manifest's config blob would hold.
+		Config ContainerConfig `json:"config"`
 	}
 
 	// ContainerConfig captures the configuration of a docker container
@@ -34,9 +42,31 @@ type (
 	// Client for v2 of the docker registry. Maintains state and accumulates e.g. endpoints to make requests against.
 	// Although it's developed in concert with Sous, there's a conscious effort to avoid coupling to Sous concepts like e.g. SourceVersion
 	liveClient struct {
-		ctx        context.Context
-		xport      *http.Transport
-		registries map[string]*registry
+		ctx      context.Context
+		xport    *http.Transport
+		hosts    *hostClients
+		platform Platform
+		creds    *credentialStore
+		// transports are the transports set for particular registry hosts,
+		// or for all of them under "", in place of xport.
+		transports map[string]http.RoundTripper
+		// tlsConfigs are the TLS configurations set for particular registry
+		// hosts, in place of xport's.
+		tlsConfigs map[string]*tls.Config
+		// insecure are the registry hosts spoken to with plain HTTP.
+		insecure map[string]bool
+		// timeout limits each request to a registry.
+		timeout time.Duration
+		// maxRetryWait is the longest a rate limited request will wait to be
+		// tried again.
+		maxRetryWait time.Duration
+		instruments  *instruments
+		log          logging.Logger
+		// configs caches the labels from image config blobs.
+		configs *configCache
+		// requiredLabels are the labels which, if a manifest has them all,
+		// mean its config blob needn't be fetched.
+		requiredLabels []string
 	}
 
 	// Client is the interface for interacting with a docker registry
@@ -44,8 +74,12 @@ type (
 		LabelsForImageName(string) (map[string]string, error)
 		GetImageMetadata(imageName, etag string) (Metadata, error)
 		AllTags(repoName string) ([]string, error)
+		GetImageMetadataContext(ctx context.Context, imageName, etag string) (Metadata, error)
+		AllTagsContext(ctx context.Context, repoName string) ([]string, error)
 		Cancel()
 		BecomeFoolishlyTrusting()
+		SetCredentials(host string, creds Credentials)
+		SetInstrumentation(Instrumentation)
 	}
 
 	// Metadata represents the descriptive data for a docker image
@@ -54,15 +88,90 @@ type (
 		Etag          string
 		CanonicalName string
 		AllNames      []string
+		// Platform is the platform whose image was selected from a manifest
+		// list, e.g. linux/amd64, if the name was of a list.
+		Platform string
+		// IndexDigest is the digest of the manifest list, if any; the
+		// CanonicalName is of the image selected from it.
+		IndexDigest string
+		// Digest is the digest of the image's manifest, as computed from the
+		// manifest itself, and checked against what the registry said.
+		Digest string
 	}
 )
 
+// ErrNotModified is returned by GetImageMetadata when the etag given is still
+// that of the image named.
+var ErrNotModified = distribution.ErrManifestNotModified
+
+// IsNotFound is true if err says that the registry has no image, or
+// repository, of the name asked for, as opposed to it being unable to say.
+// Errors of other Clients, e.g. fakes, can say so with a NotFound() bool
+// method.
+func IsNotFound(err error) bool {
+	switch e := err.(type) {
+	case interface {
+		NotFound() bool
+	}:
+		return e.NotFound()
+	case errcode.Errors:
+		for _, err := range e {
+			if IsNotFound(err) {
+				return true
+			}
+		}
+	case errcode.Error:
+		return IsNotFound(e.Code)
+	case errcode.ErrorCode:
+		return e == v2.ErrorCodeManifestUnknown || e == v2.ErrorCodeNameUnknown
+	case *client.UnexpectedHTTPResponseError:
+		return e.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
 // NewClient builds a new client
 func NewClient() Client {
+	return NewClientForPlatform(DefaultPlatform)
+}
+
+// NewClientForPlatform builds a new client that selects the image for
+// platform p from multi-platform manifest lists.
+func NewClientForPlatform(p Platform) Client {
+	c := newLiveClient()
+	c.platform = p
+	return c
+}
+
+// NewClientWithOptions builds a new client configured by opts, e.g.
+//
+//	NewClientWithOptions(WithCABundle("registry.example.com", "/etc/ssl/internal.pem"))
+func NewClientWithOptions(opts ...ClientOption) (Client, error) {
+	c := newLiveClient()
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func newLiveClient() *liveClient {
 	return &liveClient{
 		ctx:        context.Background(),
 		xport:      &http.Transport{},
-		registries: make(map[string]*registry),
+		hosts:      newHostClients(),
+		platform:   DefaultPlatform,
+		creds:      newCredentialStore(),
+		transports: make(map[string]http.RoundTripper),
+		tlsConfigs: make(map[string]*tls.Config),
+		insecure:   make(map[string]bool),
+
+		timeout:      DefaultTimeout,
+		maxRetryWait: DefaultMaxRetryWait,
+		instruments:  &instruments{},
+		log:          logging.ForSubsystem(logging.Discard, logging.Registry),
+		configs:      newConfigCache(),
 	}
 }
 
@@ -72,6 +181,13 @@ func (c *liveClient) BecomeFoolishlyTrusting() {
 	c.xport.TLSClientConfig = &tls.Config{
 		InsecureSkipVerify: true,
 	}
+	c.hosts.flush()
+}
+
+// SetCredentials sets the credentials used for the registry at host, in
+// place of any in the Docker config file, ~/.docker/config.json.
+func (c *liveClient) SetCredentials(host string, creds Credentials) {
+	c.creds.set(host, creds)
 }
 
 func (c *liveClient) Cancel() {
@@ -87,18 +203,42 @@ func (c *liveClient) LabelsForImageName(imageName string) (labels map[string]str
 
 // LabelsForEtaggedImageName works like LabelsForImageName, with the additional option to send an etag with the request
 func (c *liveClient) GetImageMetadata(imageName string, etag string) (Metadata, error) {
+	return c.GetImageMetadataContext(c.ctx, imageName, etag)
+}
+
+// GetImageMetadataContext works like GetImageMetadata, giving up when ctx is done
+func (c *liveClient) GetImageMetadataContext(ctx context.Context, imageName string, etag string) (Metadata, error) {
 	regHost, ref, err := splitHost(imageName)
 
 	if err != nil {
 		return Metadata{}, err
 	}
 
-	return c.metadataForImage(regHost, ref, etag)
+	log := c.log.With("image", imageName)
+	md, err := c.metadataForImage(ctx, regHost, ref, etag)
+	if err == nil {
+		md, err = qualifyNames(regHost, md)
+	}
+	switch {
+	case err == ErrNotModified:
+		log.Debugf("Image metadata unchanged since etag %s", etag)
+	case err != nil:
+		log.Debugf("Unable to get image metadata: %s", err)
+	default:
+		log.Debugf("Got image metadata for %s", md.CanonicalName)
+	}
+	return md, err
 }
 
-// AllTags returns a list of tags for a particular repo
+// AllTags returns a list of all the tags for a particular repo, however many
+// pages the registry splits them into
 func (c *liveClient) AllTags(repoName string) ([]string, error) {
-	regHost, ref, err := splitHost(repoName)
+	return c.AllTagsContext(c.ctx, repoName)
+}
+
+// AllTagsContext works like AllTags, giving up when ctx is done
+func (c *liveClient) AllTagsContext(ctx context.Context, repoName string) ([]string, error) {
+	regHost, ref, err := splitRepo(repoName)
 	if err != nil {
 		return []string{}, err
 	}
@@ -108,7 +248,7 @@ func (c *liveClient) AllTags(repoName string) ([]string, error) {
 		return []string{}, err
 	}
 
-	return rep.getRepoTags(ref)
+	return rep.getRepoTags(ctx, ref)
 }
 
 func splitHost(in string) (url string, ref reference.Named, err error) {
@@ -122,6 +262,50 @@ func splitHost(in string) (url string, ref reference.Named, err error) {
 	return
 }
 
+// splitRepo splits the repository name in, which needn't have a tag or
+// digest, into its registry host and its name there.
+func splitRepo(in string) (url string, ref reference.Named, err error) {
+	ref, err = reference.ParseNamed(in)
+	if err != nil {
+		return
+	}
+
+	url, name := reference.SplitHostname(ref)
+	ref, err = reference.ParseNamed(name)
+	return
+}
+
+// qualifyNames returns md with the names in it, which are relative to the
+// registry at host, joined to host, so that they name the image wherever
+// they're used, as the name asked for did.
+func qualifyNames(host string, md Metadata) (Metadata, error) {
+	qualify := func(name string) (string, error) {
+		ref, err := reference.ParseNamed(name)
+		if err != nil {
+			return "", err
+		}
+		ref, err = joinHost(host, ref)
+		if err != nil {
+			return "", err
+		}
+		return ref.String(), nil
+	}
+	var err error
+	if md.CanonicalName != "" {
+		if md.CanonicalName, err = qualify(md.CanonicalName); err != nil {
+			return Metadata{}, err
+		}
+	}
+	names := make([]string, len(md.AllNames))
+	for i, n := range md.AllNames {
+		if names[i], err = qualify(n); err != nil {
+			return Metadata{}, err
+		}
+	}
+	md.AllNames = names
+	return md, nil
+}
+
 func joinHost(host string, ref reference.Named) (reference.Named, error) {
 	if host == "" {
 		return ref, nil
@@ -159,23 +343,22 @@ func digestRef(ref reference.Named, digst string) (reference.Canonical, error) {
 	return reference.WithDigest(rn, d)
 }
 
+// registryForHostname returns the registry at regHost, reusing the
+// connections and tokens of earlier requests to it.
 func (c *liveClient) registryForHostname(regHost string) (*registry, error) {
-	url := fmt.Sprintf("https://%s", regHost)
-	if reg, ok := c.registries[url]; ok {
-		return reg, nil
-	}
-	reg, err := newRegistry(url, c.xport)
-	if err != nil {
-		return nil, err
-	}
-	c.registries[url] = reg
-	return reg, nil
+	return c.hosts.get(regHost, func() (*hostClient, error) {
+		return c.newHostClient(regHost)
+	})
 }
 
 // LabelsForTaggedImage makes a query to a docker registry an returns a map of the labels on that image.
-// Currently supports the v2.0 registry Schema v1 (not to be confused with Schema v2)
-// This shouldn't be a problem, since the second version of the schema isn't due until the summer
-// and registries that suport it are supposed to use HTTP Accept headers to negotiate with clients.
+// Supports the v2.0 registry Schema v1 and Schema v2, and OCI image manifests.
+// If the name is of a manifest list or OCI index, the image for the client's platform is selected
+// from it, and the labels are those of that image.
+// The labels of the image's config, from the config blob, or for schema 1 the
+// top layer's history, are merged over those of the manifest, unless the
+// manifest already has all the client's required labels. Config blobs are
+// cached by digest.
 // c.f. https://github.com/docker/distribution/blob/master/docs/spec/manifest-v2-1.md
 // and  https://github.com/docker/distribution/blob/master/docs/spec/manifest-v2-2.md
 //
@@ -185,7 +368,7 @@ func (c *liveClient) registryForHostname(regHost string) (*registry, error) {
 //	"demo-server-0.7.3-SNAPSHOT-20160329_202654_teamcity-unconfigured"
 // )
 // ( which returns an empty map, since the demo-server doesn't have labels... )
-func (c *liveClient) metadataForImage(regHost string, ref reference.Named, etag string) (md Metadata, err error) {
+func (c *liveClient) metadataForImage(ctx context.Context, regHost string, ref reference.Named, etag string) (md Metadata, err error) {
 	// slightly weird but: a non-empty etag implies that we've seen this
 	// digest-named container before - and a digest reference should be
 	// immutable.
@@ -198,30 +381,82 @@ func (c *liveClient) metadataForImage(regHost string, ref reference.Named, etag
 		return
 	}
 
-	mani, headers, err := rep.getManifestWithEtag(c.ctx, ref, etag)
+	top, err := rep.getManifest(ctx, ref, etag)
 	if err != nil {
 		return
 	}
 
 	md = Metadata{
-		AllNames: make([]string, 2),
+		AllNames: []string{ref.String()},
 		Labels:   make(map[string]string),
-		Etag:     headers.Get("Etag"),
+		// The etag is always that of the manifest ref names, even when it's a
+		// list, so that it can be sent back with ref next time.
+		Etag: top.headers.Get("Etag"),
+	}
+
+	mani := top
+	if isIndex(top.mediaType) {
+		md.IndexDigest = top.digest.String()
+		if ir, err := digestRef(ref, md.IndexDigest); err == nil {
+			md.AllNames = append(md.AllNames, ir.String())
+		}
+		desc, err := selectPlatform(top.body, c.platform)
+		if err != nil {
+			return Metadata{}, fmt.Errorf("%s: %s", ref, err)
+		}
+		md.Platform = desc.Platform.String()
+		pr, err := digestRef(ref, desc.Digest.String())
+		if err != nil {
+			return Metadata{}, err
+		}
+		if mani, err = rep.getManifest(ctx, pr, ""); err != nil {
+			return Metadata{}, err
+		}
 	}
-	md.AllNames[0] = ref.String()
-	dr, err := digestRef(ref, headers.Get("Docker-Content-Digest"))
+
+	md.Digest = mani.digest.String()
+	dr, err := digestRef(ref, md.Digest)
 	if err == nil {
-		md.AllNames[1] = dr.String()
+		md.AllNames = append(md.AllNames, dr.String())
 		md.CanonicalName = dr.String()
 	}
 
-	switch mani := mani.(type) {
+	if isImageManifest(mani.mediaType) {
+		var im imageManifest
+		if err := json.Unmarshal(mani.body, &im); err != nil {
+			return Metadata{}, err
+		}
+		for k, v := range im.Annotations {
+			md.Labels[k] = v
+		}
+		if hasLabels(md.Labels, c.requiredLabels) {
+			return md, nil
+		}
+		labels, err := c.configLabels(ctx, rep, ref, im.Config.Digest)
+		if err != nil {
+			return Metadata{}, err
+		}
+		for k, v := range labels {
+			md.Labels[k] = v
+		}
+		return md, nil
+	}
+
+	m, _, err := distribution.UnmarshalManifest(mani.mediaType, mani.body)
+	if err != nil {
+		return Metadata{}, err
+	}
+	switch m := m.(type) {
 	case *schema1.SignedManifest:
-		history := mani.History
-		for _, v1 := range history {
+		history := m.History
+		var top V1Schema
+		for i, v1 := range history {
 			var historyEntry V1Schema
 			json.Unmarshal([]byte(v1.V1Compatibility), &historyEntry)
 			//	log.Print(historyEntry.ContainerConfig.Cmd)
+			if i == 0 {
+				top = historyEntry
+			}
 
 			histLabels := historyEntry.CC.Labels
 			// XXX It's unclear from the docker spec which order the labels appear in.
@@ -232,8 +467,16 @@ func (c *liveClient) metadataForImage(regHost string, ref reference.Named, etag
 				md.Labels[k] = v
 			}
 		}
+		// Labels set with LABEL may be only in the image's config, which
+		// schema 1 keeps with the top layer; as with a config blob, its
+		// labels win.
+		if !hasLabels(md.Labels, c.requiredLabels) {
+			for k, v := range top.Config.Labels {
+				md.Labels[k] = v
+			}
+		}
 	default:
-		err = fmt.Errorf("Cripes! v2 manifest, which is awesome, but we have no idea how to parse it. Contact your nearest sous chef.")
+		err = fmt.Errorf("Cripes! %s manifest, which is awesome, but we have no idea how to parse it. Contact your nearest sous chef.", mani.mediaType)
 	}
 
 	return
@@ -258,12 +501,16 @@ func newRegistry(baseURL string, transport http.RoundTripper) (*registry, error)
 	return &registry{
 		client: client,
 		ub:     ub,
+		log:    logging.Discard,
 	}, nil
 }
 
 type registry struct {
-	client *http.Client
-	ub     *v2.URLBuilder
+	host         string
+	client       *http.Client
+	ub           *v2.URLBuilder
+	maxRetryWait time.Duration
+	log          logging.Logger
 }
 
 func (r *registry) getRequest(u, etag string) (req *http.Request, err error) {
@@ -275,6 +522,9 @@ func (r *registry) getRequest(u, etag string) (req *http.Request, err error) {
 	for _, t := range distribution.ManifestMediaTypes() {
 		req.Header.Add("Accept", t)
 	}
+	for _, t := range extraManifestMediaTypes {
+		req.Header.Add("Accept", t)
+	}
 
 	if etag != "" {
 		req.Header.Set("If-None-Match", etag)
@@ -286,81 +536,129 @@ type tagsResponse struct {
 	Tags []string `json:"tags"`
 }
 
-func (r *registry) getRepoTags(ref reference.Named) (tags []string, err error) {
+// tagsPageSize is the number of tags asked for in each request, for
+// registries that paginate with the n and last parameters.
+const tagsPageSize = 100
+
+// maxTagsPages is the most pages of tags that will be fetched for a single
+// repository, in case a registry sends us round in circles.
+const maxTagsPages = 1000
+
+// getRepoTags returns all the tags in the repository ref. Registries cap the
+// number of tags in a response, so it follows the rel="next" Link header of
+// each response, or if there is none but the page was full, asks for the
+// next page with the last parameter, until every tag has been fetched.
+func (r *registry) getRepoTags(ctx context.Context, ref reference.Named) (tags []string, err error) {
 	u, err := r.ub.BuildTagsURL(ref)
 	if err != nil {
 		return tags, err
 	}
+	next, err := tagsPageURL(u, "")
+	if err != nil {
+		return tags, err
+	}
+
+	for pages := 0; next != ""; pages++ {
+		if pages == maxTagsPages {
+			return tags, fmt.Errorf("gave up listing tags for %s after %d pages", ref.Name(), pages)
+		}
+		page, link, err := r.getTagsPage(ctx, next)
+		if err != nil {
+			return tags, err
+		}
+		tags = append(tags, page...)
+
+		switch {
+		case link != "":
+			next, err = resolveLink(next, link)
+		case len(page) == tagsPageSize:
+			next, err = tagsPageURL(u, page[len(page)-1])
+		default:
+			next = ""
+		}
+		if err != nil {
+			return tags, err
+		}
+	}
+	return tags, nil
+}
 
+// getTagsPage fetches one page of tags from u, and returns it along with
+// the target of its rel="next" Link header, if any.
+func (r *registry) getTagsPage(ctx context.Context, u string) (tags []string, next string, err error) {
 	req, err := r.getRequest(u, "")
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	resp, err := r.client.Do(req)
+	resp, err := r.do(ctx, req)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer resp.Body.Close()
 
 	if !client.SuccessStatus(resp.StatusCode) {
-		return tags, client.HandleErrorResponse(resp)
+		return tags, "", client.HandleErrorResponse(resp)
 	}
 
 	b, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return tags, err
+		return tags, "", err
 	}
 
 	var tr tagsResponse
 	if err := json.Unmarshal(b, &tr); err != nil {
-		return tags, err
+		return tags, "", err
 	}
-	tags = tr.Tags
-	return tags, nil
+	return tr.Tags, nextLink(resp.Header), nil
 }
 
-func (r *registry) getManifestWithEtag(ctx context.Context, ref reference.Named, etag string) (distribution.Manifest, http.Header, error) {
-	var err error
-
-	u, err := r.ub.BuildManifestURL(ref)
+// tagsPageURL adds the n and last parameters to the tags URL u.
+func tagsPageURL(u, last string) (string, error) {
+	pu, err := url.Parse(u)
 	if err != nil {
-		return nil, http.Header{}, err
+		return "", err
 	}
+	q := pu.Query()
+	q.Set("n", strconv.Itoa(tagsPageSize))
+	if last != "" {
+		q.Set("last", last)
+	}
+	pu.RawQuery = q.Encode()
+	return pu.String(), nil
+}
 
-	req, err := r.getRequest(u, etag)
-	if err != nil {
-		return nil, http.Header{}, err
+// nextLink returns the target of the RFC 5988 Link header with rel="next"
+// in h, or "" if there is none.
+func nextLink(h http.Header) string {
+	for _, header := range h["Link"] {
+		for _, link := range strings.Split(header, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, param := range parts[1:] {
+				param = strings.Replace(strings.TrimSpace(param), " ", "", -1)
+				if param == `rel="next"` || param == "rel=next" {
+					return target[1 : len(target)-1]
+				}
+			}
+		}
 	}
+	return ""
+}
 
-	resp, err := r.client.Do(req)
+// resolveLink resolves link, which is often only a path, against the URL it
+// was returned from.
+func resolveLink(from, link string) (string, error) {
+	base, err := url.Parse(from)
 	if err != nil {
-		return nil, http.Header{}, err
+		return "", err
 	}
-	defer resp.Body.Close()
-	mf, err := r.manifestFromResponse(resp)
+	lu, err := url.Parse(link)
 	if err != nil {
-		return nil, http.Header{}, err
-	}
-
-	return mf, resp.Header, err
-}
-
-func (r *registry) manifestFromResponse(resp *http.Response) (distribution.Manifest, error) {
-	if resp.StatusCode == http.StatusNotModified {
-		return nil, distribution.ErrManifestNotModified
-	} else if client.SuccessStatus(resp.StatusCode) {
-		mt := resp.Header.Get("Content-Type")
-		body, err := ioutil.ReadAll(resp.Body)
-
-		if err != nil {
-			return nil, err
-		}
-		m, _, err := distribution.UnmarshalManifest(mt, body)
-		if err != nil {
-			return nil, err
-		}
-		return m, nil
+		return "", err
 	}
-	return nil, client.HandleErrorResponse(resp)
+	return base.ResolveReference(lu).String(), nil
 }
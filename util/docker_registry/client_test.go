@@ -0,0 +1,290 @@
+package docker_registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/reference"
+	"golang.org/x/net/context"
+)
+
+func testTagsRegistry(t *testing.T, h http.HandlerFunc) (*registry, reference.Named, func()) {
+	srv := httptest.NewServer(h)
+	r, err := newRegistry(srv.URL, &http.Transport{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := reference.ParseNamed("example/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, ref, srv.Close
+}
+
+func TestGetRepoTags_LinkHeaders(t *testing.T) {
+	pages := map[string]string{
+		"":      `{"name": "example/repo", "tags": ["1.0.0", "1.1.0"]}`,
+		"1.1.0": `{"name": "example/repo", "tags": ["2.0.0", "2.1.0"]}`,
+		"2.1.0": `{"name": "example/repo", "tags": ["3.0.0"]}`,
+	}
+	next := map[string]string{"": "1.1.0", "1.1.0": "2.1.0"}
+	r, ref, done := testTagsRegistry(t, func(w http.ResponseWriter, req *http.Request) {
+		last := req.URL.Query().Get("last")
+		if n, ok := next[last]; ok {
+			w.Header().Set("Link",
+				fmt.Sprintf(`</v2/example/repo/tags/list?n=2&last=%s>; rel="next"`, n))
+		}
+		fmt.Fprint(w, pages[last])
+	})
+	defer done()
+
+	tags, err := r.getRepoTags(context.Background(), ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1.0.0", "1.1.0", "2.0.0", "2.1.0", "3.0.0"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("got %v; want %v", tags, want)
+	}
+}
+
+func TestGetRepoTags_LastParameter(t *testing.T) {
+	all := make([]string, 2*tagsPageSize+1)
+	for i := range all {
+		all[i] = fmt.Sprintf("1.0.%04d", i)
+	}
+	requests := 0
+	r, ref, done := testTagsRegistry(t, func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		start := 0
+		if last := req.URL.Query().Get("last"); last != "" {
+			for i, tag := range all {
+				if tag == last {
+					start = i + 1
+				}
+			}
+		}
+		end := start + tagsPageSize
+		if end > len(all) {
+			end = len(all)
+		}
+		fmt.Fprintf(w, `{"tags": ["%s"]}`, strings.Join(all[start:end], `", "`))
+	})
+	defer done()
+
+	tags, err := r.getRepoTags(context.Background(), ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, all) {
+		t.Errorf("got %d tags; want %d", len(tags), len(all))
+	}
+	if requests != 3 {
+		t.Errorf("made %d requests; want 3", requests)
+	}
+}
+
+func TestGetRepoTags_Cancelled(t *testing.T) {
+	r, ref, done := testTagsRegistry(t, func(w http.ResponseWriter, req *http.Request) {
+		t.Error("request made with a cancelled context")
+	})
+	defer done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := r.getRepoTags(ctx, ref); err == nil {
+		t.Error("got no error with a cancelled context")
+	}
+}
+
+func TestNextLink(t *testing.T) {
+	h := http.Header{}
+	h.Add("Link", `</v2/a/tags/list?last=x>; rel="prev", </v2/a/tags/list?last=y>; rel="next"`)
+	if got, want := nextLink(h), "/v2/a/tags/list?last=y"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if got := nextLink(http.Header{}); got != "" {
+		t.Errorf("got %q with no Link header", got)
+	}
+}
+
+func testDigest(c byte) string {
+	return "sha256:" + strings.Repeat(string(c), 64)
+}
+
+// testManifestRegistry serves a manifest list for example/repo:1.0.0 of an
+// amd64 and an arm64 image, with labels in their config blobs.
+func testManifestRegistry(t *testing.T, listType string) (host string, list, amd string, done func()) {
+	amdConfig, armConfig := testDigest('d'), testDigest('e')
+	amdBody := fmt.Sprintf(`{"config": {"digest": %q}}`, amdConfig)
+	armBody := fmt.Sprintf(`{"config": {"digest": %q}}`, armConfig)
+	amd, arm := digest.FromBytes([]byte(amdBody)).String(), digest.FromBytes([]byte(armBody)).String()
+	listBody := fmt.Sprintf(`{"manifests": [
+			{"digest": %q, "platform": {"os": "linux", "architecture": "amd64"}},
+			{"digest": %q, "platform": {"os": "linux", "architecture": "arm64", "variant": "v8"}}
+		]}`, amd, arm)
+	list = digest.FromBytes([]byte(listBody)).String()
+	routes := map[string]struct{ mediaType, digest, body string }{
+		"/v2/example/repo/manifests/1.0.0":    {listType, list, listBody},
+		"/v2/example/repo/manifests/" + amd:   {mediaTypeManifestV2, amd, amdBody},
+		"/v2/example/repo/manifests/" + arm:   {mediaTypeOCIManifest, arm, armBody},
+		"/v2/example/repo/blobs/" + amdConfig: {"application/json", "", `{"config": {"Labels": {"arch": "amd64"}}}`},
+		"/v2/example/repo/blobs/" + armConfig: {"application/json", "", `{"config": {"Labels": {"arch": "arm64"}}}`},
+	}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r, ok := routes[req.URL.Path]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", r.mediaType)
+		if r.digest != "" {
+			w.Header().Set("Docker-Content-Digest", r.digest)
+			w.Header().Set("Etag", `"`+r.digest+`"`)
+		}
+		fmt.Fprint(w, r.body)
+	}))
+	return strings.TrimPrefix(srv.URL, "https://"), list, amd, srv.Close
+}
+
+func TestGetImageMetadata_ManifestList(t *testing.T) {
+	for _, listType := range []string{mediaTypeManifestList, mediaTypeOCIIndex} {
+		host, list, amd, done := testManifestRegistry(t, listType)
+		c := NewClient()
+		c.BecomeFoolishlyTrusting()
+
+		md, err := c.GetImageMetadata(host+"/example/repo:1.0.0", "")
+		done()
+		if err != nil {
+			t.Fatalf("%s: %s", listType, err)
+		}
+		if md.Labels["arch"] != "amd64" {
+			t.Errorf("%s: got labels %v; want those of the amd64 image", listType, md.Labels)
+		}
+		if want := host + "/example/repo@" + amd; md.CanonicalName != want || md.Digest != amd {
+			t.Errorf("%s: got canonical name %q; want %q", listType, md.CanonicalName, want)
+		}
+		if md.Platform != "linux/amd64" || md.IndexDigest != list {
+			t.Errorf("%s: got platform %q, index digest %q", listType, md.Platform, md.IndexDigest)
+		}
+		if want := `"` + list + `"`; md.Etag != want {
+			t.Errorf("%s: got etag %q; want that of the list, %q", listType, md.Etag, want)
+		}
+		if len(md.AllNames) != 3 {
+			t.Errorf("%s: got names %v; want the tag, the list and the image", listType, md.AllNames)
+		}
+	}
+}
+
+func TestGetImageMetadata_Platform(t *testing.T) {
+	host, _, _, done := testManifestRegistry(t, mediaTypeManifestList)
+	defer done()
+	p, err := ParsePlatform("linux/arm64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewClientForPlatform(p)
+	c.BecomeFoolishlyTrusting()
+
+	md, err := c.GetImageMetadata(host+"/example/repo:1.0.0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Labels["arch"] != "arm64" || md.Platform != "linux/arm64/v8" {
+		t.Errorf("got labels %v for platform %q; want the arm64 image", md.Labels, md.Platform)
+	}
+
+	c = NewClientForPlatform(Platform{OS: "windows", Architecture: "amd64"})
+	c.BecomeFoolishlyTrusting()
+	_, err = c.GetImageMetadata(host+"/example/repo:1.0.0", "")
+	if err == nil || !strings.Contains(err.Error(), "linux/amd64, linux/arm64/v8") {
+		t.Errorf("got error %v; want one listing the available platforms", err)
+	}
+}
+
+func TestGetImageMetadata_DigestMismatch(t *testing.T) {
+	body := fmt.Sprintf(`{"config": {"digest": %q}}`, testDigest('d'))
+	good := digest.FromBytes([]byte(body)).String()
+	tampered := strings.Replace(body, "dddd", "ffff", 1)
+	served, header := body, ""
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case strings.Contains(req.URL.Path, "/manifests/"):
+			w.Header().Set("Content-Type", mediaTypeManifestV2)
+			if header != "" {
+				w.Header().Set("Docker-Content-Digest", header)
+			}
+			fmt.Fprint(w, served)
+		case strings.Contains(req.URL.Path, "/blobs/"):
+			fmt.Fprint(w, `{"config": {"Labels": {"a": "b"}}}`)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "https://")
+	c := NewClient()
+	c.BecomeFoolishlyTrusting()
+
+	header = good
+	md, err := c.GetImageMetadata(host+"/example/repo:1.0.0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Digest != good {
+		t.Errorf("got digest %q; want %q", md.Digest, good)
+	}
+
+	served = tampered
+	_, err = c.GetImageMetadata(host+"/example/repo:1.0.0", "")
+	dm, ok := err.(DigestMismatch)
+	if !ok {
+		t.Fatalf("got error %v; want a DigestMismatch", err)
+	}
+	if dm.Expected != good || dm.Actual != digest.FromBytes([]byte(tampered)).String() {
+		t.Errorf("got %+v; want the header's digest and the tampered body's", dm)
+	}
+
+	header = ""
+	_, err = c.GetImageMetadata(host+"/example/repo@"+good, "")
+	if dm, ok := err.(DigestMismatch); !ok || dm.Source != "the image name" {
+		t.Errorf("got error %v; want a DigestMismatch with the image name", err)
+	}
+}
+
+func TestParsePlatform(t *testing.T) {
+	for _, s := range []string{"linux/amd64", "linux/arm64/v8"} {
+		p, err := ParsePlatform(s)
+		if err != nil || p.String() != s {
+			t.Errorf("ParsePlatform(%q) = %v, %v", s, p, err)
+		}
+	}
+	for _, s := range []string{"", "linux", "linux/", "a/b/c/d"} {
+		if _, err := ParsePlatform(s); err == nil {
+			t.Errorf("ParsePlatform(%q) didn't fail", s)
+		}
+	}
+}
+
+func TestIsNotFound(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"errors": [{"code": "MANIFEST_UNKNOWN", "message": "manifest unknown"}]}`)
+	}))
+	defer srv.Close()
+	c := NewClient()
+	c.BecomeFoolishlyTrusting()
+
+	_, err := c.GetImageMetadata(strings.TrimPrefix(srv.URL, "https://")+"/example/repo:1.0.0", "")
+	if !IsNotFound(err) {
+		t.Errorf("IsNotFound(%#v) = false; want true", err)
+	}
+	if IsNotFound(fmt.Errorf("connection refused")) {
+		t.Error("IsNotFound true for an unrelated error")
+	}
+}
@@ -0,0 +1,84 @@
+package docker_registry
+
+import (
+	"sync"
+
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/reference"
+	"golang.org/x/net/context"
+)
+
+// DefaultMaxConfigs is the most image config blobs whose labels a client
+// keeps at once, unless it's built with WithMaxConfigs.
+const DefaultMaxConfigs = 1024
+
+// configCache keeps the labels read from image config blobs by the blobs'
+// digests, which, being of their content, name the same labels whichever
+// registry or repository they're fetched from.
+type configCache struct {
+	sync.Mutex
+	labels map[digest.Digest]map[string]string
+	max    int
+}
+
+func newConfigCache() *configCache {
+	return &configCache{
+		labels: map[digest.Digest]map[string]string{},
+		max:    DefaultMaxConfigs,
+	}
+}
+
+// get returns the labels of the config blob d, if they're cached.
+func (cc *configCache) get(d digest.Digest) (map[string]string, bool) {
+	cc.Lock()
+	defer cc.Unlock()
+	labels, ok := cc.labels[d]
+	return labels, ok
+}
+
+// put caches the labels of the config blob d, dropping another blob's to
+// make room if there are already max of them. If max is zero, nothing is
+// cached.
+func (cc *configCache) put(d digest.Digest, labels map[string]string) {
+	cc.Lock()
+	defer cc.Unlock()
+	if cc.max <= 0 {
+		return
+	}
+	for k := range cc.labels {
+		if len(cc.labels) < cc.max {
+			break
+		}
+		delete(cc.labels, k)
+	}
+	cc.labels[d] = labels
+}
+
+// hasLabels is true if every one of names is in labels. It's false if
+// names is empty, so that, unless some labels are required, the config blob
+// is always consulted.
+func hasLabels(labels map[string]string, names []string) bool {
+	if len(names) == 0 {
+		return false
+	}
+	for _, n := range names {
+		if _, ok := labels[n]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// configLabels returns the labels in the config blob d of the image ref,
+// fetching it from rep unless it's cached.
+func (c *liveClient) configLabels(ctx context.Context, rep *registry, ref reference.Named, d digest.Digest) (map[string]string, error) {
+	if labels, ok := c.configs.get(d); ok {
+		return labels, nil
+	}
+	labels, err := rep.configLabels(ctx, ref, d)
+	if err != nil {
+		return nil, err
+	}
+	c.configs.put(d, labels)
+	return labels, nil
+}
@@ -0,0 +1,186 @@
+package docker_registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest/schema1"
+	"github.com/docker/libtrust"
+)
+
+// configRegistry serves example/repo:one and example/repo:two, both with
+// the OCI manifest body, and the config blob config, counting how often
+// the blob is fetched.
+type configRegistry struct {
+	sync.Mutex
+	host        string
+	blobFetches int
+}
+
+func testConfigRegistry(t *testing.T, body, config string) (*configRegistry, func()) {
+	cr := &configRegistry{}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case strings.Contains(req.URL.Path, "/manifests/"):
+			w.Header().Set("Content-Type", mediaTypeOCIManifest)
+			fmt.Fprint(w, body)
+		case strings.Contains(req.URL.Path, "/blobs/"):
+			cr.Lock()
+			cr.blobFetches++
+			cr.Unlock()
+			fmt.Fprint(w, config)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	cr.host = strings.TrimPrefix(srv.URL, "https://")
+	return cr, srv.Close
+}
+
+func (cr *configRegistry) fetches() int {
+	cr.Lock()
+	defer cr.Unlock()
+	return cr.blobFetches
+}
+
+func testConfigClient(t *testing.T, opts ...ClientOption) Client {
+	c, err := NewClientWithOptions(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BecomeFoolishlyTrusting()
+	return c
+}
+
+func TestGetImageMetadata_ConfigLabelsWin(t *testing.T) {
+	body := fmt.Sprintf(`{"config": {"digest": %q}, "annotations": {"a": "manifest", "b": "manifest"}}`, testDigest('d'))
+	cr, done := testConfigRegistry(t, body, `{"config": {"Labels": {"b": "config", "c": "config"}}}`)
+	defer done()
+	c := testConfigClient(t)
+
+	md, err := c.GetImageMetadata(cr.host+"/example/repo:one", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"a": "manifest", "b": "config", "c": "config"}
+	if !reflect.DeepEqual(md.Labels, want) {
+		t.Errorf("got labels %v; want %v", md.Labels, want)
+	}
+}
+
+func TestGetImageMetadata_ConfigCachedByDigest(t *testing.T) {
+	body := fmt.Sprintf(`{"config": {"digest": %q}}`, testDigest('d'))
+	cr, done := testConfigRegistry(t, body, `{"config": {"Labels": {"a": "b"}}}`)
+	defer done()
+	c := testConfigClient(t)
+
+	for _, tag := range []string{"one", "two", "one"} {
+		md, err := c.GetImageMetadata(cr.host+"/example/repo:"+tag, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if md.Labels["a"] != "b" {
+			t.Errorf("%s: got labels %v; want those of the config", tag, md.Labels)
+		}
+	}
+	if n := cr.fetches(); n != 1 {
+		t.Errorf("config fetched %d times; want once", n)
+	}
+
+	c = testConfigClient(t, WithMaxConfigs(0))
+	for _, tag := range []string{"one", "two"} {
+		if _, err := c.GetImageMetadata(cr.host+"/example/repo:"+tag, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := cr.fetches(); n != 3 {
+		t.Errorf("config fetched %d times in all; want every time when none are kept", n)
+	}
+}
+
+func TestGetImageMetadata_RequiredLabelsSkipConfig(t *testing.T) {
+	body := fmt.Sprintf(`{"config": {"digest": %q}, "annotations": {"repo": "r", "version": "v"}}`, testDigest('d'))
+	cr, done := testConfigRegistry(t, body, `{"config": {"Labels": {"other": "o"}}}`)
+	defer done()
+
+	c := testConfigClient(t, WithRequiredLabels("repo", "version"))
+	md, err := c.GetImageMetadata(cr.host+"/example/repo:one", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := cr.fetches(); n != 0 {
+		t.Errorf("config fetched %d times; want none, since the manifest has the required labels", n)
+	}
+	if want := map[string]string{"repo": "r", "version": "v"}; !reflect.DeepEqual(md.Labels, want) {
+		t.Errorf("got labels %v; want %v", md.Labels, want)
+	}
+
+	c = testConfigClient(t, WithRequiredLabels("repo", "version", "revision"))
+	md, err = c.GetImageMetadata(cr.host+"/example/repo:one", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := cr.fetches(); n != 1 {
+		t.Errorf("config fetched %d times; want once, since a required label is missing", n)
+	}
+	if md.Labels["other"] != "o" {
+		t.Errorf("got labels %v; want those of the config too", md.Labels)
+	}
+}
+
+func TestGetImageMetadata_Schema1Config(t *testing.T) {
+	history := func(v V1Schema) schema1.History {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return schema1.History{V1Compatibility: string(b)}
+	}
+	m := schema1.Manifest{
+		Name: "example/repo",
+		Tag:  "one",
+		History: []schema1.History{
+			history(V1Schema{
+				CC:     ContainerConfig{Labels: map[string]string{"a": "container"}},
+				Config: ContainerConfig{Labels: map[string]string{"a": "config", "b": "config"}},
+			}),
+			history(V1Schema{CC: ContainerConfig{Labels: map[string]string{"c": "base"}}}),
+		},
+	}
+	m.SchemaVersion = 1
+	key, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm, err := schema1.Sign(&m, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := sm.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", schema1.MediaTypeSignedManifest)
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(sm.Canonical).String())
+		w.Write(body)
+	}))
+	defer srv.Close()
+	c := testConfigClient(t)
+
+	md, err := c.GetImageMetadata(strings.TrimPrefix(srv.URL, "https://")+"/example/repo:one", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"a": "config", "b": "config", "c": "base"}
+	if !reflect.DeepEqual(md.Labels, want) {
+		t.Errorf("got labels %v; want %v", md.Labels, want)
+	}
+}
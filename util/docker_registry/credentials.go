@@ -0,0 +1,168 @@
+package docker_registry
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+type (
+	// Credentials authenticate the client to a registry.
+	Credentials struct {
+		Username, Password string
+		// IdentityToken, if set, is an OAuth refresh token used instead of
+		// the username and password to get bearer tokens.
+		IdentityToken string
+	}
+
+	// credentialStore resolves the credentials for each registry host:
+	// those set with SetCredentials, and otherwise those in the Docker
+	// config file, which is read once, when first needed.
+	credentialStore struct {
+		sync.Mutex
+		configPath string
+		overrides  map[string]Credentials
+		config     *dockerConfig
+	}
+
+	// dockerConfig is the part of ~/.docker/config.json about credentials.
+	dockerConfig struct {
+		Auths map[string]struct {
+			Auth          string `json:"auth"`
+			IdentityToken string `json:"identitytoken"`
+		} `json:"auths"`
+		CredHelpers map[string]string `json:"credHelpers"`
+		CredsStore  string            `json:"credsStore"`
+	}
+)
+
+func newCredentialStore() *credentialStore {
+	return &credentialStore{
+		configPath: dockerConfigPath(),
+		overrides:  map[string]Credentials{},
+	}
+}
+
+// dockerConfigPath is where docker keeps its config file: in $DOCKER_CONFIG
+// if it's set, otherwise ~/.docker.
+func dockerConfigPath() string {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".docker")
+	}
+	return filepath.Join(dir, "config.json")
+}
+
+// set overrides the credentials for host.
+func (cs *credentialStore) set(host string, creds Credentials) {
+	cs.Lock()
+	defer cs.Unlock()
+	cs.overrides[normalizeRegistryHost(host)] = creds
+}
+
+// credentials returns the credentials for host, or false if there are none,
+// in which case requests to it are anonymous.
+func (cs *credentialStore) credentials(host string) (Credentials, bool, error) {
+	host = normalizeRegistryHost(host)
+	cs.Lock()
+	if creds, ok := cs.overrides[host]; ok {
+		cs.Unlock()
+		return creds, true, nil
+	}
+	if cs.config == nil {
+		cs.config = &dockerConfig{}
+		if err := readDockerConfig(cs.configPath, cs.config); err != nil {
+			cs.Unlock()
+			return Credentials{}, false, err
+		}
+	}
+	config := cs.config
+	cs.Unlock()
+
+	if helper, ok := config.CredHelpers[host]; ok {
+		return credentialsFromHelper(helper, host)
+	}
+	for key, auth := range config.Auths {
+		if normalizeRegistryHost(key) != host {
+			continue
+		}
+		creds := Credentials{IdentityToken: auth.IdentityToken}
+		if auth.Auth != "" {
+			b, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return Credentials{}, false, fmt.Errorf("bad auth for %s in docker config: %s", key, err)
+			}
+			parts := strings.SplitN(string(b), ":", 2)
+			if len(parts) != 2 {
+				return Credentials{}, false, fmt.Errorf("bad auth for %s in docker config: no password", key)
+			}
+			creds.Username, creds.Password = parts[0], parts[1]
+		}
+		return creds, true, nil
+	}
+	if config.CredsStore != "" {
+		return credentialsFromHelper(config.CredsStore, host)
+	}
+	return Credentials{}, false, nil
+}
+
+func readDockerConfig(path string, config *dockerConfig) error {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, config); err != nil {
+		return fmt.Errorf("unable to parse docker config %s: %s", path, err)
+	}
+	return nil
+}
+
+// credentialsFromHelper asks the docker credential helper named helper,
+// which is the binary docker-credential-<helper>, for the credentials for
+// host.
+func credentialsFromHelper(helper, host string) (Credentials, bool, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(host)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// Helpers say so on stdout when they have nothing for a host.
+		if strings.Contains(string(out), "credentials not found") {
+			return Credentials{}, false, nil
+		}
+		return Credentials{}, false, fmt.Errorf("docker-credential-%s: %s: %s",
+			helper, err, strings.TrimSpace(stderr.String()+string(out)))
+	}
+	var resp struct {
+		Username, Secret string
+	}
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return Credentials{}, false, fmt.Errorf("docker-credential-%s: %s", helper, err)
+	}
+	// Helpers return identity tokens with this username.
+	if resp.Username == "<token>" {
+		return Credentials{IdentityToken: resp.Secret}, true, nil
+	}
+	return Credentials{Username: resp.Username, Password: resp.Secret}, true, nil
+}
+
+// normalizeRegistryHost reduces the keys of the docker config, which may be
+// URLs like https://index.docker.io/v1/, to host names.
+func normalizeRegistryHost(host string) string {
+	host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+	return host
+}
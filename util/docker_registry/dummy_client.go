@@ -1,5 +1,7 @@
 package docker_registry
 
+import "golang.org/x/net/context"
+
 type mdChan chan Metadata
 type tChan chan []string
 
@@ -23,11 +25,27 @@ func (drc *DummyRegistryClient) Cancel() {}
 // BecomeFoolishlyTrusting fulfills part of Client
 func (drc *DummyRegistryClient) BecomeFoolishlyTrusting() {}
 
+// SetCredentials fulfills part of Client
+func (drc *DummyRegistryClient) SetCredentials(host string, creds Credentials) {}
+
+// SetInstrumentation fulfills part of Client
+func (drc *DummyRegistryClient) SetInstrumentation(i Instrumentation) {}
+
 // GetImageMetadata fulfills part of Client
 func (drc *DummyRegistryClient) GetImageMetadata(in, et string) (Metadata, error) {
 	return <-drc.mds, nil
 }
 
+// GetImageMetadataContext fulfills part of Client
+func (drc *DummyRegistryClient) GetImageMetadataContext(ctx context.Context, in, et string) (Metadata, error) {
+	return drc.GetImageMetadata(in, et)
+}
+
+// AllTagsContext fulfills part of Client
+func (drc *DummyRegistryClient) AllTagsContext(ctx context.Context, rn string) ([]string, error) {
+	return drc.AllTags(rn)
+}
+
 // AllTags fulfills part of Client
 func (drc *DummyRegistryClient) AllTags(rn string) ([]string, error) {
 	return <-drc.ts, nil
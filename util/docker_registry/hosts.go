@@ -0,0 +1,142 @@
+package docker_registry
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxHosts is the most registry hosts a client keeps connections
+	// and tokens for at once, unless it's built with WithMaxHosts.
+	DefaultMaxHosts = 16
+	// DefaultIdleTimeout is how long a client keeps the connections and
+	// tokens for a registry host it isn't using, unless it's built with
+	// WithIdleTimeout.
+	DefaultIdleTimeout = 5 * time.Minute
+
+	// maxIdleConnsPerHost is raised from net/http's default of 2, so that
+	// concurrent lookups against one registry reuse their connections
+	// rather than handshaking afresh.
+	maxIdleConnsPerHost = 16
+)
+
+type (
+	// hostClient is everything a client keeps for one registry host: the
+	// registry's base URL, its transport with the connections to it, and
+	// its auth tokens.
+	hostClient struct {
+		reg *registry
+		// xport is the transport made for this host, or nil if it was given
+		// to the client with WithTransport.
+		xport    *http.Transport
+		lastUsed time.Time
+	}
+
+	// hostClients caches a hostClient for each registry host in use, up to
+	// max of them, dropping those idle for longer than idle.
+	hostClients struct {
+		sync.Mutex
+		clients map[string]*hostClient
+		max     int
+		idle    time.Duration
+	}
+)
+
+func newHostClients() *hostClients {
+	return &hostClients{
+		clients: map[string]*hostClient{},
+		max:     DefaultMaxHosts,
+		idle:    DefaultIdleTimeout,
+	}
+}
+
+// get returns the hostClient for host, calling create to make one if there
+// isn't one already.
+func (hc *hostClients) get(host string, create func() (*hostClient, error)) (*registry, error) {
+	hc.Lock()
+	defer hc.Unlock()
+	now := time.Now()
+	hc.evictIdle(now)
+	if c, ok := hc.clients[host]; ok {
+		c.lastUsed = now
+		return c.reg, nil
+	}
+	c, err := create()
+	if err != nil {
+		return nil, err
+	}
+	if hc.max > 0 {
+		for len(hc.clients) >= hc.max {
+			hc.evictLeastRecent()
+		}
+	}
+	c.lastUsed = now
+	hc.clients[host] = c
+	return c.reg, nil
+}
+
+// flush drops every hostClient, so that they're made anew with the
+// client's current configuration.
+func (hc *hostClients) flush() {
+	hc.Lock()
+	defer hc.Unlock()
+	for host := range hc.clients {
+		hc.evict(host)
+	}
+}
+
+func (hc *hostClients) len() int {
+	hc.Lock()
+	defer hc.Unlock()
+	return len(hc.clients)
+}
+
+func (hc *hostClients) evictIdle(now time.Time) {
+	if hc.idle <= 0 {
+		return
+	}
+	for host, c := range hc.clients {
+		if now.Sub(c.lastUsed) > hc.idle {
+			hc.evict(host)
+		}
+	}
+}
+
+func (hc *hostClients) evictLeastRecent() {
+	oldest := ""
+	for host, c := range hc.clients {
+		if oldest == "" || c.lastUsed.Before(hc.clients[oldest].lastUsed) {
+			oldest = host
+		}
+	}
+	hc.evict(oldest)
+}
+
+func (hc *hostClients) evict(host string) {
+	if c := hc.clients[host]; c != nil && c.xport != nil {
+		c.xport.CloseIdleConnections()
+	}
+	delete(hc.clients, host)
+}
+
+// newHostClient makes the hostClient for regHost.
+func (c *liveClient) newHostClient(regHost string) (*hostClient, error) {
+	scheme := "https"
+	if c.insecure[regHost] {
+		scheme = "http"
+	}
+	rt, owned := c.transportFor(regHost)
+	rt = &instrumentedTransport{base: rt, in: c.instruments}
+	reg, err := newRegistry(fmt.Sprintf("%s://%s", scheme, regHost),
+		newAuthTransport(rt, c.creds, newTokenCache()))
+	if err != nil {
+		return nil, err
+	}
+	reg.host = regHost
+	reg.client.Timeout = c.timeout
+	reg.maxRetryWait = c.maxRetryWait
+	reg.log = c.log.With("registry", regHost)
+	return &hostClient{reg: reg, xport: owned}, nil
+}
@@ -0,0 +1,128 @@
+package docker_registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHostClients_Reused(t *testing.T) {
+	c := newLiveClient()
+	first, err := c.registryForHostname("one.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := c.registryForHostname("one.example.com")
+	if first != again {
+		t.Error("a new registry was made for a host already in use")
+	}
+	other, _ := c.registryForHostname("two.example.com")
+	if other == first {
+		t.Error("two hosts share a registry")
+	}
+}
+
+func TestHostClients_MaxHosts(t *testing.T) {
+	c := newLiveClient()
+	WithMaxHosts(2)(c)
+	one, _ := c.registryForHostname("one.example.com")
+	c.registryForHostname("two.example.com")
+	c.registryForHostname("one.example.com")
+	c.registryForHostname("three.example.com")
+	if n := c.hosts.len(); n != 2 {
+		t.Errorf("got %d hosts; want 2", n)
+	}
+	// two was used least recently, so one is still there.
+	if again, _ := c.registryForHostname("one.example.com"); again != one {
+		t.Error("the most recently used host was evicted")
+	}
+}
+
+func TestHostClients_IdleTimeout(t *testing.T) {
+	c := newLiveClient()
+	WithIdleTimeout(time.Millisecond)(c)
+	one, _ := c.registryForHostname("one.example.com")
+	time.Sleep(5 * time.Millisecond)
+	c.registryForHostname("two.example.com")
+	if n := c.hosts.len(); n != 1 {
+		t.Errorf("got %d hosts; want the idle one dropped", n)
+	}
+	if again, _ := c.registryForHostname("one.example.com"); again == one {
+		t.Error("an idle host's registry was reused")
+	}
+}
+
+// benchmarkRegistry serves a schema 2 image for any tag of example/repo,
+// counting the TLS connections made to it.
+func benchmarkRegistry() (*httptest.Server, *int32) {
+	conns := int32(0)
+	digest, config := testDigest('a'), testDigest('b')
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/v2/example/repo/blobs/") {
+			fmt.Fprint(w, `{"config": {"Labels": {"a": "b"}}}`)
+			return
+		}
+		w.Header().Set("Content-Type", mediaTypeManifestV2)
+		w.Header().Set("Docker-Content-Digest", digest)
+		fmt.Fprintf(w, `{"config": {"digest": %q}}`, config)
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.StartTLS()
+	return srv, &conns
+}
+
+// BenchmarkMetadata fetches the metadata for 200 tags on one registry,
+// with one client reusing its connections to the host, and, as it was
+// before clients kept them, with new connections for each image.
+func BenchmarkMetadata(b *testing.B) {
+	srv, conns := benchmarkRegistry()
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "https://")
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	newClient := func(b *testing.B) Client {
+		c, err := NewClientWithOptions(WithTLSConfig("", &tls.Config{RootCAs: pool}))
+		if err != nil {
+			b.Fatal(err)
+		}
+		return c
+	}
+	fetch := func(b *testing.B, c Client, i int) {
+		if _, err := c.GetImageMetadata(fmt.Sprintf("%s/example/repo:1.0.%d", host, i), ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.Run("Reused", func(b *testing.B) {
+		atomic.StoreInt32(conns, 0)
+		for n := 0; n < b.N; n++ {
+			c := newClient(b)
+			for i := 0; i < 200; i++ {
+				fetch(b, c, i)
+			}
+		}
+		b.ReportMetric(float64(atomic.LoadInt32(conns))/float64(b.N), "conns/op")
+	})
+	b.Run("PerImage", func(b *testing.B) {
+		atomic.StoreInt32(conns, 0)
+		for n := 0; n < b.N; n++ {
+			for i := 0; i < 200; i++ {
+				c := newClient(b)
+				fetch(b, c, i)
+				c.(*liveClient).hosts.flush()
+			}
+		}
+		b.ReportMetric(float64(atomic.LoadInt32(conns))/float64(b.N), "conns/op")
+	})
+}
@@ -0,0 +1,113 @@
+package docker_registry
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Instrumentation is told about every HTTP request a client makes,
+	// including those to get auth tokens and those retried.
+	Instrumentation interface {
+		// RequestStarted is called before each request is sent.
+		RequestStarted(method, host, path string)
+		// RequestCompleted is called when the response to each request has
+		// arrived, or the request has failed, in which case status is 0.
+		// fromCache is true if the registry said what we had cached, by
+		// etag, was still current.
+		RequestCompleted(method, host, path string, status int, duration time.Duration, fromCache bool)
+	}
+
+	// instruments are the Instrumentation and debug logger of a client,
+	// which can be set after its transports have been made.
+	instruments struct {
+		sync.RWMutex
+		hook  Instrumentation
+		debug *log.Logger
+	}
+
+	// instrumentedTransport reports each request it sends to instruments.
+	instrumentedTransport struct {
+		base http.RoundTripper
+		in   *instruments
+	}
+)
+
+// redactedHeaders are the headers whose values aren't written to the debug
+// log.
+var redactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+}
+
+// SetInstrumentation has the client call the methods of i around every HTTP
+// request it makes. A nil i stops it.
+func (c *liveClient) SetInstrumentation(i Instrumentation) {
+	c.instruments.Lock()
+	defer c.instruments.Unlock()
+	c.instruments.hook = i
+}
+
+func (in *instruments) get() (Instrumentation, *log.Logger) {
+	in.RLock()
+	defer in.RUnlock()
+	return in.hook, in.debug
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	hook, debug := t.in.get()
+	if hook == nil && debug == nil {
+		return t.base.RoundTrip(req)
+	}
+	method, host, path := req.Method, req.URL.Host, req.URL.Path
+	if hook != nil {
+		hook.RequestStarted(method, host, path)
+	}
+	if debug != nil {
+		debug.Printf("registry request: %s %s%s", method, req.URL, formatHeaders(req.Header))
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	duration := time.Since(start)
+
+	status := 0
+	if err == nil {
+		status = resp.StatusCode
+	}
+	if hook != nil {
+		hook.RequestCompleted(method, host, path, status, duration, status == http.StatusNotModified)
+	}
+	if debug != nil {
+		if err != nil {
+			debug.Printf("registry error: %s %s after %s: %s", method, req.URL, duration, err)
+		} else {
+			debug.Printf("registry response: %s %s after %s: %s%s",
+				method, req.URL, duration, resp.Status, formatHeaders(resp.Header))
+		}
+	}
+	return resp, err
+}
+
+// formatHeaders writes h one header a line, in order, with secrets redacted.
+func formatHeaders(h http.Header) string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := []string{}
+	for _, name := range names {
+		value := strings.Join(h[name], ", ")
+		if redactedHeaders[http.CanonicalHeaderKey(name)] {
+			value = "[redacted]"
+		}
+		lines = append(lines, "\n  "+name+": "+value)
+	}
+	return strings.Join(lines, "")
+}
@@ -0,0 +1,93 @@
+package docker_registry
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/digest"
+)
+
+type recordedRequest struct {
+	method, host, path string
+	status             int
+	fromCache          bool
+}
+
+type recordingInstrumentation struct {
+	started   int
+	completed []recordedRequest
+}
+
+func (ri *recordingInstrumentation) RequestStarted(method, host, path string) {
+	ri.started++
+}
+
+func (ri *recordingInstrumentation) RequestCompleted(method, host, path string, status int, d time.Duration, fromCache bool) {
+	ri.completed = append(ri.completed, recordedRequest{method, host, path, status, fromCache})
+}
+
+func TestInstrumentation(t *testing.T) {
+	body := fmt.Sprintf(`{"config": {"digest": %q}}`, testDigest('b'))
+	digest := digest.FromBytes([]byte(body)).String()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("If-None-Match") == `"`+digest+`"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if strings.Contains(req.URL.Path, "/blobs/") {
+			fmt.Fprint(w, `{"config": {"Labels": {"a": "b"}}}`)
+			return
+		}
+		w.Header().Set("Content-Type", mediaTypeManifestV2)
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.Header().Set("Etag", `"`+digest+`"`)
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	ri := &recordingInstrumentation{}
+	debug := &bytes.Buffer{}
+	c, err := NewClientWithOptions(WithInsecureHTTP(host), WithInstrumentation(ri),
+		WithDebugLog(log.New(debug, "", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetCredentials(host, Credentials{Username: "user", Password: "secret"})
+
+	md, err := c.GetImageMetadata(host+"/example/repo:1.0.0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetImageMetadata(host+"/example/repo:1.0.0", md.Etag); err != ErrNotModified {
+		t.Fatalf("got %v; want ErrNotModified", err)
+	}
+
+	want := []recordedRequest{
+		{"GET", host, "/v2/example/repo/manifests/1.0.0", 200, false},
+		{"GET", host, "/v2/example/repo/blobs/" + testDigest('b'), 200, false},
+		{"GET", host, "/v2/example/repo/manifests/1.0.0", 304, true},
+	}
+	if ri.started != 3 || fmt.Sprint(ri.completed) != fmt.Sprint(want) {
+		t.Errorf("got %d started, completed %v; want %v", ri.started, ri.completed, want)
+	}
+	if !strings.Contains(debug.String(), "registry response: GET http://"+host+"/v2/example/repo/manifests/1.0.0") {
+		t.Errorf("debug log missing the response:\n%s", debug)
+	}
+}
+
+func TestFormatHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Authorization", "Bearer secret")
+	h.Set("Accept", "application/json")
+	got := formatHeaders(h)
+	if want := "\n  Accept: application/json\n  Authorization: [redacted]"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,268 @@
+package docker_registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest/schema1"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/client"
+	"golang.org/x/net/context"
+)
+
+// The media types of the manifests we understand beyond schema 1, which is
+// handled by docker/distribution.
+const (
+	mediaTypeManifestV2   = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIManifest  = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex     = "application/vnd.oci.image.index.v1+json"
+)
+
+var extraManifestMediaTypes = []string{
+	mediaTypeManifestV2,
+	mediaTypeManifestList,
+	mediaTypeOCIManifest,
+	mediaTypeOCIIndex,
+}
+
+type (
+	// Platform identifies one of the images in a multi-platform manifest list
+	// or OCI index.
+	Platform struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+		Variant      string `json:"variant,omitempty"`
+	}
+
+	descriptor struct {
+		MediaType string        `json:"mediaType"`
+		Digest    digest.Digest `json:"digest"`
+		Platform  *Platform     `json:"platform,omitempty"`
+	}
+
+	// manifestIndex is either a docker manifest list or an OCI index, which
+	// share a format.
+	manifestIndex struct {
+		Manifests []descriptor `json:"manifests"`
+	}
+
+	// imageManifest is either a docker schema 2 or an OCI image manifest,
+	// which also share a format as far as we're concerned.
+	imageManifest struct {
+		Config descriptor `json:"config"`
+		// Annotations are the labels of an OCI manifest itself, as opposed
+		// to those of the image config.
+		Annotations map[string]string `json:"annotations,omitempty"`
+	}
+
+	imageConfig struct {
+		Config ContainerConfig `json:"config"`
+	}
+
+	// rawManifest is a manifest as it came from the registry.
+	rawManifest struct {
+		mediaType string
+		body      []byte
+		headers   http.Header
+		// digest is the digest of body, which has been checked against the
+		// one the registry gave and the one asked for, if any.
+		digest digest.Digest
+	}
+
+	// DigestMismatch is returned when a manifest fetched from a registry
+	// doesn't have the digest it should, because it was corrupted by the
+	// registry or on its way from it.
+	DigestMismatch struct {
+		// Name is the name of the image whose manifest was fetched.
+		Name string
+		// Expected is the digest the manifest should have had, and Source
+		// says why, e.g. "the Docker-Content-Digest header".
+		Expected, Source string
+		// Actual is the digest of the manifest as fetched.
+		Actual string
+	}
+)
+
+func (dm DigestMismatch) Error() string {
+	return fmt.Sprintf("manifest for %s has digest %s, but %s says %s",
+		dm.Name, dm.Actual, dm.Source, dm.Expected)
+}
+
+// DefaultPlatform is the platform selected from manifest lists unless the
+// client is built with NewClientForPlatform.
+var DefaultPlatform = Platform{OS: "linux", Architecture: "amd64"}
+
+// ParsePlatform parses a platform written as os/architecture[/variant], e.g.
+// linux/arm64/v8.
+func ParsePlatform(s string) (Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("bad platform %q: want os/architecture[/variant]", s)
+	}
+	p := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+func (p Platform) String() string {
+	if p.Variant == "" {
+		return p.OS + "/" + p.Architecture
+	}
+	return p.OS + "/" + p.Architecture + "/" + p.Variant
+}
+
+// matches is true if p is the platform want. An unspecified variant in want
+// matches any.
+func (p Platform) matches(want Platform) bool {
+	return p.OS == want.OS && p.Architecture == want.Architecture &&
+		(want.Variant == "" || p.Variant == want.Variant)
+}
+
+func isIndex(mediaType string) bool {
+	return mediaType == mediaTypeManifestList || mediaType == mediaTypeOCIIndex
+}
+
+func isImageManifest(mediaType string) bool {
+	return mediaType == mediaTypeManifestV2 || mediaType == mediaTypeOCIManifest
+}
+
+// selectPlatform picks the manifest for want from a manifest list or OCI
+// index.
+func selectPlatform(body []byte, want Platform) (descriptor, error) {
+	var idx manifestIndex
+	if err := json.Unmarshal(body, &idx); err != nil {
+		return descriptor{}, err
+	}
+	available := []string{}
+	for _, m := range idx.Manifests {
+		if m.Platform == nil {
+			continue
+		}
+		if m.Platform.matches(want) {
+			return m, nil
+		}
+		available = append(available, m.Platform.String())
+	}
+	return descriptor{}, fmt.Errorf("no image for platform %s in manifest list; available: %s",
+		want, strings.Join(available, ", "))
+}
+
+// getManifest fetches the manifest for ref, which may be a tag or a digest,
+// without interpreting it.
+func (r *registry) getManifest(ctx context.Context, ref reference.Named, etag string) (rawManifest, error) {
+	u, err := r.ub.BuildManifestURL(ref)
+	if err != nil {
+		return rawManifest{}, err
+	}
+	req, err := r.getRequest(u, etag)
+	if err != nil {
+		return rawManifest{}, err
+	}
+	resp, err := r.do(ctx, req)
+	if err != nil {
+		return rawManifest{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return rawManifest{}, distribution.ErrManifestNotModified
+	}
+	if !client.SuccessStatus(resp.StatusCode) {
+		return rawManifest{}, client.HandleErrorResponse(resp)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return rawManifest{}, err
+	}
+	mt := resp.Header.Get("Content-Type")
+	if i := strings.Index(mt, ";"); i >= 0 {
+		mt = strings.TrimSpace(mt[:i])
+	}
+	raw := rawManifest{mediaType: mt, body: body, headers: resp.Header}
+	if err := raw.verify(ref); err != nil {
+		return rawManifest{}, err
+	}
+	return raw, nil
+}
+
+// verify checks the digest of the manifest against the Docker-Content-Digest
+// header, and against the digest in ref, if it has one, and sets m.digest.
+func (m *rawManifest) verify(ref reference.Named) error {
+	content := m.body
+	// The digest of a signed schema 1 manifest is of its payload, without
+	// the signatures.
+	if !isIndex(m.mediaType) && !isImageManifest(m.mediaType) {
+		var sm schema1.SignedManifest
+		if err := json.Unmarshal(m.body, &sm); err == nil && len(sm.Canonical) > 0 {
+			content = sm.Canonical
+		}
+	}
+
+	m.digest = digest.FromBytes(content)
+	check := func(expected digest.Digest, source string) error {
+		if err := expected.Validate(); err != nil {
+			return fmt.Errorf("bad digest %q in %s for %s: %s", expected, source, ref, err)
+		}
+		actual := expected.Algorithm().FromBytes(content)
+		if actual != expected {
+			return DigestMismatch{Name: ref.String(), Expected: expected.String(),
+				Source: source, Actual: actual.String()}
+		}
+		m.digest = actual
+		return nil
+	}
+
+	if h := m.headers.Get("Docker-Content-Digest"); h != "" {
+		if err := check(digest.Digest(h), "the Docker-Content-Digest header"); err != nil {
+			return err
+		}
+	}
+	if dr, ok := ref.(reference.Digested); ok {
+		if err := check(dr.Digest(), "the image name"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configLabels fetches the config blob d of the image ref, and returns the
+// labels in it.
+func (r *registry) configLabels(ctx context.Context, ref reference.Named, d digest.Digest) (map[string]string, error) {
+	dr, err := digestRef(ref, d.String())
+	if err != nil {
+		return nil, err
+	}
+	u, err := r.ub.BuildBlobURL(dr)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if !client.SuccessStatus(resp.StatusCode) {
+		return nil, client.HandleErrorResponse(resp)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var config imageConfig
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, err
+	}
+	return config.Config.Labels, nil
+}
@@ -0,0 +1,239 @@
+package docker_registry
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/opentable/sous/util/logging"
+)
+
+// A ClientOption configures a client built with NewClientWithOptions.
+// Options that take a registry host apply only to requests to that host,
+// e.g. "registry.example.com:5000", or to every host if it is "".
+type ClientOption func(*liveClient) error
+
+// WithPlatform selects the image for p from multi-platform manifest lists.
+func WithPlatform(p Platform) ClientOption {
+	return func(c *liveClient) error {
+		c.platform = p
+		return nil
+	}
+}
+
+// WithTimeout limits each request to a registry to d, rather than
+// DefaultTimeout. Zero means no limit.
+func WithTimeout(d time.Duration) ClientOption {
+	return func(c *liveClient) error {
+		c.timeout = d
+		return nil
+	}
+}
+
+// WithMaxRetryWait waits at most d, rather than DefaultMaxRetryWait, before
+// trying a rate limited request again. If the registry asks for a longer
+// wait, a RateLimited error is returned straight away.
+func WithMaxRetryWait(d time.Duration) ClientOption {
+	return func(c *liveClient) error {
+		c.maxRetryWait = d
+		return nil
+	}
+}
+
+// WithMaxHosts keeps connections and tokens for at most n registry hosts at
+// once, rather than DefaultMaxHosts; those of the host used least recently
+// are dropped to make room. Zero means no limit.
+func WithMaxHosts(n int) ClientOption {
+	return func(c *liveClient) error {
+		c.hosts.max = n
+		return nil
+	}
+}
+
+// WithIdleTimeout drops the connections and tokens for a registry host once
+// it hasn't been used for d, rather than DefaultIdleTimeout. Zero means they
+// are kept until there are too many hosts.
+func WithIdleTimeout(d time.Duration) ClientOption {
+	return func(c *liveClient) error {
+		c.hosts.idle = d
+		return nil
+	}
+}
+
+// WithRequiredLabels skips fetching an image's config blob when its
+// manifest already carries every one of labels. By default the config blob
+// is always fetched, and its labels merged over the manifest's.
+func WithRequiredLabels(labels ...string) ClientOption {
+	return func(c *liveClient) error {
+		c.requiredLabels = labels
+		return nil
+	}
+}
+
+// WithMaxConfigs keeps the labels of at most n image config blobs, rather
+// than DefaultMaxConfigs, so that images sharing a config don't fetch it
+// again. Zero means none are kept.
+func WithMaxConfigs(n int) ClientOption {
+	return func(c *liveClient) error {
+		c.configs.max = n
+		return nil
+	}
+}
+
+// WithInstrumentation has the client call the methods of i around every
+// HTTP request it makes.
+func WithInstrumentation(i Instrumentation) ClientOption {
+	return func(c *liveClient) error {
+		c.SetInstrumentation(i)
+		return nil
+	}
+}
+
+// WithDebugLog logs every request the client makes, and the response, with
+// their headers, to l. Credentials are redacted.
+func WithDebugLog(l *log.Logger) ClientOption {
+	return func(c *liveClient) error {
+		c.instruments.debug = l
+		return nil
+	}
+}
+
+// WithLogger logs what the client does to l, with the image name or
+// registry host concerned as context. By default nothing is logged.
+func WithLogger(l logging.Logger) ClientOption {
+	return func(c *liveClient) error {
+		c.log = logging.ForSubsystem(l, logging.Registry)
+		return nil
+	}
+}
+
+// WithTLSConfig uses config for TLS connections to host.
+func WithTLSConfig(host string, config *tls.Config) ClientOption {
+	return func(c *liveClient) error {
+		if host == "" {
+			c.xport.TLSClientConfig = config
+			return nil
+		}
+		c.tlsConfigs[host] = config
+		return nil
+	}
+}
+
+// WithCABundle trusts the certificates in the PEM file at path, as well as
+// the system's, for TLS connections to host.
+func WithCABundle(host, path string) ClientOption {
+	return func(c *liveClient) error {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("unable to read CA bundle for %s: %s", describeHost(host), err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return fmt.Errorf("no certificates in CA bundle %s for %s", path, describeHost(host))
+		}
+		return WithTLSConfig(host, &tls.Config{RootCAs: pool})(c)
+	}
+}
+
+// WithInsecureHTTP speaks plain HTTP, rather than HTTPS, to host, as for a
+// development registry.
+func WithInsecureHTTP(host string) ClientOption {
+	return func(c *liveClient) error {
+		if host == "" {
+			return fmt.Errorf("insecure HTTP can only be allowed for a named registry host")
+		}
+		c.insecure[host] = true
+		return nil
+	}
+}
+
+// WithTransport sends requests to host with rt, in place of the client's
+// own transport. Other options for the same host are then ignored, apart
+// from WithInsecureHTTP.
+func WithTransport(host string, rt http.RoundTripper) ClientOption {
+	return func(c *liveClient) error {
+		c.transports[host] = rt
+		return nil
+	}
+}
+
+func describeHost(host string) string {
+	if host == "" {
+		return "all registries"
+	}
+	return "registry " + host
+}
+
+// transportFor returns the transport for requests to host, and if it was
+// made for host, rather than given with WithTransport, returns it as owned
+// too.
+func (c *liveClient) transportFor(host string) (rt http.RoundTripper, owned *http.Transport) {
+	rt, ok := c.transports[host]
+	if !ok {
+		rt, ok = c.transports[""]
+	}
+	if !ok {
+		owned = c.xport.Clone()
+		owned.MaxIdleConnsPerHost = maxIdleConnsPerHost
+		if config, ok := c.tlsConfigs[host]; ok {
+			owned.TLSClientConfig = config
+		}
+		rt = owned
+	}
+	if c.insecure[host] {
+		return &insecureTransport{host: host, base: rt}, owned
+	}
+	return rt, owned
+}
+
+// insecureTransport explains the errors got when a registry that is spoken
+// to with plain HTTP turns out to serve HTTPS.
+type insecureTransport struct {
+	host string
+	base http.RoundTripper
+}
+
+// httpsResponse is how Go servers, amongst others, answer plain HTTP sent
+// to a TLS port.
+const httpsResponse = "Client sent an HTTP request to an HTTPS server"
+
+// RoundTrip implements http.RoundTripper
+func (t *insecureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		// A TLS alert record starts \x15\x03.
+		if strings.Contains(err.Error(), `malformed HTTP response "\x15\x03`) {
+			return nil, t.misconfigured()
+		}
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusBadRequest || req.URL.Host != t.host {
+		return resp, nil
+	}
+	body := bufio.NewReader(resp.Body)
+	peek, _ := body.Peek(len(httpsResponse))
+	if string(peek) == httpsResponse {
+		resp.Body.Close()
+		return nil, t.misconfigured()
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{body, resp.Body}
+	return resp, nil
+}
+
+func (t *insecureTransport) misconfigured() error {
+	return fmt.Errorf("registry %s is configured for insecure HTTP, but serves HTTPS; "+
+		"remove it from the insecure registries", t.host)
+}
@@ -0,0 +1,103 @@
+package docker_registry
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func testTagsHandler(w http.ResponseWriter, req *http.Request) {
+	fmt.Fprint(w, `{"tags": ["1.0.0"]}`)
+}
+
+func TestWithCABundle(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(testTagsHandler))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "https://")
+
+	f, err := ioutil.TempFile("", "ca-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	f.Close()
+
+	c, err := NewClientWithOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.AllTags(host + "/example/repo:latest"); err == nil {
+		t.Error("expected an error from a registry with an untrusted certificate")
+	}
+
+	c, err = NewClientWithOptions(WithCABundle(host, f.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags, err := c.AllTags(host + "/example/repo:latest"); err != nil || len(tags) != 1 {
+		t.Errorf("got %v, %v", tags, err)
+	}
+
+	if _, err := NewClientWithOptions(WithCABundle(host, "/nonexistent.pem")); err == nil {
+		t.Error("expected an error for a missing CA bundle")
+	}
+}
+
+func TestWithInsecureHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(testTagsHandler))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	c, err := NewClientWithOptions(WithInsecureHTTP(host))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags, err := c.AllTags(host + "/example/repo:latest"); err != nil || len(tags) != 1 {
+		t.Errorf("got %v, %v", tags, err)
+	}
+
+	tlsSrv := httptest.NewTLSServer(http.HandlerFunc(testTagsHandler))
+	defer tlsSrv.Close()
+	tlsHost := strings.TrimPrefix(tlsSrv.URL, "https://")
+	c, err = NewClientWithOptions(WithInsecureHTTP(tlsHost))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.AllTags(tlsHost + "/example/repo:latest")
+	if err == nil || !strings.Contains(err.Error(), "registry "+tlsHost+" is configured for insecure HTTP") {
+		t.Errorf("got error %v; want one explaining the misconfiguration", err)
+	}
+}
+
+type countingTransport struct {
+	requests int
+}
+
+func (ct *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ct.requests++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestWithTransport(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(testTagsHandler))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "https://")
+
+	ct := &countingTransport{}
+	c, err := NewClientWithOptions(WithTransport(host, srv.Client().Transport), WithTransport("", ct))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.AllTags(host + "/example/repo:latest"); err != nil {
+		t.Error(err)
+	}
+	if ct.requests != 0 {
+		t.Errorf("the default transport was used for a host with its own")
+	}
+}
@@ -0,0 +1,83 @@
+package docker_registry
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// DefaultTimeout limits each request to a registry, unless the client
+	// is built with WithTimeout.
+	DefaultTimeout = 30 * time.Second
+	// DefaultMaxRetryWait is the longest a rate limited request waits to be
+	// tried again, unless the client is built with WithMaxRetryWait.
+	DefaultMaxRetryWait = 30 * time.Second
+
+	// defaultRetryAfter is how long to wait before trying a rate limited
+	// request again if the registry doesn't say.
+	defaultRetryAfter = time.Second
+	// rateLimitRetries is how many times a rate limited request is tried
+	// again before giving up.
+	rateLimitRetries = 2
+)
+
+// RateLimited is returned when a registry keeps refusing requests with 429
+// Too Many Requests, or asks us to wait longer than we're willing to. The
+// request might succeed if tried again after RetryAfter.
+type RateLimited struct {
+	Host       string
+	RetryAfter time.Duration
+}
+
+func (rl RateLimited) Error() string {
+	return fmt.Sprintf("registry %s is rate limiting requests; retry after %s", rl.Host, rl.RetryAfter)
+}
+
+// do sends req, and if the registry answers 429 Too Many Requests, waits as
+// long as its Retry-After header asks, up to r.maxRetryWait, before trying
+// again.
+func (r *registry) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	for tries := 0; ; tries++ {
+		resp, err := r.client.Do(req.WithContext(ctx))
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+			return resp, err
+		}
+		resp.Body.Close()
+
+		wait := retryAfter(resp.Header.Get("Retry-After"), time.Now())
+		if tries == rateLimitRetries || wait > r.maxRetryWait {
+			return nil, RateLimited{Host: r.host, RetryAfter: wait}
+		}
+		r.log.Debugf("Rate limited requesting %s; retrying after %s", req.URL.Path, wait)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// retryAfter interprets a Retry-After header, which is either a number of
+// seconds or an HTTP date, as of now.
+func retryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return defaultRetryAfter
+	}
+	if secs, err := strconv.Atoi(header); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		if wait := t.Sub(now); wait > 0 {
+			return wait
+		}
+		return 0
+	}
+	return defaultRetryAfter
+}
@@ -0,0 +1,122 @@
+package docker_registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// testRateLimitedRegistry refuses the first limited requests for tags with
+// a 429 and the Retry-After header retry.
+func testRateLimitedRegistry(limited int, retry string) (*httptest.Server, *int) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		if requests <= limited {
+			w.Header().Set("Retry-After", retry)
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, `{"tags": ["1.0.0"]}`)
+	}))
+	return srv, &requests
+}
+
+func testInsecureClient(t *testing.T, srv *httptest.Server, opts ...ClientOption) (Client, string) {
+	host := strings.TrimPrefix(srv.URL, "http://")
+	c, err := NewClientWithOptions(append(opts, WithInsecureHTTP(host))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, host + "/example/repo:latest"
+}
+
+func TestRateLimited_RetryAfter(t *testing.T) {
+	srv, requests := testRateLimitedRegistry(1, "0")
+	defer srv.Close()
+	c, repo := testInsecureClient(t, srv)
+
+	tags, err := c.AllTags(repo)
+	if err != nil || len(tags) != 1 {
+		t.Fatalf("got %v, %v", tags, err)
+	}
+	if *requests != 2 {
+		t.Errorf("got %d requests; want 2", *requests)
+	}
+}
+
+func TestRateLimited_GivesUp(t *testing.T) {
+	srv, requests := testRateLimitedRegistry(100, "0")
+	defer srv.Close()
+	c, repo := testInsecureClient(t, srv)
+
+	_, err := c.AllTags(repo)
+	if _, ok := err.(RateLimited); !ok {
+		t.Errorf("got error %v; want RateLimited", err)
+	}
+	if *requests != rateLimitRetries+1 {
+		t.Errorf("got %d requests; want %d", *requests, rateLimitRetries+1)
+	}
+
+	// A wait longer than the cap isn't waited for at all.
+	srv, requests = testRateLimitedRegistry(1, "3600")
+	defer srv.Close()
+	c, repo = testInsecureClient(t, srv, WithMaxRetryWait(time.Minute))
+	_, err = c.AllTags(repo)
+	if rl, ok := err.(RateLimited); !ok || rl.RetryAfter != time.Hour {
+		t.Errorf("got error %v; want RateLimited for an hour", err)
+	}
+	if *requests != 1 {
+		t.Errorf("got %d requests; want 1", *requests)
+	}
+}
+
+func TestRateLimited_Cancelled(t *testing.T) {
+	srv, _ := testRateLimitedRegistry(1, "10")
+	defer srv.Close()
+	c, repo := testInsecureClient(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.AllTagsContext(ctx, repo)
+	if err != context.DeadlineExceeded {
+		t.Errorf("got error %v; want the context's", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("waited out the Retry-After despite the context ending")
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(time.Second)
+	}))
+	defer srv.Close()
+	c, repo := testInsecureClient(t, srv, WithTimeout(50*time.Millisecond))
+
+	if _, err := c.AllTags(repo); err == nil {
+		t.Error("expected a timeout")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2016, 10, 1, 12, 0, 0, 0, time.UTC)
+	for header, want := range map[string]time.Duration{
+		"":                              defaultRetryAfter,
+		"120":                           2 * time.Minute,
+		"-1":                            0,
+		"Sat, 01 Oct 2016 12:00:30 GMT": 30 * time.Second,
+		"Sat, 01 Oct 2016 11:00:00 GMT": 0,
+		"soon":                          defaultRetryAfter,
+	} {
+		if got := retryAfter(header, now); got != want {
+			t.Errorf("retryAfter(%q) = %s; want %s", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+// Package registrytest provides an in-memory docker registry client, for
+// testing code built on docker_registry.Client, such as the NameCache.
+package registrytest
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/opentable/sous/util/docker_registry"
+	"golang.org/x/net/context"
+)
+
+type (
+	// Fake is a docker_registry.Client that serves images added to it,
+	// rather than querying a registry. It behaves as a client talking to a
+	// real registry would: images are named by tag or digest, each image's
+	// etag is derived from its digest, and GetImageMetadata with the etag an
+	// image currently has returns docker_registry.ErrNotModified.
+	Fake struct {
+		sync.Mutex
+		// repos are the images in each repository, e.g.
+		// docker.example.com/ot/sous
+		repos map[string]*repo
+		// failures are errors to return from the next calls of each method.
+		failures map[Call][]error
+		latency  map[Call]time.Duration
+		calls    map[Call]int
+	}
+
+	repo struct {
+		tags   map[string]string
+		images map[string]Image
+	}
+
+	// Image is an image held by a Fake.
+	Image struct {
+		Labels map[string]string
+		Digest string
+		Etag   string
+	}
+
+	// Call names the Fake's methods that errors and latency can be injected
+	// into.
+	Call string
+
+	// NotFound is returned when there is no image with the name asked for.
+	NotFound struct {
+		Name string
+	}
+)
+
+const (
+	// GetImageMetadata is any call for the metadata of an image, including
+	// LabelsForImageName.
+	GetImageMetadata Call = "GetImageMetadata"
+	// AllTags is any call for the tags of a repository.
+	AllTags Call = "AllTags"
+)
+
+var _ docker_registry.Client = &Fake{}
+
+func (nf NotFound) Error() string {
+	return fmt.Sprintf("no image named %s in the registry", nf.Name)
+}
+
+// NotFound is always true, so that docker_registry.IsNotFound recognises nf.
+func (nf NotFound) NotFound() bool { return true }
+
+// NewFake returns an empty Fake.
+func NewFake() *Fake {
+	return &Fake{
+		repos:    map[string]*repo{},
+		failures: map[Call][]error{},
+		latency:  map[Call]time.Duration{},
+		calls:    map[Call]int{},
+	}
+}
+
+// Add pushes an image with labels as name, which must include a tag, and
+// returns its digest. Adding an image with different labels under a tag
+// already in use moves the tag to the new image, as a push would.
+func (f *Fake) Add(name string, labels map[string]string) (string, error) {
+	ref, err := reference.ParseNamed(name)
+	if err != nil {
+		return "", err
+	}
+	tagged, ok := ref.(reference.Tagged)
+	if !ok {
+		return "", fmt.Errorf("can't add %s: it has no tag", name)
+	}
+	copied := map[string]string{}
+	for k, v := range labels {
+		copied[k] = v
+	}
+	b, err := json.Marshal(copied)
+	if err != nil {
+		return "", err
+	}
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(append([]byte(ref.Name()+"\n"), b...)))
+
+	f.Lock()
+	defer f.Unlock()
+	r := f.repo(ref.Name())
+	r.images[digest] = Image{Labels: copied, Digest: digest, Etag: `"` + digest + `"`}
+	r.tags[tagged.Tag()] = digest
+	return digest, nil
+}
+
+// Retag makes to, which must include a tag, name the image from names. The
+// two may be in different repositories.
+func (f *Fake) Retag(from, to string) error {
+	toRef, err := reference.ParseNamed(to)
+	if err != nil {
+		return err
+	}
+	tagged, ok := toRef.(reference.Tagged)
+	if !ok {
+		return fmt.Errorf("can't retag as %s: it has no tag", to)
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	img, err := f.lookup(from)
+	if err != nil {
+		return err
+	}
+	r := f.repo(toRef.Name())
+	r.images[img.Digest] = img
+	r.tags[tagged.Tag()] = img.Digest
+	return nil
+}
+
+// Delete removes name. If name is a tag, only the tag is removed; if it's a
+// digest, the image is removed along with all its tags in that repository.
+func (f *Fake) Delete(name string) error {
+	ref, err := reference.ParseNamed(name)
+	if err != nil {
+		return err
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	img, err := f.lookup(name)
+	if err != nil {
+		return err
+	}
+	r := f.repos[ref.Name()]
+	if tagged, ok := ref.(reference.Tagged); ok {
+		delete(r.tags, tagged.Tag())
+		return nil
+	}
+	delete(r.images, img.Digest)
+	for tag, d := range r.tags {
+		if d == img.Digest {
+			delete(r.tags, tag)
+		}
+	}
+	return nil
+}
+
+// Lookup returns the image name names, if there is one.
+func (f *Fake) Lookup(name string) (Image, bool) {
+	f.Lock()
+	defer f.Unlock()
+	img, err := f.lookup(name)
+	return img, err == nil
+}
+
+// FailNext makes the next call of c return err instead. Errors queued for
+// the same call are returned by successive calls.
+func (f *Fake) FailNext(c Call, err error) {
+	f.Lock()
+	defer f.Unlock()
+	f.failures[c] = append(f.failures[c], err)
+}
+
+// SetLatency makes every call of c take at least d, unless its context is
+// done first.
+func (f *Fake) SetLatency(c Call, d time.Duration) {
+	f.Lock()
+	defer f.Unlock()
+	f.latency[c] = d
+}
+
+// Calls returns the number of times c has been called.
+func (f *Fake) Calls(c Call) int {
+	f.Lock()
+	defer f.Unlock()
+	return f.calls[c]
+}
+
+// LabelsForImageName implements docker_registry.Client
+func (f *Fake) LabelsForImageName(name string) (map[string]string, error) {
+	md, err := f.GetImageMetadata(name, "")
+	return md.Labels, err
+}
+
+// GetImageMetadata implements docker_registry.Client
+func (f *Fake) GetImageMetadata(name, etag string) (docker_registry.Metadata, error) {
+	return f.GetImageMetadataContext(context.Background(), name, etag)
+}
+
+// GetImageMetadataContext implements docker_registry.Client
+func (f *Fake) GetImageMetadataContext(ctx context.Context, name, etag string) (docker_registry.Metadata, error) {
+	if err := f.call(ctx, GetImageMetadata); err != nil {
+		return docker_registry.Metadata{}, err
+	}
+	ref, err := reference.ParseNamed(name)
+	if err != nil {
+		return docker_registry.Metadata{}, err
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	img, err := f.lookup(name)
+	if err != nil {
+		return docker_registry.Metadata{}, err
+	}
+	if etag != "" && etag == img.Etag {
+		return docker_registry.Metadata{}, docker_registry.ErrNotModified
+	}
+
+	cn := ref.Name() + "@" + img.Digest
+	md := docker_registry.Metadata{
+		Labels:        map[string]string{},
+		Etag:          img.Etag,
+		CanonicalName: cn,
+		AllNames:      []string{name},
+	}
+	if name != cn {
+		md.AllNames = append(md.AllNames, cn)
+	}
+	for k, v := range img.Labels {
+		md.Labels[k] = v
+	}
+	return md, nil
+}
+
+// AllTags implements docker_registry.Client
+func (f *Fake) AllTags(repoName string) ([]string, error) {
+	return f.AllTagsContext(context.Background(), repoName)
+}
+
+// AllTagsContext implements docker_registry.Client
+func (f *Fake) AllTagsContext(ctx context.Context, repoName string) ([]string, error) {
+	if err := f.call(ctx, AllTags); err != nil {
+		return nil, err
+	}
+	ref, err := reference.ParseNamed(repoName)
+	if err != nil {
+		return nil, err
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	r, ok := f.repos[ref.Name()]
+	if !ok {
+		return nil, NotFound{Name: ref.Name()}
+	}
+	tags := make([]string, 0, len(r.tags))
+	for tag := range r.tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// Cancel implements docker_registry.Client
+func (f *Fake) Cancel() {}
+
+// BecomeFoolishlyTrusting implements docker_registry.Client
+func (f *Fake) BecomeFoolishlyTrusting() {}
+
+// SetCredentials implements docker_registry.Client
+func (f *Fake) SetCredentials(host string, creds docker_registry.Credentials) {}
+
+// SetInstrumentation implements docker_registry.Client. The Fake makes no
+// HTTP requests, so it never calls i.
+func (f *Fake) SetInstrumentation(i docker_registry.Instrumentation) {}
+
+// call counts a call of c, waits out its latency, and returns the next
+// error injected for it, if any.
+func (f *Fake) call(ctx context.Context, c Call) error {
+	f.Lock()
+	f.calls[c]++
+	latency := f.latency[c]
+	var err error
+	if len(f.failures[c]) > 0 {
+		err = f.failures[c][0]
+		f.failures[c] = f.failures[c][1:]
+	}
+	f.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(latency):
+		}
+	}
+	return err
+}
+
+func (f *Fake) repo(name string) *repo {
+	r, ok := f.repos[name]
+	if !ok {
+		r = &repo{tags: map[string]string{}, images: map[string]Image{}}
+		f.repos[name] = r
+	}
+	return r
+}
+
+// lookup finds the image name names. f must be locked.
+func (f *Fake) lookup(name string) (Image, error) {
+	ref, err := reference.ParseNamed(name)
+	if err != nil {
+		return Image{}, err
+	}
+	r, ok := f.repos[ref.Name()]
+	if !ok {
+		return Image{}, NotFound{Name: name}
+	}
+	digest := ""
+	switch ref := ref.(type) {
+	case reference.Digested:
+		digest = ref.Digest().String()
+	case reference.Tagged:
+		digest = r.tags[ref.Tag()]
+	default:
+		return Image{}, fmt.Errorf("image name %s has neither tag nor digest", name)
+	}
+	img, ok := r.images[digest]
+	if !ok {
+		return Image{}, NotFound{Name: name}
+	}
+	return img, nil
+}
@@ -0,0 +1,85 @@
+package registrytest
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/opentable/sous/util/docker_registry"
+	"golang.org/x/net/context"
+)
+
+func TestFake_Metadata(t *testing.T) {
+	f := NewFake()
+	digest, err := f.Add("docker.example.com/ot/app:1.0.0", map[string]string{"a": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md, err := f.GetImageMetadata("docker.example.com/ot/app:1.0.0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cn := "docker.example.com/ot/app@" + digest
+	if md.CanonicalName != cn || md.Labels["a"] != "b" || md.Etag == "" {
+		t.Errorf("got %+v", md)
+	}
+	if want := []string{"docker.example.com/ot/app:1.0.0", cn}; !reflect.DeepEqual(md.AllNames, want) {
+		t.Errorf("got names %v; want %v", md.AllNames, want)
+	}
+
+	if _, err := f.GetImageMetadata(cn, md.Etag); err != docker_registry.ErrNotModified {
+		t.Errorf("got %v with the current etag; want ErrNotModified", err)
+	}
+	if _, err := f.GetImageMetadata(cn, `"stale"`); err != nil {
+		t.Errorf("got %v with a stale etag", err)
+	}
+	if _, err := f.GetImageMetadata("docker.example.com/ot/app:2.0.0", ""); err == nil {
+		t.Error("got metadata for a missing tag")
+	}
+}
+
+func TestFake_RetagAndDelete(t *testing.T) {
+	f := NewFake()
+	digest, _ := f.Add("docker.example.com/ot/app:1.0.0", nil)
+	if err := f.Retag("docker.example.com/ot/app:1.0.0", "docker.example.com/ot/app:latest"); err != nil {
+		t.Fatal(err)
+	}
+	tags, err := f.AllTags("docker.example.com/ot/app")
+	if want := []string{"1.0.0", "latest"}; err != nil || !reflect.DeepEqual(tags, want) {
+		t.Errorf("got %v, %v; want %v", tags, err, want)
+	}
+
+	f.Delete("docker.example.com/ot/app:latest")
+	if _, ok := f.Lookup("docker.example.com/ot/app:1.0.0"); !ok {
+		t.Error("deleting a tag removed the image")
+	}
+	f.Delete("docker.example.com/ot/app@" + digest)
+	if _, ok := f.Lookup("docker.example.com/ot/app:1.0.0"); ok {
+		t.Error("deleting the digest left its tags")
+	}
+}
+
+func TestFake_Injection(t *testing.T) {
+	f := NewFake()
+	f.Add("docker.example.com/ot/app:1.0.0", nil)
+	boom := errors.New("boom")
+	f.FailNext(AllTags, boom)
+	if _, err := f.AllTags("docker.example.com/ot/app"); err != boom {
+		t.Errorf("got %v; want the injected error", err)
+	}
+	if _, err := f.AllTags("docker.example.com/ot/app"); err != nil {
+		t.Errorf("got %v after the injected error", err)
+	}
+
+	f.SetLatency(GetImageMetadata, time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := f.GetImageMetadataContext(ctx, "docker.example.com/ot/app:1.0.0", ""); err != context.DeadlineExceeded {
+		t.Errorf("got %v; want the context's error", err)
+	}
+	if f.Calls(AllTags) != 2 || f.Calls(GetImageMetadata) != 1 {
+		t.Errorf("got %d, %d calls", f.Calls(AllTags), f.Calls(GetImageMetadata))
+	}
+}
@@ -0,0 +1,192 @@
+package registrytest
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+)
+
+// Server serves the images of a Fake over the docker registry HTTP API, so
+// that code can be tested with the real docker_registry.Client, which must
+// BecomeFoolishlyTrusting of its certificate. Each image is served as a
+// schema 2 manifest, with its labels in its config blob. Manifest digests
+// are of the manifests served, and so aren't those Add returns. Errors
+// injected into the Fake with FailNext are served as 500s.
+type Server struct {
+	*httptest.Server
+	// Fake holds the images served. Their names must be in Host.
+	Fake *Fake
+	// Host is the host, and port, of the registry, which starts the names
+	// of the images it serves.
+	Host string
+}
+
+const (
+	manifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+	configMediaType   = "application/vnd.docker.container.image.v1+json"
+)
+
+// NewServer starts a Server of an empty Fake. It must be closed.
+func NewServer() *Server {
+	s := &Server{Fake: NewFake()}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serve))
+	s.Host = strings.TrimPrefix(s.URL, "https://")
+	return s
+}
+
+// Name returns the name of the image repoRef, e.g. ot/sous:1.0.0, in s.
+func (s *Server) Name(repoRef string) string {
+	return s.Host + "/" + repoRef
+}
+
+// Add adds the image repoRef in s, as Fake.Add does, and returns its full
+// name.
+func (s *Server) Add(repoRef string, labels map[string]string) (string, error) {
+	name := s.Name(repoRef)
+	_, err := s.Fake.Add(name, labels)
+	return name, err
+}
+
+// served is an image as the Server serves it.
+type served struct {
+	manifest, config     []byte
+	digest, configDigest string
+}
+
+func serve(img Image) served {
+	config, _ := json.Marshal(map[string]interface{}{
+		"config": map[string]interface{}{"Labels": img.Labels},
+	})
+	s := served{config: config, configDigest: sha256Digest(config)}
+	s.manifest, _ = json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     manifestMediaType,
+		"config": map[string]interface{}{
+			"mediaType": configMediaType,
+			"size":      len(config),
+			"digest":    s.configDigest,
+		},
+		"layers": []interface{}{},
+	})
+	s.digest = sha256Digest(s.manifest)
+	return s
+}
+
+func sha256Digest(b []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(b))
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	switch {
+	case r.Method != "GET":
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	case strings.HasSuffix(path, "/tags/list"):
+		s.serveTags(w, r, strings.TrimSuffix(path, "/tags/list"))
+	case strings.Contains(path, "/manifests/"):
+		i := strings.LastIndex(path, "/manifests/")
+		s.serveManifest(w, r, path[:i], path[i+len("/manifests/"):])
+	case strings.Contains(path, "/blobs/"):
+		i := strings.LastIndex(path, "/blobs/")
+		s.serveBlob(w, path[:i], path[i+len("/blobs/"):])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) serveTags(w http.ResponseWriter, r *http.Request, repoName string) {
+	tags, err := s.Fake.AllTagsContext(r.Context(), s.Name(repoName))
+	if err != nil {
+		s.fail(w, err, "NAME_UNKNOWN")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"name": repoName, "tags": tags})
+}
+
+func (s *Server) serveManifest(w http.ResponseWriter, r *http.Request, repoName, ref string) {
+	if err := s.Fake.call(r.Context(), GetImageMetadata); err != nil {
+		s.fail(w, err, "")
+		return
+	}
+	img, ok := s.find(repoName, ref)
+	if !ok {
+		s.fail(w, NotFound{Name: s.Name(repoName) + ":" + ref}, "MANIFEST_UNKNOWN")
+		return
+	}
+	etag := `"` + img.digest + `"`
+	w.Header().Set("Docker-Content-Digest", img.digest)
+	w.Header().Set("Etag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", manifestMediaType)
+	w.Write(img.manifest)
+}
+
+func (s *Server) serveBlob(w http.ResponseWriter, repoName, digest string) {
+	s.Fake.Lock()
+	r, ok := s.Fake.repos[s.Name(repoName)]
+	var images []Image
+	if ok {
+		for _, img := range r.images {
+			images = append(images, img)
+		}
+	}
+	s.Fake.Unlock()
+	for _, img := range images {
+		if sv := serve(img); sv.configDigest == digest {
+			w.Header().Set("Content-Type", configMediaType)
+			w.Write(sv.config)
+			return
+		}
+	}
+	s.fail(w, NotFound{Name: digest}, "BLOB_UNKNOWN")
+}
+
+// find returns the image served as ref, a tag or the digest of its
+// manifest, in the repository repoName.
+func (s *Server) find(repoName, ref string) (served, bool) {
+	s.Fake.Lock()
+	defer s.Fake.Unlock()
+	r, ok := s.Fake.repos[s.Name(repoName)]
+	if !ok {
+		return served{}, false
+	}
+	if !strings.HasPrefix(ref, "sha256:") {
+		img, ok := r.images[r.tags[ref]]
+		return serve(img), ok
+	}
+	digests := make([]string, 0, len(r.images))
+	for d := range r.images {
+		digests = append(digests, d)
+	}
+	sort.Strings(digests)
+	for _, d := range digests {
+		if sv := serve(r.images[d]); sv.digest == ref {
+			return sv, true
+		}
+	}
+	return served{}, false
+}
+
+// fail serves err: as a 404 with the registry error code if it's a
+// NotFound, and as a 500 otherwise.
+func (s *Server) fail(w http.ResponseWriter, err error, code string) {
+	status := http.StatusInternalServerError
+	if _, ok := err.(NotFound); ok && code != "" {
+		status = http.StatusNotFound
+	} else {
+		code = "UNKNOWN"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []interface{}{map[string]string{"code": code, "message": err.Error()}},
+	})
+}
@@ -0,0 +1,64 @@
+package registrytest
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/opentable/sous/util/docker_registry"
+)
+
+func TestServer(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	name, err := s.Add("ot/app:1.0.0", map[string]string{"a": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Add("ot/app:1.1.0", map[string]string{"a": "c"}); err != nil {
+		t.Fatal(err)
+	}
+	c := docker_registry.NewClient()
+	c.BecomeFoolishlyTrusting()
+
+	md, err := c.GetImageMetadata(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Labels["a"] != "b" || md.Etag == "" {
+		t.Errorf("got %+v", md)
+	}
+	byDigest, err := c.GetImageMetadata(md.CanonicalName, "")
+	if err != nil || byDigest.Labels["a"] != "b" {
+		t.Errorf("got %+v, %v by digest", byDigest, err)
+	}
+	if _, err := c.GetImageMetadata(name, md.Etag); err != docker_registry.ErrNotModified {
+		t.Errorf("got %v with the current etag; want ErrNotModified", err)
+	}
+
+	tags, err := c.AllTags(s.Name("ot/app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1.0.0", "1.1.0"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("got tags %v; want %v", tags, want)
+	}
+
+	if err := s.Fake.Delete(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetImageMetadata(name, ""); !docker_registry.IsNotFound(err) {
+		t.Errorf("got %v for a deleted tag; want not found", err)
+	}
+	if _, err := c.AllTags(s.Name("ot/missing")); err == nil {
+		t.Error("got tags for a missing repository")
+	}
+
+	s.Fake.FailNext(GetImageMetadata, errors.New("boom"))
+	if _, err := c.GetImageMetadata(s.Name("ot/app:1.1.0"), ""); err == nil || docker_registry.IsNotFound(err) {
+		t.Errorf("got %v; want the injected failure", err)
+	}
+	if n := s.Fake.Calls(GetImageMetadata); n != 5 {
+		t.Errorf("got %d manifest requests; want 5", n)
+	}
+}
@@ -0,0 +1,133 @@
+package hy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type (
+	// A Codec marshals and unmarshals the files with a particular extension.
+	// Either func may be nil if hy is only used in one direction.
+	Codec struct {
+		// Ext is the file extension handled, including the leading dot,
+		// e.g. ".json"
+		Ext       string
+		Marshal   func(interface{}) ([]byte, error)
+		Unmarshal func([]byte, interface{}) error
+		// UnmarshalStrict is used instead of Unmarshal by strict
+		// Unmarshalers, and should reject fields unknown to the target.
+		UnmarshalStrict func([]byte, interface{}) error
+	}
+
+	// codecs maps file extensions to the Codecs that handle them
+	codecs map[string]Codec
+)
+
+// defaultExt is the extension of files written for map entries, which have
+// no extension of their own.
+const defaultExt = ".yaml"
+
+// JSONCodec handles .json files using encoding/json. It is registered by
+// default.
+var JSONCodec = Codec{
+	Ext: ".json",
+	Marshal: func(v interface{}) ([]byte, error) {
+		return json.MarshalIndent(v, "", "  ")
+	},
+	Unmarshal: json.Unmarshal,
+	UnmarshalStrict: func(b []byte, v interface{}) error {
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.DisallowUnknownFields()
+		return d.Decode(v)
+	},
+}
+
+var (
+	registryMu sync.RWMutex
+	// registry holds the codecs added by RegisterCodec.
+	registry = codecs{}
+)
+
+// RegisterCodec makes all Marshallers and Unmarshalers handle files ending
+// c.Ext, which includes the leading dot, using c, replacing JSONCodec or any
+// codec previously registered for c.Ext. Strict Unmarshalers can only read
+// the files if c has an UnmarshalStrict func. Codecs passed to Marshal and
+// Unmarshal take precedence over registered ones. Files ending .yaml are
+// always handled by the Marshaller's or Unmarshaler's own func.
+func RegisterCodec(c Codec) {
+	if !strings.HasPrefix(c.Ext, ".") || len(c.Ext) < 2 {
+		panic(fmt.Sprintf("hy: RegisterCodec passed extension %q; want e.g. \".toml\"", c.Ext))
+	}
+	if c.Ext == defaultExt {
+		panic("hy: RegisterCodec cannot replace the " + defaultExt + " codec")
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[c.Ext] = c
+}
+
+// newCodecs registers yaml for .yaml files, JSONCodec, the codecs added by
+// RegisterCodec, and then extra, which may replace any of them.
+func newCodecs(yaml Codec, extra []Codec) codecs {
+	yaml.Ext = defaultExt
+	cs := codecs{defaultExt: yaml, JSONCodec.Ext: JSONCodec}
+	registryMu.RLock()
+	for ext, c := range registry {
+		cs[ext] = c
+	}
+	registryMu.RUnlock()
+	for _, c := range extra {
+		cs[c.Ext] = c
+	}
+	return cs
+}
+
+// forPath returns the codec for path's extension, if one is registered.
+func (cs codecs) forPath(path string) (Codec, bool) {
+	c, ok := cs[filepath.Ext(path)]
+	return c, ok
+}
+
+func (cs codecs) isFile(path string) bool {
+	_, ok := cs.forPath(path)
+	return ok
+}
+
+// trimExt removes a registered extension from path.
+func (cs codecs) trimExt(path string) string {
+	if cs.isFile(path) {
+		return strings.TrimSuffix(path, filepath.Ext(path))
+	}
+	return path
+}
+
+// entryPath returns the file path to write for path. Map entries have no
+// extension of their own, so they keep the extension of a file already
+// written for them, or get defaultExt.
+func (cs codecs) entryPath(path string) string {
+	if cs.isFile(path) {
+		return path
+	}
+	for _, ext := range cs.exts() {
+		if _, err := os.Stat(path + ext); err == nil {
+			return path + ext
+		}
+	}
+	return path + defaultExt
+}
+
+// exts returns the registered extensions, sorted.
+func (cs codecs) exts() []string {
+	es := make([]string, 0, len(cs))
+	for e := range cs {
+		es = append(es, e)
+	}
+	sort.Strings(es)
+	return es
+}
@@ -0,0 +1,78 @@
+package hy
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// CollisionMode says what Unmarshal does when two files or directories in a
+// dir or tree target provide the same key, e.g. a.yaml and a.json, or two
+// names that a map key type's UnmarshalText treats as the same.
+type CollisionMode int
+
+const (
+	// RejectCollisions makes a collision an error naming both paths. It is
+	// the default.
+	RejectCollisions CollisionMode = iota
+	// FirstPathWins reads the lexically first of the colliding paths, and
+	// ignores the others, warning of each. It is meant for trees that can't
+	// yet be fixed.
+	FirstPathWins
+)
+
+// checkCollisions returns the targets read for a dir or tree target of type
+// typ, checking that no two of them provide the same key once their names
+// are normalized. Under FirstPathWins the later colliding targets are
+// dropped, and the others kept in order.
+func (c ctx) checkCollisions(ts targets, typ reflect.Type) (targets, error) {
+	sorted := append(targets{}, ts...)
+	sort.Stable(byPath(sorted))
+	claimed := map[string]*target{}
+	dropped := map[*target]bool{}
+	for _, t := range sorted {
+		if t.err != nil {
+			// It stands for files that weren't read.
+			continue
+		}
+		key := collisionKey(t.name, typ)
+		first, ok := claimed[key]
+		if !ok {
+			claimed[key] = t
+			continue
+		}
+		if c.read.collisions != FirstPathWins {
+			return nil, fmt.Errorf("duplicate key %q read from both %s and %s", key, first.path, t.path)
+		}
+		c.read.warnf("hy: duplicate key %q read from both %s and %s; ignoring %s", key, first.path, t.path, t.path)
+		dropped[t] = true
+	}
+	if len(dropped) == 0 {
+		return ts, nil
+	}
+	kept := targets{}
+	for _, t := range ts {
+		if !dropped[t] {
+			kept = append(kept, t)
+		}
+	}
+	return kept, nil
+}
+
+// collisionKey is the key that name is read as, for maps of type typ, or
+// just name, for slices or names that aren't valid keys, whose errors are
+// left to be reported when the key is set.
+func collisionKey(name string, typ reflect.Type) string {
+	if typ.Kind() != reflect.Map {
+		return name
+	}
+	k, err := nameKey(name, typ.Key())
+	if err != nil {
+		return name
+	}
+	key, err := keyName(k)
+	if err != nil {
+		return name
+	}
+	return key
+}
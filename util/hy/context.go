@@ -2,17 +2,27 @@ package hy
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
 )
 
 type (
 	ctx struct {
-		path      string
-		unmarshal func([]byte, interface{}) error
-		marshal   func(interface{}) ([]byte, error)
+		path   string
+		codecs codecs
+		write  writeOpts
+		read   readOpts
+		ignore []string
+		// fileName names the files of fields with shorthand tags, see
+		// fileSource.
+		fileName func(field string) string
+		// names converts between the keys of dir and tree targets and
+		// file names; nil means IdentityNames.
+		names NameCodec
 	}
 	walkFunc func(name, tag string, val reflect.Value) (*target, error)
 )
@@ -65,9 +75,23 @@ func (c ctx) walkTarget(t *target, walkFunc walkFunc) (targets, error) {
 	}
 	nf := st.NumField()
 	subTargets := targets{}
+	hasRemainder := false
 	for i := 0; i < nf; i++ {
 		f := st.Field(i)
 		tag := f.Tag.Get("hy")
+		if info, err := parseTag(tag); err == nil && info.remainder {
+			if hasRemainder {
+				return nil, fmt.Errorf("%s has more than one remainder file", st)
+			}
+			hasRemainder = true
+			rt, err := c.remainderTarget(info, t.val)
+			if err != nil {
+				return nil, err
+			}
+			rt.optional = !info.required
+			subTargets = append(subTargets, rt)
+			continue
+		}
 		if tag != "" {
 			debugf("field: %s hy tag: %s", f.Name, tag)
 			t, err := walkFunc(f.Name, tag, t.val.Elem().Field(i))
@@ -85,137 +109,370 @@ func (c ctx) getStructTargets(v interface{}) (targets, error) {
 	return c.walkStructTree(v, c.readTarget)
 }
 
-func (c ctx) readDirTarget(source, name string, val reflect.Value) (*target, error) {
+func (c ctx) readDirTarget(tag tagInfo, name string, val reflect.Value) (*target, error) {
 	typ := val.Type()
-	if typ.Kind() != reflect.Map {
-		return nil, fmt.Errorf("directory targets only accept maps")
+	if typ.Kind() != reflect.Map && typ.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("directory targets only accept maps and slices")
 	}
 	elemType, err := getElemType(typ)
 	if err != nil {
 		return nil, err
 	}
-	c = c.enter(source)
-	yamlFiles, err := filepath.Glob(c.enter("*.yaml").path)
-	if err != nil {
+	if err := checkMapKey(typ); err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	if tag.key != "" {
+		if err := checkKeyField(elemType, tag.key); err != nil {
+			return nil, err
+		}
+	}
+	c = c.enter(tag.source)
+	entries, err := ioutil.ReadDir(c.path)
+	if err != nil && !os.IsNotExist(err) {
 		return nil, err
 	}
-	subTargets := make(targets, len(yamlFiles))
-	for i, filename := range yamlFiles {
-		filename = strings.TrimPrefix(filename, c.path)
-		subTargets[i], err = c.getFileTarget(filename, pathToName(filename), newValue(elemType))
+	subTargets := targets{}
+	errs := Errors{}
+	nested := len(c.hySources(elemType)) != 0
+	for _, e := range entries {
+		filename := filepath.Join(c.path, e.Name())
+		if c.ignored(filename) {
+			continue
+		}
+		dir := isDir(filename, e)
+		if dir && !nested {
+			continue
+		}
+		if !dir && (nested || !c.codecs.isFile(filename)) {
+			if c.read.rejectUnknownFiles {
+				errs = append(errs, c.unknownFileError(filename))
+			}
+			continue
+		}
+		if c.enoughFiles(subTargets) {
+			subTargets = append(subTargets, c.limitTarget(c.path, c.tooManyFiles()))
+			break
+		}
+		var t *target
+		if dir {
+			t, err = c.readNestedEntry(e.Name(), elemType, tag.key)
+		} else {
+			t, err = c.readEntry(e.Name(), elemType, tag.key)
+		}
 		if err != nil {
 			return nil, err
 		}
+		subTargets = append(subTargets, t)
+	}
+	if len(errs) != 0 {
+		return nil, errs
+	}
+	if subTargets, err = c.checkCollisions(subTargets, typ); err != nil {
+		return nil, err
 	}
 	return c.makeTarget(name, val, subTargets), nil
 }
 
-func (c ctx) readTree(elemType reflect.Type) (targets, error) {
+// readEntry makes the target for a file in a dir or tree target. filename
+// is relative to c.path.
+func (c ctx) readEntry(filename string, elemType reflect.Type, keyField string) (*target, error) {
+	name, err := c.pathToName(filename)
+	if err != nil {
+		return nil, err
+	}
+	t, err := c.getFileTarget(filename, name, newValue(elemType))
+	if err != nil {
+		return nil, err
+	}
+	t.keyField = keyField
+	return t, nil
+}
+
+func (c ctx) readTree(elemType reflect.Type, keyField string) (targets, error) {
 	ts := targets{}
-	err := filepath.Walk(c.path, func(path string, f os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	errs := Errors{}
+	var dirFn func(string) (bool, error)
+	if sources := c.hySources(elemType); len(sources) != 0 {
+		dirFn = func(path string) (bool, error) {
+			if !isElementDir(path, sources) {
+				return true, nil
+			}
+			if c.enoughFiles(ts) {
+				return false, errEnoughFiles
+			}
+			rel, err := filepath.Rel(c.path, path)
+			if err != nil {
+				return false, err
+			}
+			t, err := c.readNestedEntry(rel, elemType, keyField)
+			if err != nil {
+				return false, err
+			}
+			ts = append(ts, t)
+			return false, nil
 		}
-		if f.IsDir() || !strings.HasSuffix(path, ".yaml") {
+	}
+	tooDeep, err := c.walkTree(dirFn, func(path string) error {
+		if dirFn != nil {
+			// Only directories are elements.
 			return nil
 		}
-		path = strings.TrimPrefix(path, c.path)
-		t, err := c.getFileTarget(path, pathToName(path), newValue(elemType))
+		if !c.codecs.isFile(path) {
+			if c.read.rejectUnknownFiles {
+				errs = append(errs, c.unknownFileError(path))
+			}
+			return nil
+		}
+		if c.enoughFiles(ts) {
+			return errEnoughFiles
+		}
+		rel, err := filepath.Rel(c.path, path)
+		if err != nil {
+			return err
+		}
+		t, err := c.readEntry(rel, elemType, keyField)
 		if err != nil {
 			return err
 		}
 		ts = append(ts, t)
 		return nil
 	})
+	if err == errEnoughFiles {
+		err = nil
+		ts = append(ts, c.limitTarget(c.path, c.tooManyFiles()))
+	}
+	for _, dir := range tooDeep {
+		ts = append(ts, c.limitTarget(dir, c.tooDeep()))
+	}
+	// walkTree visits "a/b.yaml" before "a.yaml"; slices are filled in
+	// lexical path order.
+	sort.Sort(byPath(ts))
+	if err == nil && len(errs) != 0 {
+		return nil, errs
+	}
 	return ts, err
 }
 
-func (c ctx) readTreeTarget(source, name string, val reflect.Value) (*target, error) {
+func (c ctx) readTreeTarget(tag tagInfo, name string, val reflect.Value) (*target, error) {
 	typ := val.Type()
 	elemType, err := getElemType(typ)
 	if err != nil {
 		return nil, err
 	}
-	source = strings.TrimSuffix(source, "**")
-	c = c.enter(source)
-	subTargets, err := c.readTree(elemType)
+	if err := checkMapKey(typ); err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	if tag.key != "" {
+		if err := checkKeyField(elemType, tag.key); err != nil {
+			return nil, err
+		}
+	}
+	c = c.enter(strings.TrimSuffix(tag.source, "**"))
+	subTargets, err := c.readTree(elemType, tag.key)
+	if err != nil {
+		return nil, err
+	}
+	if subTargets, err = c.checkCollisions(subTargets, typ); err != nil {
+		return nil, err
+	}
 	return c.makeTarget(name, val, subTargets), nil
 }
 
-func (c ctx) writeDirTarget(source, name string, val reflect.Value) (*target, error) {
-	return c.writeTreeTarget(source, name, val)
+func (c ctx) writeDirTarget(tag tagInfo, name string, val reflect.Value) (*target, error) {
+	return c.writeEntriesTarget(tag, name, val, false)
+}
+
+func (c ctx) writeTreeTarget(tag tagInfo, name string, val reflect.Value) (*target, error) {
+	return c.writeEntriesTarget(tag, name, val, true)
 }
 
-func (c ctx) writeTreeTarget(source, name string, val reflect.Value) (*target, error) {
+// writeEntriesTarget makes the target for a map or slice written to a
+// directory, one file per entry.
+func (c ctx) writeEntriesTarget(tag tagInfo, name string, val reflect.Value, recursive bool) (*target, error) {
 	t := val.Type()
-	if t.Kind() != reflect.Map || t.Key().Kind() != reflect.String {
-		return nil, fmt.Errorf("internal error: writeTarget passed %s; want map[string]T", t)
+	if t.Kind() == reflect.Slice {
+		return c.writeSliceTarget(tag, name, val, recursive)
 	}
-	source = strings.TrimSuffix(source, "**")
-	c = c.enter(source)
+	if t.Kind() != reflect.Map {
+		return nil, fmt.Errorf("internal error: writeTarget passed %s; want map[K]T or []T", t)
+	}
+	if err := checkKeyType(t.Key()); err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	if tag.key != "" {
+		elemType, err := getElemType(t)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkKeyField(elemType, tag.key); err != nil {
+			return nil, err
+		}
+	}
+	c = c.enter(strings.TrimSuffix(tag.source, "**"))
 	m := reflect.MakeMap(reflect.TypeOf(map[string]interface{}{}))
 	for _, k := range val.MapKeys() {
 		elemVal := val.MapIndex(k)
-		m.SetMapIndex(k, elemVal)
+		key, err := keyName(k)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		if tag.key != "" {
+			if key, err = resolveKey(key, elemVal, tag.key); err != nil {
+				return nil, fmt.Errorf("%s: %s", name, err)
+			}
+		}
+		if m.MapIndex(reflect.ValueOf(key)).IsValid() {
+			return nil, fmt.Errorf("%s: more than one entry has key %q", name, key)
+		}
+		m.SetMapIndex(reflect.ValueOf(key), elemVal)
 	}
-	subTargets, err := c.writeTree(m.Interface().(map[string]interface{}))
+	return c.writeEntries(name, val, m.Interface().(map[string]interface{}), recursive)
+}
+
+// writeSliceTarget names each element's file using its key field if tag has
+// one, or else its zero-padded index.
+func (c ctx) writeSliceTarget(tag tagInfo, name string, val reflect.Value, recursive bool) (*target, error) {
+	if tag.key != "" {
+		elemType, err := getElemType(val.Type())
+		if err != nil {
+			return nil, err
+		}
+		if err := checkKeyField(elemType, tag.key); err != nil {
+			return nil, err
+		}
+	}
+	c = c.enter(strings.TrimSuffix(tag.source, "**"))
+	m := make(map[string]interface{}, val.Len())
+	width := len(fmt.Sprint(val.Len() - 1))
+	if width < minIndexWidth {
+		width = minIndexWidth
+	}
+	for i := 0; i < val.Len(); i++ {
+		elemVal := val.Index(i)
+		key := fmt.Sprintf("%0*d", width, i)
+		if tag.key != "" {
+			var err error
+			if key, err = resolveKey("", elemVal, tag.key); err != nil {
+				return nil, fmt.Errorf("%s[%d]: %s", name, i, err)
+			}
+			if _, ok := m[key]; ok {
+				return nil, fmt.Errorf("%s: more than one entry has key %q", name, key)
+			}
+		}
+		m[key] = elemVal.Interface()
+	}
+	return c.writeEntries(name, val, m, recursive)
+}
+
+// minIndexWidth is the least number of digits used to name files for slice
+// elements, so small slices can grow without renaming every file.
+const minIndexWidth = 3
+
+func (c ctx) writeEntries(name string, val reflect.Value, m map[string]interface{}, recursive bool) (*target, error) {
+	elemType, err := getElemType(val.Type())
 	if err != nil {
 		return nil, err
 	}
-	return c.makeTarget(name, val, subTargets), nil
+	if !recursive {
+		for key := range m {
+			if err := checkFlatName(c.encodeName(key)); err != nil {
+				return nil, fmt.Errorf("%s: %s", name, err)
+			}
+		}
+	}
+	nested := len(c.hySources(elemType)) != 0
+	subTargets, err := c.writeTree(m, elemType, nested)
+	if err != nil {
+		return nil, err
+	}
+	stale, err := c.staleFiles(subTargets, recursive, nested)
+	if err != nil {
+		return nil, err
+	}
+	target := c.makeTarget(name, val, subTargets)
+	target.stale = stale
+	return target, nil
 }
 
-func (c ctx) writeTree(m map[string]interface{}) (targets, error) {
-	ts := make(targets, len(m))
-	i := 0
-	for name, val := range m {
-		ts[i] = c.enter(name).makeTarget(name, reflect.ValueOf(val), nil)
-		i++
+func (c ctx) writeTree(m map[string]interface{}, elemType reflect.Type, nested bool) (targets, error) {
+	// Visit names in order, so that plans and the files they write do not
+	// depend on map iteration order.
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ts := make(targets, 0, len(m))
+	for _, name := range names {
+		val := m[name]
+		if !nested {
+			ts = append(ts, c.enter(filepath.FromSlash(c.encodeName(name))).makeTarget(name, reflect.ValueOf(val), nil))
+			continue
+		}
+		t, err := c.writeNestedEntry(name, reflect.ValueOf(val), elemType)
+		if err != nil {
+			return nil, err
+		}
+		ts = append(ts, t)
 	}
 	return ts, nil
 }
 
 func (c ctx) makeTarget(name string, val reflect.Value, subTargets targets) *target {
 	return &target{
-		path:          c.path,
-		name:          name,
-		typ:           val.Type(),
-		val:           val,
-		subTargets:    subTargets,
-		unmarshalFunc: c.unmarshal,
-		marshalFunc:   c.marshal,
+		path:       c.path,
+		name:       name,
+		typ:        val.Type(),
+		val:        val,
+		subTargets: subTargets,
+		codecs:     c.codecs,
+		writeOpts:  c.write,
+		readOpts:   c.read,
 	}
 }
 
 func (c ctx) readTarget(name, tag string, val reflect.Value) (*target, error) {
-	source := strings.Split(tag, ",")[0]
-	if strings.HasSuffix(source, ".yaml") {
+	info, err := parseTag(tag)
+	if err != nil {
+		return nil, err
+	}
+	source := c.fileSource(name, info)
+	if c.codecs.isFile(source) {
 		debug("file")
-		return c.getFileTarget(source, name, val)
+		t, err := c.getFileTarget(source, name, val)
+		if err != nil {
+			return nil, err
+		}
+		t.optional = !info.required
+		return t, nil
 	}
 	if strings.HasSuffix(source, "/") {
 		debug("dir")
-		return c.readDirTarget(source, name, val)
+		return c.readDirTarget(info, name, val)
 	}
 	if strings.HasSuffix(source, "/**") {
 		debug("tree")
-		return c.readTreeTarget(source, name, val)
+		return c.readTreeTarget(info, name, val)
 	}
-	return nil, fmt.Errorf("%s.%s has hy tag %q; source does not end with .yaml, /, nor /**", val.Type(), name, tag)
+	return nil, fmt.Errorf("%s.%s has hy tag %q; source is not an extension, and does not end with one of %s, /, nor /**", val.Type(), name, tag, strings.Join(c.codecs.exts(), ", "))
 }
 
 func (c ctx) writeTarget(name, tag string, val reflect.Value) (*target, error) {
-	source := strings.Split(tag, ",")[0]
-	if strings.HasSuffix(source, ".yaml") {
+	info, err := parseTag(tag)
+	if err != nil {
+		return nil, err
+	}
+	source := c.fileSource(name, info)
+	if c.codecs.isFile(source) {
 		return c.getFileTarget(source, name, val)
 	}
 	if strings.HasSuffix(source, "/") {
-		return c.writeDirTarget(source, name, val)
+		return c.writeDirTarget(info, name, val)
 	}
 	if strings.HasSuffix(source, "/**") {
-		return c.writeTreeTarget(source, name, val)
+		return c.writeTreeTarget(info, name, val)
 	}
-	return nil, fmt.Errorf("%s.%s has hy tag %q; source does not end with .yaml, /, nor /**", val.Type(), name, tag)
+	return nil, fmt.Errorf("%s.%s has hy tag %q; source is not an extension, and does not end with one of %s, /, nor /**", val.Type(), name, tag, strings.Join(c.codecs.exts(), ", "))
 }
 
 func (c ctx) getFileTarget(source, name string, val reflect.Value) (*target, error) {
@@ -227,12 +484,12 @@ func (c ctx) getFileTarget(source, name string, val reflect.Value) (*target, err
 
 func (c ctx) enter(path string) ctx {
 	return ctx{
-		path:      filepath.Join(c.path, path),
-		unmarshal: c.unmarshal,
-		marshal:   c.marshal,
+		path:     filepath.Join(c.path, path),
+		codecs:   c.codecs,
+		write:    c.write,
+		read:     c.read,
+		ignore:   c.ignore,
+		fileName: c.fileName,
+		names:    c.names,
 	}
 }
-
-func pathToName(path string) string {
-	return strings.TrimPrefix(strings.TrimSuffix(path, ".yaml"), "/")
-}
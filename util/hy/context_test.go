@@ -0,0 +1,75 @@
+package hy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestPathToName(t *testing.T) {
+	c := ctx{codecs: newCodecs(Codec{}, nil)}
+	cases := map[string]string{
+		"c.yaml":                             "c",
+		"c.json":                             "c",
+		"c.txt":                              "c.txt",
+		filepath.Join("a", "b", "c.yaml"):    "a/b/c",
+		filepath.Join("a.yaml", "b", "c.js"): "a.yaml/b/c.js",
+	}
+	for path, want := range cases {
+		if got, err := c.pathToName(path); err != nil || got != want {
+			t.Errorf("pathToName(%q) = %q, %v; want %q", path, got, err, want)
+		}
+	}
+}
+
+func TestReadTreeNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hy-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, f := range []string{"one.yaml", "a/two.yaml", "a/b/three.json"} {
+		path := filepath.Join(dir, "tree", filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("{}"), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	sep := string(filepath.Separator)
+	want := []string{"a/b/three", "a/two", "one"}
+	for _, root := range []string{
+		filepath.Join(dir, "tree"),
+		filepath.Join(dir, "tree") + sep,
+		"tree",
+		"tree" + sep,
+		"." + sep + "tree" + sep,
+	} {
+		c := ctx{path: root, codecs: newCodecs(Codec{}, nil)}
+		ts, err := c.readTree(reflect.TypeOf(struct{}{}), "")
+		if err != nil {
+			t.Fatalf("root %q: %s", root, err)
+		}
+		names := []string{}
+		for _, t := range ts {
+			names = append(names, t.name)
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("root %q: got names %q; want %q", root, names, want)
+		}
+	}
+}
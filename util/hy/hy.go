@@ -1,6 +1,81 @@
 /*
 Package hy enables marshaling and unmarshaling tagged structs as filesystem
-trees of YAML files.
+trees of YAML files. Files ending .json are read and written using
+encoding/json, and codecs for other extensions can be registered for all
+calls with RegisterCodec, or passed to Marshal and Unmarshal. Directory and
+tree targets may mix files of any registered extension, but two files may
+not provide the same key. When marshaling,
+files in a directory or tree target which no longer correspond to a map key
+are removed; files without a registered extension, and files outside the
+target's directory, are never touched.
+
+Directory and tree targets of structs may name a string field to hold each
+element's key, e.g. `hy:"widgets/**,key=Name"`. The field is set from the
+file's path when unmarshaling, and supplies the file name when marshaling an
+entry whose map key is empty.
+
+Directory and tree targets may also be slices, which are read in lexical order
+of file path. When marshaling, each element is named using its key field if
+there is one, or else its zero-padded index.
+
+Map keys may be any string type, or a type implementing encoding.TextMarshaler
+and encoding.TextUnmarshaler, which converts between keys and file names.
+Keys containing a path separator are only allowed in tree targets, where they
+become nested directories.
+
+Keys are written as file names unchanged, unless Marshaller.Names and
+Unmarshaler.Names give a NameCodec to convert them, e.g. EscapedNames, for
+keys containing characters which are awkward in file names. Slashes escaped
+by the NameCodec don't count as path separators.
+
+Symlinked files are always read, but symlinked directories are only walked by
+tree targets if Unmarshaler.FollowSymlinks is set.
+
+Hidden files, editor backups and other names matching DefaultIgnore are
+skipped in directory and tree targets, both when reading and when removing
+stale files.
+
+Marshaller.Plan returns the files that marshaling would create, modify and
+delete, with their old and new contents, without changing anything; applying
+the Plan makes exactly those changes.
+
+Unmarshaler.UnmarshalManifest also returns a ReadManifest recording which
+field or map entry each file was read into, and the file's size, modification
+time and hash, so that callers can tell which files have since changed.
+
+Two files or directories providing the same key in a directory or tree
+target, e.g. a.yaml and a.json, or two names that a key's UnmarshalText reads
+as equal, are an error naming both; see Unmarshaler.Collisions to read the
+lexically first instead.
+
+Unmarshaling reads files of at most Unmarshaler.MaxFileSize bytes, at most
+Unmarshaler.MaxFiles files for each dir or tree target, and directories at
+most Unmarshaler.MaxDepth levels below a tree target's. Whatever is beyond a
+limit is skipped, and reported as an Error for its path, without stopping
+the rest of the read; the defaults are generous but finite.
+
+Unmarshaling into a struct whose maps already have entries keeps the entries
+with no corresponding file; see Unmarshaler.Merge for the alternatives.
+
+If the elements of a directory or tree target are structs with hy tags of
+their own, each element is a directory rather than a file, and its tags are
+resolved relative to that directory. In tree targets, any directory
+containing one of those sources is an element.
+
+Fields without hy tags are only read and written if the struct names a
+remainder file, usually using a blank field:
+
+    _ struct{} `hy:"service.yaml,remainder"`
+
+That file holds every exported untagged field, and none of the tagged ones.
+
+A tag which is just an extension is shorthand for a file named after the
+field, lower-cased: `hy:"yaml"` on a field Config means config.yaml. Set
+Unmarshaler.FileName and Marshaller.FileName to name them differently.
+
+A file named by a struct field which is missing when unmarshaling leaves the
+field as it would be for an empty file, unless its tag has the "required"
+option, e.g. `hy:"config.yaml,required"`.
 
 For example, the following program...
 
@@ -47,14 +122,22 @@ import (
 	"log"
 	"path/filepath"
 	"runtime"
+
+	"github.com/opentable/sous/util/logging"
 )
 
 // Debug is a global flag, set it to true to print debug messages when
 // marshaling and unmarshaling using the log package.
 var Debug = false
 
+// debugEnabled reports whether debug messages are printed: if Debug is set,
+// or the level of logging.Hy is debug.
+func debugEnabled() bool {
+	return Debug || logging.Enabled(logging.Hy, logging.DebugLevel)
+}
+
 func debugf(format string, a ...interface{}) {
-	if !Debug {
+	if !debugEnabled() {
 		return
 	}
 	_, fn, ln, ok := runtime.Caller(1)
@@ -67,7 +150,7 @@ func debugf(format string, a ...interface{}) {
 }
 
 func debug(a ...interface{}) {
-	if !Debug {
+	if !debugEnabled() {
 		return
 	}
 	_, fn, ln, ok := runtime.Caller(1)
@@ -0,0 +1,46 @@
+package hy
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultIgnore are the patterns of file and directory names skipped in dir
+// and tree targets, unless the Marshaller or Unmarshaler sets Ignore. They
+// match hidden files (including editor swap files), editor backups and emacs
+// autosaves.
+var DefaultIgnore = []string{".*", "*~", "#*#"}
+
+// ignorePatterns returns patterns, or DefaultIgnore if patterns is nil, after
+// checking they are valid.
+func ignorePatterns(patterns []string) ([]string, error) {
+	if patterns == nil {
+		return DefaultIgnore, nil
+	}
+	for _, p := range patterns {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("bad ignore pattern %q: %s", p, err)
+		}
+	}
+	return patterns, nil
+}
+
+// ignored returns true if the base name of path matches any of c's ignore
+// patterns.
+func (c ctx) ignored(path string) bool {
+	base := filepath.Base(path)
+	for _, p := range c.ignore {
+		if ok, _ := filepath.Match(p, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// unknownFileError is reported for files without a registered extension
+// when the Unmarshaler is set to RejectUnknownFiles.
+func (c ctx) unknownFileError(path string) *Error {
+	return newError(path, fmt.Errorf("unexpected file; want one of %s, or a name matching one of %s",
+		strings.Join(c.codecs.exts(), ", "), strings.Join(c.ignore, ", ")))
+}
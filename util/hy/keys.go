@@ -0,0 +1,72 @@
+package hy
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var (
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// checkKeyType returns an error unless map keys of type t can be converted
+// to and from file names: either t is a string type, or t implements
+// encoding.TextMarshaler and *t implements encoding.TextUnmarshaler.
+func checkKeyType(t reflect.Type) error {
+	if t.Kind() == reflect.String {
+		return nil
+	}
+	if t.Implements(textMarshalerType) && reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return nil
+	}
+	return fmt.Errorf("map key type %s is not a string, and does not implement encoding.TextMarshaler and encoding.TextUnmarshaler", t)
+}
+
+// checkMapKey calls checkKeyType if t is a map type.
+func checkMapKey(t reflect.Type) error {
+	if t.Kind() != reflect.Map {
+		return nil
+	}
+	return checkKeyType(t.Key())
+}
+
+// keyName returns the name of the file for map key k, relative to its
+// directory and without an extension.
+func keyName(k reflect.Value) (string, error) {
+	if m, ok := k.Interface().(encoding.TextMarshaler); ok {
+		b, err := m.MarshalText()
+		if err != nil {
+			return "", fmt.Errorf("map key %v: %s", k.Interface(), err)
+		}
+		return string(b), nil
+	}
+	return k.String(), nil
+}
+
+// nameKey returns the map key of type t for a file named name, and is the
+// inverse of keyName.
+func nameKey(name string, t reflect.Type) (reflect.Value, error) {
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		k := reflect.New(t)
+		if err := k.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(name)); err != nil {
+			return reflect.Value{}, fmt.Errorf("%q is not a valid %s: %s", name, t, err)
+		}
+		return k.Elem(), nil
+	}
+	if t.Kind() != reflect.String {
+		return reflect.Value{}, fmt.Errorf("map key type %s is not a string, and does not implement encoding.TextUnmarshaler", t)
+	}
+	return reflect.ValueOf(name).Convert(t), nil
+}
+
+// checkFlatName returns an error if name would be written to a
+// subdirectory, which only tree targets read.
+func checkFlatName(name string) error {
+	if strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("key %q contains a path separator; use a tree target (/**) for nested keys", name)
+	}
+	return nil
+}
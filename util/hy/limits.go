@@ -0,0 +1,75 @@
+package hy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// The limits used when the Unmarshaler's are zero. They are far beyond what
+// any sensible tree needs, and are only there so that a mistakenly committed
+// huge file or runaway directory can't exhaust memory.
+const (
+	// DefaultMaxFileSize is the most bytes read from a single file.
+	DefaultMaxFileSize = 32 << 20
+	// DefaultMaxFiles is the most files read for a dir or tree target.
+	DefaultMaxFiles = 100000
+	// DefaultMaxDepth is how deep a tree target's directories are walked.
+	DefaultMaxDepth = 32
+)
+
+// errEnoughFiles stops walking a tree target once it has as many files as
+// the maxFiles read option allows.
+var errEnoughFiles = errors.New("read enough files")
+
+// readLimit returns the limit an Unmarshaler option n sets, where zero
+// means def: a positive limit, or zero for none.
+func readLimit(n, def int64) int64 {
+	switch {
+	case n == 0:
+		return def
+	case n < 0:
+		return 0
+	}
+	return n
+}
+
+// limitTarget makes a target which, instead of being read, fails with err,
+// which explains what was skipped at path for going over a read limit.
+func (c ctx) limitTarget(path string, err error) *target {
+	return &target{path: path, codecs: c.codecs, readOpts: c.read, err: err}
+}
+
+// tooManyFiles is the error for a dir or tree target at c.path with more
+// than maxFiles files.
+func (c ctx) tooManyFiles() error {
+	return fmt.Errorf("more than %d files; only the first %d were read", c.read.maxFiles, c.read.maxFiles)
+}
+
+// enoughFiles reports whether a dir or tree target already has as many of
+// ts as it may read.
+func (c ctx) enoughFiles(ts targets) bool {
+	return c.read.maxFiles > 0 && int64(len(ts)) >= c.read.maxFiles
+}
+
+// checkFileSize fails if the file at path is bigger than the maxFileSize
+// read option allows.
+func (t target) checkFileSize() error {
+	if t.readOpts.maxFileSize <= 0 {
+		return nil
+	}
+	s, err := os.Stat(t.path)
+	if err != nil {
+		return err
+	}
+	if s.Size() > t.readOpts.maxFileSize {
+		return fmt.Errorf("file is %d bytes, more than the limit of %d; not read", s.Size(), t.readOpts.maxFileSize)
+	}
+	return nil
+}
+
+// tooDeep is the error for a directory in a tree target nested more than
+// maxDepth levels deep.
+func (c ctx) tooDeep() error {
+	return fmt.Errorf("directory is nested more than %d levels deep; not read", c.read.maxDepth)
+}
@@ -0,0 +1,134 @@
+package hy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+)
+
+type (
+	// ReadManifest lists the files read by Unmarshaler.UnmarshalManifest,
+	// sorted by path.
+	ReadManifest []ReadRecord
+
+	// ReadRecord describes a file read by Unmarshaler.UnmarshalManifest.
+	ReadRecord struct {
+		// Path is the absolute path of the file.
+		Path string
+		// Field is the path of the field the file was read into, from the
+		// struct passed to Unmarshal, e.g. "Regions[eu].Widgets". For a
+		// remainder file, it is the path of the struct the file belongs to,
+		// which is empty for the struct passed to Unmarshal.
+		Field string
+		// Key is the key of the entry the file was read into, for files in
+		// dir and tree targets; for slices, it is the key the element's file
+		// name gives, rather than its index. It is empty for other files.
+		Key string
+		// ModTime and Size are the file's modification time and size when
+		// it was read.
+		ModTime time.Time
+		Size    int64
+		// Hash is the hex-encoded SHA-256 of the file's contents.
+		Hash string
+	}
+)
+
+// UnmarshalManifest is like Unmarshal, but also returns a ReadManifest of
+// the files read. If err is not nil, the manifest still lists every file
+// that was read, including those that failed to unmarshal.
+func (u Unmarshaler) UnmarshalManifest(path string, v interface{}) (ReadManifest, error) {
+	m := ReadManifest{}
+	err := u.unmarshal(path, v, &m)
+	sort.Slice(m, func(i, j int) bool { return m[i].Path < m[j].Path })
+	return m, err
+}
+
+// Changed returns the records of m whose files have changed since they were
+// read, or have been removed. Files whose size and modification time are the
+// same are assumed not to have changed; the rest are hashed.
+func (m ReadManifest) Changed() (ReadManifest, error) {
+	changed := ReadManifest{}
+	for _, r := range m {
+		s, err := os.Stat(r.Path)
+		if os.IsNotExist(err) {
+			changed = append(changed, r)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if s.Size() == r.Size && s.ModTime().Equal(r.ModTime) {
+			continue
+		}
+		b, err := ioutil.ReadFile(r.Path)
+		if err != nil {
+			return nil, err
+		}
+		if hashContents(b) != r.Hash {
+			changed = append(changed, r)
+		}
+	}
+	return changed, nil
+}
+
+func hashContents(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// record adds a record of t's file, which had contents b, to the manifest
+// being built, if there is one.
+func (t target) record(b []byte) error {
+	if t.readOpts.manifest == nil {
+		return nil
+	}
+	path, err := filepath.Abs(t.path)
+	if err != nil {
+		return err
+	}
+	s, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	*t.readOpts.manifest = append(*t.readOpts.manifest, ReadRecord{
+		Path:    path,
+		Field:   t.field,
+		Key:     t.key,
+		ModTime: s.ModTime(),
+		Size:    s.Size(),
+		Hash:    hashContents(b),
+	})
+	return nil
+}
+
+// setFields sets the field and key of t, which ReadRecords report, and of
+// all of its subtargets.
+func (t *target) setFields(field, key string) {
+	t.field, t.key = field, key
+	if k := t.val.Kind(); k == reflect.Map || k == reflect.Slice {
+		for _, st := range t.subTargets {
+			st.setFields(field, st.name)
+		}
+		return
+	}
+	base := field
+	if key != "" {
+		base = fmt.Sprintf("%s[%s]", field, key)
+	}
+	for _, st := range t.subTargets {
+		switch {
+		case st.remainder != nil:
+			st.setFields(base, "")
+		case base == "":
+			st.setFields(st.name, "")
+		default:
+			st.setFields(base+"."+st.name, "")
+		}
+	}
+}
@@ -1,12 +1,12 @@
 package hy
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"path"
+	"path/filepath"
 	"reflect"
-	"strings"
 
 	"github.com/opentable/sous/util/yaml"
 )
@@ -14,45 +14,131 @@ import (
 type (
 	Marshaller struct {
 		MarshalFunc func(interface{}) ([]byte, error)
+		// Codecs handle files with other extensions than .yaml, and
+		// may replace the default JSONCodec
+		Codecs []Codec
+		// FileMode and DirMode are the permissions of files and directories
+		// created; they default to DefaultFileMode and DefaultDirMode.
+		FileMode, DirMode os.FileMode
+		// Durable causes each file to be synced to disk before it replaces
+		// the previous version.
+		Durable bool
+		// Ignore are patterns of file and directory names in dir and tree
+		// targets which are never removed, see Unmarshaler.Ignore.
+		Ignore []string
+		// FileName names the files of fields whose hy tag is just an
+		// extension, see Unmarshaler.FileName.
+		FileName func(field string) string
+		// Names names the files of the entries of dir and tree targets,
+		// see Unmarshaler.Names.
+		Names NameCodec
 	}
+
+	// writeOpts are the Marshaller options needed by targets when writing.
+	writeOpts struct {
+		fileMode, dirMode os.FileMode
+		durable           bool
+	}
+)
+
+const (
+	// DefaultFileMode is the mode of files written when
+	// Marshaller.FileMode is zero.
+	DefaultFileMode os.FileMode = 0644
+	// DefaultDirMode is the mode of directories created when
+	// Marshaller.DirMode is zero.
+	DefaultDirMode os.FileMode = 0755
 )
 
-func NewMarshaller(marshalFunc func(interface{}) ([]byte, error)) Marshaller {
+func NewMarshaller(marshalFunc func(interface{}) ([]byte, error), codecs ...Codec) Marshaller {
 	if marshalFunc == nil {
 		panic("marshalFunc cannot be nil")
 	}
-	return Marshaller{marshalFunc}
+	return Marshaller{MarshalFunc: marshalFunc, Codecs: codecs}
 }
 
-// Marshal is shorthand for NewMarshaller(yaml.Marshal).Marshal
-func Marshal(dir string, v interface{}) error {
-	return NewMarshaller(yaml.Marshal).Marshal(dir, v)
+// Marshal is shorthand for NewMarshaller(yaml.Marshal, codecs...).Marshal
+func Marshal(dir string, v interface{}, codecs ...Codec) error {
+	return NewMarshaller(yaml.Marshal, codecs...).Marshal(dir, v)
 }
 
+// Marshal writes v to the directory path, making the changes returned by
+// Plan.
 func (m Marshaller) Marshal(path string, v interface{}) error {
-	return ctx{path, nil, m.MarshalFunc}.marshalDir(v)
+	p, err := m.Plan(path, v)
+	if err != nil {
+		return err
+	}
+	return p.Apply()
+}
+
+// Plan works out the changes needed to write v, which must be a pointer to
+// struct, to the directory path, without changing anything.
+func (m Marshaller) Plan(path string, v interface{}) (*Plan, error) {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("hy cannot marshal %T; want a pointer to struct", v)
+	}
+	// Marshaling zeroes fields as they are written, so work on a copy.
+	cp := reflect.New(val.Elem().Type())
+	cp.Elem().Set(val.Elem())
+	c := ctx{
+		path:     path,
+		codecs:   newCodecs(Codec{Marshal: m.MarshalFunc}, m.Codecs),
+		fileName: m.FileName,
+		names:    m.Names,
+		write: writeOpts{
+			fileMode: m.FileMode,
+			dirMode:  m.DirMode,
+			durable:  m.Durable,
+		},
+	}
+	if c.write.fileMode == 0 {
+		c.write.fileMode = DefaultFileMode
+	}
+	if c.write.dirMode == 0 {
+		c.write.dirMode = DefaultDirMode
+	}
+	var err error
+	if c.ignore, err = ignorePatterns(m.Ignore); err != nil {
+		return nil, err
+	}
+	p := &Plan{opts: c.write}
+	if err := c.marshalDir(cp.Interface(), p); err != nil {
+		return nil, err
+	}
+	return p, nil
 }
 
-func (c ctx) marshalDir(v interface{}) error {
+func (c ctx) marshalDir(v interface{}, p *Plan) error {
 	ts, err := c.writeStructTargets(v)
 	if err != nil {
 		return err
 	}
-	return ts.marshalAll(nil)
+	return ts.marshalAll(nil, p)
 }
 
-func (ts targets) marshalAll(parent *reflect.Value) error {
+func (ts targets) marshalAll(parent *reflect.Value, p *Plan) error {
 	for _, t := range ts {
-		t.marshal(parent)
+		if err := t.marshal(parent, p); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func (t target) marshal(parent *reflect.Value) error {
+func (t target) marshal(parent *reflect.Value, p *Plan) error {
+	if err := t.planStale(p); err != nil {
+		return err
+	}
+	if t.remainder != nil {
+		t.copyFromParent(*parent)
+		return t.write(p)
+	}
 	// first marshal children
 	if len(t.subTargets) != 0 {
 		debugf("Marshalling %d children of %s", len(t.subTargets), t.val.Type())
-		if err := t.subTargets.marshalAll(&t.val); err != nil {
+		if err := t.subTargets.marshalAll(&t.val, p); err != nil {
 			return err
 		}
 	} else {
@@ -63,7 +149,7 @@ func (t target) marshal(parent *reflect.Value) error {
 		// zero out the field in the parent
 		switch parent.Kind() {
 		default:
-			return fmt.Errorf("parents may only be structs or map[string]T")
+			return fmt.Errorf("parents may only be structs, map[K]T or []T")
 		case reflect.Ptr:
 			field := parent.Elem().FieldByName(t.name)
 			if !field.CanSet() {
@@ -74,7 +160,7 @@ func (t target) marshal(parent *reflect.Value) error {
 			field.Set(z)
 		case reflect.Struct:
 			panic("parent is struct, want *struct or map")
-		case reflect.Map:
+		case reflect.Map, reflect.Slice:
 			z := reflect.Zero(parent.Type())
 			debugf("Zeroing %s (%v)\n", parent.Type(), z.Interface())
 			parent.Set(z)
@@ -83,46 +169,93 @@ func (t target) marshal(parent *reflect.Value) error {
 		}
 		debugf("Done zeroing: %s.%s (%v)", parent.Type(), t.name, parent.Interface())
 	}
-	if parent == nil {
+	if parent == nil || t.nested {
 		return nil
 	}
-	if t.val.Type().Kind() == reflect.Map && t.val.Len() == 0 {
+	if k := t.val.Type().Kind(); (k == reflect.Map || k == reflect.Slice) && t.val.Len() == 0 {
 		return nil
 	}
 	debugf("Final %s.%s (%v)", t.val.Type(), t.name, t.val.Interface())
-	return t.write()
+	return t.write(p)
 }
 
-func (t target) write() error {
+// write adds the change needed to write t to p, if any.
+func (t target) write(p *Plan) error {
 	// first convert to map[string]interface{}, then delete keys that have
 	// hy tags, and finally marshal what's left.
 	// this will cause issues with field name mappings used by the marshaller/
 	// unmarshaller. This could potentially be avoided by first marshalling
 	// everything, then unmarshalling to map and deleting.
-	if t.marshalFunc == nil {
-		panic("marshalFunc is nil")
+	path := t.codecs.entryPath(t.path)
+	codec, _ := t.codecs.forPath(path)
+	if codec.Marshal == nil {
+		return fmt.Errorf("no marshal func for %s files", codec.Ext)
+	}
+	b, err := codec.Marshal(t.val.Interface())
+	if err != nil {
+		return err
 	}
-	b, err := t.marshalFunc(t.val.Interface())
+	old, err := readExisting(path)
 	if err != nil {
 		return err
 	}
-	dir := path.Dir(t.path)
-	if err := ensureDirExists(dir); err != nil {
+	switch {
+	case old == nil:
+		p.Changes = append(p.Changes, Change{Path: path, Action: CreateFile, New: b})
+	case !bytes.Equal(old, b):
+		p.Changes = append(p.Changes, Change{Path: path, Action: ModifyFile, Old: old, New: b})
+	}
+	return nil
+}
+
+// writeFile writes b to a temporary file next to path, and then renames it
+// over path, so that path is never left partially written.
+func writeFile(path string, b []byte, opts writeOpts) (err error) {
+	dir, base := filepath.Split(path)
+	f, err := ioutil.TempFile(dir, "."+base+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if _, err := f.Write(b); err != nil {
+		return err
+	}
+	if opts.durable {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
 		return err
 	}
-	path := t.path
-	if !strings.HasSuffix(path, ".yaml") {
-		path += ".yaml"
+	if err := os.Chmod(f.Name(), opts.fileMode); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	if !opts.durable {
+		return nil
+	}
+	// Sync the directory as well, so the rename itself is durable.
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
 	}
-	debug("Writing file", path, string(b))
-	return ioutil.WriteFile(path, b, 0777)
+	defer d.Close()
+	return d.Sync()
 }
 
-func ensureDirExists(path string) error {
+func ensureDirExists(path string, mode os.FileMode) error {
 	d, err := os.Stat(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return os.MkdirAll(path, 0777)
+			return os.MkdirAll(path, mode)
 		}
 		return err
 	}
@@ -0,0 +1,106 @@
+package hy
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"path/filepath"
+)
+
+// NameCodec converts between the keys of dir and tree targets and the names
+// of the files or directories they are written to, relative to the target
+// and without an extension. Names always use "/" as the separator, and in
+// tree targets a name containing one is a nested directory.
+type NameCodec interface {
+	EncodeName(key string) string
+	// DecodeName is the inverse of EncodeName.
+	DecodeName(file string) (string, error)
+}
+
+type (
+	// IdentityNames is the default NameCodec, which names each file after
+	// its key, unchanged.
+	IdentityNames struct{}
+
+	// EscapedNames is a NameCodec which URL-escapes every character of a key
+	// but letters, digits, '-', '_' and '.', so that keys like
+	// "github.com/org/svc,api" make portable file names. A '.' starting a
+	// name is escaped too, so that no key is written to a hidden file.
+	EscapedNames struct {
+		// NestSlashes leaves '/' unescaped, so that in tree targets keys
+		// containing it are written to nested directories, rather than to
+		// a single file named e.g. "github.com%2Forg%2Fsvc%2Capi.yaml".
+		NestSlashes bool
+	}
+)
+
+// EncodeName returns key.
+func (IdentityNames) EncodeName(key string) string { return key }
+
+// DecodeName returns file.
+func (IdentityNames) DecodeName(file string) (string, error) { return file, nil }
+
+// EncodeName escapes key.
+func (n EscapedNames) EncodeName(key string) string {
+	b := &bytes.Buffer{}
+	start := true
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case c == '/' && n.NestSlashes:
+			b.WriteByte(c)
+			start = true
+			continue
+		case c == '.' && start, !unescapedName(c):
+			fmt.Fprintf(b, "%%%02X", c)
+		default:
+			b.WriteByte(c)
+		}
+		start = false
+	}
+	return b.String()
+}
+
+// DecodeName unescapes file.
+func (n EscapedNames) DecodeName(file string) (string, error) {
+	key, err := url.PathUnescape(file)
+	if err != nil {
+		return "", fmt.Errorf("file name %q: %s", file, err)
+	}
+	return key, nil
+}
+
+func unescapedName(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '_' || c == '.'
+}
+
+// encodeName returns the name of the file or directory for key, relative
+// to c.path and without an extension.
+func (c ctx) encodeName(key string) string {
+	if c.names == nil {
+		return key
+	}
+	return c.names.EncodeName(key)
+}
+
+// decodeName returns the key for the file or directory name, relative to
+// c.path and without an extension.
+func (c ctx) decodeName(name string) (string, error) {
+	if c.names == nil {
+		return name, nil
+	}
+	return c.names.DecodeName(name)
+}
+
+// pathName returns the name of the file at path, relative to c.path, as
+// encodeName would: without its extension, and using "/" as the separator.
+func (c ctx) pathName(path string) string {
+	return filepath.ToSlash(c.codecs.trimExt(path))
+}
+
+// pathToName returns the map key for the file at path, relative to c.path.
+// Keys always use "/" as the separator.
+func (c ctx) pathToName(path string) (string, error) {
+	return c.decodeName(c.pathName(path))
+}
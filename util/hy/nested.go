@@ -0,0 +1,87 @@
+package hy
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// hySources returns the sources of the hy-tagged fields of t, which may be a
+// struct or pointer to struct. Elements of dir and tree targets whose type
+// has any are nested: each is a directory containing those sources, rather
+// than a single file.
+func (c ctx) hySources(t reflect.Type) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	sources := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("hy")
+		if tag == "" {
+			continue
+		}
+		info, err := parseTag(tag)
+		if err != nil {
+			continue
+		}
+		sources = append(sources, c.fileSource(f.Name, info))
+	}
+	return sources
+}
+
+// isElementDir returns true if dir contains any of sources.
+func isElementDir(dir string, sources []string) bool {
+	for _, s := range sources {
+		s = strings.TrimSuffix(strings.TrimSuffix(s, "**"), "/")
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(s))); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// readNestedEntry makes the target for the nested element in dir, relative
+// to c.path.
+func (c ctx) readNestedEntry(dir string, elemType reflect.Type, keyField string) (*target, error) {
+	name, err := c.decodeName(filepath.ToSlash(dir))
+	if err != nil {
+		return nil, err
+	}
+	ec := c.enter(dir)
+	ts, err := ec.walkStructTree(reflect.New(elemType).Interface(), ec.readTarget)
+	if err != nil {
+		return nil, err
+	}
+	t := ts[0]
+	t.name = name
+	t.nested = true
+	t.keyField = keyField
+	return t, nil
+}
+
+// writeNestedEntry makes the target for writing val as a nested element
+// named name.
+func (c ctx) writeNestedEntry(name string, val reflect.Value, elemType reflect.Type) (*target, error) {
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		val = val.Elem()
+	}
+	// Copy val, because marshaling zeroes the fields written.
+	cp := reflect.New(elemType)
+	if val.IsValid() {
+		cp.Elem().Set(val)
+	}
+	ec := c.enter(filepath.FromSlash(c.encodeName(name)))
+	ts, err := ec.walkStructTree(cp.Interface(), ec.writeTarget)
+	if err != nil {
+		return nil, err
+	}
+	t := ts[0]
+	t.name = name
+	t.nested = true
+	return t, nil
+}
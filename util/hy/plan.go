@@ -0,0 +1,110 @@
+package hy
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+type (
+	// A Plan is the set of changes needed to marshal a value, as returned by
+	// Marshaller.Plan.
+	Plan struct {
+		// Changes are in the order they were found, which is the same for
+		// equal values; Apply makes all deletions before other changes.
+		Changes []Change
+		opts    writeOpts
+	}
+
+	// A Change to a single file.
+	Change struct {
+		Path   string
+		Action Action
+		// Old is the content of the file when the plan was made, and is nil
+		// for CreateFile.
+		Old []byte
+		// New is the content to be written, and is nil for DeleteFile.
+		New []byte
+		// root is the directory of the target that owns a deleted file;
+		// directories left empty are removed up to root.
+		root string
+	}
+
+	// Action is what a Change does.
+	Action int
+)
+
+const (
+	CreateFile Action = iota
+	ModifyFile
+	DeleteFile
+)
+
+func (a Action) String() string {
+	switch a {
+	default:
+		return "unknown"
+	case CreateFile:
+		return "create"
+	case ModifyFile:
+		return "modify"
+	case DeleteFile:
+		return "delete"
+	}
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s %s", c.Action, c.Path)
+}
+
+// Apply makes the changes in p. It first checks that every file is still as
+// it was when the plan was made, and changes nothing if any is not.
+func (p *Plan) Apply() error {
+	for _, c := range p.Changes {
+		current, err := readExisting(c.Path)
+		if err != nil {
+			return err
+		}
+		if (current == nil) != (c.Old == nil) || !bytes.Equal(current, c.Old) {
+			return fmt.Errorf("cannot %s: file changed since the plan was made", c)
+		}
+	}
+	for _, c := range p.Changes {
+		if c.Action != DeleteFile {
+			continue
+		}
+		if err := removeFile(c.Path, c.root); err != nil {
+			return err
+		}
+	}
+	for _, c := range p.Changes {
+		if c.Action == DeleteFile {
+			continue
+		}
+		if err := ensureDirExists(filepath.Dir(c.Path), p.opts.dirMode); err != nil {
+			return err
+		}
+		debug("Writing file", c.Path, string(c.New))
+		if err := writeFile(c.Path, c.New, p.opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readExisting returns the contents of path, or nil if it does not exist.
+func readExisting(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		b = []byte{}
+	}
+	return b, nil
+}
@@ -0,0 +1,107 @@
+package hy
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// staleFiles returns the files owned by the dir or tree target at c.path
+// which are not written by any of ts. A target only owns files with a
+// registered extension whose names are not ignored, and dir targets only own
+// files directly inside c.path; anything else is left alone. Targets of
+// nested elements instead own every such file in directories below c.path,
+// and those in the directories of ts are left to ts.
+func (c ctx) staleFiles(ts targets, recursive, nested bool) ([]string, error) {
+	keys := make(map[string]struct{}, len(ts))
+	for _, t := range ts {
+		keys[c.encodeName(t.name)] = struct{}{}
+	}
+	stale := []string{}
+	err := filepath.Walk(c.path, func(path string, f os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == c.path {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if path != c.path && c.ignored(path) {
+			if f.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if f.IsDir() {
+			if !recursive && !nested && path != c.path {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !c.codecs.isFile(path) {
+			return nil
+		}
+		rel, err := filepath.Rel(c.path, path)
+		if err != nil {
+			return err
+		}
+		if nested {
+			if c.inElement(filepath.ToSlash(rel), keys) {
+				return nil
+			}
+			stale = append(stale, path)
+			return nil
+		}
+		if _, ok := keys[c.pathName(rel)]; !ok {
+			stale = append(stale, path)
+		}
+		return nil
+	})
+	return stale, err
+}
+
+// inElement returns true if the file at rel, relative to c.path, is either
+// directly inside c.path, or inside the directory of one of keys.
+func (c ctx) inElement(rel string, keys map[string]struct{}) bool {
+	if !strings.Contains(rel, "/") {
+		return true
+	}
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		if _, ok := keys[dir]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// planStale adds the deletion of each of t.stale to p.
+func (t target) planStale(p *Plan) error {
+	for _, path := range t.stale {
+		old, err := readExisting(path)
+		if err != nil {
+			return err
+		}
+		if old == nil {
+			continue
+		}
+		p.Changes = append(p.Changes, Change{Path: path, Action: DeleteFile, Old: old, root: t.path})
+	}
+	return nil
+}
+
+// removeFile deletes path, along with any directories that are left empty by
+// doing so, up to but not including root.
+func removeFile(path, root string) error {
+	debug("Removing stale file", path)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for dir := filepath.Dir(path); strings.HasPrefix(dir, root+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		// os.Remove fails for non-empty directories, which is what we
+		// want here.
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package hy
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// remainderType returns a struct type made of the exported fields of st
+// which have no hy tag, keeping their other tags, along with the index of
+// each in st. Remainder files are marshaled from and unmarshaled into values
+// of this type, so codecs never see the fields written elsewhere.
+func remainderType(st reflect.Type) (reflect.Type, []int, error) {
+	fields := []reflect.StructField{}
+	indexes := []int{}
+	for i := 0; i < st.NumField(); i++ {
+		f := st.Field(i)
+		if f.Tag.Get("hy") != "" || f.PkgPath != "" {
+			continue
+		}
+		if f.Anonymous && (f.Type.NumMethod() != 0 || reflect.PtrTo(f.Type).NumMethod() != 0) {
+			return nil, nil, fmt.Errorf("%s embeds %s, which has methods; this is not supported with a remainder file", st, f.Type)
+		}
+		fields = append(fields, reflect.StructField{
+			Name:      f.Name,
+			Type:      f.Type,
+			Tag:       f.Tag,
+			Anonymous: f.Anonymous,
+		})
+		indexes = append(indexes, i)
+	}
+	return reflect.StructOf(fields), indexes, nil
+}
+
+// remainderTarget makes the target for the remainder file of the struct
+// that parent points to.
+func (c ctx) remainderTarget(tag tagInfo, parent reflect.Value) (*target, error) {
+	if !c.codecs.isFile(tag.source) {
+		return nil, fmt.Errorf("remainder source %q of %s is not a file", tag.source, parent.Type())
+	}
+	typ, indexes, err := remainderType(parent.Type().Elem())
+	if err != nil {
+		return nil, err
+	}
+	c = c.enter(tag.source)
+	t := c.makeTarget("", reflect.New(typ), nil)
+	t.remainder = indexes
+	return t, nil
+}
+
+// copyFromParent sets t.val's fields from the struct parent points to.
+func (t target) copyFromParent(parent reflect.Value) {
+	p, r := parent.Elem(), t.val.Elem()
+	for i, idx := range t.remainder {
+		r.Field(i).Set(p.Field(idx))
+	}
+}
+
+// copyToParent sets fields of the struct parent points to from t.val.
+func (t target) copyToParent(parent reflect.Value) {
+	p, r := parent.Elem(), t.val.Elem()
+	for i, idx := range t.remainder {
+		p.Field(idx).Set(r.Field(i))
+	}
+}
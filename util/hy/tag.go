@@ -0,0 +1,98 @@
+package hy
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// tagInfo is a parsed hy tag, which looks like "source[,option...]".
+type tagInfo struct {
+	source string
+	// key is the name of a string field on elements of a dir or tree target,
+	// set from the "key=Field" option. That field is set to each element's
+	// map key when reading, and may supply the key when writing.
+	key string
+	// remainder is set by the "remainder" option, which makes source a file
+	// holding all of the struct's fields which have no hy tag.
+	remainder bool
+	// required is set by the "required" option, which makes it an error for
+	// a file source to be missing when reading.
+	required bool
+}
+
+func parseTag(tag string) (tagInfo, error) {
+	parts := strings.Split(tag, ",")
+	info := tagInfo{source: parts[0]}
+	for _, opt := range parts[1:] {
+		if strings.HasPrefix(opt, "key=") {
+			info.key = strings.TrimPrefix(opt, "key=")
+			if info.key == "" {
+				return info, fmt.Errorf("hy tag %q has empty key option", tag)
+			}
+		}
+		if opt == "remainder" {
+			info.remainder = true
+		}
+		if opt == "required" {
+			info.required = true
+		}
+	}
+	return info, nil
+}
+
+// fileSource returns the source of the field called name with tag, which is
+// tag.source unless that is a bare extension, e.g. "yaml", which is
+// shorthand for a file named after the field, e.g. "name.yaml".
+func (c ctx) fileSource(name string, tag tagInfo) string {
+	s := tag.source
+	if s == "" || strings.ContainsAny(s, "./") || !c.codecs.isFile("."+s) {
+		return s
+	}
+	fileName := c.fileName
+	if fileName == nil {
+		fileName = strings.ToLower
+	}
+	return fileName(name) + "." + s
+}
+
+// checkKeyField returns an error unless elemType has a string field named
+// field.
+func checkKeyField(elemType reflect.Type, field string) error {
+	if elemType.Kind() != reflect.Struct {
+		return fmt.Errorf("key=%s used with %s; want struct elements", field, elemType)
+	}
+	f, ok := elemType.FieldByName(field)
+	if !ok {
+		return fmt.Errorf("key=%s used with %s, which has no field %s", field, elemType, field)
+	}
+	if f.Type.Kind() != reflect.String {
+		return fmt.Errorf("key=%s used with %s, but %s.%s is %s; want string", field, elemType, elemType, field, f.Type)
+	}
+	return nil
+}
+
+// resolveKey returns the map key to write elem under, which is key unless it
+// is empty, in which case it is the value of elem's key field. If both are
+// set they must agree.
+func resolveKey(key string, elem reflect.Value, field string) (string, error) {
+	for elem.Kind() == reflect.Ptr || elem.Kind() == reflect.Interface {
+		if elem.IsNil() {
+			break
+		}
+		elem = elem.Elem()
+	}
+	fieldKey := ""
+	if elem.Kind() == reflect.Struct {
+		fieldKey = elem.FieldByName(field).String()
+	}
+	switch {
+	case key == "" && fieldKey == "":
+		return "", fmt.Errorf("map entry has empty key and empty %s", field)
+	case key == "":
+		return fieldKey, nil
+	case fieldKey != "" && fieldKey != key:
+		return "", fmt.Errorf("map key %q disagrees with %s %q", key, field, fieldKey)
+	}
+	return key, nil
+}
@@ -11,9 +11,39 @@ type (
 		name string
 		// subTargets includes both map and slice element targets, as well as
 		// struct field targets.
-		subTargets    targets
-		unmarshalFunc func([]byte, interface{}) error
-		marshalFunc   func(interface{}) ([]byte, error)
+		subTargets targets
+		codecs     codecs
+		writeOpts  writeOpts
+		readOpts   readOpts
+		// stale lists files in a dir or tree target which no longer
+		// correspond to any map key, and are removed before writing.
+		stale []string
+		// keyField is the field of a dir or tree element which is set to
+		// name when reading, see tagInfo.key.
+		keyField string
+		// nested is true for elements of dir and tree targets which are
+		// directories, see hySources.
+		nested bool
+		// remainder lists the fields of the parent struct held by a
+		// remainder file, in the order of val's fields, see remainderType.
+		remainder []int
+		// optional is set for the file targets of struct fields, which are
+		// left as they are if their file is missing when reading, unless
+		// their tag has the "required" option.
+		optional bool
+		// field and key locate the value read from a file, see ReadRecord.
+		field, key string
+		// err, if set, is returned instead of reading the target, which
+		// stands for files skipped for going over a read limit; see
+		// limitTarget.
+		err error
 	}
 	targets []*target
+
+	// byPath sorts targets by their path
+	byPath targets
 )
+
+func (ts byPath) Len() int           { return len(ts) }
+func (ts byPath) Swap(i, j int)      { ts[i], ts[j] = ts[j], ts[i] }
+func (ts byPath) Less(i, j int) bool { return ts[i].path < ts[j].path }
@@ -0,0 +1,141 @@
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opentable/sous/util/hy"
+)
+
+type JSONBase struct {
+	Config  Config            `hy:"config.json"`
+	Things  map[string]Thing  `hy:"things/"`
+	Widgets map[string]Widget `hy:"widgets/**"`
+}
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "hy-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestUnmarshal_MixedYAMLAndJSON(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"config.json":              `{"Name": "Dave"}`,
+		"things/one.yaml":          "Name: Thing One\n",
+		"things/two.json":          `{"Name": "Thing Two"}`,
+		"things/ignored.txt":       "Name: Not a thing\n",
+		"widgets/a/b/widget1.json": `{"Name": "Pingu"}`,
+		"widgets/a/widget2.yaml":   "Name: Pinga\n",
+	})
+	defer os.RemoveAll(dir)
+
+	b := JSONBase{}
+	if err := hy.Unmarshal(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Config.Name != "Dave" {
+		t.Errorf("Config.Name was %q; want %q", b.Config.Name, "Dave")
+	}
+	if len(b.Things) != 2 {
+		t.Errorf("got %d things; want 2: %v", len(b.Things), b.Things)
+	}
+	if b.Things["one"].Name != "Thing One" || b.Things["two"].Name != "Thing Two" {
+		t.Errorf("got things %v", b.Things)
+	}
+	if b.Widgets["a/b/widget1"].Name != "Pingu" || b.Widgets["a/widget2"].Name != "Pinga" {
+		t.Errorf("got widgets %v", b.Widgets)
+	}
+}
+
+func TestUnmarshal_DuplicateKeys(t *testing.T) {
+	for _, files := range []map[string]string{
+		{
+			"config.json":     `{}`,
+			"things/one.yaml": "Name: Thing One\n",
+			"things/one.json": `{"Name": "Thing One"}`,
+		},
+		{
+			"config.json":        `{}`,
+			"widgets/a/one.yaml": "Name: Widget One\n",
+			"widgets/a/one.json": `{"Name": "Widget One"}`,
+			"widgets/a/two.json": `{"Name": "Widget Two"}`,
+		},
+	} {
+		dir := writeFiles(t, files)
+		defer os.RemoveAll(dir)
+		err := hy.Unmarshal(dir, &JSONBase{})
+		if err == nil {
+			t.Errorf("got nil error; want duplicate key error for %v", files)
+			continue
+		}
+		if !strings.Contains(err.Error(), "duplicate key") {
+			t.Errorf("got error %q; want duplicate key error", err)
+		}
+	}
+}
+
+func TestMarshal_CustomCodec(t *testing.T) {
+	upper := hy.Codec{
+		Ext: ".up",
+		Marshal: func(v interface{}) ([]byte, error) {
+			b, err := json.Marshal(v)
+			return []byte(strings.ToUpper(string(b))), err
+		},
+		Unmarshal: func(b []byte, v interface{}) error {
+			return json.Unmarshal([]byte(strings.ToLower(string(b))), v)
+		},
+	}
+	type custom struct {
+		Config Config           `hy:"config.up"`
+		Things map[string]Thing `hy:"things/"`
+	}
+	dir := writeFiles(t, map[string]string{
+		"things/one.json": `{"Name": "one"}`,
+		"things/two.up":   `{"NAME": "TWO"}`,
+	})
+	defer os.RemoveAll(dir)
+
+	in := custom{
+		Config: Config{Name: "config"},
+		Things: map[string]Thing{
+			"one":   {Name: "one"},
+			"two":   {Name: "two"},
+			"three": {Name: "three"},
+		},
+	}
+	if err := hy.Marshal(dir, &in, upper); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"config.up", "things/one.json", "things/two.up", "things/three.yaml"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected %s to be written: %s", name, err)
+		}
+	}
+
+	out := custom{}
+	if err := hy.Unmarshal(dir, &out, upper); err != nil {
+		t.Fatal(err)
+	}
+	if out.Config.Name != "config" {
+		t.Errorf("Config.Name was %q; want %q", out.Config.Name, "config")
+	}
+	if len(out.Things) != 3 || out.Things["two"].Name != "two" {
+		t.Errorf("got things %v", out.Things)
+	}
+}
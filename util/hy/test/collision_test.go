@@ -0,0 +1,95 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opentable/sous/util/hy"
+	"github.com/opentable/sous/util/yaml"
+)
+
+type (
+	// FoldedName reads file names case-insensitively.
+	FoldedName string
+
+	FoldedBase struct {
+		Things  map[FoldedName]Thing  `hy:"things/"`
+		Widgets map[FoldedName]Widget `hy:"widgets/**"`
+	}
+)
+
+func (n FoldedName) MarshalText() ([]byte, error) { return []byte(n), nil }
+
+func (n *FoldedName) UnmarshalText(b []byte) error {
+	*n = FoldedName(strings.ToLower(string(b)))
+	return nil
+}
+
+func TestUnmarshal_NormalizedKeyCollision(t *testing.T) {
+	for _, files := range []map[string]string{
+		{
+			"things/One.yaml": "Name: Upper\n",
+			"things/one.yaml": "Name: Lower\n",
+		},
+		{
+			"widgets/A/one.yaml": "Name: Upper\n",
+			"widgets/a/one.yaml": "Name: Lower\n",
+		},
+	} {
+		dir := writeFiles(t, files)
+		defer os.RemoveAll(dir)
+		err := hy.Unmarshal(dir, &FoldedBase{})
+		if err == nil {
+			t.Errorf("got nil error; want duplicate key error for %v", files)
+			continue
+		}
+		for name := range files {
+			if path := filepath.Join(dir, name); !strings.Contains(err.Error(), path) {
+				t.Errorf("got error %q; want it to name %s", err, path)
+			}
+		}
+	}
+}
+
+func TestUnmarshal_FirstPathWins(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"things/One.yaml":    "Name: Upper\n",
+		"things/one.yaml":    "Name: Lower\n",
+		"things/two.yaml":    "Name: Two\n",
+		"widgets/a/one.json": `{"Name": "JSON"}`,
+		"widgets/a/one.yaml": "Name: YAML\n",
+	})
+	defer os.RemoveAll(dir)
+
+	var warnings []string
+	u := hy.NewUnmarshaler(yaml.Unmarshal)
+	u.Collisions = hy.FirstPathWins
+	u.Warnf = func(format string, v ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, v...))
+	}
+	b := FoldedBase{}
+	if err := u.Unmarshal(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Things) != 2 || b.Things["one"].Name != "Upper" || b.Things["two"].Name != "Two" {
+		t.Errorf("got things %v; want One.yaml read as one, and two", b.Things)
+	}
+	if len(b.Widgets) != 1 || b.Widgets["a/one"].Name != "JSON" {
+		t.Errorf("got widgets %v; want a/one.json read", b.Widgets)
+	}
+	if len(warnings) != 2 {
+		t.Fatalf("got warnings %q; want 2", warnings)
+	}
+	for _, ignored := range []string{"things/one.yaml", "widgets/a/one.yaml"} {
+		found := false
+		for _, w := range warnings {
+			found = found || strings.HasSuffix(w, "ignoring "+filepath.Join(dir, ignored))
+		}
+		if !found {
+			t.Errorf("got warnings %q; want one ignoring %s", warnings, ignored)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opentable/sous/util/hy"
+	"github.com/opentable/sous/util/yaml"
+)
+
+// junkFiles are read as broken YAML, or add entries, unless ignored.
+var junkFiles = map[string]string{
+	"things/.one.yaml.swp":     "\x00\x01",
+	"things/.hidden.yaml":      "Name: [\n",
+	"things/one.yaml~":         "Name: [\n",
+	"things/#one.yaml#":        "Name: [\n",
+	"widgets/a/.DS_Store":      "\x00\x01",
+	"widgets/a/two.yaml~":      "Name: [\n",
+	"widgets/a/#two.yaml#":     "Name: [\n",
+	"widgets/.git/config.yaml": "Name: [\n",
+	"widgets/.hidden.yaml":     "Name: [\n",
+}
+
+func junkTree(t *testing.T, extra map[string]string) string {
+	files := map[string]string{
+		"things/one.yaml":    "Name: one\n",
+		"widgets/a/two.yaml": "Name: two\n",
+	}
+	for k, v := range junkFiles {
+		files[k] = v
+	}
+	for k, v := range extra {
+		files[k] = v
+	}
+	return writeFiles(t, files)
+}
+
+func TestUnmarshal_IgnoresJunk(t *testing.T) {
+	dir := junkTree(t, nil)
+	defer os.RemoveAll(dir)
+
+	b := PruneBase{}
+	if err := hy.Unmarshal(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Things) != 1 || len(b.Widgets) != 1 {
+		t.Errorf("got things %v and widgets %v; want one of each", b.Things, b.Widgets)
+	}
+}
+
+func TestUnmarshal_CustomIgnore(t *testing.T) {
+	dir := junkTree(t, map[string]string{
+		"things/draft-two.yaml": "Name: [\n",
+	})
+	defer os.RemoveAll(dir)
+
+	u := hy.NewUnmarshaler(yaml.Unmarshal)
+	u.Ignore = []string{".*", "*~", "#*#", "draft-*"}
+	b := PruneBase{}
+	if err := u.Unmarshal(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Things) != 1 {
+		t.Errorf("got things %v; want 1", b.Things)
+	}
+
+	u.Ignore = []string{}
+	if err := u.Unmarshal(dir, &PruneBase{}); err == nil {
+		t.Error("got nil error with no ignore patterns; want errors from junk")
+	}
+
+	u.Ignore = []string{"[bad"}
+	if err := u.Unmarshal(dir, &PruneBase{}); err == nil {
+		t.Error("got nil error for bad pattern")
+	}
+}
+
+func TestUnmarshal_RejectUnknownFiles(t *testing.T) {
+	dir := junkTree(t, map[string]string{
+		"things/notes.txt":   "notes",
+		"widgets/a/b/README": "readme",
+	})
+	defer os.RemoveAll(dir)
+
+	u := hy.NewUnmarshaler(yaml.Unmarshal)
+	if err := u.Unmarshal(dir, &PruneBase{}); err != nil {
+		t.Fatalf("unknown files rejected by default: %s", err)
+	}
+
+	u.RejectUnknownFiles = true
+	err := u.Unmarshal(dir, &PruneBase{})
+	if err == nil {
+		t.Fatal("got nil error; want error for things/notes.txt")
+	}
+	if !strings.Contains(err.Error(), "things/notes.txt") {
+		t.Errorf("error does not mention things/notes.txt:\n%s", err)
+	}
+	for junk := range junkFiles {
+		if strings.Contains(err.Error(), junk) {
+			t.Errorf("error mentions ignored file %s:\n%s", junk, err)
+		}
+	}
+
+	if err := os.Remove(filepath.Join(dir, "things/notes.txt")); err != nil {
+		t.Fatal(err)
+	}
+	err = u.Unmarshal(dir, &PruneBase{})
+	if err == nil || !strings.Contains(err.Error(), "widgets/a/b/README") {
+		t.Errorf("got error %v; want error for widgets/a/b/README", err)
+	}
+}
+
+func TestMarshal_KeepsIgnoredFiles(t *testing.T) {
+	dir := junkTree(t, nil)
+	defer os.RemoveAll(dir)
+
+	if err := hy.Marshal(dir, &PruneBase{}); err != nil {
+		t.Fatal(err)
+	}
+	exist := map[string]bool{
+		"things/one.yaml":    false,
+		"widgets/a/two.yaml": false,
+	}
+	for junk := range junkFiles {
+		exist[junk] = true
+	}
+	assertFiles(t, dir, exist)
+}
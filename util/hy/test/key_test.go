@@ -0,0 +1,107 @@
+package test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/opentable/sous/util/hy"
+)
+
+type (
+	KeyBase struct {
+		Things  map[string]Thing   `hy:"things/,key=Name"`
+		Widgets map[string]*Widget `hy:"widgets/**,key=Name"`
+	}
+	BadKeyBase struct {
+		Things map[string]Thing `hy:"things/,key=Colour"`
+	}
+)
+
+func TestUnmarshal_KeyField(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"things/one.yaml":          "Desc: first\n",
+		"things/two.json":          `{"Name": "wrong", "Desc": "second"}`,
+		"widgets/a/b/widget1.yaml": "Desc: nested\n",
+		"widgets/widget2.yaml":     "Desc: top\n",
+	})
+	defer os.RemoveAll(dir)
+
+	b := KeyBase{}
+	if err := hy.Unmarshal(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+	for key, thing := range b.Things {
+		if thing.Name != key {
+			t.Errorf("Things[%q].Name = %q; want %q", key, thing.Name, key)
+		}
+	}
+	for key, widget := range b.Widgets {
+		if widget.Name != key {
+			t.Errorf("Widgets[%q].Name = %q; want %q", key, widget.Name, key)
+		}
+	}
+	if w, ok := b.Widgets["a/b/widget1"]; !ok || w.Desc != "nested" {
+		t.Errorf("got widgets %v", b.Widgets)
+	}
+}
+
+func TestMarshal_KeyField(t *testing.T) {
+	dir := writeFiles(t, nil)
+	defer os.RemoveAll(dir)
+
+	b := KeyBase{
+		Things: map[string]Thing{
+			"":    {Name: "one"},
+			"two": {Name: "two"},
+		},
+		Widgets: map[string]*Widget{
+			"":         {Name: "a/b/widget1"},
+			"widget2":  {},
+			"c/widget": {Name: "c/widget"},
+		},
+	}
+	if err := hy.Marshal(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+	assertFiles(t, dir, map[string]bool{
+		"things/one.yaml":          true,
+		"things/two.yaml":          true,
+		"things/.yaml":             false,
+		"widgets/a/b/widget1.yaml": true,
+		"widgets/widget2.yaml":     true,
+		"widgets/c/widget.yaml":    true,
+	})
+
+	after := KeyBase{}
+	if err := hy.Unmarshal(dir, &after); err != nil {
+		t.Fatal(err)
+	}
+	if after.Widgets["widget2"] == nil || after.Widgets["widget2"].Name != "widget2" {
+		t.Errorf("got widgets %v", after.Widgets)
+	}
+}
+
+func TestMarshal_KeyFieldErrors(t *testing.T) {
+	cases := []struct {
+		v    interface{}
+		want string
+	}{
+		{&KeyBase{Things: map[string]Thing{"one": {Name: "two"}}}, "disagrees"},
+		{&KeyBase{Things: map[string]Thing{"": {}}}, "empty key"},
+		{&KeyBase{Things: map[string]Thing{"": {Name: "one"}, "one": {}}}, "more than one entry"},
+		{&BadKeyBase{Things: map[string]Thing{"one": {}}}, "no field Colour"},
+	}
+	for _, c := range cases {
+		dir := writeFiles(t, nil)
+		defer os.RemoveAll(dir)
+		err := hy.Marshal(dir, c.v)
+		if err == nil {
+			t.Errorf("got nil error; want error containing %q", c.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.want) {
+			t.Errorf("got error %q; want error containing %q", err, c.want)
+		}
+	}
+}
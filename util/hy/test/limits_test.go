@@ -0,0 +1,160 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/opentable/sous/util/hy"
+	"github.com/opentable/sous/util/yaml"
+)
+
+// limitErrors returns the errors in err by file, failing t unless err is
+// hy.Errors.
+func limitErrors(t *testing.T, err error) map[string]*hy.Error {
+	errs, ok := err.(hy.Errors)
+	if !ok {
+		t.Fatalf("got %T %v; want hy.Errors", err, err)
+	}
+	got := map[string]*hy.Error{}
+	for _, e := range errs {
+		got[e.File] = e
+	}
+	return got
+}
+
+// checkLimitErrors checks that err has exactly one error for each of want's
+// files, mentioning its value.
+func checkLimitErrors(t *testing.T, err error, want map[string]string) {
+	got := limitErrors(t, err)
+	for file, msg := range want {
+		if e, ok := got[file]; !ok {
+			t.Errorf("no error reported for %s; got:\n%v", file, err)
+		} else if !strings.Contains(e.Error(), msg) {
+			t.Errorf("got error %q for %s; want mention of %q", e, file, msg)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d errors; want %d:\n%v", len(got), len(want), err)
+	}
+}
+
+func TestUnmarshal_MaxFileSize(t *testing.T) {
+	big := "Name: big\nDesc: " + strings.Repeat("x", 2048) + "\n"
+	dir := writeFiles(t, map[string]string{
+		"config.json":        `{"Name": "` + strings.Repeat("x", 2048) + `"}`,
+		"things/small.yaml":  "Name: small\n",
+		"things/big.yaml":    big,
+		"widgets/a/big.yaml": big,
+		"widgets/b.yaml":     "Name: b\n",
+	})
+	defer os.RemoveAll(dir)
+
+	u := hy.NewUnmarshaler(yaml.Unmarshal)
+	u.MaxFileSize = 1024
+	b := JSONBase{}
+	checkLimitErrors(t, u.Unmarshal(dir, &b), map[string]string{
+		"config.json":        "more than the limit of 1024",
+		"things/big.yaml":    "more than the limit of 1024",
+		"widgets/a/big.yaml": "more than the limit of 1024",
+	})
+	if b.Things["small"].Name != "small" || b.Widgets["b"].Name != "b" {
+		t.Errorf("small files weren't read: got %+v", b)
+	}
+	if _, ok := b.Things["big"]; ok {
+		t.Errorf("big file was read: got %+v", b.Things)
+	}
+
+	u.MaxFileSize = -1
+	if err := u.Unmarshal(dir, &JSONBase{}); err != nil {
+		t.Errorf("with no limit: %v", err)
+	}
+}
+
+func TestUnmarshal_MaxFiles(t *testing.T) {
+	files := map[string]string{}
+	for i := 0; i < 5; i++ {
+		files[fmt.Sprintf("things/%d.yaml", i)] = fmt.Sprintf("Name: thing %d\n", i)
+		files[fmt.Sprintf("widgets/%d/w.yaml", i)] = fmt.Sprintf("Name: widget %d\n", i)
+	}
+	files["things/notes.txt"] = "not counted"
+	dir := writeFiles(t, files)
+	defer os.RemoveAll(dir)
+
+	u := hy.NewUnmarshaler(yaml.Unmarshal)
+	u.MaxFiles = 3
+	b := JSONBase{}
+	checkLimitErrors(t, u.Unmarshal(dir, &b), map[string]string{
+		"things":  "more than 3 files",
+		"widgets": "more than 3 files",
+	})
+	if got, want := thingKeys(b.Things), []string{"0", "1", "2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got things %q; want %q", got, want)
+	}
+	if got, want := widgetKeys(b.Widgets), []string{"0/w", "1/w", "2/w"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got widgets %q; want %q", got, want)
+	}
+
+	u.MaxFiles = 5
+	if err := u.Unmarshal(dir, &JSONBase{}); err != nil {
+		t.Errorf("with exactly MaxFiles files: %v", err)
+	}
+}
+
+func TestUnmarshal_MaxDepth(t *testing.T) {
+	// widgets/d/d/.../d.yaml, one at each depth from 0 to 9
+	files := map[string]string{}
+	for depth := 0; depth < 10; depth++ {
+		name := strings.Repeat("d/", depth) + "d.yaml"
+		files[path.Join("widgets", name)] = fmt.Sprintf("Name: depth %d\n", depth)
+	}
+	dir := writeFiles(t, files)
+	defer os.RemoveAll(dir)
+
+	u := hy.NewUnmarshaler(yaml.Unmarshal)
+	u.MaxDepth = 3
+	b := JSONBase{}
+	checkLimitErrors(t, u.Unmarshal(dir, &b), map[string]string{
+		"widgets/d/d/d/d": "more than 3 levels deep",
+	})
+	if got, want := widgetKeys(b.Widgets), []string{"d", "d/d", "d/d/d", "d/d/d/d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got widgets %q; want %q", got, want)
+	}
+
+	u.MaxDepth = -1
+	b = JSONBase{}
+	if err := u.Unmarshal(dir, &b); err != nil || len(b.Widgets) != 10 {
+		t.Errorf("with no limit: got %d widgets, %v; want 10", len(b.Widgets), err)
+	}
+}
+
+func TestUnmarshal_DefaultLimits(t *testing.T) {
+	deep := strings.Repeat("d/", hy.DefaultMaxDepth+1) + "deep.yaml"
+	dir := writeFiles(t, map[string]string{
+		path.Join("widgets", deep): "Name: deep\n",
+		"widgets/shallow.yaml":     "Name: shallow\n",
+	})
+	defer os.RemoveAll(dir)
+
+	b := JSONBase{}
+	tooDeep := "widgets/" + strings.TrimSuffix(strings.Repeat("d/", hy.DefaultMaxDepth+1), "/")
+	checkLimitErrors(t, hy.Unmarshal(dir, &b), map[string]string{
+		tooDeep: fmt.Sprintf("more than %d levels deep", hy.DefaultMaxDepth),
+	})
+	if got, want := widgetKeys(b.Widgets), []string{"shallow"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got widgets %q; want %q", got, want)
+	}
+}
+
+func thingKeys(ts map[string]Thing) []string {
+	keys := []string{}
+	for k := range ts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,165 @@
+package test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/opentable/sous/util/hy"
+	"github.com/opentable/sous/util/yaml"
+)
+
+type ManifestBase struct {
+	Config   Config              `hy:"config.yaml"`
+	Things   map[string]Thing    `hy:"things/"`
+	Clusters map[string]*Cluster `hy:"clusters/**"`
+}
+
+func TestUnmarshalManifest(t *testing.T) {
+	files := map[string]string{
+		"config.yaml":                  "Name: config\n",
+		"things/one.yaml":              "Name: one\n",
+		"things/two.json":              `{"Name": "two"}`,
+		"clusters/a/cluster.yaml":      "Name: a\n",
+		"clusters/a/services/s.yaml":   "Name: s\n",
+		"clusters/eu/b/cluster.yaml":   "Name: b\n",
+		"clusters/eu/b/services/t.yml": "Name: not read\n",
+	}
+	dir := writeFiles(t, files)
+	defer os.RemoveAll(dir)
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := hy.NewUnmarshaler(yaml.Unmarshal).UnmarshalManifest(dir, &ManifestBase{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type loc struct{ File, Field, Key string }
+	got := []loc{}
+	for _, r := range m {
+		rel, err := filepath.Rel(dir, r.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel = filepath.ToSlash(rel)
+		got = append(got, loc{rel, r.Field, r.Key})
+
+		if !filepath.IsAbs(r.Path) {
+			t.Errorf("%s: path is not absolute", r.Path)
+		}
+		h := sha256.Sum256([]byte(files[rel]))
+		if want := hex.EncodeToString(h[:]); r.Hash != want {
+			t.Errorf("%s: hash %s; want %s", rel, r.Hash, want)
+		}
+		if want := int64(len(files[rel])); r.Size != want {
+			t.Errorf("%s: size %d; want %d", rel, r.Size, want)
+		}
+		if r.ModTime.IsZero() {
+			t.Errorf("%s: zero mod time", rel)
+		}
+	}
+	want := []loc{
+		{"clusters/a/cluster.yaml", "Clusters[a].Config", ""},
+		{"clusters/a/services/s.yaml", "Clusters[a].Services", "s"},
+		{"clusters/eu/b/cluster.yaml", "Clusters[eu/b].Config", ""},
+		{"config.yaml", "Config", ""},
+		{"things/one.yaml", "Things", "one"},
+		{"things/two.json", "Things", "two"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got manifest\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestUnmarshalManifest_Remainder(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"service.yaml":              "Name: service\n",
+		"regions/eu/dc.json":        `{"region": "eu-west-1"}`,
+		"regions/eu/widgets/w.yaml": "Name: w\n",
+	})
+	defer os.RemoveAll(dir)
+
+	m, err := hy.NewUnmarshaler(yaml.Unmarshal).UnmarshalManifest(dir, &RemainderBase{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string][2]string{}
+	for _, r := range m {
+		rel, err := filepath.Rel(dir, r.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[filepath.ToSlash(rel)] = [2]string{r.Field, r.Key}
+	}
+	want := map[string][2]string{
+		"service.yaml":              {"", ""},
+		"regions/eu/dc.json":        {"Regions[eu]", ""},
+		"regions/eu/widgets/w.yaml": {"Regions[eu].Widgets", "w"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestUnmarshalManifest_ReportsFailingFiles(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"things/good.yaml": "Name: good\n",
+		"things/bad.yaml":  "Name: [\n",
+	})
+	defer os.RemoveAll(dir)
+
+	m, err := hy.NewUnmarshaler(yaml.Unmarshal).UnmarshalManifest(dir, &Base{})
+	if err == nil {
+		t.Fatal("no error unmarshaling bad.yaml")
+	}
+	if len(m) != 2 {
+		t.Errorf("got %d records; want 2: %v", len(m), m)
+	}
+}
+
+func TestReadManifest_Changed(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"config.yaml":       "Name: config\n",
+		"things/same.yaml":  "Name: same\n",
+		"things/touch.yaml": "Name: touch\n",
+		"things/edit.yaml":  "Name: edit\n",
+		"things/gone.yaml":  "Name: gone\n",
+	})
+	defer os.RemoveAll(dir)
+
+	m, err := hy.NewUnmarshaler(yaml.Unmarshal).UnmarshalManifest(dir, &Base{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "things/touch.yaml"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "things/edit.yaml"), []byte("Name: edited\n"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "things/gone.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := m.Changed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for _, r := range changed {
+		keys = append(keys, r.Key)
+	}
+	if want := []string{"edit", "gone"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("changed keys %q; want %q", keys, want)
+	}
+}
@@ -0,0 +1,107 @@
+package test
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/opentable/sous/util/hy"
+	"github.com/opentable/sous/util/yaml"
+)
+
+type MergeBase struct {
+	Config  Config             `hy:"config.yaml"`
+	Things  map[string]Thing   `hy:"things/"`
+	Widgets map[string]*Widget `hy:"widgets/**"`
+}
+
+func defaults() MergeBase {
+	return MergeBase{
+		Config: Config{Name: "default", Desc: "default config"},
+		Things: map[string]Thing{
+			"kept":     {Name: "kept", Desc: "default"},
+			"replaced": {Name: "default", Desc: "default"},
+		},
+		Widgets: map[string]*Widget{
+			"a/kept":     {Name: "kept", Desc: "default"},
+			"a/replaced": {Name: "default", Desc: "default"},
+		},
+	}
+}
+
+func TestUnmarshal_MergeModes(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"config.yaml":             "Name: config\n",
+		"things/replaced.yaml":    "Name: replaced\n",
+		"things/new.yaml":         "Name: new\n",
+		"widgets/a/replaced.yaml": "Name: replaced\n",
+	})
+	defer os.RemoveAll(dir)
+
+	cases := map[hy.MergeMode]struct {
+		config  Config
+		things  map[string]Thing
+		widgets map[string]Widget
+	}{
+		hy.MergeEntries: {
+			config: Config{Name: "config"},
+			things: map[string]Thing{
+				"kept":     {Name: "kept", Desc: "default"},
+				"replaced": {Name: "replaced"},
+				"new":      {Name: "new"},
+			},
+			widgets: map[string]Widget{
+				"a/kept":     {Name: "kept", Desc: "default"},
+				"a/replaced": {Name: "replaced"},
+			},
+		},
+		hy.DeepMerge: {
+			config: Config{Name: "config", Desc: "default config"},
+			things: map[string]Thing{
+				"kept":     {Name: "kept", Desc: "default"},
+				"replaced": {Name: "replaced", Desc: "default"},
+				"new":      {Name: "new"},
+			},
+			widgets: map[string]Widget{
+				"a/kept":     {Name: "kept", Desc: "default"},
+				"a/replaced": {Name: "replaced", Desc: "default"},
+			},
+		},
+		hy.ReplaceAll: {
+			config: Config{Name: "config"},
+			things: map[string]Thing{
+				"replaced": {Name: "replaced"},
+				"new":      {Name: "new"},
+			},
+			widgets: map[string]Widget{
+				"a/replaced": {Name: "replaced"},
+			},
+		},
+	}
+
+	for mode, want := range cases {
+		b := defaults()
+		originalWidget := b.Widgets["a/replaced"]
+		u := hy.NewUnmarshaler(yaml.Unmarshal)
+		u.Merge = mode
+		if err := u.Unmarshal(dir, &b); err != nil {
+			t.Fatal(err)
+		}
+		if b.Config != want.config {
+			t.Errorf("mode %d: got config %v; want %v", mode, b.Config, want.config)
+		}
+		if !reflect.DeepEqual(b.Things, want.things) {
+			t.Errorf("mode %d: got things %v; want %v", mode, b.Things, want.things)
+		}
+		widgets := map[string]Widget{}
+		for k, w := range b.Widgets {
+			widgets[k] = *w
+		}
+		if !reflect.DeepEqual(widgets, want.widgets) {
+			t.Errorf("mode %d: got widgets %v; want %v", mode, widgets, want.widgets)
+		}
+		if originalWidget.Name != "default" {
+			t.Errorf("mode %d: existing *Widget was modified: %v", mode, originalWidget)
+		}
+	}
+}
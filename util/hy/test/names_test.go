@@ -0,0 +1,143 @@
+package test
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/opentable/sous/util/hy"
+	"github.com/opentable/sous/util/yaml"
+)
+
+type NamesBase struct {
+	Things  map[string]Thing  `hy:"things/"`
+	Widgets map[string]Widget `hy:"widgets/**"`
+}
+
+func TestEscapedNames_RoundTrip(t *testing.T) {
+	for _, n := range []hy.EscapedNames{{}, {NestSlashes: true}} {
+		for _, key := range []string{
+			"plain",
+			"github.com/org/svc,api",
+			"docker.example.com:5000/svc",
+			".hidden/../up",
+			"100%, 50% off",
+			"",
+		} {
+			name := n.EncodeName(key)
+			if strings.ContainsAny(name, ",:") {
+				t.Errorf("%+v: %q encoded as %q", n, key, name)
+			}
+			if strings.Contains(name, "/") != (n.NestSlashes && strings.Contains(key, "/")) {
+				t.Errorf("%+v: %q encoded as %q", n, key, name)
+			}
+			if strings.HasPrefix(name, ".") || strings.Contains(name, "/.") {
+				t.Errorf("%+v: %q encoded as hidden name %q", n, key, name)
+			}
+			got, err := n.DecodeName(name)
+			if err != nil || got != key {
+				t.Errorf("%+v: %q encoded as %q, decoded as %q, %v", n, key, name, got, err)
+			}
+		}
+	}
+}
+
+func TestMarshal_EscapedNames(t *testing.T) {
+	cases := []struct {
+		names hy.EscapedNames
+		files map[string]bool
+	}{
+		{hy.EscapedNames{}, map[string]bool{
+			"things/svc%2Capi.yaml":                     true,
+			"things/docker.example.com%3A5000.yaml":     true,
+			"widgets/github.com%2Forg%2Fsvc%2Capi.yaml": true,
+			"widgets/%2Econfig.yaml":                    true,
+		}},
+		{hy.EscapedNames{NestSlashes: true}, map[string]bool{
+			"things/svc%2Capi.yaml":                 true,
+			"things/docker.example.com%3A5000.yaml": true,
+			"widgets/github.com/org/svc%2Capi.yaml": true,
+			"widgets/%2Econfig.yaml":                true,
+		}},
+	}
+	for _, c := range cases {
+		dir := writeFiles(t, nil)
+		defer os.RemoveAll(dir)
+
+		b := NamesBase{
+			Things: map[string]Thing{
+				"svc,api":                 {Name: "svc"},
+				"docker.example.com:5000": {Name: "registry"},
+			},
+			Widgets: map[string]Widget{
+				"github.com/org/svc,api": {Name: "svc"},
+				".config":                {Name: "config"},
+			},
+		}
+		m := hy.NewMarshaller(yaml.Marshal)
+		m.Names = c.names
+		if err := m.Marshal(dir, &b); err != nil {
+			t.Fatal(err)
+		}
+		assertFiles(t, dir, c.files)
+
+		u := hy.NewUnmarshaler(yaml.Unmarshal)
+		u.Names = c.names
+		after := NamesBase{}
+		if err := u.Unmarshal(dir, &after); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(after, b) {
+			t.Errorf("%+v: got %+v; want %+v", c.names, after, b)
+		}
+
+		// Stale entries are found by their escaped names.
+		delete(b.Widgets, ".config")
+		if err := m.Marshal(dir, &b); err != nil {
+			t.Fatal(err)
+		}
+		assertFiles(t, dir, map[string]bool{"widgets/%2Econfig.yaml": false})
+	}
+}
+
+func TestMarshal_EscapedSlashesInDirTargets(t *testing.T) {
+	dir := writeFiles(t, nil)
+	defer os.RemoveAll(dir)
+
+	b := NamesBase{Things: map[string]Thing{"github.com/org/svc": {Name: "svc"}}}
+	m := hy.NewMarshaller(yaml.Marshal)
+	m.Names = hy.EscapedNames{NestSlashes: true}
+	if err := m.Marshal(dir, &b); err == nil || !strings.Contains(err.Error(), "path separator") {
+		t.Errorf("got error %v; want a path separator error", err)
+	}
+
+	m.Names = hy.EscapedNames{}
+	if err := m.Marshal(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+	assertFiles(t, dir, map[string]bool{"things/github.com%2Forg%2Fsvc.yaml": true})
+}
+
+func TestUnmarshal_EscapedNameErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"things/bad%zz.yaml": "Name: bad\n",
+	})
+	defer os.RemoveAll(dir)
+
+	u := hy.NewUnmarshaler(yaml.Unmarshal)
+	u.Names = hy.EscapedNames{}
+	err := u.Unmarshal(dir, &NamesBase{})
+	if err == nil || !strings.Contains(err.Error(), `file name "bad%zz"`) {
+		t.Errorf("got error %v; want one naming bad%%zz", err)
+	}
+
+	// Without a NameCodec, the file name is the key.
+	b := NamesBase{}
+	if err := hy.Unmarshal(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.Things["bad%zz"]; !ok {
+		t.Errorf("got things %+v; want key bad%%zz", b.Things)
+	}
+}
@@ -0,0 +1,130 @@
+package test
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/opentable/sous/util/hy"
+)
+
+type (
+	NestedBase struct {
+		Clusters map[string]Cluster  `hy:"clusters/,key=Name"`
+		Regions  map[string]*Cluster `hy:"regions/**"`
+	}
+	Cluster struct {
+		Name     string
+		Config   Config           `hy:"cluster.yaml"`
+		Services map[string]Thing `hy:"services/"`
+	}
+)
+
+func clusterSummary(c *Cluster) []string {
+	s := []string{c.Name, c.Config.Name}
+	names := []string{}
+	for k, v := range c.Services {
+		names = append(names, k+"="+v.Name)
+	}
+	sort.Strings(names)
+	return append(s, names...)
+}
+
+func TestUnmarshal_NestedElements(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"clusters/a/cluster.yaml":          "Name: cluster a\n",
+		"clusters/a/services/one.yaml":     "Name: service one\n",
+		"clusters/a/services/two.json":     `{"Name": "service two"}`,
+		"clusters/b/cluster.yaml":          "Name: cluster b\n",
+		"clusters/notes.yaml":              "Name: not a cluster\n",
+		"regions/eu/west/cluster.yaml":     "Name: eu west\n",
+		"regions/eu/west/services/x.yaml":  "Name: service x\n",
+		"regions/us/cluster.yaml":          "{}\n",
+		"regions/us/services/y.yaml":       "Name: service y\n",
+		"regions/empty/nothing/README.txt": "not a region",
+	})
+	defer os.RemoveAll(dir)
+
+	b := NestedBase{}
+	if err := hy.Unmarshal(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+	wantClusters := map[string][]string{
+		"a": {"a", "cluster a", "one=service one", "two=service two"},
+		"b": {"b", "cluster b"},
+	}
+	gotClusters := map[string][]string{}
+	for k, c := range b.Clusters {
+		c := c
+		gotClusters[k] = clusterSummary(&c)
+	}
+	if !reflect.DeepEqual(gotClusters, wantClusters) {
+		t.Errorf("got clusters %q; want %q", gotClusters, wantClusters)
+	}
+	wantRegions := map[string][]string{
+		"eu/west": {"", "eu west", "x=service x"},
+		"us":      {"", "", "y=service y"},
+	}
+	gotRegions := map[string][]string{}
+	for k, c := range b.Regions {
+		gotRegions[k] = clusterSummary(c)
+	}
+	if !reflect.DeepEqual(gotRegions, wantRegions) {
+		t.Errorf("got regions %q; want %q", gotRegions, wantRegions)
+	}
+}
+
+func TestMarshal_NestedElements(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"clusters/old/cluster.yaml":       "Name: old\n",
+		"clusters/old/services/s.yaml":    "Name: s\n",
+		"clusters/a/services/gone.yaml":   "Name: gone\n",
+		"clusters/a/unrelated.txt":        "kept",
+		"regions/old/deep/cluster.yaml":   "Name: old\n",
+		"regions/eu/west/services/x.yaml": "Name: x\n",
+	})
+	defer os.RemoveAll(dir)
+
+	in := NestedBase{
+		Clusters: map[string]Cluster{
+			"a": {
+				Config:   Config{Name: "cluster a"},
+				Services: map[string]Thing{"one": {Name: "service one"}},
+			},
+			"": {Name: "b", Config: Config{Name: "cluster b"}},
+		},
+		Regions: map[string]*Cluster{
+			"eu/west": {
+				Config:   Config{Name: "eu west"},
+				Services: map[string]Thing{"x": {Name: "service x"}},
+			},
+		},
+	}
+	if err := hy.Marshal(dir, &in); err != nil {
+		t.Fatal(err)
+	}
+	assertFiles(t, dir, map[string]bool{
+		"clusters/a/cluster.yaml":         true,
+		"clusters/a/services/one.yaml":    true,
+		"clusters/a/services/gone.yaml":   false,
+		"clusters/a/unrelated.txt":        true,
+		"clusters/b/cluster.yaml":         true,
+		"clusters/a.yaml":                 false,
+		"clusters/old":                    false,
+		"regions/eu/west/cluster.yaml":    true,
+		"regions/eu/west/services/x.yaml": true,
+		"regions/old":                     false,
+	})
+
+	out := NestedBase{}
+	if err := hy.Unmarshal(dir, &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Clusters) != 2 || out.Clusters["b"].Config.Name != "cluster b" {
+		t.Errorf("got clusters %v", out.Clusters)
+	}
+	if r := out.Regions["eu/west"]; r == nil || r.Services["x"].Name != "service x" {
+		t.Errorf("got regions %v", out.Regions)
+	}
+}
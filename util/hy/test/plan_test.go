@@ -0,0 +1,123 @@
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/opentable/sous/util/hy"
+	"github.com/opentable/sous/util/yaml"
+)
+
+func planSummary(dir string, p *hy.Plan) map[string]hy.Action {
+	s := map[string]hy.Action{}
+	for _, c := range p.Changes {
+		rel, _ := filepath.Rel(dir, c.Path)
+		s[rel] = c.Action
+	}
+	return s
+}
+
+func listFiles(t *testing.T, dir string) []string {
+	files := []string{}
+	err := filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
+		if err == nil && !f.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, rel)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestPlan(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"things/same.yaml":    "Name: same\nDesc: \"\"\n",
+		"things/changed.yaml": "Name: old\nDesc: \"\"\n",
+		"things/gone.yaml":    "Name: gone\nDesc: \"\"\n",
+		"widgets/a/gone.yaml": "Name: gone\n",
+	})
+	defer os.RemoveAll(dir)
+	before := listFiles(t, dir)
+
+	b := PruneBase{
+		Things: map[string]Thing{
+			"same":    {Name: "same"},
+			"changed": {Name: "new"},
+			"new":     {Name: "new"},
+		},
+	}
+	m := hy.NewMarshaller(yaml.Marshal)
+	p, err := m.Plan(dir, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]hy.Action{
+		"things/changed.yaml": hy.ModifyFile,
+		"things/new.yaml":     hy.CreateFile,
+		"things/gone.yaml":    hy.DeleteFile,
+		"widgets/a/gone.yaml": hy.DeleteFile,
+	}
+	if got := planSummary(dir, p); !reflect.DeepEqual(got, want) {
+		t.Errorf("got plan %v; want %v", got, want)
+	}
+	for _, c := range p.Changes {
+		if c.Action == hy.ModifyFile && string(c.Old) != "Name: old\nDesc: \"\"\n" {
+			t.Errorf("got old content %q for %s", c.Old, c.Path)
+		}
+		if c.Action != hy.DeleteFile && len(c.New) == 0 {
+			t.Errorf("no new content for %s", c)
+		}
+	}
+	if after := listFiles(t, dir); !reflect.DeepEqual(after, before) {
+		t.Errorf("Plan changed files: got %v; want %v", after, before)
+	}
+	if len(b.Things) != 3 {
+		t.Errorf("Plan changed its input: %v", b)
+	}
+
+	if err := p.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	want2 := []string{"things/changed.yaml", "things/new.yaml", "things/same.yaml"}
+	if got := listFiles(t, dir); !reflect.DeepEqual(got, want2) {
+		t.Errorf("got files %v after Apply; want %v", got, want2)
+	}
+	again, err := m.Plan(dir, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again.Changes) != 0 {
+		t.Errorf("got changes %v after Apply; want none", again.Changes)
+	}
+}
+
+func TestPlan_ApplyRefusesChangedFiles(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"things/one.yaml": "Name: one\n",
+	})
+	defer os.RemoveAll(dir)
+
+	p, err := hy.NewMarshaller(yaml.Marshal).Plan(dir, &PruneBase{
+		Things: map[string]Thing{"one": {Name: "uno"}, "two": {Name: "two"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "things/one.yaml"), []byte("Name: edited\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Apply(); err == nil {
+		t.Fatal("got nil error; want error for changed file")
+	}
+	if got, want := listFiles(t, dir), []string{"things/one.yaml"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got files %v; want %v", got, want)
+	}
+}
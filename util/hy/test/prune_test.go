@@ -0,0 +1,114 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opentable/sous/util/hy"
+)
+
+type PruneBase struct {
+	Things  map[string]Thing  `hy:"things/"`
+	Widgets map[string]Widget `hy:"widgets/**"`
+}
+
+func assertFiles(t *testing.T, dir string, exist map[string]bool) {
+	for name, want := range exist {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if got := err == nil; got != want {
+			t.Errorf("%s exists: %t; want %t (%v)", name, got, want, err)
+		}
+	}
+}
+
+func TestMarshal_RemovesDeletedEntries(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"things/one.yaml":          "Name: one\n",
+		"things/two.json":          `{"Name": "two"}`,
+		"things/notes.txt":         "not a thing",
+		"things/sub/three.yaml":    "Name: three\n",
+		"widgets/a/b/one.yaml":     "Name: one\n",
+		"widgets/a/b/two.yaml":     "Name: two\n",
+		"widgets/c/three.json":     `{"Name": "three"}`,
+		"widgets/c/README":         "not a widget",
+		"unrelated/whatever.yaml":  "Name: whatever\n",
+		"widgets/d/e/f/four.yaml":  "Name: four\n",
+		"widgets/d/e/keep/x.notes": "not a widget",
+	})
+	defer os.RemoveAll(dir)
+
+	b := PruneBase{}
+	if err := hy.Unmarshal(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+	delete(b.Things, "two")
+	delete(b.Widgets, "a/b/two")
+	delete(b.Widgets, "c/three")
+	delete(b.Widgets, "d/e/f/four")
+	if err := hy.Marshal(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+
+	assertFiles(t, dir, map[string]bool{
+		"things/one.yaml":          true,
+		"things/two.json":          false,
+		"things/notes.txt":         true,
+		"things/sub/three.yaml":    true,
+		"widgets/a/b/one.yaml":     true,
+		"widgets/a/b/two.yaml":     false,
+		"widgets/c/three.json":     false,
+		"widgets/c/README":         true,
+		"unrelated/whatever.yaml":  true,
+		"widgets/d/e/f":            false,
+		"widgets/d/e/keep/x.notes": true,
+	})
+
+	after := PruneBase{}
+	if err := hy.Unmarshal(dir, &after); err != nil {
+		t.Fatal(err)
+	}
+	if len(after.Things) != 1 || len(after.Widgets) != 1 {
+		t.Errorf("got things %v and widgets %v; want one of each", after.Things, after.Widgets)
+	}
+}
+
+func TestMarshal_RemovesEntryWhoseKeyChangedCase(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"widgets/Some/Widget.yaml": "Name: widget\n",
+	})
+	defer os.RemoveAll(dir)
+
+	b := PruneBase{Widgets: map[string]Widget{
+		"some/widget": {Name: "widget"},
+	}}
+	if err := hy.Marshal(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+
+	after := PruneBase{}
+	if err := hy.Unmarshal(dir, &after); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := after.Widgets["some/widget"]; !ok || len(after.Widgets) != 1 {
+		t.Errorf("got widgets %v; want only some/widget", after.Widgets)
+	}
+}
+
+func TestMarshal_RemovesAllEntries(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"things/one.yaml":   "Name: one\n",
+		"widgets/a/b.yaml":  "Name: b\n",
+		"widgets/a/keep.md": "not a widget",
+	})
+	defer os.RemoveAll(dir)
+
+	if err := hy.Marshal(dir, &PruneBase{}); err != nil {
+		t.Fatal(err)
+	}
+	assertFiles(t, dir, map[string]bool{
+		"things/one.yaml":   false,
+		"widgets/a/b.yaml":  false,
+		"widgets/a/keep.md": true,
+	})
+}
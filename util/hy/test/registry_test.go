@@ -0,0 +1,141 @@
+package test
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/opentable/sous/util/hy"
+)
+
+// ini is a toy codec registered for .ini files, which are JSON with a
+// header line.
+const iniHeader = "; ini\n"
+
+func init() {
+	hy.RegisterCodec(hy.Codec{
+		Ext: ".ini",
+		Marshal: func(v interface{}) ([]byte, error) {
+			b, err := json.Marshal(v)
+			return append([]byte(iniHeader), b...), err
+		},
+		Unmarshal: func(b []byte, v interface{}) error {
+			return json.Unmarshal([]byte(strings.TrimPrefix(string(b), iniHeader)), v)
+		},
+		UnmarshalStrict: func(b []byte, v interface{}) error {
+			return hy.JSONCodec.UnmarshalStrict([]byte(strings.TrimPrefix(string(b), iniHeader)), v)
+		},
+	})
+}
+
+type IniBase struct {
+	Config Config           `hy:"config.ini"`
+	Things map[string]Thing `hy:"things/"`
+}
+
+func TestRegisterCodec_RoundTrip(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"config.ini":        iniHeader + `{}`,
+		"things/legacy.ini": iniHeader + `{"Name": "legacy"}`,
+		"things/new.yaml":   "Name: new\n",
+	})
+	defer os.RemoveAll(dir)
+
+	b := IniBase{}
+	if err := hy.Unmarshal(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Things["legacy"].Name != "legacy" || b.Things["new"].Name != "new" {
+		t.Fatalf("got things %v", b.Things)
+	}
+	b.Config.Name = "config"
+	b.Things["legacy"] = Thing{Name: "changed"}
+	if err := hy.Marshal(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+	assertFiles(t, dir, map[string]bool{
+		"config.ini":         true,
+		"things/legacy.ini":  true,
+		"things/legacy.yaml": false,
+	})
+
+	after := IniBase{}
+	if err := hy.Unmarshal(dir, &after); err != nil {
+		t.Fatal(err)
+	}
+	if after.Config.Name != "config" || after.Things["legacy"].Name != "changed" {
+		t.Errorf("got %+v", after)
+	}
+}
+
+func TestRegisterCodec_Strict(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"config.ini":       iniHeader + `{}`,
+		"things/good.ini":  iniHeader + `{"Name": "good"}`,
+		"things/typo.ini":  iniHeader + `{"Nmae": "typo"}`,
+		"things/other.ini": iniHeader + `{"Name": "other"}`,
+	})
+	defer os.RemoveAll(dir)
+
+	err := hy.UnmarshalStrict(dir, &IniBase{})
+	if err == nil || !strings.Contains(err.Error(), "Nmae") {
+		t.Fatalf("got error %v; want one naming the unknown field", err)
+	}
+
+	if err := os.Remove(dir + "/things/typo.ini"); err != nil {
+		t.Fatal(err)
+	}
+	b := IniBase{}
+	if err := hy.UnmarshalStrict(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Things["good"].Name != "good" {
+		t.Errorf("got things %v", b.Things)
+	}
+}
+
+func TestRegisterCodec_Collision(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"things/foo.yaml": "Name: foo\n",
+		"things/foo.ini":  iniHeader + `{"Name": "foo"}`,
+		"config.ini":      iniHeader + `{}`,
+	})
+	defer os.RemoveAll(dir)
+
+	err := hy.Unmarshal(dir, &IniBase{})
+	if err == nil {
+		t.Fatal("got nil error; want duplicate key error")
+	}
+	for _, name := range []string{"things/foo.yaml", "things/foo.ini"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error %q does not mention %s", err, name)
+		}
+	}
+}
+
+func TestRegisterCodec_Override(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"config.ini":     `{}`,
+		"things/one.ini": `{"Name": "one"}`,
+	})
+	defer os.RemoveAll(dir)
+
+	plain := hy.Codec{Ext: ".ini", Unmarshal: json.Unmarshal}
+	b := IniBase{}
+	if err := hy.Unmarshal(dir, &b, plain); err != nil {
+		t.Fatal(err)
+	}
+	if b.Things["one"].Name != "one" {
+		t.Errorf("got things %v", b.Things)
+	}
+}
+
+func TestRegisterCodec_RejectsYAML(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("registering .yaml did not panic")
+		}
+	}()
+	hy.RegisterCodec(hy.Codec{Ext: ".yaml"})
+}
@@ -0,0 +1,123 @@
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/opentable/sous/util/hy"
+)
+
+type (
+	RemainderBase struct {
+		_        struct{} `hy:"service.yaml,remainder"`
+		Name     string
+		Replicas int
+		Owners   []string
+		Things   map[string]Thing       `hy:"things/"`
+		Regions  map[string]*Datacenter `hy:"regions/"`
+	}
+	Datacenter struct {
+		_       struct{}          `hy:"dc.json,remainder"`
+		Region  string            `json:"region"`
+		Hosts   []string          `json:"hosts,omitempty"`
+		Widgets map[string]Widget `hy:"widgets/"`
+	}
+	BadRemainderBase struct {
+		_    struct{} `hy:"rest/,remainder"`
+		Name string
+	}
+)
+
+func TestMarshal_RemainderRoundTrip(t *testing.T) {
+	dir := writeFiles(t, nil)
+	defer os.RemoveAll(dir)
+
+	b := RemainderBase{
+		Name:     "service",
+		Replicas: 3,
+		Owners:   []string{"sam", "judson"},
+		Things:   map[string]Thing{"one": {Name: "one"}},
+		Regions: map[string]*Datacenter{
+			"eu": {
+				Region:  "eu-west-1",
+				Widgets: map[string]Widget{"w": {Name: "w"}},
+			},
+		},
+	}
+	if err := hy.Marshal(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+	assertFiles(t, dir, map[string]bool{
+		"service.yaml":               true,
+		"things/one.yaml":            true,
+		"regions/eu/dc.json":         true,
+		"regions/eu/widgets/w.yaml":  true,
+		"regions/eu/dc.yaml":         false,
+		"regions/eu/Widgets.yaml":    false,
+		"regions/eu/widgets/w/.yaml": false,
+	})
+	for file, notWant := range map[string][]string{
+		"service.yaml":       {"Things", "Regions", "one", "eu-west-1"},
+		"regions/eu/dc.json": {"Widgets", "hosts"},
+	} {
+		contents, err := ioutil.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range notWant {
+			if strings.Contains(string(contents), s) {
+				t.Errorf("%s contains %q:\n%s", file, s, contents)
+			}
+		}
+	}
+
+	after := RemainderBase{}
+	if err := hy.Unmarshal(dir, &after); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(after, b) {
+		t.Errorf("got %+v; want %+v", after, b)
+	}
+}
+
+func TestUnmarshal_Remainder(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"service.yaml":    "Name: service\nReplicas: 2\n",
+		"things/one.yaml": "Name: one\n",
+	})
+	defer os.RemoveAll(dir)
+
+	b := RemainderBase{}
+	if err := hy.UnmarshalStrict(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Name != "service" || b.Replicas != 2 || b.Things["one"].Name != "one" {
+		t.Errorf("got %+v", b)
+	}
+}
+
+func TestUnmarshalStrict_RemainderRejectsTaggedFields(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"service.yaml": "Name: service\nThings: {}\n",
+	})
+	defer os.RemoveAll(dir)
+
+	err := hy.UnmarshalStrict(dir, &RemainderBase{})
+	if err == nil || !strings.Contains(err.Error(), "Things") {
+		t.Errorf("got error %v; want one mentioning Things", err)
+	}
+}
+
+func TestMarshal_RemainderMustBeFile(t *testing.T) {
+	dir := writeFiles(t, nil)
+	defer os.RemoveAll(dir)
+
+	err := hy.Marshal(dir, &BadRemainderBase{Name: "x"})
+	if err == nil || !strings.Contains(err.Error(), "not a file") {
+		t.Errorf("got error %v; want remainder source is not a file", err)
+	}
+}
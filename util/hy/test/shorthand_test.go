@@ -0,0 +1,125 @@
+package test
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/opentable/sous/util/hy"
+	"github.com/opentable/sous/util/yaml"
+)
+
+type (
+	ShorthandBase struct {
+		Config   Config            `hy:"config.yaml"`
+		Owner    Thing             `hy:"yaml"`
+		Backup   *Thing            `hy:"json"`
+		Replicas int               `hy:"yaml"`
+		Region   string            `hy:"yaml"`
+		Things   map[string]Thing  `hy:"things/"`
+		Widgets  map[string]Widget `hy:"widgets/**"`
+	}
+	RequiredBase struct {
+		Owner Thing `hy:"yaml,required"`
+	}
+)
+
+func TestMarshal_ShorthandRoundTrip(t *testing.T) {
+	dir := writeFiles(t, nil)
+	defer os.RemoveAll(dir)
+
+	b := ShorthandBase{
+		Config:   Config{Name: "config"},
+		Owner:    Thing{Name: "owner"},
+		Backup:   &Thing{Name: "backup"},
+		Replicas: 3,
+		Region:   "eu",
+		Things:   map[string]Thing{"one": {Name: "one"}},
+		Widgets:  map[string]Widget{"a/w": {Name: "w"}},
+	}
+	if err := hy.Marshal(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+	assertFiles(t, dir, map[string]bool{
+		"config.yaml":      true,
+		"owner.yaml":       true,
+		"backup.json":      true,
+		"replicas.yaml":    true,
+		"region.yaml":      true,
+		"things/one.yaml":  true,
+		"widgets/a/w.yaml": true,
+		"Owner.yaml":       false,
+		"yaml":             false,
+	})
+
+	after := ShorthandBase{}
+	if err := hy.Unmarshal(dir, &after); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(after, b) {
+		t.Errorf("got %#v; want %#v", after, b)
+	}
+}
+
+func TestUnmarshal_MissingFilesAreZero(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"owner.yaml":      "Name: owner\n",
+		"things/one.yaml": "Name: one\n",
+	})
+	defer os.RemoveAll(dir)
+
+	b := ShorthandBase{}
+	if err := hy.Unmarshal(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+	want := ShorthandBase{
+		Owner:  Thing{Name: "owner"},
+		Things: map[string]Thing{"one": {Name: "one"}},
+	}
+	if !reflect.DeepEqual(b, want) {
+		t.Errorf("got %#v; want %#v", b, want)
+	}
+}
+
+func TestUnmarshal_RequiredFile(t *testing.T) {
+	dir := writeFiles(t, nil)
+	defer os.RemoveAll(dir)
+
+	err := hy.Unmarshal(dir, &RequiredBase{})
+	errs, ok := err.(hy.Errors)
+	if !ok || len(errs) != 1 {
+		t.Fatalf("got error %v; want one hy.Error", err)
+	}
+	if !strings.HasSuffix(errs[0].File, "owner.yaml") {
+		t.Errorf("error is in %s; want owner.yaml", errs[0].File)
+	}
+}
+
+func TestShorthand_FileName(t *testing.T) {
+	dir := writeFiles(t, nil)
+	defer os.RemoveAll(dir)
+
+	fileName := func(field string) string { return "the-" + strings.ToLower(field) }
+	m := hy.NewMarshaller(yaml.Marshal)
+	m.FileName = fileName
+	b := ShorthandBase{Owner: Thing{Name: "owner"}, Replicas: 2}
+	if err := m.Marshal(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+	assertFiles(t, dir, map[string]bool{
+		"the-owner.yaml":    true,
+		"the-replicas.yaml": true,
+		"owner.yaml":        false,
+	})
+
+	u := hy.NewUnmarshaler(yaml.Unmarshal)
+	u.FileName = fileName
+	after := ShorthandBase{}
+	if err := u.Unmarshal(dir, &after); err != nil {
+		t.Fatal(err)
+	}
+	if after.Owner.Name != "owner" || after.Replicas != 2 {
+		t.Errorf("got %#v; want Owner.Name owner and Replicas 2", after)
+	}
+}
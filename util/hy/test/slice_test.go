@@ -0,0 +1,120 @@
+package test
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/opentable/sous/util/hy"
+)
+
+type SliceBase struct {
+	Things  []Thing   `hy:"things/,key=Name"`
+	Widgets []*Widget `hy:"widgets/**"`
+	Names   []string  `hy:"names/"`
+}
+
+func TestUnmarshal_Slices(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"things/b.yaml":      "Desc: second\n",
+		"things/a.json":      `{"Desc": "first"}`,
+		"things/c.yaml":      "Desc: third\n",
+		"widgets/a/b.yaml":   "Name: a/b\n",
+		"widgets/a.yaml":     "Name: a\n",
+		"widgets/0/z.yaml":   "Name: 0/z\n",
+		"names/002.yaml":     "two\n",
+		"names/010.yaml":     "ten\n",
+		"names/001.yaml":     "one\n",
+		"names/ignored.text": "ignored\n",
+	})
+	defer os.RemoveAll(dir)
+
+	b := SliceBase{Names: []string{"stale"}}
+	if err := hy.Unmarshal(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+	wantThings := []Thing{{"a", "first"}, {"b", "second"}, {"c", "third"}}
+	if !reflect.DeepEqual(b.Things, wantThings) {
+		t.Errorf("got things %v; want %v", b.Things, wantThings)
+	}
+	gotWidgets := []string{}
+	for _, w := range b.Widgets {
+		gotWidgets = append(gotWidgets, w.Name)
+	}
+	if want := []string{"0/z", "a", "a/b"}; !reflect.DeepEqual(gotWidgets, want) {
+		t.Errorf("got widgets %v; want %v", gotWidgets, want)
+	}
+	if want := []string{"one", "two", "ten"}; !reflect.DeepEqual(b.Names, want) {
+		t.Errorf("got names %v; want %v", b.Names, want)
+	}
+}
+
+func TestMarshal_SlicesRoundTrip(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"names/005.yaml": "stale\n",
+	})
+	defer os.RemoveAll(dir)
+
+	in := SliceBase{
+		Things:  []Thing{{Name: "z"}, {Name: "a", Desc: "first"}},
+		Widgets: []*Widget{{Name: "one"}, {Name: "two"}},
+		Names:   []string{"one", "two", "three"},
+	}
+	if err := hy.Marshal(dir, &in); err != nil {
+		t.Fatal(err)
+	}
+	assertFiles(t, dir, map[string]bool{
+		"things/z.yaml":    true,
+		"things/a.yaml":    true,
+		"widgets/000.yaml": true,
+		"widgets/001.yaml": true,
+		"names/000.yaml":   true,
+		"names/002.yaml":   true,
+		"names/005.yaml":   false,
+	})
+
+	out := SliceBase{}
+	if err := hy.Unmarshal(dir, &out); err != nil {
+		t.Fatal(err)
+	}
+	// Things are named by key, so come back in key order.
+	if want := []Thing{{"a", "first"}, {"z", ""}}; !reflect.DeepEqual(out.Things, want) {
+		t.Errorf("got things %v; want %v", out.Things, want)
+	}
+	if len(out.Widgets) != 2 || out.Widgets[1].Name != "two" {
+		t.Errorf("got widgets %v", out.Widgets)
+	}
+	if want := []string{"one", "two", "three"}; !reflect.DeepEqual(out.Names, want) {
+		t.Errorf("got names %v; want %v", out.Names, want)
+	}
+}
+
+func TestMarshal_SliceKeyErrors(t *testing.T) {
+	for _, things := range [][]Thing{
+		{{Name: "a"}, {Name: ""}},
+		{{Name: "a"}, {Name: "a"}},
+	} {
+		dir := writeFiles(t, nil)
+		defer os.RemoveAll(dir)
+		if err := hy.Marshal(dir, &SliceBase{Things: things}); err == nil {
+			t.Errorf("got nil error marshaling %v", things)
+		}
+	}
+}
+
+func TestMarshal_MapStillWorks(t *testing.T) {
+	dir := writeFiles(t, nil)
+	defer os.RemoveAll(dir)
+
+	in := PruneBase{Things: map[string]Thing{"one": {Name: "one"}}}
+	if err := hy.Marshal(dir, &in); err != nil {
+		t.Fatal(err)
+	}
+	out := PruneBase{}
+	if err := hy.Unmarshal(dir, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Things["one"].Name != "one" {
+		t.Errorf("got %v", out.Things)
+	}
+}
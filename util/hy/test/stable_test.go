@@ -0,0 +1,97 @@
+package test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/opentable/sous/util/hy"
+	"github.com/opentable/sous/util/yaml"
+)
+
+type StableBase struct {
+	Config  map[string]string `hy:"config.yaml"`
+	Things  map[string]Thing  `hy:"things/"`
+	Widgets map[string]Widget `hy:"widgets/**"`
+}
+
+func stableValue() *StableBase {
+	b := &StableBase{
+		Config:  map[string]string{},
+		Things:  map[string]Thing{},
+		Widgets: map[string]Widget{},
+	}
+	for i := 0; i < 50; i++ {
+		b.Config[fmt.Sprintf("key%d", i)] = fmt.Sprint(i)
+		b.Things[fmt.Sprintf("thing%d", i)] = Thing{Name: fmt.Sprint(i)}
+		b.Widgets[fmt.Sprintf("w/%d/widget", i)] = Widget{Name: fmt.Sprint(i)}
+	}
+	return b
+}
+
+func readTree(t *testing.T, dir string) map[string]string {
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
+		if err != nil || f.IsDir() {
+			return err
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		files[rel] = string(b)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestMarshal_Stable(t *testing.T) {
+	dir1, dir2 := writeFiles(t, nil), writeFiles(t, nil)
+	defer os.RemoveAll(dir1)
+	defer os.RemoveAll(dir2)
+
+	if err := hy.Marshal(dir1, stableValue()); err != nil {
+		t.Fatal(err)
+	}
+	if err := hy.Marshal(dir2, stableValue()); err != nil {
+		t.Fatal(err)
+	}
+	files1, files2 := readTree(t, dir1), readTree(t, dir2)
+	if len(files1) != 101 {
+		t.Errorf("wrote %d files; want 101", len(files1))
+	}
+	if !reflect.DeepEqual(files1, files2) {
+		t.Error("marshaling the same value twice wrote different files")
+	}
+}
+
+func TestPlan_Stable(t *testing.T) {
+	dir := writeFiles(t, nil)
+	defer os.RemoveAll(dir)
+
+	m := hy.NewMarshaller(yaml.Marshal)
+	paths := func() []string {
+		p, err := m.Plan(dir, stableValue())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ps := make([]string, len(p.Changes))
+		for i, c := range p.Changes {
+			ps[i] = c.Path
+		}
+		return ps
+	}
+	first := paths()
+	for i := 0; i < 5; i++ {
+		if got := paths(); !reflect.DeepEqual(got, first) {
+			t.Fatal("planning the same value twice gave changes in a different order")
+		}
+	}
+}
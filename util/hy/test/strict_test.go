@@ -0,0 +1,73 @@
+package test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/opentable/sous/util/hy"
+)
+
+func TestUnmarshalStrict_RejectsUnknownFields(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"config.json":          `{"Name": "Dave"}`,
+		"things/good.yaml":     "Name: good\n",
+		"things/typo.yaml":     "Name: typo\nDsec: oops\n",
+		"widgets/a/typo.json":  `{"Name": "typo", "Dsec": "oops"}`,
+		"widgets/a/b/bad.yaml": "Name: bad\n  Desc: [\n",
+	})
+	defer os.RemoveAll(dir)
+
+	if err := hy.Unmarshal(dir, &JSONBase{}); err == nil {
+		t.Error("got nil error for syntax error in non-strict mode")
+	}
+
+	err := hy.UnmarshalStrict(dir, &JSONBase{})
+	errs, ok := err.(hy.Errors)
+	if !ok {
+		t.Fatalf("got %T %v; want hy.Errors", err, err)
+	}
+	got := map[string]*hy.Error{}
+	for _, e := range errs {
+		got[e.File] = e
+	}
+	for _, file := range []string{"things/typo.yaml", "widgets/a/typo.json", "widgets/a/b/bad.yaml"} {
+		e, ok := got[file]
+		if !ok {
+			t.Errorf("no error reported for %s; got %v", file, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), file) {
+			t.Errorf("error message does not mention %s:\n%s", file, err)
+		}
+		if file == "widgets/a/b/bad.yaml" && e.Line == 0 {
+			t.Errorf("no line number reported for %s: %v", file, e)
+		}
+	}
+	if len(errs) != 3 {
+		t.Errorf("got %d errors; want 3:\n%s", len(errs), err)
+	}
+	if !strings.Contains(got["things/typo.yaml"].Error(), "Dsec") {
+		t.Errorf("got %v; want mention of unknown field Dsec", got["things/typo.yaml"])
+	}
+}
+
+func TestUnmarshalStrict_AcceptsKnownFields(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"config.json":         `{"Name": "Dave", "Desc": "config"}`,
+		"things/one.yaml":     "Name: one\nDesc: first\n",
+		"widgets/a/two.json":  `{"Name": "two"}`,
+		"widgets/three.yaml":  "Name: three\n",
+		"widgets/notes.txt":   "Dsec: not read\n",
+		"widgets/a/README.md": "not read either",
+	})
+	defer os.RemoveAll(dir)
+
+	b := JSONBase{}
+	if err := hy.UnmarshalStrict(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Things["one"].Desc != "first" || len(b.Widgets) != 2 {
+		t.Errorf("got %+v", b)
+	}
+}
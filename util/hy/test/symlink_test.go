@@ -0,0 +1,113 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/opentable/sous/util/hy"
+	"github.com/opentable/sous/util/yaml"
+)
+
+// symlinkTree writes a tree with a shared directory linked into widgets/, a
+// symlinked file, and a link back to the root of the tree.
+func symlinkTree(t *testing.T) string {
+	dir := writeFiles(t, map[string]string{
+		"shared/common.yaml":     "Name: common\n",
+		"elsewhere/linked.yaml":  "Name: linked\n",
+		"widgets/own/local.yaml": "Name: local\n",
+	})
+	links := map[string]string{
+		"widgets/shared":       "../shared",
+		"widgets/file.yaml":    "../elsewhere/linked.yaml",
+		"widgets/own/loop":     "..",
+		"widgets/own/selfloop": ".",
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func widgetKeys(ws map[string]Widget) []string {
+	keys := []string{}
+	for k := range ws {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestUnmarshal_SymlinksNotFollowedByDefault(t *testing.T) {
+	dir := symlinkTree(t)
+	defer os.RemoveAll(dir)
+
+	b := PruneBase{}
+	if err := hy.Unmarshal(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := widgetKeys(b.Widgets), []string{"file", "own/local"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got widgets %q; want %q", got, want)
+	}
+	if b.Widgets["file"].Name != "linked" {
+		t.Errorf("symlinked file read as %v", b.Widgets["file"])
+	}
+}
+
+func TestUnmarshal_FollowSymlinks(t *testing.T) {
+	dir := symlinkTree(t)
+	defer os.RemoveAll(dir)
+
+	u := hy.NewUnmarshaler(yaml.Unmarshal)
+	u.FollowSymlinks = true
+	b := PruneBase{}
+	if err := u.Unmarshal(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+	// own/loop and own/selfloop link to ancestors, so are skipped.
+	want := []string{"file", "own/local", "shared/common"}
+	if got := widgetKeys(b.Widgets); !reflect.DeepEqual(got, want) {
+		t.Errorf("got widgets %q; want %q", got, want)
+	}
+}
+
+func TestUnmarshal_BrokenSymlink(t *testing.T) {
+	for _, follow := range []bool{false, true} {
+		dir := writeFiles(t, map[string]string{
+			"things/good.yaml":  "Name: good\n",
+			"widgets/good.yaml": "Name: good\n",
+		})
+		defer os.RemoveAll(dir)
+		for _, link := range []string{"things/broken.yaml", "widgets/a/broken.yaml"} {
+			path := filepath.Join(dir, link)
+			if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink("missing.yaml", path); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		u := hy.NewUnmarshaler(yaml.Unmarshal)
+		u.FollowSymlinks = follow
+		b := PruneBase{}
+		err := u.Unmarshal(dir, &b)
+		errs, ok := err.(hy.Errors)
+		if !ok || len(errs) != 2 {
+			t.Fatalf("follow=%t: got %v; want an error for each broken link", follow, err)
+		}
+		for _, want := range []string{"things/broken.yaml", "widgets/a/broken.yaml"} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("follow=%t: error does not mention %s:\n%s", follow, want, err)
+			}
+		}
+		if b.Things["good"].Name != "good" || b.Widgets["good"].Name != "good" {
+			t.Errorf("follow=%t: good files not read: %v", follow, b)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/opentable/sous/util/hy"
+)
+
+type (
+	// SourceLoc is written as "repo,dir", e.g. "github.com/x/y,sub".
+	SourceLoc struct{ Repo, Dir string }
+
+	TextKeyBase struct {
+		Things  map[SourceLoc]Thing   `hy:"things/"`
+		Widgets map[SourceLoc]*Widget `hy:"widgets/**"`
+	}
+	NamedKeyBase struct {
+		Things map[ThingName]Thing `hy:"things/"`
+	}
+	ThingName      string
+	BadKeyTypeBase struct {
+		Things map[int]Thing `hy:"things/"`
+	}
+)
+
+func (l SourceLoc) MarshalText() ([]byte, error) {
+	if l.Repo == "" {
+		return nil, fmt.Errorf("empty repo")
+	}
+	return []byte(l.Repo + "," + l.Dir), nil
+}
+
+func (l *SourceLoc) UnmarshalText(b []byte) error {
+	s := string(b)
+	i := strings.LastIndex(s, ",")
+	if i < 1 {
+		return fmt.Errorf("want repo,dir")
+	}
+	l.Repo, l.Dir = s[:i], s[i+1:]
+	return nil
+}
+
+func TestMarshal_TextKeys(t *testing.T) {
+	dir := writeFiles(t, nil)
+	defer os.RemoveAll(dir)
+
+	b := TextKeyBase{
+		Things: map[SourceLoc]Thing{
+			{Repo: "one"}:             {Name: "one"},
+			{Repo: "two", Dir: "sub"}: {Name: "two"},
+		},
+		Widgets: map[SourceLoc]*Widget{
+			{Repo: "github.com/x/y", Dir: "sub"}: {Name: "y"},
+			{Repo: "z"}:                          {Name: "z"},
+		},
+	}
+	if err := hy.Marshal(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+	assertFiles(t, dir, map[string]bool{
+		"things/one,.yaml":                true,
+		"things/two,sub.yaml":             true,
+		"widgets/github.com/x/y,sub.yaml": true,
+		"widgets/z,.yaml":                 true,
+	})
+
+	after := TextKeyBase{}
+	if err := hy.Unmarshal(dir, &after); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(after, b) {
+		t.Errorf("got %+v; want %+v", after, b)
+	}
+}
+
+func TestMarshal_TextKeyErrors(t *testing.T) {
+	cases := []struct {
+		v    interface{}
+		want string
+	}{
+		{&TextKeyBase{Things: map[SourceLoc]Thing{{Repo: "a/b"}: {}}}, "path separator"},
+		{&TextKeyBase{Things: map[SourceLoc]Thing{{}: {}}}, "empty repo"},
+		{&BadKeyTypeBase{Things: map[int]Thing{1: {}}}, "map key type int"},
+	}
+	for _, c := range cases {
+		dir := writeFiles(t, nil)
+		defer os.RemoveAll(dir)
+		err := hy.Marshal(dir, c.v)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("got error %v; want error containing %q", err, c.want)
+		}
+	}
+}
+
+func TestUnmarshal_TextKeyErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"things/good,.yaml": "Name: good\n",
+		"things/bad.yaml":   "Name: bad\n",
+	})
+	defer os.RemoveAll(dir)
+
+	err := hy.Unmarshal(dir, &TextKeyBase{})
+	errs, ok := err.(hy.Errors)
+	if !ok || len(errs) != 1 || errs[0].File != "things/bad.yaml" {
+		t.Errorf("got error %v; want one error for things/bad.yaml", err)
+	}
+	if err := hy.Unmarshal(dir, &BadKeyTypeBase{}); err == nil {
+		t.Error("got nil error unmarshaling map[int]Thing")
+	}
+}
+
+func TestUnmarshal_NamedStringKeys(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"things/one.yaml": "Name: one\n",
+	})
+	defer os.RemoveAll(dir)
+
+	b := NamedKeyBase{}
+	if err := hy.Unmarshal(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Things[ThingName("one")].Name != "one" {
+		t.Errorf("got things %v", b.Things)
+	}
+}
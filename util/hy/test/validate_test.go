@@ -0,0 +1,128 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/opentable/sous/util/hy"
+)
+
+type (
+	ValidatedBase struct {
+		Config  ValidatedConfig             `hy:"config.yaml"`
+		Things  map[string]ValidatedThing   `hy:"things/"`
+		Widgets map[string]*ValidatedWidget `hy:"widgets/**"`
+	}
+	ValidatedConfig struct{ Name string }
+	ValidatedThing  struct{ Name, Desc string }
+	ValidatedWidget struct{ Name string }
+)
+
+func (c ValidatedConfig) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("config needs a Name")
+	}
+	return nil
+}
+
+func (t ValidatedThing) Validate() error {
+	if t.Desc != "" && t.Name == "" {
+		return fmt.Errorf("a thing with a Desc needs a Name")
+	}
+	return nil
+}
+
+func (w *ValidatedWidget) Validate() error {
+	if w.Name == "" {
+		return fmt.Errorf("widget needs a Name")
+	}
+	return nil
+}
+
+func TestUnmarshal_Validates(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"config.yaml":          "{}\n",
+		"things/good.yaml":     "Name: good\nDesc: fine\n",
+		"things/bad.yaml":      "Desc: no name\n",
+		"widgets/a/good.yaml":  "Name: good\n",
+		"widgets/a/b/bad.yaml": "{}\n",
+	})
+	defer os.RemoveAll(dir)
+
+	err := hy.Unmarshal(dir, &ValidatedBase{})
+	errs, ok := err.(hy.Errors)
+	if !ok {
+		t.Fatalf("got %T %v; want hy.Errors", err, err)
+	}
+	got := map[string]string{}
+	for _, e := range errs {
+		got[e.File] = e.Cause.Error()
+	}
+	want := map[string]string{
+		"config.yaml":          "config needs a Name",
+		"things/bad.yaml":      "a thing with a Desc needs a Name",
+		"widgets/a/b/bad.yaml": "widget needs a Name",
+	}
+	if len(got) != len(want) {
+		t.Errorf("got errors %v; want %v", got, want)
+	}
+	for file, msg := range want {
+		if got[file] != msg {
+			t.Errorf("got error %q for %s; want %q", got[file], file, msg)
+		}
+	}
+}
+
+func TestUnmarshal_ValidateFunc(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"config.yaml":      "Name: config\n",
+		"things/one.yaml":  "Name: one\n",
+		"things/TWO.yaml":  "Name: TWO\n",
+		"widgets/one.yaml": "Name: one\n",
+	})
+	defer os.RemoveAll(dir)
+
+	validated := []string{}
+	u := hy.NewStrictUnmarshaler()
+	u.Validate = func(v interface{}) error {
+		var name string
+		switch v := v.(type) {
+		default:
+			return fmt.Errorf("unexpected %T", v)
+		case *ValidatedConfig:
+			name = v.Name
+		case *ValidatedThing:
+			name = v.Name
+		case *ValidatedWidget:
+			name = v.Name
+		}
+		validated = append(validated, name)
+		if strings.ToLower(name) != name {
+			return fmt.Errorf("%s is not lower case", name)
+		}
+		return nil
+	}
+	err := u.Unmarshal(dir, &ValidatedBase{})
+	errs, ok := err.(hy.Errors)
+	if !ok || len(errs) != 1 || errs[0].File != "things/TWO.yaml" {
+		t.Errorf("got error %v; want an error for things/TWO.yaml", err)
+	}
+	if len(validated) != 4 {
+		t.Errorf("validated %v; want 4 values", validated)
+	}
+}
+
+func TestMarshal_DoesNotValidate(t *testing.T) {
+	dir := writeFiles(t, nil)
+	defer os.RemoveAll(dir)
+
+	b := ValidatedBase{
+		Things:  map[string]ValidatedThing{"bad": {Desc: "no name"}},
+		Widgets: map[string]*ValidatedWidget{"bad": {}},
+	}
+	if err := hy.Marshal(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,80 @@
+package test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opentable/sous/util/hy"
+	"github.com/opentable/sous/util/yaml"
+)
+
+func TestMarshal_ErrorLeavesOriginalUntouched(t *testing.T) {
+	original := "Name: one\n"
+	dir := writeFiles(t, map[string]string{
+		"things/one.yaml": original,
+	})
+	defer os.RemoveAll(dir)
+
+	m := hy.NewMarshaller(func(v interface{}) ([]byte, error) {
+		return []byte("Name: tru"), fmt.Errorf("marshal failed")
+	})
+	b := PruneBase{Things: map[string]Thing{"one": {Name: "changed"}}}
+	if err := m.Marshal(dir, &b); err == nil {
+		t.Fatal("got nil error; want marshal failed")
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(dir, "things/one.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != original {
+		t.Errorf("things/one.yaml = %q; want %q", contents, original)
+	}
+	files, err := ioutil.ReadDir(filepath.Join(dir, "things"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("got %d files in things/; want 1", len(files))
+	}
+}
+
+func TestMarshal_Modes(t *testing.T) {
+	dir := writeFiles(t, nil)
+	defer os.RemoveAll(dir)
+
+	m := hy.NewMarshaller(yaml.Marshal)
+	m.FileMode = 0600
+	m.DirMode = 0700
+	m.Durable = true
+	b := PruneBase{Widgets: map[string]Widget{"a/b/widget": {Name: "widget"}}}
+	if err := m.Marshal(dir, &b); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]os.FileMode{
+		"widgets/a":               0700 | os.ModeDir,
+		"widgets/a/b":             0700 | os.ModeDir,
+		"widgets/a/b/widget.yaml": 0600,
+	} {
+		f, err := os.Stat(filepath.Join(dir, path))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		// Modes may only be reduced by the umask.
+		if got := f.Mode(); got&^want != 0 {
+			t.Errorf("%s has mode %s; want at most %s", path, got, want)
+		}
+	}
+	files, err := ioutil.ReadDir(filepath.Join(dir, "widgets/a/b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("got %d files in widgets/a/b; want 1 (temp file left behind?)", len(files))
+	}
+}
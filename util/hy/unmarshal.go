@@ -9,8 +9,12 @@ package hy
 import (
 	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/opentable/sous/util/yaml"
@@ -18,35 +22,174 @@ import (
 
 // Error wraps YAML errors in order to indicate which file contains them
 type Error struct {
-	file  string
-	cause error
+	// File is the path of the file, relative to the directory being
+	// unmarshaled.
+	File string
+	// Line is the line the error occurred on, if known, or zero.
+	Line  int
+	Cause error
 }
 
+// Validator may be implemented by types read from files. Validate is called
+// after each file is unmarshaled, and its error is reported against that
+// file.
+type Validator interface {
+	Validate() error
+}
+
+// readOpts are the Unmarshaler options needed by targets when reading.
+type readOpts struct {
+	strict, followSymlinks, rejectUnknownFiles bool
+	validate                                   func(interface{}) error
+	merge                                      MergeMode
+	// manifest, if set, has a record of each file read appended to it.
+	manifest *ReadManifest
+	// collisions and warnf are as set by the Unmarshaler.
+	collisions CollisionMode
+	warnf      func(format string, v ...interface{})
+	// maxFileSize, maxFiles and maxDepth are the Unmarshaler's limits,
+	// where zero means no limit.
+	maxFileSize, maxFiles, maxDepth int64
+}
+
+// MergeMode determines what happens to values already in the struct passed
+// to Unmarshal.
+type MergeMode int
+
+const (
+	// MergeEntries keeps map entries with no corresponding file, and
+	// replaces the rest with what is read.
+	MergeEntries MergeMode = iota
+	// DeepMerge is like MergeEntries, but files are unmarshaled over the
+	// existing entry, so fields missing from a file keep their values.
+	DeepMerge
+	// ReplaceAll empties maps before reading, so they end up containing
+	// only what is read.
+	ReplaceAll
+)
+
+// Errors is returned from Unmarshal when any file fails, and contains an
+// Error for each failing file.
+type Errors []*Error
+
 // Unmarshaler tracks the process of deserializing a directory of yaml files
 // into a set of structs
 type Unmarshaler struct {
 	UnmarshalFunc func([]byte, interface{}) error
+	// UnmarshalStrictFunc is used in place of UnmarshalFunc when Strict is
+	// set.
+	UnmarshalStrictFunc func([]byte, interface{}) error
+	// Codecs handle files with other extensions than .yaml, and may replace
+	// the default JSONCodec
+	Codecs []Codec
+	// Strict makes fields in files which are unknown to the target type an
+	// error, rather than silently dropping them.
+	Strict bool
+	// Validate, if set, is called with a pointer to the value read from each
+	// file, after Validator.Validate if the value implements it.
+	Validate func(interface{}) error
+	// FollowSymlinks makes tree targets descend into symlinked directories.
+	// By default they are ignored; symlinked files are always read.
+	FollowSymlinks bool
+	// Ignore are patterns, as used by filepath.Match, of file and directory
+	// names to skip in dir and tree targets. If nil, DefaultIgnore is used.
+	Ignore []string
+	// RejectUnknownFiles makes any other file without a registered
+	// extension in a dir or tree target an error.
+	RejectUnknownFiles bool
+	// Merge says how to combine what is read with values already in the
+	// target. Slices are always replaced.
+	Merge MergeMode
+	// FileName names the files of fields whose hy tag is just an extension,
+	// e.g. `hy:"yaml"`, given the field's name. If nil, strings.ToLower is
+	// used, so a field Config is read from config.yaml.
+	FileName func(field string) string
+	// Names converts the names of the files and directories of entries in
+	// dir and tree targets to their keys. If nil, IdentityNames is used,
+	// so each entry's key is its file name. Use the same NameCodec as the
+	// Marshaller that wrote them.
+	Names NameCodec
+	// Collisions says what happens when two files or directories in a dir
+	// or tree target provide the same key: see CollisionMode.
+	Collisions CollisionMode
+	// Warnf is given the warnings of reads that carry on regardless, such
+	// as of a collision under FirstPathWins. If nil, they are logged with
+	// log.Printf.
+	Warnf func(format string, v ...interface{})
+	// MaxFileSize is the most bytes a file may hold. Bigger files aren't
+	// read, and each is reported as an Error. If zero, DefaultMaxFileSize
+	// is used; if negative, there's no limit.
+	MaxFileSize int64
+	// MaxFiles is the most files read for each dir or tree target. The
+	// rest are skipped, and reported as an Error for the target's
+	// directory. If zero, DefaultMaxFiles is used; if negative, there's no
+	// limit.
+	MaxFiles int
+	// MaxDepth is how many levels of directories below a tree target's
+	// directory are walked. Deeper directories are skipped, and each is
+	// reported as an Error. If zero, DefaultMaxDepth is used; if negative,
+	// there's no limit.
+	MaxDepth int
 }
 
 // NewUnmarshaler creates an Unmarshaler
-func NewUnmarshaler(unmarshalFunc func([]byte, interface{}) error) Unmarshaler {
+func NewUnmarshaler(unmarshalFunc func([]byte, interface{}) error, codecs ...Codec) Unmarshaler {
 	if unmarshalFunc == nil {
 		panic("unmarshalFunc must not be nil")
 	}
-	return Unmarshaler{unmarshalFunc}
+	return Unmarshaler{UnmarshalFunc: unmarshalFunc, Codecs: codecs}
+}
+
+// NewStrictUnmarshaler creates a strict Unmarshaler for YAML, and any other
+// codecs passed.
+func NewStrictUnmarshaler(codecs ...Codec) Unmarshaler {
+	u := NewUnmarshaler(yaml.Unmarshal, codecs...)
+	u.UnmarshalStrictFunc = yaml.UnmarshalStrict
+	u.Strict = true
+	return u
 }
 
-// Unmarshal is shorthand for NewUnmarshaler(yaml.Unmarshal).Unmarshal
-func Unmarshal(path string, v interface{}) error {
-	return NewUnmarshaler(yaml.Unmarshal).Unmarshal(path, v)
+// Unmarshal is shorthand for NewUnmarshaler(yaml.Unmarshal, codecs...).Unmarshal
+func Unmarshal(path string, v interface{}, codecs ...Codec) error {
+	return NewUnmarshaler(yaml.Unmarshal, codecs...).Unmarshal(path, v)
+}
+
+// UnmarshalStrict is shorthand for NewStrictUnmarshaler(codecs...).Unmarshal
+func UnmarshalStrict(path string, v interface{}, codecs ...Codec) error {
+	return NewStrictUnmarshaler(codecs...).Unmarshal(path, v)
+}
+
+var lineRegexp = regexp.MustCompile(`\bline (\d+)\b`)
+
+func newError(file string, cause error) *Error {
+	e := &Error{File: file, Cause: cause}
+	if m := lineRegexp.FindStringSubmatch(cause.Error()); m != nil {
+		e.Line, _ = strconv.Atoi(m[1])
+	}
+	return e
 }
 
 func (e *Error) Error() string {
-	return fmt.Sprintf("In %s: %s", e.file, e.cause)
+	if e.Line != 0 {
+		return fmt.Sprintf("In %s (line %d): %s", e.File, e.Line, e.Cause)
+	}
+	return fmt.Sprintf("In %s: %s", e.File, e.Cause)
+}
+
+func (es Errors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
 }
 
 // Unmarshal deserializes from a directory
 func (u Unmarshaler) Unmarshal(path string, v interface{}) error {
+	return u.unmarshal(path, v, nil)
+}
+
+func (u Unmarshaler) unmarshal(path string, v interface{}, manifest *ReadManifest) error {
 	if v == nil {
 		return fmt.Errorf("hy cannot unmarshal to nil")
 	}
@@ -57,7 +200,40 @@ func (u Unmarshaler) Unmarshal(path string, v interface{}) error {
 	if !s.IsDir() {
 		return fmt.Errorf("%s is not a directory", path)
 	}
-	return ctx{path, u.UnmarshalFunc, nil}.unmarshalDir(v)
+	cs := newCodecs(Codec{
+		Unmarshal:       u.UnmarshalFunc,
+		UnmarshalStrict: u.UnmarshalStrictFunc,
+	}, u.Codecs)
+	ignore, err := ignorePatterns(u.Ignore)
+	if err != nil {
+		return err
+	}
+	read := readOpts{
+		strict:             u.Strict,
+		followSymlinks:     u.FollowSymlinks,
+		rejectUnknownFiles: u.RejectUnknownFiles,
+		validate:           u.Validate,
+		merge:              u.Merge,
+		manifest:           manifest,
+		collisions:         u.Collisions,
+		warnf:              u.Warnf,
+		maxFileSize:        readLimit(u.MaxFileSize, DefaultMaxFileSize),
+		maxFiles:           readLimit(int64(u.MaxFiles), DefaultMaxFiles),
+		maxDepth:           readLimit(int64(u.MaxDepth), DefaultMaxDepth),
+	}
+	if read.warnf == nil {
+		read.warnf = log.Printf
+	}
+	c := ctx{path: path, codecs: cs, read: read, ignore: ignore, fileName: u.FileName, names: u.Names}
+	err = c.unmarshalDir(v)
+	if es, ok := err.(Errors); ok {
+		for _, e := range es {
+			if rel, err := filepath.Rel(path, e.File); err == nil {
+				e.File = rel
+			}
+		}
+	}
+	return err
 }
 
 func (c ctx) unmarshalDir(v interface{}) error {
@@ -65,16 +241,25 @@ func (c ctx) unmarshalDir(v interface{}) error {
 	if err != nil {
 		return err
 	}
+	for _, t := range targets {
+		t.setFields("", "")
+	}
 	return targets.unmarshalAll(nil)
 }
 
+// unmarshalAll unmarshals every target, rather than stopping at the first
+// failure, and returns Errors for all of the targets that failed.
 func (ts targets) unmarshalAll(parent *reflect.Value) error {
+	errs := Errors{}
 	for _, t := range ts {
 		if err := t.unmarshal(parent); err != nil {
-			if _, ok := err.(*Error); ok {
-				return err
+			switch err := err.(type) {
+			default:
+				errs = append(errs, newError(t.path, err))
+			case Errors:
+				errs = append(errs, err...)
 			}
-			return &Error{t.path, err}
+			continue
 		}
 		debug(t.val.Type(), t.val.Interface())
 		if parent == nil {
@@ -88,17 +273,44 @@ func (ts targets) unmarshalAll(parent *reflect.Value) error {
 		}
 
 	}
+	if len(errs) != 0 {
+		return errs
+	}
 	return nil
 }
 
 func (t target) unmarshal(parent *reflect.Value) error {
 	debugf("Target: %s\n", t.path)
+	if t.err != nil {
+		return t.err
+	}
 	iface := t.val.Interface()
-	if isFile(t.path) {
+	if !t.nested && t.codecs.isFile(t.path) {
 		debug("unmarshall file", t)
+		t.prepareFile(parent)
+		if t.optional && t.missing() {
+			// The value stays as prepareFile left it.
+			debug("missing file", t.path)
+			return t.insertIntoParent(parent)
+		}
 		if err := t.unmarshalFile(iface); err != nil {
 			return err
 		}
+		if t.keyField != "" {
+			t.val.Elem().FieldByName(t.keyField).SetString(t.name)
+		}
+		if t.remainder != nil {
+			// The struct containing these fields is validated as a whole,
+			// if at all.
+			return t.insertIntoParent(parent)
+		}
+		if err := t.validate(); err != nil {
+			return err
+		}
+	}
+	if k := t.val.Kind(); t.val.CanSet() && (k == reflect.Slice || k == reflect.Map && t.readOpts.merge == ReplaceAll) {
+		// Elements are appended or inserted as they are read.
+		t.val.Set(reflect.Zero(t.val.Type()))
 	}
 	if len(t.subTargets) != 0 {
 		debug("subtargets", t)
@@ -106,6 +318,14 @@ func (t target) unmarshal(parent *reflect.Value) error {
 			return err
 		}
 	}
+	if t.nested {
+		if t.keyField != "" {
+			t.val.Elem().FieldByName(t.keyField).SetString(t.name)
+		}
+		if err := t.validate(); err != nil {
+			return err
+		}
+	}
 	if parent != nil {
 		debug("insert into parent", parent, t)
 		if err := t.insertIntoParent(parent); err != nil {
@@ -116,8 +336,62 @@ func (t target) unmarshal(parent *reflect.Value) error {
 	return nil
 }
 
+// prepareFile sets the value a file is unmarshaled into according to the
+// merge mode: either zero, or for DeepMerge a copy of the existing value.
+func (t target) prepareFile(parent *reflect.Value) {
+	elem := t.val.Elem()
+	if t.readOpts.merge != DeepMerge {
+		elem.Set(reflect.Zero(elem.Type()))
+		return
+	}
+	if t.remainder != nil {
+		t.copyFromParent(*parent)
+		return
+	}
+	// t.val already holds a copy of an existing struct field, but map
+	// entries start out zero.
+	if parent == nil || parent.Kind() != reflect.Map || parent.IsNil() {
+		return
+	}
+	k, err := nameKey(t.name, parent.Type().Key())
+	if err != nil {
+		return
+	}
+	existing := parent.MapIndex(k)
+	if !existing.IsValid() {
+		return
+	}
+	if existing.Kind() == reflect.Ptr {
+		if existing.IsNil() {
+			return
+		}
+		existing = existing.Elem()
+	}
+	elem.Set(existing)
+}
+
+// missing reports whether t's file does not exist.
+func (t target) missing() bool {
+	_, err := os.Lstat(t.path)
+	return os.IsNotExist(err)
+}
+
+// validate checks a value just read from a file.
+func (t target) validate() error {
+	v := t.val.Interface()
+	if validator, ok := v.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			return err
+		}
+	}
+	if t.readOpts.validate != nil {
+		return t.readOpts.validate(v)
+	}
+	return nil
+}
+
 func parentTypeError(parent *reflect.Value) error {
-	return fmt.Errorf("parent was %s; want pointer or map[string]T", parent.Type())
+	return fmt.Errorf("parent was %s; want pointer, map[K]T or []T", parent.Type())
 }
 
 func (t target) insertIntoParent(parent *reflect.Value) error {
@@ -129,12 +403,17 @@ func (t target) insertIntoParent(parent *reflect.Value) error {
 		if parent.Elem().Kind() != reflect.Struct {
 			return parentTypeError(parent)
 		}
+		if t.remainder != nil {
+			t.copyToParent(*parent)
+			return nil
+		}
 		debugf("Setting field %s on %s\n", t.name, parent.Elem().Type())
 		f := parent.Elem().FieldByName(t.name)
 		f.Set(*getConcreteValRef(t.val))
 	case reflect.Map:
-		if parent.Type().Key().Kind() != reflect.String {
-			return parentTypeError(parent)
+		k, err := nameKey(t.name, parent.Type().Key())
+		if err != nil {
+			return err
 		}
 		debugf("Setting key %q on %s\n", t.name, parent.Type())
 		if parent.IsNil() {
@@ -146,22 +425,46 @@ func (t target) insertIntoParent(parent *reflect.Value) error {
 		if parent.Type().Elem().Kind() != reflect.Ptr {
 			elem = elem.Elem()
 		}
-		parent.SetMapIndex(reflect.ValueOf(t.name), elem)
+		parent.SetMapIndex(k, elem)
 		debug(parent.Interface())
+	case reflect.Slice:
+		debugf("Appending %q to %s\n", t.name, parent.Type())
+		elem := t.val
+		if parent.Type().Elem().Kind() != reflect.Ptr {
+			elem = elem.Elem()
+		}
+		parent.Set(reflect.Append(*parent, elem))
 	}
 	return nil
 }
 
 func (t target) unmarshalFile(iface interface{}) error {
-	if t.val.Kind() != reflect.Ptr || t.val.Elem().Kind() != reflect.Struct {
-		return fmt.Errorf("tried to unmarshal file %s to %T; want a pointer to struct", t.path, iface)
+	if t.val.Kind() != reflect.Ptr {
+		return fmt.Errorf("tried to unmarshal file %s to %T; want a pointer", t.path, iface)
 	}
 	debugf("Path: %s; Type: %s; ValType: %s; IfaceType: %s\n", t.path, t.typ, t.val.Type(), reflect.TypeOf(t.val.Interface()))
+	codec, _ := t.codecs.forPath(t.path)
+	unmarshal := codec.Unmarshal
+	if t.readOpts.strict {
+		unmarshal = codec.UnmarshalStrict
+	}
+	if unmarshal == nil {
+		if t.readOpts.strict {
+			return fmt.Errorf("no strict unmarshal func for %s files", codec.Ext)
+		}
+		return fmt.Errorf("no unmarshal func for %s files", codec.Ext)
+	}
+	if err := t.checkFileSize(); err != nil {
+		return err
+	}
 	b, err := ioutil.ReadFile(t.path)
 	if err != nil {
 		return err
 	}
-	if err := t.unmarshalFunc(b, iface); err != nil {
+	if err := t.record(b); err != nil {
+		return err
+	}
+	if err := unmarshal(b, iface); err != nil {
 		return err
 	}
 	debugf("Unmarshalled: val: %v; type: %T", iface, iface)
@@ -211,7 +514,3 @@ func getConcreteVal(v *reflect.Value) reflect.Value {
 		return e
 	}
 }
-
-func isFile(path string) bool {
-	return strings.HasSuffix(path, ".yaml")
-}
@@ -0,0 +1,83 @@
+package hy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// walkTree calls fn with the path of every non-directory under c.path. A
+// missing c.path is treated as empty. If dirFn is not nil, it is called for
+// each directory, which is only walked if it returns true.
+//
+// Symlinks to directories are only descended into if the followSymlinks
+// option is set, in which case a link to one of its own ancestors is skipped.
+// Any other symlink, including a broken one, is passed to fn like a file, so
+// that errors reading it are reported against the link's path.
+//
+// Directories nested more than the maxDepth read option below c.path are
+// neither passed to dirFn nor walked; their paths are returned instead.
+func (c ctx) walkTree(dirFn func(path string) (bool, error), fn func(path string) error) ([]string, error) {
+	if _, err := os.Stat(c.path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	tooDeep := []string{}
+	// ancestors holds the resolved paths of the directories currently being
+	// walked.
+	ancestors := map[string]struct{}{}
+	var walk func(dir string, depth int64) error
+	walk = func(dir string, depth int64) error {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return err
+		}
+		if _, ok := ancestors[resolved]; ok {
+			debug("Skipping symlink cycle at", dir)
+			return nil
+		}
+		ancestors[resolved] = struct{}{}
+		defer delete(ancestors, resolved)
+
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			path := filepath.Join(dir, e.Name())
+			if c.ignored(path) {
+				continue
+			}
+			if c.read.followSymlinks && isDir(path, e) || e.IsDir() {
+				if c.read.maxDepth > 0 && depth >= c.read.maxDepth {
+					tooDeep = append(tooDeep, path)
+					continue
+				}
+				descend := true
+				if dirFn != nil {
+					descend, err = dirFn(path)
+				}
+				if err == nil && descend {
+					err = walk(path, depth+1)
+				}
+			} else {
+				err = fn(path)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err := walk(c.path, 0)
+	return tooDeep, err
+}
+
+// isDir returns true if f, the FileInfo for path, is a directory or a
+// symlink to one.
+func isDir(path string, f os.FileInfo) bool {
+	if f.Mode()&os.ModeSymlink == 0 {
+		return f.IsDir()
+	}
+	s, err := os.Stat(path)
+	return err == nil && s.IsDir()
+}
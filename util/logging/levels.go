@@ -0,0 +1,239 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The subsystems whose levels can be set apart from the rest.
+const (
+	NameCache = "namecache"
+	Rectify   = "rectify"
+	Registry  = "registry"
+	Hy        = "hy"
+)
+
+// LevelsEnv is the environment variable SetLevelsFromEnv reads a levels spec
+// from.
+const LevelsEnv = "SOUS_LOG_LEVELS"
+
+type (
+	// Levels holds the level each subsystem logs at: messages less important
+	// than it aren't logged, or even formatted. It's safe to change levels
+	// while they're in use. *Levels implements flag.Value, taking a spec as
+	// for Set.
+	Levels struct {
+		sync.RWMutex
+		def    Level
+		levels map[string]Level
+	}
+
+	// subsystemLogger logs to a Logger only what its subsystem's level
+	// allows.
+	subsystemLogger struct {
+		Logger
+		// out is Logger, as a leveledLogger.
+		out       leveledLogger
+		levels    *Levels
+		subsystem string
+	}
+
+	// leveledAdapter makes a leveledLogger of any Logger.
+	leveledAdapter struct{ Logger }
+)
+
+// DefaultLevels are the levels used by the functions of this package that
+// don't take Levels. Every subsystem logs at InfoLevel unless it's changed.
+var DefaultLevels = NewLevels(InfoLevel)
+
+// NewLevels returns Levels with every subsystem at def.
+func NewLevels(def Level) *Levels {
+	return &Levels{def: def, levels: map[string]Level{}}
+}
+
+// ParseLevel parses the name of a level, e.g. "debug".
+func ParseLevel(name string) (Level, error) {
+	for l := DebugLevel; l <= WarnLevel; l++ {
+		if strings.EqualFold(strings.TrimSpace(name), l.String()) {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q: want debug, info or warn", name)
+}
+
+// Level returns the level subsystem logs at.
+func (ls *Levels) Level(subsystem string) Level {
+	ls.RLock()
+	defer ls.RUnlock()
+	if l, ok := ls.levels[subsystem]; ok {
+		return l
+	}
+	return ls.def
+}
+
+// Enabled reports whether subsystem logs messages at level l.
+func (ls *Levels) Enabled(subsystem string, l Level) bool {
+	return l >= ls.Level(subsystem)
+}
+
+// Lowest returns the least important level any subsystem logs at.
+func (ls *Levels) Lowest() Level {
+	ls.RLock()
+	defer ls.RUnlock()
+	lowest := ls.def
+	for _, l := range ls.levels {
+		if l < lowest {
+			lowest = l
+		}
+	}
+	return lowest
+}
+
+// SetLevel sets the level of subsystem.
+func (ls *Levels) SetLevel(subsystem string, l Level) {
+	ls.Lock()
+	defer ls.Unlock()
+	ls.levels[subsystem] = l
+}
+
+// SetDefault sets the level of every subsystem whose level hasn't been set
+// with SetLevel.
+func (ls *Levels) SetDefault(l Level) {
+	ls.Lock()
+	defer ls.Unlock()
+	ls.def = l
+}
+
+// Set applies spec, a comma separated list of subsystem=level pairs, e.g.
+// "rectify=debug,registry=warn". A level on its own sets the default. If
+// any part of spec is invalid, no levels are changed.
+func (ls *Levels) Set(spec string) error {
+	def, levels, err := parseLevels(spec)
+	if err != nil {
+		return err
+	}
+	ls.Lock()
+	defer ls.Unlock()
+	if def != nil {
+		ls.def = *def
+	}
+	for s, l := range levels {
+		ls.levels[s] = l
+	}
+	return nil
+}
+
+// String formats the levels as a spec Set would accept.
+func (ls *Levels) String() string {
+	if ls == nil {
+		return ""
+	}
+	ls.RLock()
+	defer ls.RUnlock()
+	parts := []string{ls.def.String()}
+	for s, l := range ls.levels {
+		parts = append(parts, s+"="+l.String())
+	}
+	sort.Strings(parts[1:])
+	return strings.Join(parts, ",")
+}
+
+// Logger returns a Logger that logs to l what subsystem's level allows, and
+// doesn't format anything else.
+func (ls *Levels) Logger(l Logger, subsystem string) Logger {
+	out, ok := l.(leveledLogger)
+	if !ok {
+		out = leveledAdapter{l}
+	}
+	return &subsystemLogger{Logger: l, out: out, levels: ls, subsystem: subsystem}
+}
+
+func parseLevels(spec string) (*Level, map[string]Level, error) {
+	var def *Level
+	levels := map[string]Level{}
+	for _, part := range strings.Split(spec, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		l, err := ParseLevel(kv[len(kv)-1])
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(kv) == 1 {
+			def = &l
+			continue
+		}
+		s := strings.TrimSpace(kv[0])
+		if s == "" {
+			return nil, nil, fmt.Errorf("no subsystem named in %q", part)
+		}
+		levels[s] = l
+	}
+	return def, levels, nil
+}
+
+// Enabled reports whether subsystem logs messages at level l, according to
+// DefaultLevels.
+func Enabled(subsystem string, l Level) bool {
+	return DefaultLevels.Enabled(subsystem, l)
+}
+
+// ForSubsystem returns a Logger that logs to l what subsystem's level in
+// DefaultLevels allows.
+func ForSubsystem(l Logger, subsystem string) Logger {
+	return DefaultLevels.Logger(l, subsystem)
+}
+
+// SetLevelsFromEnv applies the spec in $SOUS_LOG_LEVELS, if any, to
+// DefaultLevels.
+func SetLevelsFromEnv() error {
+	spec := os.Getenv(LevelsEnv)
+	if spec == "" {
+		return nil
+	}
+	if err := DefaultLevels.Set(spec); err != nil {
+		return fmt.Errorf("$%s: %s", LevelsEnv, err)
+	}
+	return nil
+}
+
+// Debugf implements Logger
+func (l *subsystemLogger) Debugf(format string, args ...interface{}) {
+	if l.levels.Enabled(l.subsystem, DebugLevel) {
+		l.out.logAt(DebugLevel, format, args)
+	}
+}
+
+// Infof implements Logger
+func (l *subsystemLogger) Infof(format string, args ...interface{}) {
+	if l.levels.Enabled(l.subsystem, InfoLevel) {
+		l.out.logAt(InfoLevel, format, args)
+	}
+}
+
+// Warnf implements Logger
+func (l *subsystemLogger) Warnf(format string, args ...interface{}) {
+	if l.levels.Enabled(l.subsystem, WarnLevel) {
+		l.out.logAt(WarnLevel, format, args)
+	}
+}
+
+func (l leveledAdapter) logAt(level Level, format string, args []interface{}) {
+	switch level {
+	case DebugLevel:
+		l.Logger.Debugf(format, args...)
+	case InfoLevel:
+		l.Logger.Infof(format, args...)
+	default:
+		l.Logger.Warnf(format, args...)
+	}
+}
+
+// With implements Logger
+func (l *subsystemLogger) With(kv ...interface{}) Logger {
+	return l.levels.Logger(l.Logger.With(kv...), l.subsystem)
+}
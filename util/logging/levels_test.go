@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// countingArg counts the times it's formatted.
+type countingArg struct{ formatted *int }
+
+func (a countingArg) String() string {
+	*a.formatted++
+	return "counted"
+}
+
+func TestLevelsSet(t *testing.T) {
+	ls := NewLevels(InfoLevel)
+	if err := ls.Set("rectify=debug, registry=WARN"); err != nil {
+		t.Fatal(err)
+	}
+	for s, want := range map[string]Level{Rectify: DebugLevel, Registry: WarnLevel, NameCache: InfoLevel} {
+		if got := ls.Level(s); got != want {
+			t.Errorf("got level %s for %s; want %s", got, s, want)
+		}
+	}
+	if got, want := ls.String(), "info,rectify=debug,registry=warn"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+
+	if err := ls.Set("warn,hy=debug"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ls.String(), "warn,hy=debug,rectify=debug,registry=warn"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if got := ls.Lowest(); got != DebugLevel {
+		t.Errorf("got lowest level %s; want debug", got)
+	}
+}
+
+func TestLevelsSetInvalid(t *testing.T) {
+	for _, spec := range []string{"rectify=debug,registry=loud", "=debug", "rectify=debug,verbose"} {
+		ls := NewLevels(InfoLevel)
+		if err := ls.Set(spec); err == nil {
+			t.Errorf("Set(%q) succeeded; want error", spec)
+		}
+		if got := ls.String(); got != "info" {
+			t.Errorf("Set(%q) changed the levels to %q", spec, got)
+		}
+	}
+}
+
+func TestLevelsConcurrent(t *testing.T) {
+	ls := NewLevels(InfoLevel)
+	l := ls.Logger(Discard, Rectify)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			ls.SetLevel(Rectify, Level(i%3))
+			ls.SetDefault(Level(i % 3))
+		}(i)
+		go func() {
+			defer wg.Done()
+			l.Debugf("%d", 1)
+			_ = ls.String()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSubsystemLoggerDoesNoWorkWhenSuppressed(t *testing.T) {
+	buf := &bytes.Buffer{}
+	ls := NewLevels(InfoLevel)
+	l := ls.Logger(NewStdLogger(log.New(buf, "", 0), log.New(buf, "", 0), nil), Registry).With("host", "example.com")
+
+	formatted := 0
+	l.Debugf("suppressed %s", countingArg{&formatted})
+	l.Warnf("discarded by the std logger %s", countingArg{&formatted})
+	if formatted != 0 {
+		t.Errorf("suppressed messages were formatted %d times", formatted)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("logged %q", buf.String())
+	}
+
+	ls.SetLevel(Registry, DebugLevel)
+	l.Debugf("logged %s", countingArg{&formatted})
+	if formatted != 1 {
+		t.Errorf("message was formatted %d times; want 1", formatted)
+	}
+	if got, want := buf.String(), "logged counted host=example.com\n"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestSubsystemLoggerReportsCaller(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewLevels(DebugLevel).Logger(NewStdLogger(log.New(buf, "", log.Lshortfile), nil, nil), Hy)
+	l.With("k", "v").Debugf("here")
+	if got := buf.String(); !strings.HasPrefix(got, "levels_test.go:") || !strings.HasSuffix(got, ": here k=v\n") {
+		t.Errorf("got %q; want the caller's file and line, then the message", got)
+	}
+}
@@ -0,0 +1,209 @@
+// Package logging defines the structured Logger used by sous's components,
+// with adapters that log through the standard library's log package or as
+// lines of JSON.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+type (
+	// Logger logs messages at three levels, along with context: key-value
+	// pairs that say what the message is about, e.g. "cluster", "east".
+	Logger interface {
+		Debugf(format string, args ...interface{})
+		Infof(format string, args ...interface{})
+		Warnf(format string, args ...interface{})
+		// With returns a Logger that adds kv, alternating keys and values,
+		// to the context of everything it logs.
+		With(kv ...interface{}) Logger
+	}
+
+	// Level is the importance of a message.
+	Level int
+
+	// stdLogger logs to a standard library logger for each level.
+	stdLogger struct {
+		debug, info, warn *log.Logger
+		context           []interface{}
+	}
+
+	// jsonLogger writes each message as a line of JSON.
+	jsonLogger struct {
+		out     *lockedWriter
+		context []interface{}
+	}
+
+	lockedWriter struct {
+		sync.Mutex
+		w io.Writer
+	}
+
+	discard struct{}
+
+	// leveledLogger is implemented by the Loggers of this package, so that
+	// wrappers can log through them without adding a frame for
+	// log.Lshortfile to report.
+	leveledLogger interface {
+		// logAt logs at level, as Debugf, Infof or Warnf do when they call it.
+		logAt(level Level, format string, args []interface{})
+	}
+)
+
+const (
+	// DebugLevel messages help to diagnose problems.
+	DebugLevel Level = iota
+	// InfoLevel messages report normal progress.
+	InfoLevel
+	// WarnLevel messages report problems.
+	WarnLevel
+)
+
+// Discard is a Logger that logs nothing.
+var Discard Logger = discard{}
+
+func (l Level) String() string {
+	switch l {
+	default:
+		return fmt.Sprintf("Level(%d)", int(l))
+	case DebugLevel:
+		return "debug"
+	case InfoLevel:
+		return "info"
+	case WarnLevel:
+		return "warn"
+	}
+}
+
+// NewStdLogger returns a Logger that logs each level to the corresponding
+// standard library logger, with its context appended as key=value pairs. A
+// nil logger discards its level.
+func NewStdLogger(debug, info, warn *log.Logger) Logger {
+	return &stdLogger{debug: debug, info: info, warn: warn}
+}
+
+// Debugf implements Logger
+func (l *stdLogger) Debugf(format string, args ...interface{}) {
+	l.logAt(DebugLevel, format, args)
+}
+
+// Infof implements Logger
+func (l *stdLogger) Infof(format string, args ...interface{}) {
+	l.logAt(InfoLevel, format, args)
+}
+
+// Warnf implements Logger
+func (l *stdLogger) Warnf(format string, args ...interface{}) {
+	l.logAt(WarnLevel, format, args)
+}
+
+// With implements Logger
+func (l *stdLogger) With(kv ...interface{}) Logger {
+	with := *l
+	with.context = appendContext(l.context, kv)
+	return &with
+}
+
+func (l *stdLogger) logAt(level Level, format string, args []interface{}) {
+	var to *log.Logger
+	switch level {
+	case DebugLevel:
+		to = l.debug
+	case InfoLevel:
+		to = l.info
+	case WarnLevel:
+		to = l.warn
+	}
+	if to == nil {
+		return
+	}
+	msg := bytes.NewBufferString(fmt.Sprintf(format, args...))
+	for i := 0; i < len(l.context); i += 2 {
+		fmt.Fprintf(msg, " %s=%v", l.context[i], l.context[i+1])
+	}
+	// The caller of Debugf, Infof or Warnf is reported by log.Lshortfile.
+	to.Output(3, msg.String())
+}
+
+// NewJSONLogger returns a Logger that writes each message to w as a line of
+// JSON, with the fields "time", "level" and "msg", and a field for each
+// key of its context. Lines are never interleaved, even when the Logger is
+// used concurrently.
+func NewJSONLogger(w io.Writer) Logger {
+	return &jsonLogger{out: &lockedWriter{w: w}}
+}
+
+// Debugf implements Logger
+func (l *jsonLogger) Debugf(format string, args ...interface{}) {
+	l.logAt(DebugLevel, format, args)
+}
+
+// Infof implements Logger
+func (l *jsonLogger) Infof(format string, args ...interface{}) {
+	l.logAt(InfoLevel, format, args)
+}
+
+// Warnf implements Logger
+func (l *jsonLogger) Warnf(format string, args ...interface{}) {
+	l.logAt(WarnLevel, format, args)
+}
+
+// With implements Logger
+func (l *jsonLogger) With(kv ...interface{}) Logger {
+	return &jsonLogger{out: l.out, context: appendContext(l.context, kv)}
+}
+
+func (l *jsonLogger) logAt(level Level, format string, args []interface{}) {
+	fields := map[string]interface{}{}
+	for i := 0; i < len(l.context); i += 2 {
+		fields[fmt.Sprint(l.context[i])] = jsonValue(l.context[i+1])
+	}
+	fields["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	fields["level"] = level.String()
+	fields["msg"] = fmt.Sprintf(format, args...)
+	line, err := json.Marshal(fields)
+	if err != nil {
+		line = []byte(fmt.Sprintf(`{"level":"warn","msg":%q}`, "unable to log: "+err.Error()))
+	}
+	l.out.Lock()
+	defer l.out.Unlock()
+	l.out.w.Write(append(line, '\n'))
+}
+
+// jsonValue returns v if it's a simple value, or else v formatted as text,
+// so that the JSON written is predictable.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	default:
+		return fmt.Sprint(v)
+	case nil, string, bool, int, int32, int64, uint, uint32, uint64, float32, float64:
+		return v
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	}
+}
+
+// appendContext returns a new context made of context and kv. A key with no
+// value is given the value "MISSING".
+func appendContext(context, kv []interface{}) []interface{} {
+	with := make([]interface{}, 0, len(context)+len(kv)+1)
+	with = append(with, context...)
+	with = append(with, kv...)
+	if len(kv)%2 != 0 {
+		with = append(with, "MISSING")
+	}
+	return with
+}
+
+func (discard) Debugf(string, ...interface{}) {}
+func (discard) Infof(string, ...interface{})  {}
+func (discard) Warnf(string, ...interface{})  {}
+func (d discard) With(...interface{}) Logger  { return d }
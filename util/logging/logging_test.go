@@ -0,0 +1,82 @@
+package logging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestStdLogger(t *testing.T) {
+	debug, warn := &bytes.Buffer{}, &bytes.Buffer{}
+	l := NewStdLogger(log.New(debug, "debug: ", 0), nil, log.New(warn, "warn: ", 0))
+
+	withCluster := l.With("cluster", "east")
+	withCluster.With("request", "req1").Debugf("deploying %d", 3)
+	withCluster.Warnf("slow")
+	l.Infof("discarded")
+	l.With("odd").Debugf("odd")
+
+	if got, want := debug.String(), "debug: deploying 3 cluster=east request=req1\ndebug: odd odd=MISSING\n"; got != want {
+		t.Errorf("got debug %q; want %q", got, want)
+	}
+	if got, want := warn.String(), "warn: slow cluster=east\n"; got != want {
+		t.Errorf("got warn %q; want %q", got, want)
+	}
+}
+
+func TestJSONLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewJSONLogger(buf).With("cluster", "east", "instances", 3, "err", errors.New("boom"))
+	l.Warnf("failed %s", "deploy")
+
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["time"] == nil {
+		t.Errorf("got no time in %v", fields)
+	}
+	delete(fields, "time")
+	want := map[string]interface{}{
+		"level": "warn", "msg": "failed deploy", "cluster": "east", "instances": 3.0, "err": "boom",
+	}
+	for k, v := range want {
+		if fields[k] != v {
+			t.Errorf("got %s %v; want %v", k, fields[k], v)
+		}
+	}
+	if len(fields) != len(want) {
+		t.Errorf("got fields %v; want %v", fields, want)
+	}
+}
+
+func TestJSONLoggerLinesDontInterleave(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewJSONLogger(buf)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l.With("goroutine", i).Debugf("%s", strings.Repeat("x", 1000))
+		}(i)
+	}
+	wg.Wait()
+
+	lines := 0
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		lines++
+		if !json.Valid(scanner.Bytes()) {
+			t.Errorf("got invalid line %q", scanner.Text())
+		}
+	}
+	if lines != 20 {
+		t.Errorf("got %d lines; want 20", lines)
+	}
+}
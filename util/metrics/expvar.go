@@ -0,0 +1,253 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the histogram buckets
+// of an ExpvarCollector made without any.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+type (
+	// ExpvarCollector is a Collector that keeps the latest value of each
+	// series in memory. It's an http.Handler that serves them in the
+	// Prometheus text exposition format, so they can be scraped, and an
+	// expvar.Var, so they can be published alongside the standard expvars.
+	ExpvarCollector struct {
+		buckets []float64
+		sync.Mutex
+		series map[string]*series
+	}
+
+	kind int
+
+	series struct {
+		kind   kind
+		name   string
+		labels string
+		// value is the sum of the observations of a histogram
+		value float64
+		// count is the number of observations of a histogram, and counts
+		// the number in each bucket.
+		count  uint64
+		counts []uint64
+	}
+)
+
+const (
+	counter kind = iota
+	gauge
+	histogram
+)
+
+func (k kind) String() string {
+	switch k {
+	default:
+		return "untyped"
+	case counter:
+		return "counter"
+	case gauge:
+		return "gauge"
+	case histogram:
+		return "histogram"
+	}
+}
+
+// NewExpvarCollector returns an empty ExpvarCollector whose histograms count
+// observations into buckets with the upper bounds given, in increasing
+// order. Without any, DefaultBuckets are used.
+func NewExpvarCollector(buckets ...float64) *ExpvarCollector {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	return &ExpvarCollector{buckets: buckets, series: map[string]*series{}}
+}
+
+// Publish publishes c as the expvar called name. Like expvar.Publish, it
+// panics if name is already in use.
+func (c *ExpvarCollector) Publish(name string) {
+	expvar.Publish(name, c)
+}
+
+// AddCounter implements Collector.
+func (c *ExpvarCollector) AddCounter(name string, labels Labels, delta float64) {
+	c.Lock()
+	defer c.Unlock()
+	c.get(counter, name, labels).value += delta
+}
+
+// SetGauge implements Collector.
+func (c *ExpvarCollector) SetGauge(name string, labels Labels, v float64) {
+	c.Lock()
+	defer c.Unlock()
+	c.get(gauge, name, labels).value = v
+}
+
+// Observe implements Collector.
+func (c *ExpvarCollector) Observe(name string, labels Labels, v float64) {
+	c.Lock()
+	defer c.Unlock()
+	s := c.get(histogram, name, labels)
+	s.value += v
+	s.count++
+	for i, le := range c.buckets {
+		if v <= le {
+			s.counts[i]++
+		}
+	}
+}
+
+// get returns the series for name and labels, creating it if need be. A
+// name used as a different kind of metric from the one it was first used as
+// gets a series that isn't kept, so the observation is dropped.
+func (c *ExpvarCollector) get(k kind, name string, labels Labels) *series {
+	ls := formatLabels(labels)
+	if s, ok := c.series[name+ls]; ok && s.kind == k {
+		return s
+	}
+	s := &series{kind: k, name: name, labels: ls, counts: make([]uint64, len(c.buckets))}
+	for _, other := range c.series {
+		if other.name == name {
+			if other.kind != k {
+				return s
+			}
+			break
+		}
+	}
+	c.series[name+ls] = s
+	return s
+}
+
+// sorted returns a copy of every series, ordered by name and labels.
+func (c *ExpvarCollector) sorted() []series {
+	c.Lock()
+	defer c.Unlock()
+	ss := make([]series, 0, len(c.series))
+	for _, s := range c.series {
+		cp := *s
+		cp.counts = append([]uint64(nil), s.counts...)
+		ss = append(ss, cp)
+	}
+	sort.Slice(ss, func(i, j int) bool {
+		if ss[i].name != ss[j].name {
+			return ss[i].name < ss[j].name
+		}
+		return ss[i].labels < ss[j].labels
+	})
+	return ss
+}
+
+// ServeHTTP writes every series in the Prometheus text exposition format.
+func (c *ExpvarCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(c.Text()))
+}
+
+// Text returns every series in the Prometheus text exposition format.
+func (c *ExpvarCollector) Text() string {
+	b := &bytes.Buffer{}
+	typed := ""
+	for _, s := range c.sorted() {
+		if s.name != typed {
+			fmt.Fprintf(b, "# TYPE %s %s\n", s.name, s.kind)
+			typed = s.name
+		}
+		if s.kind != histogram {
+			fmt.Fprintf(b, "%s%s %s\n", s.name, s.labels, formatFloat(s.value))
+			continue
+		}
+		for i, le := range c.buckets {
+			fmt.Fprintf(b, "%s_bucket%s %d\n", s.name, withLabel(s.labels, "le", formatFloat(le)), s.counts[i])
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", s.name, withLabel(s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(b, "%s_sum%s %s\n", s.name, s.labels, formatFloat(s.value))
+		fmt.Fprintf(b, "%s_count%s %d\n", s.name, s.labels, s.count)
+	}
+	return b.String()
+}
+
+// String implements expvar.Var: it returns a JSON object with a member for
+// each series, named as in Text. Counters and gauges are numbers; histograms
+// are objects with their count, sum and cumulative bucket counts.
+func (c *ExpvarCollector) String() string {
+	type hist struct {
+		Count   uint64            `json:"count"`
+		Sum     float64           `json:"sum"`
+		Buckets map[string]uint64 `json:"buckets"`
+	}
+	vars := map[string]interface{}{}
+	for _, s := range c.sorted() {
+		if s.kind != histogram {
+			vars[s.name+s.labels] = jsonFloat(s.value)
+			continue
+		}
+		h := hist{Count: s.count, Sum: jsonFloat(s.value), Buckets: map[string]uint64{}}
+		for i, le := range c.buckets {
+			h.Buckets[formatFloat(le)] = s.counts[i]
+		}
+		vars[s.name+s.labels] = h
+	}
+	js, err := json.Marshal(vars)
+	if err != nil {
+		return strconv.Quote(err.Error())
+	}
+	return string(js)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats labels as Prometheus does, e.g. `{kind="deployed"}`,
+// in order of name. No labels formats as "".
+func formatLabels(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for n := range labels {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, n := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, n, labelEscaper.Replace(labels[n]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// withLabel adds the label name=value to the formatted labels ls.
+func withLabel(ls, name, value string) string {
+	l := fmt.Sprintf(`%s="%s"`, name, value)
+	if ls == "" {
+		return "{" + l + "}"
+	}
+	return ls[:len(ls)-1] + "," + l + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// jsonFloat replaces the values JSON can't represent with zero.
+func jsonFloat(v float64) float64 {
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return 0
+	}
+	return v
+}
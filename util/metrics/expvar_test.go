@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"io/ioutil"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestExpvarCollectorText(t *testing.T) {
+	c := NewExpvarCollector(0.1, 1)
+	c.AddCounter("actions_total", Labels{"kind": "deployed"}, 1)
+	c.AddCounter("actions_total", Labels{"kind": "deployed"}, 2)
+	c.AddCounter("actions_total", Labels{"kind": `say "hi"`}, 1)
+	c.SetGauge("hit_ratio", nil, 0.25)
+	c.SetGauge("hit_ratio", nil, 0.5)
+	c.Observe("duration_seconds", Labels{"op": "Deploy"}, 0.05)
+	c.Observe("duration_seconds", Labels{"op": "Deploy"}, 0.5)
+	c.Observe("duration_seconds", Labels{"op": "Deploy"}, 2)
+	// a name can't be used as more than one kind of metric
+	c.SetGauge("actions_total", Labels{"kind": "deleted"}, 7)
+
+	want := `# TYPE actions_total counter
+actions_total{kind="deployed"} 3
+actions_total{kind="say \"hi\""} 1
+# TYPE duration_seconds histogram
+duration_seconds_bucket{op="Deploy",le="0.1"} 1
+duration_seconds_bucket{op="Deploy",le="1"} 2
+duration_seconds_bucket{op="Deploy",le="+Inf"} 3
+duration_seconds_sum{op="Deploy"} 2.55
+duration_seconds_count{op="Deploy"} 3
+# TYPE hit_ratio gauge
+hit_ratio 0.5
+`
+	if got := c.Text(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(w.Body)
+	if string(body) != want {
+		t.Errorf("served:\n%s\nwant:\n%s", body, want)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4" {
+		t.Errorf("got content type %q", ct)
+	}
+}
+
+func TestExpvarCollectorPublish(t *testing.T) {
+	c := NewExpvarCollector(1)
+	c.AddCounter("lookups_total", Labels{"result": "hit"}, 1)
+	c.Observe("latency_seconds", nil, 0.5)
+	c.Publish("metrics_test")
+
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(expvar.Get("metrics_test").String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		`lookups_total{result="hit"}`: 1.0,
+		"latency_seconds": map[string]interface{}{
+			"count":   1.0,
+			"sum":     0.5,
+			"buckets": map[string]interface{}{"1": 1.0},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestOr(t *testing.T) {
+	if _, ok := Or(nil).(Nop); !ok {
+		t.Errorf("Or(nil) should be Nop")
+	}
+	c := NewExpvarCollector()
+	if Or(c) != Collector(c) {
+		t.Errorf("Or(c) should be c")
+	}
+}
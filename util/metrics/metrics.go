@@ -0,0 +1,51 @@
+// Package metrics defines the Collector that sous's components report
+// counters, gauges and timings to, along with a Collector that keeps them in
+// memory and serves them over HTTP, in Prometheus's text format, and through
+// expvar.
+package metrics
+
+import "time"
+
+type (
+	// Collector is told about observations made by sous's components. A
+	// metric is identified by its name and labels; e.g. the name
+	// "sous_rectify_actions_total" with the labels {"kind": "deployed"}.
+	// Implementations must be safe for concurrent use.
+	Collector interface {
+		// AddCounter adds delta, which should not be negative, to a counter.
+		AddCounter(name string, labels Labels, delta float64)
+		// SetGauge sets a gauge to v.
+		SetGauge(name string, labels Labels, v float64)
+		// Observe records v in a histogram, e.g. of durations in seconds.
+		Observe(name string, labels Labels, v float64)
+	}
+
+	// Labels distinguish the series of a metric. Nil means no labels.
+	Labels map[string]string
+
+	// Nop is a Collector that discards everything it's told.
+	Nop struct{}
+)
+
+// AddCounter implements Collector.
+func (Nop) AddCounter(string, Labels, float64) {}
+
+// SetGauge implements Collector.
+func (Nop) SetGauge(string, Labels, float64) {}
+
+// Observe implements Collector.
+func (Nop) Observe(string, Labels, float64) {}
+
+// Or returns c, or Nop if c is nil.
+func Or(c Collector) Collector {
+	if c == nil {
+		return Nop{}
+	}
+	return c
+}
+
+// ObserveSince records the time elapsed since start, in seconds, in the
+// histogram name.
+func ObserveSince(c Collector, name string, labels Labels, start time.Time) {
+	c.Observe(name, labels, time.Since(start).Seconds())
+}
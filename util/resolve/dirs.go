@@ -0,0 +1,64 @@
+package resolve
+
+import "strings"
+
+// ConfigDir returns the directory user configuration belongs in:
+// $XDG_CONFIG_HOME if it's set, or else ~/Library/Application Support on
+// macOS, %APPDATA% on Windows, and ~/.config elsewhere.
+func ConfigDir() (string, error) {
+	return host.configDir()
+}
+
+// CacheDir returns the directory cached data belongs in: $XDG_CACHE_HOME if
+// it's set, or else ~/Library/Caches on macOS, %LOCALAPPDATA% on Windows, and
+// ~/.cache elsewhere.
+func CacheDir() (string, error) {
+	return host.cacheDir()
+}
+
+// DataDir returns the directory user data belongs in: $XDG_DATA_HOME if it's
+// set, or else ~/Library/Application Support on macOS, %APPDATA% on Windows,
+// and ~/.local/share elsewhere.
+func DataDir() (string, error) {
+	return host.dataDir()
+}
+
+func (s system) configDir() (string, error) {
+	return s.dir("XDG_CONFIG_HOME", "APPDATA", []string{"AppData", "Roaming"},
+		[]string{"Library", "Application Support"}, []string{".config"})
+}
+
+func (s system) cacheDir() (string, error) {
+	return s.dir("XDG_CACHE_HOME", "LOCALAPPDATA", []string{"AppData", "Local"},
+		[]string{"Library", "Caches"}, []string{".cache"})
+}
+
+func (s system) dataDir() (string, error) {
+	return s.dir("XDG_DATA_HOME", "APPDATA", []string{"AppData", "Roaming"},
+		[]string{"Library", "Application Support"}, []string{".local", "share"})
+}
+
+// dir returns the directory named by the XDG variable xdg, or else the
+// platform's usual directory: the Windows variable win, or the path below
+// the home directory for Windows, macOS or anything else.
+func (s system) dir(xdg, win string, winHome, macHome, otherHome []string) (string, error) {
+	if d := s.getenv(xdg); d != "" {
+		return d, nil
+	}
+	below := otherHome
+	switch s.goos {
+	case "windows":
+		if d := s.getenv(win); d != "" {
+			return d, nil
+		}
+		below = winHome
+	case "darwin":
+		below = macHome
+	}
+	home, err := s.homeDir()
+	if err != nil {
+		return "", err
+	}
+	home = strings.TrimRight(home, s.separators())
+	return s.join(append([]string{home}, below...)...), nil
+}
@@ -0,0 +1,119 @@
+// Package resolve expands the paths users give sous, and finds the
+// directories sous keeps its files in.
+package resolve
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"runtime"
+	"strings"
+)
+
+// system is what paths are resolved against: the operating system, the
+// environment and the user database.
+type system struct {
+	goos        string
+	getenv      func(string) string
+	lookupUser  func(name string) (*user.User, error)
+	currentUser func() (*user.User, error)
+}
+
+var host = system{
+	goos:        runtime.GOOS,
+	getenv:      os.Getenv,
+	lookupUser:  user.Lookup,
+	currentUser: user.Current,
+}
+
+// Resolve expands path: a leading ~ becomes the current user's home
+// directory, and ~user that user's, and $VAR and ${VAR} are replaced by the
+// values of environment variables, which are empty if they're unset.
+// Relative paths are left relative: use a Resolver to resolve them against
+// a directory other than the working directory.
+func Resolve(path string) (string, error) {
+	return host.resolve(path)
+}
+
+// MustResolve is like Resolve, but panics if path can't be resolved.
+func MustResolve(path string) string {
+	p, err := Resolve(path)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func (s system) resolve(path string) (string, error) {
+	if !strings.HasPrefix(path, "~") {
+		return os.Expand(path, s.getenv), nil
+	}
+	end := strings.IndexAny(path, s.separators())
+	if end < 0 {
+		end = len(path)
+	}
+	name, rest := path[1:end], os.Expand(path[end:], s.getenv)
+
+	var home string
+	var err error
+	if name == "" {
+		home, err = s.homeDir()
+	} else {
+		home, err = s.userHomeDir(name)
+	}
+	if err != nil {
+		return "", err
+	}
+	if rest == "" {
+		return home, nil
+	}
+	return strings.TrimRight(home, s.separators()) + rest, nil
+}
+
+// separators are the characters that separate the elements of a path.
+func (s system) separators() string {
+	if s.goos == "windows" {
+		return `/\`
+	}
+	return "/"
+}
+
+// join joins elems with the separator of the system.
+func (s system) join(elems ...string) string {
+	sep := "/"
+	if s.goos == "windows" {
+		sep = `\`
+	}
+	return strings.Join(elems, sep)
+}
+
+// homeDir returns the current user's home directory.
+func (s system) homeDir() (string, error) {
+	if home := s.getenv("HOME"); home != "" {
+		return home, nil
+	}
+	if s.goos == "windows" {
+		if home := s.getenv("USERPROFILE"); home != "" {
+			return home, nil
+		}
+	}
+	u, err := s.currentUser()
+	if err != nil {
+		return "", fmt.Errorf("unable to find your home directory: %s", err)
+	}
+	if u.HomeDir == "" {
+		return "", fmt.Errorf("unable to find your home directory: user %s has none", u.Username)
+	}
+	return u.HomeDir, nil
+}
+
+func (s system) userHomeDir(name string) (string, error) {
+	u, err := s.lookupUser(name)
+	if err != nil {
+		return "", fmt.Errorf("unable to find the home directory of %s: %s", name, err)
+	}
+	if u.HomeDir == "" {
+		return "", fmt.Errorf("unable to find the home directory of %s: it has none", name)
+	}
+	return u.HomeDir, nil
+}
@@ -0,0 +1,139 @@
+package resolve
+
+import (
+	"errors"
+	"os/user"
+	"testing"
+)
+
+func testSystem(goos string, env map[string]string) system {
+	users := map[string]*user.User{
+		"alice":  {Username: "alice", HomeDir: "/home/alice"},
+		"nohome": {Username: "nohome"},
+	}
+	return system{
+		goos:   goos,
+		getenv: func(k string) string { return env[k] },
+		lookupUser: func(name string) (*user.User, error) {
+			if u, ok := users[name]; ok {
+				return u, nil
+			}
+			return nil, user.UnknownUserError(name)
+		},
+		currentUser: func() (*user.User, error) {
+			return nil, errors.New("no current user")
+		},
+	}
+}
+
+func TestResolve(t *testing.T) {
+	s := testSystem("linux", map[string]string{"HOME": "/home/me", "DIR": "sub", "ROOT": "/srv"})
+	for in, want := range map[string]string{
+		"":               "",
+		"plain/path":     "plain/path",
+		"/abs/path":      "/abs/path",
+		"~":              "/home/me",
+		"~/":             "/home/me/",
+		"~/x/y":          "/home/me/x/y",
+		"~alice":         "/home/alice",
+		"~alice/x":       "/home/alice/x",
+		"~/$DIR/x":       "/home/me/sub/x",
+		"${ROOT}/${DIR}": "/srv/sub",
+		"$ROOT/~/x":      "/srv/~/x",
+		"$UNSET/x":       "/x",
+		"x~/y":           "x~/y",
+	} {
+		got, err := s.resolve(in)
+		if err != nil {
+			t.Errorf("resolve(%q): %s", in, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("resolve(%q) = %q; want %q", in, got, want)
+		}
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	s := testSystem("linux", map[string]string{})
+	for _, in := range []string{"~/x", "~bob/x", "~nohome"} {
+		if got, err := s.resolve(in); err == nil {
+			t.Errorf("resolve(%q) = %q; want error", in, got)
+		}
+	}
+}
+
+func TestResolveWindows(t *testing.T) {
+	s := testSystem("windows", map[string]string{`USERPROFILE`: `C:\Users\me`})
+	for in, want := range map[string]string{
+		`~\x\y`:    `C:\Users\me\x\y`,
+		`~/x`:      `C:\Users\me/x`,
+		`~alice\x`: `/home/alice\x`,
+		`C:\abs`:   `C:\abs`,
+	} {
+		got, err := s.resolve(in)
+		if err != nil {
+			t.Errorf("resolve(%q): %s", in, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("resolve(%q) = %q; want %q", in, got, want)
+		}
+	}
+}
+
+func TestMustResolvePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("MustResolve didn't panic")
+		}
+	}()
+	MustResolve("~nosuchuserhere/x")
+}
+
+func TestDirs(t *testing.T) {
+	type dirs struct{ config, cache, data string }
+	for _, c := range []struct {
+		goos string
+		env  map[string]string
+		want dirs
+	}{
+		{"linux", map[string]string{"HOME": "/home/me"},
+			dirs{"/home/me/.config", "/home/me/.cache", "/home/me/.local/share"}},
+		{"linux", map[string]string{"HOME": "/", "XDG_CONFIG_HOME": "/xdg/config",
+			"XDG_CACHE_HOME": "/xdg/cache", "XDG_DATA_HOME": "/xdg/data"},
+			dirs{"/xdg/config", "/xdg/cache", "/xdg/data"}},
+		{"linux", map[string]string{"HOME": "/"},
+			dirs{"/.config", "/.cache", "/.local/share"}},
+		{"darwin", map[string]string{"HOME": "/Users/me"},
+			dirs{"/Users/me/Library/Application Support", "/Users/me/Library/Caches", "/Users/me/Library/Application Support"}},
+		{"darwin", map[string]string{"HOME": "/Users/me", "XDG_CACHE_HOME": "/xdg/cache"},
+			dirs{"/Users/me/Library/Application Support", "/xdg/cache", "/Users/me/Library/Application Support"}},
+		{"windows", map[string]string{"APPDATA": `C:\Users\me\AppData\Roaming`, "LOCALAPPDATA": `D:\Local`},
+			dirs{`C:\Users\me\AppData\Roaming`, `D:\Local`, `C:\Users\me\AppData\Roaming`}},
+		{"windows", map[string]string{"USERPROFILE": `C:\Users\me`},
+			dirs{`C:\Users\me\AppData\Roaming`, `C:\Users\me\AppData\Local`, `C:\Users\me\AppData\Roaming`}},
+	} {
+		s := testSystem(c.goos, c.env)
+		var got dirs
+		var err error
+		if got.config, err = s.configDir(); err != nil {
+			t.Fatal(err)
+		}
+		if got.cache, err = s.cacheDir(); err != nil {
+			t.Fatal(err)
+		}
+		if got.data, err = s.dataDir(); err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("%s %v: got %+v; want %+v", c.goos, c.env, got, c.want)
+		}
+	}
+}
+
+func TestDirsWithoutHome(t *testing.T) {
+	if d, err := testSystem("linux", map[string]string{}).configDir(); err == nil {
+		t.Errorf("got %q; want error", d)
+	}
+}
@@ -0,0 +1,86 @@
+package resolve
+
+import (
+	"path"
+	"strings"
+)
+
+// Resolver resolves paths as Resolve does, and then resolves relative paths
+// against its base directory, rather than leaving them to be taken relative
+// to the working directory of the process.
+type Resolver struct {
+	// Base is the directory relative paths are resolved against. It may
+	// itself use ~ and environment variables. If it's empty, relative paths
+	// are left relative.
+	Base string
+	// Getenv looks up environment variables. If it's nil, the process's
+	// environment is used.
+	Getenv func(key string) string
+
+	// sys is the system paths are resolved on, if not the host.
+	sys system
+}
+
+// NewResolver returns a Resolver of paths relative to base, which looks up
+// environment variables with getenv, or in the process's environment if
+// getenv is nil.
+func NewResolver(base string, getenv func(key string) string) *Resolver {
+	return &Resolver{Base: base, Getenv: getenv}
+}
+
+// Resolve expands ~, ~user and environment variables in path, as Resolve
+// does, and if the result is relative, joins it to the base directory. On
+// Windows, paths beginning with a separator or a drive letter and a colon
+// count as absolute.
+func (r *Resolver) Resolve(p string) (string, error) {
+	s := r.system()
+	p, err := s.resolve(p)
+	if err != nil || s.isAbs(p) || r.Base == "" {
+		return p, err
+	}
+	base, err := s.resolve(r.Base)
+	if err != nil {
+		return "", err
+	}
+	return s.joinPath(base, p), nil
+}
+
+func (r *Resolver) system() system {
+	s := host
+	if r.sys.goos != "" {
+		s = r.sys
+	}
+	if r.Getenv != nil {
+		s.getenv = r.Getenv
+	}
+	return s
+}
+
+// isAbs reports whether p is absolute on the system.
+func (s system) isAbs(p string) bool {
+	if p == "" {
+		return false
+	}
+	if strings.IndexByte(s.separators(), p[0]) >= 0 {
+		return true
+	}
+	if s.goos != "windows" || len(p) < 2 || p[1] != ':' {
+		return false
+	}
+	c := p[0] | 0x20 // lower case
+	return c >= 'a' && c <= 'z'
+}
+
+// joinPath joins the relative path rel to base, and cleans the result.
+func (s system) joinPath(base, rel string) string {
+	if s.goos != "windows" {
+		return path.Join(base, rel)
+	}
+	slashed := func(p string) string { return strings.Replace(p, `\`, "/", -1) }
+	joined := path.Join(slashed(base), slashed(rel))
+	// keep the leading \\ of a UNC path, which Join would make one
+	if strings.HasPrefix(slashed(base), "//") {
+		joined = "/" + joined
+	}
+	return strings.Replace(joined, "/", `\`, -1)
+}
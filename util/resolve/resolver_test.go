@@ -0,0 +1,97 @@
+package resolve
+
+import "testing"
+
+func testResolver(goos, base string, env map[string]string) *Resolver {
+	return &Resolver{Base: base, sys: testSystem(goos, env)}
+}
+
+func TestResolverResolve(t *testing.T) {
+	r := testResolver("linux", "/srv/state", map[string]string{
+		"HOME": "/home/me", "ABS": "/abs", "REL": "rel", "BASE": "/from/env",
+	})
+	for in, want := range map[string]string{
+		"x":               "/srv/state/x",
+		"./x":             "/srv/state/x",
+		"../x":            "/srv/x",
+		"x/../../../../y": "/y",
+		".":               "/srv/state",
+		"":                "/srv/state",
+		"/abs/x":          "/abs/x",
+		"~/x":             "/home/me/x",
+		"~alice/x":        "/home/alice/x",
+		"$ABS/x":          "/abs/x",
+		"$REL/x":          "/srv/state/rel/x",
+		"./$REL":          "/srv/state/rel",
+		"${UNSET}x":       "/srv/state/x",
+	} {
+		got, err := r.Resolve(in)
+		if err != nil {
+			t.Errorf("Resolve(%q): %s", in, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("Resolve(%q) = %q; want %q", in, got, want)
+		}
+	}
+}
+
+func TestResolverBase(t *testing.T) {
+	env := map[string]string{"HOME": "/home/me", "BASE": "/from/env"}
+	for base, want := range map[string]string{
+		"":             "x/y",
+		"~/state":      "/home/me/state/x/y",
+		"$BASE":        "/from/env/x/y",
+		"/trailing//":  "/trailing/x/y",
+		"~nobody/here": "",
+	} {
+		got, err := testResolver("linux", base, env).Resolve("x/y")
+		if want == "" {
+			if err == nil {
+				t.Errorf("base %q: got %q; want error", base, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("base %q: %s", base, err)
+		} else if got != want {
+			t.Errorf("base %q: got %q; want %q", base, got, want)
+		}
+	}
+}
+
+func TestResolverResolveWindows(t *testing.T) {
+	env := map[string]string{"USERPROFILE": `C:\Users\me`, "DRIVE": `D:\data`, "REL": `sub\dir`}
+	for _, c := range []struct{ base, in, want string }{
+		{`C:\state`, `x`, `C:\state\x`},
+		{`C:\state`, `.\x`, `C:\state\x`},
+		{`C:\state`, `./x`, `C:\state\x`},
+		{`C:\state`, `..\x`, `C:\x`},
+		{`C:\state`, `$REL\x`, `C:\state\sub\dir\x`},
+		{`C:\state`, `D:\abs`, `D:\abs`},
+		{`C:\state`, `d:/abs`, `d:/abs`},
+		{`C:\state`, `\rooted`, `\rooted`},
+		{`C:\state`, `$DRIVE\x`, `D:\data\x`},
+		{`C:\state`, `~\x`, `C:\Users\me\x`},
+		{`~\state`, `x`, `C:\Users\me\state\x`},
+		{`\\server\share\state`, `..\x`, `\\server\share\x`},
+	} {
+		got, err := testResolver("windows", c.base, env).Resolve(c.in)
+		if err != nil {
+			t.Errorf("Resolve(%q) from %q: %s", c.in, c.base, err)
+		} else if got != c.want {
+			t.Errorf("Resolve(%q) from %q = %q; want %q", c.in, c.base, got, c.want)
+		}
+	}
+}
+
+func TestNewResolverUsesGetenv(t *testing.T) {
+	r := NewResolver("/base", func(k string) string { return map[string]string{"X": "from-func"}[k] })
+	got, err := r.Resolve("$X/y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "/base/from-func/y" {
+		t.Errorf("got %q; want /base/from-func/y", got)
+	}
+}
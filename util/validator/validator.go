@@ -227,6 +227,10 @@ func (c ctx) validateStruct() error {
 		return fmt.Errorf("cannot validate %s (non-struct value) without context", t)
 	}
 	for i := 0; i < v.NumField(); i++ {
+		// Unexported fields cannot be interfaced, and are not validated.
+		if t.Field(i).PkgPath != "" {
+			continue
+		}
 		if err := c.enterField(v.Field(i), t.Field(i)).validate(); err != nil {
 			return err
 		}
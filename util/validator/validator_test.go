@@ -52,6 +52,11 @@ type (
 		Name Name
 	}
 	Name string
+	// UnexportedField has a field the validator cannot see.
+	UnexportedField struct {
+		String string `validate:"nonempty"`
+		name   Name
+	}
 	// NestedStructs can never be valid in finite space, since it's recursive.
 	// Don't really make structs like this!
 	NestedStructs struct {
@@ -191,6 +196,7 @@ func TestValidate_Valid(t *testing.T) {
 		NonemptyStringMapKey{Map: map[string]string{"x": ""}},
 		NonemptyStringMapVal{Map: nil},
 		NonemptyStringMapVal{Map: map[string]string{"": "x"}},
+		UnexportedField{String: "x", name: Name("toolong")},
 	}
 	for _, x := range valid {
 		if err := Validate(x); err != nil {
@@ -0,0 +1,155 @@
+package yaml
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	y "github.com/samsalisbury/yaml"
+)
+
+// UnknownFieldsError is returned by UnmarshalStrict when the YAML contains
+// keys which do not correspond to any field of the target struct.
+type UnknownFieldsError struct {
+	Type reflect.Type
+	// Fields are the paths of the unknown keys, e.g. "Env" or
+	// "Deployments.global.Resurces".
+	Fields []string
+}
+
+func (e *UnknownFieldsError) Error() string {
+	return fmt.Sprintf("unknown fields in %s: %s", e.Type, strings.Join(e.Fields, ", "))
+}
+
+// FieldUnmarshaler is implemented by types whose UnmarshalYAML reads their
+// fields as they'd be read without it, e.g. only to note which keys were
+// present, so that UnmarshalStrict checks their keys all the same.
+type FieldUnmarshaler interface {
+	y.Unmarshaler
+	UnmarshalsFields()
+}
+
+var (
+	unmarshalerType      = reflect.TypeOf((*y.Unmarshaler)(nil)).Elem()
+	fieldUnmarshalerType = reflect.TypeOf((*FieldUnmarshaler)(nil)).Elem()
+)
+
+// UnmarshalStrict is like Unmarshal, but returns an *UnknownFieldsError if
+// any key in the input would be silently dropped.
+func UnmarshalStrict(in []byte, out interface{}) error {
+	if err := Unmarshal(in, out); err != nil {
+		return err
+	}
+	var raw interface{}
+	if err := Unmarshal(in, &raw); err != nil {
+		return err
+	}
+	unknown := unknownFields(raw, reflect.TypeOf(out), "")
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return &UnknownFieldsError{Type: reflect.TypeOf(out), Fields: unknown}
+}
+
+// unknownFields returns the paths of keys in raw which have nowhere to go in
+// a value of type t. Values of types which unmarshal themselves are not
+// checked, unless they're FieldUnmarshalers.
+func unknownFields(raw interface{}, t reflect.Type, path string) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	pt := reflect.PtrTo(t)
+	if pt.Implements(unmarshalerType) && !pt.Implements(fieldUnmarshalerType) {
+		return nil
+	}
+	unknown := []string{}
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := raw.(map[interface{}]interface{})
+		if !ok {
+			return nil
+		}
+		fields, open := structFields(t)
+		for k, v := range m {
+			key := fmt.Sprint(k)
+			ft, ok := fields[key]
+			if !ok {
+				if !open {
+					unknown = append(unknown, joinPath(path, key))
+				}
+				continue
+			}
+			unknown = append(unknown, unknownFields(v, ft, joinPath(path, key))...)
+		}
+	case reflect.Map:
+		m, ok := raw.(map[interface{}]interface{})
+		if !ok {
+			return nil
+		}
+		for k, v := range m {
+			unknown = append(unknown, unknownFields(v, t.Elem(), joinPath(path, fmt.Sprint(k)))...)
+		}
+	case reflect.Slice, reflect.Array:
+		s, ok := raw.([]interface{})
+		if !ok {
+			return nil
+		}
+		for i, v := range s {
+			unknown = append(unknown, unknownFields(v, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	return unknown
+}
+
+// structFields returns the types of the fields of struct type t by YAML key,
+// following the same rules as the underlying library with OPT_NOLOWERCASE.
+// open is true if t has an inline map, which accepts any other key.
+func structFields(t reflect.Type) (fields map[string]reflect.Type, open bool) {
+	fields = map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		tag := f.Tag.Get("yaml")
+		if tag == "" && !strings.Contains(string(f.Tag), ":") {
+			tag = string(f.Tag)
+		}
+		if tag == "-" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		inline := false
+		for _, flag := range parts[1:] {
+			inline = inline || flag == "inline"
+		}
+		if inline {
+			switch f.Type.Kind() {
+			case reflect.Map:
+				open = true
+			case reflect.Struct:
+				inlined, inlinedOpen := structFields(f.Type)
+				for k, v := range inlined {
+					fields[k] = v
+				}
+				open = open || inlinedOpen
+			}
+			continue
+		}
+		key := parts[0]
+		if key == "" {
+			key = f.Name
+		}
+		fields[key] = f.Type
+	}
+	return fields, open
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
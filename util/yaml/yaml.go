@@ -6,6 +6,13 @@ package yaml
 // before going back to the upstream repo.
 import y "github.com/samsalisbury/yaml"
 
+type (
+	// MapSlice is a YAML map whose keys are marshalled in the order given.
+	MapSlice = y.MapSlice
+	// MapItem is a key and value of a MapSlice.
+	MapItem = y.MapItem
+)
+
 func Marshal(in interface{}) ([]byte, error) {
 	return y.Marshal(in, y.OPT_NOLOWERCASE)
 }
@@ -21,3 +21,78 @@ func TestUnmarshalAnonymousFields(t *testing.T) {
 	}
 	t.Logf("Got: % +v", it)
 }
+
+type strictThing struct {
+	Thing    `yaml:",inline"`
+	Renamed  string `yaml:"other"`
+	Children map[string]StructField
+	List     []StructField
+	Extra    map[string]string `yaml:",inline"`
+}
+
+type closedThing struct {
+	Name     string
+	Children []StructField
+}
+
+func TestUnmarshalStrict(t *testing.T) {
+	good := `{"StringField": "hello", "IntField": 5, "other": "x",
+		"Children": {"a": {"IntField": 1}}, "List": [{"IntField": 2}], "whatever": "y"}`
+	var st strictThing
+	if err := UnmarshalStrict([]byte(good), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Extra["whatever"] != "y" {
+		t.Errorf("inline map not populated: %+v", st)
+	}
+
+	bad := `{"Name": "n", "Nmae": "typo", "Children": [{"IntField": 1}, {"IntFeild": 2}]}`
+	var ct closedThing
+	err := UnmarshalStrict([]byte(bad), &ct)
+	uf, ok := err.(*UnknownFieldsError)
+	if !ok {
+		t.Fatalf("got error %v; want *UnknownFieldsError", err)
+	}
+	if len(uf.Fields) != 2 || uf.Fields[0] != "Children[1].IntFeild" || uf.Fields[1] != "Nmae" {
+		t.Errorf("got unknown fields %q", uf.Fields)
+	}
+	if err := Unmarshal([]byte(bad), &ct); err != nil {
+		t.Errorf("non-strict Unmarshal returned %v", err)
+	}
+}
+
+// notingThing notes whether its IntField was present.
+type notingThing struct {
+	IntField int
+	present  bool
+}
+
+func (nt *notingThing) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain notingThing
+	if err := unmarshal((*plain)(nt)); err != nil {
+		return err
+	}
+	keys := map[string]interface{}{}
+	if err := unmarshal(&keys); err != nil {
+		return err
+	}
+	_, nt.present = keys["IntField"]
+	return nil
+}
+
+func (nt *notingThing) UnmarshalsFields() {}
+
+func TestUnmarshalStrictFieldUnmarshaler(t *testing.T) {
+	var nts map[string]notingThing
+	err := UnmarshalStrict([]byte(`{"a": {"IntField": 0}, "b": {"IntFeild": 1}}`), &nts)
+	uf, ok := err.(*UnknownFieldsError)
+	if !ok {
+		t.Fatalf("got error %v; want *UnknownFieldsError", err)
+	}
+	if len(uf.Fields) != 1 || uf.Fields[0] != "b.IntFeild" {
+		t.Errorf("got unknown fields %q", uf.Fields)
+	}
+	if !nts["a"].present || nts["b"].present {
+		t.Errorf("UnmarshalYAML wasn't called: %+v", nts)
+	}
+}